@@ -14,15 +14,13 @@
 package main
 
 import (
-	"fmt"
 	"os"
 
 	"knative.dev/kn-plugin-migration/core"
+	"knative.dev/kn-plugin-migration/pkg/command"
 )
 
 func main() {
-	if err := core.NewMigrationCommand().Execute(); err != nil {
-		fmt.Println("failed to execute migration command:", err)
-		os.Exit(1)
-	}
+	command.EnableConsoleColors()
+	os.Exit(command.ExitCode(core.NewMigrationCommand().Execute()))
 }
@@ -16,6 +16,7 @@ package core
 
 import (
 	"fmt"
+	"io"
 	"os"
 
 	"github.com/spf13/cobra"
@@ -29,6 +30,9 @@ import (
 
 var cfgFile string
 
+// localConfigFile is the config file used by default when it exists in the current directory
+const localConfigFile = "migrate.yaml"
+
 // migrationCmd represents the base command when called without any subcommands
 func NewMigrationCommand() *cobra.Command {
 	rootCmd := &cobra.Command{
@@ -39,26 +43,38 @@ For example:
 kn migration list
 kn migration migrate --namespace default --destination-namespace default
 `,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			// The flags are parsed, the errors of the command itself are not caused by its usage
+			cmd.SilenceUsage = true
+			if err := initConfig(cmd.ErrOrStderr()); err != nil {
+				return err
+			}
+			command.ConfigureColors(cmd, os.Stdout)
+			return command.ApplyConfigDefaults(cmd)
+		},
 	}
-	cobra.OnInitialize(initConfig)
-	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.config/kn/plugins/admin.yaml)")
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is migrate.yaml in the current directory, else $HOME/.migration)")
+	rootCmd.PersistentFlags().Duration(command.TimeoutFlag, 0, "Maximum duration of the command, the calls in progress are stopped once elapsed (0 for no limit)")
+	rootCmd.PersistentFlags().Bool(command.NoColorFlag, false, "Disable the colors of the output, also disabled by the NO_COLOR environment variable or when the output is not a terminal")
 	rootCmd.AddCommand(list.NewListCommand())
 	rootCmd.AddCommand(migrate.NewMigrateCommand())
 	rootCmd.AddCommand(command.NewVersionCommand())
 	return rootCmd
 }
 
-// initConfig reads in config file and ENV variables if set.
-func initConfig() {
+// initConfig reads in config file and ENV variables if set, telling out which config file is used.
+func initConfig(out io.Writer) error {
 	if cfgFile != "" {
 		// Use config file from the flag.
 		viper.SetConfigFile(cfgFile)
+	} else if _, err := os.Stat(localConfigFile); err == nil {
+		// Use the config file of the project the migration is run from.
+		viper.SetConfigFile(localConfigFile)
 	} else {
 		// Find home directory.
 		home, err := homedir.Dir()
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			return fmt.Errorf("cannot find the home directory of the config file: %w", err)
 		}
 
 		// Search config in home directory with name ".migration" (without extension).
@@ -70,6 +86,7 @@ func initConfig() {
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
+		fmt.Fprintln(out, "Using config file:", viper.ConfigFileUsed())
 	}
+	return nil
 }
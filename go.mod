@@ -4,14 +4,23 @@ go 1.15
 
 require (
 	github.com/fatih/color v1.13.0
+	github.com/golang/protobuf v1.5.2
+	github.com/google/gnostic v0.5.7-v3refs
+	github.com/google/go-cmp v0.5.7
+	github.com/google/go-containerregistry v0.8.1-0.20220414143355-892d7a808387
+	github.com/mattn/go-isatty v0.0.14
 	github.com/mitchellh/go-homedir v1.1.0
 	github.com/spf13/afero v1.8.0 // indirect
 	github.com/spf13/cobra v1.4.0
+	github.com/spf13/pflag v1.0.5
 	github.com/spf13/viper v1.10.1
+	golang.org/x/sys v0.0.0-20220227234510-4e6760a101f9
 	gotest.tools v2.2.0+incompatible
 	k8s.io/api v0.24.4
 	k8s.io/apimachinery v0.24.4
 	k8s.io/client-go v0.24.4
 	knative.dev/hack v0.0.0-20220923094413-9b7638704a22
+	knative.dev/pkg v0.0.0-20220921024409-d1d5c849073b
 	knative.dev/serving v0.34.1-0.20220926140858-243fad9ab495
+	sigs.k8s.io/yaml v1.3.0
 )
@@ -16,17 +16,28 @@ package command
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 
 	"github.com/fatih/color"
 	api_errors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/pager"
 	api_serving "knative.dev/serving/pkg/apis/serving"
 	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
 	serving_v1_client "knative.dev/serving/pkg/client/clientset/versioned/typed/serving/v1"
 )
 
+// FieldManager is the field manager owning the fields of the services applied by the migration
+const FieldManager = "kn-migration"
+
+// DefaultListPageSize is the number of services or revisions fetched per list request, namespaces with thousands of
+// revisions are listed in several requests instead of a single one which may time out
+const DefaultListPageSize = 500
+
 type MigrationClient interface {
 	// Create service struct from provided options
 	ConstructService(originalservice serving_v1_api.Service) *serving_v1_api.Service
@@ -35,83 +46,133 @@ type MigrationClient interface {
 	BuildRevision(originalrevision serving_v1_api.Revision, config_uuid types.UID) *serving_v1_api.Revision
 
 	// Check if service exists
-	ServiceExists(name string) (bool, error)
+	ServiceExists(ctx context.Context, name string) (bool, error)
 
 	// Get a config by service name
-	GetConfig(name string) (*serving_v1_api.Configuration, error)
+	GetConfig(ctx context.Context, name string) (*serving_v1_api.Configuration, error)
 
 	// Get a service by name
-	GetService(name string) (*serving_v1_api.Service, error)
+	GetService(ctx context.Context, name string) (*serving_v1_api.Service, error)
 
 	// Get service list
-	ListService() (*serving_v1_api.ServiceList, error)
+	ListService(ctx context.Context) (*serving_v1_api.ServiceList, error)
+
+	// Get service list by label selector
+	ListServiceBySelector(ctx context.Context, labelSelector string) (*serving_v1_api.ServiceList, error)
+
+	// Call fn for every service selected by the label selector as the pages of the list are fetched
+	EachService(ctx context.Context, labelSelector string, fn func(*serving_v1_api.Service) error) error
 
 	// Create a service
-	CreateService(service *serving_v1_api.Service) (*serving_v1_api.Service, error)
+	CreateService(ctx context.Context, service *serving_v1_api.Service) (*serving_v1_api.Service, error)
+
+	// Apply the given service over an existing one with server-side apply, owning its fields as the field manager
+	ApplyService(ctx context.Context, service *serving_v1_api.Service, fieldManager string) (*serving_v1_api.Service, error)
+
+	// Update the given service
+	UpdateService(ctx context.Context, service *serving_v1_api.Service) (*serving_v1_api.Service, error)
 
 	// Delete a service by name
-	DeleteService(name string) error
+	DeleteService(ctx context.Context, name string) error
 
 	// Get a revision by service name
-	GetRevision(name string) (*serving_v1_api.Revision, error)
+	GetRevision(ctx context.Context, name string) (*serving_v1_api.Revision, error)
 
 	// Create a revision
-	CreateRevision(revision *serving_v1_api.Revision, config_uuid types.UID) (*serving_v1_api.Revision, error)
+	CreateRevision(ctx context.Context, revision *serving_v1_api.Revision, config_uuid types.UID) (*serving_v1_api.Revision, error)
 
 	// Update the given revision
-	UpdateRevision(revision *serving_v1_api.Revision) error
+	UpdateRevision(ctx context.Context, revision *serving_v1_api.Revision) error
+
+	// Delete a revision by name
+	DeleteRevision(ctx context.Context, name string) error
 
 	// Get revision list by service
-	ListRevisionByService(name string) (*serving_v1_api.RevisionList, error)
+	ListRevisionByService(ctx context.Context, name string) (*serving_v1_api.RevisionList, error)
+
+	// Call fn for every revision of a service as the pages of the list are fetched
+	EachRevisionByService(ctx context.Context, name string, fn func(*serving_v1_api.Revision) error) error
 
 	// Get service list with revisions
-	PrintServiceWithRevisions(clustername string) error
+	PrintServiceWithRevisions(ctx context.Context, out io.Writer, clustername string) error
 }
 
 type migrationClient struct {
 	client    serving_v1_client.ServingV1Interface
 	namespace string
+	pageSize  int64
 }
 
 // NewMigrationClient creates a new client facade for the provided cl.namespace
 func NewMigrationClient(client serving_v1_client.ServingV1Interface, namespace string) MigrationClient {
+	return NewPagedMigrationClient(client, namespace, DefaultListPageSize)
+}
+
+// NewPagedMigrationClient creates a new client facade listing the services and revisions of the namespace
+// by pages of pageSize items, zero lists them in a single request
+func NewPagedMigrationClient(client serving_v1_client.ServingV1Interface, namespace string, pageSize int64) MigrationClient {
 	return &migrationClient{
 		client:    client,
 		namespace: namespace,
+		pageSize:  pageSize,
 	}
 }
 
+// EachListItem calls fn for every item of a list fetched by pages of pageSize items with the list function,
+// the next pages are fetched while fn runs and the listing stops at the first error of fn
+func EachListItem(ctx context.Context, pageSize int64, labelSelector string, list func(opts metav1.ListOptions) (runtime.Object, error), fn func(runtime.Object) error) error {
+	p := pager.New(pager.SimplePageFunc(list))
+	p.PageSize = pageSize
+	return p.EachListItem(ctx, metav1.ListOptions{LabelSelector: labelSelector}, fn)
+}
+
+func (mc *migrationClient) eachItem(ctx context.Context, labelSelector string, list func(opts metav1.ListOptions) (runtime.Object, error), fn func(runtime.Object) error) error {
+	return EachListItem(ctx, mc.pageSize, labelSelector, list, fn)
+}
+
 func (mc *migrationClient) ConstructService(originalservice serving_v1_api.Service) *serving_v1_api.Service {
 
 	service := serving_v1_api.Service{
-		ObjectMeta: originalservice.ObjectMeta,
+		ObjectMeta: SanitizeObjectMeta(originalservice.ObjectMeta, mc.namespace),
 	}
 
-	service.ObjectMeta.Namespace = mc.namespace
-
 	service.Spec = originalservice.Spec
-	service.Spec.Template.ObjectMeta.Name = originalservice.Status.LatestCreatedRevisionName
-	service.ObjectMeta.ResourceVersion = ""
+	service.Spec.Template.ObjectMeta.Name = TemplateRevisionName(originalservice)
 
 	return &service
 }
 
+// TemplateRevisionName returns the name of the revision a migrated service creates in the destination,
+// the name given in spec.template with bring-your-own revision names, else its latest created revision
+func TemplateRevisionName(service serving_v1_api.Service) string {
+	if service.Spec.Template.ObjectMeta.Name != "" {
+		return service.Spec.Template.ObjectMeta.Name
+	}
+	return service.Status.LatestCreatedRevisionName
+}
+
 func (mc *migrationClient) BuildRevision(originalrevision serving_v1_api.Revision, config_uuid types.UID) *serving_v1_api.Revision {
 	revision := serving_v1_api.Revision{
-		ObjectMeta: originalrevision.ObjectMeta,
+		ObjectMeta: SanitizeObjectMeta(originalrevision.ObjectMeta, mc.namespace),
 	}
 
-	revision.ObjectMeta.Namespace = mc.namespace
-	revision.ObjectMeta.ResourceVersion = ""
-	revision.ObjectMeta.OwnerReferences[0].UID = config_uuid
+	// The revision is owned by the configuration of the destination service
+	if len(originalrevision.OwnerReferences) > 0 {
+		owner := originalrevision.OwnerReferences[0]
+		owner.UID = config_uuid
+		revision.ObjectMeta.OwnerReferences = []metav1.OwnerReference{owner}
+	}
+	if revision.ObjectMeta.Labels == nil {
+		revision.ObjectMeta.Labels = map[string]string{}
+	}
 	revision.ObjectMeta.Labels["serving.knative.dev/configurationGeneration"] = originalrevision.ObjectMeta.Labels["serving.knative.dev/configurationGeneration"]
 	revision.Spec = originalrevision.Spec
 
 	return &revision
 }
 
-func (mc *migrationClient) ServiceExists(name string) (bool, error) {
-	_, err := mc.client.Services(mc.namespace).Get(context.TODO(), name, metav1.GetOptions{})
+func (mc *migrationClient) ServiceExists(ctx context.Context, name string) (bool, error) {
+	_, err := mc.client.Services(mc.namespace).Get(ctx, name, metav1.GetOptions{})
 	if api_errors.IsNotFound(err) {
 		return false, nil
 	}
@@ -121,101 +182,158 @@ func (mc *migrationClient) ServiceExists(name string) (bool, error) {
 	return true, nil
 }
 
-func (mc *migrationClient) GetConfig(name string) (*serving_v1_api.Configuration, error) {
-	config, err := mc.client.Configurations(mc.namespace).Get(context.TODO(), name, metav1.GetOptions{})
+func (mc *migrationClient) GetConfig(ctx context.Context, name string) (*serving_v1_api.Configuration, error) {
+	config, err := mc.client.Configurations(mc.namespace).Get(ctx, name, metav1.GetOptions{})
 	if err != nil {
 		return nil, err
 	}
 	return config, nil
 }
 
-func (mc *migrationClient) GetService(name string) (*serving_v1_api.Service, error) {
-	service, err := mc.client.Services(mc.namespace).Get(context.TODO(), name, metav1.GetOptions{})
+func (mc *migrationClient) GetService(ctx context.Context, name string) (*serving_v1_api.Service, error) {
+	service, err := mc.client.Services(mc.namespace).Get(ctx, name, metav1.GetOptions{})
 	if err != nil {
 		return nil, err
 	}
 	return service, nil
 }
 
-func (mc *migrationClient) ListService() (*serving_v1_api.ServiceList, error) {
-	servicelist, err := mc.client.Services(mc.namespace).List(context.TODO(), metav1.ListOptions{})
+func (mc *migrationClient) ListService(ctx context.Context) (*serving_v1_api.ServiceList, error) {
+	return mc.ListServiceBySelector(ctx, "")
+}
+
+func (mc *migrationClient) ListServiceBySelector(ctx context.Context, labelSelector string) (*serving_v1_api.ServiceList, error) {
+	servicelist := &serving_v1_api.ServiceList{}
+	err := mc.EachService(ctx, labelSelector, func(service *serving_v1_api.Service) error {
+		servicelist.Items = append(servicelist.Items, *service)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 	return servicelist, nil
 }
 
-func (mc *migrationClient) CreateService(service *serving_v1_api.Service) (*serving_v1_api.Service, error) {
+func (mc *migrationClient) EachService(ctx context.Context, labelSelector string, fn func(*serving_v1_api.Service) error) error {
+	list := func(opts metav1.ListOptions) (runtime.Object, error) {
+		return mc.client.Services(mc.namespace).List(ctx, opts)
+	}
+	return mc.eachItem(ctx, labelSelector, list, func(object runtime.Object) error {
+		return fn(object.(*serving_v1_api.Service))
+	})
+}
+
+func (mc *migrationClient) CreateService(ctx context.Context, service *serving_v1_api.Service) (*serving_v1_api.Service, error) {
 	newserivce := mc.ConstructService(*service)
-	service, err := mc.client.Services(mc.namespace).Create(context.TODO(), newserivce, metav1.CreateOptions{})
+	service, err := mc.client.Services(mc.namespace).Create(ctx, newserivce, metav1.CreateOptions{})
 	if err != nil {
 		return nil, err
 	}
 	return service, nil
 }
 
-func (mc *migrationClient) DeleteService(name string) error {
-	err := mc.client.Services(mc.namespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
+func (mc *migrationClient) ApplyService(ctx context.Context, service *serving_v1_api.Service, fieldManager string) (*serving_v1_api.Service, error) {
+	applied := mc.ConstructService(*service)
+	applied.TypeMeta = metav1.TypeMeta{APIVersion: serving_v1_api.SchemeGroupVersion.String(), Kind: "Service"}
+	data, err := json.Marshal(applied)
+	if err != nil {
+		return nil, err
+	}
+	// The fields owned by other managers, e.g. kubectl, are taken over since the service is forcefully replaced
+	force := true
+	return mc.client.Services(mc.namespace).Patch(ctx, applied.Name, types.ApplyPatchType, data, metav1.PatchOptions{FieldManager: fieldManager, Force: &force})
+}
+
+func (mc *migrationClient) UpdateService(ctx context.Context, service *serving_v1_api.Service) (*serving_v1_api.Service, error) {
+	service, err := mc.client.Services(mc.namespace).Update(ctx, service, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return service, nil
+}
+
+func (mc *migrationClient) DeleteService(ctx context.Context, name string) error {
+	err := mc.client.Services(mc.namespace).Delete(ctx, name, metav1.DeleteOptions{})
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (mc *migrationClient) GetRevision(name string) (*serving_v1_api.Revision, error) {
-	revision, err := mc.client.Revisions(mc.namespace).Get(context.TODO(), name, metav1.GetOptions{})
+func (mc *migrationClient) GetRevision(ctx context.Context, name string) (*serving_v1_api.Revision, error) {
+	revision, err := mc.client.Revisions(mc.namespace).Get(ctx, name, metav1.GetOptions{})
 	if err != nil {
 		return nil, err
 	}
 	return revision, nil
 }
 
-func (mc *migrationClient) CreateRevision(revision *serving_v1_api.Revision, config_uuid types.UID) (*serving_v1_api.Revision, error) {
+func (mc *migrationClient) CreateRevision(ctx context.Context, revision *serving_v1_api.Revision, config_uuid types.UID) (*serving_v1_api.Revision, error) {
 	newrevision := mc.BuildRevision(*revision, config_uuid)
-	revision, err := mc.client.Revisions(mc.namespace).Create(context.TODO(), newrevision, metav1.CreateOptions{})
+	revision, err := mc.client.Revisions(mc.namespace).Create(ctx, newrevision, metav1.CreateOptions{})
 	if err != nil {
 		return nil, err
 	}
 	return revision, nil
 }
 
-func (mc *migrationClient) UpdateRevision(revision *serving_v1_api.Revision) error {
-	_, err := mc.client.Revisions(mc.namespace).Update(context.TODO(), revision, metav1.UpdateOptions{})
+func (mc *migrationClient) UpdateRevision(ctx context.Context, revision *serving_v1_api.Revision) error {
+	_, err := mc.client.Revisions(mc.namespace).Update(ctx, revision, metav1.UpdateOptions{})
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (mc *migrationClient) ListRevisionByService(name string) (*serving_v1_api.RevisionList, error) {
-	revisions, err := mc.client.Revisions(mc.namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: api_serving.ServiceLabelKey + "=" + name})
+func (mc *migrationClient) DeleteRevision(ctx context.Context, name string) error {
+	err := mc.client.Revisions(mc.namespace).Delete(ctx, name, metav1.DeleteOptions{})
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return revisions, nil
+	return nil
 }
 
-func (mc *migrationClient) PrintServiceWithRevisions(clustername string) error {
-	services, err := mc.ListService()
+func (mc *migrationClient) ListRevisionByService(ctx context.Context, name string) (*serving_v1_api.RevisionList, error) {
+	revisions := &serving_v1_api.RevisionList{}
+	err := mc.EachRevisionByService(ctx, name, func(revision *serving_v1_api.Revision) error {
+		revisions.Items = append(revisions.Items, *revision)
+		return nil
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return revisions, nil
+}
 
-	fmt.Println("There are", color.CyanString("%v", len(services.Items)), "service(s) in", clustername, color.BlueString(mc.namespace), "namespace")
-	for i := 0; i < len(services.Items); i++ {
-		service := services.Items[i]
-		color.Cyan("%-25s%-30s%-20s\n", "Name", "Current Revision", "Ready")
-		fmt.Printf("%-25s%-30s%-20s\n", service.Name, service.Status.LatestReadyRevisionName, fmt.Sprint(service.IsReady()))
+func (mc *migrationClient) EachRevisionByService(ctx context.Context, name string, fn func(*serving_v1_api.Revision) error) error {
+	list := func(opts metav1.ListOptions) (runtime.Object, error) {
+		return mc.client.Revisions(mc.namespace).List(ctx, opts)
+	}
+	return mc.eachItem(ctx, api_serving.ServiceLabelKey+"="+name, list, func(object runtime.Object) error {
+		return fn(object.(*serving_v1_api.Revision))
+	})
+}
 
-		revisions_s, err := mc.ListRevisionByService(service.Name)
-		if err != nil {
-			return err
-		}
-		for i := 0; i < len(revisions_s.Items); i++ {
-			revision_s := revisions_s.Items[i]
-			fmt.Println("  |- Revision", revision_s.Name, "( Generation: "+fmt.Sprint(revision_s.Labels["serving.knative.dev/configurationGeneration"]), ", Ready:", revision_s.IsReady(), ")")
-		}
-		fmt.Println("")
+// PrintServiceWithRevisions prints the services of the namespace with their revisions, page by page
+// so that large namespaces start printing before they are fully listed
+func (mc *migrationClient) PrintServiceWithRevisions(ctx context.Context, out io.Writer, clustername string) error {
+	fmt.Fprintln(out, "Services in", clustername, color.BlueString(mc.namespace), "namespace:")
+	count := 0
+	err := mc.EachService(ctx, "", func(service *serving_v1_api.Service) error {
+		count++
+		color.New(color.FgCyan).Fprintf(out, "%-25s%-30s%-20s\n", "Name", "Current Revision", "Ready")
+		fmt.Fprintf(out, "%-25s%-30s%-20s\n", service.Name, service.Status.LatestReadyRevisionName, fmt.Sprint(service.IsReady()))
+
+		err := mc.EachRevisionByService(ctx, service.Name, func(revision_s *serving_v1_api.Revision) error {
+			fmt.Fprintln(out, "  |- Revision", revision_s.Name, "( Generation: "+fmt.Sprint(revision_s.Labels["serving.knative.dev/configurationGeneration"]), ", Ready:", revision_s.IsReady(), ")")
+			return nil
+		})
+		fmt.Fprintln(out, "")
+		return err
+	})
+	if err != nil {
+		return err
 	}
+	fmt.Fprintln(out, "There are", color.CyanString("%v", count), "service(s) in", clustername, color.BlueString(mc.namespace), "namespace")
 	return nil
 }
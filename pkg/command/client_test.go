@@ -0,0 +1,109 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"gotest.tools/assert"
+	"k8s.io/client-go/rest"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+	"knative.dev/serving/pkg/client/clientset/versioned"
+	serving_v1_client "knative.dev/serving/pkg/client/clientset/versioned/typed/serving/v1"
+)
+
+// pagedAPIServer serves the lists of services and revisions by pages as an API server would, the continue
+// token being the index of the next item, and records the limit and continue token of every list request
+func pagedAPIServer(t *testing.T, services, revisions int) (serving_v1_client.ServingV1Interface, *[]string) {
+	var mu sync.Mutex
+	requests := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		mu.Lock()
+		requests = append(requests, fmt.Sprintf("limit=%s continue=%s", query.Get("limit"), query.Get("continue")))
+		mu.Unlock()
+		total, kind := services, "svc"
+		if strings.HasSuffix(r.URL.Path, "/revisions") {
+			total, kind = revisions, "rev"
+		}
+		start, _ := strconv.Atoi(query.Get("continue"))
+		limit, _ := strconv.Atoi(query.Get("limit"))
+		end := total
+		if limit > 0 && start+limit < total {
+			end = start + limit
+		}
+		list := map[string]interface{}{"apiVersion": "serving.knative.dev/v1", "metadata": map[string]string{}}
+		if end < total {
+			list["metadata"] = map[string]string{"continue": strconv.Itoa(end)}
+		}
+		items := []interface{}{}
+		for i := start; i < end; i++ {
+			items = append(items, map[string]interface{}{"metadata": map[string]string{"name": fmt.Sprintf("%s-%05d", kind, i)}})
+		}
+		list["items"] = items
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(list)
+	}))
+	t.Cleanup(server.Close)
+	client, err := versioned.NewForConfig(&rest.Config{Host: server.URL})
+	assert.NilError(t, err)
+	return client.ServingV1(), &requests
+}
+
+func TestListServicesByPages(t *testing.T) {
+	client, requests := pagedAPIServer(t, 5, 0)
+	services, err := NewPagedMigrationClient(client, "default", 2).ListService(context.Background())
+	assert.NilError(t, err)
+	assert.Equal(t, len(services.Items), 5)
+	assert.Equal(t, services.Items[4].Name, "svc-00004")
+	assert.DeepEqual(t, *requests, []string{"limit=2 continue=", "limit=2 continue=2", "limit=2 continue=4"})
+
+	// A zero page size lists everything in a single request
+	client, requests = pagedAPIServer(t, 5, 0)
+	services, err = NewPagedMigrationClient(client, "default", 0).ListService(context.Background())
+	assert.NilError(t, err)
+	assert.Equal(t, len(services.Items), 5)
+	assert.DeepEqual(t, *requests, []string{"limit= continue="})
+}
+
+func TestEachRevisionByService(t *testing.T) {
+	client, requests := pagedAPIServer(t, 0, 1200)
+	migrationClient := NewMigrationClient(client, "default")
+	revisions, err := migrationClient.ListRevisionByService(context.Background(), "hello")
+	assert.NilError(t, err)
+	assert.Equal(t, len(revisions.Items), 1200)
+	assert.Equal(t, revisions.Items[1199].Name, "rev-01199")
+	assert.DeepEqual(t, *requests, []string{"limit=500 continue=", "limit=500 continue=500", "limit=500 continue=1000"})
+
+	// The listing stops at the first error of the callback
+	seen := 0
+	err = migrationClient.EachRevisionByService(context.Background(), "hello", func(revision *serving_v1_api.Revision) error {
+		seen++
+		if seen == 10 {
+			return fmt.Errorf("stop at %s", revision.Name)
+		}
+		return nil
+	})
+	assert.ErrorContains(t, err, "stop at rev-00009")
+	assert.Equal(t, seen, 10)
+}
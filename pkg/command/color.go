@@ -0,0 +1,45 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"os"
+
+	"github.com/fatih/color"
+	"github.com/mattn/go-isatty"
+	"github.com/spf13/cobra"
+)
+
+// NoColorFlag is the global flag disabling the colors of the output
+const NoColorFlag = "no-color"
+
+// unsupportedConsole is set on consoles which cannot process the ANSI color sequences
+var unsupportedConsole bool
+
+// isTerminal returns true if a file is a terminal, colors corrupt the logs of CI systems capturing the output
+var isTerminal = func(file *os.File) bool {
+	return isatty.IsTerminal(file.Fd()) || isatty.IsCygwinTerminal(file.Fd())
+}
+
+// ConfigureColors disables the colors of the output written to out with the global --no-color, when the NO_COLOR
+// environment variable is set, see https://no-color.org, when TERM is dumb or when out is not a terminal
+func ConfigureColors(cmd *cobra.Command, out *os.File) {
+	noColor := false
+	if flag := cmd.Flag(NoColorFlag); flag != nil {
+		noColor = flag.Value.String() == "true"
+	}
+	_, noColorSet := os.LookupEnv("NO_COLOR")
+	color.NoColor = noColor || noColorSet || unsupportedConsole || os.Getenv("TERM") == "dumb" || !isTerminal(out)
+}
@@ -0,0 +1,57 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"os"
+	"testing"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"gotest.tools/assert"
+)
+
+func TestConfigureColors(t *testing.T) {
+	noColor, wasTerminal := color.NoColor, isTerminal
+	defer func() {
+		color.NoColor, isTerminal = noColor, wasTerminal
+	}()
+	defer os.Setenv("TERM", os.Getenv("TERM"))
+	os.Unsetenv("NO_COLOR")
+	os.Setenv("TERM", "xterm")
+	terminal := true
+	isTerminal = func(*os.File) bool { return terminal }
+
+	cmd := &cobra.Command{}
+	cmd.Flags().Bool(NoColorFlag, false, "")
+	ConfigureColors(cmd, os.Stderr)
+	assert.Assert(t, !color.NoColor)
+
+	// A log captured by a CI system
+	terminal = false
+	ConfigureColors(cmd, os.Stderr)
+	assert.Assert(t, color.NoColor)
+
+	terminal = true
+	assert.NilError(t, cmd.Flags().Set(NoColorFlag, "true"))
+	ConfigureColors(cmd, os.Stdout)
+	assert.Assert(t, color.NoColor)
+
+	assert.NilError(t, cmd.Flags().Set(NoColorFlag, "false"))
+	os.Setenv("NO_COLOR", "")
+	defer os.Unsetenv("NO_COLOR")
+	ConfigureColors(cmd, os.Stdout)
+	assert.Assert(t, color.NoColor)
+}
@@ -0,0 +1,119 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/mitchellh/go-homedir"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// StdinKubeConfig is the kubeconfig path reading the kubeconfig from the standard input
+const StdinKubeConfig = "-"
+
+var (
+	// Stdin is the reader of a kubeconfig given as StdinKubeConfig
+	Stdin io.Reader = os.Stdin
+
+	stdinOnce       sync.Once
+	stdinKubeConfig []byte
+	stdinErr        error
+)
+
+// BuildConfig returns the client configuration of a context of a kubeconfig file, the current context of the
+// file is used when kubeContext is empty and the default kubeconfig loading rules when kubeConfig is empty.
+// kubeConfig may also be a list of files separated by the OS path list separator, like KUBECONFIG, or
+// StdinKubeConfig to read the kubeconfig from the standard input without writing its credentials to disk.
+func BuildConfig(kubeConfig, kubeContext string) (*rest.Config, error) {
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: kubeContext}
+	if kubeConfig == StdinKubeConfig {
+		data, err := readStdinKubeConfig()
+		if err != nil {
+			return nil, err
+		}
+		config, err := clientcmd.Load(data)
+		if err != nil {
+			return nil, err
+		}
+		return clientcmd.NewNonInteractiveClientConfig(*config, kubeContext, overrides, nil).ClientConfig()
+	}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules(kubeConfig), overrides).ClientConfig()
+}
+
+// ReadKubeConfig returns the content of a kubeconfig as BuildConfig loads it, the files of a list are merged
+func ReadKubeConfig(kubeConfig string) ([]byte, error) {
+	if kubeConfig == StdinKubeConfig {
+		return readStdinKubeConfig()
+	}
+	paths, err := expandPaths(kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 1 {
+		return ioutil.ReadFile(paths[0])
+	}
+	config, err := loadingRules(kubeConfig).Load()
+	if err != nil {
+		return nil, err
+	}
+	return clientcmd.Write(*config)
+}
+
+// loadingRules returns the loading rules of a kubeconfig path or path list
+func loadingRules(kubeConfig string) *clientcmd.ClientConfigLoadingRules {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	paths, err := expandPaths(kubeConfig)
+	if err != nil || len(paths) == 0 {
+		rules.ExplicitPath = kubeConfig
+		return rules
+	}
+	if len(paths) == 1 {
+		rules.ExplicitPath = paths[0]
+		return rules
+	}
+	rules.Precedence = paths
+	return rules
+}
+
+// expandPaths splits a kubeconfig path list and expands the home directory of each path, so that paths like
+// ~/.kube/config work when the shell does not expand them, as on Windows
+func expandPaths(kubeConfig string) ([]string, error) {
+	paths := []string{}
+	for _, path := range filepath.SplitList(kubeConfig) {
+		if path == "" {
+			continue
+		}
+		expanded, err := homedir.Expand(path)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, filepath.Clean(expanded))
+	}
+	return paths, nil
+}
+
+// readStdinKubeConfig reads the standard input once, both clusters may be contexts of the same piped kubeconfig
+func readStdinKubeConfig() ([]byte, error) {
+	stdinOnce.Do(func() {
+		stdinKubeConfig, stdinErr = ioutil.ReadAll(Stdin)
+	})
+	return stdinKubeConfig, stdinErr
+}
@@ -0,0 +1,125 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/go-homedir"
+	"gotest.tools/assert"
+)
+
+const twoContextsKubeConfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: staging
+  cluster:
+    server: https://staging.example.com
+- name: prod
+  cluster:
+    server: https://prod.example.com
+users:
+- name: admin
+  user:
+    token: secret
+contexts:
+- name: staging
+  context:
+    cluster: staging
+    user: admin
+- name: prod
+  context:
+    cluster: prod
+    user: admin
+current-context: staging
+`
+
+func TestBuildConfigContext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubeconfig")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	kubeConfig := filepath.Join(dir, "config")
+	assert.NilError(t, ioutil.WriteFile(kubeConfig, []byte(twoContextsKubeConfig), 0600))
+
+	cfg, err := BuildConfig(kubeConfig, "")
+	assert.NilError(t, err)
+	assert.Equal(t, cfg.Host, "https://staging.example.com")
+
+	cfg, err = BuildConfig(kubeConfig, "prod")
+	assert.NilError(t, err)
+	assert.Equal(t, cfg.Host, "https://prod.example.com")
+
+	_, err = BuildConfig(kubeConfig, "dev")
+	assert.ErrorContains(t, err, "dev")
+}
+
+func TestBuildConfigPathList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubeconfig")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	kubeConfig := filepath.Join(dir, "config")
+	assert.NilError(t, ioutil.WriteFile(kubeConfig, []byte(twoContextsKubeConfig), 0600))
+	empty := filepath.Join(dir, "empty")
+	assert.NilError(t, ioutil.WriteFile(empty, []byte("apiVersion: v1\nkind: Config\n"), 0600))
+
+	list := strings.Join([]string{empty, kubeConfig}, string(filepath.ListSeparator))
+	cfg, err := BuildConfig(list, "prod")
+	assert.NilError(t, err)
+	assert.Equal(t, cfg.Host, "https://prod.example.com")
+
+	data, err := ReadKubeConfig(list)
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(string(data), "https://staging.example.com"))
+}
+
+func TestBuildConfigHomeDirectory(t *testing.T) {
+	home, err := homedir.Dir()
+	if err != nil {
+		t.Skip("no home directory")
+	}
+	dir, err := ioutil.TempDir(home, "kubeconfig")
+	if err != nil {
+		t.Skip("home directory not writable")
+	}
+	defer os.RemoveAll(dir)
+	assert.NilError(t, ioutil.WriteFile(filepath.Join(dir, "config"), []byte(twoContextsKubeConfig), 0600))
+
+	cfg, err := BuildConfig(filepath.Join("~", filepath.Base(dir), "config"), "")
+	assert.NilError(t, err)
+	assert.Equal(t, cfg.Host, "https://staging.example.com")
+}
+
+func TestBuildConfigStdin(t *testing.T) {
+	// Windows editors save the kubeconfig with CRLF line endings
+	Stdin = strings.NewReader(strings.ReplaceAll(twoContextsKubeConfig, "\n", "\r\n"))
+	defer func() { Stdin = os.Stdin }()
+
+	cfg, err := BuildConfig(StdinKubeConfig, "")
+	assert.NilError(t, err)
+	assert.Equal(t, cfg.Host, "https://staging.example.com")
+
+	// Both clusters may be contexts of the piped kubeconfig, the standard input is read once
+	cfg, err = BuildConfig(StdinKubeConfig, "prod")
+	assert.NilError(t, err)
+	assert.Equal(t, cfg.Host, "https://prod.example.com")
+
+	data, err := ReadKubeConfig(StdinKubeConfig)
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(string(data), "https://prod.example.com"))
+}
@@ -0,0 +1,42 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build windows
+// +build windows
+
+package command
+
+import (
+	"os"
+
+	"github.com/fatih/color"
+	"golang.org/x/sys/windows"
+)
+
+// EnableConsoleColors turns on the processing of the ANSI color sequences by the Windows console, the colors
+// are disabled on consoles which do not support it, like the legacy console of Windows before Windows 10
+func EnableConsoleColors() {
+	for _, file := range []*os.File{os.Stdout, os.Stderr} {
+		handle := windows.Handle(file.Fd())
+		var mode uint32
+		if err := windows.GetConsoleMode(handle, &mode); err != nil {
+			// Not a console, the output is redirected to a file or a pipe
+			continue
+		}
+		if err := windows.SetConsoleMode(handle, mode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING); err != nil {
+			unsupportedConsole = true
+			color.NoColor = true
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// TimeoutFlag is the global flag bounding the duration of a command
+const TimeoutFlag = "timeout"
+
+// interrupted is set once a command received an interrupt or termination signal
+var interrupted int32
+
+// ExitCode returns the exit code of a command that returned err: 130 when it was stopped by a signal, so that
+// the deferred journal and checkpoint flushes ran before the process exits, 1 on other errors and 0 otherwise.
+func ExitCode(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case atomic.LoadInt32(&interrupted) != 0:
+		return 130
+	default:
+		return 1
+	}
+}
+
+// NewCommandContext returns the context of the API calls of a command, cancelled once the global --timeout
+// elapsed or on an interrupt or termination signal, so that no new API call is issued and the command returns
+// once the calls in progress returned. The returned function releases the context and the signal handler.
+func NewCommandContext(cmd *cobra.Command) (context.Context, context.CancelFunc) {
+	ctx := cmd.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	var cancel context.CancelFunc
+	if flag := cmd.Flag(TimeoutFlag); flag != nil {
+		timeout, err := time.ParseDuration(flag.Value.String())
+		if err == nil && timeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+		}
+	}
+	if cancel == nil {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-signals:
+				atomic.StoreInt32(&interrupted, 1)
+				fmt.Fprintln(cmd.ErrOrStderr(), color.YellowString("Interrupted, stopping once the calls in progress returned"))
+				cancel()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() {
+			signal.Stop(signals)
+			close(done)
+			cancel()
+		})
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gotest.tools/assert"
+)
+
+func TestNewCommandContextTimeout(t *testing.T) {
+	cmd := &cobra.Command{}
+	cmd.Flags().Duration(TimeoutFlag, 0, "")
+	assert.NilError(t, cmd.Flags().Set(TimeoutFlag, "1m"))
+
+	ctx, cancel := NewCommandContext(cmd)
+	deadline, ok := ctx.Deadline()
+	assert.Assert(t, ok)
+	assert.Assert(t, time.Until(deadline) <= time.Minute)
+
+	cancel()
+	assert.Assert(t, ctx.Err() != nil)
+	// Releasing the context twice is harmless
+	cancel()
+}
+
+func TestNewCommandContextWithoutTimeout(t *testing.T) {
+	ctx, cancel := NewCommandContext(&cobra.Command{})
+	defer cancel()
+	_, ok := ctx.Deadline()
+	assert.Assert(t, !ok)
+	assert.NilError(t, ctx.Err())
+}
+
+func TestExitCode(t *testing.T) {
+	assert.Equal(t, ExitCode(nil), 0)
+	assert.Equal(t, ExitCode(errors.New("failed")), 1)
+
+	atomic.StoreInt32(&interrupted, 1)
+	defer atomic.StoreInt32(&interrupted, 0)
+	assert.Equal(t, ExitCode(errors.New("context canceled")), 130)
+	assert.Equal(t, ExitCode(nil), 0)
+}
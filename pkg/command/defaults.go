@@ -0,0 +1,74 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// DefaultsConfigKey is the config file key declaring the default flags of each command by command name, e.g.
+//
+//	defaults:
+//	  migrate:
+//	    source-profile: staging
+//	    concurrency: 4
+//	    exclude-namespace: [kube-system, knative-serving]
+const DefaultsConfigKey = "defaults"
+
+// ApplyConfigDefaults sets the flags of a command which are not given on the command line to their default in the
+// config file. The defaults replace the built-in defaults of the flags, they are not reported as changed flags.
+func ApplyConfigDefaults(cmd *cobra.Command) error {
+	key := DefaultsConfigKey + "." + cmd.Name()
+	if !viper.IsSet(key) {
+		return nil
+	}
+	defaults := viper.GetStringMap(key)
+	names := make([]string, 0, len(defaults))
+	for name := range defaults {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		flag := cmd.Flags().Lookup(name)
+		if flag == nil {
+			return fmt.Errorf("unknown flag %s in the defaults of %s in config file", name, cmd.Name())
+		}
+		if flag.Changed {
+			continue
+		}
+		if err := setDefault(flag, defaults[name]); err != nil {
+			return fmt.Errorf("invalid default %s of %s in config file: %w", name, cmd.Name(), err)
+		}
+	}
+	return nil
+}
+
+// setDefault sets a flag to a value of the config file, each element of a list is added to a repeatable flag
+func setDefault(flag *pflag.Flag, value interface{}) error {
+	if list, ok := value.([]interface{}); ok {
+		for _, element := range list {
+			if err := flag.Value.Set(fmt.Sprint(element)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return flag.Value.Set(fmt.Sprint(value))
+}
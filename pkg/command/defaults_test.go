@@ -0,0 +1,79 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gotest.tools/assert"
+)
+
+func readTestConfig(t *testing.T, config string) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.SetConfigType("yaml")
+	assert.NilError(t, viper.ReadConfig(bytes.NewBufferString(config)))
+}
+
+func TestApplyConfigDefaults(t *testing.T) {
+	readTestConfig(t, `
+defaults:
+  migrate:
+    concurrency: 4
+    dry-run: true
+    service: [checkout, "frontend-*"]
+    namespace: staging
+`)
+	cmd := &cobra.Command{Use: "migrate"}
+	concurrency := cmd.Flags().Int("concurrency", 1, "")
+	dryRun := cmd.Flags().Bool("dry-run", false, "")
+	services := cmd.Flags().StringArray("service", nil, "")
+	namespace := cmd.Flags().String("namespace", "", "")
+	assert.NilError(t, cmd.Flags().Parse([]string{"--namespace", "default"}))
+
+	assert.NilError(t, ApplyConfigDefaults(cmd))
+	assert.Equal(t, *concurrency, 4)
+	assert.Equal(t, *dryRun, true)
+	assert.DeepEqual(t, *services, []string{"checkout", "frontend-*"})
+	// The command line wins over the config file
+	assert.Equal(t, *namespace, "default")
+	assert.Assert(t, !cmd.Flags().Changed("concurrency"))
+
+	other := &cobra.Command{Use: "sync"}
+	assert.NilError(t, ApplyConfigDefaults(other))
+}
+
+func TestApplyConfigDefaultsErrors(t *testing.T) {
+	readTestConfig(t, `
+defaults:
+  migrate:
+    concurrency: many
+`)
+	cmd := &cobra.Command{Use: "migrate"}
+	cmd.Flags().Int("concurrency", 1, "")
+	err := ApplyConfigDefaults(cmd)
+	assert.ErrorContains(t, err, "invalid default concurrency of migrate in config file")
+
+	readTestConfig(t, `
+defaults:
+  migrate:
+    unknown: true
+`)
+	err = ApplyConfigDefaults(cmd)
+	assert.ErrorContains(t, err, "unknown flag unknown in the defaults of migrate in config file")
+}
@@ -15,12 +15,10 @@
 package list
 
 import (
-	"fmt"
 	"os"
 
 	"github.com/spf13/cobra"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/oidc" // from https://github.com/kubernetes/client-go/issues/345
-	"k8s.io/client-go/tools/clientcmd"
 	"knative.dev/kn-plugin-migration/pkg/command"
 	servingv1client "knative.dev/serving/pkg/client/clientset/versioned/typed/serving/v1"
 )
@@ -28,6 +26,7 @@ import (
 type listCmdFlags struct {
 	Namespace  string
 	KubeConfig string
+	Context    string
 }
 
 var listFlags listCmdFlags
@@ -38,31 +37,30 @@ func NewListCommand() *cobra.Command {
 		Use:   "list",
 		Short: "List all Knative service resources",
 		Long:  `List all Knative service resources`,
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := command.NewCommandContext(cmd)
+			defer cancel()
+
 			kubeConfig := listFlags.KubeConfig
 			if kubeConfig == "" {
 				kubeConfig = os.Getenv("KUBECONFIG")
 			}
-			ServingClient, err := getClient(kubeConfig, listFlags.Namespace)
-			if err != nil {
-				fmt.Errorf(err.Error())
-				os.Exit(1)
-			}
-			err = ServingClient.PrintServiceWithRevisions("current")
+			ServingClient, err := getClient(kubeConfig, listFlags.Context, listFlags.Namespace)
 			if err != nil {
-				fmt.Errorf(err.Error())
-				os.Exit(1)
+				return err
 			}
+			return ServingClient.PrintServiceWithRevisions(ctx, cmd.OutOrStdout(), "current")
 		},
 	}
 
 	listCmd.Flags().StringVarP(&listFlags.Namespace, "namespace", "n", "default", "The namespace of the Knative resources (default is default namespace)")
 	listCmd.Flags().StringVar(&listFlags.KubeConfig, "kubeconfig", "", "The kubeconfig of the Knative resources (default is KUBECONFIG from environment variable)")
+	listCmd.Flags().StringVar(&listFlags.Context, "context", "", "The context of the kubeconfig of the Knative resources (default is the current context)")
 	return listCmd
 }
 
-func getClient(kubeConfig, namespace string) (command.MigrationClient, error) {
-	cfg, err := clientcmd.BuildConfigFromFlags("", kubeConfig)
+func getClient(kubeConfig, kubeContext, namespace string) (command.MigrationClient, error) {
+	cfg, err := command.BuildConfig(kubeConfig, kubeContext)
 	if err != nil {
 		return nil, err
 	}
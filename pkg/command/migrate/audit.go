@@ -0,0 +1,195 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// auditRecord is a line of the audit log, for a write request sent to a cluster
+type auditRecord struct {
+	Timestamp string `json:"timestamp"`
+	Cluster   string `json:"cluster"`
+	Verb      string `json:"verb"`
+	// Kind is the resource written, with its API group unless it is a core resource, e.g. services.serving.knative.dev
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name,omitempty"`
+	// Result is the HTTP status of the response, or the error of a request which got no response
+	Result string `json:"result"`
+}
+
+// auditLog appends a JSON record to a file for every create, update, patch and delete request sent to the clusters
+type auditLog struct {
+	mu   sync.Mutex
+	file *os.File
+	// err is the first failure to write a record
+	err error
+	// now returns the timestamp of the records
+	now func() time.Time
+}
+
+// openAuditLog opens the audit log file for appending, creating it if needed, no log is kept if the path is empty
+func openAuditLog(path string) (*auditLog, error) {
+	if path == "" {
+		return nil, nil
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open the audit log: %w", err)
+	}
+	return &auditLog{file: file, now: time.Now}, nil
+}
+
+// Close closes the audit log file and returns the first failure to write a record, since the changes it could
+// not record are unaccounted for
+func (l *auditLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	err := l.file.Close()
+	if l.err != nil {
+		return fmt.Errorf("cannot write the audit log: %w", l.err)
+	}
+	return err
+}
+
+// record appends a record to the audit log
+func (l *auditLog) record(record auditRecord) {
+	record.Timestamp = l.now().UTC().Format(time.RFC3339Nano)
+	line, err := json.Marshal(record)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err == nil {
+		_, err = l.file.Write(append(line, '\n'))
+	}
+	if err != nil && l.err == nil {
+		l.err = err
+	}
+}
+
+// wrap returns a function wrapping the transport of the clients of a cluster to audit their write requests
+func (l *auditLog) wrap(cluster string) func(http.RoundTripper) http.RoundTripper {
+	return func(rt http.RoundTripper) http.RoundTripper {
+		return &auditTransport{log: l, cluster: cluster, next: rt}
+	}
+}
+
+// auditTransport records the write requests it sends
+type auditTransport struct {
+	log     *auditLog
+	cluster string
+	next    http.RoundTripper
+}
+
+// auditedVerbs are the verbs of the write requests by HTTP method
+var auditedVerbs = map[string]string{
+	http.MethodPost:   "create",
+	http.MethodPut:    "update",
+	http.MethodPatch:  "patch",
+	http.MethodDelete: "delete",
+}
+
+func (t *auditTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	verb, ok := auditedVerbs[request.Method]
+	if !ok {
+		return t.next.RoundTrip(request)
+	}
+	record := auditRecord{Cluster: t.cluster, Verb: verb}
+	record.Kind, record.Namespace, record.Name = parseResourcePath(request.URL.Path)
+	if verb == "create" && record.Name == "" {
+		record.Name = requestObjectName(request)
+	}
+	if verb == "delete" && record.Name == "" {
+		record.Verb = "deletecollection"
+	}
+
+	response, err := t.next.RoundTrip(request)
+	if err != nil {
+		record.Result = err.Error()
+	} else {
+		record.Result = response.Status
+	}
+	t.log.record(record)
+	return response, err
+}
+
+// parseResourcePath returns the resource, namespace and name of an API path, e.g.
+// /apis/serving.knative.dev/v1/namespaces/default/services/hello or /api/v1/namespaces/default
+func parseResourcePath(path string) (string, string, string) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	group := ""
+	switch {
+	case len(segments) >= 2 && segments[0] == "api":
+		segments = segments[2:]
+	case len(segments) >= 3 && segments[0] == "apis":
+		group = segments[1]
+		segments = segments[3:]
+	default:
+		return path, "", ""
+	}
+	namespace := ""
+	if len(segments) >= 3 && segments[0] == "namespaces" {
+		namespace = segments[1]
+		segments = segments[2:]
+	}
+	if len(segments) == 0 {
+		return path, "", ""
+	}
+	resource := segments[0]
+	if group != "" {
+		resource += "." + group
+	}
+	name := ""
+	if len(segments) >= 2 {
+		name = segments[1]
+	}
+	if len(segments) >= 3 {
+		// A subresource, e.g. the status of a service
+		resource += "/" + segments[2]
+	}
+	return resource, namespace, name
+}
+
+// requestObjectName returns the name of the object created by a request from its body
+func requestObjectName(request *http.Request) string {
+	if request.GetBody == nil {
+		return ""
+	}
+	body, err := request.GetBody()
+	if err != nil {
+		return ""
+	}
+	defer body.Close()
+	object := struct {
+		Metadata struct {
+			Name         string `json:"name"`
+			GenerateName string `json:"generateName"`
+		} `json:"metadata"`
+	}{}
+	if err := json.NewDecoder(body).Decode(&object); err != nil {
+		return ""
+	}
+	if object.Metadata.Name == "" {
+		return object.Metadata.GenerateName
+	}
+	return object.Metadata.Name
+}
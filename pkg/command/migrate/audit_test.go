@@ -0,0 +1,113 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestParseResourcePath(t *testing.T) {
+	for _, tc := range []struct {
+		path, resource, namespace, name string
+	}{
+		{"/api/v1/namespaces", "namespaces", "", ""},
+		{"/api/v1/namespaces/prod", "namespaces", "", "prod"},
+		{"/api/v1/namespaces/prod/configmaps", "configmaps", "prod", ""},
+		{"/api/v1/namespaces/prod/secrets/db", "secrets", "prod", "db"},
+		{"/apis/serving.knative.dev/v1/namespaces/prod/services/hello", "services.serving.knative.dev", "prod", "hello"},
+		{"/apis/serving.knative.dev/v1/namespaces/prod/services/hello/status", "services.serving.knative.dev/status", "prod", "hello"},
+		{"/apis/rbac.authorization.k8s.io/v1/clusterroles/view", "clusterroles.rbac.authorization.k8s.io", "", "view"},
+		{"/healthz", "/healthz", "", ""},
+	} {
+		resource, namespace, name := parseResourcePath(tc.path)
+		assert.Equal(t, resource, tc.resource, tc.path)
+		assert.Equal(t, namespace, tc.namespace, tc.path)
+		assert.Equal(t, name, tc.name, tc.path)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodPost:
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"hello"}}`))
+		case r.Method == http.MethodDelete:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"apiVersion":"v1","kind":"Status","status":"Failure","reason":"NotFound","code":404}`))
+		default:
+			_, _ = w.Write([]byte(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"hello"}}`))
+		}
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	assert.NilError(t, ioutil.WriteFile(path, []byte("{\"previous\":\"run\"}\n"), 0640))
+	audit, err := openAuditLog(path)
+	assert.NilError(t, err)
+	audit.now = func() time.Time { return time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC) }
+
+	cfg := &rest.Config{Host: server.URL}
+	cfg.Wrap(audit.wrap("destination.yaml"))
+	clientSet, err := kubernetes.NewForConfig(cfg)
+	assert.NilError(t, err)
+	ctx := context.Background()
+	_, err = clientSet.CoreV1().ConfigMaps("prod").Create(ctx, &apiv1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "hello"}}, metav1.CreateOptions{})
+	assert.NilError(t, err)
+	_, err = clientSet.CoreV1().ConfigMaps("prod").Get(ctx, "hello", metav1.GetOptions{})
+	assert.NilError(t, err)
+	_ = clientSet.CoreV1().ConfigMaps("prod").Delete(ctx, "gone", metav1.DeleteOptions{})
+	assert.NilError(t, audit.Close())
+
+	data, err := ioutil.ReadFile(path)
+	assert.NilError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	assert.Equal(t, len(lines), 3)
+	assert.Equal(t, lines[0], `{"previous":"run"}`)
+	records := []auditRecord{}
+	for _, line := range lines[1:] {
+		record := auditRecord{}
+		assert.NilError(t, json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+	assert.DeepEqual(t, records, []auditRecord{
+		{Timestamp: "2021-03-01T10:00:00Z", Cluster: "destination.yaml", Verb: "create", Kind: "configmaps", Namespace: "prod", Name: "hello", Result: "201 Created"},
+		{Timestamp: "2021-03-01T10:00:00Z", Cluster: "destination.yaml", Verb: "delete", Kind: "configmaps", Namespace: "prod", Name: "gone", Result: "404 Not Found"},
+	})
+
+	// Records which cannot be written fail the run once the log is closed
+	audit.err = nil
+	audit.record(auditRecord{Verb: "create"})
+	assert.ErrorContains(t, audit.Close(), "cannot write the audit log")
+
+	log, err := openAuditLog("")
+	assert.NilError(t, err)
+	assert.NilError(t, log.Close())
+}
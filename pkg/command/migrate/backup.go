@@ -0,0 +1,261 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+	"knative.dev/kn-plugin-migration/pkg/command"
+)
+
+const (
+	// DefaultBackupDir is the default directory of the backups of the services deleted from the source with --delete
+	DefaultBackupDir = "kn-migration-backups"
+	// backupTimeFormat names the backup directory of a run after the time it started
+	backupTimeFormat = "20060102-150405"
+)
+
+// backupRunDir returns the directory of the backups of a run, one bundle per namespace is written in it
+func backupRunDir(dir string, started time.Time) string {
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, started.Format(backupTimeFormat))
+}
+
+// backupServices writes the services about to be deleted from a source namespace, with their revisions and
+// configmaps, to a bundle in the backup directory of the run, so that a botched migration can be restored
+func backupServices(ctx context.Context, out io.Writer, clientSet kubernetes.Interface, migrationClient command.MigrationClient, namespace string, names []string, dir string) error {
+	if dir == "" || len(names) == 0 {
+		return nil
+	}
+	filter, err := newServiceFilter(names, "")
+	if err != nil {
+		return err
+	}
+	bundle := filepath.Join(dir, namespace)
+	if err := exportNamespace(ctx, out, clientSet, migrationClient, namespace, filter, bundle, nil); err != nil {
+		return fmt.Errorf("cannot back up the services of namespace %s before deleting them, nothing was deleted: %w", namespace, err)
+	}
+	fmt.Fprintln(out, "Backed up the services to delete from namespace", color.BlueString(namespace), "to", color.CyanString(bundle))
+	return nil
+}
+
+// backupBundles returns the bundles of a backup, the directory is either a bundle or the backup directory of a run
+func backupBundles(dir string) ([]string, error) {
+	if _, err := os.Stat(filepath.Join(dir, bundleIndexFile)); err == nil {
+		return []string{dir}, nil
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the backup %s: %w", dir, err)
+	}
+	bundles := []string{}
+	for _, entry := range entries {
+		if _, err := os.Stat(filepath.Join(dir, entry.Name(), bundleIndexFile)); entry.IsDir() && err == nil {
+			bundles = append(bundles, filepath.Join(dir, entry.Name()))
+		}
+	}
+	if len(bundles) == 0 {
+		return nil, fmt.Errorf("%s is not a backup written by 'kn migrate --delete'", dir)
+	}
+	sort.Strings(bundles)
+	return bundles, nil
+}
+
+type restoreCmdFlags struct {
+	From       string
+	KubeConfig string
+	Context    string
+	Namespaces []string
+	Services   []string
+	Output     string
+	AuditLog   string
+	Yes        bool
+	Options    *MigrationOptions
+}
+
+var restoreFlags restoreCmdFlags
+
+// NewRestoreCommand represents the 'migrate restore' command
+func NewRestoreCommand() *cobra.Command {
+	restoreFlags.Options = NewMigrationOptions()
+	restoreCmd := &cobra.Command{
+		Use:   "restore",
+		Short: "Restore the Knative services deleted from the source cluster by a migration",
+		Long: `Restore the Knative services deleted from the source cluster by a migration.
+
+'kn migrate --delete' backs up the services it deletes, with their revisions and configmaps,
+to a timestamped directory with one bundle per namespace. The services of the backup are
+recreated in the namespaces they were deleted from, with their revisions.`,
+		Example: `
+  # Restore every namespace of the backup of a migration
+  kn migrate restore --from kn-migration-backups/20221015-093000
+  # Restore a single service of the default namespace
+  kn migrate restore --from kn-migration-backups/20221015-093000 --namespace default --service hello`,
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := command.NewCommandContext(cmd)
+			defer cancel()
+
+			if restoreFlags.From == "" {
+				return fmt.Errorf("cannot get the backup directory, please use --from to set")
+			}
+			if restoreFlags.Options.ForceRecreate {
+				restoreFlags.Options.Force = true
+			}
+			if err := validateOutputFormat(restoreFlags.Output); err != nil {
+				return err
+			}
+			// Outside of the maintenance windows the services which exist again are not replaced
+			if restoreFlags.Options.replacing() {
+				outside, err := outsideMaintenanceWindows(time.Now())
+				if err != nil {
+					return err
+				}
+				if outside {
+					return fmt.Errorf("refused --force outside of the maintenance windows declared in the config file, nothing was restored")
+				}
+			}
+			filter, err := newServiceFilter(restoreFlags.Services, "")
+			if err != nil {
+				return err
+			}
+			bundles, err := backupBundles(restoreFlags.From)
+			if err != nil {
+				return err
+			}
+			cluster := clusterConfig{KubeConfig: restoreFlags.KubeConfig, Context: restoreFlags.Context}
+			if cluster.KubeConfig == "" {
+				cluster.KubeConfig = os.Getenv("KUBECONFIG")
+			}
+			audit, err := openAuditLog(restoreFlags.AuditLog)
+			if err != nil {
+				return err
+			}
+			cluster.audit = audit
+
+			// With a structured report the progress messages go to stderr so that stdout only holds the report
+			out := cmd.OutOrStdout()
+			report := newMigrationReport()
+			restoreFlags.Options.Out = out
+			if restoreFlags.Output != "" {
+				restoreFlags.Options.Out = cmd.ErrOrStderr()
+				command.ConfigureColors(cmd, os.Stderr)
+			}
+			err = restoreBackup(ctx, cluster, bundles, restoreFlags.Namespaces, filter, restoreFlags.Options, restoreFlags.Yes, report)
+			report.finish(err)
+			if err := printOutcome(out, cmd.ErrOrStderr(), report, restoreFlags.Output); err != nil {
+				fmt.Fprintln(restoreFlags.Options.Out, err.Error())
+			}
+			if err := audit.Close(); err != nil {
+				return err
+			}
+			if err != nil {
+				return fmt.Errorf("cannot restore the backup %s: %w", restoreFlags.From, err)
+			}
+			if report.failures() > 0 {
+				return fmt.Errorf("%d service(s) of the backup %s failed to restore", report.failures(), restoreFlags.From)
+			}
+			return nil
+		},
+	}
+
+	restoreCmd.Flags().StringVar(&restoreFlags.From, "from", "", "The backup directory of a migration run, or the bundle of one of its namespaces")
+	restoreCmd.Flags().StringVar(&restoreFlags.KubeConfig, "kubeconfig", "", "The kubeconfig of the cluster the services were deleted from (default is KUBECONFIG from environment variable)")
+	restoreCmd.Flags().StringVar(&restoreFlags.Context, "context", "", "The context of the kubeconfig of the cluster the services were deleted from (default is the current context)")
+	restoreCmd.Flags().StringSliceVarP(&restoreFlags.Namespaces, "namespace", "n", nil, "The namespaces to restore, comma separated or repeated (default is every namespace of the backup)")
+	restoreCmd.Flags().StringSliceVar(&restoreFlags.Services, "service", nil, "The names or glob patterns of the services to restore, comma separated or repeated (default is every service of the backup)")
+	restoreCmd.Flags().BoolVar(&restoreFlags.Options.Force, "force", false, "Replace the services which exist again in the cluster with their backup")
+	restoreCmd.Flags().BoolVar(&restoreFlags.Options.ForceRecreate, "force-recreate", false, "Delete the services which exist again and create them from their backup instead of applying their backup over them, implies --force")
+	restoreCmd.Flags().BoolVarP(&restoreFlags.Yes, "yes", "y", false, "Replace the services which exist again with --force without asking for confirmation")
+	restoreCmd.Flags().BoolVar(&restoreFlags.Options.Adopt, "adopt", false, "Replace the services which exist again even if they were not written by a migration, which are refused otherwise")
+	restoreCmd.Flags().BoolVar(&restoreFlags.Options.BestEffort, "best-effort", false, "Continue with the remaining services and namespaces when a service fails to restore")
+	restoreCmd.Flags().StringVar(&restoreFlags.AuditLog, "audit-log", "", "Append a JSON record with the timestamp, cluster, verb, resource, namespace, name and result of every create, update, patch and delete request sent to the clusters to this file")
+	restoreCmd.Flags().StringVarP(&restoreFlags.Output, "output", "o", "", "Output format of the restore report, one of: json, yaml (default is human readable)")
+	return restoreCmd
+}
+
+// restoreBackup recreates the services of the backup bundles in the namespaces of the cluster they were deleted from
+func restoreBackup(ctx context.Context, cluster clusterConfig, bundles []string, namespaces []string, filter *serviceFilter, options *MigrationOptions, yes bool, report *MigrationReport) error {
+	clientSet, servingClient, err := getClusterClients(cluster)
+	if err != nil {
+		return err
+	}
+	return restoreBundles(ctx, clientSet, func(namespace string) command.MigrationClient {
+		return command.NewMigrationClient(servingClient, namespace)
+	}, bundles, namespaces, filter, options, yes, report)
+}
+
+// restoreBundles recreates the services of the backup bundles in the namespaces they were deleted from,
+// with the migration client of each namespace. Replacing the services which exist again with Force is confirmed
+// before anything is restored, unless yes.
+func restoreBundles(ctx context.Context, clientSet kubernetes.Interface, migrationClients func(namespace string) command.MigrationClient, bundles []string, namespaces []string, filter *serviceFilter, options *MigrationOptions, yes bool, report *MigrationReport) error {
+	selectedBundles := []string{}
+	sources := []*bundleSource{}
+	for _, bundle := range bundles {
+		source, err := readBundle(bundle, nil)
+		if err != nil {
+			return err
+		}
+		selected := len(namespaces) == 0
+		for _, name := range namespaces {
+			selected = selected || name == source.Namespace()
+		}
+		if selected {
+			selectedBundles, sources = append(selectedBundles, bundle), append(sources, source)
+		}
+	}
+
+	if options.replacing() {
+		plans := []*migrationPlan{}
+		for _, source := range sources {
+			plan, err := buildPlan(ctx, source, clientSet, migrationClients(source.Namespace()), source.Namespace(), filter, options, false)
+			if err != nil {
+				return err
+			}
+			plans = append(plans, plan)
+		}
+		if err := confirmDestructiveActions(plans, yes); err != nil {
+			return err
+		}
+	}
+
+	for i, source := range sources {
+		namespace := source.Namespace()
+		fmt.Fprintln(options.out(), "Restoring the services of namespace", color.BlueString(namespace), "from", color.CyanString(selectedBundles[i]))
+		migrationClient := migrationClients(namespace)
+		namespaceReport := report.namespace(namespace, namespace)
+		_, err := migrateNamespace(ctx, source, clientSet, migrationClient, namespace, filter, options, namespaceReport)
+		if err != nil {
+			if !options.BestEffort || ctx.Err() != nil {
+				return err
+			}
+			fmt.Fprintln(options.out(), err.Error())
+			namespaceReport.Error = err.Error()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/kn-plugin-migration/pkg/command"
+)
+
+func TestBackupRestoreRoundTrip(t *testing.T) {
+	seed := simulatedBundle("default", "bye", "hello")
+	seed.configmaps = map[string]*apiv1.ConfigMap{
+		"hello-config": {ObjectMeta: metav1.ObjectMeta{Name: "hello-config", Namespace: "default"}, Data: map[string]string{"key": "value"}},
+	}
+	clientSet, migrationClient := newSimulatedDestination("default", seed)
+	dir := backupRunDir(t.TempDir(), time.Now())
+	assert.NilError(t, backupServices(context.Background(), io.Discard, clientSet, migrationClient, "default", []string{"hello"}, dir))
+
+	// The backed up service is deleted with its revisions and configmap, the other one is kept
+	assert.NilError(t, migrationClient.DeleteService(context.Background(), "hello"))
+	assert.NilError(t, clientSet.CoreV1().ConfigMaps("default").Delete(context.Background(), "hello-config", metav1.DeleteOptions{}))
+	revisions, err := migrationClient.ListRevisionByService(context.Background(), "hello")
+	assert.NilError(t, err)
+	assert.Equal(t, len(revisions.Items), 0)
+
+	bundles, err := backupBundles(dir)
+	assert.NilError(t, err)
+	filter, err := newServiceFilter(nil, "")
+	assert.NilError(t, err)
+	options := NewMigrationOptions()
+	options.Out = io.Discard
+	report := newMigrationReport()
+	err = restoreBundles(context.Background(), clientSet, func(namespace string) command.MigrationClient {
+		assert.Equal(t, namespace, "default")
+		return migrationClient
+	}, bundles, nil, filter, options, false, report)
+	assert.NilError(t, err)
+	report.finish(err)
+	assert.Assert(t, report.Succeeded)
+	assert.Equal(t, len(report.Namespaces), 1)
+	assert.Equal(t, len(report.Namespaces[0].Services), 1)
+	assert.Equal(t, report.Namespaces[0].Services[0].Name, "hello")
+
+	// The service is back with its revisions and configmap
+	revisions, err = migrationClient.ListRevisionByService(context.Background(), "hello")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, revisionNames(revisions), []string{"hello-00001", "hello-00002"})
+	configmap, err := clientSet.CoreV1().ConfigMaps("default").Get(context.Background(), "hello-config", metav1.GetOptions{})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, configmap.Data, map[string]string{"key": "value"})
+
+	// A namespace which is not selected is not restored
+	report = newMigrationReport()
+	assert.NilError(t, restoreBundles(context.Background(), clientSet, nil, bundles, []string{"other"}, filter, options, false, report))
+	assert.Equal(t, len(report.Namespaces), 0)
+
+	// Replacing the service which exists again is confirmed before anything is restored
+	withConfirmation(t, false, "")
+	options.Force = true
+	migrationClients := func(namespace string) command.MigrationClient { return migrationClient }
+	report = newMigrationReport()
+	assert.ErrorContains(t, restoreBundles(context.Background(), clientSet, migrationClients, bundles, nil, filter, options, false, report), "use --yes to run non-interactively")
+	assert.Equal(t, len(report.Namespaces), 0)
+	assert.NilError(t, restoreBundles(context.Background(), clientSet, migrationClients, bundles, nil, filter, options, true, report))
+	assert.Equal(t, len(report.Namespaces), 1)
+}
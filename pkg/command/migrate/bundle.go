@@ -0,0 +1,192 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// bundleIndexFile is the name of the index manifest of an exported bundle
+const bundleIndexFile = "index.yaml"
+
+// bundleIndex is the index manifest listing every resource file of an exported bundle
+type bundleIndex struct {
+	Namespace  string        `json:"namespace"`
+	ExportedAt metav1.Time   `json:"exportedAt"`
+	Resources  []bundleEntry `json:"resources"`
+	// Encryption is set when the resource files are encrypted
+	Encryption *bundleEncryption `json:"encryption,omitempty"`
+}
+
+// bundleEntry is a single resource of an exported bundle
+type bundleEntry struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	// Service is the name of the service the resource belongs to
+	Service string `json:"service"`
+	// File is the path of the resource file relative to the bundle directory
+	File string `json:"file"`
+}
+
+// bundleWriter writes resources and the index manifest of a bundle to a directory
+type bundleWriter struct {
+	dir   string
+	index bundleIndex
+	// dataKey encrypts the resource files, nil when the bundle is not encrypted
+	dataKey []byte
+}
+
+// newBundleWriter returns a writer of a bundle encrypted with the key, not encrypted when the key is nil
+func newBundleWriter(dir, namespace string, key *bundleKey) (*bundleWriter, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	writer := &bundleWriter{
+		dir: dir,
+		index: bundleIndex{
+			Namespace:  namespace,
+			ExportedAt: metav1.NewTime(time.Now()),
+			Resources:  []bundleEntry{},
+		},
+	}
+	if key != nil {
+		writer.dataKey, writer.index.Encryption, err = key.newDataKey()
+		if err != nil {
+			return nil, fmt.Errorf("cannot encrypt the bundle: %w", err)
+		}
+	}
+	return writer, nil
+}
+
+// encrypted returns true if the resource files of the bundle are encrypted
+func (w *bundleWriter) encrypted() bool {
+	return w.dataKey != nil
+}
+
+// write serializes a resource to <kind directory>/<name>.yaml, or <name>.yaml.enc when the bundle is encrypted,
+// and records it in the index
+func (w *bundleWriter) write(kind, name, service string, object interface{}) error {
+	data, err := yaml.Marshal(object)
+	if err != nil {
+		return err
+	}
+	file := filepath.Join(kindDirectory(kind), name+".yaml")
+	if w.encrypted() {
+		file += bundleFileSuffix
+		data, err = seal(w.dataKey, data)
+		if err != nil {
+			return err
+		}
+	}
+	err = os.MkdirAll(filepath.Join(w.dir, kindDirectory(kind)), 0755)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(filepath.Join(w.dir, file), data, 0644)
+	if err != nil {
+		return err
+	}
+	w.index.Resources = append(w.index.Resources, bundleEntry{Kind: kind, Name: name, Service: service, File: filepath.ToSlash(file)})
+	return nil
+}
+
+// close writes the index manifest
+func (w *bundleWriter) close() error {
+	data, err := yaml.Marshal(w.index)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(w.dir, bundleIndexFile), data, 0644)
+}
+
+// kindDirectory returns the bundle directory of a kind, e.g. services for Service
+func kindDirectory(kind string) string {
+	return strings.ToLower(kind) + "s"
+}
+
+// readBundle loads an exported bundle from a directory, resolving the files listed in its index manifest.
+// The key decrypts an encrypted bundle and is ignored for a bundle which is not encrypted.
+func readBundle(dir string, key *bundleKey) (*bundleSource, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, bundleIndexFile))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the bundle index of %s: %w", dir, err)
+	}
+	index := bundleIndex{}
+	err = yaml.Unmarshal(data, &index)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse the bundle index of %s: %w", dir, err)
+	}
+	var dataKey []byte
+	if index.Encryption != nil {
+		dataKey, err = key.dataKey(index.Encryption)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decrypt the bundle %s: %w", dir, err)
+		}
+	}
+
+	source := &bundleSource{
+		namespace:  index.Namespace,
+		configmaps: map[string]*apiv1.ConfigMap{},
+		secrets:    map[string]*apiv1.Secret{},
+		revisions:  map[string][]serving_v1_api.Revision{},
+	}
+	for _, entry := range index.Resources {
+		data, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(entry.File)))
+		if err != nil {
+			return nil, err
+		}
+		if dataKey != nil {
+			data, err = open(dataKey, data)
+			if err != nil {
+				return nil, fmt.Errorf("cannot decrypt %s: %w", entry.File, err)
+			}
+		}
+		switch entry.Kind {
+		case "ConfigMap":
+			configmap := &apiv1.ConfigMap{}
+			err = yaml.Unmarshal(data, configmap)
+			source.configmaps[configmap.Name] = configmap
+		case "Secret":
+			secret := &apiv1.Secret{}
+			err = yaml.Unmarshal(data, secret)
+			source.secrets[secret.Name] = secret
+		case "Service":
+			service := serving_v1_api.Service{}
+			err = yaml.Unmarshal(data, &service)
+			source.services = append(source.services, service)
+		case "Revision":
+			revision := serving_v1_api.Revision{}
+			err = yaml.Unmarshal(data, &revision)
+			source.revisions[entry.Service] = append(source.revisions[entry.Service], revision)
+		default:
+			return nil, fmt.Errorf("unsupported kind %s of %s in the bundle index", entry.Kind, entry.File)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse %s: %w", entry.File, err)
+		}
+	}
+	return source, nil
+}
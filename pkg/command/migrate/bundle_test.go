@@ -0,0 +1,73 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"gotest.tools/assert"
+	apiv1 "k8s.io/api/core/v1"
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+func TestReadBundle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bundle")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	writer, err := newBundleWriter(dir, "default", nil)
+	assert.NilError(t, err)
+	assert.NilError(t, writer.write("ConfigMap", "hello-config", "hello", apiv1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "hello-config"},
+		Data:       map[string]string{"key": "value"},
+	}))
+	assert.NilError(t, writer.write("Service", "hello", "hello", serving_v1_api.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "hello", Labels: map[string]string{"team": "payments"}},
+	}))
+	assert.NilError(t, writer.write("Service", "bye", "bye", serving_v1_api.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "bye"},
+	}))
+	assert.NilError(t, writer.write("Revision", "hello-00001", "hello", serving_v1_api.Revision{
+		ObjectMeta: metav1.ObjectMeta{Name: "hello-00001"},
+	}))
+	assert.NilError(t, writer.close())
+
+	source, err := readBundle(dir, nil)
+	assert.NilError(t, err)
+	assert.Equal(t, source.Namespace(), "default")
+
+	configmap, err := source.GetConfigmap(context.Background(), "hello-config")
+	assert.NilError(t, err)
+	assert.Equal(t, configmap.Data["key"], "value")
+	_, err = source.GetConfigmap(context.Background(), "bye-config")
+	assert.Assert(t, api_errors.IsNotFound(err))
+
+	revisions, err := source.ListRevisionByService(context.Background(), "hello")
+	assert.NilError(t, err)
+	assert.Equal(t, len(revisions.Items), 1)
+	assert.Equal(t, revisions.Items[0].Name, "hello-00001")
+
+	filter, err := newServiceFilter(nil, "team=payments")
+	assert.NilError(t, err)
+	services, err := source.ListServices(context.Background(), filter)
+	assert.NilError(t, err)
+	assert.Equal(t, len(services.Items), 1)
+	assert.Equal(t, services.Items[0].Name, "hello")
+}
@@ -0,0 +1,200 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const (
+	// BundlePassphraseEnv is the environment variable holding the passphrase of the bundles encrypted with a passphrase
+	BundlePassphraseEnv = "KN_MIGRATION_PASSPHRASE"
+
+	// bundleKeyIterations is the number of PBKDF2 iterations deriving the key of a passphrase
+	bundleKeyIterations = 600000
+	// bundleFileSuffix is appended to the resource files of an encrypted bundle
+	bundleFileSuffix = ".enc"
+)
+
+// bundleKey encrypts or decrypts a bundle, either with an age recipient or identity file, or with the
+// passphrase of the BundlePassphraseEnv environment variable
+type bundleKey struct {
+	method string
+	value  string
+}
+
+// bundleEncryption is how the data key encrypting the resource files of a bundle is wrapped, recorded in its index
+type bundleEncryption struct {
+	// Method is age or passphrase
+	Method string `json:"method"`
+	// Salt and Iterations derive the key of the passphrase wrapping the data key
+	Salt       []byte `json:"salt,omitempty"`
+	Iterations int    `json:"iterations,omitempty"`
+	// Key is the wrapped data key
+	Key []byte `json:"key"`
+}
+
+// parseBundleKey parses age:<recipient or identity file> or passphrase, nil when empty
+func parseBundleKey(value string) (*bundleKey, error) {
+	switch {
+	case value == "":
+		return nil, nil
+	case value == "passphrase":
+		return &bundleKey{method: "passphrase"}, nil
+	case strings.HasPrefix(value, "age:") && len(value) > len("age:"):
+		return &bundleKey{method: "age", value: strings.TrimPrefix(value, "age:")}, nil
+	}
+	return nil, fmt.Errorf("unsupported bundle key %q, use age:<recipient> to encrypt, age:<identity file> to decrypt, or passphrase", value)
+}
+
+// newDataKey returns a random key encrypting the resource files of a bundle and its wrapping
+func (k *bundleKey) newDataKey() ([]byte, *bundleEncryption, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, err
+	}
+	encryption := &bundleEncryption{Method: k.method}
+	switch k.method {
+	case "age":
+		wrapped, err := runAge(dataKey, "--encrypt", "--armor", "--recipient", k.value)
+		if err != nil {
+			return nil, nil, err
+		}
+		encryption.Key = wrapped
+	default:
+		passphrase, err := bundlePassphrase()
+		if err != nil {
+			return nil, nil, err
+		}
+		encryption.Salt = make([]byte, 16)
+		if _, err := rand.Read(encryption.Salt); err != nil {
+			return nil, nil, err
+		}
+		encryption.Iterations = bundleKeyIterations
+		wrapped, err := seal(pbkdf2(passphrase, encryption.Salt, encryption.Iterations), dataKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		encryption.Key = wrapped
+	}
+	return dataKey, encryption, nil
+}
+
+// dataKey unwraps the data key of an encrypted bundle
+func (k *bundleKey) dataKey(encryption *bundleEncryption) ([]byte, error) {
+	if k == nil {
+		return nil, fmt.Errorf("the bundle is encrypted with %s, use --decrypt-with to read it", encryption.Method)
+	}
+	if k.method != encryption.Method {
+		return nil, fmt.Errorf("the bundle is encrypted with %s, not %s", encryption.Method, k.method)
+	}
+	if k.method == "age" {
+		return runAge(encryption.Key, "--decrypt", "--identity", k.value)
+	}
+	passphrase, err := bundlePassphrase()
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := open(pbkdf2(passphrase, encryption.Salt, encryption.Iterations), encryption.Key)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt the bundle, is the passphrase right?")
+	}
+	return dataKey, nil
+}
+
+func bundlePassphrase() ([]byte, error) {
+	passphrase := os.Getenv(BundlePassphraseEnv)
+	if passphrase == "" {
+		return nil, fmt.Errorf("set the passphrase of the bundle in the %s environment variable", BundlePassphraseEnv)
+	}
+	return []byte(passphrase), nil
+}
+
+// runAge runs the age command with the input on its standard input and returns its output
+func runAge(input []byte, args ...string) ([]byte, error) {
+	path, err := exec.LookPath("age")
+	if err != nil {
+		return nil, fmt.Errorf("the age command is required to use age keys: %w", err)
+	}
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd := exec.Command(path, args...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("age failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// seal encrypts data with AES-256-GCM, prefixed with its random nonce
+func seal(key, data []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, data, nil), nil
+}
+
+// open decrypts the data encrypted by seal
+func open(key, data []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("the encrypted data is truncated")
+	}
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// pbkdf2 derives a 32 bytes key from a passphrase with PBKDF2-HMAC-SHA256 as defined by RFC 8018
+func pbkdf2(passphrase, salt []byte, iterations int) []byte {
+	mac := hmac.New(sha256.New, passphrase)
+	mac.Write(salt)
+	mac.Write([]byte{0, 0, 0, 1})
+	u := mac.Sum(nil)
+	key := append([]byte{}, u...)
+	for i := 1; i < iterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return key
+}
@@ -0,0 +1,129 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPBKDF2(t *testing.T) {
+	// Test vectors of PBKDF2-HMAC-SHA256 with a 32 bytes key
+	for iterations, expected := range map[int]string{
+		1:    "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b",
+		2:    "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43",
+		4096: "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a",
+	} {
+		assert.Equal(t, hex.EncodeToString(pbkdf2([]byte("password"), []byte("salt"), iterations)), expected)
+	}
+}
+
+func TestParseBundleKey(t *testing.T) {
+	key, err := parseBundleKey("")
+	assert.NilError(t, err)
+	assert.Assert(t, key == nil)
+
+	key, err = parseBundleKey("passphrase")
+	assert.NilError(t, err)
+	assert.Equal(t, key.method, "passphrase")
+
+	key, err = parseBundleKey("age:key.txt")
+	assert.NilError(t, err)
+	assert.Equal(t, key.method, "age")
+	assert.Equal(t, key.value, "key.txt")
+
+	_, err = parseBundleKey("age:")
+	assert.ErrorContains(t, err, "unsupported bundle key")
+	_, err = parseBundleKey("gpg:key")
+	assert.ErrorContains(t, err, "unsupported bundle key")
+}
+
+// writeSecretBundle writes a bundle holding a secret encrypted with the key
+func writeSecretBundle(t *testing.T, dir string, key *bundleKey) {
+	writer, err := newBundleWriter(dir, "default", key)
+	assert.NilError(t, err)
+	assert.NilError(t, writer.write("Secret", "hello-secret", "hello", apiv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "hello-secret"},
+		Data:       map[string][]byte{"password": []byte("s3cr3t")},
+	}))
+	assert.NilError(t, writer.close())
+}
+
+func TestEncryptedBundleWithPassphrase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bundle")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	defer os.Unsetenv(BundlePassphraseEnv)
+
+	key := &bundleKey{method: "passphrase"}
+	os.Unsetenv(BundlePassphraseEnv)
+	_, err = newBundleWriter(dir, "default", key)
+	assert.ErrorContains(t, err, BundlePassphraseEnv)
+
+	os.Setenv(BundlePassphraseEnv, "correct horse battery staple")
+	writeSecretBundle(t, dir, key)
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "secrets", "hello-secret.yaml.enc"))
+	assert.NilError(t, err)
+	assert.Assert(t, !strings.Contains(string(data), "hello-secret"))
+
+	_, err = readBundle(dir, nil)
+	assert.ErrorContains(t, err, "the bundle is encrypted with passphrase, use --decrypt-with to read it")
+	_, err = readBundle(dir, &bundleKey{method: "age", value: "key.txt"})
+	assert.ErrorContains(t, err, "the bundle is encrypted with passphrase, not age")
+
+	os.Setenv(BundlePassphraseEnv, "wrong")
+	_, err = readBundle(dir, key)
+	assert.ErrorContains(t, err, "is the passphrase right?")
+
+	os.Setenv(BundlePassphraseEnv, "correct horse battery staple")
+	source, err := readBundle(dir, key)
+	assert.NilError(t, err)
+	secret, err := source.GetSecret(context.Background(), "hello-secret")
+	assert.NilError(t, err)
+	assert.Equal(t, string(secret.Data["password"]), "s3cr3t")
+}
+
+func TestEncryptedBundleWithAge(t *testing.T) {
+	if _, err := exec.LookPath("age-keygen"); err != nil {
+		t.Skip("age is not installed")
+	}
+	dir, err := ioutil.TempDir("", "bundle")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	identity := filepath.Join(dir, "key.txt")
+	assert.NilError(t, exec.Command("age-keygen", "-o", identity).Run())
+	recipient, err := exec.Command("age-keygen", "-y", identity).Output()
+	assert.NilError(t, err)
+
+	bundle := filepath.Join(dir, "bundle")
+	writeSecretBundle(t, bundle, &bundleKey{method: "age", value: strings.TrimSpace(string(recipient))})
+	source, err := readBundle(bundle, &bundleKey{method: "age", value: identity})
+	assert.NilError(t, err)
+	secret, err := source.GetSecret(context.Background(), "hello-secret")
+	assert.NilError(t, err)
+	assert.Equal(t, string(secret.Data["password"]), "s3cr3t")
+}
@@ -0,0 +1,295 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"knative.dev/serving/pkg/apis/config"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+// servingVersionLabels hold the version of Knative Serving on its namespace, the first one set wins
+var servingVersionLabels = []string{"app.kubernetes.io/version", "serving.knative.dev/release"}
+
+// destinationCapabilities are the Knative Serving version and the features of the destination cluster
+type destinationCapabilities struct {
+	Version  string
+	Features *config.Features
+}
+
+// podSpecFeature is a field of the pod spec which Knative Serving only accepts when its feature is not disabled
+type podSpecFeature struct {
+	field string
+	key   string
+	flag  func(*config.Features) config.Flag
+	uses  func(apiv1.PodSpec) bool
+}
+
+// podSpecFeatures are the fields checked against the config-features of the destination, as validated by its webhook
+var podSpecFeatures = []podSpecFeature{
+	{
+		field: "multiple containers",
+		key:   "multi-container",
+		flag:  func(f *config.Features) config.Flag { return f.MultiContainer },
+		uses:  func(spec apiv1.PodSpec) bool { return len(spec.Containers) > 1 },
+	},
+	{
+		field: "init containers",
+		key:   "kubernetes.podspec-init-containers",
+		flag:  func(f *config.Features) config.Flag { return f.PodSpecInitContainers },
+		uses:  func(spec apiv1.PodSpec) bool { return len(spec.InitContainers) > 0 },
+	},
+	{
+		field: "emptyDir volumes",
+		key:   "kubernetes.podspec-volumes-emptydir",
+		flag:  func(f *config.Features) config.Flag { return f.PodSpecVolumesEmptyDir },
+		uses: func(spec apiv1.PodSpec) bool {
+			for _, volume := range spec.Volumes {
+				if volume.EmptyDir != nil {
+					return true
+				}
+			}
+			return false
+		},
+	},
+	{
+		field: "persistentVolumeClaim volumes",
+		key:   "kubernetes.podspec-persistent-volume-claim",
+		flag:  func(f *config.Features) config.Flag { return f.PodSpecPersistentVolumeClaim },
+		uses: func(spec apiv1.PodSpec) bool {
+			for _, volume := range spec.Volumes {
+				if volume.PersistentVolumeClaim != nil {
+					return true
+				}
+			}
+			return false
+		},
+	},
+	{
+		field: "writable persistentVolumeClaim volumes",
+		key:   "kubernetes.podspec-persistent-volume-write",
+		flag:  func(f *config.Features) config.Flag { return f.PodSpecPersistentVolumeWrite },
+		uses: func(spec apiv1.PodSpec) bool {
+			for _, volume := range spec.Volumes {
+				if volume.PersistentVolumeClaim != nil && !volume.PersistentVolumeClaim.ReadOnly {
+					return true
+				}
+			}
+			return false
+		},
+	},
+	{
+		field: "affinity",
+		key:   "kubernetes.podspec-affinity",
+		flag:  func(f *config.Features) config.Flag { return f.PodSpecAffinity },
+		uses:  func(spec apiv1.PodSpec) bool { return spec.Affinity != nil },
+	},
+	{
+		field: "topologySpreadConstraints",
+		key:   "kubernetes.podspec-topologyspreadconstraints",
+		flag:  func(f *config.Features) config.Flag { return f.PodSpecTopologySpreadConstraints },
+		uses:  func(spec apiv1.PodSpec) bool { return len(spec.TopologySpreadConstraints) > 0 },
+	},
+	{
+		field: "nodeSelector",
+		key:   "kubernetes.podspec-nodeselector",
+		flag:  func(f *config.Features) config.Flag { return f.PodSpecNodeSelector },
+		uses:  func(spec apiv1.PodSpec) bool { return len(spec.NodeSelector) > 0 },
+	},
+	{
+		field: "tolerations",
+		key:   "kubernetes.podspec-tolerations",
+		flag:  func(f *config.Features) config.Flag { return f.PodSpecTolerations },
+		uses:  func(spec apiv1.PodSpec) bool { return len(spec.Tolerations) > 0 },
+	},
+	{
+		field: "hostAliases",
+		key:   "kubernetes.podspec-hostaliases",
+		flag:  func(f *config.Features) config.Flag { return f.PodSpecHostAliases },
+		uses:  func(spec apiv1.PodSpec) bool { return len(spec.HostAliases) > 0 },
+	},
+	{
+		field: "runtimeClassName",
+		key:   "kubernetes.podspec-runtimeclassname",
+		flag:  func(f *config.Features) config.Flag { return f.PodSpecRuntimeClassName },
+		uses:  func(spec apiv1.PodSpec) bool { return spec.RuntimeClassName != nil },
+	},
+	{
+		field: "priorityClassName",
+		key:   "kubernetes.podspec-priorityclassname",
+		flag:  func(f *config.Features) config.Flag { return f.PodSpecPriorityClassName },
+		uses:  func(spec apiv1.PodSpec) bool { return spec.PriorityClassName != "" },
+	},
+	{
+		field: "schedulerName",
+		key:   "kubernetes.podspec-schedulername",
+		flag:  func(f *config.Features) config.Flag { return f.PodSpecSchedulerName },
+		uses:  func(spec apiv1.PodSpec) bool { return spec.SchedulerName != "" },
+	},
+	{
+		field: "dnsPolicy",
+		key:   "kubernetes.podspec-dnspolicy",
+		flag:  func(f *config.Features) config.Flag { return f.PodSpecDNSPolicy },
+		uses:  func(spec apiv1.PodSpec) bool { return spec.DNSPolicy != "" },
+	},
+	{
+		field: "dnsConfig",
+		key:   "kubernetes.podspec-dnsconfig",
+		flag:  func(f *config.Features) config.Flag { return f.PodSpecDNSConfig },
+		uses:  func(spec apiv1.PodSpec) bool { return spec.DNSConfig != nil },
+	},
+	{
+		field: "a pod securityContext",
+		key:   "kubernetes.podspec-securitycontext",
+		flag:  func(f *config.Features) config.Flag { return f.PodSpecSecurityContext },
+		uses: func(spec apiv1.PodSpec) bool {
+			if spec.SecurityContext == nil {
+				return false
+			}
+			// The seccomp profile is accepted without the feature
+			context := spec.SecurityContext.DeepCopy()
+			context.SeccompProfile = nil
+			return !equality.Semantic.DeepEqual(context, &apiv1.PodSecurityContext{})
+		},
+	},
+	{
+		field: "added capabilities",
+		key:   "kubernetes.containerspec-addcapabilities",
+		flag:  func(f *config.Features) config.Flag { return f.ContainerSpecAddCapabilities },
+		uses: func(spec apiv1.PodSpec) bool {
+			for _, container := range spec.Containers {
+				if container.SecurityContext == nil || container.SecurityContext.Capabilities == nil {
+					continue
+				}
+				for _, capability := range container.SecurityContext.Capabilities.Add {
+					// NET_BIND_SERVICE is accepted without the feature
+					if capability != "NET_BIND_SERVICE" {
+						return true
+					}
+				}
+			}
+			return false
+		},
+	},
+	{
+		field: "fieldRef environment variables",
+		key:   "kubernetes.podspec-fieldref",
+		flag:  func(f *config.Features) config.Flag { return f.PodSpecFieldRef },
+		uses: func(spec apiv1.PodSpec) bool {
+			for _, container := range append(spec.InitContainers, spec.Containers...) {
+				for _, env := range container.Env {
+					if env.ValueFrom != nil && (env.ValueFrom.FieldRef != nil || env.ValueFrom.ResourceFieldRef != nil) {
+						return true
+					}
+				}
+			}
+			return false
+		},
+	},
+}
+
+// detectCapabilities reads the version and the config-features of Knative Serving in the destination, nil when
+// they cannot be read and the services are migrated without checking them
+func detectCapabilities(ctx context.Context, clientSet kubernetes.Interface, options *MigrationOptions) *destinationCapabilities {
+	configmap, err := clientSet.CoreV1().ConfigMaps(networkConfigNamespace).Get(ctx, config.FeaturesConfigName, metav1.GetOptions{})
+	if api_errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		options.warn("Cannot read the %s configmap of the destination, the services are not checked against its features: %v", config.FeaturesConfigName, err)
+		return nil
+	}
+	features, err := config.NewFeaturesConfigFromConfigMap(configmap)
+	if err != nil {
+		options.warn("Cannot parse the %s configmap of the destination, the services are not checked against its features: %v", config.FeaturesConfigName, err)
+		return nil
+	}
+	capabilities := &destinationCapabilities{Version: "unknown", Features: features}
+	// The version is only reported, reading it is not required to check the features
+	namespace, err := clientSet.CoreV1().Namespaces().Get(ctx, networkConfigNamespace, metav1.GetOptions{})
+	if err == nil {
+		for _, label := range servingVersionLabels {
+			if version := namespace.Labels[label]; version != "" {
+				capabilities.Version = version
+				break
+			}
+		}
+	}
+	return capabilities
+}
+
+// unsupportedFields returns the fields of a pod spec which the destination does not accept
+func (c *destinationCapabilities) unsupportedFields(spec apiv1.PodSpec) []string {
+	fields := []string{}
+	for _, feature := range podSpecFeatures {
+		if flag := feature.flag(c.Features); flag == config.Disabled && feature.uses(spec) {
+			fields = append(fields, fmt.Sprintf("%s (%s is %s)", feature.field, feature.key, flag))
+		}
+	}
+	return fields
+}
+
+// incompatibility is a service whose template or revisions use fields the destination does not accept
+type incompatibility struct {
+	Service string
+	Fields  []string
+}
+
+// checkCompatibility checks the template and the selected revisions of every service against the capabilities of
+// the destination, and returns the services its webhook would reject. Nothing is checked without capabilities.
+func checkCompatibility(ctx context.Context, source migrationSource, services *serving_v1_api.ServiceList, capabilities *destinationCapabilities, selection RevisionSelection) ([]incompatibility, error) {
+	incompatibilities := []incompatibility{}
+	if capabilities == nil {
+		return incompatibilities, nil
+	}
+	for i := 0; i < len(services.Items); i++ {
+		serviceS := services.Items[i]
+		fields := capabilities.unsupportedFields(serviceS.Spec.Template.Spec.PodSpec)
+		seen := map[string]bool{}
+		for _, field := range fields {
+			seen[field] = true
+		}
+
+		revisionsS, err := source.ListRevisionByService(ctx, serviceS.Name)
+		if err != nil {
+			return nil, err
+		}
+		revisionsS, _ = selectRevisions(serviceS, revisionsS, selection)
+		for _, revisionS := range revisionsS.Items {
+			for _, field := range capabilities.unsupportedFields(revisionS.Spec.PodSpec) {
+				if !seen[field] {
+					seen[field] = true
+					fields = append(fields, field)
+				}
+			}
+		}
+		if len(fields) > 0 {
+			incompatibilities = append(incompatibilities, incompatibility{Service: serviceS.Name, Fields: fields})
+		}
+	}
+	return incompatibilities, nil
+}
+
+func (i incompatibility) String() string {
+	return fmt.Sprintf("Service %s uses %s", i.Service, strings.Join(i.Fields, ", "))
+}
@@ -0,0 +1,92 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"testing"
+
+	"gotest.tools/assert"
+	apiv1 "k8s.io/api/core/v1"
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"knative.dev/serving/pkg/apis/config"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+// withFeatures installs the config-features of Knative Serving in a destination
+func withFeatures(t *testing.T, clientSet kubernetes.Interface, version string, features map[string]string) {
+	namespace := &apiv1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "knative-serving", Labels: map[string]string{"app.kubernetes.io/version": version}}}
+	_, err := clientSet.CoreV1().Namespaces().Create(context.Background(), namespace, metav1.CreateOptions{})
+	assert.NilError(t, err)
+	configmap := &apiv1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: config.FeaturesConfigName, Namespace: "knative-serving"}, Data: features}
+	_, err = clientSet.CoreV1().ConfigMaps("knative-serving").Create(context.Background(), configmap, metav1.CreateOptions{})
+	assert.NilError(t, err)
+}
+
+func TestUnsupportedFields(t *testing.T) {
+	features, err := config.NewFeaturesConfigFromMap(map[string]string{"kubernetes.podspec-volumes-emptydir": "enabled"})
+	assert.NilError(t, err)
+	capabilities := &destinationCapabilities{Version: "1.8.0", Features: features}
+
+	spec := apiv1.PodSpec{
+		Containers:      []apiv1.Container{{Name: "app"}, {Name: "sidecar"}},
+		InitContainers:  []apiv1.Container{{Name: "init"}},
+		Volumes:         []apiv1.Volume{{Name: "cache", VolumeSource: apiv1.VolumeSource{EmptyDir: &apiv1.EmptyDirVolumeSource{}}}},
+		SecurityContext: &apiv1.PodSecurityContext{SeccompProfile: &apiv1.SeccompProfile{Type: apiv1.SeccompProfileTypeRuntimeDefault}},
+	}
+	assert.DeepEqual(t, capabilities.unsupportedFields(spec), []string{"init containers (kubernetes.podspec-init-containers is Disabled)"})
+}
+
+func TestCheckCompatibility(t *testing.T) {
+	filter, err := newServiceFilter(nil, "")
+	assert.NilError(t, err)
+	services := func(source *bundleSource) *serving_v1_api.ServiceList {
+		list, err := source.ListServices(context.Background(), filter)
+		assert.NilError(t, err)
+		return list
+	}
+	source := simulatedBundle("default", "hello", "bye")
+	source.services[0].Spec.Template.Spec.InitContainers = []apiv1.Container{{Name: "init"}}
+	source.revisions["hello"][0].Spec.NodeSelector = map[string]string{"disk": "ssd"}
+
+	// Without config-features the destination is not checked
+	clientSetD, _ := newSimulatedDestination("prod", simulatedBundle("prod"))
+	options := NewMigrationOptions()
+	incompatibilities, err := checkCompatibility(context.Background(), source, services(source), detectCapabilities(context.Background(), clientSetD, options), options.Revisions)
+	assert.NilError(t, err)
+	assert.Equal(t, len(incompatibilities), 0)
+
+	// Services the destination rejects fail the migration before anything is created
+	clientSetD, migrationClientD := newSimulatedDestination("prod", simulatedBundle("prod"))
+	withFeatures(t, clientSetD, "1.8.0", map[string]string{})
+	capabilities := detectCapabilities(context.Background(), clientSetD, options)
+	assert.Equal(t, capabilities.Version, "1.8.0")
+	incompatibilities, err = checkCompatibility(context.Background(), source, services(source), capabilities, options.Revisions)
+	assert.NilError(t, err)
+	assert.Equal(t, len(incompatibilities), 1)
+	assert.Equal(t, incompatibilities[0].String(), "Service hello uses init containers (kubernetes.podspec-init-containers is Disabled), nodeSelector (kubernetes.podspec-nodeselector is Disabled)")
+
+	_, err = migrateNamespace(context.Background(), source, clientSetD, migrationClientD, "prod", filter, options, newMigrationReport().namespace("default", "prod"))
+	assert.ErrorContains(t, err, "1 service(s) of namespace default use fields the destination does not accept")
+	_, err = migrationClientD.GetService(context.Background(), "bye")
+	assert.Assert(t, api_errors.IsNotFound(err))
+
+	// Revisions which are not migrated are not checked
+	incompatibilities, err = checkCompatibility(context.Background(), source, services(source), capabilities, RevisionsLatest)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, incompatibilities[0].Fields, []string{"init containers (kubernetes.podspec-init-containers is Disabled)"})
+}
@@ -0,0 +1,114 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// FailureInjection randomly fails writes to the destination, so that teams can rehearse their runbooks
+// (--resume, --rollback-on-failure, --best-effort) against partial failures before the real migration.
+// It is set with a comma separated list of key=value, e.g. rate=0.05,seed=42.
+type FailureInjection struct {
+	// Rate is the probability that a write to the destination fails, between 0 and 1
+	Rate float64
+	// Seed makes the failures reproducible across rehearsals, a random seed is used when zero
+	Seed int64
+
+	mu     sync.Mutex
+	random *rand.Rand
+}
+
+// String implements pflag.Value
+func (f *FailureInjection) String() string {
+	if f.Rate == 0 {
+		return ""
+	}
+	value := "rate=" + strconv.FormatFloat(f.Rate, 'g', -1, 64)
+	if f.Seed != 0 {
+		value += ",seed=" + strconv.FormatInt(f.Seed, 10)
+	}
+	return value
+}
+
+// Set implements pflag.Value
+func (f *FailureInjection) Set(value string) error {
+	rate, seed := 0.0, int64(0)
+	for _, setting := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(setting), "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid failure injection %q, expected key=value settings, e.g. rate=0.05", setting)
+		}
+		var err error
+		switch parts[0] {
+		case "rate":
+			rate, err = strconv.ParseFloat(parts[1], 64)
+			if err == nil && (rate < 0 || rate > 1) {
+				err = fmt.Errorf("must be between 0 and 1")
+			}
+		case "seed":
+			seed, err = strconv.ParseInt(parts[1], 10, 64)
+		default:
+			return fmt.Errorf("unsupported failure injection setting %q, supported settings are: rate, seed", parts[0])
+		}
+		if err != nil {
+			return fmt.Errorf("invalid failure injection %s %q: %w", parts[0], parts[1], err)
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Rate, f.Seed, f.random = rate, seed, nil
+	return nil
+}
+
+// Type implements pflag.Value
+func (f *FailureInjection) Type() string {
+	return "string"
+}
+
+// fail returns an injected error for a write to the destination, nil when the write goes through.
+// The error is an internal server error, which is not retried.
+func (f *FailureInjection) fail(what string) error {
+	if f.Rate <= 0 {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.random == nil {
+		seed := f.Seed
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		f.random = rand.New(rand.NewSource(seed))
+	}
+	if f.random.Float64() >= f.Rate {
+		return nil
+	}
+	return api_errors.NewInternalError(fmt.Errorf("injected failure of %s", what))
+}
+
+// warnInjectedFailures reminds that failures are injected, so that a rehearsal is not mistaken for a real migration
+func warnInjectedFailures(options *MigrationOptions) {
+	if options.InjectFailures.Rate > 0 {
+		options.warn("Failure injection is enabled, %g%% of the writes to the destination fail on purpose", options.InjectFailures.Rate*100)
+	}
+}
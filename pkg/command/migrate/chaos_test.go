@@ -0,0 +1,60 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"testing"
+
+	"gotest.tools/assert"
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+func TestFailureInjectionSet(t *testing.T) {
+	injection := &FailureInjection{}
+	assert.NilError(t, injection.Set("rate=0.05, seed=42"))
+	assert.Equal(t, injection.Rate, 0.05)
+	assert.Equal(t, injection.Seed, int64(42))
+	assert.Equal(t, injection.String(), "rate=0.05,seed=42")
+
+	assert.ErrorContains(t, injection.Set("rate=2"), "between 0 and 1")
+	assert.ErrorContains(t, injection.Set("rate"), "key=value")
+	assert.ErrorContains(t, injection.Set("ratio=0.1"), "unsupported failure injection setting")
+}
+
+func TestPacedInjectedFailures(t *testing.T) {
+	options := NewMigrationOptions()
+	assert.NilError(t, options.InjectFailures.Set("rate=1"))
+	writes := 0
+	err := options.paced(context.Background(), "create service hello", func() error {
+		writes++
+		return nil
+	})
+	assert.Assert(t, api_errors.IsInternalError(err))
+	assert.ErrorContains(t, err, "injected failure of create service hello")
+	assert.Equal(t, writes, 0)
+
+	// The same seed fails the same writes in every rehearsal
+	failures := func() []bool {
+		injection := &FailureInjection{}
+		assert.NilError(t, injection.Set("rate=0.5,seed=7"))
+		failed := []bool{}
+		for i := 0; i < 20; i++ {
+			failed = append(failed, injection.fail("write") != nil)
+		}
+		return failed
+	}
+	assert.DeepEqual(t, failures(), failures())
+}
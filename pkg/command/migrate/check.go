@@ -0,0 +1,315 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	apiv1 "k8s.io/api/core/v1"
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"knative.dev/kn-plugin-migration/pkg/command"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+	serving_v1_client "knative.dev/serving/pkg/client/clientset/versioned/typed/serving/v1"
+)
+
+// checkStatus is the outcome of a pre-flight check
+type checkStatus string
+
+const (
+	checkStatusPass    checkStatus = "pass"
+	checkStatusWarn    checkStatus = "warn"
+	checkStatusFail    checkStatus = "fail"
+	checkStatusSkipped checkStatus = "skipped"
+)
+
+// checkResult is the outcome of one pre-flight check of one cluster
+type checkResult struct {
+	Cluster string      `json:"cluster"`
+	Check   string      `json:"check"`
+	Status  checkStatus `json:"status"`
+	Message string      `json:"message,omitempty"`
+}
+
+// quotaResources are the object count quotas a migration consumes in the destination namespace
+var quotaResources = map[apiv1.ResourceName]string{
+	"count/services.serving.knative.dev":  "services",
+	"count/revisions.serving.knative.dev": "revisions",
+}
+
+type checkCmdFlags struct {
+	Namespace             string
+	KubeConfig            string
+	Context               string
+	SourceInCluster       bool
+	DestinationKubeConfig string
+	DestinationContext    string
+	DestinationNamespace  string
+	Output                string
+}
+
+var checkFlags checkCmdFlags
+
+// NewCheckCommand represents the 'migrate check' command
+func NewCheckCommand() *cobra.Command {
+	checkCmd := &cobra.Command{
+		Use:   "check",
+		Short: "Validate both clusters before a migration",
+		Long: `Validate both clusters before a migration.
+
+The kubeconfigs of both clusters are loaded, both API servers are reached, Knative Serving
+is discovered on both clusters, the source namespace is read and the quotas of the
+destination namespace are checked against the services and revisions to migrate.
+Every check is run and reported in one pass, nothing is changed in either cluster.`,
+		Example: `
+  # Validate both clusters before migrating the default namespace
+  kn migrate check --namespace default --destination-namespace default`,
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := command.NewCommandContext(cmd)
+			defer cancel()
+
+			if err := validateOutputFormat(checkFlags.Output); err != nil {
+				return err
+			}
+			if checkFlags.Namespace == "" {
+				return fmt.Errorf("cannot get source cluster namespace, please use --namespace to set")
+			}
+			if checkFlags.DestinationNamespace == "" {
+				checkFlags.DestinationNamespace = checkFlags.Namespace
+			}
+			kubeconfigS, kubeconfigD, err := getKubeConfigs(clusterConfig{KubeConfig: checkFlags.KubeConfig, Context: checkFlags.Context, InCluster: checkFlags.SourceInCluster}, clusterConfig{KubeConfig: checkFlags.DestinationKubeConfig, Context: checkFlags.DestinationContext})
+			if err != nil {
+				return err
+			}
+
+			results, servicesS, revisionsS := checkSourceCluster(ctx, kubeconfigS, checkFlags.Namespace)
+			results = append(results, checkDestinationCluster(ctx, kubeconfigD, checkFlags.DestinationNamespace, servicesS, revisionsS)...)
+			if err := printChecks(cmd.OutOrStdout(), results, checkFlags.Output); err != nil {
+				return err
+			}
+			if checksFailed(results) {
+				return fmt.Errorf("the clusters failed some checks of the migration")
+			}
+			return nil
+		},
+	}
+
+	checkCmd.Flags().StringVarP(&checkFlags.Namespace, "namespace", "n", "", "The namespace of the source Knative resources")
+	checkCmd.Flags().StringVar(&checkFlags.KubeConfig, "kubeconfig", "", "The kubeconfig of the Knative resources (default is KUBECONFIG from environment variable)")
+	checkCmd.Flags().StringVar(&checkFlags.Context, "context", "", "The context of the kubeconfig of the Knative resources (default is the current context)")
+	checkCmd.Flags().BoolVar(&checkFlags.SourceInCluster, "source-in-cluster", false, "Use the ServiceAccount of the pod the check runs in for the source cluster instead of a kubeconfig")
+	checkCmd.Flags().StringVar(&checkFlags.DestinationKubeConfig, "destination-kubeconfig", "", "The kubeconfig of the destination Knative resources (default is KUBECONFIG_DESTINATION from environment variable, else the source kubeconfig with --destination-context)")
+	checkCmd.Flags().StringVar(&checkFlags.DestinationContext, "destination-context", "", "The context of the kubeconfig of the destination Knative resources (default is the current context)")
+	checkCmd.Flags().StringVar(&checkFlags.DestinationNamespace, "destination-namespace", "", "The namespace of the destination Knative resources (default is the source namespace)")
+	checkCmd.Flags().StringVarP(&checkFlags.Output, "output", "o", "", "Output format of the checks, one of: json, yaml (default is human readable)")
+	return checkCmd
+}
+
+// connectCluster checks the kubeconfig of a cluster and returns its clients, nil when the kubeconfig is invalid
+func connectCluster(name string, cluster clusterConfig) (kubernetes.Interface, serving_v1_client.ServingV1Interface, checkResult) {
+	clientSet, servingClient, err := getClusterClients(cluster)
+	if err != nil {
+		return nil, nil, checkResult{Cluster: name, Check: "kubeconfig", Status: checkStatusFail, Message: err.Error()}
+	}
+	return clientSet, servingClient, checkResult{Cluster: name, Check: "kubeconfig", Status: checkStatusPass, Message: cluster.String()}
+}
+
+// checkSourceCluster checks the source cluster and returns the number of services and revisions to migrate
+func checkSourceCluster(ctx context.Context, cluster clusterConfig, namespace string) ([]checkResult, int, int) {
+	clientSet, servingClient, result := connectCluster("source", cluster)
+	results := []checkResult{result}
+	if clientSet == nil {
+		return append(results, skippedChecks("source", "connectivity", "knative serving", "namespace")...), 0, 0
+	}
+	return checkSource(ctx, clientSet, servingClient, namespace, results)
+}
+
+func checkSource(ctx context.Context, clientSet kubernetes.Interface, servingClient serving_v1_client.ServingV1Interface, namespace string, results []checkResult) ([]checkResult, int, int) {
+	connectivity, knative := checkServing(clientSet, "source")
+	results = append(results, connectivity)
+	if connectivity.Status == checkStatusFail {
+		return append(results, skippedChecks("source", "knative serving", "namespace")...), 0, 0
+	}
+	results = append(results, knative)
+	if knative.Status == checkStatusFail {
+		return append(results, skippedChecks("source", "namespace")...), 0, 0
+	}
+
+	_, err := clientSet.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if err != nil {
+		return append(results, checkResult{Cluster: "source", Check: "namespace", Status: checkStatusFail, Message: err.Error()}), 0, 0
+	}
+	// Large namespaces are counted page by page rather than listed in a single request
+	count := func(list func(opts metav1.ListOptions) (runtime.Object, error)) (int, error) {
+		items := 0
+		err := command.EachListItem(ctx, command.DefaultListPageSize, "", list, func(runtime.Object) error {
+			items++
+			return nil
+		})
+		return items, err
+	}
+	services, err := count(func(opts metav1.ListOptions) (runtime.Object, error) {
+		return servingClient.Services(namespace).List(ctx, opts)
+	})
+	if err != nil {
+		return append(results, checkResult{Cluster: "source", Check: "namespace", Status: checkStatusFail, Message: err.Error()}), 0, 0
+	}
+	revisions, err := count(func(opts metav1.ListOptions) (runtime.Object, error) {
+		return servingClient.Revisions(namespace).List(ctx, opts)
+	})
+	if err != nil {
+		return append(results, checkResult{Cluster: "source", Check: "namespace", Status: checkStatusFail, Message: err.Error()}), 0, 0
+	}
+	result := checkResult{Cluster: "source", Check: "namespace", Status: checkStatusPass, Message: fmt.Sprintf("%s has %d service(s) with %d revision(s)", namespace, services, revisions)}
+	if services == 0 {
+		result.Status = checkStatusWarn
+	}
+	return append(results, result), services, revisions
+}
+
+// checkDestinationCluster checks the destination cluster can receive the services and revisions to migrate
+func checkDestinationCluster(ctx context.Context, cluster clusterConfig, namespace string, services, revisions int) []checkResult {
+	clientSet, _, result := connectCluster("destination", cluster)
+	results := []checkResult{result}
+	if clientSet == nil {
+		return append(results, skippedChecks("destination", "connectivity", "knative serving", "namespace", "quotas")...)
+	}
+	return checkDestination(ctx, clientSet, namespace, services, revisions, results)
+}
+
+func checkDestination(ctx context.Context, clientSet kubernetes.Interface, namespace string, services, revisions int, results []checkResult) []checkResult {
+	connectivity, knative := checkServing(clientSet, "destination")
+	results = append(results, connectivity)
+	if connectivity.Status == checkStatusFail {
+		return append(results, skippedChecks("destination", "knative serving", "namespace", "quotas")...)
+	}
+	results = append(results, knative)
+
+	_, err := clientSet.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	switch {
+	case api_errors.IsNotFound(err):
+		// There is nothing to exceed in a namespace created by the migration
+		return append(results,
+			checkResult{Cluster: "destination", Check: "namespace", Status: checkStatusPass, Message: fmt.Sprintf("%s does not exist and will be created", namespace)},
+			checkResult{Cluster: "destination", Check: "quotas", Status: checkStatusPass, Message: "no quota in a new namespace"})
+	case err != nil:
+		return append(results, checkResult{Cluster: "destination", Check: "namespace", Status: checkStatusFail, Message: err.Error()}, skippedChecks("destination", "quotas")[0])
+	}
+	results = append(results, checkResult{Cluster: "destination", Check: "namespace", Status: checkStatusPass, Message: fmt.Sprintf("%s exists", namespace)})
+	return append(results, checkQuotas(ctx, clientSet, namespace, map[string]int{"services": services, "revisions": revisions}))
+}
+
+// checkServing checks that the API server of a cluster answers and serves Knative services
+func checkServing(clientSet kubernetes.Interface, cluster string) (checkResult, checkResult) {
+	version, err := clientSet.Discovery().ServerVersion()
+	if err != nil {
+		return checkResult{Cluster: cluster, Check: "connectivity", Status: checkStatusFail, Message: err.Error()}, checkResult{}
+	}
+	connectivity := checkResult{Cluster: cluster, Check: "connectivity", Status: checkStatusPass, Message: "Kubernetes " + version.GitVersion}
+
+	cache := &discoveryCache{client: clientSet.Discovery()}
+	served, err := cache.serves(serving_v1_api.SchemeGroupVersion.WithResource("services"))
+	switch {
+	case err != nil:
+		return connectivity, checkResult{Cluster: cluster, Check: "knative serving", Status: checkStatusFail, Message: err.Error()}
+	case !served:
+		return connectivity, checkResult{Cluster: cluster, Check: "knative serving", Status: checkStatusFail, Message: "services.serving.knative.dev is not served, is Knative Serving installed?"}
+	}
+	return connectivity, checkResult{Cluster: cluster, Check: "knative serving", Status: checkStatusPass, Message: "services.serving.knative.dev is served"}
+}
+
+// checkQuotas checks that the object count quotas of the destination namespace leave room for the objects to migrate
+func checkQuotas(ctx context.Context, clientSet kubernetes.Interface, namespace string, needed map[string]int) checkResult {
+	quotas, err := clientSet.CoreV1().ResourceQuotas(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return checkResult{Cluster: "destination", Check: "quotas", Status: checkStatusFail, Message: err.Error()}
+	}
+	exceeded := []string{}
+	for _, quota := range quotas.Items {
+		for resource, kind := range quotaResources {
+			hard, ok := quota.Status.Hard[resource]
+			if !ok {
+				hard, ok = quota.Spec.Hard[resource]
+			}
+			if !ok {
+				continue
+			}
+			used := quota.Status.Used[resource]
+			left := hard.Value() - used.Value()
+			if int64(needed[kind]) > left {
+				exceeded = append(exceeded, fmt.Sprintf("quota %s leaves room for %d %s, %d to migrate", quota.Name, left, kind, needed[kind]))
+			}
+		}
+	}
+	if len(exceeded) > 0 {
+		sort.Strings(exceeded)
+		return checkResult{Cluster: "destination", Check: "quotas", Status: checkStatusFail, Message: fmt.Sprint(exceeded)}
+	}
+	return checkResult{Cluster: "destination", Check: "quotas", Status: checkStatusPass, Message: fmt.Sprintf("%d quota(s) leave room for the services and revisions", len(quotas.Items))}
+}
+
+// skippedChecks returns the checks which cannot be run because of a failed check
+func skippedChecks(cluster string, checks ...string) []checkResult {
+	results := []checkResult{}
+	for _, check := range checks {
+		results = append(results, checkResult{Cluster: cluster, Check: check, Status: checkStatusSkipped})
+	}
+	return results
+}
+
+func checksFailed(results []checkResult) bool {
+	for _, result := range results {
+		if result.Status == checkStatusFail {
+			return true
+		}
+	}
+	return false
+}
+
+// printChecks prints the results of the checks as a table, or in the given structured format
+func printChecks(out io.Writer, results []checkResult, format string) error {
+	if format != "" {
+		return printStructured(out, results, format)
+	}
+	const row = "%-13s%-17s%-9s%s\n"
+	fmt.Fprintln(out, color.GreenString("[Pre-flight checks]"))
+	color.New(color.FgCyan).Fprintf(out, row, "Cluster", "Check", "Status", "Message")
+	for _, result := range results {
+		status := string(result.Status)
+		switch result.Status {
+		case checkStatusFail:
+			status = color.RedString("%-9s", status)
+		case checkStatusWarn:
+			status = color.YellowString("%-9s", status)
+		default:
+			status = fmt.Sprintf("%-9s", status)
+		}
+		fmt.Fprintf(out, "%-13s%-17s%s%s\n", result.Cluster, result.Check, status, result.Message)
+	}
+	if checksFailed(results) {
+		fmt.Fprintln(out, color.RedString("The clusters are not ready for the migration"))
+	} else {
+		fmt.Fprintln(out, color.GreenString("The clusters are ready for the migration"))
+	}
+	return nil
+}
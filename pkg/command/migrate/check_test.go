@@ -0,0 +1,91 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"gotest.tools/assert"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	discovery_fake "k8s.io/client-go/discovery/fake"
+	k8s_fake "k8s.io/client-go/kubernetes/fake"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+	serving_fake "knative.dev/serving/pkg/client/clientset/versioned/fake"
+)
+
+func newCheckedCluster(knative bool, objects ...runtime.Object) *k8s_fake.Clientset {
+	clientSet := k8s_fake.NewSimpleClientset(objects...)
+	if knative {
+		clientSet.Discovery().(*discovery_fake.FakeDiscovery).Resources = newFakeDiscovery().Resources
+	}
+	return clientSet
+}
+
+func checkStatuses(results []checkResult) map[string]checkStatus {
+	statuses := map[string]checkStatus{}
+	for _, result := range results {
+		statuses[result.Cluster+" "+result.Check] = result.Status
+	}
+	return statuses
+}
+
+func TestCheckClusters(t *testing.T) {
+	clientSetS := newCheckedCluster(true, &apiv1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}})
+	servingClientS := serving_fake.NewSimpleClientset(
+		&serving_v1_api.Service{ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "default"}},
+		&serving_v1_api.Revision{ObjectMeta: metav1.ObjectMeta{Name: "hello-00001", Namespace: "default"}},
+		&serving_v1_api.Revision{ObjectMeta: metav1.ObjectMeta{Name: "hello-00002", Namespace: "default"}},
+	)
+	results, services, revisions := checkSource(context.Background(), clientSetS, servingClientS.ServingV1(), "default", nil)
+	assert.Equal(t, services, 1)
+	assert.Equal(t, revisions, 2)
+
+	quota := &apiv1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: "knative", Namespace: "prod"},
+		Status: apiv1.ResourceQuotaStatus{
+			Hard: apiv1.ResourceList{"count/revisions.serving.knative.dev": resource.MustParse("10")},
+			Used: apiv1.ResourceList{"count/revisions.serving.knative.dev": resource.MustParse("9")},
+		},
+	}
+	clientSetD := newCheckedCluster(true, &apiv1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "prod"}}, quota)
+	results = checkDestination(context.Background(), clientSetD, "prod", services, revisions, results)
+	assert.DeepEqual(t, checkStatuses(results), map[string]checkStatus{
+		"source connectivity":         checkStatusPass,
+		"source knative serving":      checkStatusPass,
+		"source namespace":            checkStatusPass,
+		"destination connectivity":    checkStatusPass,
+		"destination knative serving": checkStatusPass,
+		"destination namespace":       checkStatusPass,
+		"destination quotas":          checkStatusFail,
+	})
+	assert.Assert(t, checksFailed(results))
+
+	// Every check is reported in one pass, even when Knative is missing from the destination
+	results = checkDestination(context.Background(), newCheckedCluster(false), "prod", services, revisions, nil)
+	assert.DeepEqual(t, checkStatuses(results), map[string]checkStatus{
+		"destination connectivity":    checkStatusPass,
+		"destination knative serving": checkStatusFail,
+		"destination namespace":       checkStatusPass,
+		"destination quotas":          checkStatusPass,
+	})
+	out := &bytes.Buffer{}
+	assert.NilError(t, printChecks(out, results, ""))
+	assert.Assert(t, bytes.Contains(out.Bytes(), []byte("is Knative Serving installed?")))
+}
@@ -0,0 +1,189 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"sigs.k8s.io/yaml"
+)
+
+// defaultCheckpointFile returns the file recording the progress of a migration, in the cache directory of the plugin
+// next to the discovery cache rather than in the working directory, empty without a home directory
+func defaultCheckpointFile() string {
+	dir := defaultDiscoveryCacheDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "checkpoint.yaml")
+}
+
+// checkpoint records the services completely migrated by a run, and the services it deleted from the source,
+// so that an interrupted run can be resumed without migrating or deleting them again
+type checkpoint struct {
+	mu   sync.Mutex
+	path string
+	// Completed lists the migrated services by source and destination namespace, e.g. default/prod
+	Completed map[string][]string `json:"completed"`
+	// Deleted lists the services deleted from the source by source namespace
+	Deleted map[string][]string `json:"deleted,omitempty"`
+}
+
+// loadCheckpoint reads the checkpoint file to resume from, or starts a new checkpoint
+func loadCheckpoint(path string, resume bool) (*checkpoint, error) {
+	c := &checkpoint{path: path, Completed: map[string][]string{}, Deleted: map[string][]string{}}
+	if !resume {
+		return c, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("cannot resume, there is no checkpoint file %s of a previous run", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = yaml.Unmarshal(data, c)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse the checkpoint file %s: %w", path, err)
+	}
+	if c.Completed == nil {
+		c.Completed = map[string][]string{}
+	}
+	if c.Deleted == nil {
+		c.Deleted = map[string][]string{}
+	}
+	return c, nil
+}
+
+func checkpointKey(namespaceS, namespaceD string) string {
+	return namespaceS + "/" + namespaceD
+}
+
+// done returns true if the service was migrated by a previous run, always false for a nil checkpoint
+func (c *checkpoint) done(namespaceS, namespaceD, service string) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, name := range c.Completed[checkpointKey(namespaceS, namespaceD)] {
+		if name == service {
+			return true
+		}
+	}
+	return false
+}
+
+// complete records a migrated service and persists the checkpoint
+func (c *checkpoint) complete(namespaceS, namespaceD, service string) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := checkpointKey(namespaceS, namespaceD)
+	c.Completed[key] = append(c.Completed[key], service)
+	return c.save()
+}
+
+// deleted returns true if the service was deleted from the source by a previous run, always false for a nil checkpoint
+func (c *checkpoint) deleted(namespaceS, service string) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, name := range c.Deleted[namespaceS] {
+		if name == service {
+			return true
+		}
+	}
+	return false
+}
+
+// deletion records a service deleted from the source and persists the checkpoint
+func (c *checkpoint) deletion(namespaceS, service string) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Deleted[namespaceS] = append(c.Deleted[namespaceS], service)
+	return c.save()
+}
+
+// forget removes services recorded as migrated, e.g. once they were rolled back, and persists the checkpoint
+func (c *checkpoint) forget(namespaceS, namespaceD string, services ...string) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	forgotten := map[string]bool{}
+	for _, name := range services {
+		forgotten[name] = true
+	}
+	key := checkpointKey(namespaceS, namespaceD)
+	kept := []string{}
+	for _, name := range c.Completed[key] {
+		if !forgotten[name] {
+			kept = append(kept, name)
+		}
+	}
+	c.Completed[key] = kept
+	return c.save()
+}
+
+// save writes the checkpoint to a temporary file renamed over the checkpoint file,
+// so that an interruption never leaves a truncated checkpoint
+func (c *checkpoint) save() error {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(c.path), 0700)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(c.path), filepath.Base(c.path)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path)
+}
+
+// remove deletes the checkpoint file once the run completed
+func (c *checkpoint) remove() error {
+	if c == nil {
+		return nil
+	}
+	err := os.Remove(c.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,53 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cache", "checkpoint.yaml")
+
+	_, err = loadCheckpoint(path, true)
+	assert.ErrorContains(t, err, "there is no checkpoint file")
+
+	c, err := loadCheckpoint(path, false)
+	assert.NilError(t, err)
+	assert.NilError(t, c.complete("default", "prod", "hello"))
+	assert.NilError(t, c.complete("default", "prod", "bye"))
+
+	resumed, err := loadCheckpoint(path, true)
+	assert.NilError(t, err)
+	assert.Assert(t, resumed.done("default", "prod", "hello"))
+	assert.Assert(t, resumed.done("default", "prod", "bye"))
+	assert.Assert(t, !resumed.done("default", "staging", "hello"))
+
+	assert.NilError(t, resumed.remove())
+	_, err = os.Stat(path)
+	assert.Assert(t, os.IsNotExist(err))
+
+	var disabled *checkpoint
+	assert.Assert(t, !disabled.done("default", "prod", "hello"))
+	assert.NilError(t, disabled.complete("default", "prod", "hello"))
+}
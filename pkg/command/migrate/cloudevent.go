@@ -0,0 +1,94 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/fatih/color"
+	"k8s.io/apimachinery/pkg/util/uuid"
+)
+
+const (
+	// migrationSucceededEvent and migrationFailedEvent are the types of the CloudEvent sent once a run completed
+	migrationSucceededEvent = "dev.knative.migration.succeeded"
+	migrationFailedEvent    = "dev.knative.migration.failed"
+	// migrationEventSource is the source of the CloudEvents sent by the migration
+	migrationEventSource = "/kn-migration"
+)
+
+// eventClient sends the CloudEvents to their sink
+var eventClient = &http.Client{Timeout: 10 * time.Second}
+
+// sendCompletionEvent posts a CloudEvent summarizing the run to the sink, e.g. the URL of a Broker, so that follow-up
+// workflows like a DNS cutover can be triggered. Its data is the JSON report of the run.
+func sendCompletionEvent(ctx context.Context, sink string, report *MigrationReport) error {
+	eventType := migrationSucceededEvent
+	if !report.Succeeded {
+		eventType = migrationFailedEvent
+	}
+	return sendEvent(ctx, sink, eventType, report.FinishedAt.Time, nil, report)
+}
+
+// sendEvent posts a CloudEvent in the binary content mode of the HTTP binding: its attributes and extensions are
+// ce- headers and its data is the JSON of the data
+func sendEvent(ctx context.Context, sink, eventType string, at time.Time, extensions map[string]string, data interface{}) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, sink, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Ce-Specversion", "1.0")
+	request.Header.Set("Ce-Id", string(uuid.NewUUID()))
+	request.Header.Set("Ce-Type", eventType)
+	request.Header.Set("Ce-Source", migrationEventSource)
+	request.Header.Set("Ce-Time", at.UTC().Format(time.RFC3339Nano))
+	for name, value := range extensions {
+		request.Header.Set("Ce-"+name, value)
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := eventClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("cannot send the %s event to %s: %w", eventType, sink, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("cannot send the %s event to %s: %s", eventType, sink, response.Status)
+	}
+	return nil
+}
+
+// notifyCompletion sends the completion event of a run if a sink is set, a failure to send it only prints a warning
+func notifyCompletion(out io.Writer, sink string, report *MigrationReport) {
+	if sink == "" {
+		return
+	}
+	// The event is sent even when the run was interrupted
+	if err := sendCompletionEvent(context.Background(), sink, report); err != nil {
+		fmt.Fprintln(out, color.YellowString(err.Error()))
+		return
+	}
+	fmt.Fprintln(out, "Sent the completion event to", color.CyanString(sink))
+}
@@ -0,0 +1,61 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestSendCompletionEvent(t *testing.T) {
+	var received *http.Request
+	var data []byte
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		data, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer sink.Close()
+
+	report := newMigrationReport()
+	report.namespace("default", "prod").add("hello", []string{"hello-00001"}, nil, 0, nil)
+	report.finish(nil)
+	assert.NilError(t, sendCompletionEvent(context.Background(), sink.URL, report))
+	assert.Equal(t, received.Header.Get("Ce-Specversion"), "1.0")
+	assert.Equal(t, received.Header.Get("Ce-Type"), migrationSucceededEvent)
+	assert.Equal(t, received.Header.Get("Ce-Source"), migrationEventSource)
+	assert.Assert(t, received.Header.Get("Ce-Id") != "")
+	sent := MigrationReport{}
+	assert.NilError(t, json.Unmarshal(data, &sent))
+	assert.Equal(t, sent.Namespaces[0].Services[0].Name, "hello")
+
+	report = newMigrationReport()
+	report.finish(errors.New("source unreachable"))
+	assert.NilError(t, sendCompletionEvent(context.Background(), sink.URL, report))
+	assert.Equal(t, received.Header.Get("Ce-Type"), migrationFailedEvent)
+
+	rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer rejecting.Close()
+	assert.ErrorContains(t, sendCompletionEvent(context.Background(), rejecting.URL, report), "400 Bad Request")
+}
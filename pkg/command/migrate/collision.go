@@ -0,0 +1,213 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+	"knative.dev/kn-plugin-migration/pkg/command"
+	api_serving "knative.dev/serving/pkg/apis/serving"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+// RevisionCollisionPolicy tells what to do with a source revision whose name is already
+// taken in the destination by a different revision
+type RevisionCollisionPolicy string
+
+const (
+	// RevisionCollisionFail fails the migration of a service with colliding revisions
+	RevisionCollisionFail RevisionCollisionPolicy = "fail"
+	// RevisionCollisionRemap migrates colliding revisions under new names
+	RevisionCollisionRemap RevisionCollisionPolicy = "remap"
+)
+
+// String implements pflag.Value
+func (p *RevisionCollisionPolicy) String() string {
+	return string(*p)
+}
+
+// Set implements pflag.Value
+func (p *RevisionCollisionPolicy) Set(value string) error {
+	policy, err := parseRevisionCollisionPolicy(value)
+	if err != nil {
+		return err
+	}
+	*p = policy
+	return nil
+}
+
+// Type implements pflag.Value
+func (p *RevisionCollisionPolicy) Type() string {
+	return "string"
+}
+
+// remapSuffix is appended to the name of a colliding revision when it is remapped
+const remapSuffix = "-migrated"
+
+func parseRevisionCollisionPolicy(policy string) (RevisionCollisionPolicy, error) {
+	switch RevisionCollisionPolicy(policy) {
+	case RevisionCollisionFail, RevisionCollisionRemap:
+		return RevisionCollisionPolicy(policy), nil
+	default:
+		return "", fmt.Errorf("unsupported revision collision policy %q, supported policies are: fail, remap", policy)
+	}
+}
+
+// revisionCollision is a source revision whose name is taken in the destination by a revision
+// which is not removed by the migration and has a different content
+type revisionCollision struct {
+	Name string
+	// Reason explains how the destination revision differs from the source revision
+	Reason string
+	// Remapped is the name the revision is migrated as with the remap policy
+	Remapped string
+}
+
+func (c revisionCollision) String() string {
+	return fmt.Sprintf("revision %s %s", c.Name, c.Reason)
+}
+
+// revisionCollisions are the collisions and the identical revisions already present in the destination
+// found for the revisions of a service
+type revisionCollisions struct {
+	Collisions []revisionCollision
+	// Existing are the revisions already present with the same content, e.g. from a previous partial run,
+	// which are kept instead of created again
+	Existing map[string]bool
+}
+
+// remapping returns the new name of every remapped revision
+func (c *revisionCollisions) remapping() map[string]string {
+	remapping := map[string]string{}
+	for _, collision := range c.Collisions {
+		if collision.Remapped != "" {
+			remapping[collision.Name] = collision.Remapped
+		}
+	}
+	return remapping
+}
+
+// detectRevisionCollisions compares the revisions of a source service with the destination revisions of the same name,
+// revisions owned by the destination service are ignored when the service is replaced since they are deleted with it,
+// and identical ones are kept when the service is applied over the destination service, including its latest revision
+func detectRevisionCollisions(ctx context.Context, migrationClientD command.MigrationClient, serviceS serving_v1_api.Service, revisionsS *serving_v1_api.RevisionList, replace, apply bool, policy RevisionCollisionPolicy) (*revisionCollisions, error) {
+	result := &revisionCollisions{Existing: map[string]bool{}}
+	taken := map[string]bool{}
+	for _, revisionS := range revisionsS.Items {
+		taken[revisionS.Name] = true
+	}
+
+	for _, revisionS := range revisionsS.Items {
+		revisionD, err := migrationClientD.GetRevision(ctx, revisionS.Name)
+		if api_errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		owner := revisionD.Labels[api_serving.ServiceLabelKey]
+		if replace && owner == serviceS.Name {
+			continue
+		}
+
+		var reason string
+		switch {
+		case owner != serviceS.Name:
+			reason = fmt.Sprintf("already exists in the destination and belongs to service %q", owner)
+		case apply && equality.Semantic.DeepEqual(revisionS.Spec, revisionD.Spec):
+			result.Existing[revisionS.Name] = true
+			continue
+		case revisionS.Name == command.TemplateRevisionName(serviceS):
+			// The latest revision is created by the service itself and cannot be adopted
+			reason = "already exists in the destination and is the latest revision, which is created by the service"
+		case !equality.Semantic.DeepEqual(revisionS.Spec, revisionD.Spec):
+			reason = "already exists in the destination with a different spec"
+		default:
+			result.Existing[revisionS.Name] = true
+			continue
+		}
+
+		collision := revisionCollision{Name: revisionS.Name, Reason: reason}
+		if policy == RevisionCollisionRemap {
+			collision.Remapped, err = freeRevisionName(ctx, migrationClientD, revisionS.Name, taken)
+			if err != nil {
+				return nil, err
+			}
+			taken[collision.Remapped] = true
+		}
+		result.Collisions = append(result.Collisions, collision)
+	}
+	return result, nil
+}
+
+// freeRevisionName returns the first name derived from the revision name which is neither used in the destination
+// nor by another revision of the migration
+func freeRevisionName(ctx context.Context, migrationClientD command.MigrationClient, name string, taken map[string]bool) (string, error) {
+	for i := 1; ; i++ {
+		candidate := name + remapSuffix
+		if i > 1 {
+			candidate = fmt.Sprintf("%s%s-%d", name, remapSuffix, i)
+		}
+		if taken[candidate] {
+			continue
+		}
+		_, err := migrationClientD.GetRevision(ctx, candidate)
+		if api_errors.IsNotFound(err) {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// remapRevisions renames the remapped revisions of a service and rewrites the references
+// of the service to them, its template and latest revision and its traffic targets
+func remapRevisions(serviceS *serving_v1_api.Service, revisionsS *serving_v1_api.RevisionList, remapping map[string]string) {
+	if len(remapping) == 0 {
+		return
+	}
+	if remapped, ok := remapping[serviceS.Spec.Template.Name]; ok {
+		serviceS.Spec.Template.Name = remapped
+	}
+	if remapped, ok := remapping[serviceS.Status.LatestCreatedRevisionName]; ok {
+		serviceS.Status.LatestCreatedRevisionName = remapped
+	}
+	for i := range serviceS.Spec.Traffic {
+		if remapped, ok := remapping[serviceS.Spec.Traffic[i].RevisionName]; ok {
+			serviceS.Spec.Traffic[i].RevisionName = remapped
+		}
+	}
+	for i := range revisionsS.Items {
+		if remapped, ok := remapping[revisionsS.Items[i].Name]; ok {
+			revisionsS.Items[i].Name = remapped
+		}
+	}
+}
+
+// collisionError explains why the revisions of a service cannot be migrated with the fail policy
+func collisionError(serviceName string, collisions []revisionCollision) error {
+	reasons := make([]string, 0, len(collisions))
+	for _, collision := range collisions {
+		reasons = append(reasons, collision.String())
+	}
+	return fmt.Errorf("cannot migrate service %s because of revision name collisions in the destination: %s, use --revision-collision remap to migrate them under new names",
+		serviceName, strings.Join(reasons, "; "))
+}
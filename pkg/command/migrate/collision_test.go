@@ -0,0 +1,71 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"testing"
+
+	"gotest.tools/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+func TestRemapRevisions(t *testing.T) {
+	service := serving_v1_api.Service{ObjectMeta: metav1.ObjectMeta{Name: "hello"}}
+	service.Status.LatestCreatedRevisionName = "hello-00002"
+	service.Spec.Traffic = []serving_v1_api.TrafficTarget{
+		{RevisionName: "hello-00001"},
+		{RevisionName: "hello-00002"},
+	}
+	revisions := &serving_v1_api.RevisionList{Items: []serving_v1_api.Revision{
+		{ObjectMeta: metav1.ObjectMeta{Name: "hello-00001"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "hello-00002"}},
+	}}
+	collisions := &revisionCollisions{Collisions: []revisionCollision{
+		{Name: "hello-00002", Reason: "already exists in the destination and belongs to service \"other\"", Remapped: "hello-00002-migrated"},
+	}}
+
+	remapRevisions(&service, revisions, collisions.remapping())
+
+	assert.Equal(t, service.Status.LatestCreatedRevisionName, "hello-00002-migrated")
+	assert.Equal(t, service.Spec.Traffic[0].RevisionName, "hello-00001")
+	assert.Equal(t, service.Spec.Traffic[1].RevisionName, "hello-00002-migrated")
+	assert.Equal(t, revisions.Items[0].Name, "hello-00001")
+	assert.Equal(t, revisions.Items[1].Name, "hello-00002-migrated")
+}
+
+func TestCollisionError(t *testing.T) {
+	err := collisionError("hello", []revisionCollision{
+		{Name: "hello-00001", Reason: "already exists in the destination with a different spec"},
+	})
+	assert.ErrorContains(t, err, "revision hello-00001 already exists in the destination with a different spec")
+
+	_, err = parseRevisionCollisionPolicy("rename")
+	assert.ErrorContains(t, err, "unsupported revision collision policy")
+}
+
+func TestRemapTemplateRevisionName(t *testing.T) {
+	service := serving_v1_api.Service{ObjectMeta: metav1.ObjectMeta{Name: "hello"}}
+	service.Spec.Template.Name = "hello-blue"
+	service.Status.LatestCreatedRevisionName = "hello-blue"
+	service.Spec.Traffic = []serving_v1_api.TrafficTarget{{RevisionName: "hello-blue"}}
+	revisions := &serving_v1_api.RevisionList{Items: []serving_v1_api.Revision{{ObjectMeta: metav1.ObjectMeta{Name: "hello-blue"}}}}
+
+	remapRevisions(&service, revisions, map[string]string{"hello-blue": "hello-blue-migrated"})
+
+	assert.Equal(t, service.Spec.Template.Name, "hello-blue-migrated")
+	assert.Equal(t, service.Spec.Traffic[0].RevisionName, "hello-blue-migrated")
+	assert.Equal(t, len(unresolvedTrafficRevisions(service, revisions)), 0)
+}
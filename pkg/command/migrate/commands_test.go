@@ -0,0 +1,43 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"bytes"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestCommandErrors(t *testing.T) {
+	for _, test := range []struct {
+		args    []string
+		message string
+	}{
+		{[]string{"import"}, "please use --from or --from-file to set"},
+		{[]string{"simulate"}, "please use --from to set"},
+		{[]string{"diff"}, "please use --namespace to set"},
+		{[]string{"simulate", "--from", "/nonexistent/bundle"}, "/nonexistent/bundle"},
+	} {
+		migrateCmd := NewMigrateCommand()
+		out := &bytes.Buffer{}
+		migrateCmd.SetOut(out)
+		migrateCmd.SetErr(out)
+		migrateCmd.SetArgs(test.args)
+		err := migrateCmd.Execute()
+		assert.ErrorContains(t, err, test.message)
+		assert.Assert(t, bytes.Contains(out.Bytes(), []byte("Error: ")))
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+var (
+	// confirmInput is where the answer to a confirmation prompt is read from
+	confirmInput io.Reader = os.Stdin
+	// confirmOutput is where a confirmation prompt is written to, stdout may only hold a structured report
+	confirmOutput io.Writer = os.Stderr
+	// interactive returns true if a user can answer a confirmation prompt
+	interactive = func() bool {
+		info, err := os.Stdin.Stat()
+		return err == nil && info.Mode()&os.ModeCharDevice != 0
+	}
+)
+
+// destructiveEntries returns the entries of the plans replacing objects in the destination or deleting them from the source
+func destructiveEntries(plans []*migrationPlan) []planEntry {
+	entries := []planEntry{}
+	for _, plan := range plans {
+		for _, entry := range plan.Entries {
+			if entry.Action == planActionReplace || entry.Action == planActionDelete {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	return entries
+}
+
+// confirmDestructiveActions summarizes the objects the plans replace or delete and asks the user to confirm them.
+// Nothing is asked when the plans neither replace nor delete anything, or when the user already confirmed with --yes.
+// Without a terminal to ask on, the migration is refused rather than replacing or deleting objects unattended.
+func confirmDestructiveActions(plans []*migrationPlan, yes bool) error {
+	entries := destructiveEntries(plans)
+	if yes || len(entries) == 0 {
+		return nil
+	}
+	if !interactive() {
+		return fmt.Errorf("refusing to replace or delete %d object(s) without confirmation, use --yes to run non-interactively", len(entries))
+	}
+
+	fmt.Fprintln(confirmOutput, color.YellowString("The migration will replace or delete the following objects:"))
+	for _, entry := range entries {
+		fmt.Fprintf(confirmOutput, "  %-8s %s %s/%s in the %s cluster\n", entry.Action, entry.Kind, entry.Namespace, entry.Name, entry.Cluster)
+	}
+	fmt.Fprint(confirmOutput, "Do you want to continue? [y/N]: ")
+	answer, err := bufio.NewReader(confirmInput).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+	return fmt.Errorf("migration cancelled, nothing was replaced or deleted")
+}
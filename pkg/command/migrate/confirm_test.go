@@ -0,0 +1,67 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func withConfirmation(t *testing.T, isInteractive bool, answer string) *bytes.Buffer {
+	input, output, wasInteractive := confirmInput, confirmOutput, interactive
+	t.Cleanup(func() {
+		confirmInput, confirmOutput, interactive = input, output, wasInteractive
+	})
+	prompt := &bytes.Buffer{}
+	confirmInput = strings.NewReader(answer)
+	confirmOutput = prompt
+	interactive = func() bool { return isInteractive }
+	return prompt
+}
+
+func TestConfirmDestructiveActions(t *testing.T) {
+	plans := []*migrationPlan{{
+		SourceNamespace:      "default",
+		DestinationNamespace: "prod",
+		Entries: []planEntry{
+			{Kind: "Service", Name: "hello", Namespace: "prod", Cluster: "destination", Action: planActionReplace},
+			{Kind: "Service", Name: "world", Namespace: "prod", Cluster: "destination", Action: planActionCreate},
+			{Kind: "Service", Name: "hello", Namespace: "default", Cluster: "source", Action: planActionDelete},
+		},
+	}}
+
+	prompt := withConfirmation(t, true, "y\n")
+	assert.NilError(t, confirmDestructiveActions(plans, false))
+	assert.Assert(t, strings.Contains(prompt.String(), "replace  Service prod/hello in the destination cluster"), prompt.String())
+	assert.Assert(t, strings.Contains(prompt.String(), "delete   Service default/hello in the source cluster"), prompt.String())
+	assert.Assert(t, !strings.Contains(prompt.String(), "world"), prompt.String())
+
+	withConfirmation(t, true, "\n")
+	assert.ErrorContains(t, confirmDestructiveActions(plans, false), "cancelled")
+	withConfirmation(t, true, "")
+	assert.ErrorContains(t, confirmDestructiveActions(plans, false), "cancelled")
+
+	prompt = withConfirmation(t, false, "y\n")
+	assert.ErrorContains(t, confirmDestructiveActions(plans, false), "refusing to replace or delete 2 object(s)")
+	assert.Equal(t, prompt.Len(), 0)
+	assert.NilError(t, confirmDestructiveActions(plans, true))
+
+	// Nothing to confirm when nothing is replaced or deleted
+	withConfirmation(t, false, "")
+	assert.NilError(t, confirmDestructiveActions([]*migrationPlan{{Entries: plans[0].Entries[1:2]}}, false))
+}
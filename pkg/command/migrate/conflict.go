@@ -0,0 +1,101 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"errors"
+	"fmt"
+
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+// ConflictStrategy tells what to do with a source service which already exists in the destination
+type ConflictStrategy string
+
+const (
+	// ConflictSkip keeps the destination service and skips the migration of the source service
+	ConflictSkip ConflictStrategy = "skip"
+	// ConflictOverwrite applies the migrated service over the destination service, as --force does
+	ConflictOverwrite ConflictStrategy = "overwrite"
+	// ConflictMerge merges the migrated service into the destination service, keeping the labels, annotations and
+	// traffic block of the destination which the source service does not set
+	ConflictMerge ConflictStrategy = "merge"
+	// ConflictFail fails the migration of the source service
+	ConflictFail ConflictStrategy = "fail"
+)
+
+// errServiceSkipped is returned by the migration of a service skipped because it exists in the destination
+var errServiceSkipped = errors.New("service already exists in the destination and is skipped")
+
+// String implements pflag.Value
+func (s *ConflictStrategy) String() string {
+	return string(*s)
+}
+
+// Set implements pflag.Value
+func (s *ConflictStrategy) Set(value string) error {
+	strategy, err := parseConflictStrategy(value)
+	if err != nil {
+		return err
+	}
+	*s = strategy
+	return nil
+}
+
+// Type implements pflag.Value
+func (s *ConflictStrategy) Type() string {
+	return "string"
+}
+
+func parseConflictStrategy(strategy string) (ConflictStrategy, error) {
+	switch ConflictStrategy(strategy) {
+	case ConflictSkip, ConflictOverwrite, ConflictMerge, ConflictFail:
+		return ConflictStrategy(strategy), nil
+	default:
+		return "", fmt.Errorf("unsupported conflict strategy %q, supported strategies are: skip, overwrite, merge, fail", strategy)
+	}
+}
+
+// replaces returns true if the strategy changes the services already existing in the destination
+func (s ConflictStrategy) replaces() bool {
+	return s == ConflictOverwrite || s == ConflictMerge
+}
+
+// mergeService returns the migrated service merged into the destination service: the labels and annotations of both
+// are kept, the migrated ones winning, and so are those of their templates, the template spec is the migrated one and
+// the traffic block of the destination is kept when the migrated service has none
+func mergeService(existing, migrated serving_v1_api.Service) serving_v1_api.Service {
+	merged := migrated
+	merged.Labels = mergeStringMaps(existing.Labels, migrated.Labels)
+	merged.Annotations = mergeStringMaps(existing.Annotations, migrated.Annotations)
+	merged.Spec.Template.Labels = mergeStringMaps(existing.Spec.Template.Labels, migrated.Spec.Template.Labels)
+	merged.Spec.Template.Annotations = mergeStringMaps(existing.Spec.Template.Annotations, migrated.Spec.Template.Annotations)
+	if len(migrated.Spec.Traffic) == 0 {
+		merged.Spec.Traffic = existing.Spec.Traffic
+	}
+	return merged
+}
+
+// mergeStringMaps returns the entries of a map overridden by the entries of another
+func mergeStringMaps(values, overrides map[string]string) map[string]string {
+	merged := make(map[string]string, len(values)+len(overrides))
+	for key, value := range values {
+		merged[key] = value
+	}
+	for key, value := range overrides {
+		merged[key] = value
+	}
+	return merged
+}
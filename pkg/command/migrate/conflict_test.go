@@ -0,0 +1,107 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gotest.tools/assert"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+func TestParseConflictStrategy(t *testing.T) {
+	for _, value := range []string{"skip", "overwrite", "merge", "fail"} {
+		strategy, err := parseConflictStrategy(value)
+		assert.NilError(t, err)
+		assert.Equal(t, string(strategy), value)
+	}
+	_, err := parseConflictStrategy("replace")
+	assert.ErrorContains(t, err, "unsupported conflict strategy")
+
+	options := NewMigrationOptions()
+	assert.Equal(t, options.onConflict(), ConflictFail)
+	options.Force = true
+	assert.Equal(t, options.onConflict(), ConflictOverwrite)
+	options.OnConflict = ConflictSkip
+	assert.Equal(t, options.onConflict(), ConflictSkip)
+	assert.Assert(t, !options.recreates())
+}
+
+func TestMergeService(t *testing.T) {
+	percent := func(p int64) *int64 { return &p }
+	existing := serving_v1_api.Service{}
+	existing.Labels = map[string]string{"team": "a", "tier": "web"}
+	existing.Spec.Template.Annotations = map[string]string{"autoscaling.knative.dev/minScale": "1"}
+	existing.Spec.Traffic = []serving_v1_api.TrafficTarget{{RevisionName: "hello-00007", Percent: percent(100)}}
+	migrated := serving_v1_api.Service{}
+	migrated.Labels = map[string]string{"team": "b"}
+	migrated.Spec.Template.Annotations = map[string]string{"autoscaling.knative.dev/maxScale": "3"}
+
+	merged := mergeService(existing, migrated)
+	assert.DeepEqual(t, merged.Labels, map[string]string{"team": "b", "tier": "web"})
+	assert.DeepEqual(t, merged.Spec.Template.Annotations, map[string]string{"autoscaling.knative.dev/minScale": "1", "autoscaling.knative.dev/maxScale": "3"})
+	assert.DeepEqual(t, merged.Spec.Traffic, existing.Spec.Traffic)
+}
+
+func TestOnConflict(t *testing.T) {
+	filter, err := newServiceFilter(nil, "")
+	assert.NilError(t, err)
+
+	// Skipped services are reported as skipped and not migrated, so that --delete keeps them in the source
+	source := simulatedBundle("default", "hello", "bye")
+	clientSetD, migrationClientD := newSimulatedDestination("prod", destinationWithExtraRevision())
+	options := NewMigrationOptions()
+	options.OnConflict = ConflictSkip
+	report := newMigrationReport()
+	migrated, err := migrateNamespace(context.Background(), source, clientSetD, migrationClientD, "prod", filter, options, report.namespace("default", "prod"))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, migrated, []string{"bye"})
+	assert.Equal(t, report.failures(), 0)
+	for _, service := range report.Namespaces[0].Services {
+		if service.Name == "hello" {
+			assert.Equal(t, service.Status, ServiceStatusSkipped)
+		}
+	}
+	serviceD, err := migrationClientD.GetService(context.Background(), "hello")
+	assert.NilError(t, err)
+	assert.Equal(t, serviceD.Annotations[migratedFromAnnotation], "")
+
+	// Merged services keep the labels of the destination
+	source = simulatedBundle("default", "hello")
+	source.services[0].Labels = map[string]string{"team": "a"}
+	seed := destinationWithExtraRevision()
+	seed.services[0].Labels = map[string]string{"tier": "web"}
+	clientSetD, migrationClientD = newSimulatedDestination("prod", seed)
+	options = NewMigrationOptions()
+	options.OnConflict = ConflictMerge
+	options.Adopt = true
+	_, _, err = migrateService(context.Background(), source, clientSetD, migrationClientD, "prod", source.services[0], options)
+	assert.NilError(t, err)
+	serviceD, err = migrationClientD.GetService(context.Background(), "hello")
+	assert.NilError(t, err)
+	assert.Equal(t, serviceD.Labels["team"], "a")
+	assert.Equal(t, serviceD.Labels["tier"], "web")
+	revisions, err := migrationClientD.ListRevisionByService(context.Background(), "hello")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, revisionNames(revisions), []string{"hello-00001", "hello-00002", "hello-00007"})
+
+	// Failing services fail before anything is written to the destination
+	options = NewMigrationOptions()
+	options.OnConflict = ConflictFail
+	_, _, err = migrateService(context.Background(), source, clientSetD, migrationClientD, "prod", source.services[0], options)
+	assert.Assert(t, errors.Is(err, ErrServiceExists))
+}
@@ -0,0 +1,336 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/fatih/color"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+	serving_v1alpha1_api "knative.dev/serving/pkg/apis/serving/v1alpha1"
+	serving_v1beta1_api "knative.dev/serving/pkg/apis/serving/v1beta1"
+	serving_v1_client "knative.dev/serving/pkg/client/clientset/versioned/typed/serving/v1"
+)
+
+// servingVersions are the versions of the Knative Serving API a source cluster may serve its services with,
+// the first one served is read
+var servingVersions = []schema.GroupVersion{
+	serving_v1_api.SchemeGroupVersion,
+	serving_v1beta1_api.SchemeGroupVersion,
+	serving_v1alpha1_api.SchemeGroupVersion,
+}
+
+// servedServingVersion returns the newest version of the Knative Serving API a cluster serves its services with
+func servedServingVersion(cache *discoveryCache) (schema.GroupVersion, error) {
+	for _, version := range servingVersions {
+		served, err := cache.serves(version.WithResource("services"))
+		if err != nil {
+			return schema.GroupVersion{}, err
+		}
+		if served {
+			return version, nil
+		}
+	}
+	return schema.GroupVersion{}, fmt.Errorf("the source cluster does not serve services.serving.knative.dev, is Knative Serving installed?")
+}
+
+// sourceServingClient returns the serving client of a source cluster. When the cluster does not serve v1, its
+// services, configurations and revisions are read with the version it serves and converted to v1, so that
+// older Knative installations can be migrated.
+func sourceServingClient(out io.Writer, cluster clusterConfig, cache *discoveryCache, servingClient serving_v1_client.ServingV1Interface) (serving_v1_client.ServingV1Interface, error) {
+	version, err := servedServingVersion(cache)
+	if err != nil || version == serving_v1_api.SchemeGroupVersion {
+		return servingClient, err
+	}
+	dynamicClient, err := getDynamicClient(cluster)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintln(out, color.YellowString("The source cluster serves Knative services as %s, they are converted to %s", version, serving_v1_api.SchemeGroupVersion))
+	return newConvertingServingClient(servingClient, dynamicClient, version), nil
+}
+
+// convertingServingClient reads the serving objects of an older API version and returns them as v1,
+// the requests it does not convert are sent to the v1 API
+type convertingServingClient struct {
+	serving_v1_client.ServingV1Interface
+	client  dynamic.Interface
+	version schema.GroupVersion
+}
+
+func newConvertingServingClient(servingClient serving_v1_client.ServingV1Interface, client dynamic.Interface, version schema.GroupVersion) serving_v1_client.ServingV1Interface {
+	return &convertingServingClient{ServingV1Interface: servingClient, client: client, version: version}
+}
+
+func (c *convertingServingClient) Services(namespace string) serving_v1_client.ServiceInterface {
+	return &convertingServices{
+		ServiceInterface: c.ServingV1Interface.Services(namespace),
+		resource:         c.client.Resource(c.version.WithResource("services")).Namespace(namespace),
+	}
+}
+
+func (c *convertingServingClient) Configurations(namespace string) serving_v1_client.ConfigurationInterface {
+	return &convertingConfigurations{
+		ConfigurationInterface: c.ServingV1Interface.Configurations(namespace),
+		resource:               c.client.Resource(c.version.WithResource("configurations")).Namespace(namespace),
+	}
+}
+
+func (c *convertingServingClient) Revisions(namespace string) serving_v1_client.RevisionInterface {
+	return &convertingRevisions{
+		RevisionInterface: c.ServingV1Interface.Revisions(namespace),
+		resource:          c.client.Resource(c.version.WithResource("revisions")).Namespace(namespace),
+	}
+}
+
+type convertingServices struct {
+	serving_v1_client.ServiceInterface
+	resource dynamic.ResourceInterface
+}
+
+func (s *convertingServices) Get(ctx context.Context, name string, opts metav1.GetOptions) (*serving_v1_api.Service, error) {
+	object, err := s.resource.Get(ctx, name, opts)
+	if err != nil {
+		return nil, err
+	}
+	service := &serving_v1_api.Service{}
+	return service, convertObject(object, service)
+}
+
+func (s *convertingServices) List(ctx context.Context, opts metav1.ListOptions) (*serving_v1_api.ServiceList, error) {
+	list, err := s.resource.List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	services := &serving_v1_api.ServiceList{ListMeta: metav1.ListMeta{ResourceVersion: list.GetResourceVersion(), Continue: list.GetContinue()}}
+	for i := range list.Items {
+		service := serving_v1_api.Service{}
+		if err := convertObject(&list.Items[i], &service); err != nil {
+			return nil, err
+		}
+		services.Items = append(services.Items, service)
+	}
+	return services, nil
+}
+
+func (s *convertingServices) Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+	return s.resource.Delete(ctx, name, opts)
+}
+
+type convertingConfigurations struct {
+	serving_v1_client.ConfigurationInterface
+	resource dynamic.ResourceInterface
+}
+
+func (c *convertingConfigurations) Get(ctx context.Context, name string, opts metav1.GetOptions) (*serving_v1_api.Configuration, error) {
+	object, err := c.resource.Get(ctx, name, opts)
+	if err != nil {
+		return nil, err
+	}
+	configuration := &serving_v1_api.Configuration{}
+	return configuration, convertObject(object, configuration)
+}
+
+func (c *convertingConfigurations) List(ctx context.Context, opts metav1.ListOptions) (*serving_v1_api.ConfigurationList, error) {
+	list, err := c.resource.List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	configurations := &serving_v1_api.ConfigurationList{ListMeta: metav1.ListMeta{ResourceVersion: list.GetResourceVersion(), Continue: list.GetContinue()}}
+	for i := range list.Items {
+		configuration := serving_v1_api.Configuration{}
+		if err := convertObject(&list.Items[i], &configuration); err != nil {
+			return nil, err
+		}
+		configurations.Items = append(configurations.Items, configuration)
+	}
+	return configurations, nil
+}
+
+type convertingRevisions struct {
+	serving_v1_client.RevisionInterface
+	resource dynamic.ResourceInterface
+}
+
+func (r *convertingRevisions) Get(ctx context.Context, name string, opts metav1.GetOptions) (*serving_v1_api.Revision, error) {
+	object, err := r.resource.Get(ctx, name, opts)
+	if err != nil {
+		return nil, err
+	}
+	revision := &serving_v1_api.Revision{}
+	return revision, convertObject(object, revision)
+}
+
+func (r *convertingRevisions) List(ctx context.Context, opts metav1.ListOptions) (*serving_v1_api.RevisionList, error) {
+	list, err := r.resource.List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	revisions := &serving_v1_api.RevisionList{ListMeta: metav1.ListMeta{ResourceVersion: list.GetResourceVersion(), Continue: list.GetContinue()}}
+	for i := range list.Items {
+		revision := serving_v1_api.Revision{}
+		if err := convertObject(&list.Items[i], &revision); err != nil {
+			return nil, err
+		}
+		revisions.Items = append(revisions.Items, revision)
+	}
+	return revisions, nil
+}
+
+func (r *convertingRevisions) Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+	return r.resource.Delete(ctx, name, opts)
+}
+
+// convertObject converts a serving object of an older API version to its v1 type. The v1beta1 schema is the one of
+// v1, the v1alpha1 shapes which predate it are moved to their v1 place and the fields v1 dropped are ignored.
+func convertObject(object *unstructured.Unstructured, into interface{}) error {
+	content := object.DeepCopy().Object
+	if object.GetAPIVersion() == serving_v1alpha1_api.SchemeGroupVersion.String() {
+		spec, ok := content["spec"].(map[string]interface{})
+		if ok {
+			var err error
+			switch object.GetKind() {
+			case "Service":
+				err = convertV1alpha1ServiceSpec(spec)
+			case "Configuration":
+				convertV1alpha1ConfigurationSpec(spec)
+			case "Revision":
+				convertV1alpha1RevisionSpec(spec)
+			}
+			if err != nil {
+				return fmt.Errorf("cannot convert %s %s to %s: %w", object.GetKind(), object.GetName(), serving_v1_api.SchemeGroupVersion, err)
+			}
+		}
+	}
+	content["apiVersion"] = serving_v1_api.SchemeGroupVersion.String()
+	data, err := json.Marshal(content)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, into)
+}
+
+// convertV1alpha1ServiceSpec replaces the runLatest, pinned and release modes of a v1alpha1 service by the template
+// and the traffic of their configuration, the manual mode has no configuration to migrate
+func convertV1alpha1ServiceSpec(spec map[string]interface{}) error {
+	var configuration map[string]interface{}
+	var traffic []interface{}
+	switch {
+	case spec["runLatest"] != nil:
+		configuration, _, _ = unstructured.NestedMap(spec, "runLatest", "configuration")
+		traffic = []interface{}{map[string]interface{}{"latestRevision": true, "percent": int64(100)}}
+	case spec["pinned"] != nil:
+		configuration, _, _ = unstructured.NestedMap(spec, "pinned", "configuration")
+		revision, _, _ := unstructured.NestedString(spec, "pinned", "revisionName")
+		traffic = []interface{}{map[string]interface{}{"revisionName": revision, "percent": int64(100)}}
+	case spec["release"] != nil:
+		configuration, _, _ = unstructured.NestedMap(spec, "release", "configuration")
+		revisions, _, _ := unstructured.NestedStringSlice(spec, "release", "revisions")
+		rollout, _, _ := unstructured.NestedInt64(spec, "release", "rolloutPercent")
+		traffic = releaseTraffic(revisions, rollout)
+	case spec["manual"] != nil:
+		return fmt.Errorf("services in manual mode have no configuration")
+	default:
+		// The inline v1alpha1 shape is the one of v1, with the deprecated names of its traffic targets
+		convertV1alpha1ConfigurationSpec(spec)
+		targets, _, _ := unstructured.NestedSlice(spec, "traffic")
+		for _, target := range targets {
+			target, ok := target.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if name, ok := target["name"]; ok && target["tag"] == nil {
+				target["tag"] = name
+			}
+			delete(target, "name")
+		}
+		if targets != nil {
+			spec["traffic"] = targets
+		}
+		return nil
+	}
+	if configuration == nil {
+		return fmt.Errorf("no configuration is set")
+	}
+	convertV1alpha1ConfigurationSpec(configuration)
+	for key := range spec {
+		delete(spec, key)
+	}
+	spec["template"] = configuration["template"]
+	spec["traffic"] = traffic
+	return nil
+}
+
+// releaseTraffic returns the traffic of a v1alpha1 service in release mode: the current revision, the candidate
+// revision receiving the rollout percent and the latest revision, tagged as v1alpha1 named them
+func releaseTraffic(revisions []string, rollout int64) []interface{} {
+	target := func(revision, tag string, percent int64) map[string]interface{} {
+		target := map[string]interface{}{"tag": tag, "percent": percent}
+		if revision == "@latest" {
+			target["latestRevision"] = true
+		} else {
+			target["revisionName"] = revision
+		}
+		return target
+	}
+	traffic := []interface{}{}
+	switch len(revisions) {
+	case 0:
+	case 1:
+		traffic = append(traffic, target(revisions[0], "current", 100))
+	default:
+		traffic = append(traffic, target(revisions[0], "current", 100-rollout), target(revisions[1], "candidate", rollout))
+	}
+	return append(traffic, map[string]interface{}{"tag": "latest", "latestRevision": true, "percent": int64(0)})
+}
+
+// convertV1alpha1ConfigurationSpec moves the revisionTemplate of a v1alpha1 configuration to its template
+func convertV1alpha1ConfigurationSpec(spec map[string]interface{}) {
+	if template, ok := spec["revisionTemplate"]; ok {
+		if spec["template"] == nil {
+			spec["template"] = template
+		}
+		delete(spec, "revisionTemplate")
+	}
+	delete(spec, "build")
+	if revisionSpec, ok, _ := unstructured.NestedMap(spec, "template", "spec"); ok {
+		convertV1alpha1RevisionSpec(revisionSpec)
+		_ = unstructured.SetNestedMap(spec, revisionSpec, "template", "spec")
+	}
+}
+
+// convertV1alpha1RevisionSpec moves the single container of a v1alpha1 revision to its containers and
+// its concurrency model to its container concurrency
+func convertV1alpha1RevisionSpec(spec map[string]interface{}) {
+	if container, ok := spec["container"]; ok {
+		if spec["containers"] == nil {
+			spec["containers"] = []interface{}{container}
+		}
+		delete(spec, "container")
+	}
+	if model, ok := spec["concurrencyModel"]; ok {
+		if model == "Single" && spec["containerConcurrency"] == nil {
+			spec["containerConcurrency"] = int64(1)
+		}
+		delete(spec, "concurrencyModel")
+	}
+}
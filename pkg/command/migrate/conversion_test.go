@@ -0,0 +1,125 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"testing"
+
+	"gotest.tools/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamic_fake "k8s.io/client-go/dynamic/fake"
+	"knative.dev/kn-plugin-migration/pkg/command"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+	serving_v1alpha1_api "knative.dev/serving/pkg/apis/serving/v1alpha1"
+	serving_v1beta1_api "knative.dev/serving/pkg/apis/serving/v1beta1"
+	serving_fake "knative.dev/serving/pkg/client/clientset/versioned/fake"
+)
+
+func newServingObject(version schema.GroupVersion, kind, name string, labels map[string]string, spec map[string]interface{}) *unstructured.Unstructured {
+	object := &unstructured.Unstructured{Object: map[string]interface{}{"kind": kind, "spec": spec}}
+	object.SetAPIVersion(version.String())
+	object.SetName(name)
+	object.SetNamespace("default")
+	object.SetLabels(labels)
+	return object
+}
+
+func TestConvertObject(t *testing.T) {
+	container := map[string]interface{}{"image": "gcr.io/hello:v1"}
+	configuration := map[string]interface{}{"revisionTemplate": map[string]interface{}{"spec": map[string]interface{}{"container": container, "concurrencyModel": "Single"}}}
+
+	service := serving_v1_api.Service{}
+	object := newServingObject(serving_v1alpha1_api.SchemeGroupVersion, "Service", "hello", nil, map[string]interface{}{"runLatest": map[string]interface{}{"configuration": configuration}})
+	assert.NilError(t, convertObject(object, &service))
+	assert.Equal(t, service.APIVersion, "serving.knative.dev/v1")
+	assert.Equal(t, service.Spec.Template.Spec.Containers[0].Image, "gcr.io/hello:v1")
+	assert.Equal(t, *service.Spec.Template.Spec.ContainerConcurrency, int64(1))
+	assert.Equal(t, *service.Spec.Traffic[0].LatestRevision, true)
+	assert.Equal(t, *service.Spec.Traffic[0].Percent, int64(100))
+
+	service = serving_v1_api.Service{}
+	release := map[string]interface{}{"revisions": []interface{}{"hello-00001", "@latest"}, "rolloutPercent": int64(20), "configuration": configuration}
+	object = newServingObject(serving_v1alpha1_api.SchemeGroupVersion, "Service", "hello", nil, map[string]interface{}{"release": release})
+	assert.NilError(t, convertObject(object, &service))
+	assert.Equal(t, len(service.Spec.Traffic), 3)
+	assert.Equal(t, service.Spec.Traffic[0].RevisionName, "hello-00001")
+	assert.Equal(t, *service.Spec.Traffic[0].Percent, int64(80))
+	assert.Equal(t, service.Spec.Traffic[1].Tag, "candidate")
+	assert.Equal(t, *service.Spec.Traffic[1].LatestRevision, true)
+	assert.Equal(t, *service.Spec.Traffic[1].Percent, int64(20))
+
+	object = newServingObject(serving_v1alpha1_api.SchemeGroupVersion, "Service", "hello", nil, map[string]interface{}{"manual": map[string]interface{}{}})
+	assert.ErrorContains(t, convertObject(object, &service), "cannot convert Service hello to serving.knative.dev/v1: services in manual mode have no configuration")
+
+	// The v1beta1 schema is the one of v1
+	service = serving_v1_api.Service{}
+	template := map[string]interface{}{"spec": map[string]interface{}{"containers": []interface{}{container}}}
+	traffic := []interface{}{map[string]interface{}{"revisionName": "hello-00001", "tag": "stable", "percent": int64(100)}}
+	object = newServingObject(serving_v1beta1_api.SchemeGroupVersion, "Service", "hello", nil, map[string]interface{}{"template": template, "traffic": traffic})
+	assert.NilError(t, convertObject(object, &service))
+	assert.Equal(t, service.Spec.Template.Spec.Containers[0].Image, "gcr.io/hello:v1")
+	assert.Equal(t, service.Spec.Traffic[0].Tag, "stable")
+}
+
+func TestConvertingServingClient(t *testing.T) {
+	version := serving_v1alpha1_api.SchemeGroupVersion
+	container := map[string]interface{}{"image": "gcr.io/hello:v1"}
+	template := map[string]interface{}{"spec": map[string]interface{}{"container": container}}
+	listKinds := map[schema.GroupVersionResource]string{
+		version.WithResource("services"):       "ServiceList",
+		version.WithResource("configurations"): "ConfigurationList",
+		version.WithResource("revisions"):      "RevisionList",
+	}
+	dynamicClient := dynamic_fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds,
+		newServingObject(version, "Service", "hello", nil, map[string]interface{}{"template": template}),
+		newServingObject(version, "Revision", "hello-00001", map[string]string{"serving.knative.dev/service": "hello"}, map[string]interface{}{"container": container}),
+		newServingObject(version, "Revision", "bye-00001", map[string]string{"serving.knative.dev/service": "bye"}, map[string]interface{}{"container": container}))
+	servingClient := newConvertingServingClient(serving_fake.NewSimpleClientset().ServingV1(), dynamicClient, version)
+	migrationClient := command.NewMigrationClient(servingClient, "default")
+
+	services, err := migrationClient.ListService(context.Background())
+	assert.NilError(t, err)
+	assert.Equal(t, len(services.Items), 1)
+	assert.Equal(t, services.Items[0].Spec.Template.Spec.Containers[0].Image, "gcr.io/hello:v1")
+
+	revisions, err := migrationClient.ListRevisionByService(context.Background(), "hello")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, revisionNames(revisions), []string{"hello-00001"})
+	assert.Equal(t, revisions.Items[0].Spec.Containers[0].Image, "gcr.io/hello:v1")
+
+	assert.NilError(t, migrationClient.DeleteService(context.Background(), "hello"))
+	_, err = migrationClient.GetService(context.Background(), "hello")
+	assert.ErrorContains(t, err, "not found")
+}
+
+func TestServedServingVersion(t *testing.T) {
+	cache := &discoveryCache{client: newFakeDiscovery()}
+	version, err := servedServingVersion(cache)
+	assert.NilError(t, err)
+	assert.Equal(t, version, serving_v1_api.SchemeGroupVersion)
+
+	client := newFakeDiscovery()
+	client.Resources[0].GroupVersion = "serving.knative.dev/v1alpha1"
+	version, err = servedServingVersion(&discoveryCache{client: client})
+	assert.NilError(t, err)
+	assert.Equal(t, version, serving_v1alpha1_api.SchemeGroupVersion)
+
+	client.Resources = nil
+	_, err = servedServingVersion(&discoveryCache{client: client})
+	assert.ErrorContains(t, err, "does not serve services.serving.knative.dev")
+}
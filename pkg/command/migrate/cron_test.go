@@ -0,0 +1,103 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"gotest.tools/assert"
+)
+
+func TestParseCron(t *testing.T) {
+	for _, expression := range []string{"* * * * *", "*/15 2-4 1,15 * 1-5", "0 22 * * 7", "5/10 * * 1-12/2 *"} {
+		_, err := parseCron(expression)
+		assert.NilError(t, err, expression)
+	}
+	for _, expression := range []string{"* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "a * * * *", "5-1 * * * *"} {
+		_, err := parseCron(expression)
+		assert.Assert(t, err != nil, expression)
+	}
+}
+
+func TestCronMatches(t *testing.T) {
+	schedule, err := parseCron("30 22 * * 5")
+	assert.NilError(t, err)
+	// 2021-01-01 is a Friday
+	assert.Assert(t, schedule.matches(time.Date(2021, 1, 1, 22, 30, 0, 0, time.UTC)))
+	assert.Assert(t, !schedule.matches(time.Date(2021, 1, 1, 22, 31, 0, 0, time.UTC)))
+	assert.Assert(t, !schedule.matches(time.Date(2021, 1, 2, 22, 30, 0, 0, time.UTC)))
+
+	schedule, err = parseCron("0 0 13 * 5")
+	assert.NilError(t, err)
+	// Day of month and day of week are OR-ed when both are restricted
+	assert.Assert(t, schedule.matches(time.Date(2021, 1, 13, 0, 0, 0, 0, time.UTC)))
+	assert.Assert(t, schedule.matches(time.Date(2021, 1, 8, 0, 0, 0, 0, time.UTC)))
+	assert.Assert(t, !schedule.matches(time.Date(2021, 1, 9, 0, 0, 0, 0, time.UTC)))
+}
+
+func TestCronNext(t *testing.T) {
+	schedule, err := parseCron("0 2 * * *")
+	assert.NilError(t, err)
+	assert.Equal(t, schedule.next(time.Date(2021, 1, 1, 1, 59, 30, 0, time.UTC)), time.Date(2021, 1, 1, 2, 0, 0, 0, time.UTC))
+	// The minute the schedule fires at is not returned again
+	assert.Equal(t, schedule.next(time.Date(2021, 1, 1, 2, 0, 0, 0, time.UTC)), time.Date(2021, 1, 2, 2, 0, 0, 0, time.UTC))
+
+	schedule, err = parseCron("0 0 30 2 *")
+	assert.NilError(t, err)
+	assert.Assert(t, schedule.next(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)).IsZero())
+}
+
+func TestInMaintenanceWindow(t *testing.T) {
+	windows := []maintenanceWindow{{Schedule: "0 22 * * 5", Duration: "4h", Timezone: "UTC"}}
+
+	open, err := inMaintenanceWindow(windows, time.Date(2021, 1, 2, 1, 59, 0, 0, time.UTC))
+	assert.NilError(t, err)
+	assert.Assert(t, open)
+
+	open, err = inMaintenanceWindow(windows, time.Date(2021, 1, 2, 2, 0, 0, 0, time.UTC))
+	assert.NilError(t, err)
+	assert.Assert(t, !open)
+
+	_, err = inMaintenanceWindow([]maintenanceWindow{{Schedule: "0 22 * * 5", Duration: "4h", Timezone: "Nowhere/Unknown"}}, time.Now())
+	assert.Assert(t, err != nil)
+}
+
+func TestOutsideMaintenanceWindows(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	friday := time.Date(2021, 1, 1, 23, 0, 0, 0, time.UTC)
+
+	// Without maintenance windows the commands may always write
+	outside, err := outsideMaintenanceWindows(friday.Add(time.Hour * 24))
+	assert.NilError(t, err)
+	assert.Assert(t, !outside)
+
+	viper.SetConfigType("yaml")
+	assert.NilError(t, viper.ReadConfig(bytes.NewBufferString(`
+maintenance-windows:
+- schedule: "0 22 * * 5"
+  duration: 4h
+  timezone: UTC
+`)))
+	outside, err = outsideMaintenanceWindows(friday)
+	assert.NilError(t, err)
+	assert.Assert(t, !outside)
+	outside, err = outsideMaintenanceWindows(friday.Add(time.Hour * 24))
+	assert.NilError(t, err)
+	assert.Assert(t, outside)
+}
@@ -0,0 +1,347 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"knative.dev/kn-plugin-migration/pkg/command"
+)
+
+const (
+	// DefaultCutoverInterval is the default time each step of a cutover runs before the next one
+	DefaultCutoverInterval = 5 * time.Minute
+	// hookCutoverStep is the event the probe of a cutover is called for after each step
+	hookCutoverStep = "cutover-step"
+
+	// externalDNSHostnameAnnotation names the record external-dns manages for an object, its weight is set by
+	// externalDNSWeightAnnotation among the objects of the same record told apart by externalDNSSetIdentifierAnnotation
+	externalDNSHostnameAnnotation      = "external-dns.alpha.kubernetes.io/hostname"
+	externalDNSWeightAnnotation        = "external-dns.alpha.kubernetes.io/aws-weight"
+	externalDNSSetIdentifierAnnotation = "external-dns.alpha.kubernetes.io/set-identifier"
+
+	// sourceSetIdentifier and destinationSetIdentifier tell apart the weighted records of the clusters
+	sourceSetIdentifier      = "kn-migration-source"
+	destinationSetIdentifier = "kn-migration-destination"
+)
+
+// trafficSplitter sends a share of the traffic of a DNS record to the destination cluster and the rest to the source
+type trafficSplitter interface {
+	// setWeight sends percent of the traffic to the destination
+	setWeight(ctx context.Context, percent int) error
+	String() string
+}
+
+type cutoverCmdFlags struct {
+	Report                string
+	KubeConfig            string
+	Context               string
+	DestinationKubeConfig string
+	DestinationContext    string
+	VerifyTimeout         time.Duration
+	Provider              string
+	DNSService            string
+	Record                string
+	RecordType            string
+	TTL                   int
+	ZoneID                string
+	AccountID             string
+	PoolID                string
+	SourceTarget          string
+	DestinationTarget     string
+	Step                  int
+	Interval              time.Duration
+	Probe                 string
+	ProbeTimeout          time.Duration
+	MaxErrorRate          float64
+}
+
+var cutoverFlags cutoverCmdFlags
+
+// NewCutoverCommand represents the 'migrate cutover' command
+func NewCutoverCommand() *cobra.Command {
+	cutoverCmd := &cobra.Command{
+		Use:   "cutover",
+		Short: "Shift the traffic of a domain from the source cluster to the destination cluster by steps",
+		Long: `Shift the traffic of a domain from the source cluster to the destination cluster by steps.
+
+The services of the migration report are verified again first, the cutover starts only if all
+of them are Ready in the destination and answer their URL. The weights of the DNS record of the
+domain are then shifted by --step percent every --interval until the destination gets the whole
+traffic. The weights are set by one of the providers:
+
+  external-dns  the aws-weight annotation of a Service of each cluster, e.g. the LoadBalancer of
+                the ingress, which already has the external-dns hostname annotation
+  route53       two weighted records of a Route53 hosted zone, with the AWS_ACCESS_KEY_ID,
+                AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables
+  cloudflare    the weights of the two origins of a Cloudflare load balancer pool, with the
+                CLOUDFLARE_API_TOKEN environment variable
+
+At the end of every step the --probe command, or webhook, prints the error rate of the destination,
+e.g. 0.02 or 2%. An error rate above --max-error-rate, or a failing probe, aborts the cutover and
+sends the whole traffic back to the source.`,
+		Example: `
+  # Shift the traffic of api.example.com to the destination by 10% every 5 minutes with Route53
+  kn migrate cutover --report report.json --provider route53 --zone-id Z0123456789 --record api.example.com \
+    --source-target lb.old.example.com --destination-target lb.new.example.com --probe ./error-rate.sh
+  # Shift the traffic by 25% every 10 minutes with the external-dns annotations of the Kourier LoadBalancers
+  kn migrate cutover --report report.json --provider external-dns --dns-service kourier-system/kourier --step 25 --interval 10m`,
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := command.NewCommandContext(cmd)
+			defer cancel()
+
+			if cutoverFlags.Report == "" {
+				return fmt.Errorf("cannot get the migration report, please use --report to set")
+			}
+			if cutoverFlags.Step < 1 || cutoverFlags.Step > 100 {
+				return fmt.Errorf("the step must be between 1 and 100 percent, got %d", cutoverFlags.Step)
+			}
+			if cutoverFlags.Interval < 0 {
+				return fmt.Errorf("the interval cannot be negative, got %s", cutoverFlags.Interval)
+			}
+			if cutoverFlags.MaxErrorRate < 0 || cutoverFlags.MaxErrorRate > 1 {
+				return fmt.Errorf("the maximum error rate must be between 0 and 1, got %g", cutoverFlags.MaxErrorRate)
+			}
+			probe, err := newMigrationHook(cutoverFlags.Probe, cutoverFlags.ProbeTimeout, cmd.OutOrStdout(), cmd.ErrOrStderr())
+			if err != nil {
+				return err
+			}
+			kubeconfigS, kubeconfigD, err := getKubeConfigs(clusterConfig{KubeConfig: cutoverFlags.KubeConfig, Context: cutoverFlags.Context}, clusterConfig{KubeConfig: cutoverFlags.DestinationKubeConfig, Context: cutoverFlags.DestinationContext})
+			if err != nil {
+				return err
+			}
+			clientSetS, _, err := getClusterClients(kubeconfigS)
+			if err != nil {
+				return err
+			}
+			clientSetD, servingClientD, err := getClusterClients(kubeconfigD)
+			if err != nil {
+				return err
+			}
+			splitter, err := newTrafficSplitter(&cutoverFlags, clientSetS, clientSetD)
+			if err != nil {
+				return err
+			}
+
+			report, err := readReport(cutoverFlags.Report)
+			if err != nil {
+				return err
+			}
+			reverifyReport(ctx, cmd.OutOrStdout(), report, verifyOnlyAll, func(namespace string) command.MigrationClient {
+				return command.NewMigrationClient(servingClientD, namespace)
+			}, cutoverFlags.VerifyTimeout)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if !report.Succeeded {
+				return fmt.Errorf("%d service(s) of the report %s fail verification in the destination, not cutting over", report.failures(), cutoverFlags.Report)
+			}
+
+			fmt.Fprintln(cmd.OutOrStdout(), "\nNow shift the traffic to the destination cluster", color.CyanString(kubeconfigD.String()))
+			err = cutover(ctx, cmd.OutOrStdout(), splitter, probe, cutoverFlags.Step, cutoverFlags.Interval, cutoverFlags.MaxErrorRate)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), color.GreenString("The destination cluster gets the whole traffic through %s", splitter))
+			return nil
+		},
+	}
+
+	cutoverCmd.Flags().StringVar(&cutoverFlags.Report, "report", "", "The JSON or YAML report of the migration written with --output, whose services are verified before the cutover")
+	cutoverCmd.Flags().StringVar(&cutoverFlags.KubeConfig, "kubeconfig", "", "The kubeconfig of the source cluster (default is KUBECONFIG from environment variable)")
+	cutoverCmd.Flags().StringVar(&cutoverFlags.Context, "context", "", "The context of the kubeconfig of the source cluster (default is the current context)")
+	cutoverCmd.Flags().StringVar(&cutoverFlags.DestinationKubeConfig, "destination-kubeconfig", "", "The kubeconfig of the destination cluster (default is KUBECONFIG_DESTINATION from environment variable, else the source kubeconfig with --destination-context)")
+	cutoverCmd.Flags().StringVar(&cutoverFlags.DestinationContext, "destination-context", "", "The context of the kubeconfig of the destination cluster (default is the current context)")
+	cutoverCmd.Flags().DurationVar(&cutoverFlags.VerifyTimeout, "verify-timeout", DefaultVerifyTimeout, "The maximum time for a service of the report to be Ready and answer its URL")
+	cutoverCmd.Flags().StringVar(&cutoverFlags.Provider, "provider", "", "The provider of the weighted DNS record, one of: external-dns, route53, cloudflare")
+	cutoverCmd.Flags().StringVar(&cutoverFlags.DNSService, "dns-service", "", "The namespace/name of the Service of each cluster annotated for external-dns with --provider external-dns, e.g. kourier-system/kourier")
+	cutoverCmd.Flags().StringVar(&cutoverFlags.Record, "record", "", "The name of the DNS record with --provider route53, e.g. api.example.com")
+	cutoverCmd.Flags().StringVar(&cutoverFlags.RecordType, "record-type", "CNAME", "The type of the DNS record with --provider route53, one of: A, AAAA, CNAME")
+	cutoverCmd.Flags().IntVar(&cutoverFlags.TTL, "ttl", 60, "The TTL in seconds of the DNS records with --provider route53, a short TTL makes every step take effect quickly")
+	cutoverCmd.Flags().StringVar(&cutoverFlags.ZoneID, "zone-id", "", "The ID of the hosted zone of the record with --provider route53")
+	cutoverCmd.Flags().StringVar(&cutoverFlags.AccountID, "account-id", "", "The ID of the account of the load balancer pool with --provider cloudflare")
+	cutoverCmd.Flags().StringVar(&cutoverFlags.PoolID, "pool-id", "", "The ID of the load balancer pool with --provider cloudflare")
+	cutoverCmd.Flags().StringVar(&cutoverFlags.SourceTarget, "source-target", "", "The address of the source cluster, the value of its record with --provider route53 or the address of its origin with --provider cloudflare")
+	cutoverCmd.Flags().StringVar(&cutoverFlags.DestinationTarget, "destination-target", "", "The address of the destination cluster, the value of its record with --provider route53 or the address of its origin with --provider cloudflare")
+	cutoverCmd.Flags().IntVar(&cutoverFlags.Step, "step", 10, "The percent of the traffic shifted to the destination at every step")
+	cutoverCmd.Flags().DurationVar(&cutoverFlags.Interval, "interval", DefaultCutoverInterval, "The time every step runs before the probe is asked for the error rate of the destination and the next step starts")
+	cutoverCmd.Flags().StringVar(&cutoverFlags.Probe, "probe", "", "A command, or a webhook URL, printing the error rate of the destination, e.g. 0.02 or 2%, at the end of every step, called with the weight of the step as JSON on stdin, or as body of a POST")
+	cutoverCmd.Flags().DurationVar(&cutoverFlags.ProbeTimeout, "probe-timeout", DefaultHookTimeout, "The maximum time a call of --probe may take")
+	cutoverCmd.Flags().Float64Var(&cutoverFlags.MaxErrorRate, "max-error-rate", 0.01, "The error rate of the destination reported by --probe above which the cutover is aborted and the traffic sent back to the source")
+	return cutoverCmd
+}
+
+// newTrafficSplitter returns the traffic splitter of the --provider of the cutover
+func newTrafficSplitter(flags *cutoverCmdFlags, clientSetS, clientSetD kubernetes.Interface) (trafficSplitter, error) {
+	switch flags.Provider {
+	case "external-dns":
+		parts := strings.SplitN(flags.DNSService, "/", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("--provider external-dns requires --dns-service as namespace/name, got %q", flags.DNSService)
+		}
+		return &externalDNSSplitter{clientSetS: clientSetS, clientSetD: clientSetD, namespace: parts[0], name: parts[1]}, nil
+	case "route53":
+		if flags.ZoneID == "" || flags.Record == "" || flags.SourceTarget == "" || flags.DestinationTarget == "" {
+			return nil, fmt.Errorf("--provider route53 requires --zone-id, --record, --source-target and --destination-target")
+		}
+		return newRoute53Splitter(flags.ZoneID, flags.Record, flags.RecordType, flags.TTL, flags.SourceTarget, flags.DestinationTarget)
+	case "cloudflare":
+		if flags.AccountID == "" || flags.PoolID == "" || flags.SourceTarget == "" || flags.DestinationTarget == "" {
+			return nil, fmt.Errorf("--provider cloudflare requires --account-id, --pool-id, --source-target and --destination-target")
+		}
+		return newCloudflareSplitter(flags.AccountID, flags.PoolID, flags.SourceTarget, flags.DestinationTarget)
+	default:
+		return nil, fmt.Errorf("unsupported --provider %q, supported values are: external-dns, route53, cloudflare", flags.Provider)
+	}
+}
+
+// cutoverWeights returns the percents of the traffic sent to the destination by the steps of a cutover
+func cutoverWeights(step int) []int {
+	weights := []int{}
+	for weight := step; weight < 100; weight += step {
+		weights = append(weights, weight)
+	}
+	return append(weights, 100)
+}
+
+// cutoverStep is the JSON the probe of a cutover receives
+type cutoverStep struct {
+	Weight int    `json:"weight"`
+	Target string `json:"target"`
+}
+
+// cutover shifts the traffic to the destination by steps, each step runs for the interval before the probe is
+// asked for the error rate of the destination. An error rate above maxErrorRate, or a failing probe, sends the
+// whole traffic back to the source and fails with ErrCutoverAborted. An interrupted cutover keeps the weights of
+// its last step. The steps are told to out.
+func cutover(ctx context.Context, out io.Writer, splitter trafficSplitter, probe *migrationHook, step int, interval time.Duration, maxErrorRate float64) error {
+	for _, weight := range cutoverWeights(step) {
+		if err := splitter.setWeight(ctx, weight); err != nil {
+			return fmt.Errorf("cannot send %d%% of the traffic to the destination through %s: %w", weight, splitter, err)
+		}
+		fmt.Fprintln(out, "Sending", color.CyanString("%d%%", weight), "of the traffic to the destination through", splitter)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("the cutover was interrupted with %d%% of the traffic sent to the destination: %w", weight, ctx.Err())
+		case <-time.After(interval):
+		}
+		if probe == nil {
+			continue
+		}
+		rate, err := probeErrorRate(ctx, probe, cutoverStep{Weight: weight, Target: splitter.String()})
+		if err == nil && rate > maxErrorRate {
+			err = fmt.Errorf("the error rate of the destination is %g at %d%% of the traffic, above %g", rate, weight, maxErrorRate)
+		}
+		if err != nil {
+			err = newMigrationError(ErrCutoverAborted, err)
+			if rollbackErr := splitter.setWeight(context.Background(), 0); rollbackErr != nil {
+				return fmt.Errorf("%w, and the traffic cannot be sent back to the source: %v", err, rollbackErr)
+			}
+			fmt.Fprintln(out, color.YellowString("Sent the whole traffic back to the source"))
+			return err
+		}
+		fmt.Fprintln(out, "The error rate of the destination is", rate)
+	}
+	return nil
+}
+
+// probeErrorRate calls the probe of a cutover and reads the error rate it prints
+func probeErrorRate(ctx context.Context, probe *migrationHook, step cutoverStep) (float64, error) {
+	out, err := probe.query(ctx, hookCutoverStep, map[string]string{"weight": strconv.Itoa(step.Weight)}, step)
+	if err != nil {
+		return 0, err
+	}
+	return parseErrorRate(string(out))
+}
+
+// parseErrorRate reads an error rate as a fraction, e.g. 0.02, or as a percent, e.g. 2%, or as the rate of a JSON
+// object, e.g. {"errorRate": 0.02}
+func parseErrorRate(value string) (float64, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "{") {
+		answer := struct {
+			ErrorRate *float64 `json:"errorRate"`
+		}{}
+		if err := json.Unmarshal([]byte(value), &answer); err != nil || answer.ErrorRate == nil {
+			return 0, fmt.Errorf("the probe answered %s, expected an errorRate", value)
+		}
+		value = strconv.FormatFloat(*answer.ErrorRate, 'g', -1, 64)
+	}
+	percent := strings.HasSuffix(value, "%")
+	rate, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(value, "%")), 64)
+	if err != nil {
+		return 0, fmt.Errorf("the probe printed %q, expected an error rate, e.g. 0.02 or 2%%", value)
+	}
+	if percent {
+		rate /= 100
+	}
+	if rate < 0 || rate > 1 {
+		return 0, fmt.Errorf("the probe printed %q, an error rate must be between 0 and 1, or 0%% and 100%%", value)
+	}
+	return rate, nil
+}
+
+// externalDNSSplitter weights the records external-dns manages for a Service of each cluster, usually the
+// LoadBalancer of their ingress, with the annotations of the weighted records of external-dns
+type externalDNSSplitter struct {
+	clientSetS kubernetes.Interface
+	clientSetD kubernetes.Interface
+	namespace  string
+	name       string
+}
+
+func (s *externalDNSSplitter) String() string {
+	return fmt.Sprintf("the external-dns annotations of service %s/%s", s.namespace, s.name)
+}
+
+func (s *externalDNSSplitter) setWeight(ctx context.Context, percent int) error {
+	if err := s.annotate(ctx, s.clientSetS, "source", sourceSetIdentifier, 100-percent); err != nil {
+		return err
+	}
+	return s.annotate(ctx, s.clientSetD, "destination", destinationSetIdentifier, percent)
+}
+
+// annotate sets the weight of the Service of a cluster, and its set identifier unless it has one
+func (s *externalDNSSplitter) annotate(ctx context.Context, clientSet kubernetes.Interface, cluster, setIdentifier string, weight int) error {
+	service, err := clientSet.CoreV1().Services(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("cannot get service %s/%s of the %s cluster: %w", s.namespace, s.name, cluster, err)
+	}
+	if service.Annotations[externalDNSHostnameAnnotation] == "" {
+		return fmt.Errorf("service %s/%s of the %s cluster has no %s annotation", s.namespace, s.name, cluster, externalDNSHostnameAnnotation)
+	}
+	annotations := map[string]string{externalDNSWeightAnnotation: strconv.Itoa(weight)}
+	if service.Annotations[externalDNSSetIdentifierAnnotation] == "" {
+		annotations[externalDNSSetIdentifierAnnotation] = setIdentifier
+	}
+	patch, err := json.Marshal(map[string]interface{}{"metadata": map[string]interface{}{"annotations": annotations}})
+	if err != nil {
+		return err
+	}
+	_, err = clientSet.CoreV1().Services(s.namespace).Patch(ctx, s.name, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
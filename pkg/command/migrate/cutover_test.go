@@ -0,0 +1,184 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8s_fake "k8s.io/client-go/kubernetes/fake"
+)
+
+// recordingSplitter records the weights a cutover sets
+type recordingSplitter struct {
+	weights []int
+}
+
+func (s *recordingSplitter) setWeight(ctx context.Context, percent int) error {
+	s.weights = append(s.weights, percent)
+	return nil
+}
+
+func (s *recordingSplitter) String() string {
+	return "the recording splitter"
+}
+
+func TestCutoverWeights(t *testing.T) {
+	assert.DeepEqual(t, cutoverWeights(10), []int{10, 20, 30, 40, 50, 60, 70, 80, 90, 100})
+	assert.DeepEqual(t, cutoverWeights(30), []int{30, 60, 90, 100})
+	assert.DeepEqual(t, cutoverWeights(100), []int{100})
+}
+
+func TestParseErrorRate(t *testing.T) {
+	for value, expected := range map[string]float64{"0.02\n": 0.02, "2%": 0.02, " 0 ": 0, `{"errorRate": 0.5}`: 0.5} {
+		rate, err := parseErrorRate(value)
+		assert.NilError(t, err)
+		assert.Equal(t, rate, expected)
+	}
+	for _, value := range []string{"", "ok", "1.5", "-1%", `{"rate": 0.5}`} {
+		_, err := parseErrorRate(value)
+		assert.Assert(t, err != nil, value)
+	}
+}
+
+func TestCutover(t *testing.T) {
+	splitter := &recordingSplitter{}
+	assert.NilError(t, cutover(context.Background(), io.Discard, splitter, nil, 25, time.Millisecond, 0.01))
+	assert.DeepEqual(t, splitter.weights, []int{25, 50, 75, 100})
+
+	// The destination fails once it gets 30% of the traffic
+	probe, err := newMigrationHook(`if grep -q '"weight":30'; then echo 5%; else echo 0.001; fi`, time.Minute, io.Discard, io.Discard)
+	assert.NilError(t, err)
+	splitter = &recordingSplitter{}
+	err = cutover(context.Background(), io.Discard, splitter, probe, 10, time.Millisecond, 0.01)
+	assert.Assert(t, errors.Is(err, ErrCutoverAborted))
+	assert.ErrorContains(t, err, "the error rate of the destination is 0.05 at 30% of the traffic")
+	assert.DeepEqual(t, splitter.weights, []int{10, 20, 30, 0})
+
+	// A failing probe aborts the cutover as well
+	probe, err = newMigrationHook("exit 1", time.Minute, io.Discard, io.Discard)
+	assert.NilError(t, err)
+	splitter = &recordingSplitter{}
+	err = cutover(context.Background(), io.Discard, splitter, probe, 50, time.Millisecond, 0.01)
+	assert.Assert(t, errors.Is(err, ErrCutoverAborted))
+	assert.DeepEqual(t, splitter.weights, []int{50, 0})
+}
+
+func TestExternalDNSSplitter(t *testing.T) {
+	lb := func(annotations map[string]string) *apiv1.Service {
+		return &apiv1.Service{ObjectMeta: metav1.ObjectMeta{Name: "kourier", Namespace: "kourier-system", Annotations: annotations}}
+	}
+	clientSetS := k8s_fake.NewSimpleClientset(lb(map[string]string{externalDNSHostnameAnnotation: "api.example.com", externalDNSSetIdentifierAnnotation: "eu-old"}))
+	clientSetD := k8s_fake.NewSimpleClientset(lb(map[string]string{externalDNSHostnameAnnotation: "api.example.com"}))
+	splitter, err := newTrafficSplitter(&cutoverCmdFlags{Provider: "external-dns", DNSService: "kourier-system/kourier"}, clientSetS, clientSetD)
+	assert.NilError(t, err)
+	assert.NilError(t, splitter.setWeight(context.Background(), 40))
+
+	serviceS, err := clientSetS.CoreV1().Services("kourier-system").Get(context.Background(), "kourier", metav1.GetOptions{})
+	assert.NilError(t, err)
+	assert.Equal(t, serviceS.Annotations[externalDNSWeightAnnotation], "60")
+	assert.Equal(t, serviceS.Annotations[externalDNSSetIdentifierAnnotation], "eu-old")
+	serviceD, err := clientSetD.CoreV1().Services("kourier-system").Get(context.Background(), "kourier", metav1.GetOptions{})
+	assert.NilError(t, err)
+	assert.Equal(t, serviceD.Annotations[externalDNSWeightAnnotation], "40")
+	assert.Equal(t, serviceD.Annotations[externalDNSSetIdentifierAnnotation], destinationSetIdentifier)
+
+	unannotated := k8s_fake.NewSimpleClientset(lb(nil))
+	splitter, err = newTrafficSplitter(&cutoverCmdFlags{Provider: "external-dns", DNSService: "kourier-system/kourier"}, unannotated, clientSetD)
+	assert.NilError(t, err)
+	assert.ErrorContains(t, splitter.setWeight(context.Background(), 40), "has no external-dns.alpha.kubernetes.io/hostname annotation")
+
+	_, err = newTrafficSplitter(&cutoverCmdFlags{Provider: "external-dns", DNSService: "kourier"}, clientSetS, clientSetD)
+	assert.ErrorContains(t, err, "namespace/name")
+}
+
+func TestSignAWSRequest(t *testing.T) {
+	// The example of the AWS documentation of the Signature Version 4
+	request, err := http.NewRequest(http.MethodGet, "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", nil)
+	assert.NilError(t, err)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	signAWSRequest(request, nil, awsCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}, "us-east-1", "iam", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+	assert.Equal(t, request.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7")
+}
+
+func TestRoute53Splitter(t *testing.T) {
+	var path, authorization, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		path, authorization, body = r.URL.Path, r.Header.Get("Authorization"), string(data)
+		w.Write([]byte("<ChangeResourceRecordSetsResponse/>"))
+	}))
+	defer server.Close()
+	defer func(endpoint string) { route53Endpoint = endpoint }(route53Endpoint)
+	route53Endpoint = server.URL
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+
+	splitter, err := newTrafficSplitter(&cutoverCmdFlags{Provider: "route53", ZoneID: "/hostedzone/Z0123", Record: "api.example.com", RecordType: "CNAME", TTL: 60, SourceTarget: "lb.old.example.com", DestinationTarget: "lb.new.example.com"}, nil, nil)
+	assert.NilError(t, err)
+	assert.NilError(t, splitter.setWeight(context.Background(), 20))
+	assert.Equal(t, path, "/2013-04-01/hostedzone/Z0123/rrset/")
+	assert.Assert(t, strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/"))
+	assert.Assert(t, strings.Contains(body, "<ResourceRecordSet><Name>api.example.com</Name><Type>CNAME</Type><SetIdentifier>kn-migration-source</SetIdentifier><Weight>80</Weight><TTL>60</TTL><ResourceRecords><ResourceRecord><Value>lb.old.example.com</Value></ResourceRecord></ResourceRecords></ResourceRecordSet>"), body)
+	assert.Assert(t, strings.Contains(body, "<SetIdentifier>kn-migration-destination</SetIdentifier><Weight>20</Weight>"), body)
+
+	_, err = newTrafficSplitter(&cutoverCmdFlags{Provider: "route53", ZoneID: "Z0123", Record: "api.example.com", RecordType: "MX", SourceTarget: "a", DestinationTarget: "b"}, nil, nil)
+	assert.ErrorContains(t, err, "unsupported --record-type")
+}
+
+func TestCloudflareSplitter(t *testing.T) {
+	origins := `[{"name":"old","address":"lb.old.example.com","enabled":true,"weight":1},{"name":"new","address":"lb.new.example.com","enabled":true,"weight":0}]`
+	var patched struct {
+		Origins []map[string]interface{} `json:"origins"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" || r.URL.Path != "/accounts/account/load_balancers/pools/pool" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if r.Method == http.MethodPatch {
+			assert.NilError(t, json.NewDecoder(r.Body).Decode(&patched))
+		}
+		w.Write([]byte(`{"success":true,"errors":[],"result":{"origins":` + origins + `}}`))
+	}))
+	defer server.Close()
+	defer func(endpoint string) { cloudflareEndpoint = endpoint }(cloudflareEndpoint)
+	cloudflareEndpoint = server.URL
+	t.Setenv("CLOUDFLARE_API_TOKEN", "token")
+
+	splitter, err := newTrafficSplitter(&cutoverCmdFlags{Provider: "cloudflare", AccountID: "account", PoolID: "pool", SourceTarget: "lb.old.example.com", DestinationTarget: "lb.new.example.com"}, nil, nil)
+	assert.NilError(t, err)
+	assert.NilError(t, splitter.setWeight(context.Background(), 30))
+	assert.Equal(t, len(patched.Origins), 2)
+	assert.Equal(t, patched.Origins[0]["weight"], 0.7)
+	assert.Equal(t, patched.Origins[0]["name"], "old")
+	assert.Equal(t, patched.Origins[1]["weight"], 0.3)
+
+	splitter, err = newTrafficSplitter(&cutoverCmdFlags{Provider: "cloudflare", AccountID: "account", PoolID: "pool", SourceTarget: "lb.old.example.com", DestinationTarget: "lb.other.example.com"}, nil, nil)
+	assert.NilError(t, err)
+	assert.ErrorContains(t, splitter.setWeight(context.Background(), 30), "has no origin with the address lb.other.example.com")
+}
@@ -0,0 +1,264 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// dashboardRefresh is the interval at which the dashboard page reloads itself
+const dashboardRefresh = 5 * time.Second
+
+// dashboardState is the state of a namespace on the dashboard
+type dashboardState string
+
+const (
+	dashboardStatePending   dashboardState = "pending"
+	dashboardStateMigrating dashboardState = "migrating"
+	dashboardStateDone      dashboardState = "done"
+	dashboardStateFailed    dashboardState = "failed"
+)
+
+// dashboard is the live progress of a run served read-only over HTTP, so that the progress of a large migration
+// can be followed without access to the terminal running it. Its methods do nothing on a nil dashboard.
+type dashboard struct {
+	mu     sync.Mutex
+	status dashboardStatus
+}
+
+// dashboardStatus is the progress of a run, served as JSON by /status
+type dashboardStatus struct {
+	StartedAt  time.Time             `json:"startedAt"`
+	Finished   bool                  `json:"finished"`
+	Error      string                `json:"error,omitempty"`
+	Namespaces []*dashboardNamespace `json:"namespaces"`
+}
+
+// dashboardNamespace is the progress of a namespace pair
+type dashboardNamespace struct {
+	SourceNamespace      string             `json:"sourceNamespace"`
+	DestinationNamespace string             `json:"destinationNamespace"`
+	State                dashboardState     `json:"state"`
+	Services             int                `json:"services"`
+	Migrated             int                `json:"migrated"`
+	Skipped              int                `json:"skipped"`
+	Failed               int                `json:"failed"`
+	Failures             []dashboardFailure `json:"failures,omitempty"`
+	// Drift is the difference between both namespaces once the namespace is done, empty until then
+	Drift string `json:"drift,omitempty"`
+}
+
+// dashboardFailure is the failure of a service, or of a whole namespace without a service name
+type dashboardFailure struct {
+	Service string `json:"service,omitempty"`
+	Error   string `json:"error"`
+}
+
+func newDashboard(namespaces []namespacePair) *dashboard {
+	d := &dashboard{status: dashboardStatus{StartedAt: time.Now(), Namespaces: []*dashboardNamespace{}}}
+	for _, namespace := range namespaces {
+		d.status.Namespaces = append(d.status.Namespaces, &dashboardNamespace{SourceNamespace: namespace.Source, DestinationNamespace: namespace.Destination, State: dashboardStatePending})
+	}
+	return d
+}
+
+// serve serves the dashboard on the address until the process exits
+func (d *dashboard) serve(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("cannot serve the dashboard on %s: %w", addr, err)
+	}
+	go http.Serve(listener, d)
+	return nil
+}
+
+// ServeHTTP serves the dashboard page on / and its status as JSON on /status
+func (d *dashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "the dashboard is read-only", http.StatusMethodNotAllowed)
+		return
+	}
+	d.mu.Lock()
+	data, err := json.Marshal(d.status)
+	d.mu.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	switch r.URL.Path {
+	case "/status":
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	case "/":
+		// The page is rendered from a copy of the status, the run goes on while it is written
+		status := dashboardStatus{}
+		if err := json.Unmarshal(data, &status); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := dashboardPage.Execute(w, struct {
+			dashboardStatus
+			Refresh int
+		}{status, int(dashboardRefresh.Seconds())}); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// namespace returns the progress of a namespace pair, added if the run did not list it upfront
+func (d *dashboard) namespace(namespaceS, namespaceD string) *dashboardNamespace {
+	for _, namespace := range d.status.Namespaces {
+		if namespace.SourceNamespace == namespaceS && namespace.DestinationNamespace == namespaceD {
+			return namespace
+		}
+	}
+	namespace := &dashboardNamespace{SourceNamespace: namespaceS, DestinationNamespace: namespaceD, State: dashboardStatePending}
+	d.status.Namespaces = append(d.status.Namespaces, namespace)
+	return namespace
+}
+
+// start records the number of services of a namespace once their migration starts
+func (d *dashboard) start(namespaceS, namespaceD string, services int) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	namespace := d.namespace(namespaceS, namespaceD)
+	namespace.State = dashboardStateMigrating
+	namespace.Services = services
+}
+
+// skip records a service migrated by a previous run
+func (d *dashboard) skip(namespaceS, namespaceD string) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.namespace(namespaceS, namespaceD).Skipped++
+}
+
+// service records the outcome of a service
+func (d *dashboard) service(namespaceS, namespaceD, name string, err error) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	namespace := d.namespace(namespaceS, namespaceD)
+	if err != nil {
+		namespace.Failed++
+		namespace.Failures = append(namespace.Failures, dashboardFailure{Service: name, Error: err.Error()})
+		return
+	}
+	namespace.Migrated++
+}
+
+// rolledBack records a migrated service rolled back with its application
+func (d *dashboard) rolledBack(namespaceS, namespaceD, name string, err error) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	namespace := d.namespace(namespaceS, namespaceD)
+	namespace.Migrated--
+	namespace.Failed++
+	namespace.Failures = append(namespace.Failures, dashboardFailure{Service: name, Error: err.Error()})
+}
+
+// done records the end of a namespace and the failure which stopped it, if any
+func (d *dashboard) done(namespaceS, namespaceD string, err error) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	namespace := d.namespace(namespaceS, namespaceD)
+	namespace.State = dashboardStateDone
+	if err != nil {
+		namespace.State = dashboardStateFailed
+		namespace.Failures = append(namespace.Failures, dashboardFailure{Error: err.Error()})
+	}
+}
+
+// drift records the difference between both namespaces
+func (d *dashboard) drift(namespaceS, namespaceD, drift string) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.namespace(namespaceS, namespaceD).Drift = drift
+}
+
+// finish records the end of the run and the error that stopped it, if any
+func (d *dashboard) finish(err error) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.status.Finished = true
+	if err != nil {
+		d.status.Error = err.Error()
+	}
+}
+
+// summary describes the difference between two namespaces in a few words
+func (d namespaceDiff) summary() string {
+	if d.empty() {
+		return "in sync"
+	}
+	return fmt.Sprintf("%d only in source, %d only in destination, %d differing", len(d.OnlyInSource), len(d.OnlyInDestination), len(d.Differing))
+}
+
+var dashboardPage = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+{{if not .Finished}}<meta http-equiv="refresh" content="{{.Refresh}}">{{end}}
+<title>kn migration</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.8em; text-align: left; }
+.failed { color: #b00; }
+.done { color: #070; }
+</style>
+</head>
+<body>
+<h1>Knative migration</h1>
+<p>Started at {{.StartedAt.Format "2006-01-02 15:04:05 MST"}}, {{if .Finished}}finished{{if .Error}} with an error: <span class="failed">{{.Error}}</span>{{end}}{{else}}in progress, refreshed every {{.Refresh}}s{{end}}</p>
+<table>
+<tr><th>Namespace</th><th>Destination</th><th>State</th><th>Services</th><th>Migrated</th><th>Skipped</th><th>Failed</th><th>Drift</th></tr>
+{{range .Namespaces}}<tr><td>{{.SourceNamespace}}</td><td>{{.DestinationNamespace}}</td><td class="{{.State}}">{{.State}}</td><td>{{.Services}}</td><td>{{.Migrated}}</td><td>{{.Skipped}}</td><td>{{.Failed}}</td><td>{{.Drift}}</td></tr>
+{{end}}</table>
+{{range .Namespaces}}{{if .Failures}}<h2>Failures in {{.SourceNamespace}}</h2>
+<ul>{{range .Failures}}<li class="failed">{{if .Service}}{{.Service}}: {{end}}{{.Error}}</li>{{end}}</ul>
+{{end}}{{end}}</body>
+</html>
+`))
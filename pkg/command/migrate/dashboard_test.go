@@ -0,0 +1,76 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestDashboard(t *testing.T) {
+	board := newDashboard([]namespacePair{{Source: "default", Destination: "prod"}, {Source: "payments", Destination: "payments"}})
+	board.start("default", "prod", 4)
+	board.skip("default", "prod")
+	board.service("default", "prod", "hello", nil)
+	board.service("default", "prod", "api", nil)
+	board.service("default", "prod", "broken", errors.New("image not found"))
+	board.rolledBack("default", "prod", "api", errors.New("rolled back with application shop: image not found"))
+	board.done("default", "prod", nil)
+	board.drift("default", "prod", "in sync")
+
+	recorder := httptest.NewRecorder()
+	board.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/status", nil))
+	assert.Equal(t, recorder.Code, http.StatusOK)
+	status := dashboardStatus{}
+	assert.NilError(t, json.Unmarshal(recorder.Body.Bytes(), &status))
+	assert.Equal(t, len(status.Namespaces), 2)
+	assert.DeepEqual(t, *status.Namespaces[0], dashboardNamespace{
+		SourceNamespace: "default", DestinationNamespace: "prod", State: dashboardStateDone,
+		Services: 4, Migrated: 1, Skipped: 1, Failed: 2,
+		Failures: []dashboardFailure{
+			{Service: "broken", Error: "image not found"},
+			{Service: "api", Error: "rolled back with application shop: image not found"},
+		},
+		Drift: "in sync",
+	})
+	assert.Equal(t, status.Namespaces[1].State, dashboardStatePending)
+
+	recorder = httptest.NewRecorder()
+	board.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, recorder.Code, http.StatusOK)
+	assert.Assert(t, strings.Contains(recorder.Body.String(), "<td>payments</td>"))
+	assert.Assert(t, strings.Contains(recorder.Body.String(), "broken: image not found"))
+	assert.Assert(t, strings.Contains(recorder.Body.String(), `http-equiv="refresh"`))
+
+	// The dashboard is read-only
+	recorder = httptest.NewRecorder()
+	board.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/status", nil))
+	assert.Equal(t, recorder.Code, http.StatusMethodNotAllowed)
+
+	board.finish(nil)
+	recorder = httptest.NewRecorder()
+	board.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Assert(t, !strings.Contains(recorder.Body.String(), `http-equiv="refresh"`))
+
+	// A run without a dashboard records nothing
+	var none *dashboard
+	none.service("default", "prod", "hello", nil)
+}
@@ -0,0 +1,192 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fatih/color"
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+	"knative.dev/kn-plugin-migration/pkg/command"
+)
+
+// verifyBeforeDeletion verifies the destination copies of the migrated services before their source services are
+// deleted, and returns the names of the verified services and the failure of every other service. A copy is verified
+// once it is Ready, and its URL answers with a success status with options.Verify, and it is still so at the end of
+// the grace period. The error of the context is returned if it is done.
+func verifyBeforeDeletion(ctx context.Context, migrationClientD command.MigrationClient, names []string, options *MigrationOptions, gracePeriod time.Duration) ([]string, map[string]error, error) {
+	// The copies of renamed services are verified under their name in the destination
+	check := func(name string, timeout time.Duration) error {
+		if options.Verify {
+			return verifyService(ctx, options.out(), migrationClientD, options.destinationName(name), timeout)
+		}
+		return waitForServicesReady(ctx, migrationClientD, []string{options.destinationName(name)}, timeout)
+	}
+
+	failures := map[string]error{}
+	verified := []string{}
+	for _, name := range names {
+		if err := check(name, options.VerifyTimeout); err != nil {
+			if ctx.Err() != nil {
+				return nil, nil, ctx.Err()
+			}
+			failures[name] = err
+			continue
+		}
+		verified = append(verified, name)
+	}
+	if gracePeriod <= 0 || len(verified) == 0 {
+		return verified, failures, nil
+	}
+
+	// The copies must keep serving during the whole grace period, a copy which stops being Ready is kept in the source
+	fmt.Fprintln(options.out(), "Waiting", gracePeriod, "for the migrated services to keep serving in the destination before deleting them from the source")
+	deadline := time.Now().Add(gracePeriod)
+	for {
+		for _, name := range verified {
+			if _, failed := failures[name]; failed {
+				continue
+			}
+			service, err := migrationClientD.GetService(ctx, options.destinationName(name))
+			if err == nil && !service.IsReady() {
+				err = errors.New("not Ready")
+			}
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, nil, ctx.Err()
+				}
+				failures[name] = newMigrationError(ErrVerificationFailed, fmt.Errorf("service %s stopped serving during the delete grace period: %w", name, err))
+			}
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			break
+		}
+		if remaining > readinessPollInterval {
+			remaining = readinessPollInterval
+		}
+		if err := sleep(ctx, remaining); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	serving := []string{}
+	for _, name := range verified {
+		if _, failed := failures[name]; failed {
+			continue
+		}
+		// The URL answered before the grace period, it must still answer after it
+		if options.Verify {
+			if err := check(name, probePollInterval); err != nil {
+				if ctx.Err() != nil {
+					return nil, nil, ctx.Err()
+				}
+				failures[name] = err
+				continue
+			}
+		}
+		serving = append(serving, name)
+	}
+	return serving, failures, nil
+}
+
+// keptServicesError reports the services kept in the source because their destination copy failed verification
+func keptServicesError(out io.Writer, failures map[string]error) error {
+	names := make([]string, 0, len(failures))
+	for name := range failures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintln(out, color.YellowString("Kept service %s in the source cluster: %s", name, failures[name].Error()))
+	}
+	return newMigrationError(ErrVerificationFailed, fmt.Errorf("%d service(s) kept in the source cluster because their destination copy failed verification: %s", len(names), strings.Join(names, ", ")))
+}
+
+// deleteVerifiedServices deletes the verified services from the source with the concurrency and the pace of the
+// migration, and records each deletion in the checkpoint so that a resumed run does not delete it again.
+// A service already gone from the source counts as deleted. The first failure stops the deletions unless
+// options.BestEffort is set, in which case every failure is reported once the remaining services are deleted.
+func deleteVerifiedServices(ctx context.Context, migrationClient command.MigrationClient, namespace string, names []string, options *MigrationOptions, progress *checkpoint) error {
+	failures := make([]error, len(names))
+	var aborted int32
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < options.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if atomic.LoadInt32(&aborted) != 0 || ctx.Err() != nil {
+					continue
+				}
+				name := names[i]
+				err := options.sourcePaced(ctx, "delete service "+name, func() error {
+					err := migrationClient.DeleteService(ctx, name)
+					if api_errors.IsNotFound(err) {
+						return nil
+					}
+					return err
+				})
+				if err == nil {
+					fmt.Fprintln(options.out(), "Deleted service", name, "in source cluster")
+					err = progress.deletion(namespace, name)
+				}
+				if err != nil {
+					failures[i] = fmt.Errorf("cannot delete service %s from the source cluster: %w", name, err)
+					if options.BestEffort {
+						fmt.Fprintln(options.out(), color.RedString(failures[i].Error()))
+					} else {
+						atomic.StoreInt32(&aborted, 1)
+					}
+				}
+			}
+		}()
+	}
+	for i := range names {
+		if atomic.LoadInt32(&aborted) != 0 || ctx.Err() != nil {
+			break
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	failed := []string{}
+	for _, err := range failures {
+		if err == nil {
+			continue
+		}
+		if !options.BestEffort {
+			return err
+		}
+		failed = append(failed, err.Error())
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d service(s) could not be deleted from the source cluster:\n%s", len(failed), strings.Join(failed, "\n"))
+	}
+	return nil
+}
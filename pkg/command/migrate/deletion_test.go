@@ -0,0 +1,127 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8s_fake "k8s.io/client-go/kubernetes/fake"
+	k8s_testing "k8s.io/client-go/testing"
+	"knative.dev/kn-plugin-migration/pkg/command"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+	serving_fake "knative.dev/serving/pkg/client/clientset/versioned/fake"
+)
+
+func TestDeleteServicesVerified(t *testing.T) {
+	servingClientS := serving_fake.NewSimpleClientset(
+		&serving_v1_api.Service{ObjectMeta: metav1.ObjectMeta{Name: "ready", Namespace: "default"}},
+		&serving_v1_api.Service{ObjectMeta: metav1.ObjectMeta{Name: "broken", Namespace: "default"}},
+	)
+	servingClientD := serving_fake.NewSimpleClientset(
+		newReadyService("ready", "http://ready.default.example.com", nil),
+		// The destination copy never became Ready
+		&serving_v1_api.Service{ObjectMeta: metav1.ObjectMeta{Name: "broken", Namespace: "default"}},
+	)
+	migrationClientS := command.NewMigrationClient(servingClientS.ServingV1(), "default")
+	migrationClientD := command.NewMigrationClient(servingClientD.ServingV1(), "default")
+	options := NewMigrationOptions()
+	options.VerifyTimeout = time.Millisecond
+
+	dir, err := ioutil.TempDir("", "backup")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	err = deleteServices(context.Background(), k8s_fake.NewSimpleClientset(), migrationClientS, migrationClientD, "default", []string{"ready", "broken"}, true, options, time.Millisecond, dir)
+	assert.Assert(t, errors.Is(err, ErrVerificationFailed))
+	assert.ErrorContains(t, err, "1 service(s) kept in the source cluster because their destination copy failed verification: broken")
+	services, err := migrationClientS.ListService(context.Background())
+	assert.NilError(t, err)
+	assert.Equal(t, len(services.Items), 1)
+	assert.Equal(t, services.Items[0].Name, "broken")
+
+	// Only the deleted service is backed up
+	bundles, err := backupBundles(dir)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, bundles, []string{filepath.Join(dir, "default")})
+	backup, err := readBundle(bundles[0], nil)
+	assert.NilError(t, err)
+	assert.Equal(t, len(backup.services), 1)
+	assert.Equal(t, backup.services[0].Name, "ready")
+}
+
+func TestDeleteVerifiedServicesResumable(t *testing.T) {
+	servingClientS := serving_fake.NewSimpleClientset(
+		&serving_v1_api.Service{ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "default"}},
+		&serving_v1_api.Service{ObjectMeta: metav1.ObjectMeta{Name: "world", Namespace: "default"}},
+		&serving_v1_api.Service{ObjectMeta: metav1.ObjectMeta{Name: "locked", Namespace: "default"}},
+	)
+	// The source API server throttles the first deletion and refuses to delete the locked service
+	throttled := false
+	servingClientS.PrependReactor("delete", "services", func(action k8s_testing.Action) (bool, runtime.Object, error) {
+		name := action.(k8s_testing.DeleteAction).GetName()
+		if !throttled {
+			throttled = true
+			return true, nil, api_errors.NewTooManyRequests("slow down", 0)
+		}
+		if name == "locked" {
+			return true, nil, api_errors.NewForbidden(schema.GroupResource{Resource: "services"}, name, errors.New("denied"))
+		}
+		return false, nil, nil
+	})
+	migrationClientS := command.NewMigrationClient(servingClientS.ServingV1(), "default")
+	dir, err := ioutil.TempDir("", "checkpoint")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	options := NewMigrationOptions()
+	options.Concurrency = 2
+	options.BestEffort = true
+	options.RetryBackoff = time.Millisecond
+	options.CheckpointFile = filepath.Join(dir, "checkpoint.yaml")
+	progress, err := options.progress()
+	assert.NilError(t, err)
+
+	// A service already gone from the source, e.g. deleted by an interrupted run, counts as deleted
+	err = deleteVerifiedServices(context.Background(), migrationClientS, "default", []string{"hello", "world", "locked", "gone"}, options, progress)
+	assert.ErrorContains(t, err, "1 service(s) could not be deleted from the source cluster")
+	assert.ErrorContains(t, err, "locked")
+	services, err := migrationClientS.ListService(context.Background())
+	assert.NilError(t, err)
+	assert.Equal(t, len(services.Items), 1)
+	assert.Equal(t, services.Items[0].Name, "locked")
+
+	// A resumed run knows which services were deleted
+	resumed, err := loadCheckpoint(options.CheckpointFile, true)
+	assert.NilError(t, err)
+	assert.Assert(t, resumed.deleted("default", "hello"))
+	assert.Assert(t, resumed.deleted("default", "world"))
+	assert.Assert(t, resumed.deleted("default", "gone"))
+	assert.Assert(t, !resumed.deleted("default", "locked"))
+
+	// Without --best-effort the first failure stops the deletions
+	options.BestEffort = false
+	err = deleteVerifiedServices(context.Background(), migrationClientS, "default", []string{"locked"}, options, progress)
+	assert.ErrorContains(t, err, "cannot delete service locked from the source cluster")
+}
@@ -0,0 +1,324 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"knative.dev/kn-plugin-migration/pkg/command"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// diffContextLines is the number of unchanged lines shown around the changes of a spec diff
+const diffContextLines = 3
+
+// namespaceDiff is the difference between the services of a source and a destination namespace
+type namespaceDiff struct {
+	SourceNamespace      string        `json:"sourceNamespace"`
+	DestinationNamespace string        `json:"destinationNamespace"`
+	OnlyInSource         []string      `json:"onlyInSource"`
+	OnlyInDestination    []string      `json:"onlyInDestination"`
+	Differing            []serviceDiff `json:"differing"`
+}
+
+// serviceDiff is the unified diff of the sanitized specs of a service present in both namespaces
+type serviceDiff struct {
+	Name string `json:"name"`
+	Diff string `json:"diff"`
+}
+
+func (d namespaceDiff) empty() bool {
+	return len(d.OnlyInSource) == 0 && len(d.OnlyInDestination) == 0 && len(d.Differing) == 0
+}
+
+type diffCmdFlags struct {
+	Namespace             string
+	KubeConfig            string
+	Context               string
+	SourceInCluster       bool
+	DestinationKubeConfig string
+	DestinationContext    string
+	DestinationNamespace  string
+	SourceProfile         string
+	DestinationProfile    string
+	Output                string
+}
+
+var diffFlags diffCmdFlags
+
+// NewDiffCommand represents the 'migrate diff' command
+func NewDiffCommand() *cobra.Command {
+	diffCmd := &cobra.Command{
+		Use:   "diff",
+		Short: "Compare the Knative services of a source and a destination namespace",
+		Long: `Compare the Knative services of a source and a destination namespace.
+
+The services present only in the source, only in the destination, and present in both
+with differing specs are listed, with a unified diff of the specs of the differing services.
+The specs are compared without the revision names, which each cluster generates on its own.
+The command exits with an error when the namespaces differ, nothing is changed in either cluster.`,
+		Example: `
+  # Verify that the default namespace of both clusters did not drift after a migration
+  kn migrate diff --namespace default --destination-namespace default`,
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := command.NewCommandContext(cmd)
+			defer cancel()
+
+			if err := applyProfiles(cmd, diffFlags.SourceProfile, diffFlags.DestinationProfile); err != nil {
+				return err
+			}
+
+			if err := validateOutputFormat(diffFlags.Output); err != nil {
+				return err
+			}
+			if diffFlags.Namespace == "" {
+				return fmt.Errorf("cannot get source cluster namespace, please use --namespace to set")
+			}
+			if diffFlags.DestinationNamespace == "" {
+				diffFlags.DestinationNamespace = diffFlags.Namespace
+			}
+			kubeconfigS, kubeconfigD, err := getKubeConfigs(clusterConfig{KubeConfig: diffFlags.KubeConfig, Context: diffFlags.Context, InCluster: diffFlags.SourceInCluster}, clusterConfig{KubeConfig: diffFlags.DestinationKubeConfig, Context: diffFlags.DestinationContext})
+			if err != nil {
+				return err
+			}
+
+			_, migrationClientS, err := getClients(kubeconfigS, diffFlags.Namespace)
+			if err != nil {
+				return err
+			}
+			_, migrationClientD, err := getClients(kubeconfigD, diffFlags.DestinationNamespace)
+			if err != nil {
+				return err
+			}
+
+			diff, err := diffServices(ctx, migrationClientS, migrationClientD, diffFlags.Namespace, diffFlags.DestinationNamespace)
+			if err != nil {
+				return err
+			}
+			if diffFlags.Output != "" {
+				err = printStructured(cmd.OutOrStdout(), diff, diffFlags.Output)
+			} else {
+				printDiff(cmd.OutOrStdout(), diff)
+			}
+			if err != nil {
+				return err
+			}
+			if !diff.empty() {
+				return fmt.Errorf("the services of namespace %s differ between the source and the destination", diffFlags.DestinationNamespace)
+			}
+			return nil
+		},
+	}
+
+	diffCmd.Flags().StringVarP(&diffFlags.Namespace, "namespace", "n", "", "The namespace of the source Knative resources")
+	diffCmd.Flags().StringVar(&diffFlags.KubeConfig, "kubeconfig", "", "The kubeconfig of the Knative resources (default is KUBECONFIG from environment variable)")
+	diffCmd.Flags().StringVar(&diffFlags.Context, "context", "", "The context of the kubeconfig of the Knative resources (default is the current context)")
+	diffCmd.Flags().BoolVar(&diffFlags.SourceInCluster, "source-in-cluster", false, "Use the ServiceAccount of the pod the diff runs in for the source cluster instead of a kubeconfig")
+	diffCmd.Flags().StringVar(&diffFlags.DestinationKubeConfig, "destination-kubeconfig", "", "The kubeconfig of the destination Knative resources (default is KUBECONFIG_DESTINATION from environment variable, else the source kubeconfig with --destination-context)")
+	diffCmd.Flags().StringVar(&diffFlags.DestinationContext, "destination-context", "", "The context of the kubeconfig of the destination Knative resources (default is the current context)")
+	diffCmd.Flags().StringVar(&diffFlags.DestinationNamespace, "destination-namespace", "", "The namespace of the destination Knative resources (default is the source namespace)")
+	diffCmd.Flags().StringVar(&diffFlags.SourceProfile, "source-profile", "", "The profile of the config file giving the kubeconfig, context and namespace of the source Knative resources")
+	diffCmd.Flags().StringVar(&diffFlags.DestinationProfile, "destination-profile", "", "The profile of the config file giving the kubeconfig, context and namespace of the destination Knative resources")
+	diffCmd.Flags().StringVarP(&diffFlags.Output, "output", "o", "", "Output format of the diff, one of: json, yaml (default is human readable)")
+	return diffCmd
+}
+
+// diffServices compares the services of the source and destination namespaces
+func diffServices(ctx context.Context, migrationClientS, migrationClientD command.MigrationClient, namespaceS, namespaceD string) (namespaceDiff, error) {
+	diff := namespaceDiff{SourceNamespace: namespaceS, DestinationNamespace: namespaceD, OnlyInSource: []string{}, OnlyInDestination: []string{}, Differing: []serviceDiff{}}
+	servicesS, err := migrationClientS.ListService(ctx)
+	if err != nil {
+		return diff, sourceError(err)
+	}
+	servicesD, err := migrationClientD.ListService(ctx)
+	if err != nil {
+		return diff, destinationError(err)
+	}
+
+	byNameD := map[string]serving_v1_api.Service{}
+	for _, service := range servicesD.Items {
+		byNameD[service.Name] = service
+	}
+	for _, serviceS := range servicesS.Items {
+		serviceD, ok := byNameD[serviceS.Name]
+		if !ok {
+			diff.OnlyInSource = append(diff.OnlyInSource, serviceS.Name)
+			continue
+		}
+		delete(byNameD, serviceS.Name)
+		specS, err := sanitizedServiceSpec(serviceS)
+		if err != nil {
+			return diff, err
+		}
+		specD, err := sanitizedServiceSpec(serviceD)
+		if err != nil {
+			return diff, err
+		}
+		if text := unifiedDiff(namespaceS+"/"+serviceS.Name, namespaceD+"/"+serviceD.Name, specS, specD); text != "" {
+			diff.Differing = append(diff.Differing, serviceDiff{Name: serviceS.Name, Diff: text})
+		}
+	}
+	for name := range byNameD {
+		diff.OnlyInDestination = append(diff.OnlyInDestination, name)
+	}
+	sort.Strings(diff.OnlyInSource)
+	sort.Strings(diff.OnlyInDestination)
+	sort.Slice(diff.Differing, func(i, j int) bool { return diff.Differing[i].Name < diff.Differing[j].Name })
+	return diff, nil
+}
+
+// sanitizedServiceSpec returns the lines of the YAML spec of a service without the revision name,
+// which is generated independently by each cluster
+func sanitizedServiceSpec(service serving_v1_api.Service) ([]string, error) {
+	spec := service.Spec.DeepCopy()
+	spec.Template.ObjectMeta.Name = ""
+	data, err := yaml.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"), nil
+}
+
+// diffLine is a line of a diff, kind is ' ' for an unchanged line, '-' for a removed line and '+' for an added line.
+// from and to are the numbers of lines of both sides before the line.
+type diffLine struct {
+	kind     byte
+	text     string
+	from, to int
+}
+
+// unifiedDiff returns the unified diff of two texts given as lines, empty when they are equal
+func unifiedDiff(fromName, toName string, from, to []string) string {
+	// common[i][j] is the length of the longest common subsequence of from[i:] and to[j:]
+	common := make([][]int, len(from)+1)
+	for i := range common {
+		common[i] = make([]int, len(to)+1)
+	}
+	for i := len(from) - 1; i >= 0; i-- {
+		for j := len(to) - 1; j >= 0; j-- {
+			if from[i] == to[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else if common[i+1][j] >= common[i][j+1] {
+				common[i][j] = common[i+1][j]
+			} else {
+				common[i][j] = common[i][j+1]
+			}
+		}
+	}
+
+	lines := []diffLine{}
+	changes := []int{}
+	i, j := 0, 0
+	for i < len(from) || j < len(to) {
+		switch {
+		case i < len(from) && j < len(to) && from[i] == to[j]:
+			lines = append(lines, diffLine{' ', from[i], i, j})
+			i++
+			j++
+		case j == len(to) || (i < len(from) && common[i+1][j] >= common[i][j+1]):
+			changes = append(changes, len(lines))
+			lines = append(lines, diffLine{'-', from[i], i, j})
+			i++
+		default:
+			changes = append(changes, len(lines))
+			lines = append(lines, diffLine{'+', to[j], i, j})
+			j++
+		}
+	}
+	if len(changes) == 0 {
+		return ""
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+	for c := 0; c < len(changes); {
+		start := changes[c] - diffContextLines
+		if start < 0 {
+			start = 0
+		}
+		end := changes[c] + diffContextLines + 1
+		// Changes closer than twice the context share a hunk
+		for c++; c < len(changes) && changes[c]-diffContextLines <= end; c++ {
+			end = changes[c] + diffContextLines + 1
+		}
+		if end > len(lines) {
+			end = len(lines)
+		}
+		writeHunk(&out, lines[start:end])
+	}
+	return out.String()
+}
+
+// writeHunk writes a hunk of a unified diff with its header
+func writeHunk(out *strings.Builder, hunk []diffLine) {
+	fromCount, toCount := 0, 0
+	for _, line := range hunk {
+		if line.kind != '+' {
+			fromCount++
+		}
+		if line.kind != '-' {
+			toCount++
+		}
+	}
+	// An empty side starts at the line before the hunk
+	fromStart, toStart := hunk[0].from, hunk[0].to
+	if fromCount > 0 {
+		fromStart++
+	}
+	if toCount > 0 {
+		toStart++
+	}
+	fmt.Fprintf(out, "@@ -%d,%d +%d,%d @@\n", fromStart, fromCount, toStart, toCount)
+	for _, line := range hunk {
+		fmt.Fprintf(out, "%c%s\n", line.kind, line.text)
+	}
+}
+
+// printDiff prints the difference between two namespaces, with colored spec diffs
+func printDiff(out io.Writer, diff namespaceDiff) {
+	if diff.empty() {
+		fmt.Fprintln(out, color.GreenString("The services of namespace %s and destination namespace %s are identical", diff.SourceNamespace, diff.DestinationNamespace))
+		return
+	}
+	for _, name := range diff.OnlyInSource {
+		fmt.Fprintf(out, "Only in source %s: %s\n", diff.SourceNamespace, name)
+	}
+	for _, name := range diff.OnlyInDestination {
+		fmt.Fprintf(out, "Only in destination %s: %s\n", diff.DestinationNamespace, name)
+	}
+	for _, service := range diff.Differing {
+		fmt.Fprintf(out, "Differs: %s\n", service.Name)
+		for _, line := range strings.Split(strings.TrimSuffix(service.Diff, "\n"), "\n") {
+			switch {
+			case strings.HasPrefix(line, "@@"):
+				fmt.Fprintln(out, color.CyanString(line))
+			case strings.HasPrefix(line, "-"):
+				fmt.Fprintln(out, color.RedString(line))
+			case strings.HasPrefix(line, "+"):
+				fmt.Fprintln(out, color.GreenString(line))
+			default:
+				fmt.Fprintln(out, line)
+			}
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/kn-plugin-migration/pkg/command"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+	serving_fake "knative.dev/serving/pkg/client/clientset/versioned/fake"
+)
+
+func newDiffedService(name, namespace, revision, image string) *serving_v1_api.Service {
+	service := &serving_v1_api.Service{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+	service.Spec.Template.Name = revision
+	service.Spec.Template.Spec.Containers = []apiv1.Container{{Image: image}}
+	return service
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	assert.Equal(t, unifiedDiff("a", "b", []string{"x", "y"}, []string{"x", "y"}), "")
+
+	from := []string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11", "12"}
+	to := []string{"1", "2", "three", "4", "5", "6", "7", "8", "9", "10", "11", "12", "13"}
+	assert.Equal(t, unifiedDiff("a", "b", from, to), `--- a
++++ b
+@@ -1,6 +1,6 @@
+ 1
+ 2
+-3
++three
+ 4
+ 5
+ 6
+@@ -10,3 +10,4 @@
+ 10
+ 11
+ 12
++13
+`)
+}
+
+func TestDiffServices(t *testing.T) {
+	servingClientS := serving_fake.NewSimpleClientset(
+		newDiffedService("same", "default", "same-00001", "example.com/same"),
+		newDiffedService("changed", "default", "changed-00001", "example.com/changed:v1"),
+		newDiffedService("removed", "default", "", "example.com/removed"),
+	)
+	servingClientD := serving_fake.NewSimpleClientset(
+		// The revision names generated by each cluster are not compared
+		newDiffedService("same", "prod", "same-abcde", "example.com/same"),
+		newDiffedService("changed", "prod", "changed-00001", "example.com/changed:v2"),
+		newDiffedService("added", "prod", "", "example.com/added"),
+	)
+	diff, err := diffServices(context.Background(), command.NewMigrationClient(servingClientS.ServingV1(), "default"), command.NewMigrationClient(servingClientD.ServingV1(), "prod"), "default", "prod")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, diff.OnlyInSource, []string{"removed"})
+	assert.DeepEqual(t, diff.OnlyInDestination, []string{"added"})
+	assert.Equal(t, len(diff.Differing), 1)
+	assert.Equal(t, diff.Differing[0].Name, "changed")
+	assert.Assert(t, strings.Contains(diff.Differing[0].Diff, "--- default/changed\n+++ prod/changed\n"))
+	assert.Assert(t, strings.Contains(diff.Differing[0].Diff, "\n-    - image: example.com/changed:v1\n+    - image: example.com/changed:v2\n"))
+
+	out := &bytes.Buffer{}
+	printDiff(out, diff)
+	assert.Assert(t, strings.Contains(out.String(), "Only in source default: removed"))
+	assert.Assert(t, strings.Contains(out.String(), "Only in destination prod: added"))
+}
@@ -0,0 +1,204 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/golang/protobuf/proto"
+	openapi_v2 "github.com/google/gnostic/openapiv2"
+	"github.com/mitchellh/go-homedir"
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+	serving_v1beta1_api "knative.dev/serving/pkg/apis/serving/v1beta1"
+)
+
+// DefaultDiscoveryCacheTTL is the default time the discovery results of a cluster are reused before being refreshed
+const DefaultDiscoveryCacheTTL = 10 * time.Minute
+
+// unsafeHostCharacters are replaced in the name of the cache directory of a cluster
+var unsafeHostCharacters = regexp.MustCompile(`[^a-zA-Z0-9.\-]`)
+
+// defaultDiscoveryCacheDir returns the directory under which the discovery results of every cluster are cached
+func defaultDiscoveryCacheDir() string {
+	home, err := homedir.Dir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".kube", "cache", "kn-migration")
+}
+
+// discoveryCache reuses the API discovery results and the OpenAPI schema of a cluster across runs, slow control
+// planes otherwise spend a long time discovering their APIs at the start of every plan or migration.
+// Results older than the TTL are fetched again, nothing is cached when the directory is empty or the TTL is zero.
+type discoveryCache struct {
+	client discovery.DiscoveryInterface
+	dir    string
+	ttl    time.Duration
+}
+
+// newDiscoveryCache returns the discovery cache of a cluster, its results are stored in a directory per API server
+func newDiscoveryCache(cluster clusterConfig, dir string, ttl time.Duration) (*discoveryCache, error) {
+	cfg, err := cluster.restConfig()
+	if err != nil {
+		return nil, err
+	}
+	client, err := discovery.NewDiscoveryClientForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if dir != "" {
+		dir = filepath.Join(dir, unsafeHostCharacters.ReplaceAllString(cfg.Host, "_"))
+	}
+	return &discoveryCache{client: client, dir: dir, ttl: ttl}, nil
+}
+
+// resources returns the resources served for a group version, an empty list if the group version is not served
+func (c *discoveryCache) resources(groupVersion schema.GroupVersion) (*metav1.APIResourceList, error) {
+	path := c.path(groupVersion.Group, groupVersion.Version, "serverresources.json")
+	if data, ok := c.read(path); ok {
+		list := &metav1.APIResourceList{}
+		if err := json.Unmarshal(data, list); err == nil {
+			return list, nil
+		}
+	}
+
+	list, err := c.client.ServerResourcesForGroupVersion(groupVersion.String())
+	if api_errors.IsNotFound(err) {
+		list, err = &metav1.APIResourceList{GroupVersion: groupVersion.String()}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		return nil, err
+	}
+	c.write(path, data)
+	return list, nil
+}
+
+// serves returns true if the cluster serves the resource
+func (c *discoveryCache) serves(resource schema.GroupVersionResource) (bool, error) {
+	list, err := c.resources(resource.GroupVersion())
+	if err != nil {
+		return false, fmt.Errorf("cannot discover the resources of %s: %w", resource.GroupVersion(), err)
+	}
+	for _, served := range list.APIResources {
+		if served.Name == resource.Resource {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// openAPISchema returns the OpenAPI v2 schema of the cluster
+func (c *discoveryCache) openAPISchema() (*openapi_v2.Document, error) {
+	path := c.path("openapi", "v2.pb")
+	if data, ok := c.read(path); ok {
+		document := &openapi_v2.Document{}
+		if err := proto.Unmarshal(data, document); err == nil {
+			return document, nil
+		}
+	}
+
+	document, err := c.client.OpenAPISchema()
+	if err != nil {
+		return nil, err
+	}
+	data, err := proto.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+	c.write(path, data)
+	return document, nil
+}
+
+func (c *discoveryCache) path(elements ...string) string {
+	if c.dir == "" || c.ttl <= 0 {
+		return ""
+	}
+	// The core group has no name
+	if elements[0] == "" {
+		elements[0] = "core"
+	}
+	return filepath.Join(append([]string{c.dir}, elements...)...)
+}
+
+// read returns the content of a cached file which is not expired
+func (c *discoveryCache) read(path string) ([]byte, bool) {
+	if path == "" {
+		return nil, false
+	}
+	info, err := os.Stat(path)
+	if err != nil || time.Since(info.ModTime()) > c.ttl {
+		return nil, false
+	}
+	data, err := ioutil.ReadFile(path)
+	return data, err == nil
+}
+
+// write caches a file, the cache is best effort and a failure to write it only costs a discovery on the next run
+func (c *discoveryCache) write(path string, data []byte) {
+	if path == "" {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return
+	}
+	_ = ioutil.WriteFile(path, data, 0640)
+}
+
+// checkDestinationAPIs fails early when the destination cluster does not serve the resources the migration writes,
+// instead of failing on the first object
+func checkDestinationAPIs(out io.Writer, cache *discoveryCache, includeDomainMappings, includeEventing bool) error {
+	required := []schema.GroupVersionResource{serving_v1_api.SchemeGroupVersion.WithResource("services")}
+	if includeDomainMappings {
+		required = append(required, serving_v1beta1_api.SchemeGroupVersion.WithResource("domainmappings"))
+	}
+	for _, resource := range required {
+		served, err := cache.serves(resource)
+		if err != nil {
+			return err
+		}
+		if !served {
+			return fmt.Errorf("the destination cluster does not serve %s, is Knative Serving installed?", resource.GroupResource())
+		}
+	}
+	if !includeEventing {
+		return nil
+	}
+	for _, resource := range eventingResources {
+		served, err := cache.serves(resource.Resource)
+		if err != nil {
+			return err
+		}
+		if !served {
+			fmt.Fprintln(out, color.YellowString("The destination cluster does not serve %s, the %ss of the source cannot be migrated", resource.Resource.GroupResource(), resource.Kind))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	discovery_fake "k8s.io/client-go/discovery/fake"
+	k8s_testing "k8s.io/client-go/testing"
+)
+
+func newFakeDiscovery() *discovery_fake.FakeDiscovery {
+	return &discovery_fake.FakeDiscovery{Fake: &k8s_testing.Fake{Resources: []*metav1.APIResourceList{{
+		GroupVersion: "serving.knative.dev/v1",
+		APIResources: []metav1.APIResource{{Name: "services", Kind: "Service", Namespaced: true}},
+	}}}}
+}
+
+func TestDiscoveryCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "discovery")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	client := newFakeDiscovery()
+	cache := &discoveryCache{client: client, dir: dir, ttl: time.Hour}
+	assert.NilError(t, checkDestinationAPIs(io.Discard, cache, false, true))
+	_, err = cache.openAPISchema()
+	assert.NilError(t, err)
+	discoveries := len(client.Actions())
+
+	// A second run reuses the cached results, including the group versions which are not served
+	rerun := &discoveryCache{client: newFakeDiscovery(), dir: dir, ttl: time.Hour}
+	assert.NilError(t, checkDestinationAPIs(io.Discard, rerun, false, true))
+	assert.Equal(t, len(rerun.client.(*discovery_fake.FakeDiscovery).Actions()), 0)
+	assert.Assert(t, discoveries > 0)
+
+	// Expired results are discovered again
+	expired := &discoveryCache{client: newFakeDiscovery(), dir: dir, ttl: time.Nanosecond}
+	assert.NilError(t, checkDestinationAPIs(io.Discard, expired, false, false))
+	assert.Equal(t, len(expired.client.(*discovery_fake.FakeDiscovery).Actions()), 1)
+
+	err = checkDestinationAPIs(io.Discard, cache, true, false)
+	assert.ErrorContains(t, err, "does not serve domainmappings.serving.knative.dev")
+}
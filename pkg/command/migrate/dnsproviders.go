@@ -0,0 +1,274 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	// route53Endpoint and cloudflareEndpoint are the APIs of the DNS providers of a cutover
+	route53Endpoint    = "https://route53.amazonaws.com"
+	cloudflareEndpoint = "https://api.cloudflare.com/client/v4"
+	// dnsClient sends the requests to the APIs of the DNS providers
+	dnsClient = &http.Client{Timeout: 30 * time.Second}
+)
+
+// awsCredentials sign the requests to the AWS APIs
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// route53Splitter weights two records of the same name of a Route53 hosted zone, one per cluster
+type route53Splitter struct {
+	zoneID            string
+	record            string
+	recordType        string
+	ttl               int
+	sourceTarget      string
+	destinationTarget string
+	credentials       awsCredentials
+}
+
+// newRoute53Splitter returns the splitter of a Route53 record, signed with the credentials of the environment
+func newRoute53Splitter(zoneID, record, recordType string, ttl int, sourceTarget, destinationTarget string) (*route53Splitter, error) {
+	switch recordType {
+	case "A", "AAAA", "CNAME":
+	default:
+		return nil, fmt.Errorf("unsupported --record-type %q, supported values are: A, AAAA, CNAME", recordType)
+	}
+	credentials := awsCredentials{AccessKeyID: os.Getenv("AWS_ACCESS_KEY_ID"), SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"), SessionToken: os.Getenv("AWS_SESSION_TOKEN")}
+	if credentials.AccessKeyID == "" || credentials.SecretAccessKey == "" {
+		return nil, fmt.Errorf("cannot get the AWS credentials, please export the environment variables AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	return &route53Splitter{zoneID: strings.TrimPrefix(zoneID, "/hostedzone/"), record: record, recordType: recordType, ttl: ttl, sourceTarget: sourceTarget, destinationTarget: destinationTarget, credentials: credentials}, nil
+}
+
+func (s *route53Splitter) String() string {
+	return fmt.Sprintf("the Route53 record %s of hosted zone %s", s.record, s.zoneID)
+}
+
+// route53ChangeRequest is the body of a ChangeResourceRecordSets request
+type route53ChangeRequest struct {
+	XMLName xml.Name        `xml:"https://route53.amazonaws.com/doc/2013-04-01/ ChangeResourceRecordSetsRequest"`
+	Comment string          `xml:"ChangeBatch>Comment"`
+	Changes []route53Change `xml:"ChangeBatch>Changes>Change"`
+}
+
+type route53Change struct {
+	Action        string   `xml:"Action"`
+	Name          string   `xml:"ResourceRecordSet>Name"`
+	Type          string   `xml:"ResourceRecordSet>Type"`
+	SetIdentifier string   `xml:"ResourceRecordSet>SetIdentifier"`
+	Weight        int      `xml:"ResourceRecordSet>Weight"`
+	TTL           int      `xml:"ResourceRecordSet>TTL"`
+	Values        []string `xml:"ResourceRecordSet>ResourceRecords>ResourceRecord>Value"`
+}
+
+func (s *route53Splitter) setWeight(ctx context.Context, percent int) error {
+	change := route53ChangeRequest{
+		Comment: fmt.Sprintf("kn-migration cutover, %d%% to the destination", percent),
+		Changes: []route53Change{
+			{Action: "UPSERT", Name: s.record, Type: s.recordType, SetIdentifier: sourceSetIdentifier, Weight: 100 - percent, TTL: s.ttl, Values: []string{s.sourceTarget}},
+			{Action: "UPSERT", Name: s.record, Type: s.recordType, SetIdentifier: destinationSetIdentifier, Weight: percent, TTL: s.ttl, Values: []string{s.destinationTarget}},
+		},
+	}
+	body, err := xml.Marshal(change)
+	if err != nil {
+		return err
+	}
+	body = append([]byte(xml.Header), body...)
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, route53Endpoint+"/2013-04-01/hostedzone/"+s.zoneID+"/rrset/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "text/xml")
+	signAWSRequest(request, body, s.credentials, "us-east-1", "route53", time.Now())
+	_, err = sendDNSRequest(request)
+	return err
+}
+
+// signAWSRequest signs a request with the AWS Signature Version 4, over its host, its content type and its
+// x-amz-* headers
+func signAWSRequest(request *http.Request, body []byte, credentials awsCredentials, region, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	request.Header.Set("X-Amz-Date", amzDate)
+	if credentials.SessionToken != "" {
+		request.Header.Set("X-Amz-Security-Token", credentials.SessionToken)
+	}
+
+	headers := map[string]string{"host": request.URL.Host}
+	for name, values := range request.Header {
+		name = strings.ToLower(name)
+		if name == "content-type" || strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	canonicalHeaders := ""
+	for _, name := range names {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+	path := request.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	bodyHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{request.Method, path, request.URL.Query().Encode(), canonicalHeaders, signedHeaders, hex.EncodeToString(bodyHash[:])}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+	key := []byte("AWS4" + credentials.SecretAccessKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	request.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", credentials.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// cloudflareSplitter weights the origins of the clusters in a Cloudflare load balancer pool
+type cloudflareSplitter struct {
+	accountID         string
+	poolID            string
+	sourceTarget      string
+	destinationTarget string
+	token             string
+}
+
+// newCloudflareSplitter returns the splitter of a Cloudflare pool, authorized with the token of the environment
+func newCloudflareSplitter(accountID, poolID, sourceTarget, destinationTarget string) (*cloudflareSplitter, error) {
+	token := os.Getenv("CLOUDFLARE_API_TOKEN")
+	if token == "" {
+		return nil, fmt.Errorf("cannot get the Cloudflare API token, please export the environment variable CLOUDFLARE_API_TOKEN")
+	}
+	return &cloudflareSplitter{accountID: accountID, poolID: poolID, sourceTarget: sourceTarget, destinationTarget: destinationTarget, token: token}, nil
+}
+
+func (s *cloudflareSplitter) String() string {
+	return fmt.Sprintf("the Cloudflare load balancer pool %s", s.poolID)
+}
+
+// cloudflareAnswer is the envelope of the answers of the Cloudflare API
+type cloudflareAnswer struct {
+	Success bool `json:"success"`
+	Errors  []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+	Result struct {
+		Origins []map[string]interface{} `json:"origins"`
+	} `json:"result"`
+}
+
+func (s *cloudflareSplitter) setWeight(ctx context.Context, percent int) error {
+	url := fmt.Sprintf("%s/accounts/%s/load_balancers/pools/%s", cloudflareEndpoint, s.accountID, s.poolID)
+	pool, err := s.send(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	// The other fields of the origins are sent back as they are
+	weights := map[string]float64{s.sourceTarget: float64(100-percent) / 100, s.destinationTarget: float64(percent) / 100}
+	found := map[string]bool{}
+	for _, origin := range pool.Result.Origins {
+		address, _ := origin["address"].(string)
+		if weight, ok := weights[address]; ok {
+			origin["weight"] = weight
+			found[address] = true
+		}
+	}
+	for _, target := range []string{s.sourceTarget, s.destinationTarget} {
+		if !found[target] {
+			return fmt.Errorf("the pool %s has no origin with the address %s", s.poolID, target)
+		}
+	}
+	body, err := json.Marshal(map[string]interface{}{"origins": pool.Result.Origins})
+	if err != nil {
+		return err
+	}
+	_, err = s.send(ctx, http.MethodPatch, url, body)
+	return err
+}
+
+// send sends a request to the Cloudflare API and reads its answer
+func (s *cloudflareSplitter) send(ctx context.Context, method, url string, body []byte) (*cloudflareAnswer, error) {
+	request, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Authorization", "Bearer "+s.token)
+	request.Header.Set("Content-Type", "application/json")
+	data, err := sendDNSRequest(request)
+	if err != nil {
+		return nil, err
+	}
+	answer := &cloudflareAnswer{}
+	if err := json.Unmarshal(data, answer); err != nil {
+		return nil, fmt.Errorf("cannot read the answer of %s: %w", url, err)
+	}
+	if !answer.Success {
+		messages := []string{}
+		for _, e := range answer.Errors {
+			messages = append(messages, e.Message)
+		}
+		return nil, fmt.Errorf("%s %s failed: %s", method, url, strings.Join(messages, ", "))
+	}
+	return answer, nil
+}
+
+// sendDNSRequest sends a request to the API of a DNS provider and returns the body of its answer, which must have a
+// success status
+func sendDNSRequest(request *http.Request) ([]byte, error) {
+	response, err := dnsClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s %s answered %s: %s", request.Method, request.URL, response.Status, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
@@ -0,0 +1,171 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/fatih/color"
+	"k8s.io/apimachinery/pkg/api/equality"
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"knative.dev/kn-plugin-migration/pkg/command"
+	serving_v1beta1_api "knative.dev/serving/pkg/apis/serving/v1beta1"
+	serving_v1beta1_client "knative.dev/serving/pkg/client/clientset/versioned/typed/serving/v1beta1"
+)
+
+// listServiceDomainMappings returns the DomainMappings of a namespace whose ref points at one of the named services
+func listServiceDomainMappings(ctx context.Context, domainMappings serving_v1beta1_client.DomainMappingsGetter, namespace string, services []string) ([]serving_v1beta1_api.DomainMapping, error) {
+	selected := map[string]bool{}
+	for _, name := range services {
+		selected[name] = true
+	}
+	list, err := domainMappings.DomainMappings(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	mappings := []serving_v1beta1_api.DomainMapping{}
+	for _, mapping := range list.Items {
+		ref := mapping.Spec.Ref
+		if ref.Kind != "Service" || (ref.Namespace != "" && ref.Namespace != namespace) || !selected[ref.Name] {
+			continue
+		}
+		mappings = append(mappings, mapping)
+	}
+	return mappings, nil
+}
+
+// buildDomainMapping returns the copy of the DomainMapping to create in the given namespace
+func buildDomainMapping(namespace string, mapping serving_v1beta1_api.DomainMapping) *serving_v1beta1_api.DomainMapping {
+	built := &serving_v1beta1_api.DomainMapping{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "DomainMapping",
+			APIVersion: serving_v1beta1_api.SchemeGroupVersion.String(),
+		},
+		ObjectMeta: command.SanitizeObjectMeta(mapping.ObjectMeta, namespace),
+		Spec:       *mapping.Spec.DeepCopy(),
+	}
+	if built.Spec.Ref.Namespace != "" {
+		built.Spec.Ref.Namespace = namespace
+	}
+	return built
+}
+
+// migrateDomainMappings recreates in the destination namespace the DomainMappings of the migrated services, with
+// the secrets holding their TLS certificates, and returns the objects it copied. A DomainMapping already pointing
+// at the same service is kept, one pointing elsewhere is replaced when forced and fails the migration otherwise.
+func migrateDomainMappings(ctx context.Context, clientSetS, clientSetD kubernetes.Interface, domainMappingsS, domainMappingsD serving_v1beta1_client.DomainMappingsGetter, namespaceS, namespaceD string, services []string, options *MigrationOptions) ([]string, error) {
+	mappings, err := listServiceDomainMappings(ctx, domainMappingsS, namespaceS, services)
+	if err != nil {
+		return nil, sourceError(err)
+	}
+	copied := []string{}
+	for _, mapping := range mappings {
+		if mapping.Spec.TLS != nil && mapping.Spec.TLS.SecretName != "" {
+			applied, err := migrateDomainMappingSecret(ctx, clientSetS, clientSetD, namespaceS, namespaceD, mapping.Spec.TLS.SecretName, options)
+			if err != nil {
+				return copied, err
+			}
+			if applied {
+				copied = append(copied, "Secret "+mapping.Spec.TLS.SecretName)
+			}
+		}
+
+		built := buildDomainMapping(namespaceD, mapping)
+		options.stampOwnership(&built.ObjectMeta)
+		existing, err := domainMappingsD.DomainMappings(namespaceD).Get(ctx, mapping.Name, metav1.GetOptions{})
+		switch {
+		case api_errors.IsNotFound(err):
+			err = options.paced(ctx, "create domainmapping "+mapping.Name, func() error {
+				_, err := domainMappingsD.DomainMappings(namespaceD).Create(ctx, built, metav1.CreateOptions{})
+				return destinationError(err)
+			})
+			if err != nil {
+				return copied, err
+			}
+			options.changes().createdDomainMapping(namespaceD, mapping.Name, domainMappingsD)
+			fmt.Fprintln(options.out(), "Migrated domainmapping", color.CyanString(mapping.Name), "Successfully")
+		case err != nil:
+			return copied, err
+		case equality.Semantic.DeepEqual(existing.Spec.Ref, built.Spec.Ref):
+			fmt.Fprintln(options.out(), "Domainmapping", color.CyanString(mapping.Name), "already points at service", color.CyanString(built.Spec.Ref.Name), "in the destination, skip migrate domainmapping")
+			continue
+		case !options.forces(ForceDomainMappings):
+			return copied, fmt.Errorf("cannot migrate domainmapping %s: it already exists in the destination and points at %s %s, use --force or --force-scope domainmappings to replace it", mapping.Name, existing.Spec.Ref.Kind, existing.Spec.Ref.Name)
+		default:
+			if err := options.checkOwnership("DomainMapping", mapping.Name, existing.Annotations); err != nil {
+				return copied, err
+			}
+			built.ResourceVersion = existing.ResourceVersion
+			err = options.paced(ctx, "update domainmapping "+mapping.Name, func() error {
+				_, err := domainMappingsD.DomainMappings(namespaceD).Update(ctx, built, metav1.UpdateOptions{})
+				return destinationError(err)
+			})
+			if err != nil {
+				return copied, err
+			}
+			options.changes().updatedDomainMapping(existing, domainMappingsD)
+			fmt.Fprintln(options.out(), "Replaced domainmapping", color.CyanString(mapping.Name), "Successfully")
+		}
+		copied = append(copied, "DomainMapping "+mapping.Name)
+	}
+	return copied, nil
+}
+
+// migrateDomainMappingSecret migrates the secret holding the TLS certificate of a DomainMapping
+func migrateDomainMappingSecret(ctx context.Context, clientSetS, clientSetD kubernetes.Interface, namespaceS, namespaceD, name string, options *MigrationOptions) (bool, error) {
+	secretS, err := getSecret(ctx, clientSetS, namespaceS, name)
+	if api_errors.IsNotFound(err) {
+		fmt.Fprintf(options.out(), "no secret %s in the source for the TLS of a domainmapping, skip migrate secret\n", name)
+		return false, nil
+	}
+	if err != nil {
+		return false, sourceError(err)
+	}
+	return copySecret(ctx, clientSetD, namespaceD, secretS, options)
+}
+
+// planDomainMappings adds the DomainMappings of the services of a plan
+func planDomainMappings(ctx context.Context, plan *migrationPlan, domainMappingsS, domainMappingsD serving_v1beta1_client.DomainMappingsGetter, options *MigrationOptions) error {
+	services := []string{}
+	for _, entry := range plan.Entries {
+		if entry.Kind == "Service" && entry.Cluster == "destination" && entry.Action != planActionConflict {
+			services = append(services, entry.Name)
+		}
+	}
+	mappings, err := listServiceDomainMappings(ctx, domainMappingsS, plan.SourceNamespace, services)
+	if err != nil {
+		return err
+	}
+	for _, mapping := range mappings {
+		built := buildDomainMapping(plan.DestinationNamespace, mapping)
+		existing, err := domainMappingsD.DomainMappings(plan.DestinationNamespace).Get(ctx, mapping.Name, metav1.GetOptions{})
+		switch {
+		case api_errors.IsNotFound(err):
+			plan.add(planEntry{Kind: "DomainMapping", Name: mapping.Name, Namespace: plan.DestinationNamespace, Cluster: "destination", Action: planActionCreate})
+		case err != nil:
+			return err
+		case equality.Semantic.DeepEqual(existing.Spec.Ref, built.Spec.Ref):
+			plan.add(planEntry{Kind: "DomainMapping", Name: mapping.Name, Namespace: plan.DestinationNamespace, Cluster: "destination", Action: planActionSkip, Reason: "already points at the service"})
+		case options.forces(ForceDomainMappings):
+			plan.add(planEntry{Kind: "DomainMapping", Name: mapping.Name, Namespace: plan.DestinationNamespace, Cluster: "destination", Action: planActionReplace, Reason: "already exists and domainmappings are forced"})
+		default:
+			plan.add(planEntry{Kind: "DomainMapping", Name: mapping.Name, Namespace: plan.DestinationNamespace, Cluster: "destination", Action: planActionConflict, Reason: fmt.Sprintf("already exists pointing at %s %s and domainmappings are not forced", existing.Spec.Ref.Kind, existing.Spec.Ref.Name)})
+		}
+	}
+	return nil
+}
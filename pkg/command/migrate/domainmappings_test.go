@@ -0,0 +1,81 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"testing"
+
+	"gotest.tools/assert"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8s_fake "k8s.io/client-go/kubernetes/fake"
+	duckv1 "knative.dev/pkg/apis/duck/v1"
+	serving_v1beta1_api "knative.dev/serving/pkg/apis/serving/v1beta1"
+	serving_fake "knative.dev/serving/pkg/client/clientset/versioned/fake"
+)
+
+func newDomainMapping(name, namespace, service string) *serving_v1beta1_api.DomainMapping {
+	return &serving_v1beta1_api.DomainMapping{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec:       serving_v1beta1_api.DomainMappingSpec{Ref: duckv1.KReference{Kind: "Service", Name: service, APIVersion: "serving.knative.dev/v1"}},
+	}
+}
+
+func TestMigrateDomainMappings(t *testing.T) {
+	secured := newDomainMapping("api.example.com", "default", "hello")
+	secured.Spec.TLS = &serving_v1beta1_api.SecretTLS{SecretName: "api-tls"}
+	servingClientS := serving_fake.NewSimpleClientset(secured, newDomainMapping("www.example.com", "default", "web"))
+	clientSetS := k8s_fake.NewSimpleClientset(&apiv1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "api-tls", Namespace: "default"}, Data: map[string][]byte{"tls.crt": []byte("crt")}})
+	servingClientD := serving_fake.NewSimpleClientset()
+	clientSetD := k8s_fake.NewSimpleClientset()
+	options := NewMigrationOptions()
+	options.RollbackOnFailure = true
+
+	copied, err := migrateDomainMappings(context.Background(), clientSetS, clientSetD, servingClientS.ServingV1beta1(), servingClientD.ServingV1beta1(), "default", "prod", []string{"hello"}, options)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, copied, []string{"Secret api-tls", "DomainMapping api.example.com"})
+	mapping, err := servingClientD.ServingV1beta1().DomainMappings("prod").Get(context.Background(), "api.example.com", metav1.GetOptions{})
+	assert.NilError(t, err)
+	assert.Equal(t, mapping.Spec.Ref.Name, "hello")
+	_, err = getSecret(context.Background(), clientSetD, "prod", "api-tls")
+	assert.NilError(t, err)
+
+	// A DomainMapping of the destination pointing elsewhere is only replaced when forced
+	_, err = servingClientD.ServingV1beta1().DomainMappings("prod").Update(context.Background(), newDomainMapping("api.example.com", "prod", "other"), metav1.UpdateOptions{})
+	assert.NilError(t, err)
+	_, err = migrateDomainMappings(context.Background(), clientSetS, clientSetD, servingClientS.ServingV1beta1(), servingClientD.ServingV1beta1(), "default", "prod", []string{"hello"}, options)
+	assert.ErrorContains(t, err, "--force-scope domainmappings")
+
+	plan := &migrationPlan{SourceNamespace: "default", DestinationNamespace: "prod", Entries: []planEntry{{Kind: "Service", Name: "hello", Cluster: "destination", Action: planActionCreate}}}
+	assert.NilError(t, planDomainMappings(context.Background(), plan, servingClientS.ServingV1beta1(), servingClientD.ServingV1beta1(), options))
+	assert.Equal(t, plan.Entries[1].Action, planActionConflict)
+
+	options.Force = true
+	options.ForceScope = ForceScope{ForceDomainMappings}
+	_, err = migrateDomainMappings(context.Background(), clientSetS, clientSetD, servingClientS.ServingV1beta1(), servingClientD.ServingV1beta1(), "default", "prod", []string{"hello"}, options)
+	assert.ErrorContains(t, err, "use --adopt")
+	options.Adopt = true
+	_, err = migrateDomainMappings(context.Background(), clientSetS, clientSetD, servingClientS.ServingV1beta1(), servingClientD.ServingV1beta1(), "default", "prod", []string{"hello"}, options)
+	assert.NilError(t, err)
+	mapping, err = servingClientD.ServingV1beta1().DomainMappings("prod").Get(context.Background(), "api.example.com", metav1.GetOptions{})
+	assert.NilError(t, err)
+	assert.Equal(t, mapping.Spec.Ref.Name, "hello")
+
+	// Rolling back restores the replaced DomainMapping and deletes the created one
+	assert.NilError(t, options.Rollback(context.Background()))
+	_, err = servingClientD.ServingV1beta1().DomainMappings("prod").Get(context.Background(), "api.example.com", metav1.GetOptions{})
+	assert.ErrorContains(t, err, "not found")
+}
@@ -0,0 +1,97 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/fatih/color"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/kn-plugin-migration/pkg/command"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+// sourceHashAnnotation records on the migrated services and configmaps the hash of their source, so that a later run
+// skips the objects which did not change in the source and updates the objects it migrated which changed
+const sourceHashAnnotation = "migration.knative.dev/source-hash"
+
+// sourceHash returns the hash of the sanitized metadata and the content of a source object, without the annotations
+// recorded by a previous migration of the object. A change of the options rewriting the object is not part of it.
+func sourceHash(meta metav1.ObjectMeta, content interface{}) (string, error) {
+	annotations := command.SanitizeAnnotations(meta.Annotations)
+	delete(annotations, migratedFromAnnotation)
+	delete(annotations, sourceHashAnnotation)
+	delete(annotations, runIDAnnotation)
+	data, err := json.Marshal(struct {
+		Labels      map[string]string `json:"labels,omitempty"`
+		Annotations map[string]string `json:"annotations,omitempty"`
+		Content     interface{}       `json:"content"`
+	}{meta.Labels, annotations, content})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(data)), nil
+}
+
+// serviceSourceHash returns the source hash of a service
+func serviceSourceHash(service serving_v1_api.Service) (string, error) {
+	return sourceHash(service.ObjectMeta, service.Spec)
+}
+
+// configmapSourceHash returns the source hash of a configmap
+func configmapSourceHash(configmap *apiv1.ConfigMap) (string, error) {
+	return sourceHash(configmap.ObjectMeta, configmap.Data)
+}
+
+// annotateSourceHash records the source hash on the metadata of an object
+func annotateSourceHash(meta *metav1.ObjectMeta, hash string) {
+	annotations := make(map[string]string, len(meta.Annotations)+1)
+	for key, value := range meta.Annotations {
+		annotations[key] = value
+	}
+	annotations[sourceHashAnnotation] = hash
+	meta.Annotations = annotations
+}
+
+// recordedAnnotation returns an annotation of an existing destination configmap, empty if it does not exist
+func recordedAnnotation(configmap *apiv1.ConfigMap, key string) string {
+	if configmap == nil {
+		return ""
+	}
+	return configmap.Annotations[key]
+}
+
+// printMigrationState prints whether an object was created, updated or unchanged in the destination
+func printMigrationState(out io.Writer, kind, name, state string) {
+	switch state {
+	case "created":
+		fmt.Fprintln(out, kind, color.CyanString(name), color.GreenString(state))
+	case "updated":
+		fmt.Fprintln(out, kind, color.CyanString(name), color.YellowString(state))
+	default:
+		fmt.Fprintln(out, kind, color.CyanString(name), state)
+	}
+}
+
+// comparesSourceHash returns true if the objects of a kind already existing in the destination are compared with
+// the source hash recorded by a previous migration: unchanged objects are skipped and changed objects are updated.
+// They are not compared when --force or a conflict strategy tells what to do with the existing objects.
+func (o *MigrationOptions) comparesSourceHash(kind string) bool {
+	return o.OnConflict == "" && !o.forces(kind)
+}
@@ -0,0 +1,87 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"testing"
+
+	"gotest.tools/assert"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSourceHash(t *testing.T) {
+	source := simulatedBundle("default", "hello")
+	hash, err := serviceSourceHash(source.services[0])
+	assert.NilError(t, err)
+
+	// The annotations recorded by a migration and the namespace are not part of the hash
+	migrated := source.services[0].DeepCopy()
+	migrated.Namespace = "prod"
+	annotateSourceHash(&migrated.ObjectMeta, hash)
+	migrated.Annotations[migratedFromAnnotation] = "default/hello"
+	migratedHash, err := serviceSourceHash(*migrated)
+	assert.NilError(t, err)
+	assert.Equal(t, migratedHash, hash)
+
+	migrated.Spec.Template.Spec.ContainerConcurrency = new(int64)
+	changedHash, err := serviceSourceHash(*migrated)
+	assert.NilError(t, err)
+	assert.Assert(t, changedHash != hash)
+}
+
+func TestIncrementalMigration(t *testing.T) {
+	filter, err := newServiceFilter(nil, "")
+	assert.NilError(t, err)
+	source := simulatedBundle("default", "hello", "bye")
+	source.configmaps = map[string]*apiv1.ConfigMap{generateConfigmapName("hello"): {
+		ObjectMeta: metav1.ObjectMeta{Name: generateConfigmapName("hello"), Namespace: "default"},
+		Data:       map[string]string{"greeting": "hello"},
+	}}
+	clientSetD, migrationClientD := newSimulatedDestination("prod", &bundleSource{})
+	migrate := func() *NamespaceReport {
+		report := newMigrationReport()
+		_, err := migrateNamespace(context.Background(), source, clientSetD, migrationClientD, "prod", filter, NewMigrationOptions(), report.namespace("default", "prod"))
+		assert.NilError(t, err)
+		assert.Equal(t, report.failures(), 0)
+		return report.Namespaces[0]
+	}
+	statuses := func(report *NamespaceReport) map[string]ServiceStatus {
+		statuses := map[string]ServiceStatus{}
+		for _, service := range report.Services {
+			statuses[service.Name] = service.Status
+		}
+		return statuses
+	}
+	assert.DeepEqual(t, statuses(migrate()), map[string]ServiceStatus{"hello": ServiceStatusMigrated, "bye": ServiceStatusMigrated})
+
+	// Running the migration again without --force skips the services which did not change in the source
+	assert.DeepEqual(t, statuses(migrate()), map[string]ServiceStatus{"hello": ServiceStatusSkipped, "bye": ServiceStatusSkipped})
+
+	// The services and configmaps which changed in the source are updated without --force
+	source.services[1].Labels = map[string]string{"team": "a"}
+	source.configmaps[generateConfigmapName("hello")].Data["greeting"] = "hi"
+	assert.DeepEqual(t, statuses(migrate()), map[string]ServiceStatus{"hello": ServiceStatusSkipped, "bye": ServiceStatusMigrated})
+	serviceD, err := migrationClientD.GetService(context.Background(), "bye")
+	assert.NilError(t, err)
+	assert.Equal(t, serviceD.Labels["team"], "a")
+	configmapD, err := getConfigmap(context.Background(), clientSetD, "prod", generateConfigmapName("hello"))
+	assert.NilError(t, err)
+	assert.Equal(t, configmapD.Data["greeting"], "hi")
+	revisions, err := migrationClientD.ListRevisionByService(context.Background(), "bye")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, revisionNames(revisions), []string{"bye-00001", "bye-00002"})
+}
@@ -0,0 +1,152 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	api_serving "knative.dev/serving/pkg/apis/serving"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// dumpObject is a document of a kubectl dump, either a single object or a List of objects
+type dumpObject struct {
+	metav1.TypeMeta `json:",inline"`
+	Metadata        struct {
+		Namespace string `json:"namespace"`
+	} `json:"metadata"`
+	Items []json.RawMessage `json:"items"`
+}
+
+// readDump loads the services, revisions and configmaps of a namespace from a multi-document YAML or JSON dump,
+// e.g. written by 'kubectl get ksvc,revisions,configmaps -o yaml'. Other kinds are ignored, the namespace can be
+// left empty when the dump holds the resources of a single namespace.
+func readDump(path, namespace string) (*bundleSource, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	sources := map[string]*bundleSource{}
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(file))
+	for {
+		document, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot read the dump %s: %w", path, err)
+		}
+		data, err := yaml.YAMLToJSON(document)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse the dump %s: %w", path, err)
+		}
+		err = addDumpObject(sources, data)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse the dump %s: %w", path, err)
+		}
+	}
+
+	if namespace == "" {
+		namespaces := []string{}
+		for name := range sources {
+			namespaces = append(namespaces, name)
+		}
+		sort.Strings(namespaces)
+		switch len(namespaces) {
+		case 0:
+			return nil, fmt.Errorf("the dump %s contains no service, revision or configmap", path)
+		case 1:
+			namespace = namespaces[0]
+		default:
+			return nil, fmt.Errorf("the dump %s contains the resources of several namespaces (%s), please use --namespace to select one", path, strings.Join(namespaces, ", "))
+		}
+	}
+	source, ok := sources[namespace]
+	if !ok {
+		return nil, fmt.Errorf("the dump %s contains no resource of namespace %s", path, namespace)
+	}
+	return source, nil
+}
+
+// addDumpObject adds a dumped object, or every item of a dumped List, to the source of its namespace
+func addDumpObject(sources map[string]*bundleSource, data []byte) error {
+	if len(data) == 0 || string(data) == "null" {
+		return nil
+	}
+	object := dumpObject{}
+	err := json.Unmarshal(data, &object)
+	if err != nil {
+		return err
+	}
+	if strings.HasSuffix(object.Kind, "List") {
+		for _, item := range object.Items {
+			err = addDumpObject(sources, item)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	switch {
+	case object.Kind == "ConfigMap" && object.APIVersion == "v1":
+	case (object.Kind == "Service" || object.Kind == "Revision") && object.APIVersion == serving_v1_api.SchemeGroupVersion.String():
+	default:
+		return nil
+	}
+	source, ok := sources[object.Metadata.Namespace]
+	if !ok {
+		source = &bundleSource{
+			namespace:  object.Metadata.Namespace,
+			configmaps: map[string]*apiv1.ConfigMap{},
+			revisions:  map[string][]serving_v1_api.Revision{},
+		}
+		sources[object.Metadata.Namespace] = source
+	}
+	switch object.Kind {
+	case "ConfigMap":
+		configmap := &apiv1.ConfigMap{}
+		err = json.Unmarshal(data, configmap)
+		source.configmaps[configmap.Name] = configmap
+	case "Service":
+		service := serving_v1_api.Service{}
+		err = json.Unmarshal(data, &service)
+		source.services = append(source.services, service)
+	case "Revision":
+		revision := serving_v1_api.Revision{}
+		err = json.Unmarshal(data, &revision)
+		if err != nil {
+			return err
+		}
+		service := revision.Labels[api_serving.ServiceLabelKey]
+		if service == "" {
+			return fmt.Errorf("revision %s of namespace %s has no %s label, cannot tell which service it belongs to", revision.Name, revision.Namespace, api_serving.ServiceLabelKey)
+		}
+		source.revisions[service] = append(source.revisions[service], revision)
+	}
+	return err
+}
@@ -0,0 +1,103 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+const testDump = `apiVersion: v1
+kind: List
+items:
+- apiVersion: serving.knative.dev/v1
+  kind: Service
+  metadata:
+    name: hello
+    namespace: default
+- apiVersion: serving.knative.dev/v1
+  kind: Revision
+  metadata:
+    name: hello-00001
+    namespace: default
+    labels:
+      serving.knative.dev/service: hello
+- apiVersion: v1
+  kind: Service
+  metadata:
+    name: kubernetes
+    namespace: default
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: hello-config
+  namespace: default
+data:
+  key: value
+---
+apiVersion: serving.knative.dev/v1
+kind: Service
+metadata:
+  name: bye
+  namespace: other
+`
+
+func TestReadDump(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dump")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dump.yaml")
+	assert.NilError(t, ioutil.WriteFile(path, []byte(testDump), 0644))
+
+	_, err = readDump(path, "")
+	assert.ErrorContains(t, err, "several namespaces (default, other)")
+	_, err = readDump(path, "missing")
+	assert.ErrorContains(t, err, "no resource of namespace missing")
+
+	source, err := readDump(path, "default")
+	assert.NilError(t, err)
+	assert.Equal(t, source.Namespace(), "default")
+	filter, err := newServiceFilter(nil, "")
+	assert.NilError(t, err)
+	services, err := source.ListServices(context.Background(), filter)
+	assert.NilError(t, err)
+	assert.Equal(t, len(services.Items), 1)
+	assert.Equal(t, services.Items[0].Name, "hello")
+	revisions, err := source.ListRevisionByService(context.Background(), "hello")
+	assert.NilError(t, err)
+	assert.Equal(t, len(revisions.Items), 1)
+	configmap, err := source.GetConfigmap(context.Background(), "hello-config")
+	assert.NilError(t, err)
+	assert.Equal(t, configmap.Data["key"], "value")
+}
+
+func TestReadDumpUnlabeledRevision(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dump.yaml")
+	assert.NilError(t, ioutil.WriteFile(path, []byte(`apiVersion: serving.knative.dev/v1
+kind: Revision
+metadata:
+  name: hello-00001
+  namespace: default
+`), 0644))
+
+	_, err := readDump(path, "default")
+	assert.ErrorContains(t, err, "revision hello-00001 of namespace default has no serving.knative.dev/service label")
+}
@@ -0,0 +1,185 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"io/ioutil"
+	"sort"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/kn-plugin-migration/pkg/command"
+	serving_v1_client "knative.dev/serving/pkg/client/clientset/versioned/typed/serving/v1"
+	serving_v1beta1_client "knative.dev/serving/pkg/client/clientset/versioned/typed/serving/v1beta1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	endpointKindURL           = "url"
+	endpointKindTag           = "tag"
+	endpointKindDomainMapping = "domainmapping"
+)
+
+// endpointKey identifies a public hostname of a service, the default URL, a traffic tag URL or a DomainMapping
+type endpointKey struct {
+	Service string `json:"service"`
+	Kind    string `json:"kind"`
+	// Name is the traffic tag or the DomainMapping name, empty for the default URL
+	Name string `json:"name,omitempty"`
+}
+
+// endpointChange is a public hostname of a service before and after the migration
+type endpointChange struct {
+	Namespace            string `json:"namespace"`
+	DestinationNamespace string `json:"destinationNamespace"`
+	endpointKey
+	Before  string `json:"before"`
+	After   string `json:"after"`
+	Changed bool   `json:"changed"`
+}
+
+// endpointNotice is the endpoint-change notice written with --endpoints-file, meant to be sent to the consumers of the services
+type endpointNotice struct {
+	GeneratedAt metav1.Time      `json:"generatedAt"`
+	Endpoints   []endpointChange `json:"endpoints"`
+}
+
+// collectEndpoints returns the public URLs of the named services of a namespace: their default URL, the URL of
+// every tagged traffic target and the DomainMappings referencing them. A nil domainMappings skips DomainMappings.
+func collectEndpoints(ctx context.Context, migrationClient command.MigrationClient, domainMappings serving_v1beta1_client.DomainMappingsGetter, namespace string, services []string) (map[endpointKey]string, error) {
+	endpoints := map[endpointKey]string{}
+	selected := map[string]bool{}
+	for _, name := range services {
+		selected[name] = true
+		service, err := migrationClient.GetService(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if service.Status.URL != nil {
+			endpoints[endpointKey{Service: name, Kind: endpointKindURL}] = service.Status.URL.String()
+		}
+		for _, target := range service.Status.Traffic {
+			if target.Tag != "" && target.URL != nil {
+				endpoints[endpointKey{Service: name, Kind: endpointKindTag, Name: target.Tag}] = target.URL.String()
+			}
+		}
+	}
+	if domainMappings == nil {
+		return endpoints, nil
+	}
+
+	mappings, err := domainMappings.DomainMappings(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, mapping := range mappings.Items {
+		if mapping.Spec.Ref.Kind != "Service" || !selected[mapping.Spec.Ref.Name] {
+			continue
+		}
+		url := "https://" + mapping.Name
+		if mapping.Status.URL != nil {
+			url = mapping.Status.URL.String()
+		}
+		endpoints[endpointKey{Service: mapping.Spec.Ref.Name, Kind: endpointKindDomainMapping, Name: mapping.Name}] = url
+	}
+	return endpoints, nil
+}
+
+// diffEndpoints pairs the endpoints of the source and destination namespaces, sorted by service, kind and name
+// so that notices of successive runs can be diffed
+func diffEndpoints(namespaceS, namespaceD string, before, after map[endpointKey]string) []endpointChange {
+	keys := []endpointKey{}
+	for key := range before {
+		keys = append(keys, key)
+	}
+	for key := range after {
+		if _, ok := before[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Service != keys[j].Service {
+			return keys[i].Service < keys[j].Service
+		}
+		if keys[i].Kind != keys[j].Kind {
+			return keys[i].Kind < keys[j].Kind
+		}
+		return keys[i].Name < keys[j].Name
+	})
+
+	changes := []endpointChange{}
+	for _, key := range keys {
+		changes = append(changes, endpointChange{
+			Namespace:            namespaceS,
+			DestinationNamespace: namespaceD,
+			endpointKey:          key,
+			Before:               before[key],
+			After:                after[key],
+			Changed:              before[key] != after[key],
+		})
+	}
+	return changes
+}
+
+// writeMigrationEndpoints writes the endpoint-change notice of the services migrated from every namespace
+func writeMigrationEndpoints(ctx context.Context, path string, servingClientS, servingClientD serving_v1_client.ServingV1Interface, kubeconfigS, kubeconfigD clusterConfig, namespaces []namespacePair, migratedByNamespace [][]string, renames map[string]string) error {
+	domainMappingsS, err := getDomainMappingClient(kubeconfigS)
+	if err != nil {
+		return err
+	}
+	domainMappingsD, err := getDomainMappingClient(kubeconfigD)
+	if err != nil {
+		return err
+	}
+	changes := []endpointChange{}
+	for i, namespace := range namespaces {
+		before, err := collectEndpoints(ctx, command.NewMigrationClient(servingClientS, namespace.Source), domainMappingsS, namespace.Source, migratedByNamespace[i])
+		if err != nil {
+			return sourceError(err)
+		}
+		// The endpoints of renamed services are paired with their endpoints in the source
+		sources := map[string]string{}
+		names := []string{}
+		for _, name := range migratedByNamespace[i] {
+			renamed := name
+			if destination, ok := renames[name]; ok {
+				renamed = destination
+			}
+			sources[renamed] = name
+			names = append(names, renamed)
+		}
+		renamedAfter, err := collectEndpoints(ctx, command.NewMigrationClient(servingClientD, namespace.Destination), domainMappingsD, namespace.Destination, names)
+		if err != nil {
+			return err
+		}
+		after := map[endpointKey]string{}
+		for key, url := range renamedAfter {
+			key.Service = sources[key.Service]
+			after[key] = url
+		}
+		changes = append(changes, diffEndpoints(namespace.Source, namespace.Destination, before, after)...)
+	}
+	return writeEndpointNotice(path, changes)
+}
+
+// writeEndpointNotice writes the endpoint changes of every namespace to a YAML file
+func writeEndpointNotice(path string, changes []endpointChange) error {
+	data, err := yaml.Marshal(endpointNotice{GeneratedAt: metav1.NewTime(time.Now()), Endpoints: changes})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
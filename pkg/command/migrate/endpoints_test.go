@@ -0,0 +1,67 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"testing"
+
+	"gotest.tools/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/kn-plugin-migration/pkg/command"
+	"knative.dev/pkg/apis"
+	duckv1 "knative.dev/pkg/apis/duck/v1"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+	serving_v1beta1_api "knative.dev/serving/pkg/apis/serving/v1beta1"
+	serving_fake "knative.dev/serving/pkg/client/clientset/versioned/fake"
+)
+
+func newEndpointService(namespace, host string) *serving_v1_api.Service {
+	service := &serving_v1_api.Service{ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: namespace}}
+	service.Status.URL = &apis.URL{Scheme: "https", Host: "hello." + host}
+	service.Status.Traffic = []serving_v1_api.TrafficTarget{
+		{RevisionName: "hello-00002"},
+		{RevisionName: "hello-00001", Tag: "old", URL: &apis.URL{Scheme: "https", Host: "old-hello." + host}},
+	}
+	return service
+}
+
+func TestCollectEndpoints(t *testing.T) {
+	mapping := &serving_v1beta1_api.DomainMapping{
+		ObjectMeta: metav1.ObjectMeta{Name: "api.example.com", Namespace: "default"},
+		Spec:       serving_v1beta1_api.DomainMappingSpec{Ref: duckv1.KReference{Kind: "Service", Name: "hello"}},
+	}
+	servingClientS := serving_fake.NewSimpleClientset(newEndpointService("default", "default.old.example.com"), mapping)
+	servingClientD := serving_fake.NewSimpleClientset(newEndpointService("prod", "prod.new.example.com"))
+
+	before, err := collectEndpoints(context.Background(), command.NewMigrationClient(servingClientS.ServingV1(), "default"), servingClientS.ServingV1beta1(), "default", []string{"hello"})
+	assert.NilError(t, err)
+	assert.Equal(t, len(before), 3)
+	assert.Equal(t, before[endpointKey{Service: "hello", Kind: endpointKindDomainMapping, Name: "api.example.com"}], "https://api.example.com")
+
+	after, err := collectEndpoints(context.Background(), command.NewMigrationClient(servingClientD.ServingV1(), "prod"), nil, "prod", []string{"hello"})
+	assert.NilError(t, err)
+	assert.Equal(t, len(after), 2)
+
+	changes := diffEndpoints("default", "prod", before, after)
+	assert.Equal(t, len(changes), 3)
+	assert.Equal(t, changes[0].Kind, endpointKindDomainMapping)
+	assert.Equal(t, changes[0].After, "")
+	assert.Equal(t, changes[1].Kind, endpointKindTag)
+	assert.Equal(t, changes[1].Before, "https://old-hello.default.old.example.com")
+	assert.Equal(t, changes[1].After, "https://old-hello.prod.new.example.com")
+	assert.Equal(t, changes[2].Kind, endpointKindURL)
+	assert.Assert(t, changes[2].Changed)
+}
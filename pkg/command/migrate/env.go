@@ -0,0 +1,187 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+// EnvOverrides set or remove environment variables of the serving container of the migrated services and revisions
+type EnvOverrides struct {
+	// Set are the variables set in the order they were given, replacing the variables of the same name
+	Set []apiv1.EnvVar
+	// Remove are the names of the variables removed
+	Remove []string
+}
+
+// parseEnvOverrides parses the KEY=VALUE lines of an env file and the KEY=VALUE pairs of --env, which override
+// the file. A KEY- pair removes the variable, like kn service update --env.
+func parseEnvOverrides(pairs []string, file string) (EnvOverrides, error) {
+	overrides := EnvOverrides{}
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return overrides, err
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for line := 1; scanner.Scan(); line++ {
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" || strings.HasPrefix(text, "#") {
+				continue
+			}
+			if err := overrides.add(text); err != nil {
+				return overrides, fmt.Errorf("%w at %s:%d", err, file, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return overrides, err
+		}
+	}
+	for _, pair := range pairs {
+		if err := overrides.add(pair); err != nil {
+			return overrides, err
+		}
+	}
+	return overrides, nil
+}
+
+// add validates a KEY=VALUE or KEY- pair and records it, replacing a previous pair of the same variable
+func (o *EnvOverrides) add(pair string) error {
+	name, value, set := pair, "", false
+	if parts := strings.SplitN(pair, "=", 2); len(parts) == 2 {
+		name, value, set = parts[0], parts[1], true
+	} else if strings.HasSuffix(pair, "-") {
+		name = strings.TrimSuffix(pair, "-")
+	} else {
+		return fmt.Errorf("invalid environment variable %q, expected KEY=VALUE or KEY- to remove it", pair)
+	}
+	if errs := validation.IsEnvVarName(name); len(errs) > 0 {
+		return fmt.Errorf("invalid environment variable %q: %s", pair, strings.Join(errs, ", "))
+	}
+
+	o.forget(name)
+	if set {
+		o.Set = append(o.Set, apiv1.EnvVar{Name: name, Value: value})
+	} else {
+		o.Remove = append(o.Remove, name)
+	}
+	return nil
+}
+
+// forget drops the previous pair of a variable
+func (o *EnvOverrides) forget(name string) {
+	set := o.Set[:0:0]
+	for _, env := range o.Set {
+		if env.Name != name {
+			set = append(set, env)
+		}
+	}
+	o.Set = set
+	remove := o.Remove[:0:0]
+	for _, removed := range o.Remove {
+		if removed != name {
+			remove = append(remove, removed)
+		}
+	}
+	o.Remove = remove
+}
+
+// empty returns true if the overrides change nothing
+func (o EnvOverrides) empty() bool {
+	return len(o.Set) == 0 && len(o.Remove) == 0
+}
+
+// servingContainer returns the index of the container receiving the requests, the only container exposing a port
+// of a multi-container service, else the first container
+func servingContainer(spec *apiv1.PodSpec) int {
+	for i, container := range spec.Containers {
+		if len(container.Ports) > 0 {
+			return i
+		}
+	}
+	return 0
+}
+
+// apply returns a copy of the containers with the overrides applied to the serving container and the applied changes
+func (o EnvOverrides) apply(spec *apiv1.PodSpec) []string {
+	if len(spec.Containers) == 0 {
+		return nil
+	}
+	containers := make([]apiv1.Container, len(spec.Containers))
+	copy(containers, spec.Containers)
+	container := &containers[servingContainer(spec)]
+
+	removed := map[string]bool{}
+	for _, name := range o.Remove {
+		removed[name] = true
+	}
+	values := map[string]string{}
+	for _, env := range o.Set {
+		values[env.Name] = env.Value
+	}
+	changes := []string{}
+	env := []apiv1.EnvVar{}
+	for _, variable := range container.Env {
+		if removed[variable.Name] {
+			changes = append(changes, "removed "+variable.Name)
+			continue
+		}
+		if value, ok := values[variable.Name]; ok {
+			if variable.ValueFrom != nil || variable.Value != value {
+				changes = append(changes, "set "+variable.Name)
+			}
+			variable = apiv1.EnvVar{Name: variable.Name, Value: value}
+			delete(values, variable.Name)
+		}
+		env = append(env, variable)
+	}
+	// The variables which did not exist are added in the order they were given
+	for _, variable := range o.Set {
+		if _, ok := values[variable.Name]; ok {
+			changes = append(changes, "set "+variable.Name)
+			env = append(env, variable)
+		}
+	}
+	container.Env = env
+	spec.Containers = containers
+	return changes
+}
+
+// overrideEnv applies the environment variable overrides of the options to a service and its revisions
+func overrideEnv(serviceS *serving_v1_api.Service, revisionsS *serving_v1_api.RevisionList, options *MigrationOptions) {
+	if options.EnvOverrides.empty() {
+		return
+	}
+	report := func(kind, name string, changes []string) {
+		if len(changes) > 0 {
+			fmt.Fprintln(options.out(), "Overrode environment variables of", kind, color.CyanString(name)+":", strings.Join(changes, ", "))
+		}
+	}
+
+	report("the template of service", serviceS.Name, options.EnvOverrides.apply(&serviceS.Spec.Template.Spec.PodSpec))
+	for i := range revisionsS.Items {
+		revision := &revisionsS.Items[i]
+		report("revision", revision.Name, options.EnvOverrides.apply(&revision.Spec.PodSpec))
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/assert"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+func TestParseEnvOverrides(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "prod.env")
+	assert.NilError(t, ioutil.WriteFile(file, []byte("# destination settings\nDB_HOST=db.prod-2.internal\nAPI_URL=https://api.example.com/?a=b\nDEBUG=true\n"), 0600))
+
+	overrides, err := parseEnvOverrides([]string{"DEBUG-", "DB_HOST=db.override"}, file)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, overrides.Set, []apiv1.EnvVar{{Name: "API_URL", Value: "https://api.example.com/?a=b"}, {Name: "DB_HOST", Value: "db.override"}})
+	assert.DeepEqual(t, overrides.Remove, []string{"DEBUG"})
+
+	_, err = parseEnvOverrides([]string{"DB_HOST"}, "")
+	assert.ErrorContains(t, err, "invalid environment variable \"DB_HOST\", expected KEY=VALUE or KEY- to remove it")
+	_, err = parseEnvOverrides([]string{"1DB=x"}, "")
+	assert.ErrorContains(t, err, "invalid environment variable \"1DB=x\"")
+
+	assert.NilError(t, ioutil.WriteFile(file, []byte("DB HOST=x\n"), 0600))
+	_, err = parseEnvOverrides(nil, file)
+	assert.ErrorContains(t, err, "prod.env:1")
+}
+
+func TestOverrideEnv(t *testing.T) {
+	overrides, err := parseEnvOverrides([]string{"DB_HOST=db.prod-2.internal", "REGION=eu", "DEBUG-"}, "")
+	assert.NilError(t, err)
+	options := &MigrationOptions{EnvOverrides: overrides}
+	containers := []apiv1.Container{
+		{Name: "proxy", Env: []apiv1.EnvVar{{Name: "DB_HOST", Value: "proxy"}}},
+		{Name: "app", Ports: []apiv1.ContainerPort{{ContainerPort: 8080}}, Env: []apiv1.EnvVar{
+			{Name: "DB_HOST", ValueFrom: &apiv1.EnvVarSource{ConfigMapKeyRef: &apiv1.ConfigMapKeySelector{Key: "host"}}},
+			{Name: "DEBUG", Value: "true"},
+			{Name: "PORT_NAME", Value: "http"},
+		}},
+	}
+	service := serving_v1_api.Service{ObjectMeta: metav1.ObjectMeta{Name: "hello"}}
+	service.Spec.Template.Spec.Containers = containers
+	revisions := &serving_v1_api.RevisionList{Items: []serving_v1_api.Revision{{ObjectMeta: metav1.ObjectMeta{Name: "hello-00001"}}}}
+	revisions.Items[0].Spec.Containers = []apiv1.Container{{Name: "app"}}
+
+	overrideEnv(&service, revisions, options)
+
+	// Only the serving container, the container exposing a port, is changed
+	assert.DeepEqual(t, service.Spec.Template.Spec.Containers[0].Env, []apiv1.EnvVar{{Name: "DB_HOST", Value: "proxy"}})
+	assert.DeepEqual(t, service.Spec.Template.Spec.Containers[1].Env, []apiv1.EnvVar{
+		{Name: "DB_HOST", Value: "db.prod-2.internal"},
+		{Name: "PORT_NAME", Value: "http"},
+		{Name: "REGION", Value: "eu"},
+	})
+	assert.DeepEqual(t, revisions.Items[0].Spec.Containers[0].Env, []apiv1.EnvVar{{Name: "DB_HOST", Value: "db.prod-2.internal"}, {Name: "REGION", Value: "eu"}})
+	// The containers of the source are not modified
+	assert.Equal(t, len(containers[1].Env), 3)
+	assert.Assert(t, containers[1].Env[0].ValueFrom != nil)
+}
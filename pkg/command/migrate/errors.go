@@ -0,0 +1,85 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"errors"
+	"strings"
+
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+var (
+	// ErrServiceExists is returned when a service already exists in the destination and Force is not set
+	ErrServiceExists = errors.New("service already exists in the destination")
+	// ErrSourceUnreachable is returned when the source cluster cannot be reached
+	ErrSourceUnreachable = errors.New("source cluster is unreachable")
+	// ErrVerificationFailed is returned when migrated services do not become Ready in the destination
+	ErrVerificationFailed = errors.New("verification of the migrated services failed")
+	// ErrQuotaExceeded is returned when the destination rejects a resource because of a resource quota
+	ErrQuotaExceeded = errors.New("destination resource quota exceeded")
+	// ErrNotManaged is returned when an object to overwrite in the destination was not written by the migration
+	// and Adopt is not set
+	ErrNotManaged = errors.New("it exists in the destination and is not managed by kn-migration")
+	// ErrCutoverAborted is returned when the probe of a cutover reports the destination unhealthy and the traffic
+	// is sent back to the source
+	ErrCutoverAborted = errors.New("the cutover was aborted")
+)
+
+// migrationError is an error of one of the Err kinds above, wrapping the error which caused it,
+// errors.Is matches both the kind and the cause
+type migrationError struct {
+	kind  error
+	cause error
+}
+
+func (e *migrationError) Error() string {
+	return e.kind.Error() + ": " + e.cause.Error()
+}
+
+func (e *migrationError) Is(target error) bool {
+	return target == e.kind
+}
+
+func (e *migrationError) Unwrap() error {
+	return e.cause
+}
+
+func newMigrationError(kind, cause error) error {
+	return &migrationError{kind: kind, cause: cause}
+}
+
+// sourceError marks the errors of calls to the source cluster which did not get an answer from its API server
+func sourceError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var status api_errors.APIStatus
+	if !errors.As(err, &status) || api_errors.IsServiceUnavailable(err) || api_errors.IsTimeout(err) || api_errors.IsServerTimeout(err) {
+		return newMigrationError(ErrSourceUnreachable, err)
+	}
+	return err
+}
+
+// destinationError marks the errors of write calls to the destination cluster rejected by a resource quota
+func destinationError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if api_errors.IsForbidden(err) && strings.Contains(err.Error(), "exceeded quota") {
+		return newMigrationError(ErrQuotaExceeded, err)
+	}
+	return err
+}
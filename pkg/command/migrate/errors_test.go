@@ -0,0 +1,50 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"errors"
+	"testing"
+
+	"gotest.tools/assert"
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestSourceError(t *testing.T) {
+	err := sourceError(errors.New("dial tcp 10.0.0.1:443: connect: connection refused"))
+	assert.Assert(t, errors.Is(err, ErrSourceUnreachable))
+
+	err = sourceError(api_errors.NewServiceUnavailable("etcd is down"))
+	assert.Assert(t, errors.Is(err, ErrSourceUnreachable))
+	assert.Assert(t, api_errors.IsServiceUnavailable(err))
+
+	err = sourceError(api_errors.NewNotFound(schema.GroupResource{Resource: "configmaps"}, "hello-config"))
+	assert.Assert(t, !errors.Is(err, ErrSourceUnreachable))
+	assert.Assert(t, api_errors.IsNotFound(err))
+
+	assert.NilError(t, sourceError(nil))
+}
+
+func TestDestinationError(t *testing.T) {
+	quota := api_errors.NewForbidden(schema.GroupResource{Resource: "revisions"}, "hello-00001",
+		errors.New("exceeded quota: compute, requested: limits.cpu=1, used: limits.cpu=8, limited: limits.cpu=8"))
+	err := destinationError(quota)
+	assert.Assert(t, errors.Is(err, ErrQuotaExceeded))
+	assert.Assert(t, api_errors.IsForbidden(err))
+
+	err = destinationError(api_errors.NewForbidden(schema.GroupResource{Resource: "revisions"}, "hello-00001", errors.New("RBAC denied")))
+	assert.Assert(t, !errors.Is(err, ErrQuotaExceeded))
+}
@@ -0,0 +1,272 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+	"k8s.io/apimachinery/pkg/api/equality"
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"knative.dev/kn-plugin-migration/pkg/command"
+)
+
+// eventingResource is a kind of Knative Eventing object migrated with --include-eventing
+type eventingResource struct {
+	Kind     string
+	Resource schema.GroupVersionResource
+}
+
+// eventingResources are migrated in order, so that the objects a kind depends on already exist in the destination
+var eventingResources = []eventingResource{
+	{Kind: "Broker", Resource: schema.GroupVersionResource{Group: "eventing.knative.dev", Version: "v1", Resource: "brokers"}},
+	{Kind: "Channel", Resource: schema.GroupVersionResource{Group: "messaging.knative.dev", Version: "v1", Resource: "channels"}},
+	{Kind: "Subscription", Resource: schema.GroupVersionResource{Group: "messaging.knative.dev", Version: "v1", Resource: "subscriptions"}},
+	{Kind: "Trigger", Resource: schema.GroupVersionResource{Group: "eventing.knative.dev", Version: "v1", Resource: "triggers"}},
+	{Kind: "Sequence", Resource: schema.GroupVersionResource{Group: "flows.knative.dev", Version: "v1", Resource: "sequences"}},
+	{Kind: "Parallel", Resource: schema.GroupVersionResource{Group: "flows.knative.dev", Version: "v1", Resource: "parallels"}},
+	{Kind: "PingSource", Resource: schema.GroupVersionResource{Group: "sources.knative.dev", Version: "v1", Resource: "pingsources"}},
+	{Kind: "ApiServerSource", Resource: schema.GroupVersionResource{Group: "sources.knative.dev", Version: "v1", Resource: "apiserversources"}},
+	{Kind: "SinkBinding", Resource: schema.GroupVersionResource{Group: "sources.knative.dev", Version: "v1", Resource: "sinkbindings"}},
+	{Kind: "ContainerSource", Resource: schema.GroupVersionResource{Group: "sources.knative.dev", Version: "v1", Resource: "containersources"}},
+}
+
+// serviceAccountFields are where the eventing kinds name the service account their adapter runs as
+var serviceAccountFields = map[string][]string{
+	"ApiServerSource": {"spec", "serviceAccountName"},
+	"ContainerSource": {"spec", "template", "spec", "serviceAccountName"},
+}
+
+// listEventingObjects returns the objects of a kind in a namespace sorted by name, none if Knative Eventing
+// is not installed in the cluster. The objects owned by another object, e.g. the Channels and Subscriptions
+// of a Sequence, are left out since their owner recreates them in the destination.
+func listEventingObjects(ctx context.Context, client dynamic.Interface, resource eventingResource, namespace string) ([]unstructured.Unstructured, error) {
+	list, err := client.Resource(resource.Resource).Namespace(namespace).List(ctx, metav1.ListOptions{})
+	if api_errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	objects := []unstructured.Unstructured{}
+	for _, object := range list.Items {
+		if metav1.GetControllerOf(&object) == nil {
+			objects = append(objects, object)
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].GetName() < objects[j].GetName()
+	})
+	return objects, nil
+}
+
+// buildEventingObject returns the copy of an eventing object to create in the destination namespace: its status
+// and server populated metadata are dropped and the references to objects of the source namespace, e.g. the
+// subscriber of a Trigger, are rewritten to the destination namespace
+func buildEventingObject(object unstructured.Unstructured, namespaceS, namespaceD string) *unstructured.Unstructured {
+	built := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": object.GetAPIVersion(),
+		"kind":       object.GetKind(),
+	}}
+	built.SetName(object.GetName())
+	built.SetNamespace(namespaceD)
+	built.SetLabels(object.GetLabels())
+	built.SetAnnotations(command.SanitizeAnnotations(object.GetAnnotations()))
+	if spec, ok := object.Object["spec"]; ok {
+		built.Object["spec"] = rewriteNamespaceReferences(runtime.DeepCopyJSONValue(spec), namespaceS, namespaceD)
+	}
+	return built
+}
+
+// rewriteNamespaceReferences rewrites the namespace of every Kubernetes reference, a map with a kind, a name and
+// a namespace, which points at the source namespace
+func rewriteNamespaceReferences(value interface{}, namespaceS, namespaceD string) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		_, hasKind := value["kind"]
+		_, hasName := value["name"]
+		if namespace, ok := value["namespace"].(string); ok && hasKind && hasName && namespace == namespaceS {
+			value["namespace"] = namespaceD
+		}
+		for key, child := range value {
+			value[key] = rewriteNamespaceReferences(child, namespaceS, namespaceD)
+		}
+	case []interface{}:
+		for i, child := range value {
+			value[i] = rewriteNamespaceReferences(child, namespaceS, namespaceD)
+		}
+	}
+	return value
+}
+
+// referencedServices returns the names of the Knative Services an eventing object delivers to in its namespace,
+// e.g. the subscriber of a Trigger or the reply and steps of a Sequence, sorted and without duplicates
+func referencedServices(object unstructured.Unstructured) []string {
+	found := map[string]bool{}
+	var walk func(value interface{})
+	walk = func(value interface{}) {
+		switch value := value.(type) {
+		case map[string]interface{}:
+			namespace, _ := value["namespace"].(string)
+			name, _ := value["name"].(string)
+			if value["kind"] == "Service" && value["apiVersion"] == "serving.knative.dev/v1" && name != "" && (namespace == "" || namespace == object.GetNamespace()) {
+				found[name] = true
+			}
+			for _, child := range value {
+				walk(child)
+			}
+		case []interface{}:
+			for _, child := range value {
+				walk(child)
+			}
+		}
+	}
+	walk(object.Object["spec"])
+	services := make([]string, 0, len(found))
+	for name := range found {
+		services = append(services, name)
+	}
+	sort.Strings(services)
+	return services
+}
+
+// eventingServiceAccount returns the service account an eventing object runs its adapter as, if any
+func eventingServiceAccount(kind string, object unstructured.Unstructured) string {
+	field, ok := serviceAccountFields[kind]
+	if !ok {
+		return ""
+	}
+	name, _, _ := unstructured.NestedString(object.Object, field...)
+	return name
+}
+
+// migrateEventing copies the eventing objects of a namespace to its destination namespace and returns the objects
+// it copied. An object existing in the destination with the same spec is kept, one with a different spec is
+// replaced when forced and fails the migration otherwise. The services an object delivers to which are not migrated
+// are reported, the object is migrated anyway. The service accounts of the sources, e.g. an ApiServerSource
+// watching the API server, are copied first with their permissions.
+func migrateEventing(ctx context.Context, clientSetS, clientSetD kubernetes.Interface, clientS, clientD dynamic.Interface, namespaceS, namespaceD string, services []string, options *MigrationOptions) ([]string, error) {
+	migrated := map[string]bool{}
+	for _, name := range services {
+		migrated[name] = true
+	}
+	copied := []string{}
+	for _, resource := range eventingResources {
+		objects, err := listEventingObjects(ctx, clientS, resource, namespaceS)
+		if err != nil {
+			return copied, sourceError(err)
+		}
+		for _, object := range objects {
+			for _, service := range referencedServices(object) {
+				if !migrated[service] {
+					options.warn("%s %s delivers to service %s which is not migrated", resource.Kind, object.GetName(), service)
+				}
+			}
+			if account := eventingServiceAccount(resource.Kind, object); account != "" {
+				accountCopied, err := copyServiceAccount(ctx, clientSetS, clientSetD, namespaceS, namespaceD, account, options)
+				copied = append(copied, accountCopied...)
+				if err != nil {
+					return copied, err
+				}
+			}
+			applied, err := applyEventingObject(ctx, clientD, resource, buildEventingObject(object, namespaceS, namespaceD), options)
+			if err != nil {
+				return copied, err
+			}
+			if applied {
+				copied = append(copied, resource.Kind+" "+object.GetName())
+			}
+		}
+	}
+	return copied, nil
+}
+
+// applyEventingObject creates an eventing object in the destination and returns false if an identical object was kept
+func applyEventingObject(ctx context.Context, client dynamic.Interface, resource eventingResource, built *unstructured.Unstructured, options *MigrationOptions) (bool, error) {
+	built.SetAnnotations(options.withOwnership(built.GetAnnotations()))
+	objects := client.Resource(resource.Resource).Namespace(built.GetNamespace())
+	existing, err := objects.Get(ctx, built.GetName(), metav1.GetOptions{})
+	switch {
+	case api_errors.IsNotFound(err):
+		err = options.paced(ctx, "create "+resource.Resource.Resource+" "+built.GetName(), func() error {
+			_, err := objects.Create(ctx, built, metav1.CreateOptions{})
+			return destinationError(err)
+		})
+		if err != nil {
+			return false, err
+		}
+		options.changes().createdDynamic(resource.Kind, resource.Resource, built.GetNamespace(), built.GetName(), client)
+		fmt.Fprintln(options.out(), "Migrated", strings.ToLower(resource.Kind), color.CyanString(built.GetName()), "Successfully")
+		return true, nil
+	case err != nil:
+		return false, err
+	case equality.Semantic.DeepEqual(existing.Object["spec"], built.Object["spec"]):
+		fmt.Fprintln(options.out(), resource.Kind, color.CyanString(built.GetName()), "already exists in the destination with the same spec, skip migrate", strings.ToLower(resource.Kind))
+		return false, nil
+	case !options.forces(ForceEventing):
+		return false, fmt.Errorf("cannot migrate %s %s: it already exists in the destination with a different spec, use --force or --force-scope eventing to replace it", resource.Kind, built.GetName())
+	}
+	if err := options.checkOwnership(resource.Kind, built.GetName(), existing.GetAnnotations()); err != nil {
+		return false, err
+	}
+
+	built.SetResourceVersion(existing.GetResourceVersion())
+	err = options.paced(ctx, "update "+resource.Resource.Resource+" "+built.GetName(), func() error {
+		_, err := objects.Update(ctx, built, metav1.UpdateOptions{})
+		return destinationError(err)
+	})
+	if err != nil {
+		return false, err
+	}
+	options.changes().updatedDynamic(resource.Kind, resource.Resource, existing, client)
+	fmt.Fprintln(options.out(), "Replaced", strings.ToLower(resource.Kind), color.CyanString(built.GetName()), "Successfully")
+	return true, nil
+}
+
+// planEventing adds the eventing objects of the namespace of a plan
+func planEventing(ctx context.Context, plan *migrationPlan, clientS, clientD dynamic.Interface, options *MigrationOptions) error {
+	for _, resource := range eventingResources {
+		objects, err := listEventingObjects(ctx, clientS, resource, plan.SourceNamespace)
+		if err != nil {
+			return err
+		}
+		for _, object := range objects {
+			built := buildEventingObject(object, plan.SourceNamespace, plan.DestinationNamespace)
+			existing, err := clientD.Resource(resource.Resource).Namespace(plan.DestinationNamespace).Get(ctx, object.GetName(), metav1.GetOptions{})
+			switch {
+			case api_errors.IsNotFound(err):
+				plan.add(planEntry{Kind: resource.Kind, Name: object.GetName(), Namespace: plan.DestinationNamespace, Cluster: "destination", Action: planActionCreate})
+			case err != nil:
+				return err
+			case equality.Semantic.DeepEqual(existing.Object["spec"], built.Object["spec"]):
+				plan.add(planEntry{Kind: resource.Kind, Name: object.GetName(), Namespace: plan.DestinationNamespace, Cluster: "destination", Action: planActionSkip, Reason: "already exists with the same spec"})
+			case options.forces(ForceEventing):
+				plan.add(planEntry{Kind: resource.Kind, Name: object.GetName(), Namespace: plan.DestinationNamespace, Cluster: "destination", Action: planActionReplace, Reason: "already exists and eventing objects are forced"})
+			default:
+				plan.add(planEntry{Kind: resource.Kind, Name: object.GetName(), Namespace: plan.DestinationNamespace, Cluster: "destination", Action: planActionConflict, Reason: "already exists with a different spec and eventing objects are not forced"})
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"testing"
+
+	"gotest.tools/assert"
+	apiv1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamic_fake "k8s.io/client-go/dynamic/fake"
+	k8s_fake "k8s.io/client-go/kubernetes/fake"
+	k8s_testing "k8s.io/client-go/testing"
+)
+
+func newEventingClient(objects ...runtime.Object) *dynamic_fake.FakeDynamicClient {
+	listKinds := map[schema.GroupVersionResource]string{}
+	for _, resource := range eventingResources {
+		listKinds[resource.Resource] = resource.Kind + "List"
+	}
+	return dynamic_fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds, objects...)
+}
+
+func newEventingObject(kind, name, namespace string, spec map[string]interface{}) *unstructured.Unstructured {
+	object := &unstructured.Unstructured{Object: map[string]interface{}{
+		"kind": kind,
+		"spec": spec,
+	}}
+	for _, resource := range eventingResources {
+		if resource.Kind == kind {
+			object.SetAPIVersion(resource.Resource.GroupVersion().String())
+		}
+	}
+	object.SetName(name)
+	object.SetNamespace(namespace)
+	object.SetResourceVersion("42")
+	return object
+}
+
+func newTrigger(name, namespace, service string) *unstructured.Unstructured {
+	return newEventingObject("Trigger", name, namespace, map[string]interface{}{
+		"broker": "default",
+		"subscriber": map[string]interface{}{
+			"ref": map[string]interface{}{"apiVersion": "serving.knative.dev/v1", "kind": "Service", "name": service, "namespace": namespace},
+		},
+	})
+}
+
+func TestMigrateEventing(t *testing.T) {
+	brokers := eventingResources[0].Resource
+	triggers := eventingResources[3].Resource
+	clientS := newEventingClient(
+		newEventingObject("Broker", "default", "default", map[string]interface{}{}),
+		newTrigger("on-order", "default", "hello"),
+		newTrigger("on-audit", "default", "audit"),
+	)
+	clientD := newEventingClient()
+	options := NewMigrationOptions()
+	options.RollbackOnFailure = true
+
+	copied, err := migrateEventing(context.Background(), k8s_fake.NewSimpleClientset(), k8s_fake.NewSimpleClientset(), clientS, clientD, "default", "prod", []string{"hello"}, options)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, copied, []string{"Broker default", "Trigger on-audit", "Trigger on-order"})
+	trigger, err := clientD.Resource(triggers).Namespace("prod").Get(context.Background(), "on-order", metav1.GetOptions{})
+	assert.NilError(t, err)
+	namespace, _, _ := unstructured.NestedString(trigger.Object, "spec", "subscriber", "ref", "namespace")
+	assert.Equal(t, namespace, "prod")
+
+	// Objects of the destination with the same spec are kept
+	copied, err = migrateEventing(context.Background(), k8s_fake.NewSimpleClientset(), k8s_fake.NewSimpleClientset(), clientS, clientD, "default", "prod", []string{"hello"}, options)
+	assert.NilError(t, err)
+	assert.Equal(t, len(copied), 0)
+
+	// A Trigger of the destination with a different spec is only replaced when forced
+	_, err = clientD.Resource(triggers).Namespace("prod").Update(context.Background(), newTrigger("on-order", "prod", "other"), metav1.UpdateOptions{})
+	assert.NilError(t, err)
+	_, err = migrateEventing(context.Background(), k8s_fake.NewSimpleClientset(), k8s_fake.NewSimpleClientset(), clientS, clientD, "default", "prod", []string{"hello"}, options)
+	assert.ErrorContains(t, err, "--force-scope eventing")
+
+	plan := &migrationPlan{SourceNamespace: "default", DestinationNamespace: "prod"}
+	assert.NilError(t, planEventing(context.Background(), plan, clientS, clientD, options))
+	assert.Equal(t, len(plan.Entries), 3)
+	assert.Equal(t, plan.Entries[2].Action, planActionConflict)
+
+	options.Force = true
+	options.ForceScope = ForceScope{ForceEventing}
+	options.Adopt = true
+	copied, err = migrateEventing(context.Background(), k8s_fake.NewSimpleClientset(), k8s_fake.NewSimpleClientset(), clientS, clientD, "default", "prod", []string{"hello"}, options)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, copied, []string{"Trigger on-order"})
+
+	// Rolling back restores the replaced Trigger and deletes the created objects
+	assert.NilError(t, options.Rollback(context.Background()))
+	_, err = clientD.Resource(brokers).Namespace("prod").Get(context.Background(), "default", metav1.GetOptions{})
+	assert.ErrorContains(t, err, "not found")
+}
+
+func TestMigrateSequence(t *testing.T) {
+	serviceRef := func(name string) map[string]interface{} {
+		return map[string]interface{}{"ref": map[string]interface{}{"apiVersion": "serving.knative.dev/v1", "kind": "Service", "name": name, "namespace": "default"}}
+	}
+	sequence := newEventingObject("Sequence", "pipeline", "default", map[string]interface{}{
+		"steps": []interface{}{serviceRef("parse"), serviceRef("enrich")},
+		"reply": serviceRef("store"),
+	})
+	// The Channels wiring the steps are created by the Sequence in the destination
+	channel := newEventingObject("Channel", "pipeline-kn-sequence-0", "default", map[string]interface{}{})
+	channel.SetOwnerReferences([]metav1.OwnerReference{{APIVersion: "flows.knative.dev/v1", Kind: "Sequence", Name: "pipeline", Controller: &[]bool{true}[0]}})
+	assert.DeepEqual(t, referencedServices(*sequence), []string{"enrich", "parse", "store"})
+
+	clientD := newEventingClient()
+	copied, err := migrateEventing(context.Background(), k8s_fake.NewSimpleClientset(), k8s_fake.NewSimpleClientset(), newEventingClient(sequence, channel), clientD, "default", "prod", []string{"parse", "enrich", "store"}, NewMigrationOptions())
+	assert.NilError(t, err)
+	assert.DeepEqual(t, copied, []string{"Sequence pipeline"})
+	migrated, err := clientD.Resource(eventingResources[4].Resource).Namespace("prod").Get(context.Background(), "pipeline", metav1.GetOptions{})
+	assert.NilError(t, err)
+	steps, _, _ := unstructured.NestedSlice(migrated.Object, "spec", "steps")
+	assert.Equal(t, steps[1].(map[string]interface{})["ref"].(map[string]interface{})["namespace"], "prod")
+	namespace, _, _ := unstructured.NestedString(migrated.Object, "spec", "reply", "ref", "namespace")
+	assert.Equal(t, namespace, "prod")
+}
+
+func TestMigrateEventingNotInstalled(t *testing.T) {
+	clientS := newEventingClient()
+	clientS.PrependReactor("list", "*", func(action k8s_testing.Action) (bool, runtime.Object, error) {
+		return true, nil, api_errors.NewNotFound(action.GetResource().GroupResource(), "")
+	})
+	copied, err := migrateEventing(context.Background(), k8s_fake.NewSimpleClientset(), k8s_fake.NewSimpleClientset(), clientS, newEventingClient(), "default", "prod", nil, NewMigrationOptions())
+	assert.NilError(t, err)
+	assert.Equal(t, len(copied), 0)
+}
+
+func TestMigrateApiServerSource(t *testing.T) {
+	source := newEventingObject("ApiServerSource", "events", "default", map[string]interface{}{
+		"serviceAccountName": "events-sa",
+		"mode":               "Reference",
+		"resources":          []interface{}{map[string]interface{}{"apiVersion": "v1", "kind": "Event"}},
+		"sink":               map[string]interface{}{"ref": map[string]interface{}{"apiVersion": "serving.knative.dev/v1", "kind": "Service", "name": "display", "namespace": "default"}},
+	})
+	clientSetS := k8s_fake.NewSimpleClientset(
+		&apiv1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "events-sa", Namespace: "default"}},
+		&rbacv1.Role{ObjectMeta: metav1.ObjectMeta{Name: "event-watcher", Namespace: "default"}, Rules: []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"events"}, Verbs: []string{"get", "list", "watch"}}}},
+		&rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: "events-sa-watcher", Namespace: "default"},
+			RoleRef:    rbacv1.RoleRef{APIGroup: "rbac.authorization.k8s.io", Kind: "Role", Name: "event-watcher"},
+			Subjects:   []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: "events-sa", Namespace: "default"}},
+		},
+		&rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: "unrelated", Namespace: "default"},
+			RoleRef:    rbacv1.RoleRef{APIGroup: "rbac.authorization.k8s.io", Kind: "ClusterRole", Name: "view"},
+			Subjects:   []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: "default", Namespace: "default"}},
+		},
+	)
+	clientSetD := k8s_fake.NewSimpleClientset()
+	clientD := newEventingClient()
+	options := NewMigrationOptions()
+	options.RollbackOnFailure = true
+
+	copied, err := migrateEventing(context.Background(), clientSetS, clientSetD, newEventingClient(source), clientD, "default", "prod", []string{"display"}, options)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, copied, []string{"ServiceAccount events-sa", "Role event-watcher", "RoleBinding events-sa-watcher", "ApiServerSource events"})
+	binding, err := clientSetD.RbacV1().RoleBindings("prod").Get(context.Background(), "events-sa-watcher", metav1.GetOptions{})
+	assert.NilError(t, err)
+	assert.Equal(t, binding.Subjects[0].Namespace, "prod")
+	migrated, err := clientD.Resource(eventingResources[7].Resource).Namespace("prod").Get(context.Background(), "events", metav1.GetOptions{})
+	assert.NilError(t, err)
+	namespace, _, _ := unstructured.NestedString(migrated.Object, "spec", "sink", "ref", "namespace")
+	assert.Equal(t, namespace, "prod")
+
+	assert.NilError(t, options.Rollback(context.Background()))
+	_, err = clientSetD.CoreV1().ServiceAccounts("prod").Get(context.Background(), "events-sa", metav1.GetOptions{})
+	assert.ErrorContains(t, err, "not found")
+}
@@ -0,0 +1,257 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	apiv1 "k8s.io/api/core/v1"
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"knative.dev/kn-plugin-migration/pkg/command"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+type exportCmdFlags struct {
+	Namespace       string
+	KubeConfig      string
+	Context         string
+	Output          string
+	Services        []string
+	Selector        string
+	Exclude         []string
+	ExcludeSelector string
+	EncryptWith     string
+	Format          string
+	Overlays        []string
+}
+
+var exportFlags exportCmdFlags
+
+// NewExportCommand represents the 'migrate export' command
+func NewExportCommand() *cobra.Command {
+	exportCmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export Knative services of a namespace to a directory of YAML files",
+		Long: `Export Knative services of a namespace to a directory of YAML files.
+
+Services, their revisions and configmaps are written one file per resource,
+together with an index.yaml manifest listing every file of the bundle.
+
+The secrets referenced by the services are only exported to bundles encrypted
+with --encrypt-with, either for an age recipient or with the passphrase of the
+` + BundlePassphraseEnv + ` environment variable.
+
+With --format kustomize, the services and configmaps are written to a kustomize
+base instead, with an overlay per --overlay environment setting the namespace
+and the images of the services, to be committed to Git and applied by a GitOps
+tool such as Argo CD or Flux. With --format helm, they are written as the templates
+of a Helm chart, with values for their namespace, image registry and domain.`,
+		Example: `
+  # Export all Knative services of the default namespace to the bundle directory
+  kn migrate export --namespace default --output ./bundle/
+  # Export only the services labeled with team=payments
+  kn migrate export --namespace default --output ./bundle/ -l team=payments
+  # Export the services to a kustomize base with overlays for the staging and prod namespaces
+  kn migrate export --namespace default --output ./deploy/ --format kustomize --overlay staging --overlay prod=payments-prod
+  # Export the services as a Helm chart
+  kn migrate export --namespace default --output ./charts/default/ --format helm
+  # Export the services with their secrets, encrypted for an age recipient
+  kn migrate export --namespace default --output ./bundle/ --encrypt-with age:age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p`,
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := command.NewCommandContext(cmd)
+			defer cancel()
+
+			kubeConfig := exportFlags.KubeConfig
+			if kubeConfig == "" {
+				kubeConfig = os.Getenv("KUBECONFIG")
+			}
+			if kubeConfig == "" {
+				return fmt.Errorf("cannot get source cluster kube config, please use --kubeconfig or export environment variable KUBECONFIG to set")
+			}
+			if exportFlags.Namespace == "" {
+				return fmt.Errorf("cannot get source cluster namespace, please use --namespace to set")
+			}
+			if exportFlags.Output == "" {
+				return fmt.Errorf("cannot get the bundle directory, please use --output to set")
+			}
+
+			err := validateExportFormat(exportFlags.Format)
+			if err != nil {
+				return err
+			}
+			if exportFlags.Format != ExportFormatBundle && exportFlags.EncryptWith != "" {
+				return fmt.Errorf("cannot encrypt a %s export, --encrypt-with only applies to bundles", exportFlags.Format)
+			}
+			if exportFlags.Format != ExportFormatKustomize && len(exportFlags.Overlays) > 0 {
+				return fmt.Errorf("--overlay only applies to --format kustomize")
+			}
+			overlays, err := parseOverlays(exportFlags.Overlays, exportFlags.Namespace)
+			if err != nil {
+				return err
+			}
+			key, err := parseBundleKey(exportFlags.EncryptWith)
+			if err != nil {
+				return err
+			}
+			filter, err := newServiceFilter(exportFlags.Services, exportFlags.Selector)
+			if err != nil {
+				return err
+			}
+			err = filter.exclude(exportFlags.Exclude, exportFlags.ExcludeSelector)
+			if err != nil {
+				return err
+			}
+			cluster := clusterConfig{KubeConfig: kubeConfig, Context: exportFlags.Context}
+			clientSet, servingClient, err := getClusterClients(cluster)
+			if err != nil {
+				return err
+			}
+			discovery, err := newDiscoveryCache(cluster, defaultDiscoveryCacheDir(), DefaultDiscoveryCacheTTL)
+			if err != nil {
+				return err
+			}
+			servingClient, err = sourceServingClient(cmd.OutOrStdout(), cluster, discovery, servingClient)
+			if err != nil {
+				return err
+			}
+			migrationClient := command.NewMigrationClient(servingClient, exportFlags.Namespace)
+
+			switch exportFlags.Format {
+			case ExportFormatKustomize:
+				err = exportKustomize(ctx, cmd.OutOrStdout(), clientSet, migrationClient, exportFlags.Namespace, filter, exportFlags.Output, overlays)
+			case ExportFormatHelm:
+				err = exportHelm(ctx, cmd.OutOrStdout(), clientSet, migrationClient, exportFlags.Namespace, filter, exportFlags.Output)
+			default:
+				err = exportNamespace(ctx, cmd.OutOrStdout(), clientSet, migrationClient, exportFlags.Namespace, filter, exportFlags.Output, key)
+			}
+			if err != nil {
+				return fmt.Errorf("cannot export namespace %s: %w", exportFlags.Namespace, err)
+			}
+			return nil
+		},
+	}
+
+	exportCmd.Flags().StringVarP(&exportFlags.Namespace, "namespace", "n", "", "The namespace of the source Knative resources")
+	exportCmd.Flags().StringVar(&exportFlags.KubeConfig, "kubeconfig", "", "The kubeconfig of the Knative resources (default is KUBECONFIG from environment variable)")
+	exportCmd.Flags().StringVar(&exportFlags.Context, "context", "", "The context of the kubeconfig of the Knative resources (default is the current context)")
+	exportCmd.Flags().StringVarP(&exportFlags.Output, "output", "o", "", "The directory to write the bundle to")
+	exportCmd.Flags().StringSliceVar(&exportFlags.Services, "service", nil, "The names or glob patterns of the services to export, comma separated or repeated (default is all services of the namespace)")
+	exportCmd.Flags().StringVarP(&exportFlags.Selector, "selector", "l", "", "The label selector of the services to export, e.g. team=payments")
+	exportCmd.Flags().StringSliceVar(&exportFlags.Exclude, "exclude", nil, "The names or glob patterns of the services not to export, with their configmap and secrets, comma separated or repeated")
+	exportCmd.Flags().StringVar(&exportFlags.ExcludeSelector, "exclude-selector", "", "The label selector of the services not to export, e.g. lifecycle=decommissioned")
+	exportCmd.Flags().StringVar(&exportFlags.EncryptWith, "encrypt-with", "", "Encrypt the bundle and export the secrets of the services, either age:<recipient> or passphrase to use the passphrase of the "+BundlePassphraseEnv+" environment variable")
+	exportCmd.Flags().StringVar(&exportFlags.Format, "format", ExportFormatBundle, "The format of the export, one of: bundle, kustomize, helm")
+	exportCmd.Flags().StringSliceVar(&exportFlags.Overlays, "overlay", nil, "The kustomize overlays to write with --format kustomize as <name>=<namespace>, or <name> for the namespace of the same name, comma separated or repeated (default is a destination overlay for the source namespace)")
+	return exportCmd
+}
+
+// exportNamespace writes the selected services of the namespace with their revisions and configmaps to a bundle,
+// and their secrets when the bundle is encrypted with the key
+func exportNamespace(ctx context.Context, out io.Writer, clientSet kubernetes.Interface, migrationClient command.MigrationClient, namespace string, filter *serviceFilter, dir string, key *bundleKey) error {
+	writer, err := newBundleWriter(dir, namespace, key)
+	if err != nil {
+		return err
+	}
+	exportedSecrets := map[string]bool{}
+	skippedSecrets := 0
+
+	services, err := listSourceServices(ctx, migrationClient, filter)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(services.Items); i++ {
+		service := services.Items[i]
+
+		configmap, err := getConfigmap(ctx, clientSet, namespace, generateConfigmapName(service.Name))
+		if err != nil && !api_errors.IsNotFound(err) {
+			return err
+		}
+		if configmap != nil {
+			configmap.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"}
+			configmap.ManagedFields = nil
+			err = writer.write("ConfigMap", configmap.Name, service.Name, configmap)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, name := range referencedSecrets(service.Spec.Template) {
+			if exportedSecrets[name] {
+				continue
+			}
+			exportedSecrets[name] = true
+			if !writer.encrypted() {
+				skippedSecrets++
+				continue
+			}
+			secret, err := getSecret(ctx, clientSet, namespace, name)
+			if api_errors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if secret.Type == apiv1.SecretTypeServiceAccountToken {
+				continue
+			}
+			secret.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"}
+			secret.ManagedFields = nil
+			err = writer.write("Secret", secret.Name, service.Name, secret)
+			if err != nil {
+				return err
+			}
+		}
+
+		service.TypeMeta = metav1.TypeMeta{APIVersion: serving_v1_api.SchemeGroupVersion.String(), Kind: "Service"}
+		service.ManagedFields = nil
+		err = writer.write("Service", service.Name, service.Name, service)
+		if err != nil {
+			return err
+		}
+
+		revisions, err := migrationClient.ListRevisionByService(ctx, service.Name)
+		if err != nil {
+			return err
+		}
+		for j := 0; j < len(revisions.Items); j++ {
+			revision := revisions.Items[j]
+			revision.TypeMeta = metav1.TypeMeta{APIVersion: serving_v1_api.SchemeGroupVersion.String(), Kind: "Revision"}
+			revision.ManagedFields = nil
+			err = writer.write("Revision", revision.Name, service.Name, revision)
+			if err != nil {
+				return err
+			}
+		}
+		fmt.Fprintln(out, "Exported service", color.CyanString(service.Name), "with", len(revisions.Items), "revision(s)")
+	}
+
+	err = writer.close()
+	if err != nil {
+		return err
+	}
+	if skippedSecrets > 0 {
+		fmt.Fprintln(out, color.YellowString("The %d secret(s) referenced by the services are not written to %s since the bundle is not encrypted", skippedSecrets, dir))
+	}
+	fmt.Fprintln(out, "Exported", color.CyanString("%d", len(services.Items)), "service(s) of namespace", color.BlueString(namespace), "to", dir)
+	return nil
+}
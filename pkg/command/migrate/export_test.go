@@ -0,0 +1,63 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"gotest.tools/assert"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestExportImportRoundTrip(t *testing.T) {
+	seed := simulatedBundle("default", "bye", "hello")
+	seed.configmaps = map[string]*apiv1.ConfigMap{
+		"hello-config": {ObjectMeta: metav1.ObjectMeta{Name: "hello-config", Namespace: "default"}, Data: map[string]string{"key": "value"}},
+	}
+	clientSetS, migrationClientS := newSimulatedDestination("default", seed)
+	filter, err := newServiceFilter(nil, "")
+	assert.NilError(t, err)
+
+	dir := t.TempDir()
+	assert.NilError(t, exportNamespace(context.Background(), io.Discard, clientSetS, migrationClientS, "default", filter, dir, nil))
+	source, err := readBundle(dir, nil)
+	assert.NilError(t, err)
+	assert.Equal(t, source.Namespace(), "default")
+
+	clientSetD, migrationClientD := newSimulatedDestination("prod", &bundleSource{})
+	options := NewMigrationOptions()
+	options.Out = io.Discard
+	report := newMigrationReport()
+	migrated, err := migrateNamespace(context.Background(), source, clientSetD, migrationClientD, "prod", filter, options, report.namespace("default", "prod"))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, migrated, []string{"bye", "hello"})
+	assert.NilError(t, report.conclude(context.Background(), nil, options))
+
+	// The imported services have the revisions and configmap of the exported ones
+	for _, name := range []string{"bye", "hello"} {
+		revisionsS, err := migrationClientS.ListRevisionByService(context.Background(), name)
+		assert.NilError(t, err)
+		revisionsD, err := migrationClientD.ListRevisionByService(context.Background(), name)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, revisionNames(revisionsS), []string{name + "-00001", name + "-00002"})
+		assert.DeepEqual(t, revisionNames(revisionsD), revisionNames(revisionsS))
+	}
+	configmap, err := clientSetD.CoreV1().ConfigMaps("prod").Get(context.Background(), "hello-config", metav1.GetOptions{})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, configmap.Data, map[string]string{"key": "value"})
+}
@@ -0,0 +1,187 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	serving_v1_client "knative.dev/serving/pkg/client/clientset/versioned/typed/serving/v1"
+	serving_v1beta1_client "knative.dev/serving/pkg/client/clientset/versioned/typed/serving/v1beta1"
+)
+
+// migrationTarget is a destination cluster of a run with its clients, a run replicates the source namespaces to
+// several targets one after the other when several destinations are given
+type migrationTarget struct {
+	cluster       clusterConfig
+	clientSet     kubernetes.Interface
+	servingClient serving_v1_client.ServingV1Interface
+	// domainMappings is nil unless the DomainMappings are migrated
+	domainMappings serving_v1beta1_client.ServingV1beta1Interface
+	dynamic        dynamic.Interface
+}
+
+// newMigrationTarget creates the clients of a destination cluster and checks that it serves the migrated resources
+func newMigrationTarget(out io.Writer, cluster clusterConfig, cacheDir string, cacheTTL time.Duration, includeDomainMappings, includeEventing bool) (*migrationTarget, error) {
+	target := &migrationTarget{cluster: cluster}
+	var err error
+	target.clientSet, target.servingClient, err = getClusterClients(cluster)
+	if err != nil {
+		return nil, err
+	}
+	cache, err := newDiscoveryCache(cluster, cacheDir, cacheTTL)
+	if err != nil {
+		return nil, err
+	}
+	err = checkDestinationAPIs(out, cache, includeDomainMappings, includeEventing)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", cluster, err)
+	}
+	if includeDomainMappings {
+		target.domainMappings, err = getDomainMappingClient(cluster)
+		if err != nil {
+			return nil, err
+		}
+	}
+	target.dynamic, err = getDynamicClient(cluster)
+	if err != nil {
+		return nil, err
+	}
+	return target, nil
+}
+
+// migrateDestinations returns the destination clusters of the migrate command. Several --destination-profile
+// give a destination each, else the repeated --destination-kubeconfig and --destination-context are paired by
+// destinationClusters. A single profile sets the destination flags which are not given, as for the other commands.
+func migrateDestinations(cmd *cobra.Command, flags *migrateCmdFlags) ([]clusterConfig, error) {
+	if len(flags.DestinationProfiles) <= 1 {
+		destination := ""
+		if len(flags.DestinationProfiles) == 1 {
+			destination = flags.DestinationProfiles[0]
+		}
+		if err := applyProfiles(cmd, flags.SourceProfile, destination); err != nil {
+			return nil, err
+		}
+		return destinationClusters(flags.DestinationKubeConfigs, flags.DestinationContexts)
+	}
+
+	if err := applyProfiles(cmd, flags.SourceProfile, ""); err != nil {
+		return nil, err
+	}
+	if len(flags.DestinationKubeConfigs) > 0 || len(flags.DestinationContexts) > 0 {
+		return nil, fmt.Errorf("several --destination-profile cannot be combined with --destination-kubeconfig or --destination-context")
+	}
+	destinations := []clusterConfig{}
+	namespace := ""
+	for _, name := range flags.DestinationProfiles {
+		profile, err := loadProfile(name)
+		if err != nil {
+			return nil, err
+		}
+		if profile.Namespace != "" && namespace != "" && profile.Namespace != namespace {
+			return nil, fmt.Errorf("the destination profiles name different namespaces, %s and %s, use --destination-namespace", namespace, profile.Namespace)
+		}
+		if profile.Namespace != "" {
+			namespace = profile.Namespace
+		}
+		destinations = append(destinations, clusterConfig{KubeConfig: profile.KubeConfig, Context: profile.Context})
+	}
+	err := setUnchangedFlags(cmd, map[string]string{"destination-namespace": namespace})
+	return destinations, err
+}
+
+// destinationClusters pairs the repeated --destination-kubeconfig and --destination-context flags into destination
+// clusters: a single kubeconfig is shared by every context, a single context is looked up in every kubeconfig, else
+// the n-th kubeconfig is paired with the n-th context. No flag gives a single destination from the environment.
+func destinationClusters(kubeconfigs, contexts []string) ([]clusterConfig, error) {
+	destinations := []clusterConfig{}
+	switch {
+	case len(kubeconfigs) <= 1:
+		kubeconfig := ""
+		if len(kubeconfigs) == 1 {
+			kubeconfig = kubeconfigs[0]
+		}
+		for _, context := range contexts {
+			destinations = append(destinations, clusterConfig{KubeConfig: kubeconfig, Context: context})
+		}
+		if len(contexts) == 0 {
+			destinations = append(destinations, clusterConfig{KubeConfig: kubeconfig})
+		}
+	case len(contexts) <= 1:
+		context := ""
+		if len(contexts) == 1 {
+			context = contexts[0]
+		}
+		for _, kubeconfig := range kubeconfigs {
+			destinations = append(destinations, clusterConfig{KubeConfig: kubeconfig, Context: context})
+		}
+	case len(kubeconfigs) == len(contexts):
+		for i := range kubeconfigs {
+			destinations = append(destinations, clusterConfig{KubeConfig: kubeconfigs[i], Context: contexts[i]})
+		}
+	default:
+		return nil, fmt.Errorf("cannot pair %d --destination-kubeconfig with %d --destination-context, give one of them once or both as many times", len(kubeconfigs), len(contexts))
+	}
+	return destinations, nil
+}
+
+// validateFanOut rejects the options which cannot apply to several destinations
+func validateFanOut(flags *migrateCmdFlags, destinations []clusterConfig) error {
+	seen := map[string]bool{}
+	for _, destination := range destinations {
+		if seen[destination.String()] {
+			return fmt.Errorf("the destination %s is given twice", destination)
+		}
+		seen[destination.String()] = true
+	}
+	if len(destinations) < 2 {
+		return nil
+	}
+	unsupported := []struct {
+		flag string
+		set  bool
+	}{
+		{"--delete", flags.Delete},
+		{"--endpoints-file", flags.EndpointsFile != ""},
+		{"--owner-annotation", flags.OwnerAnnotation != ""},
+		{"--dashboard-addr", flags.DashboardAddr != ""},
+	}
+	for _, option := range unsupported {
+		if option.set {
+			return fmt.Errorf("%s cannot be used with several destinations", option.flag)
+		}
+	}
+	return nil
+}
+
+// destinationCheckpointFile returns the checkpoint file of a destination of a fan-out, so that each destination
+// resumes from its own progress, e.g. checkpoint-config-prod-eu.yaml for the context prod-eu of config
+func destinationCheckpointFile(file string, destination clusterConfig) string {
+	if file == "" {
+		return ""
+	}
+	name := filepath.Base(destination.KubeConfig)
+	if destination.Context != "" {
+		name += "-" + destination.Context
+	}
+	extension := filepath.Ext(file)
+	return strings.TrimSuffix(file, extension) + "-" + unsafeHostCharacters.ReplaceAllString(name, "_") + extension
+}
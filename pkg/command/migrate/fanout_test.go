@@ -0,0 +1,109 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+func TestDestinationClusters(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		kubeconfigs []string
+		contexts    []string
+		expected    []string
+		err         string
+	}{
+		{name: "environment", expected: []string{""}},
+		{name: "single", kubeconfigs: []string{"prod.yml"}, contexts: []string{"eu"}, expected: []string{"prod.yml (context eu)"}},
+		{name: "shared kubeconfig", kubeconfigs: []string{"prod.yml"}, contexts: []string{"eu", "us"}, expected: []string{"prod.yml (context eu)", "prod.yml (context us)"}},
+		{name: "contexts only", contexts: []string{"eu", "us"}, expected: []string{" (context eu)", " (context us)"}},
+		{name: "kubeconfigs only", kubeconfigs: []string{"eu.yml", "us.yml"}, expected: []string{"eu.yml", "us.yml"}},
+		{name: "shared context", kubeconfigs: []string{"eu.yml", "us.yml"}, contexts: []string{"admin"}, expected: []string{"eu.yml (context admin)", "us.yml (context admin)"}},
+		{name: "pairs", kubeconfigs: []string{"eu.yml", "us.yml"}, contexts: []string{"eu", "us"}, expected: []string{"eu.yml (context eu)", "us.yml (context us)"}},
+		{name: "mismatch", kubeconfigs: []string{"eu.yml", "us.yml"}, contexts: []string{"eu", "us", "ap"}, err: "cannot pair 2 --destination-kubeconfig with 3 --destination-context"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			destinations, err := destinationClusters(tc.kubeconfigs, tc.contexts)
+			if tc.err != "" {
+				assert.ErrorContains(t, err, tc.err)
+				return
+			}
+			assert.NilError(t, err)
+			names := []string{}
+			for _, destination := range destinations {
+				names = append(names, destination.String())
+			}
+			assert.DeepEqual(t, names, tc.expected)
+		})
+	}
+}
+
+func TestValidateFanOut(t *testing.T) {
+	destinations := []clusterConfig{{KubeConfig: "prod.yml", Context: "eu"}, {KubeConfig: "prod.yml", Context: "us"}}
+	assert.NilError(t, validateFanOut(&migrateCmdFlags{}, destinations))
+	assert.ErrorContains(t, validateFanOut(&migrateCmdFlags{Delete: true}, destinations), "--delete cannot be used with several destinations")
+	assert.ErrorContains(t, validateFanOut(&migrateCmdFlags{EndpointsFile: "endpoints.yaml"}, destinations), "--endpoints-file")
+	assert.NilError(t, validateFanOut(&migrateCmdFlags{Delete: true}, destinations[:1]))
+	assert.ErrorContains(t, validateFanOut(&migrateCmdFlags{}, append(destinations, destinations[0])), "given twice")
+}
+
+func TestDestinationCheckpointFile(t *testing.T) {
+	assert.Equal(t, destinationCheckpointFile("/home/me/.kube/cache/kn-migration/checkpoint.yaml", clusterConfig{KubeConfig: "/home/me/.kube/config", Context: "prod-eu"}), "/home/me/.kube/cache/kn-migration/checkpoint-config-prod-eu.yaml")
+	assert.Equal(t, destinationCheckpointFile("run/progress.yaml", clusterConfig{KubeConfig: "us.yml"}), "run/progress-us.yml.yaml")
+	assert.Equal(t, destinationCheckpointFile("", clusterConfig{KubeConfig: "us.yml"}), "")
+
+	options := NewMigrationOptions()
+	options.CheckpointFile = t.TempDir() + "/checkpoint.yaml"
+	progress, err := options.progress()
+	assert.NilError(t, err)
+	options.startDestination(options.CheckpointFile + ".us")
+	next, err := options.progress()
+	assert.NilError(t, err)
+	assert.Assert(t, progress != next)
+}
+
+func TestFanOutReport(t *testing.T) {
+	report := newMigrationReport()
+	eu := report.namespace("default", "default")
+	eu.DestinationCluster = "prod.yml (context eu)"
+	eu.add("hello", []string{"hello-00001"}, nil, time.Second, nil)
+	eu.add("bye", []string{"bye-00001"}, nil, time.Second, nil)
+	us := report.namespace("default", "default")
+	us.DestinationCluster = "prod.yml (context us)"
+	us.add("hello", []string{"hello-00001"}, nil, time.Second, nil)
+	us.add("bye", nil, nil, time.Second, errors.New("quota exceeded"))
+	report.finish(nil)
+
+	assert.DeepEqual(t, report.Destinations, []DestinationReport{
+		{Cluster: "prod.yml (context eu)", Succeeded: true, Migrated: 2},
+		{Cluster: "prod.yml (context us)", Migrated: 1, Failed: 1},
+	})
+	out := &bytes.Buffer{}
+	printSummary(out, report)
+	assert.Assert(t, strings.Contains(out.String(), "Destination cluster"))
+	assert.Assert(t, strings.Contains(out.String(), "prod.yml (context us)"))
+
+	single := newMigrationReport()
+	single.namespace("default", "default").add("hello", nil, nil, time.Second, nil)
+	single.finish(nil)
+	assert.Assert(t, single.Destinations == nil)
+}
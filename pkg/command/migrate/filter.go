@@ -0,0 +1,196 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"knative.dev/kn-plugin-migration/pkg/command"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+// serviceFilter selects the source services to migrate by name or glob pattern
+// and by label selector, and keeps out the services excluded by name, glob pattern or label selector
+type serviceFilter struct {
+	patterns []string
+	selector string
+
+	exclusions      []string
+	excludeSelector labels.Selector
+}
+
+// newServiceFilter creates a filter from the given names, glob patterns and label selector,
+// an empty filter selects every service
+func newServiceFilter(patterns []string, selector string) (*serviceFilter, error) {
+	if _, err := labels.Parse(selector); err != nil {
+		return nil, fmt.Errorf("invalid label selector %q: %w", selector, err)
+	}
+	filter := &serviceFilter{selector: selector}
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid service pattern %q: %w", pattern, err)
+		}
+		filter.patterns = append(filter.patterns, pattern)
+	}
+	return filter, nil
+}
+
+// exclude keeps the services matching any of the names or glob patterns, or the label selector, out of the selection
+func (f *serviceFilter) exclude(patterns []string, selector string) error {
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid excluded service pattern %q: %w", pattern, err)
+		}
+		f.exclusions = append(f.exclusions, pattern)
+	}
+	if selector == "" {
+		return nil
+	}
+	excludeSelector, err := labels.Parse(selector)
+	if err != nil {
+		return fmt.Errorf("invalid exclude label selector %q: %w", selector, err)
+	}
+	f.excludeSelector = excludeSelector
+	return nil
+}
+
+// excludes returns true if the service is kept out of the selection
+func (f *serviceFilter) excludes(service serving_v1_api.Service) bool {
+	for _, pattern := range f.exclusions {
+		if ok, _ := path.Match(pattern, service.Name); ok {
+			return true
+		}
+	}
+	return f.excludeSelector != nil && f.excludeSelector.Matches(labels.Set(service.Labels))
+}
+
+// matches returns true if the service name is selected by the filter
+func (f *serviceFilter) matches(name string) bool {
+	if len(f.patterns) == 0 {
+		return true
+	}
+	for _, pattern := range f.patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// filter returns the services selected by the filter and fails if a service
+// given by its exact name does not exist, the label selector is evaluated locally too
+// so that services not listed by the API server, e.g. from a bundle, are filtered the same way
+func (f *serviceFilter) filter(services *serving_v1_api.ServiceList) (*serving_v1_api.ServiceList, error) {
+	selected := &serving_v1_api.ServiceList{TypeMeta: services.TypeMeta, ListMeta: services.ListMeta}
+	err := f.each(eachListedService(services.Items), nil, func(service *serving_v1_api.Service) error {
+		selected.Items = append(selected.Items, *service)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return selected, nil
+}
+
+// each calls fn with the services visited by list which are selected by the filter, as they are visited so that
+// the services of a cluster are filtered page by page, and fails once they are all visited if a service given by
+// its exact name does not exist or is not matched by the label selector. The services of a cluster are listed
+// with the label selector, get reads a service given by its exact name which was not listed to tell both apart.
+func (f *serviceFilter) each(list func(visit func(*serving_v1_api.Service) error) error, get func(name string) (*serving_v1_api.Service, error), fn func(*serving_v1_api.Service) error) error {
+	selector, err := labels.Parse(f.selector)
+	if err != nil {
+		return err
+	}
+	found := map[string]bool{}
+	unselected := map[string]bool{}
+	err = list(func(service *serving_v1_api.Service) error {
+		if !f.matches(service.Name) {
+			return nil
+		}
+		if !selector.Matches(labels.Set(service.Labels)) {
+			unselected[service.Name] = true
+			return nil
+		}
+		found[service.Name] = true
+		if f.excludes(*service) {
+			return nil
+		}
+		return fn(service)
+	})
+	if err != nil {
+		return err
+	}
+	for _, pattern := range f.patterns {
+		if strings.ContainsAny(pattern, "*?[") || found[pattern] {
+			continue
+		}
+		if !unselected[pattern] && get != nil && f.selector != "" {
+			_, err := get(pattern)
+			if err != nil && !api_errors.IsNotFound(err) {
+				return err
+			}
+			unselected[pattern] = err == nil
+		}
+		if unselected[pattern] {
+			return fmt.Errorf("service %s is excluded by the label selector %s", pattern, f.selector)
+		}
+		return fmt.Errorf("cannot find service %s in the source namespace", pattern)
+	}
+	return nil
+}
+
+// eachListedService visits the services of a list, e.g. the services of a bundle, in order
+func eachListedService(services []serving_v1_api.Service) func(visit func(*serving_v1_api.Service) error) error {
+	return func(visit func(*serving_v1_api.Service) error) error {
+		for i := range services {
+			service := services[i]
+			if err := visit(&service); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// listSourceServices lists the source services selected by the filter, the label selector
+// is evaluated by the API server
+func listSourceServices(ctx context.Context, migrationClient command.MigrationClient, filter *serviceFilter) (*serving_v1_api.ServiceList, error) {
+	services := &serving_v1_api.ServiceList{}
+	err := filter.each(func(visit func(*serving_v1_api.Service) error) error {
+		return migrationClient.EachService(ctx, filter.selector, visit)
+	}, func(name string) (*serving_v1_api.Service, error) {
+		return migrationClient.GetService(ctx, name)
+	}, func(service *serving_v1_api.Service) error {
+		services.Items = append(services.Items, *service)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return services, nil
+}
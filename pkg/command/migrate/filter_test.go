@@ -0,0 +1,119 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gotest.tools/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8s_fake "k8s.io/client-go/kubernetes/fake"
+	k8s_testing "k8s.io/client-go/testing"
+	"knative.dev/kn-plugin-migration/pkg/command"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+	serving_fake "knative.dev/serving/pkg/client/clientset/versioned/fake"
+)
+
+func TestServiceFilterExclude(t *testing.T) {
+	services := &serving_v1_api.ServiceList{Items: []serving_v1_api.Service{
+		{ObjectMeta: metav1.ObjectMeta{Name: "checkout"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "legacy-cart"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "reports", Labels: map[string]string{"lifecycle": "decommissioned"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "search"}},
+	}}
+
+	filter, err := newServiceFilter(nil, "")
+	assert.NilError(t, err)
+	assert.NilError(t, filter.exclude([]string{"legacy-*", "search"}, "lifecycle=decommissioned"))
+	selected, err := filter.filter(services)
+	assert.NilError(t, err)
+	assert.Equal(t, len(selected.Items), 1)
+	assert.Equal(t, selected.Items[0].Name, "checkout")
+
+	// A service given by name and excluded is not reported as missing
+	filter, err = newServiceFilter([]string{"checkout", "search"}, "")
+	assert.NilError(t, err)
+	assert.NilError(t, filter.exclude([]string{"search"}, ""))
+	selected, err = filter.filter(services)
+	assert.NilError(t, err)
+	assert.Equal(t, len(selected.Items), 1)
+
+	// A service given by name which the label selector does not match is reported as such
+	filter, err = newServiceFilter([]string{"checkout", "reports"}, "lifecycle!=decommissioned")
+	assert.NilError(t, err)
+	_, err = filter.filter(services)
+	assert.ErrorContains(t, err, "service reports is excluded by the label selector lifecycle!=decommissioned")
+	filter, err = newServiceFilter([]string{"checkout", "gone"}, "lifecycle!=decommissioned")
+	assert.NilError(t, err)
+	_, err = filter.filter(services)
+	assert.ErrorContains(t, err, "cannot find service gone in the source namespace")
+
+	assert.ErrorContains(t, filter.exclude([]string{"[legacy"}, ""), "invalid excluded service pattern")
+	assert.ErrorContains(t, filter.exclude(nil, "lifecycle in (old"), "invalid exclude label selector")
+}
+
+func TestLiveSourceEachService(t *testing.T) {
+	servingClient := serving_fake.NewSimpleClientset(
+		&serving_v1_api.Service{ObjectMeta: metav1.ObjectMeta{Name: "checkout", Namespace: "default", Labels: map[string]string{"team": "payments"}}},
+		&serving_v1_api.Service{ObjectMeta: metav1.ObjectMeta{Name: "legacy-cart", Namespace: "default", Labels: map[string]string{"team": "payments"}}},
+		&serving_v1_api.Service{ObjectMeta: metav1.ObjectMeta{Name: "search", Namespace: "default"}},
+	)
+	source := newLiveSource(k8s_fake.NewSimpleClientset(), command.NewMigrationClient(servingClient.ServingV1(), "default"), "default")
+	filter, err := newServiceFilter(nil, "team=payments")
+	assert.NilError(t, err)
+	assert.NilError(t, filter.exclude([]string{"legacy-*"}, ""))
+
+	names := []string{}
+	err = source.EachService(context.Background(), filter, func(service *serving_v1_api.Service) error {
+		names = append(names, service.Name)
+		return nil
+	})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, names, []string{"checkout"})
+
+	// The errors of fn and a missing service are not errors of the source cluster
+	failed := errors.New("failed")
+	err = source.EachService(context.Background(), filter, func(service *serving_v1_api.Service) error {
+		return failed
+	})
+	assert.Equal(t, err, failed)
+	filter, err = newServiceFilter([]string{"checkout", "gone"}, "")
+	assert.NilError(t, err)
+	err = source.EachService(context.Background(), filter, func(service *serving_v1_api.Service) error {
+		return nil
+	})
+	assert.ErrorContains(t, err, "cannot find service gone in the source namespace")
+	assert.Assert(t, !errors.Is(err, ErrSourceUnreachable))
+
+	// The API server lists the services with the label selector, a service given by name it left out is read
+	filter, err = newServiceFilter([]string{"checkout", "search"}, "team=payments")
+	assert.NilError(t, err)
+	_, err = source.ListServices(context.Background(), filter)
+	assert.ErrorContains(t, err, "service search is excluded by the label selector team=payments")
+	assert.Assert(t, !errors.Is(err, ErrSourceUnreachable))
+	_, err = listSourceServices(context.Background(), command.NewMigrationClient(servingClient.ServingV1(), "default"), filter)
+	assert.ErrorContains(t, err, "service search is excluded by the label selector team=payments")
+
+	servingClient.PrependReactor("list", "services", func(action k8s_testing.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("connection refused")
+	})
+	err = source.EachService(context.Background(), filter, func(service *serving_v1_api.Service) error {
+		return nil
+	})
+	assert.Assert(t, errors.Is(err, ErrSourceUnreachable))
+}
@@ -0,0 +1,101 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/fatih/color"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"knative.dev/serving/pkg/apis/autoscaling"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+// serviceFootprint is the steady-state footprint of a service in the destination,
+// based on the min-scale of its latest revision and the requests of its containers
+type serviceFootprint struct {
+	Name      string            `json:"name"`
+	MinScale  int64             `json:"minScale"`
+	PodCPU    resource.Quantity `json:"podCPU"`
+	PodMemory resource.Quantity `json:"podMemory"`
+	CPU       resource.Quantity `json:"cpu"`
+	Memory    resource.Quantity `json:"memory"`
+}
+
+// footprint is the steady-state footprint the migration adds to the destination cluster
+type footprint struct {
+	Services []serviceFootprint `json:"services"`
+	Pods     int64              `json:"pods"`
+	CPU      resource.Quantity  `json:"cpu"`
+	Memory   resource.Quantity  `json:"memory"`
+}
+
+// estimateFootprint estimates the pods, CPU and memory requests the services keep running
+// in the destination without any traffic; the queue-proxy sidecar is not included
+func estimateFootprint(services []serving_v1_api.Service) (*footprint, error) {
+	total := &footprint{
+		Services: []serviceFootprint{},
+		CPU:      *resource.NewMilliQuantity(0, resource.DecimalSI),
+		Memory:   *resource.NewQuantity(0, resource.BinarySI),
+	}
+	for _, service := range services {
+		minScale := int64(0)
+		if value := autoscaling.MinScaleAnnotation.Value(service.Spec.Template.Annotations); value != "" {
+			scale, err := strconv.ParseInt(value, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s annotation %q of service %s: %w", autoscaling.MinScaleAnnotationKey, value, service.Name, err)
+			}
+			minScale = scale
+		}
+
+		podCPU := resource.NewMilliQuantity(0, resource.DecimalSI)
+		podMemory := resource.NewQuantity(0, resource.BinarySI)
+		for _, container := range service.Spec.Template.Spec.Containers {
+			if cpu, ok := container.Resources.Requests[apiv1.ResourceCPU]; ok {
+				podCPU.Add(cpu)
+			}
+			if memory, ok := container.Resources.Requests[apiv1.ResourceMemory]; ok {
+				podMemory.Add(memory)
+			}
+		}
+
+		entry := serviceFootprint{
+			Name:      service.Name,
+			MinScale:  minScale,
+			PodCPU:    *podCPU,
+			PodMemory: *podMemory,
+			CPU:       *resource.NewMilliQuantity(podCPU.MilliValue()*minScale, resource.DecimalSI),
+			Memory:    *resource.NewQuantity(podMemory.Value()*minScale, resource.BinarySI),
+		}
+		total.Services = append(total.Services, entry)
+		total.Pods += minScale
+		total.CPU.Add(entry.CPU)
+		total.Memory.Add(entry.Memory)
+	}
+	return total, nil
+}
+
+// printFootprint writes the footprint estimate in a human readable table
+func printFootprint(out io.Writer, estimate *footprint) {
+	fmt.Fprintln(out, color.GreenString("[Estimated steady-state footprint in destination cluster]"))
+	color.New(color.FgCyan).Fprintf(out, "%-40s%-12s%-14s%-14s%-14s%s\n", "Service", "Min Scale", "Pod CPU", "Pod Memory", "CPU", "Memory")
+	for _, service := range estimate.Services {
+		fmt.Fprintf(out, "%-40s%-12d%-14s%-14s%-14s%s\n", service.Name, service.MinScale, service.PodCPU.String(), service.PodMemory.String(), service.CPU.String(), service.Memory.String())
+	}
+	fmt.Fprintf(out, "%-40s%-12d%-14s%-14s%-14s%s\n", "Total", estimate.Pods, "", "", estimate.CPU.String(), estimate.Memory.String())
+}
@@ -0,0 +1,58 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"testing"
+
+	"gotest.tools/assert"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+func newFootprintService(name, minScale, cpu, memory string) serving_v1_api.Service {
+	service := serving_v1_api.Service{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	if minScale != "" {
+		service.Spec.Template.Annotations = map[string]string{"autoscaling.knative.dev/min-scale": minScale}
+	}
+	service.Spec.Template.Spec.Containers = []apiv1.Container{{
+		Resources: apiv1.ResourceRequirements{
+			Requests: apiv1.ResourceList{
+				apiv1.ResourceCPU:    resource.MustParse(cpu),
+				apiv1.ResourceMemory: resource.MustParse(memory),
+			},
+		},
+	}}
+	return service
+}
+
+func TestEstimateFootprint(t *testing.T) {
+	estimate, err := estimateFootprint([]serving_v1_api.Service{
+		newFootprintService("a", "2", "250m", "128Mi"),
+		newFootprintService("b", "", "1", "1Gi"),
+		newFootprintService("c", "3", "100m", "64Mi"),
+	})
+	assert.NilError(t, err)
+	assert.Equal(t, estimate.Pods, int64(5))
+	assert.Equal(t, estimate.CPU.MilliValue(), int64(800))
+	assert.Equal(t, estimate.Memory.Value(), int64(448*1024*1024))
+	assert.Equal(t, estimate.Services[1].MinScale, int64(0))
+	assert.Equal(t, estimate.Services[1].CPU.MilliValue(), int64(0))
+
+	_, err = estimateFootprint([]serving_v1_api.Service{newFootprintService("a", "x", "1", "1Gi")})
+	assert.Assert(t, err != nil)
+}
@@ -0,0 +1,83 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// ForceServices replaces the services already existing in the destination
+	ForceServices = "services"
+	// ForceConfigMaps replaces the data of the configmaps already existing in the destination
+	ForceConfigMaps = "configmaps"
+	// ForceSecrets replaces the data of the secrets already existing in the destination
+	ForceSecrets = "secrets"
+	// ForceDomainMappings replaces the DomainMappings already existing in the destination with --include-domainmappings
+	ForceDomainMappings = "domainmappings"
+	// ForceEventing replaces the eventing objects already existing in the destination with --include-eventing
+	ForceEventing = "eventing"
+)
+
+// ForceScope is the kinds of objects replaced in the destination by a forceful migration, every kind when empty
+type ForceScope []string
+
+// String implements pflag.Value
+func (s *ForceScope) String() string {
+	return strings.Join(*s, ",")
+}
+
+// Set implements pflag.Value
+func (s *ForceScope) Set(value string) error {
+	scope, err := parseForceScope(value)
+	if err != nil {
+		return err
+	}
+	*s = scope
+	return nil
+}
+
+// Type implements pflag.Value
+func (s *ForceScope) Type() string {
+	return "strings"
+}
+
+func parseForceScope(value string) (ForceScope, error) {
+	scope := ForceScope{}
+	for _, kind := range strings.Split(value, ",") {
+		kind = strings.TrimSpace(kind)
+		switch kind {
+		case ForceServices, ForceConfigMaps, ForceSecrets, ForceDomainMappings, ForceEventing:
+			scope = append(scope, kind)
+		default:
+			return nil, fmt.Errorf("unsupported force scope %q, supported scopes are: services, configmaps, secrets, domainmappings, eventing", kind)
+		}
+	}
+	return scope, nil
+}
+
+// includes returns true if the scope includes the kind, an empty scope includes every kind
+func (s ForceScope) includes(kind string) bool {
+	if len(s) == 0 {
+		return true
+	}
+	for _, included := range s {
+		if included == kind {
+			return true
+		}
+	}
+	return false
+}
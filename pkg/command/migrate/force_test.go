@@ -0,0 +1,88 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"gotest.tools/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	api_serving "knative.dev/serving/pkg/apis/serving"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+// destinationWithExtraRevision returns a destination where service hello exists with a revision the source does not have
+func destinationWithExtraRevision() *bundleSource {
+	seed := simulatedBundle("prod", "hello")
+	seed.revisions["hello"] = append(seed.revisions["hello"], serving_v1_api.Revision{ObjectMeta: metav1.ObjectMeta{
+		Name:   "hello-00007",
+		Labels: map[string]string{api_serving.ServiceLabelKey: "hello"},
+	}})
+	return seed
+}
+
+func revisionNames(list *serving_v1_api.RevisionList) []string {
+	names := []string{}
+	for _, revision := range list.Items {
+		names = append(names, revision.Name)
+	}
+	return names
+}
+
+func TestForceAppliesOverExistingService(t *testing.T) {
+	source := simulatedBundle("default", "hello")
+	source.services[0].Labels = map[string]string{"team": "a"}
+	options := NewMigrationOptions()
+	options.Force = true
+	options.Adopt = true
+	options.RollbackOnFailure = true
+	clientSetD, migrationClientD := newSimulatedDestination("prod", destinationWithExtraRevision())
+	_, _, err := migrateService(context.Background(), source, clientSetD, migrationClientD, "prod", source.services[0], options)
+	assert.NilError(t, err)
+
+	// The revisions existing only in the destination are kept
+	revisions, err := migrationClientD.ListRevisionByService(context.Background(), "hello")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, revisionNames(revisions), []string{"hello-00001", "hello-00002", "hello-00007"})
+	serviceD, err := migrationClientD.GetService(context.Background(), "hello")
+	assert.NilError(t, err)
+	assert.Equal(t, serviceD.Labels["team"], "a")
+
+	// The service applied over is restored rather than deleted on rollback
+	assert.NilError(t, options.changes().rollback(context.Background(), io.Discard))
+	serviceD, err = migrationClientD.GetService(context.Background(), "hello")
+	assert.NilError(t, err)
+	assert.Equal(t, serviceD.Labels["team"], "")
+	revisions, err = migrationClientD.ListRevisionByService(context.Background(), "hello")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, revisionNames(revisions), []string{"hello-00001", "hello-00002", "hello-00007"})
+}
+
+func TestForceRecreateDeletesExistingService(t *testing.T) {
+	source := simulatedBundle("default", "hello")
+	options := NewMigrationOptions()
+	options.Force = true
+	options.ForceRecreate = true
+	options.Adopt = true
+	clientSetD, migrationClientD := newSimulatedDestination("prod", destinationWithExtraRevision())
+	_, _, err := migrateService(context.Background(), source, clientSetD, migrationClientD, "prod", source.services[0], options)
+	assert.NilError(t, err)
+
+	revisions, err := migrationClientD.ListRevisionByService(context.Background(), "hello")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, revisionNames(revisions), []string{"hello-00001", "hello-00002"})
+}
@@ -0,0 +1,131 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/fatih/color"
+	"k8s.io/client-go/kubernetes"
+	"knative.dev/kn-plugin-migration/pkg/command"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+// DefaultGroupTimeout is the default maximum time to wait for the services of an application to be Ready with GroupBy
+const DefaultGroupTimeout = 5 * time.Minute
+
+// serviceGroup is the services of one application, the indexes of its services in the migrated service list
+type serviceGroup struct {
+	// Name is the value of the GroupBy label, empty for the services without the label
+	Name     string
+	services []int
+}
+
+// groupServices groups the services by the value of a label, sorted by value. The services without the label
+// are grouped last under an empty name.
+func groupServices(servicesS *serving_v1_api.ServiceList, label string) []serviceGroup {
+	indexes := map[string][]int{}
+	for i, service := range servicesS.Items {
+		value := service.Labels[label]
+		indexes[value] = append(indexes[value], i)
+	}
+	names := []string{}
+	for name := range indexes {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if _, ok := indexes[""]; ok {
+		names = append(names, "")
+	}
+	groups := []serviceGroup{}
+	for _, name := range names {
+		groups = append(groups, serviceGroup{Name: name, services: indexes[name]})
+	}
+	return groups
+}
+
+// migrateGroups migrates the services application by application with options.GroupBy, and returns their results
+// in the order of the services. The services of an application are migrated together and then verified Ready,
+// when one of them fails or is not Ready in time the changes made for the whole application are rolled back,
+// so that an application is never left half-migrated. The services without the label are migrated as usual.
+// Without best effort no application is started once an application failed.
+func migrateGroups(ctx context.Context, source migrationSource, clientSetD kubernetes.Interface, migrationClientD command.MigrationClient, namespaceD string, servicesS *serving_v1_api.ServiceList, options *MigrationOptions, progress *checkpoint) []serviceResult {
+	if options.GroupBy == "" {
+		return migrateServices(ctx, source, clientSetD, migrationClientD, namespaceD, servicesS, options, progress)
+	}
+
+	results := make([]serviceResult, len(servicesS.Items))
+	for _, group := range groupServices(servicesS, options.GroupBy) {
+		if ctx.Err() != nil {
+			break
+		}
+		groupS := servicesS.DeepCopy()
+		groupS.Items = nil
+		names := []string{}
+		for _, i := range group.services {
+			groupS.Items = append(groupS.Items, servicesS.Items[i])
+			names = append(names, servicesS.Items[i].Name)
+		}
+		if group.Name != "" {
+			fmt.Fprintln(options.out(), "Migrating application", color.CyanString(group.Name), "with service(s)", names)
+		}
+
+		mark := options.changes().mark()
+		groupResults := migrateServices(ctx, source, clientSetD, migrationClientD, namespaceD, groupS, options, progress)
+		var failed error
+		for _, result := range groupResults {
+			if result.err != nil {
+				failed = result.err
+				break
+			}
+		}
+		if group.Name != "" && failed == nil && ctx.Err() == nil {
+			fmt.Fprintln(options.out(), "Waiting for the services of application", color.CyanString(group.Name), "to be Ready")
+			destinationNames := make([]string, 0, len(names))
+			for _, name := range names {
+				destinationNames = append(destinationNames, options.destinationName(name))
+			}
+			failed = waitForServicesReady(ctx, migrationClientD, destinationNames, options.GroupTimeout)
+		}
+		if group.Name != "" && failed != nil && ctx.Err() == nil {
+			fmt.Fprintln(options.out(), color.RedString("Application %s failed to migrate, rolling back its services: %s", group.Name, failed.Error()))
+			if err := options.changes().rollbackSince(context.Background(), options.out(), mark); err != nil {
+				fmt.Fprintln(options.out(), err.Error())
+			}
+			for i := range groupResults {
+				if groupResults[i].started && groupResults[i].err == nil {
+					groupResults[i].err = fmt.Errorf("rolled back with application %s: %w", group.Name, failed)
+					options.dashboard.rolledBack(source.Namespace(), namespaceD, names[i], groupResults[i].err)
+				}
+			}
+			if err := progress.forget(source.Namespace(), namespaceD, names...); err != nil {
+				fmt.Fprintln(options.out(), err.Error())
+			}
+		}
+
+		for j, i := range group.services {
+			results[i] = groupResults[j]
+		}
+		if failed != nil && !options.BestEffort {
+			break
+		}
+	}
+	return results
+}
@@ -0,0 +1,578 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	apiv1 "k8s.io/api/core/v1"
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"knative.dev/kn-plugin-migration/pkg/command"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+var (
+	// ConfigMapKind, SecretKind, ServiceAccountKind, PersistentVolumeClaimKind, ServiceKind and RevisionKind are
+	// the kinds of the built-in resource handlers
+	ConfigMapKind             = apiv1.SchemeGroupVersion.WithKind("ConfigMap")
+	SecretKind                = apiv1.SchemeGroupVersion.WithKind("Secret")
+	ServiceAccountKind        = apiv1.SchemeGroupVersion.WithKind("ServiceAccount")
+	PersistentVolumeClaimKind = apiv1.SchemeGroupVersion.WithKind("PersistentVolumeClaim")
+	ServiceKind               = serving_v1_api.SchemeGroupVersion.WithKind("Service")
+	RevisionKind              = serving_v1_api.SchemeGroupVersion.WithKind("Revision")
+)
+
+// ResourceHandler migrates the objects of one kind belonging to a service. The migration of a service runs the
+// Discover of every handler, then their Transform, Apply and Verify, each phase in the order of the handlers,
+// so that a new kind of objects is migrated by registering a handler for it.
+type ResourceHandler interface {
+	// Discover returns the source objects of the service
+	Discover(ctx context.Context, migration *ServiceMigration) ([]runtime.Object, error)
+	// Transform returns the objects to write to the destination from the discovered objects
+	Transform(ctx context.Context, migration *ServiceMigration, objects []runtime.Object) ([]runtime.Object, error)
+	// Apply writes the transformed objects to the destination
+	Apply(ctx context.Context, migration *ServiceMigration, objects []runtime.Object) error
+	// Verify checks the applied objects once every handler applied its objects
+	Verify(ctx context.Context, migration *ServiceMigration, objects []runtime.Object) error
+}
+
+// ResourceHandlers are the resource handlers by kind, in the order they run
+type ResourceHandlers struct {
+	kinds    []schema.GroupVersionKind
+	handlers map[schema.GroupVersionKind]ResourceHandler
+}
+
+// NewResourceHandlers returns the built-in handlers, which copy the configmap, the secrets and the service accounts
+// of a service before the service and its revisions
+func NewResourceHandlers() *ResourceHandlers {
+	handlers := &ResourceHandlers{handlers: map[schema.GroupVersionKind]ResourceHandler{}}
+	handlers.Register(ConfigMapKind, configmapHandler{})
+	handlers.Register(SecretKind, secretHandler{})
+	handlers.Register(ServiceAccountKind, serviceAccountHandler{})
+	handlers.Register(PersistentVolumeClaimKind, persistentVolumeClaimHandler{})
+	handlers.Register(ServiceKind, serviceHandler{})
+	handlers.Register(RevisionKind, revisionHandler{})
+	return handlers
+}
+
+// Register sets the handler of a kind, a new kind runs after the kinds registered before it
+// and the handler of a registered kind is replaced in place
+func (h *ResourceHandlers) Register(kind schema.GroupVersionKind, handler ResourceHandler) {
+	if _, ok := h.handlers[kind]; !ok {
+		h.kinds = append(h.kinds, kind)
+	}
+	h.handlers[kind] = handler
+}
+
+// Unregister removes the handler of a kind, its objects are not migrated anymore
+func (h *ResourceHandlers) Unregister(kind schema.GroupVersionKind) {
+	if _, ok := h.handlers[kind]; !ok {
+		return
+	}
+	delete(h.handlers, kind)
+	for i, registered := range h.kinds {
+		if registered == kind {
+			h.kinds = append(h.kinds[:i:i], h.kinds[i+1:]...)
+			break
+		}
+	}
+}
+
+// Kinds returns the registered kinds in the order their handlers run
+func (h *ResourceHandlers) Kinds() []schema.GroupVersionKind {
+	return append([]schema.GroupVersionKind{}, h.kinds...)
+}
+
+// migrate runs the phases of every handler for a service
+func (h *ResourceHandlers) migrate(ctx context.Context, migration *ServiceMigration) error {
+	objects := make([][]runtime.Object, len(h.kinds))
+	for i, kind := range h.kinds {
+		discovered, err := h.handlers[kind].Discover(ctx, migration)
+		if err != nil {
+			return err
+		}
+		objects[i] = discovered
+	}
+	for i, kind := range h.kinds {
+		transformed, err := h.handlers[kind].Transform(ctx, migration, objects[i])
+		if err != nil {
+			return err
+		}
+		objects[i] = transformed
+	}
+	for i, kind := range h.kinds {
+		if err := h.handlers[kind].Apply(ctx, migration, objects[i]); err != nil {
+			return err
+		}
+	}
+	for i, kind := range h.kinds {
+		if err := h.handlers[kind].Verify(ctx, migration, objects[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ServiceMigration is the state of the migration of a service shared by the resource handlers
+type ServiceMigration struct {
+	// SourceName is the name of the service in the source
+	SourceName string
+	// Service and Revisions are the service and its revisions, rewritten for the destination by the Transform
+	// of the service handler, since renames and remapped revision names span both
+	Service   *serving_v1_api.Service
+	Revisions *serving_v1_api.RevisionList
+	// SourceNamespace and DestinationNamespace are the namespaces the service is migrated from and to
+	SourceNamespace      string
+	DestinationNamespace string
+	// ClientSetD and MigrationClientD are the clients of the destination
+	ClientSetD       kubernetes.Interface
+	MigrationClientD command.MigrationClient
+	Options          *MigrationOptions
+
+	source       migrationSource
+	provenance   provenance
+	exists       bool
+	collisions   *revisionCollisions
+	traffic      []serving_v1_api.TrafficTarget
+	configUID    types.UID
+	revisions    []string
+	dependencies []string
+	// sourceHash is the hash of the source service, unchanged is set when the destination service was migrated
+	// from the same source by a previous run, and conflict overrides the conflict strategy of the options
+	sourceHash string
+	unchanged  bool
+	conflict   ConflictStrategy
+}
+
+// onConflict returns what to do with the service if it already exists in the destination
+func (m *ServiceMigration) onConflict() ConflictStrategy {
+	if m.conflict != "" {
+		return m.conflict
+	}
+	return m.Options.onConflict()
+}
+
+// AddDependency records an object copied for the service, reported with the service
+func (m *ServiceMigration) AddDependency(kind, name string) {
+	m.dependencies = append(m.dependencies, kind+" "+name)
+}
+
+// configmapHandler copies the configmap named after the service
+type configmapHandler struct{}
+
+func (configmapHandler) Discover(ctx context.Context, m *ServiceMigration) ([]runtime.Object, error) {
+	configmapS, err := m.source.GetConfigmap(ctx, generateConfigmapName(m.SourceName))
+	if api_errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []runtime.Object{configmapS}, nil
+}
+
+func (configmapHandler) Transform(ctx context.Context, m *ServiceMigration, objects []runtime.Object) ([]runtime.Object, error) {
+	transformed := []runtime.Object{}
+	for _, object := range objects {
+		configmap := object.(*apiv1.ConfigMap).DeepCopy()
+		hash, err := configmapSourceHash(configmap)
+		if err != nil {
+			return nil, err
+		}
+		configmap.Name = generateConfigmapName(m.Options.destinationName(m.SourceName))
+		annotateSourceHash(&configmap.ObjectMeta, hash)
+		reportMetadataChanges(m.Options.out(), "configmap", configmap.Name, m.Options.MetadataRules.apply(&configmap.ObjectMeta))
+		transformed = append(transformed, configmap)
+	}
+	return transformed, nil
+}
+
+func (configmapHandler) Apply(ctx context.Context, m *ServiceMigration, objects []runtime.Object) error {
+	if len(objects) == 0 {
+		fmt.Fprintf(m.Options.out(), "no configmap for service %s, skip migrate configmap\n", m.Service.Name)
+		return nil
+	}
+	for _, object := range objects {
+		configmap := object.(*apiv1.ConfigMap)
+		existing, err := getConfigmap(ctx, m.ClientSetD, m.DestinationNamespace, configmap.Name)
+		if err != nil && !api_errors.IsNotFound(err) {
+			return err
+		}
+		force := m.Options.forces(ForceConfigMaps)
+		if recorded := recordedAnnotation(existing, sourceHashAnnotation); recorded != "" && m.Options.comparesSourceHash(ForceConfigMaps) {
+			if recorded == configmap.Annotations[sourceHashAnnotation] {
+				printMigrationState(m.Options.out(), "ConfigMap", configmap.Name, "unchanged")
+				continue
+			}
+			// The configmap migrated by a previous run changed in the source
+			force = true
+		}
+		var replaced *apiv1.ConfigMap
+		err = m.Options.paced(ctx, "create configmap "+configmap.Name, func() error {
+			var err error
+			replaced, err = applyConfigmap(ctx, m.ClientSetD, m.DestinationNamespace, configmap, force, m.Options)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		m.AddDependency("ConfigMap", configmap.Name)
+		if replaced != nil {
+			m.Options.changes().updated("ConfigMap", m.DestinationNamespace, replaced.Name, replaced, m.ClientSetD)
+			printMigrationState(m.Options.out(), "ConfigMap", configmap.Name, "updated")
+		} else {
+			m.Options.changes().created("ConfigMap", m.DestinationNamespace, configmap.Name, m.ClientSetD, nil)
+			printMigrationState(m.Options.out(), "ConfigMap", configmap.Name, "created")
+		}
+	}
+	return nil
+}
+
+func (configmapHandler) Verify(ctx context.Context, m *ServiceMigration, objects []runtime.Object) error {
+	return nil
+}
+
+// secretHandler copies the secrets read by the template of the service, service account tokens are left
+// to the destination cluster. With the dynamic clients of the clusters, the SealedSecrets and ExternalSecrets
+// generating secrets are migrated instead of the secrets, so that the secret material never leaves the clusters.
+type secretHandler struct {
+	clientS dynamic.Interface
+	clientD dynamic.Interface
+}
+
+// NewSecretHandler returns the handler of SecretKind migrating the SealedSecrets and ExternalSecrets generating
+// secrets with the dynamic clients of the source and destination clusters, instead of the secrets
+func NewSecretHandler(clientS, clientD dynamic.Interface) ResourceHandler {
+	return secretHandler{clientS: clientS, clientD: clientD}
+}
+
+func (h secretHandler) Discover(ctx context.Context, m *ServiceMigration) ([]runtime.Object, error) {
+	objects := []runtime.Object{}
+	generated := map[string]bool{}
+	for _, name := range referencedSecrets(m.Service.Spec.Template) {
+		secretS, err := m.source.GetSecret(ctx, name)
+		if api_errors.IsNotFound(err) {
+			fmt.Fprintf(m.Options.out(), "no secret %s in the source for service %s, skip migrate secret\n", name, m.SourceName)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if secretS.Type == apiv1.SecretTypeServiceAccountToken {
+			continue
+		}
+		if generator, generatorName, ok := secretGeneratorOf(secretS); ok && h.clientS != nil {
+			if generated[generator.Kind+"/"+generatorName] {
+				continue
+			}
+			generated[generator.Kind+"/"+generatorName] = true
+			object, err := getSecretGenerator(ctx, h.clientS, generator, m.SourceNamespace, generatorName)
+			if err != nil {
+				return nil, fmt.Errorf("cannot get %s %s generating secret %s: %w", generator.Kind, generatorName, name, err)
+			}
+			objects = append(objects, object)
+			continue
+		}
+		objects = append(objects, secretS)
+	}
+	return objects, nil
+}
+
+// Transform builds the custom resources generating secrets for the destination namespace
+func (h secretHandler) Transform(ctx context.Context, m *ServiceMigration, objects []runtime.Object) ([]runtime.Object, error) {
+	transformed := make([]runtime.Object, 0, len(objects))
+	for _, object := range objects {
+		if generator, ok := object.(*unstructured.Unstructured); ok {
+			object = buildEventingObject(*generator, m.SourceNamespace, m.DestinationNamespace)
+		}
+		transformed = append(transformed, object)
+	}
+	return transformed, nil
+}
+
+func (h secretHandler) Apply(ctx context.Context, m *ServiceMigration, objects []runtime.Object) error {
+	for _, object := range objects {
+		switch object := object.(type) {
+		case *apiv1.Secret:
+			applied, err := copySecret(ctx, m.ClientSetD, m.DestinationNamespace, object, m.Options)
+			if err != nil {
+				return err
+			}
+			if applied {
+				m.AddDependency("Secret", object.Name)
+			}
+		case *unstructured.Unstructured:
+			for _, generator := range secretGenerators {
+				if generator.Kind != object.GetKind() {
+					continue
+				}
+				warnSecretGenerator(ctx, h.clientD, object, m.SourceNamespace, m.Options)
+				applied, err := applySecretGenerator(ctx, h.clientD, generator, object, m.Options)
+				if err != nil {
+					return err
+				}
+				if applied {
+					m.AddDependency(generator.Kind, object.GetName())
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (secretHandler) Verify(ctx context.Context, m *ServiceMigration, objects []runtime.Object) error {
+	return nil
+}
+
+// serviceHandler creates the service, routing to its latest revision until the revision handler restored its traffic
+type serviceHandler struct{}
+
+func (serviceHandler) Discover(ctx context.Context, m *ServiceMigration) ([]runtime.Object, error) {
+	return []runtime.Object{m.Service}, nil
+}
+
+// Transform renames the service, remaps the names of its colliding revisions and rewrites the service
+// and its revisions with the options
+func (serviceHandler) Transform(ctx context.Context, m *ServiceMigration, objects []runtime.Object) ([]runtime.Object, error) {
+	options := m.Options
+	hash, err := serviceSourceHash(*m.Service)
+	if err != nil {
+		return nil, err
+	}
+	m.sourceHash = hash
+	if name := options.destinationName(m.SourceName); name != m.SourceName {
+		fmt.Fprintln(m.Options.out(), "Rename service", color.CyanString(m.SourceName), "to", color.CyanString(name), "in the destination")
+		renameService(m.Service, m.Revisions, name)
+	}
+	exists, err := m.MigrationClientD.ServiceExists(ctx, m.Service.Name)
+	if err != nil {
+		return nil, err
+	}
+	m.exists = exists
+	if exists {
+		existing, err := m.MigrationClientD.GetService(ctx, m.Service.Name)
+		if err != nil {
+			return nil, err
+		}
+		recorded := existing.Annotations[sourceHashAnnotation]
+		switch {
+		case recorded == "" || !options.comparesSourceHash(ForceServices):
+		case recorded == hash:
+			// Nothing is rewritten, the service is skipped once the objects it depends on are applied
+			m.unchanged = true
+			return objects, nil
+		default:
+			// The service migrated by a previous run changed in the source
+			m.conflict = ConflictOverwrite
+		}
+		switch m.onConflict() {
+		case ConflictSkip:
+			fmt.Fprintln(m.Options.out(), "Service", color.CyanString(m.Service.Name), "already exists in the destination, skip migrate service")
+			return nil, errServiceSkipped
+		case ConflictFail:
+			return nil, fmt.Errorf("cannot migrate service %s: %w and no --force or --on-conflict option was given", m.Service.Name, ErrServiceExists)
+		}
+		if err := options.checkOwnership("Service", m.Service.Name, existing.Annotations); err != nil {
+			return nil, err
+		}
+	}
+	collisions, err := detectRevisionCollisions(ctx, m.MigrationClientD, *m.Service, m.Revisions, exists && options.recreates(), exists && m.onConflict().replaces() && !options.recreates(), options.RevisionCollision)
+	if err != nil {
+		return nil, err
+	}
+	if len(collisions.Collisions) > 0 && options.RevisionCollision == RevisionCollisionFail {
+		return nil, collisionError(m.Service.Name, collisions.Collisions)
+	}
+	for _, collision := range collisions.Collisions {
+		fmt.Fprintln(m.Options.out(), "Remap revision", color.CyanString(collision.Name), "to", color.CyanString(collision.Remapped), "because it", collision.Reason)
+	}
+	m.collisions = collisions
+	remapRevisions(m.Service, m.Revisions, collisions.remapping())
+	regenerateRevisions(m.Service, options)
+	if missing := unresolvedTrafficRevisions(*m.Service, m.Revisions); len(missing) > 0 {
+		if options.Revisions.selects() {
+			return nil, fmt.Errorf("cannot migrate service %s: its traffic targets revisions %s which are not selected by --revisions %s, use --revisions traffic to migrate them", m.Service.Name, strings.Join(missing, ", "), options.Revisions)
+		}
+		return nil, fmt.Errorf("cannot migrate service %s: its traffic targets revisions %s which are neither revisions of the service nor its template name", m.Service.Name, strings.Join(missing, ", "))
+	}
+	translateNetworkingAnnotations(m.Service, m.Revisions, options)
+	if options.PreserveRevisionHistory {
+		annotateRevisionHistory(m.Revisions)
+	}
+	rewriteMetadata(m.Service, m.Revisions, nil, options)
+	if err := pinDigests(ctx, m.Service, m.Revisions, options); err != nil {
+		return nil, err
+	}
+	rewriteImages(m.Service, m.Revisions, options)
+	if err := copyImages(ctx, m.Service, m.Revisions, options); err != nil {
+		return nil, err
+	}
+	overrideEnv(m.Service, m.Revisions, options)
+	m.provenance = newProvenance(options, m.SourceNamespace, m.SourceName, time.Now())
+	m.provenance.annotate(m.Service)
+	annotateSourceHash(&m.Service.ObjectMeta, m.sourceHash)
+	options.stampOwnership(&m.Service.ObjectMeta)
+	return []runtime.Object{m.Service}, nil
+}
+
+func (serviceHandler) Apply(ctx context.Context, m *ServiceMigration, objects []runtime.Object) error {
+	options := m.Options
+	if m.unchanged {
+		printMigrationState(m.Options.out(), "Service", m.Service.Name, "unchanged")
+		return errServiceSkipped
+	}
+	applied := m.exists && !options.recreates()
+	if m.exists && options.changes() != nil {
+		replaced, err := m.MigrationClientD.GetService(ctx, m.Service.Name)
+		if err != nil {
+			return err
+		}
+		if applied {
+			options.changes().updatedService(replaced, m.MigrationClientD)
+		} else {
+			options.changes().replaced(replaced, m.MigrationClientD)
+		}
+	}
+	// The service routes to its latest revision until every revision named by its traffic block is migrated
+	m.traffic = m.Service.Spec.Traffic
+	createdS := *m.Service
+	createdS.Spec.Traffic = nil
+	err := options.paced(ctx, "create service "+m.Service.Name, func() error {
+		return createService(ctx, options.out(), m.MigrationClientD, createdS, m.onConflict(), options.recreates())
+	})
+	if err != nil {
+		return err
+	}
+	// A service applied over the existing one is restored on rollback rather than deleted
+	if !applied {
+		options.changes().created("Service", m.DestinationNamespace, m.Service.Name, nil, m.MigrationClientD)
+	}
+	if m.exists {
+		printMigrationState(m.Options.out(), "Service", m.Service.Name, "updated")
+	} else {
+		printMigrationState(m.Options.out(), "Service", m.Service.Name, "created")
+	}
+
+	serviceD, err := m.MigrationClientD.GetService(ctx, m.Service.Name)
+	if err != nil {
+		return err
+	}
+	// The Event is informational, a destination refusing it does not fail the migration
+	if err := recordMigratedFromEvent(ctx, m.ClientSetD, serviceD, m.provenance, time.Now()); err != nil {
+		options.warn("Cannot record the %s event on service %s: %v", migratedFromReason, serviceD.Name, err)
+	}
+	config, err := getConfig(ctx, m.MigrationClientD, serviceD.Name, options)
+	if err != nil {
+		return err
+	}
+	m.configUID = config.UID
+	return nil
+}
+
+// Verify waits for the service to be Ready and requests its URL with the Verify option, or only waits for it
+// to be Ready with the Wait option
+func (serviceHandler) Verify(ctx context.Context, m *ServiceMigration, objects []runtime.Object) error {
+	switch {
+	case m.Options.Verify:
+		return verifyService(ctx, m.Options.out(), m.MigrationClientD, m.Service.Name, m.Options.VerifyTimeout)
+	case m.Options.Wait:
+		return waitForServiceReady(ctx, m.Options.out(), m.MigrationClientD, m.Service.Name, m.Options.WaitTimeout)
+	}
+	return nil
+}
+
+// revisionHandler replays the revisions of the service in order, then restores its traffic block
+type revisionHandler struct{}
+
+func (revisionHandler) Discover(ctx context.Context, m *ServiceMigration) ([]runtime.Object, error) {
+	revisionsS, err := m.source.ListRevisionByService(ctx, m.SourceName)
+	if err != nil {
+		return nil, err
+	}
+	if m.Options.RegenerateRevisions {
+		fmt.Fprintln(m.Options.out(), "Skip migrate", len(revisionsS.Items), "revision(s) of service", color.CyanString(m.SourceName), "regenerated by the destination")
+		m.Revisions = &serving_v1_api.RevisionList{}
+		return nil, nil
+	}
+	revisionsS, left := selectRevisions(*m.Service, revisionsS, m.Options.Revisions)
+	if len(left) > 0 {
+		fmt.Fprintln(m.Options.out(), "Skip migrate", len(left), "revision(s) of service", color.CyanString(m.SourceName), "not selected by --revisions", m.Options.Revisions)
+	}
+	revisionsS, _ = skipBrokenRevisions(*m.Service, revisionsS, m.Options)
+	m.Revisions = revisionsS
+	return revisionObjects(revisionsS), nil
+}
+
+// Transform returns the revisions as rewritten with the service by the service handler
+func (revisionHandler) Transform(ctx context.Context, m *ServiceMigration, objects []runtime.Object) ([]runtime.Object, error) {
+	return revisionObjects(m.Revisions), nil
+}
+
+func (revisionHandler) Apply(ctx context.Context, m *ServiceMigration, objects []runtime.Object) error {
+	options := m.Options
+	// The service creates the revision named by its template, the status of the new service may not tell it yet
+	latestRevisionName := command.TemplateRevisionName(*m.Service)
+	for _, object := range objects {
+		revisionS := *object.(*serving_v1_api.Revision)
+		if m.collisions != nil && m.collisions.Existing[revisionS.Name] {
+			fmt.Fprintln(m.Options.out(), "Revision", color.CyanString(revisionS.Name), "already exists with the same spec, skip migrate revision")
+			m.revisions = append(m.revisions, revisionS.Name)
+			continue
+		}
+		err := migrateRevision(ctx, m.MigrationClientD, revisionS, *m.Service, m.configUID, latestRevisionName, options)
+		if err != nil {
+			return err
+		}
+		if revisionS.Name != latestRevisionName {
+			options.changes().created("Revision", m.DestinationNamespace, revisionS.Name, nil, m.MigrationClientD)
+		}
+		m.revisions = append(m.revisions, revisionS.Name)
+		if err := waitForRevisionReady(ctx, m.MigrationClientD, revisionS.Name, options); err != nil {
+			return err
+		}
+	}
+	if options.LatestOnly {
+		if err := waitForLatestRevisionReady(ctx, m.MigrationClientD, m.Service.Name, options); err != nil {
+			return err
+		}
+	}
+	if len(m.traffic) > 0 {
+		return applyTraffic(ctx, m.MigrationClientD, m.Service.Name, m.traffic, options)
+	}
+	return nil
+}
+
+func (revisionHandler) Verify(ctx context.Context, m *ServiceMigration, objects []runtime.Object) error {
+	return nil
+}
+
+// revisionObjects returns the revisions of a list as objects
+func revisionObjects(revisions *serving_v1_api.RevisionList) []runtime.Object {
+	objects := []runtime.Object{}
+	for i := range revisions.Items {
+		objects = append(objects, &revisions.Items[i])
+	}
+	return objects
+}
@@ -0,0 +1,101 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gotest.tools/assert"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// recordingHandler records the phases it runs for a kind
+type recordingHandler struct {
+	kind   string
+	phases *[]string
+	fail   string
+}
+
+func (h recordingHandler) record(phase string) error {
+	*h.phases = append(*h.phases, h.kind+" "+phase)
+	if phase == h.fail {
+		return errors.New(h.kind + " " + phase + " failed")
+	}
+	return nil
+}
+
+func (h recordingHandler) Discover(ctx context.Context, m *ServiceMigration) ([]runtime.Object, error) {
+	return []runtime.Object{&apiv1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: m.SourceName + "-widget"}}}, h.record("discover")
+}
+
+func (h recordingHandler) Transform(ctx context.Context, m *ServiceMigration, objects []runtime.Object) ([]runtime.Object, error) {
+	return objects, h.record("transform")
+}
+
+func (h recordingHandler) Apply(ctx context.Context, m *ServiceMigration, objects []runtime.Object) error {
+	for _, object := range objects {
+		m.AddDependency("Widget", object.(*apiv1.ConfigMap).Name)
+	}
+	return h.record("apply")
+}
+
+func (h recordingHandler) Verify(ctx context.Context, m *ServiceMigration, objects []runtime.Object) error {
+	return h.record("verify")
+}
+
+func TestResourceHandlersRegistry(t *testing.T) {
+	handlers := NewResourceHandlers()
+	assert.DeepEqual(t, handlers.Kinds(), []schema.GroupVersionKind{ConfigMapKind, SecretKind, ServiceAccountKind, PersistentVolumeClaimKind, ServiceKind, RevisionKind})
+
+	widgets := schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Widget"}
+	handlers.Register(widgets, recordingHandler{})
+	handlers.Register(ConfigMapKind, recordingHandler{})
+	handlers.Unregister(SecretKind)
+	handlers.Unregister(SecretKind)
+	assert.DeepEqual(t, handlers.Kinds(), []schema.GroupVersionKind{ConfigMapKind, ServiceAccountKind, PersistentVolumeClaimKind, ServiceKind, RevisionKind, widgets})
+	_, ok := handlers.handlers[ConfigMapKind].(recordingHandler)
+	assert.Assert(t, ok)
+}
+
+func TestMigrateServiceWithCustomHandler(t *testing.T) {
+	phases := []string{}
+	options := NewMigrationOptions()
+	options.ResourceHandlers.Register(schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Widget"}, recordingHandler{kind: "widget", phases: &phases})
+
+	source := simulatedBundle("default", "hello")
+	clientSetD, migrationClientD := newSimulatedDestination("default", &bundleSource{})
+	revisions, dependencies, err := migrateService(context.Background(), source, clientSetD, migrationClientD, "default", source.services[0], options)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, revisions, []string{"hello-00001", "hello-00002"})
+	assert.DeepEqual(t, dependencies, []string{"Widget hello-widget"})
+	assert.DeepEqual(t, phases, []string{"widget discover", "widget transform", "widget apply", "widget verify"})
+
+	// A failing handler stops the migration of the service before the next phase
+	phases = []string{}
+	options = NewMigrationOptions()
+	options.ResourceHandlers.Register(ConfigMapKind, recordingHandler{kind: "widget", phases: &phases, fail: "transform"})
+	clientSetD, migrationClientD = newSimulatedDestination("default", &bundleSource{})
+	_, _, err = migrateService(context.Background(), source, clientSetD, migrationClientD, "default", source.services[0], options)
+	assert.ErrorContains(t, err, "widget transform failed")
+	assert.DeepEqual(t, phases, []string{"widget discover", "widget transform"})
+	exists, err := migrationClientD.ServiceExists(context.Background(), "hello")
+	assert.NilError(t, err)
+	assert.Assert(t, !exists)
+}
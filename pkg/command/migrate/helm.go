@@ -0,0 +1,178 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"knative.dev/kn-plugin-migration/pkg/command"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// helmNamespace and helmRegistry mark the namespace and the image registry of the objects before they are
+	// replaced by the values of the chart
+	helmNamespace = "KN_MIGRATION_NAMESPACE"
+	helmRegistry  = "KN_MIGRATION_REGISTRY"
+)
+
+// splitImageRegistry splits an image reference in its registry and the rest of the reference, docker.io for
+// the images without registry
+func splitImageRegistry(image string) (string, string) {
+	parts := strings.SplitN(image, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		return parts[0], parts[1]
+	}
+	return "docker.io", image
+}
+
+// mostCommon returns the value counted the most, the first in order on a tie, empty without any value
+func mostCommon(counts map[string]int) string {
+	values := make([]string, 0, len(counts))
+	for value := range counts {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	common := ""
+	for _, value := range values {
+		if common == "" || counts[value] > counts[common] {
+			common = value
+		}
+	}
+	return common
+}
+
+// helmTemplate returns an object as the content of a chart template, its namespace and the images of the registry
+// marked with helmNamespace and helmRegistry, and the domain, are replaced by the values of the chart. The template
+// delimiters already in the object are escaped, so that they are rendered as they are.
+func helmTemplate(object interface{}, domain string) ([]byte, error) {
+	data, err := yaml.Marshal(object)
+	if err != nil {
+		return nil, err
+	}
+	template := strings.ReplaceAll(string(data), "{{", `{{ "{{" }}`)
+	template = strings.ReplaceAll(template, helmNamespace, "{{ .Values.namespace | default .Release.Namespace }}")
+	template = strings.ReplaceAll(template, helmRegistry, "{{ .Values.imageRegistry }}")
+	if domain != "" {
+		template = strings.ReplaceAll(template, "."+domain, ".{{ .Values.domain }}")
+	}
+	return []byte(template), nil
+}
+
+// exportHelm writes the selected services of the namespace and their configmaps as the templates of a Helm chart,
+// with values for their namespace, the registry of their images and the domain of the source cluster
+func exportHelm(ctx context.Context, out io.Writer, clientSet kubernetes.Interface, migrationClient command.MigrationClient, namespace string, filter *serviceFilter, dir string) error {
+	services, err := listSourceServices(ctx, migrationClient, filter)
+	if err != nil {
+		return err
+	}
+
+	registries := map[string]int{}
+	domains := map[string]int{}
+	for _, serviceS := range services.Items {
+		for _, container := range serviceS.Spec.Template.Spec.Containers {
+			registry, _ := splitImageRegistry(container.Image)
+			registries[registry]++
+		}
+		if url := serviceS.Status.URL; url != nil {
+			if domain := strings.TrimPrefix(url.Host, serviceS.Name+"."+namespace+"."); domain != url.Host {
+				domains[domain]++
+			}
+		}
+	}
+	registry, domain := mostCommon(registries), mostCommon(domains)
+
+	templates := filepath.Join(dir, "templates")
+	if err := os.MkdirAll(templates, 0755); err != nil {
+		return err
+	}
+	write := func(file string, object interface{}) error {
+		data, err := helmTemplate(object, domain)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(templates, file), data, 0644)
+	}
+	secrets := map[string]bool{}
+	for _, serviceS := range services.Items {
+		configmap, err := getConfigmap(ctx, clientSet, namespace, generateConfigmapName(serviceS.Name))
+		if err != nil && !api_errors.IsNotFound(err) {
+			return err
+		}
+		if configmap != nil {
+			configmap.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"}
+			configmap.ObjectMeta = command.SanitizeObjectMeta(configmap.ObjectMeta, helmNamespace)
+			if err := write("configmap-"+configmap.Name+".yaml", configmap); err != nil {
+				return err
+			}
+		}
+
+		service, split := gitOpsService(serviceS)
+		if split {
+			fmt.Fprintln(out, color.YellowString("Service %s splits its traffic between revisions, the chart routes its traffic to its latest revision", serviceS.Name))
+		}
+		service.Namespace = helmNamespace
+		for i, container := range service.Spec.Template.Spec.Containers {
+			if imageRegistry, image := splitImageRegistry(container.Image); imageRegistry == registry {
+				service.Spec.Template.Spec.Containers[i].Image = helmRegistry + "/" + image
+			}
+		}
+		if err := write("service-"+service.Name+".yaml", service); err != nil {
+			return err
+		}
+		for _, name := range referencedSecrets(serviceS.Spec.Template) {
+			secrets[name] = true
+		}
+		fmt.Fprintln(out, "Exported service", color.CyanString(service.Name))
+	}
+
+	chart := fmt.Sprintf(`apiVersion: v2
+name: %s
+description: Knative services exported from namespace %s
+type: application
+version: 0.1.0
+`, namespace, namespace)
+	if err := ioutil.WriteFile(filepath.Join(dir, "Chart.yaml"), []byte(chart), 0644); err != nil {
+		return err
+	}
+	values := fmt.Sprintf(`# namespace of the services, the namespace of the release when empty
+namespace: %s
+# imageRegistry replaces the registry the images of the services were pulled from in the source
+imageRegistry: %s
+# domain replaces the domain of the source cluster in the services
+domain: %s
+`, strconv.Quote(namespace), strconv.Quote(registry), strconv.Quote(domain))
+	if err := ioutil.WriteFile(filepath.Join(dir, "values.yaml"), []byte(values), 0644); err != nil {
+		return err
+	}
+
+	if len(secrets) > 0 {
+		fmt.Fprintln(out, color.YellowString("The %d secret(s) referenced by the services are not written to %s, they must be provided in the destination", len(secrets), dir))
+	}
+	fmt.Fprintln(out, "Exported", color.CyanString("%d", len(services.Items)), "service(s) of namespace", color.BlueString(namespace), "to the Helm chart", dir)
+	return nil
+}
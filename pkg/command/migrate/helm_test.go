@@ -0,0 +1,95 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8s_fake "k8s.io/client-go/kubernetes/fake"
+	"knative.dev/kn-plugin-migration/pkg/command"
+	"knative.dev/pkg/apis"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+	serving_fake "knative.dev/serving/pkg/client/clientset/versioned/fake"
+)
+
+func TestSplitImageRegistry(t *testing.T) {
+	for image, expected := range map[string][2]string{
+		"gcr.io/app/hello:v1":     {"gcr.io", "app/hello:v1"},
+		"localhost:5000/hello":    {"localhost:5000", "hello"},
+		"localhost/hello":         {"localhost", "hello"},
+		"library/hello:v1":        {"docker.io", "library/hello:v1"},
+		"hello":                   {"docker.io", "hello"},
+		"docker.io/library/hello": {"docker.io", "library/hello"},
+	} {
+		registry, rest := splitImageRegistry(image)
+		assert.DeepEqual(t, [2]string{registry, rest}, expected)
+	}
+}
+
+func TestExportHelm(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	hello := &serving_v1_api.Service{ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "default"}}
+	hello.Spec.Template.Spec.Containers = []apiv1.Container{{Image: "gcr.io/app/hello:v1", Env: []apiv1.EnvVar{
+		{Name: "BYE_URL", Value: "http://bye.default.old.example.com"},
+		{Name: "GREETING", Value: "{{name}}"},
+	}}}
+	hello.Status.URL = &apis.URL{Scheme: "http", Host: "hello.default.old.example.com"}
+	bye := &serving_v1_api.Service{ObjectMeta: metav1.ObjectMeta{Name: "bye", Namespace: "default"}}
+	bye.Spec.Template.Spec.Containers = []apiv1.Container{{Image: "gcr.io/app/bye:v2"}, {Image: "quay.io/sidecar:v1"}}
+	clientSet := k8s_fake.NewSimpleClientset(&apiv1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "hello-config", Namespace: "default"}, Data: map[string]string{"key": "value"}})
+	migrationClient := command.NewMigrationClient(serving_fake.NewSimpleClientset(hello, bye).ServingV1(), "default")
+	filter, _ := newServiceFilter(nil, "")
+
+	assert.NilError(t, exportHelm(context.Background(), io.Discard, clientSet, migrationClient, "default", filter, dir))
+
+	values, err := ioutil.ReadFile(filepath.Join(dir, "values.yaml"))
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(string(values), `namespace: "default"`))
+	assert.Assert(t, strings.Contains(string(values), `imageRegistry: "gcr.io"`))
+	assert.Assert(t, strings.Contains(string(values), `domain: "old.example.com"`))
+	chart, err := ioutil.ReadFile(filepath.Join(dir, "Chart.yaml"))
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(string(chart), "name: default"))
+
+	template, err := ioutil.ReadFile(filepath.Join(dir, "templates", "service-hello.yaml"))
+	assert.NilError(t, err)
+	for _, expected := range []string{
+		"namespace: {{ .Values.namespace | default .Release.Namespace }}",
+		"image: {{ .Values.imageRegistry }}/app/hello:v1",
+		"value: http://bye.default.{{ .Values.domain }}",
+		`value: '{{ "{{" }}name}}'`,
+	} {
+		assert.Assert(t, strings.Contains(string(template), expected), "%s not in %s", expected, template)
+	}
+	// Only the images of the registry of the values are templated
+	template, err = ioutil.ReadFile(filepath.Join(dir, "templates", "service-bye.yaml"))
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(string(template), "image: quay.io/sidecar:v1"))
+	template, err = ioutil.ReadFile(filepath.Join(dir, "templates", "configmap-hello-config.yaml"))
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(string(template), "namespace: {{ .Values.namespace | default .Release.Namespace }}"))
+}
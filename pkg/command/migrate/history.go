@@ -0,0 +1,52 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"time"
+
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+const (
+	// sourceCreationTimestampAnnotation records the creation time of a revision in the source cluster
+	sourceCreationTimestampAnnotation = "migration.knative.dev/source-creation-timestamp"
+	// sourceGenerationAnnotation records the configuration generation of a revision in the source cluster
+	sourceGenerationAnnotation = "migration.knative.dev/source-generation"
+)
+
+// revisionHistoryAnnotations are the annotations recording the place of a revision in the history of its service
+var revisionHistoryAnnotations = []string{sourceCreationTimestampAnnotation, sourceGenerationAnnotation}
+
+// annotateRevisionHistory annotates the source revisions with their creation time and configuration generation,
+// since every migrated revision is created anew and dashboards or GC policies sorting revisions by age would
+// otherwise see the whole history of a service created at the time of the migration
+func annotateRevisionHistory(revisionsS *serving_v1_api.RevisionList) {
+	for i := range revisionsS.Items {
+		revision := &revisionsS.Items[i]
+		if revision.Annotations == nil {
+			revision.Annotations = map[string]string{}
+		}
+		// A revision migrated again keeps the history recorded by its first migration
+		if _, ok := revision.Annotations[sourceCreationTimestampAnnotation]; !ok && !revision.CreationTimestamp.IsZero() {
+			revision.Annotations[sourceCreationTimestampAnnotation] = revision.CreationTimestamp.UTC().Format(time.RFC3339)
+		}
+		if _, ok := revision.Annotations[sourceGenerationAnnotation]; !ok {
+			if generation := revision.Labels["serving.knative.dev/configurationGeneration"]; generation != "" {
+				revision.Annotations[sourceGenerationAnnotation] = generation
+			}
+		}
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+// DefaultHookTimeout is the default maximum time a pre or post hook may run
+const DefaultHookTimeout = time.Minute
+
+const (
+	// hookPreRun, hookPostRun, hookPreService and hookPostService are the events a hook is called for
+	hookPreRun      = "pre-run"
+	hookPostRun     = "post-run"
+	hookPreService  = "pre-service"
+	hookPostService = "post-service"
+)
+
+// hookClient calls the webhooks, each call is bounded by the hook timeout
+var hookClient = &http.Client{}
+
+// migrationHook is a local command or a webhook URL called before or after the migration of every service
+// and of the whole run. A command receives the JSON of the event on stdin and its attributes as KN_MIGRATION_*
+// environment variables, a webhook receives the JSON in the body of a POST and the attributes as Kn-Migration-* headers.
+type migrationHook struct {
+	target  string
+	timeout time.Duration
+	// out and errOut receive the output and the errors of a command
+	out    io.Writer
+	errOut io.Writer
+}
+
+// newMigrationHook returns the hook of a --pre-hook or --post-hook, nil if it is empty
+func newMigrationHook(target string, timeout time.Duration, out, errOut io.Writer) (*migrationHook, error) {
+	if target == "" {
+		return nil, nil
+	}
+	if timeout <= 0 {
+		return nil, fmt.Errorf("the hook timeout must be positive, got %s", timeout)
+	}
+	hook := &migrationHook{target: target, timeout: timeout, out: out, errOut: errOut}
+	if hook.webhook() {
+		if _, err := url.ParseRequestURI(target); err != nil {
+			return nil, fmt.Errorf("invalid hook URL %s: %w", target, err)
+		}
+	}
+	return hook, nil
+}
+
+// webhook returns true if the hook is called over HTTP rather than run as a command
+func (h *migrationHook) webhook() bool {
+	return strings.HasPrefix(h.target, "http://") || strings.HasPrefix(h.target, "https://")
+}
+
+// call calls the hook for an event with the JSON of data, a nil hook does nothing
+func (h *migrationHook) call(ctx context.Context, event string, attributes map[string]string, data interface{}) error {
+	if h == nil {
+		return nil
+	}
+	return h.invoke(ctx, event, attributes, data, nil)
+}
+
+// query calls the hook for an event like call and returns what the command printed on stdout, or the body of the
+// answer of the webhook
+func (h *migrationHook) query(ctx context.Context, event string, attributes map[string]string, data interface{}) ([]byte, error) {
+	out := &bytes.Buffer{}
+	err := h.invoke(ctx, event, attributes, data, out)
+	return out.Bytes(), err
+}
+
+// invoke calls the hook, its output is written to out, or to the output of the hook for a command if out is nil
+func (h *migrationHook) invoke(ctx context.Context, event string, attributes map[string]string, data interface{}, out io.Writer) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	attributes["event"] = event
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+	if h.webhook() {
+		err = h.post(ctx, attributes, body, out)
+	} else {
+		err = h.run(ctx, attributes, body, out)
+	}
+	if err != nil {
+		return fmt.Errorf("the %s hook %s failed: %w", event, h.target, err)
+	}
+	return nil
+}
+
+// post posts the body to the webhook, which must answer with a success status
+func (h *migrationHook) post(ctx context.Context, attributes map[string]string, body []byte, out io.Writer) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, h.target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for _, name := range sortedAttributes(attributes) {
+		request.Header.Set("Kn-Migration-"+name, headerValue(attributes[name]))
+	}
+	response, err := hookClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("answered %s", response.Status)
+	}
+	if out != nil {
+		_, err = io.Copy(out, response.Body)
+	}
+	return err
+}
+
+// headerValue escapes the line breaks of an attribute, such as a multi-line error, which a header cannot carry
+func headerValue(value string) string {
+	return strings.NewReplacer("\r", `\r`, "\n", `\n`).Replace(value)
+}
+
+// run runs the command with a shell, giving it the body on stdin, and fails if it exits with an error
+func (h *migrationHook) run(ctx context.Context, attributes map[string]string, body []byte, out io.Writer) error {
+	shell, flag := "sh", "-c"
+	if runtime.GOOS == "windows" {
+		shell, flag = "cmd", "/C"
+	}
+	cmd := exec.CommandContext(ctx, shell, flag, h.target)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Stdout, cmd.Stderr = h.out, h.errOut
+	if out != nil {
+		cmd.Stdout = out
+	}
+	cmd.Env = os.Environ()
+	for _, name := range sortedAttributes(attributes) {
+		cmd.Env = append(cmd.Env, "KN_MIGRATION_"+strings.ToUpper(name)+"="+attributes[name])
+	}
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("timed out after %s", h.timeout)
+		}
+		return err
+	}
+	return nil
+}
+
+// sortedAttributes returns the names of the attributes of a hook in a stable order
+func sortedAttributes(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// setupHooks sets the service hooks of the options calling the pre hook with the manifest of every source service
+// before migrating it and the post hook once it is migrated or failed, a failing hook fails the service
+func setupHooks(options *MigrationOptions, pre, post *migrationHook) {
+	if pre != nil {
+		options.Hooks.Before = func(ctx context.Context, service *serving_v1_api.Service) error {
+			return pre.call(ctx, hookPreService, map[string]string{"service": service.Name, "namespace": service.Namespace}, service)
+		}
+	}
+	if post != nil {
+		options.Hooks.After = func(ctx context.Context, service *serving_v1_api.Service, err error) error {
+			attributes := map[string]string{"service": service.Name, "namespace": service.Namespace, "status": string(ServiceStatusMigrated)}
+			if err != nil {
+				attributes["status"] = string(ServiceStatusFailed)
+				attributes["error"] = err.Error()
+			}
+			return post.call(ctx, hookPostService, attributes, service)
+		}
+	}
+}
+
+// runHookData is the JSON the pre-run hook receives
+type runHookData struct {
+	Namespaces []namespacePair `json:"namespaces"`
+}
+
+// callPreRunHook calls the pre hook with the namespaces to migrate before anything is migrated
+func callPreRunHook(ctx context.Context, pre *migrationHook, namespaces []namespacePair) error {
+	return pre.call(ctx, hookPreRun, map[string]string{}, runHookData{Namespaces: namespaces})
+}
+
+// callPostRunHook calls the post hook with the report of the finished run
+func callPostRunHook(ctx context.Context, post *migrationHook, report *MigrationReport) error {
+	status := "succeeded"
+	if !report.Succeeded {
+		status = "failed"
+	}
+	return post.call(ctx, hookPostRun, map[string]string{"status": status}, report)
+}
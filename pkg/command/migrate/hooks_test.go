@@ -0,0 +1,135 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+func TestNewMigrationHook(t *testing.T) {
+	hook, err := newMigrationHook("", time.Minute, io.Discard, io.Discard)
+	assert.NilError(t, err)
+	assert.Assert(t, hook == nil)
+	assert.NilError(t, hook.call(context.Background(), hookPreRun, map[string]string{}, nil))
+
+	_, err = newMigrationHook("./warm-up.sh", 0, io.Discard, io.Discard)
+	assert.ErrorContains(t, err, "timeout must be positive")
+	_, err = newMigrationHook("http://", time.Minute, io.Discard, io.Discard)
+	assert.NilError(t, err)
+
+	hook, err = newMigrationHook("https://hooks.example.com/migration", time.Minute, io.Discard, io.Discard)
+	assert.NilError(t, err)
+	assert.Assert(t, hook.webhook())
+	hook, err = newMigrationHook("curl -X POST https://hooks.example.com", time.Minute, io.Discard, io.Discard)
+	assert.NilError(t, err)
+	assert.Assert(t, !hook.webhook())
+}
+
+func TestCommandHook(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	hook, err := newMigrationHook(`cat > `+out+` && echo " $KN_MIGRATION_EVENT $KN_MIGRATION_NAMESPACE/$KN_MIGRATION_SERVICE" >> `+out, time.Minute, io.Discard, io.Discard)
+	assert.NilError(t, err)
+	options := NewMigrationOptions()
+	setupHooks(options, hook, nil)
+	assert.Assert(t, options.Hooks.After == nil)
+
+	service := &serving_v1_api.Service{ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "default"}}
+	assert.NilError(t, options.Hooks.before(context.Background(), service))
+	data, err := ioutil.ReadFile(out)
+	assert.NilError(t, err)
+	manifest, err := json.Marshal(service)
+	assert.NilError(t, err)
+	assert.Equal(t, string(data), string(manifest)+" pre-service default/hello\n")
+
+	// The output and the errors of the command go to the writers of the hook
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	talking, err := newMigrationHook("echo warmed up; echo slow >&2", time.Minute, stdout, stderr)
+	assert.NilError(t, err)
+	assert.NilError(t, talking.call(context.Background(), hookPreRun, map[string]string{}, nil))
+	assert.Equal(t, stdout.String(), "warmed up\n")
+	assert.Equal(t, stderr.String(), "slow\n")
+
+	failing, err := newMigrationHook("exit 3", time.Minute, io.Discard, io.Discard)
+	assert.NilError(t, err)
+	setupHooks(options, failing, nil)
+	assert.ErrorContains(t, options.Hooks.before(context.Background(), service), "the hook before service hello failed: the pre-service hook exit 3 failed: exit status 3")
+
+	slow, err := newMigrationHook("exec sleep 5", 50*time.Millisecond, io.Discard, io.Discard)
+	assert.NilError(t, err)
+	assert.ErrorContains(t, slow.call(context.Background(), hookPreRun, map[string]string{}, nil), "timed out after 50ms")
+}
+
+func TestWebhook(t *testing.T) {
+	requests := []*http.Request{}
+	bodies := []map[string]interface{}{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]interface{}{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		requests, bodies = append(requests, r), append(bodies, body)
+		if r.Header.Get("Kn-Migration-Service") == "broken" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	hook, err := newMigrationHook(server.URL, time.Minute, io.Discard, io.Discard)
+	assert.NilError(t, err)
+	options := NewMigrationOptions()
+	setupHooks(options, nil, hook)
+	service := &serving_v1_api.Service{ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "default"}}
+
+	// A failed service is reported to the post hook, whose own failure does not replace the error of the service
+	assert.ErrorContains(t, options.Hooks.after(context.Background(), service, errors.New("quota exceeded")), "quota exceeded")
+	assert.Equal(t, requests[0].Method, http.MethodPost)
+	assert.Equal(t, requests[0].Header.Get("Content-Type"), "application/json")
+	assert.Equal(t, requests[0].Header.Get("Kn-Migration-Event"), hookPostService)
+	assert.Equal(t, requests[0].Header.Get("Kn-Migration-Status"), "failed")
+	assert.Equal(t, requests[0].Header.Get("Kn-Migration-Error"), "quota exceeded")
+	assert.Equal(t, bodies[0]["metadata"].(map[string]interface{})["name"], "hello")
+
+	broken := service.DeepCopy()
+	broken.Name = "broken"
+	assert.ErrorContains(t, options.Hooks.after(context.Background(), broken, nil), "503 Service Unavailable")
+
+	report := newMigrationReport()
+	report.namespace("default", "prod").add("hello", []string{"hello-00001"}, nil, time.Second, nil)
+	report.finish(nil)
+	assert.NilError(t, callPostRunHook(context.Background(), hook, report))
+	assert.Equal(t, requests[2].Header.Get("Kn-Migration-Event"), hookPostRun)
+	assert.Equal(t, requests[2].Header.Get("Kn-Migration-Status"), "succeeded")
+	assert.Equal(t, bodies[2]["succeeded"], true)
+
+	assert.NilError(t, callPreRunHook(context.Background(), hook, []namespacePair{{Source: "default", Destination: "prod"}}))
+	assert.DeepEqual(t, bodies[3], map[string]interface{}{"namespaces": []interface{}{map[string]interface{}{"source": "default", "destination": "prod"}}})
+
+	// The line breaks of a multi-line error are escaped in its header
+	assert.ErrorContains(t, options.Hooks.after(context.Background(), service, errors.New("quota exceeded\nretry later")), "quota exceeded")
+	assert.Equal(t, requests[4].Header.Get("Kn-Migration-Error"), `quota exceeded\nretry later`)
+}
@@ -0,0 +1,205 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	apiv1 "k8s.io/api/core/v1"
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+// unpullableImages are the images the destination cannot pull, by service, with the reason of the failure
+type unpullableImages map[string][]string
+
+func (u unpullableImages) Error() string {
+	services := make([]string, 0, len(u))
+	for service := range u {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+	lines := []string{fmt.Sprintf("the destination cannot pull the images of %d service(s), their revisions would be stuck in ImagePullBackOff:", len(services))}
+	for _, service := range services {
+		for _, image := range u[service] {
+			lines = append(lines, fmt.Sprintf("  %s: %s", service, image))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// pullSecretKeychain resolves the credentials of a registry from the docker config of image pull secrets
+type pullSecretKeychain struct {
+	auths map[string]authn.AuthConfig
+}
+
+// newPullSecretKeychain reads the credentials of kubernetes.io/dockerconfigjson and kubernetes.io/dockercfg secrets
+func newPullSecretKeychain(secrets []*apiv1.Secret) (*pullSecretKeychain, error) {
+	keychain := &pullSecretKeychain{auths: map[string]authn.AuthConfig{}}
+	for _, secret := range secrets {
+		auths := map[string]authn.AuthConfig{}
+		switch secret.Type {
+		case apiv1.SecretTypeDockerConfigJson:
+			config := struct {
+				Auths map[string]authn.AuthConfig `json:"auths"`
+			}{}
+			if err := json.Unmarshal(secret.Data[apiv1.DockerConfigJsonKey], &config); err != nil {
+				return nil, fmt.Errorf("cannot read the image pull secret %s: %w", secret.Name, err)
+			}
+			auths = config.Auths
+		case apiv1.SecretTypeDockercfg:
+			if err := json.Unmarshal(secret.Data[apiv1.DockerConfigKey], &auths); err != nil {
+				return nil, fmt.Errorf("cannot read the image pull secret %s: %w", secret.Name, err)
+			}
+		default:
+			continue
+		}
+		// The first secret giving credentials for a registry wins, as for the kubelet
+		for server, auth := range auths {
+			registry := pullSecretRegistry(server)
+			if _, ok := keychain.auths[registry]; !ok {
+				keychain.auths[registry] = auth
+			}
+		}
+	}
+	return keychain, nil
+}
+
+// pullSecretRegistry returns the registry host of a server of a docker config, e.g. https://index.docker.io/v1/
+func pullSecretRegistry(server string) string {
+	server = strings.TrimPrefix(strings.TrimPrefix(server, "https://"), "http://")
+	server = strings.SplitN(server, "/", 2)[0]
+	if server == "docker.io" {
+		return name.DefaultRegistry
+	}
+	return server
+}
+
+func (k *pullSecretKeychain) Resolve(resource authn.Resource) (authn.Authenticator, error) {
+	if auth, ok := k.auths[resource.RegistryStr()]; ok {
+		return authn.FromConfig(auth), nil
+	}
+	return authn.Anonymous, nil
+}
+
+// serviceImages returns the images of the containers and init containers of a service and its revisions, as the
+// destination will pull them after the image rewrites
+func serviceImages(service serving_v1_api.Service, revisions *serving_v1_api.RevisionList, options *MigrationOptions) []string {
+	images := map[string]bool{}
+	add := func(spec apiv1.PodSpec) {
+		for _, container := range append(append([]apiv1.Container{}, spec.Containers...), spec.InitContainers...) {
+			images[rewriteImage(container.Image, options.ImageRewrites)] = true
+		}
+	}
+	add(service.Spec.Template.Spec.PodSpec)
+	for _, revision := range revisions.Items {
+		add(revision.Spec.PodSpec)
+	}
+	sorted := make([]string, 0, len(images))
+	for image := range images {
+		sorted = append(sorted, image)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// pullSecrets returns the image pull secrets of a service and of its service account, read from the destination
+// namespace or else from the source, since the secrets are migrated with the service
+func pullSecrets(ctx context.Context, source migrationSource, clientSetS, clientSetD kubernetes.Interface, namespaceD string, service serving_v1_api.Service) ([]*apiv1.Secret, error) {
+	names := []string{}
+	for _, secret := range service.Spec.Template.Spec.ImagePullSecrets {
+		names = append(names, secret.Name)
+	}
+	accountName := service.Spec.Template.Spec.ServiceAccountName
+	if accountName == "" {
+		accountName = "default"
+	}
+	account, err := clientSetD.CoreV1().ServiceAccounts(namespaceD).Get(ctx, accountName, metav1.GetOptions{})
+	if api_errors.IsNotFound(err) {
+		account, err = clientSetS.CoreV1().ServiceAccounts(source.Namespace()).Get(ctx, accountName, metav1.GetOptions{})
+	}
+	switch {
+	case err == nil:
+		for _, secret := range account.ImagePullSecrets {
+			names = append(names, secret.Name)
+		}
+	case !api_errors.IsNotFound(err):
+		return nil, err
+	}
+
+	secrets := []*apiv1.Secret{}
+	for _, secretName := range names {
+		secret, err := getSecret(ctx, clientSetD, namespaceD, secretName)
+		if api_errors.IsNotFound(err) {
+			secret, err = source.GetSecret(ctx, secretName)
+		}
+		if api_errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, secret)
+	}
+	return secrets, nil
+}
+
+// checkImagePullability resolves the manifest of every image of the services to migrate with the image pull
+// secrets they will use in the destination, without pulling any layer, and returns an unpullableImages error
+// listing the images which cannot be resolved by service
+func checkImagePullability(ctx context.Context, source migrationSource, clientSetS, clientSetD kubernetes.Interface, namespaceD string, filter *serviceFilter, options *MigrationOptions) error {
+	servicesS, err := source.ListServices(ctx, filter)
+	if err != nil {
+		return err
+	}
+	unpullable := unpullableImages{}
+	for _, serviceS := range servicesS.Items {
+		revisionsS, err := source.ListRevisionByService(ctx, serviceS.Name)
+		if err != nil {
+			return err
+		}
+		revisionsS, _ = selectRevisions(serviceS, revisionsS, options.Revisions)
+		secrets, err := pullSecrets(ctx, source, clientSetS, clientSetD, namespaceD, serviceS)
+		if err != nil {
+			return err
+		}
+		keychain, err := newPullSecretKeychain(secrets)
+		if err != nil {
+			return err
+		}
+		for _, image := range serviceImages(serviceS, revisionsS, options) {
+			ref, err := name.ParseReference(image)
+			if err == nil {
+				_, err = remote.Head(ref, remote.WithContext(ctx), remote.WithAuthFromKeychain(keychain))
+			}
+			if err != nil {
+				unpullable[serviceS.Name] = append(unpullable[serviceS.Name], fmt.Sprintf("%s (%s)", image, err.Error()))
+			}
+		}
+	}
+	if len(unpullable) > 0 {
+		return unpullable
+	}
+	return nil
+}
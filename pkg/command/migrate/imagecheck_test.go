@@ -0,0 +1,108 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"gotest.tools/assert"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8s_fake "k8s.io/client-go/kubernetes/fake"
+	"knative.dev/kn-plugin-migration/pkg/command"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+	serving_fake "knative.dev/serving/pkg/client/clientset/versioned/fake"
+)
+
+func TestPullSecretKeychain(t *testing.T) {
+	secrets := []*apiv1.Secret{
+		{ObjectMeta: metav1.ObjectMeta{Name: "corp"}, Type: apiv1.SecretTypeDockerConfigJson, Data: map[string][]byte{
+			apiv1.DockerConfigJsonKey: []byte(`{"auths":{"https://registry.corp/v2/":{"auth":"dXNlcjpzZWNyZXQ="},"docker.io":{"username":"hub","password":"token"}}}`),
+		}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "legacy"}, Type: apiv1.SecretTypeDockercfg, Data: map[string][]byte{
+			apiv1.DockerConfigKey: []byte(`{"registry.corp":{"username":"other","password":"ignored"},"quay.io":{"username":"quay","password":"robot"}}`),
+		}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "opaque"}, Type: apiv1.SecretTypeOpaque, Data: map[string][]byte{"token": []byte("x")}},
+	}
+	keychain, err := newPullSecretKeychain(secrets)
+	assert.NilError(t, err)
+
+	for image, expected := range map[string]authn.AuthConfig{
+		// The first secret giving credentials for a registry wins
+		"registry.corp/ns/app:v1": {Username: "user", Password: "secret"},
+		"nginx:1.21":              {Username: "hub", Password: "token"},
+		"quay.io/proj/app":        {Username: "quay", Password: "robot"},
+		"gcr.io/proj/app":         {},
+	} {
+		ref, err := name.ParseReference(image)
+		assert.NilError(t, err)
+		auth, err := keychain.Resolve(ref.Context())
+		assert.NilError(t, err)
+		config, err := auth.Authorization()
+		assert.NilError(t, err)
+		assert.Equal(t, config.Username, expected.Username, image)
+		assert.Equal(t, config.Password, expected.Password, image)
+	}
+
+	_, err = newPullSecretKeychain([]*apiv1.Secret{{ObjectMeta: metav1.ObjectMeta{Name: "broken"}, Type: apiv1.SecretTypeDockerConfigJson, Data: map[string][]byte{apiv1.DockerConfigJsonKey: []byte("{")}}})
+	assert.ErrorContains(t, err, "cannot read the image pull secret broken")
+}
+
+func TestCheckImagePullability(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	img, err := random.Image(64, 1)
+	assert.NilError(t, err)
+	ref, err := name.ParseReference(host + "/proj/app:v2")
+	assert.NilError(t, err)
+	assert.NilError(t, remote.Write(ref, img))
+
+	newService := func(name, image string) *serving_v1_api.Service {
+		service := &serving_v1_api.Service{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}}
+		service.Spec.Template.Spec.Containers = []apiv1.Container{{Name: "app", Image: image}}
+		return service
+	}
+	revision := &serving_v1_api.Revision{ObjectMeta: metav1.ObjectMeta{Name: "hello-00001", Namespace: "default", Labels: map[string]string{"serving.knative.dev/service": "hello"}}}
+	revision.Spec.Containers = []apiv1.Container{{Name: "app", Image: host + "/proj/app:v1"}}
+	servingClientS := serving_fake.NewSimpleClientset(newService("hello", host+"/proj/app:v2"), revision, newService("ok", host+"/proj/app:v2"))
+	clientSetS := k8s_fake.NewSimpleClientset()
+	source := newLiveSource(clientSetS, command.NewMigrationClient(servingClientS.ServingV1(), "default"), "default")
+	filter, err := newServiceFilter(nil, "")
+	assert.NilError(t, err)
+
+	err = checkImagePullability(context.Background(), source, clientSetS, k8s_fake.NewSimpleClientset(), "default", filter, NewMigrationOptions())
+	var unpullable unpullableImages
+	assert.Assert(t, errors.As(err, &unpullable))
+	assert.Equal(t, len(unpullable), 1)
+	assert.Equal(t, len(unpullable["hello"]), 1)
+	assert.Assert(t, strings.HasPrefix(unpullable["hello"][0], host+"/proj/app:v1 ("))
+	assert.ErrorContains(t, err, "the destination cannot pull the images of 1 service(s)")
+
+	// The images are checked as the destination pulls them after the image rewrites
+	options := NewMigrationOptions()
+	options.ImageRewrites, err = parseImageRewrites([]string{host + "/proj/app:v1=" + host + "/proj/app:v2"})
+	assert.NilError(t, err)
+	assert.NilError(t, checkImagePullability(context.Background(), source, clientSetS, k8s_fake.NewSimpleClientset(), "default", filter, options))
+}
@@ -0,0 +1,168 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	apiv1 "k8s.io/api/core/v1"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+// imageKeychain resolves the credentials of the source and destination registries, from the docker config by default
+var imageKeychain = authn.DefaultKeychain
+
+// validateImageCopy checks the destination registry of --copy-images
+func validateImageCopy(copyImages bool, registry string, rewrites []string) error {
+	if !copyImages {
+		if registry != "" {
+			return fmt.Errorf("--dest-registry can only be used with --copy-images")
+		}
+		return nil
+	}
+	if registry == "" {
+		return fmt.Errorf("--copy-images requires --dest-registry, e.g. registry.corp/ns")
+	}
+	if len(rewrites) > 0 {
+		return fmt.Errorf("--copy-images cannot be combined with --image-rewrite")
+	}
+	if _, err := name.NewRepository(strings.TrimSuffix(registry, "/") + "/image"); err != nil {
+		return fmt.Errorf("invalid --dest-registry %q: %w", registry, err)
+	}
+	return nil
+}
+
+// copyImage copies an image, or the index of a multi-platform image, by digest to the destination registry
+// and returns its reference there. The repository of the image is kept under the registry, gcr.io/proj/app:v1
+// is copied to registry.corp/ns/proj/app@sha256:... Images already copied by the run are not copied again.
+func copyImage(ctx context.Context, image string, options *MigrationOptions) (string, error) {
+	options.mu.Lock()
+	copied, ok := options.copiedImages[image]
+	options.mu.Unlock()
+	if ok {
+		return copied, nil
+	}
+
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return "", fmt.Errorf("cannot copy image %s: %w", image, err)
+	}
+	remoteOptions := []remote.Option{remote.WithContext(ctx), remote.WithAuthFromKeychain(imageKeychain)}
+	desc, err := remote.Get(ref, remoteOptions...)
+	if err != nil {
+		return "", fmt.Errorf("cannot copy image %s: %w", image, err)
+	}
+	destination, err := name.NewDigest(fmt.Sprintf("%s/%s@%s", strings.TrimSuffix(options.DestRegistry, "/"), ref.Context().RepositoryStr(), desc.Digest))
+	if err != nil {
+		return "", fmt.Errorf("cannot copy image %s: %w", image, err)
+	}
+	if desc.MediaType.IsIndex() {
+		index, err := desc.ImageIndex()
+		if err == nil {
+			err = remote.WriteIndex(destination, index, remoteOptions...)
+		}
+		if err != nil {
+			return "", fmt.Errorf("cannot copy image %s to %s: %w", image, destination, err)
+		}
+	} else {
+		img, err := desc.Image()
+		if err == nil {
+			err = remote.Write(destination, img, remoteOptions...)
+		}
+		if err != nil {
+			return "", fmt.Errorf("cannot copy image %s to %s: %w", image, destination, err)
+		}
+	}
+
+	options.mu.Lock()
+	if options.copiedImages == nil {
+		options.copiedImages = map[string]string{}
+	}
+	options.copiedImages[image] = destination.String()
+	options.mu.Unlock()
+	return destination.String(), nil
+}
+
+// copyContainerImages returns a copy of the containers with their images copied to the destination registry and
+// the applied changes. The images resolved by a revision are copied by the digest it runs in the source.
+func copyContainerImages(ctx context.Context, containers []apiv1.Container, digests map[string]string, options *MigrationOptions) ([]apiv1.Container, []string, error) {
+	if containers == nil {
+		return nil, nil, nil
+	}
+	copied := make([]apiv1.Container, len(containers))
+	changes := []string{}
+	for i, container := range containers {
+		source := container.Image
+		if digest, ok := digests[container.Name]; ok && digest != "" {
+			source = digest
+		}
+		image, err := copyImage(ctx, source, options)
+		if err != nil {
+			return nil, nil, err
+		}
+		changes = append(changes, fmt.Sprintf("%s to %s", container.Image, image))
+		container.Image = image
+		copied[i] = container
+	}
+	return copied, changes, nil
+}
+
+// copyImages copies the images of the containers of a service and its revisions to the destination registry of
+// the options and rewrites the specs to refer to the copies, so the destination does not pull from the source registries
+func copyImages(ctx context.Context, serviceS *serving_v1_api.Service, revisionsS *serving_v1_api.RevisionList, options *MigrationOptions) error {
+	if !options.CopyImages {
+		return nil
+	}
+	copyPodImages := func(kind, name string, spec *apiv1.PodSpec, digests map[string]string) error {
+		var changes, initChanges []string
+		var err error
+		spec.Containers, changes, err = copyContainerImages(ctx, spec.Containers, digests, options)
+		if err != nil {
+			return err
+		}
+		spec.InitContainers, initChanges, err = copyContainerImages(ctx, spec.InitContainers, digests, options)
+		if err != nil {
+			return err
+		}
+		for _, change := range append(changes, initChanges...) {
+			fmt.Fprintln(options.out(), "Copied image of", kind, color.CyanString(name)+":", change)
+		}
+		return nil
+	}
+
+	if err := copyPodImages("the template of service", serviceS.Name, &serviceS.Spec.Template.Spec.PodSpec, nil); err != nil {
+		return err
+	}
+	for i := range revisionsS.Items {
+		revision := &revisionsS.Items[i]
+		digests := map[string]string{}
+		for _, status := range revision.Status.ContainerStatuses {
+			digests[status.Name] = status.ImageDigest
+		}
+		for _, status := range revision.Status.InitContainerStatuses {
+			digests[status.Name] = status.ImageDigest
+		}
+		if err := copyPodImages("revision", revision.Name, &revision.Spec.PodSpec, digests); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"gotest.tools/assert"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+func TestValidateImageCopy(t *testing.T) {
+	assert.NilError(t, validateImageCopy(false, "", nil))
+	assert.NilError(t, validateImageCopy(true, "registry.corp/ns", nil))
+	assert.ErrorContains(t, validateImageCopy(false, "registry.corp/ns", nil), "--dest-registry can only be used with --copy-images")
+	assert.ErrorContains(t, validateImageCopy(true, "", nil), "--copy-images requires --dest-registry")
+	assert.ErrorContains(t, validateImageCopy(true, "registry.corp/ns", []string{"gcr.io=mirror.corp"}), "--copy-images cannot be combined with --image-rewrite")
+	assert.ErrorContains(t, validateImageCopy(true, "registry.corp/NS", nil), "invalid --dest-registry")
+}
+
+func TestCopyImages(t *testing.T) {
+	source := httptest.NewServer(registry.New())
+	defer source.Close()
+	destination := httptest.NewServer(registry.New())
+	defer destination.Close()
+	sourceHost := strings.TrimPrefix(source.URL, "http://")
+	destinationHost := strings.TrimPrefix(destination.URL, "http://")
+
+	push := func(image string) string {
+		img, err := random.Image(64, 1)
+		assert.NilError(t, err)
+		ref, err := name.ParseReference(image)
+		assert.NilError(t, err)
+		assert.NilError(t, remote.Write(ref, img))
+		digest, err := img.Digest()
+		assert.NilError(t, err)
+		return digest.String()
+	}
+	v1Digest := push(sourceHost + "/proj/app:v1")
+	v2Digest := push(sourceHost + "/proj/app:v2")
+	index, err := random.Index(64, 1, 2)
+	assert.NilError(t, err)
+	indexRef, err := name.ParseReference(sourceHost + "/proj/init:latest")
+	assert.NilError(t, err)
+	assert.NilError(t, remote.WriteIndex(indexRef, index))
+	indexDigest, err := index.Digest()
+	assert.NilError(t, err)
+	// The tag moves after the revision was created, the revision keeps running the image it resolved
+	push(sourceHost + "/proj/app:v1")
+
+	options := &MigrationOptions{CopyImages: true, DestRegistry: destinationHost + "/ns"}
+	service := serving_v1_api.Service{ObjectMeta: metav1.ObjectMeta{Name: "hello"}}
+	service.Spec.Template.Spec.Containers = []apiv1.Container{{Name: "app", Image: sourceHost + "/proj/app:v2"}}
+	service.Spec.Template.Spec.InitContainers = []apiv1.Container{{Name: "init", Image: sourceHost + "/proj/init:latest"}}
+	revisions := &serving_v1_api.RevisionList{Items: []serving_v1_api.Revision{{ObjectMeta: metav1.ObjectMeta{Name: "hello-00001"}}}}
+	revisions.Items[0].Spec.Containers = []apiv1.Container{{Name: "app", Image: sourceHost + "/proj/app:v1"}}
+	revisions.Items[0].Status.ContainerStatuses = []serving_v1_api.ContainerStatus{{Name: "app", ImageDigest: sourceHost + "/proj/app@" + v1Digest}}
+
+	assert.NilError(t, copyImages(context.Background(), &service, revisions, options))
+
+	assert.Equal(t, service.Spec.Template.Spec.Containers[0].Image, fmt.Sprintf("%s/ns/proj/app@%s", destinationHost, v2Digest))
+	assert.Equal(t, service.Spec.Template.Spec.InitContainers[0].Image, fmt.Sprintf("%s/ns/proj/init@%s", destinationHost, indexDigest))
+	assert.Equal(t, revisions.Items[0].Spec.Containers[0].Image, fmt.Sprintf("%s/ns/proj/app@%s", destinationHost, v1Digest))
+	for _, image := range []string{service.Spec.Template.Spec.Containers[0].Image, service.Spec.Template.Spec.InitContainers[0].Image, revisions.Items[0].Spec.Containers[0].Image} {
+		ref, err := name.ParseReference(image)
+		assert.NilError(t, err)
+		_, err = remote.Head(ref)
+		assert.NilError(t, err, image)
+	}
+
+	// An image which cannot be pulled fails the service
+	service.Spec.Template.Spec.Containers[0].Image = sourceHost + "/proj/missing:v1"
+	err = copyImages(context.Background(), &service, &serving_v1_api.RevisionList{}, options)
+	assert.ErrorContains(t, err, "cannot copy image "+sourceHost+"/proj/missing:v1")
+}
@@ -0,0 +1,100 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+	apiv1 "k8s.io/api/core/v1"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+// ImageRewrite replaces the prefix From of the container images by To, e.g. gcr.io/old by registry.corp/new
+type ImageRewrite struct {
+	From string
+	To   string
+}
+
+// parseImageRewrites parses the from=to pairs of --image-rewrite, the longest matching prefix is applied first
+func parseImageRewrites(pairs []string) ([]ImageRewrite, error) {
+	rewrites := []ImageRewrite{}
+	for _, pair := range pairs {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("invalid --image-rewrite %q, expected old-prefix=new-prefix", pair)
+		}
+		rewrites = append(rewrites, ImageRewrite{From: strings.TrimSuffix(strings.TrimSpace(parts[0]), "/"), To: strings.TrimSuffix(strings.TrimSpace(parts[1]), "/")})
+	}
+	sort.SliceStable(rewrites, func(i, j int) bool {
+		return len(rewrites[i].From) > len(rewrites[j].From)
+	})
+	return rewrites, nil
+}
+
+// rewriteImage returns the image with the first matching prefix replaced. A prefix matches whole path
+// components, gcr.io/old matches gcr.io/old/app and gcr.io/old:v1 but not gcr.io/older/app.
+func rewriteImage(image string, rewrites []ImageRewrite) string {
+	for _, rewrite := range rewrites {
+		if !strings.HasPrefix(image, rewrite.From) {
+			continue
+		}
+		rest := strings.TrimPrefix(image, rewrite.From)
+		if rest == "" || strings.ContainsAny(rest[:1], "/:@") {
+			return rewrite.To + rest
+		}
+	}
+	return image
+}
+
+// rewriteContainerImages returns a copy of the containers with their images rewritten and the applied changes
+func rewriteContainerImages(containers []apiv1.Container, rewrites []ImageRewrite) ([]apiv1.Container, []string) {
+	if containers == nil {
+		return nil, nil
+	}
+	rewritten := make([]apiv1.Container, len(containers))
+	changes := []string{}
+	for i, container := range containers {
+		if image := rewriteImage(container.Image, rewrites); image != container.Image {
+			changes = append(changes, fmt.Sprintf("%s to %s", container.Image, image))
+			container.Image = image
+		}
+		rewritten[i] = container
+	}
+	return rewritten, changes
+}
+
+// rewriteImages rewrites the images of the containers of a service and its revisions with the image rewrites of the options
+func rewriteImages(serviceS *serving_v1_api.Service, revisionsS *serving_v1_api.RevisionList, options *MigrationOptions) {
+	if len(options.ImageRewrites) == 0 {
+		return
+	}
+	rewrite := func(kind, name string, spec *apiv1.PodSpec) {
+		var changes, initChanges []string
+		spec.Containers, changes = rewriteContainerImages(spec.Containers, options.ImageRewrites)
+		spec.InitContainers, initChanges = rewriteContainerImages(spec.InitContainers, options.ImageRewrites)
+		for _, change := range append(changes, initChanges...) {
+			fmt.Fprintln(options.out(), "Rewrote image of", kind, color.CyanString(name)+":", change)
+		}
+	}
+
+	rewrite("the template of service", serviceS.Name, &serviceS.Spec.Template.Spec.PodSpec)
+	for i := range revisionsS.Items {
+		revision := &revisionsS.Items[i]
+		rewrite("revision", revision.Name, &revision.Spec.PodSpec)
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"testing"
+
+	"gotest.tools/assert"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+func TestRewriteImage(t *testing.T) {
+	rewrites, err := parseImageRewrites([]string{"gcr.io=mirror.corp/gcr", "gcr.io/old/=registry.corp/new"})
+	assert.NilError(t, err)
+	// The longest prefix is applied first
+	assert.Equal(t, rewrites[0].From, "gcr.io/old")
+
+	for image, expected := range map[string]string{
+		"gcr.io/old/app:v1":            "registry.corp/new/app:v1",
+		"gcr.io/old:v1":                "registry.corp/new:v1",
+		"gcr.io/old@sha256:0123":       "registry.corp/new@sha256:0123",
+		"gcr.io/older/app":             "mirror.corp/gcr/older/app",
+		"gcr.io.example.com/app":       "gcr.io.example.com/app",
+		"docker.io/library/nginx:1.21": "docker.io/library/nginx:1.21",
+	} {
+		assert.Equal(t, rewriteImage(image, rewrites), expected, image)
+	}
+
+	_, err = parseImageRewrites([]string{"gcr.io/old"})
+	assert.ErrorContains(t, err, "invalid --image-rewrite \"gcr.io/old\", expected old-prefix=new-prefix")
+}
+
+func TestRewriteImages(t *testing.T) {
+	rewrites, err := parseImageRewrites([]string{"gcr.io/old=registry.corp/new"})
+	assert.NilError(t, err)
+	options := &MigrationOptions{ImageRewrites: rewrites}
+	containers := []apiv1.Container{{Name: "app", Image: "gcr.io/old/app:v2"}, {Name: "proxy", Image: "docker.io/envoy:v1"}}
+	service := serving_v1_api.Service{ObjectMeta: metav1.ObjectMeta{Name: "hello"}}
+	service.Spec.Template.Spec.Containers = containers
+	service.Spec.Template.Spec.InitContainers = []apiv1.Container{{Name: "init", Image: "gcr.io/old/init"}}
+	revisions := &serving_v1_api.RevisionList{Items: []serving_v1_api.Revision{{ObjectMeta: metav1.ObjectMeta{Name: "hello-00001"}}}}
+	revisions.Items[0].Spec.Containers = []apiv1.Container{{Name: "app", Image: "gcr.io/old/app:v1"}}
+
+	rewriteImages(&service, revisions, options)
+
+	assert.Equal(t, service.Spec.Template.Spec.Containers[0].Image, "registry.corp/new/app:v2")
+	assert.Equal(t, service.Spec.Template.Spec.Containers[1].Image, "docker.io/envoy:v1")
+	assert.Equal(t, service.Spec.Template.Spec.InitContainers[0].Image, "registry.corp/new/init")
+	assert.Equal(t, revisions.Items[0].Spec.Containers[0].Image, "registry.corp/new/app:v1")
+	// The containers of the source are not modified
+	assert.Equal(t, containers[0].Image, "gcr.io/old/app:v2")
+}
@@ -0,0 +1,372 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"knative.dev/kn-plugin-migration/pkg/command"
+)
+
+type importCmdFlags struct {
+	From                  string
+	FromFile              string
+	DecryptWith           string
+	Namespace             string
+	DestinationKubeConfig string
+	DestinationContext    string
+	DestinationNamespace  string
+	Yes                   bool
+	DryRun                bool
+	Output                string
+	Services              []string
+	Selector              string
+	Exclude               []string
+	ExcludeSelector       string
+	AnnotationMapping     string
+	Renames               []string
+	RenameFile            string
+	SetLabels             []string
+	SetAnnotations        []string
+	RemoveAnnotations     []string
+	NamespaceLabels       []string
+	ImageRewrites         []string
+	Env                   []string
+	EnvFile               string
+	EventSink             string
+	PreHook               string
+	PostHook              string
+	HookTimeout           time.Duration
+	ReportFile            string
+	AuditLog              string
+	OwnerAnnotation       string
+	Options               *MigrationOptions
+}
+
+var importFlags importCmdFlags
+
+// NewImportCommand represents the 'migrate import' command
+func NewImportCommand() *cobra.Command {
+	importFlags.Options = NewMigrationOptions()
+	importCmd := &cobra.Command{
+		Use:   "import",
+		Short: "Import Knative services from a bundle written by 'kn migrate export' or from a kubectl dump",
+		Long: `Import Knative services from a bundle written by 'kn migrate export' or from a kubectl dump.
+
+Configmaps, services and revisions of the bundle are replayed into the destination
+cluster the same way as a live migration, so that revision names and generations
+are preserved. A multi-document YAML dump of services, revisions and configmaps
+written by kubectl can be imported instead of a bundle, e.g. to restore services
+of a source cluster which is already gone.`,
+		Example: `
+  # Import all services of the bundle directory into the prod namespace
+  kn migrate import --from ./bundle/ --destination-namespace prod
+  # Print the import plan without changing anything in the destination cluster
+  kn migrate import --from ./bundle/ --destination-namespace prod --force --dry-run
+  # Import the default namespace of a dump written by 'kubectl get ksvc,revisions,configmaps -A -o yaml > dump.yaml'
+  kn migrate import --from-file dump.yaml --namespace default --destination-namespace prod`,
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := command.NewCommandContext(cmd)
+			defer cancel()
+
+			// With a structured report the progress messages go to stderr so that stdout only holds the report
+			progress := cmd.OutOrStdout()
+			if importFlags.Output != "" {
+				progress = cmd.ErrOrStderr()
+				command.ConfigureColors(cmd, os.Stderr)
+			}
+			importFlags.Options.Out = progress
+
+			if cmd.Flags().Changed("force-scope") || importFlags.Options.ForceRecreate {
+				importFlags.Options.Force = true
+			}
+			warnInjectedFailures(importFlags.Options)
+
+			if importFlags.From == "" && importFlags.FromFile == "" {
+				return fmt.Errorf("cannot get the bundle directory, please use --from or --from-file to set")
+			}
+			if importFlags.From != "" && importFlags.FromFile != "" {
+				return fmt.Errorf("--from and --from-file cannot be combined")
+			}
+			kubeConfig := importFlags.DestinationKubeConfig
+			if kubeConfig == "" {
+				kubeConfig = os.Getenv("KUBECONFIG_DESTINATION")
+			}
+			if kubeConfig == "" {
+				kubeConfig = os.Getenv("KUBECONFIG")
+			}
+			if kubeConfig == "" {
+				return fmt.Errorf("cannot get destination cluster kube config, please use --destination-kubeconfig or export environment variable KUBECONFIG_DESTINATION to set")
+			}
+			err := validateOutputFormat(importFlags.Output)
+			if err != nil {
+				return err
+			}
+			if importFlags.OwnerAnnotation != "" && importFlags.EventSink == "" {
+				return fmt.Errorf("--owner-annotation requires --event-sink to send the summaries of the owners to")
+			}
+
+			err = setupNetworkingTranslation(importFlags.Options, importFlags.AnnotationMapping)
+			if err != nil {
+				return err
+			}
+			importFlags.Options.Renames, err = parseRenames(importFlags.Renames, importFlags.RenameFile)
+			if err != nil {
+				return err
+			}
+			importFlags.Options.MetadataRules, err = parseMetadataRules(importFlags.SetLabels, importFlags.SetAnnotations, importFlags.RemoveAnnotations)
+			if err != nil {
+				return err
+			}
+			importFlags.Options.NamespaceLabels, err = parseNamespaceLabels(importFlags.NamespaceLabels)
+			if err != nil {
+				return err
+			}
+			importFlags.Options.ImageRewrites, err = parseImageRewrites(importFlags.ImageRewrites)
+			if err != nil {
+				return err
+			}
+			err = validateRevisionRegeneration(importFlags.Options)
+			if err != nil {
+				return err
+			}
+			err = validateImageCopy(importFlags.Options.CopyImages, importFlags.Options.DestRegistry, importFlags.ImageRewrites)
+			if err != nil {
+				return err
+			}
+			importFlags.Options.EnvOverrides, err = parseEnvOverrides(importFlags.Env, importFlags.EnvFile)
+			if err != nil {
+				return err
+			}
+			preHook, err := newMigrationHook(importFlags.PreHook, importFlags.HookTimeout, progress, cmd.ErrOrStderr())
+			if err != nil {
+				return err
+			}
+			postHook, err := newMigrationHook(importFlags.PostHook, importFlags.HookTimeout, progress, cmd.ErrOrStderr())
+			if err != nil {
+				return err
+			}
+			setupHooks(importFlags.Options, preHook, postHook)
+
+			filter, err := newServiceFilter(importFlags.Services, importFlags.Selector)
+			if err != nil {
+				return err
+			}
+			err = filter.exclude(importFlags.Exclude, importFlags.ExcludeSelector)
+			if err != nil {
+				return err
+			}
+
+			var source *bundleSource
+			if importFlags.FromFile != "" {
+				source, err = readDump(importFlags.FromFile, importFlags.Namespace)
+			} else {
+				var key *bundleKey
+				key, err = parseBundleKey(importFlags.DecryptWith)
+				if err == nil {
+					source, err = readBundle(importFlags.From, key)
+				}
+			}
+			if err != nil {
+				return err
+			}
+			if importFlags.FromFile != "" {
+				importFlags.Options.SourceCluster = "dump " + importFlags.FromFile
+			} else {
+				importFlags.Options.SourceCluster = "bundle " + importFlags.From
+			}
+			namespaceD := importFlags.DestinationNamespace
+			if namespaceD == "" {
+				namespaceD = source.Namespace()
+			}
+			if namespaceD == "" {
+				return fmt.Errorf("cannot get destination namespace, please use --destination-namespace to set")
+			}
+
+			audit, err := openAuditLog(importFlags.AuditLog)
+			if err != nil {
+				return err
+			}
+			clientSetD, migrationClientD, err := getClients(clusterConfig{KubeConfig: kubeConfig, Context: importFlags.DestinationContext, audit: audit}, namespaceD)
+			if err != nil {
+				return err
+			}
+
+			detectNetworkingLayers(ctx, importFlags.Options, nil, clientSetD)
+
+			// Outside of the maintenance windows only the read-only plan is allowed for destructive imports
+			outsideWindow := false
+			if importFlags.Options.replacing() && !importFlags.DryRun {
+				outsideWindow, err = outsideMaintenanceWindows(time.Now())
+				if err != nil {
+					return err
+				}
+				if outsideWindow {
+					fmt.Fprintln(cmd.ErrOrStderr(), color.YellowString("Outside of the maintenance windows declared in the config file, refusing --force and printing the import plan only"))
+					importFlags.DryRun = true
+				}
+			}
+
+			if importFlags.DryRun {
+				plan, err := buildPlan(ctx, source, clientSetD, migrationClientD, namespaceD, filter, importFlags.Options, false)
+				if err != nil {
+					return err
+				}
+				err = printPlans(cmd.OutOrStdout(), []*migrationPlan{plan}, importFlags.Output)
+				if err != nil {
+					return err
+				}
+				if outsideWindow {
+					return fmt.Errorf("refused --force outside of the maintenance windows, only the import plan was printed")
+				}
+				return nil
+			}
+			if importFlags.Options.replacing() {
+				plan, err := buildPlan(ctx, source, clientSetD, migrationClientD, namespaceD, filter, importFlags.Options, false)
+				if err == nil {
+					err = confirmDestructiveActions([]*migrationPlan{plan}, importFlags.Yes)
+				}
+				if err != nil {
+					return err
+				}
+			}
+
+			owners := map[string]map[string]serviceOwnership{}
+			if importFlags.OwnerAnnotation != "" {
+				owners[source.Namespace()], err = lookupOwners(ctx, source, filter, importFlags.OwnerAnnotation)
+				if err != nil {
+					return err
+				}
+			}
+
+			out := cmd.OutOrStdout()
+			report := newMigrationReport()
+			report.Command, report.Flags = cmd.CommandPath(), usedFlags(cmd)
+			report.RunID = importFlags.Options.runID()
+
+			err = callPreRunHook(ctx, preHook, []namespacePair{{Source: source.Namespace(), Destination: namespaceD}})
+			if err == nil {
+				fmt.Fprintln(progress, "\nNow import all Knative service resources")
+				if importFlags.FromFile != "" {
+					fmt.Fprintln(progress, "From the dump", color.CyanString(importFlags.FromFile))
+				} else {
+					fmt.Fprintln(progress, "From the bundle", color.CyanString(importFlags.From))
+				}
+				fmt.Fprintln(progress, "To the destination cluster", color.CyanString(kubeConfig))
+				_, err = migrateNamespace(ctx, source, clientSetD, migrationClientD, namespaceD, filter, importFlags.Options, report.namespace(source.Namespace(), namespaceD))
+			}
+			importFlags.Options.rollbackFailure(ctx, report, err)
+			err = report.conclude(ctx, err, importFlags.Options)
+			if hookErr := callPostRunHook(context.Background(), postHook, report); hookErr != nil {
+				importFlags.Options.warn(hookErr.Error())
+				report.Warnings = importFlags.Options.warnings()
+			}
+			if err := printOutcome(out, cmd.ErrOrStderr(), report, importFlags.Output); err != nil {
+				fmt.Fprintln(progress, err.Error())
+			}
+			if importFlags.ReportFile != "" {
+				if err := writeReportFile(importFlags.ReportFile, report); err != nil {
+					fmt.Fprintln(progress, err.Error())
+				} else {
+					fmt.Fprintln(progress, "Wrote the import report to", color.CyanString(importFlags.ReportFile))
+				}
+			}
+			notifyCompletion(progress, importFlags.EventSink, report)
+			if importFlags.OwnerAnnotation != "" {
+				summaries := ownerSummaries(context.Background(), report, owners, func(string) command.MigrationClient {
+					return migrationClientD
+				})
+				notifyOwners(progress, importFlags.EventSink, report, summaries)
+			}
+			if auditErr := audit.Close(); auditErr != nil {
+				return auditErr
+			}
+			if err != nil {
+				return fmt.Errorf("cannot import into namespace %s: %w", namespaceD, err)
+			}
+			return nil
+		},
+	}
+
+	importCmd.Flags().StringVar(&importFlags.From, "from", "", "The bundle directory written by 'kn migrate export'")
+	importCmd.Flags().StringVar(&importFlags.FromFile, "from-file", "", "A multi-document YAML dump of services, revisions and configmaps written by kubectl to import instead of a bundle")
+	importCmd.Flags().StringVarP(&importFlags.Namespace, "namespace", "n", "", "The namespace of the resources to import from a dump holding several namespaces")
+	importCmd.Flags().StringVar(&importFlags.DestinationKubeConfig, "destination-kubeconfig", "", "The kubeconfig of the destination Knative resources (default is KUBECONFIG_DESTINATION, then KUBECONFIG from environment variable)")
+	importCmd.Flags().StringVar(&importFlags.DestinationContext, "destination-context", "", "The context of the kubeconfig of the destination Knative resources (default is the current context)")
+	importCmd.Flags().StringVar(&importFlags.DestinationNamespace, "destination-namespace", "", "The namespace of the destination Knative resources (default is the namespace the bundle was exported from)")
+	importCmd.Flags().BoolVar(&importFlags.Options.Force, "force", false, "Import service forcefully, replaces existing service if any.")
+	importCmd.Flags().Var(&importFlags.Options.OnConflict, "on-conflict", "What to do with the services which already exist in the destination, one of: skip, overwrite, merge, fail (default is overwrite with --force, else fail)")
+	importCmd.Flags().BoolVar(&importFlags.Options.ForceRecreate, "force-recreate", false, "Delete the existing services and create them again instead of applying the imported services over them with server-side apply, dropping their destination-only revisions, implies --force")
+	importCmd.Flags().BoolVar(&importFlags.Options.Adopt, "adopt", false, "Overwrite the destination objects which were not written by a migration, which are refused otherwise to avoid clobbering objects managed by someone else")
+	importCmd.Flags().BoolVarP(&importFlags.Yes, "yes", "y", false, "Replace the existing objects with --force without asking for confirmation")
+	importCmd.Flags().Var(&importFlags.Options.ForceScope, "force-scope", "The kinds of objects replaced when they exist in the destination, comma separated, one of: services, configmaps, secrets, domainmappings, eventing, implies --force (default is all kinds with --force)")
+	importCmd.Flags().StringSliceVar(&importFlags.Services, "service", nil, "The names or glob patterns of the services to import, comma separated or repeated (default is all services of the bundle)")
+	importCmd.Flags().StringVarP(&importFlags.Selector, "selector", "l", "", "The label selector of the services to import, e.g. team=payments")
+	importCmd.Flags().StringSliceVar(&importFlags.Exclude, "exclude", nil, "The names or glob patterns of the services not to import, with their configmap and secrets, comma separated or repeated")
+	importCmd.Flags().StringVar(&importFlags.ExcludeSelector, "exclude-selector", "", "The label selector of the services not to import, e.g. lifecycle=decommissioned")
+	importCmd.Flags().IntVar(&importFlags.Options.MaxObjectSize, "max-object-size", importFlags.Options.MaxObjectSize, "The maximum size in bytes of a serialized object accepted by the destination cluster")
+	importCmd.Flags().Var(&importFlags.Options.Revisions, "revisions", "The source revisions of every service recreated in the destination, one of: all, traffic (the revisions its traffic routes to), latest, or a number of most recent revisions, the latest revision is always migrated (default is all)")
+	importCmd.Flags().BoolVar(&importFlags.Options.SkipBrokenRevisions, "skip-broken-revisions", false, "Leave out the source revisions which are not Ready, e.g. because their image no longer exists, unless the service routes to them")
+	importCmd.Flags().Var(&importFlags.Options.RevisionCollision, "revision-collision", "What to do with a revision whose name is taken in the destination by a different revision, one of: fail, remap")
+	importCmd.Flags().IntVar(&importFlags.Options.Concurrency, "concurrency", importFlags.Options.Concurrency, "The number of services imported in parallel, the revisions of a service are always imported in order")
+	importCmd.Flags().IntVar(&importFlags.Options.Pace, "pace", 0, "The maximum number of objects written to the destination cluster per minute, slowed down further when the API server throttles writes (default is unlimited)")
+	importCmd.Flags().StringVar(&importFlags.Options.GroupBy, "group-by", "", "A label grouping the services of an application, e.g. app.kubernetes.io/part-of, whose services are imported and verified Ready together and rolled back together when one of them fails")
+	importCmd.Flags().DurationVar(&importFlags.Options.GroupTimeout, "group-timeout", DefaultGroupTimeout, "The maximum time to wait for the services of an application to be Ready with --group-by")
+	importCmd.Flags().Var(&importFlags.Options.InjectFailures, "inject-failures", "Randomly fail writes to the destination to rehearse the recovery of a partial failure, e.g. rate=0.05,seed=42")
+	_ = importCmd.Flags().MarkHidden("inject-failures")
+	importCmd.Flags().BoolVar(&importFlags.Options.RegenerateRevisions, "regenerate-revisions", false, "Recreate none of the source revisions and let the destination generate the revisions of the imported services from their template, routing their traffic to the latest revision")
+	importCmd.Flags().BoolVar(&importFlags.Options.LatestOnly, "latest-only", false, "Move only the current definition of the imported services: recreate none of the source revisions like --regenerate-revisions and wait for the single revision generated by the destination to be Ready")
+	importCmd.Flags().BoolVar(&importFlags.Options.PreserveRevisionHistory, "preserve-revision-history", false, "Annotate the imported revisions with their creation timestamp and configuration generation in the exported cluster")
+	importCmd.Flags().BoolVar(&importFlags.Options.Verify, "verify", false, "Wait for every imported service to be Ready in the destination and request its URL, the service fails unless the URL answers with a success status within --verify-timeout")
+	importCmd.Flags().DurationVar(&importFlags.Options.VerifyTimeout, "verify-timeout", DefaultVerifyTimeout, "The maximum time for an imported service to be Ready and answer its URL with --verify")
+	importCmd.Flags().BoolVar(&importFlags.Options.Wait, "wait", false, "Wait for every imported service to be Ready in the destination before importing the next one, like kn service create, the service fails unless it is Ready within --wait-timeout")
+	importCmd.Flags().DurationVar(&importFlags.Options.WaitTimeout, "wait-timeout", DefaultWaitTimeout, "The maximum time for an imported service to be Ready with --wait")
+	importCmd.Flags().DurationVar(&importFlags.Options.RevisionTimeout, "revision-timeout", DefaultRevisionTimeout, "The maximum time to wait for an imported revision to be Ready in the destination before importing the next revision")
+	importCmd.Flags().StringVar(&importFlags.Options.SourceNetworking, "source-networking", "", "The networking layer of the cluster the bundle was exported from, one of: contour, istio, kourier")
+	importCmd.Flags().StringVar(&importFlags.Options.DestinationNetworking, "destination-networking", "", "The networking layer of the destination cluster, one of: contour, istio, kourier (default is detected from the config-network configmap)")
+	importCmd.Flags().StringVar(&importFlags.AnnotationMapping, "annotation-mapping", "", "A file of src-key=dst-key lines renaming annotations in the destination, an empty dst-key drops the annotation")
+	importCmd.Flags().StringArrayVar(&importFlags.Renames, "rename", nil, "Migrate a service under a new name in the destination as old-name=new-name, with the revisions, configmap and labels derived from its name (can be repeated)")
+	importCmd.Flags().StringVar(&importFlags.RenameFile, "rename-file", "", "A file of old-name=new-name lines renaming services in the destination, overridden by --rename")
+	importCmd.Flags().StringArrayVar(&importFlags.SetLabels, "set-label", nil, "Set a label as key=value on the migrated services, revisions and configmaps (can be repeated)")
+	importCmd.Flags().StringArrayVar(&importFlags.SetAnnotations, "set-annotation", nil, "Set an annotation as key=value on the migrated services, revisions and configmaps (can be repeated)")
+	importCmd.Flags().StringArrayVar(&importFlags.RemoveAnnotations, "remove-annotation", nil, "Remove an annotation by key or glob pattern, e.g. eks.amazonaws.com/*, from the migrated services, revisions and configmaps (can be repeated)")
+	importCmd.Flags().StringArrayVar(&importFlags.NamespaceLabels, "namespace-label", nil, "Set a label of the created destination namespaces as key=value, or remove it as key- (can be repeated)")
+	importCmd.Flags().StringArrayVar(&importFlags.ImageRewrites, "image-rewrite", nil, "Replace the prefix of the container images of the migrated services and revisions as old-prefix=new-prefix, e.g. gcr.io/old=registry.corp/new (can be repeated)")
+	importCmd.Flags().BoolVar(&importFlags.Options.PinDigests, "pin-digests", false, "Replace the tags of the container images of the imported services and revisions by the digests running in the source, so that the destination runs the same images")
+	importCmd.Flags().BoolVar(&importFlags.Options.CopyImages, "copy-images", false, "Copy the container images of the imported services and revisions by digest to --dest-registry and refer to the copies, for destinations which cannot pull from the source registries")
+	importCmd.Flags().StringVar(&importFlags.Options.DestRegistry, "dest-registry", "", "The registry and namespace the images are copied to with --copy-images, e.g. registry.corp/ns")
+	importCmd.Flags().StringArrayVar(&importFlags.Env, "env", nil, "Set an environment variable as KEY=VALUE, or remove it with KEY-, in the serving container of the migrated services and revisions (can be repeated)")
+	importCmd.Flags().StringVar(&importFlags.EnvFile, "env-from-file", "", "A file of KEY=VALUE lines setting environment variables in the serving container of the migrated services and revisions, overridden by --env")
+	importCmd.Flags().IntVar(&importFlags.Options.MaxRetries, "max-retries", DefaultMaxRetries, "The number of retries of an API call failing because a resource is not created yet or because of a conflict")
+	importCmd.Flags().IntVar(&importFlags.Options.MaxThrottledRetries, "max-throttled-retries", DefaultMaxThrottledRetries, "The number of retries of a write the API server throttled with 429 Too Many Requests")
+	importCmd.Flags().DurationVar(&importFlags.Options.RetryBackoff, "retry-backoff", DefaultRetryBackoff, "The delay before the first retry of an API call, doubled with jitter for each next retry up to 1s, or the first delay if longer")
+	importCmd.Flags().BoolVar(&importFlags.Options.RollbackOnFailure, "rollback-on-failure", false, "Delete every object created in the destination and restore the replaced services when the import fails")
+	importCmd.Flags().StringVar(&importFlags.ReportFile, "report-file", "", "Write the report of the run with the flags used and the warnings to this file, as an HTML page if its extension is .html, as JSON otherwise")
+	importCmd.Flags().StringVar(&importFlags.AuditLog, "audit-log", "", "Append a JSON record with the timestamp, cluster, verb, resource, namespace, name and result of every create, update, patch and delete request sent to the clusters to this file")
+	importCmd.Flags().StringVar(&importFlags.EventSink, "event-sink", "", "The URL to send a CloudEvent summarizing the import to once it completed, e.g. the URL of a Broker")
+	importCmd.Flags().StringVar(&importFlags.OwnerAnnotation, "owner-annotation", "", "The annotation of the imported services naming their owner, e.g. a team or an email, to send each owner a CloudEvent summarizing their services to --event-sink")
+	importCmd.Flags().StringVar(&importFlags.PreHook, "pre-hook", "", "A command, or a webhook URL, called with the manifest of every source service as JSON on stdin, or as body of a POST, before it is imported, and with the namespaces before the run, a failure fails the service or the run")
+	importCmd.Flags().StringVar(&importFlags.PostHook, "post-hook", "", "A command, or a webhook URL, called with the manifest of every source service as JSON on stdin, or as body of a POST, once it is imported or failed, and with the report after the run, a failure fails the service")
+	importCmd.Flags().DurationVar(&importFlags.HookTimeout, "hook-timeout", DefaultHookTimeout, "The maximum time a call of --pre-hook or --post-hook may take")
+	importCmd.Flags().BoolVar(&importFlags.DryRun, "dry-run", false, "Print the import plan without changing anything in the destination cluster")
+	importCmd.Flags().StringVarP(&importFlags.Output, "output", "o", "", "Output format of the import report, or of the import plan with --dry-run, one of: json, yaml (default is human readable)")
+	return importCmd
+}
@@ -0,0 +1,270 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package simulated provides an in-memory destination cluster for simulated migrations, backed by the fake
+// clientsets of client-go and Knative Serving so that they stay out of the migrate package
+package simulated
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	apiv1 "k8s.io/api/core/v1"
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	k8s_fake "k8s.io/client-go/kubernetes/fake"
+	k8s_testing "k8s.io/client-go/testing"
+	"knative.dev/kn-plugin-migration/pkg/command"
+	"knative.dev/pkg/apis"
+	duckv1 "knative.dev/pkg/apis/duck/v1"
+	api_serving "knative.dev/serving/pkg/apis/serving"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+	serving_fake "knative.dev/serving/pkg/client/clientset/versioned/fake"
+)
+
+var (
+	servicesResource       = serving_v1_api.SchemeGroupVersion.WithResource("services")
+	configurationsResource = serving_v1_api.SchemeGroupVersion.WithResource("configurations")
+	revisionsResource      = serving_v1_api.SchemeGroupVersion.WithResource("revisions")
+)
+
+// Seed holds the resources a simulated destination namespace contains before the migration
+type Seed struct {
+	// Namespace is true when the namespace exists
+	Namespace  bool
+	ConfigMaps []*apiv1.ConfigMap
+	Services   []serving_v1_api.Service
+	Revisions  []serving_v1_api.Revision
+}
+
+// NewDestination returns in-memory clients of a destination cluster containing the resources of the seed in the
+// namespace, services are reconciled synchronously into their configuration and latest revision as the
+// Knative controllers would do
+func NewDestination(namespace string, seed Seed) (kubernetes.Interface, command.MigrationClient) {
+	objects := []runtime.Object{}
+	if seed.Namespace {
+		objects = append(objects, &apiv1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}})
+	}
+	for _, configmap := range seed.ConfigMaps {
+		configmap = configmap.DeepCopy()
+		configmap.Namespace = namespace
+		objects = append(objects, configmap)
+	}
+	clientSet := k8s_fake.NewSimpleClientset(objects...)
+
+	servingClient := serving_fake.NewSimpleClientset()
+	tracker := servingClient.Tracker()
+	servingClient.PrependReactor("create", "services", func(action k8s_testing.Action) (bool, runtime.Object, error) {
+		service := action.(k8s_testing.CreateAction).GetObject().(*serving_v1_api.Service).DeepCopy()
+		service, err := reconcileService(tracker, action.GetNamespace(), service, false)
+		return true, service, err
+	})
+	servingClient.PrependReactor("create", "revisions", func(action k8s_testing.Action) (bool, runtime.Object, error) {
+		revision := action.(k8s_testing.CreateAction).GetObject().(*serving_v1_api.Revision).DeepCopy()
+		markRevisionReady(revision)
+		return true, revision, tracker.Create(revisionsResource, revision, action.GetNamespace())
+	})
+	servingClient.PrependReactor("delete", "services", func(action k8s_testing.Action) (bool, runtime.Object, error) {
+		// Garbage collect the configuration and revisions of the service, then let the tracker delete it
+		name := action.(k8s_testing.DeleteAction).GetName()
+		return false, nil, deleteService(tracker, action.GetNamespace(), name)
+	})
+	servingClient.PrependReactor("patch", "services", func(action k8s_testing.Action) (bool, runtime.Object, error) {
+		patch := action.(k8s_testing.PatchAction)
+		if patch.GetPatchType() != types.ApplyPatchType {
+			return false, nil, nil
+		}
+		applied := &serving_v1_api.Service{}
+		if err := json.Unmarshal(patch.GetPatch(), applied); err != nil {
+			return true, nil, err
+		}
+		service, err := applyService(tracker, action.GetNamespace(), applied)
+		return true, service, err
+	})
+
+	for _, revision := range seed.Revisions {
+		revision := revision.DeepCopy()
+		revision.Namespace = namespace
+		revision.ResourceVersion = ""
+		_ = tracker.Create(revisionsResource, revision, namespace)
+	}
+	for _, service := range seed.Services {
+		service := service.DeepCopy()
+		service.Namespace = namespace
+		service.ResourceVersion = ""
+		service.Spec.Template.Name = command.TemplateRevisionName(*service)
+		_, _ = reconcileService(tracker, namespace, service, true)
+	}
+	return clientSet, command.NewMigrationClient(servingClient.ServingV1(), namespace)
+}
+
+// reconcileService creates a service with its configuration and latest revision, and marks it Ready,
+// an existing latest revision is adopted instead of failing the service when adopt is true
+func reconcileService(tracker k8s_testing.ObjectTracker, namespace string, service *serving_v1_api.Service, adopt bool) (*serving_v1_api.Service, error) {
+	revisionName := service.Spec.Template.Name
+	if revisionName == "" {
+		revisionName = service.Name + "-00001"
+	}
+	service.Namespace = namespace
+	service.UID = types.UID("simulated-service-" + service.Name)
+	service.Status.LatestCreatedRevisionName = revisionName
+	service.Status.LatestReadyRevisionName = revisionName
+	service.Status.ObservedGeneration = service.Generation
+	service.Status.Conditions = duckv1.Conditions{{Type: apis.ConditionReady, Status: apiv1.ConditionTrue}}
+	err := tracker.Create(servicesResource, service, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &serving_v1_api.Configuration{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      service.Name,
+			Namespace: namespace,
+			UID:       types.UID("simulated-configuration-" + service.Name),
+			Labels:    map[string]string{api_serving.ServiceLabelKey: service.Name},
+		},
+		Spec: service.Spec.ConfigurationSpec,
+	}
+	err = tracker.Create(configurationsResource, config, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	err = createRevision(tracker, config, revisionName, 1, service.Spec.Template.Spec)
+	if err != nil && !(adopt && api_errors.IsAlreadyExists(err)) {
+		return nil, err
+	}
+	return service, nil
+}
+
+// applyService applies a service over an existing one as server-side apply would, the configuration follows
+// the new template and creates its revision unless it exists, the revisions of the previous templates are kept
+func applyService(tracker k8s_testing.ObjectTracker, namespace string, applied *serving_v1_api.Service) (*serving_v1_api.Service, error) {
+	object, err := tracker.Get(servicesResource, namespace, applied.Name)
+	if api_errors.IsNotFound(err) {
+		return reconcileService(tracker, namespace, applied, true)
+	}
+	if err != nil {
+		return nil, err
+	}
+	service := object.(*serving_v1_api.Service).DeepCopy()
+	service.Labels = mergeLabels(service.Labels, applied.Labels)
+	service.Annotations = mergeLabels(service.Annotations, applied.Annotations)
+	traffic := service.Spec.Traffic
+	service.Spec = applied.Spec
+	// The traffic block is owned by its previous managers when the applied service leaves it out
+	if len(applied.Spec.Traffic) == 0 {
+		service.Spec.Traffic = traffic
+	}
+	if service.Generation == 0 {
+		service.Generation = 1
+	}
+	service.Generation++
+	revisionName := service.Spec.Template.Name
+	if revisionName == "" {
+		revisionName = fmt.Sprintf("%s-%05d", service.Name, service.Generation)
+	}
+	service.Status.LatestCreatedRevisionName = revisionName
+	service.Status.LatestReadyRevisionName = revisionName
+	service.Status.ObservedGeneration = service.Generation
+	err = tracker.Update(servicesResource, service, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	object, err = tracker.Get(configurationsResource, namespace, service.Name)
+	if err != nil {
+		return nil, err
+	}
+	config := object.(*serving_v1_api.Configuration).DeepCopy()
+	config.Spec = service.Spec.ConfigurationSpec
+	err = tracker.Update(configurationsResource, config, namespace)
+	if err != nil {
+		return nil, err
+	}
+	err = createRevision(tracker, config, revisionName, service.Generation, service.Spec.Template.Spec)
+	if err != nil && !api_errors.IsAlreadyExists(err) {
+		return nil, err
+	}
+	return service, nil
+}
+
+// createRevision creates a Ready revision of a configuration as the Knative controllers would do
+func createRevision(tracker k8s_testing.ObjectTracker, config *serving_v1_api.Configuration, name string, generation int64, spec serving_v1_api.RevisionSpec) error {
+	revision := &serving_v1_api.Revision{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: config.Namespace,
+			Labels: map[string]string{
+				api_serving.ServiceLabelKey:                   config.Name,
+				api_serving.ConfigurationLabelKey:             config.Name,
+				"serving.knative.dev/configurationGeneration": strconv.FormatInt(generation, 10),
+			},
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: serving_v1_api.SchemeGroupVersion.String(),
+				Kind:       "Configuration",
+				Name:       config.Name,
+				UID:        config.UID,
+			}},
+		},
+		Spec: spec,
+	}
+	markRevisionReady(revision)
+	return tracker.Create(revisionsResource, revision, config.Namespace)
+}
+
+// deleteService deletes the configuration and revisions of a service
+func deleteService(tracker k8s_testing.ObjectTracker, namespace, name string) error {
+	err := tracker.Delete(configurationsResource, namespace, name)
+	if err != nil && !api_errors.IsNotFound(err) {
+		return err
+	}
+	list, err := tracker.List(revisionsResource, serving_v1_api.SchemeGroupVersion.WithKind("Revision"), namespace)
+	if err != nil {
+		return err
+	}
+	selector := labels.SelectorFromSet(labels.Set{api_serving.ServiceLabelKey: name})
+	for _, revision := range list.(*serving_v1_api.RevisionList).Items {
+		if selector.Matches(labels.Set(revision.Labels)) {
+			err = tracker.Delete(revisionsResource, namespace, revision.Name)
+			if err != nil && !api_errors.IsNotFound(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// markRevisionReady marks a revision Ready as the Knative controllers would do once its pods started
+func markRevisionReady(revision *serving_v1_api.Revision) {
+	revision.Status.ObservedGeneration = revision.Generation
+	revision.Status.Conditions = duckv1.Conditions{{Type: apis.ConditionReady, Status: apiv1.ConditionTrue}}
+}
+
+// mergeLabels returns the labels or annotations of values updated with the overrides
+func mergeLabels(values, overrides map[string]string) map[string]string {
+	merged := make(map[string]string, len(values)+len(overrides))
+	for key, value := range values {
+		merged[key] = value
+	}
+	for key, value := range overrides {
+		merged[key] = value
+	}
+	return merged
+}
@@ -0,0 +1,385 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+
+	"github.com/spf13/cobra"
+	batchv1 "k8s.io/api/batch/v1"
+	apiv1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"knative.dev/kn-plugin-migration/pkg/command"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	jobKubeConfigMountPath            = "/etc/kn-migration"
+	jobSourceKubeConfigKey            = "source-kubeconfig"
+	jobDestinationKubeConfigKey       = "destination-kubeconfig"
+	jobDefaultName                    = "kn-migration"
+	jobDefaultNamespace               = "default"
+	jobDefaultBackoffLimit      int32 = 0
+)
+
+type generateJobCmdFlags struct {
+	Name                  string
+	JobNamespace          string
+	Image                 string
+	ServiceAccount        string
+	Namespace             string
+	KubeConfig            string
+	Context               string
+	SourceInCluster       bool
+	DestinationKubeConfig string
+	DestinationContext    string
+	DestinationNamespace  string
+	Force                 bool
+	ForceRecreate         bool
+	Adopt                 bool
+	OnConflict            ConflictStrategy
+	Delete                bool
+	DeleteGracePeriod     time.Duration
+	Output                string
+}
+
+var generateJobFlags generateJobCmdFlags
+
+// NewGenerateJobCommand represents the 'migrate generate-job' command
+func NewGenerateJobCommand() *cobra.Command {
+	generateJobCmd := &cobra.Command{
+		Use:   "generate-job",
+		Short: "Generate a Kubernetes Job manifest that runs the migration inside the destination cluster",
+		Long: `Generate a Kubernetes Job manifest that runs the migration inside the destination cluster.
+
+The manifest contains a ServiceAccount with its RBAC rules, a Secret holding the
+source and destination kubeconfigs and the Job itself. The kubeconfigs are embedded
+as-is, so they must not reference local certificate files or credential plugins.
+
+With --source-in-cluster the Job is meant to be applied to the source cluster: it
+reads the source resources with its own ServiceAccount and the Secret only holds
+the destination kubeconfig.
+
+The ClusterRole only grants what the migration needs with the flags given to
+generate-job: the Job does not copy the data of the persistentvolumeclaims
+(--copy-pvc-data) nor roll back a failed migration (--rollback-on-failure).`,
+		Example: `
+  # Render a migration Job and apply it to the destination cluster
+  kn migrate generate-job --image registry.example.com/kn-migration:latest --namespace default --destination-namespace default | kubectl apply -f -
+  # Render a migration Job that replaces existing services and deletes the source services, into a file
+  kn migrate generate-job --image registry.example.com/kn-migration:latest --namespace default --destination-namespace default --force --delete --output job.yaml
+  # Render a migration Job to apply to the source cluster, reaching the destination cluster with a kubeconfig only
+  kn migrate generate-job --image registry.example.com/kn-migration:latest --namespace default --destination-namespace default --source-in-cluster --destination-kubeconfig prod.yml | kubectl apply -f -`,
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if generateJobFlags.Image == "" {
+				return fmt.Errorf("cannot get the image of the migration job, please use --image to set")
+			}
+			if generateJobFlags.Namespace == "" {
+				return fmt.Errorf("cannot get source cluster namespace, please use --namespace to set")
+			}
+			if generateJobFlags.DestinationNamespace == "" {
+				return fmt.Errorf("cannot get destination cluster namespace, please use --destination-namespace to set")
+			}
+
+			kubeconfigS, kubeconfigD, err := getKubeConfigs(clusterConfig{KubeConfig: generateJobFlags.KubeConfig, Context: generateJobFlags.Context, InCluster: generateJobFlags.SourceInCluster}, clusterConfig{KubeConfig: generateJobFlags.DestinationKubeConfig, Context: generateJobFlags.DestinationContext})
+			if err != nil {
+				return err
+			}
+
+			var sourceKubeConfig []byte
+			if !kubeconfigS.InCluster {
+				sourceKubeConfig, err = command.ReadKubeConfig(kubeconfigS.KubeConfig)
+				if err != nil {
+					return err
+				}
+			}
+			destinationKubeConfig, err := command.ReadKubeConfig(kubeconfigD.KubeConfig)
+			if err != nil {
+				return err
+			}
+
+			manifest, err := renderJobManifest(generateJobFlags, sourceKubeConfig, destinationKubeConfig)
+			if err != nil {
+				return err
+			}
+
+			var out io.Writer = cmd.OutOrStdout()
+			if generateJobFlags.Output != "" {
+				file, err := os.Create(generateJobFlags.Output)
+				if err != nil {
+					return err
+				}
+				defer file.Close()
+				out = file
+			}
+			_, err = out.Write(manifest)
+			return err
+		},
+	}
+
+	generateJobCmd.Flags().StringVar(&generateJobFlags.Name, "job-name", jobDefaultName, "The name of the Job and its supporting resources")
+	generateJobCmd.Flags().StringVar(&generateJobFlags.JobNamespace, "job-namespace", jobDefaultNamespace, "The namespace of the cluster the Job runs in")
+	generateJobCmd.Flags().StringVar(&generateJobFlags.Image, "image", "", "The container image providing the kn-migration binary")
+	generateJobCmd.Flags().StringVar(&generateJobFlags.ServiceAccount, "service-account", "", "The ServiceAccount the Job runs as (default is the job name)")
+
+	generateJobCmd.Flags().StringVarP(&generateJobFlags.Namespace, "namespace", "n", "", "The namespace of the source Knative resources")
+	generateJobCmd.Flags().StringVar(&generateJobFlags.KubeConfig, "kubeconfig", "", "The kubeconfig of the Knative resources (default is KUBECONFIG from environment variable)")
+	generateJobCmd.Flags().StringVar(&generateJobFlags.Context, "context", "", "The context of the kubeconfig of the Knative resources used by the Job (default is the current context)")
+	generateJobCmd.Flags().BoolVar(&generateJobFlags.SourceInCluster, "source-in-cluster", false, "Run the Job in the source cluster, reading the source resources with the ServiceAccount of the Job instead of a kubeconfig")
+	generateJobCmd.Flags().StringVar(&generateJobFlags.DestinationKubeConfig, "destination-kubeconfig", "", "The kubeconfig of the destination Knative resources (default is KUBECONFIG_DESTINATION from environment variable, else the source kubeconfig with --destination-context)")
+	generateJobCmd.Flags().StringVar(&generateJobFlags.DestinationContext, "destination-context", "", "The context of the kubeconfig of the destination Knative resources used by the Job (default is the current context)")
+	generateJobCmd.Flags().StringVar(&generateJobFlags.DestinationNamespace, "destination-namespace", "", "The namespace of the destination Knative resources")
+
+	generateJobCmd.Flags().BoolVar(&generateJobFlags.Force, "force", false, "Migrate service forcefully, replaces existing service if any.")
+	generateJobCmd.Flags().Var(&generateJobFlags.OnConflict, "on-conflict", "What the Job does with the services which already exist in the destination, one of: skip, overwrite, merge, fail (default is overwrite with --force, else fail)")
+	generateJobCmd.Flags().BoolVar(&generateJobFlags.ForceRecreate, "force-recreate", false, "Delete the existing services and create them again instead of applying the migrated services over them, implies --force")
+	generateJobCmd.Flags().BoolVar(&generateJobFlags.Adopt, "adopt", false, "Overwrite the destination objects which were not written by a migration, which are refused otherwise")
+	generateJobCmd.Flags().BoolVar(&generateJobFlags.Delete, "delete", false, "Delete all Knative resources after kn-migration from source cluster, once their destination copies are Ready")
+	generateJobCmd.Flags().DurationVar(&generateJobFlags.DeleteGracePeriod, "delete-grace-period", 0, "The time the destination copies must keep serving before their source services are deleted with --delete, e.g. 10m")
+	generateJobCmd.Flags().StringVarP(&generateJobFlags.Output, "output", "o", "", "The file to write the manifest to (default is stdout)")
+	return generateJobCmd
+}
+
+// jobClusterRoleRules returns the permissions the migration run by the Job with flags needs in both clusters.
+// The Job never copies the data of the claims nor rolls back, so the rules grant nothing those features use.
+func jobClusterRoleRules(flags generateJobCmdFlags) []rbacv1.PolicyRule {
+	rules := []rbacv1.PolicyRule{
+		{
+			APIGroups: []string{""},
+			Resources: []string{"namespaces"},
+			Verbs:     []string{"get", "create"},
+		},
+		{
+			APIGroups: []string{""},
+			Resources: []string{"configmaps", "secrets"},
+			Verbs:     []string{"get", "list", "create", "update"},
+		},
+		{
+			APIGroups: []string{"serving.knative.dev"},
+			Resources: []string{"services", "configurations", "revisions", "routes"},
+			// patch applies the migrated services over the existing ones with server-side apply
+			Verbs: []string{"get", "list", "create", "update", "patch"},
+		},
+		{
+			APIGroups: []string{"serving.knative.dev"},
+			Resources: []string{"domainmappings"},
+			Verbs:     []string{"get", "list", "create", "update"},
+		},
+		{
+			APIGroups: []string{"eventing.knative.dev"},
+			Resources: []string{"brokers", "triggers"},
+			Verbs:     []string{"get", "list", "create", "update"},
+		},
+		{
+			APIGroups: []string{"messaging.knative.dev"},
+			Resources: []string{"channels", "subscriptions"},
+			Verbs:     []string{"get", "list", "create", "update"},
+		},
+		{
+			APIGroups: []string{"flows.knative.dev"},
+			Resources: []string{"sequences", "parallels"},
+			Verbs:     []string{"get", "list", "create", "update"},
+		},
+		{
+			APIGroups: []string{"sources.knative.dev"},
+			Resources: []string{"pingsources", "apiserversources", "sinkbindings", "containersources"},
+			Verbs:     []string{"get", "list", "create", "update"},
+		},
+		{
+			// The claims of the volumes of the services
+			APIGroups: []string{""},
+			Resources: []string{"persistentvolumeclaims"},
+			Verbs:     []string{"get", "create"},
+		},
+		{
+			// The MigratedFrom events recorded on the destination services
+			APIGroups: []string{""},
+			Resources: []string{"events"},
+			Verbs:     []string{"create"},
+		},
+		{
+			APIGroups: []string{""},
+			Resources: []string{"serviceaccounts"},
+			Verbs:     []string{"get", "create"},
+		},
+		{
+			APIGroups: []string{"rbac.authorization.k8s.io"},
+			Resources: []string{"roles", "rolebindings"},
+			Verbs:     []string{"get", "list", "create"},
+		},
+		{
+			// Granting the roles of the copied RoleBindings
+			APIGroups: []string{"rbac.authorization.k8s.io"},
+			Resources: []string{"roles", "clusterroles"},
+			Verbs:     []string{"bind"},
+		},
+	}
+	// --force-recreate deletes the existing destination services and --delete the migrated source services
+	if flags.ForceRecreate || flags.Delete {
+		rules = append(rules, rbacv1.PolicyRule{
+			APIGroups: []string{"serving.knative.dev"},
+			Resources: []string{"services"},
+			Verbs:     []string{"delete"},
+		})
+	}
+	return rules
+}
+
+// renderJobManifest renders the ServiceAccount, RBAC, Secret and Job needed to run
+// the migration in-cluster as a multi-document YAML manifest. The source kubeconfig
+// is left out of the Secret when the Job reads the source cluster it runs in.
+func renderJobManifest(flags generateJobCmdFlags, sourceKubeConfig, destinationKubeConfig []byte) ([]byte, error) {
+	serviceAccount := flags.ServiceAccount
+	if serviceAccount == "" {
+		serviceAccount = flags.Name
+	}
+	labels := map[string]string{
+		"app.kubernetes.io/name":       flags.Name,
+		"app.kubernetes.io/managed-by": "kn-migration",
+	}
+
+	kubeconfigs := map[string][]byte{jobDestinationKubeConfigKey: destinationKubeConfig}
+	if !flags.SourceInCluster {
+		kubeconfigs[jobSourceKubeConfigKey] = sourceKubeConfig
+	}
+
+	objects := []runtime.Object{
+		&apiv1.ServiceAccount{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ServiceAccount"},
+			ObjectMeta: metav1.ObjectMeta{Name: serviceAccount, Namespace: flags.JobNamespace, Labels: labels},
+		},
+		&rbacv1.ClusterRole{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole"},
+			ObjectMeta: metav1.ObjectMeta{Name: flags.Name, Labels: labels},
+			Rules:      jobClusterRoleRules(flags),
+		},
+		&rbacv1.ClusterRoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRoleBinding"},
+			ObjectMeta: metav1.ObjectMeta{Name: flags.Name, Labels: labels},
+			RoleRef: rbacv1.RoleRef{
+				APIGroup: "rbac.authorization.k8s.io",
+				Kind:     "ClusterRole",
+				Name:     flags.Name,
+			},
+			Subjects: []rbacv1.Subject{
+				{Kind: "ServiceAccount", Name: serviceAccount, Namespace: flags.JobNamespace},
+			},
+		},
+		&apiv1.Secret{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+			ObjectMeta: metav1.ObjectMeta{Name: flags.Name + "-kubeconfig", Namespace: flags.JobNamespace, Labels: labels},
+			Type:       apiv1.SecretTypeOpaque,
+			Data:       kubeconfigs,
+		},
+		buildJob(flags, serviceAccount, labels),
+	}
+
+	var manifest bytes.Buffer
+	for i, object := range objects {
+		data, err := yaml.Marshal(object)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			manifest.WriteString("---\n")
+		}
+		manifest.Write(data)
+	}
+	return manifest.Bytes(), nil
+}
+
+func buildJob(flags generateJobCmdFlags, serviceAccount string, labels map[string]string) *batchv1.Job {
+	args := []string{
+		"migrate",
+		"--namespace", flags.Namespace,
+		"--destination-namespace", flags.DestinationNamespace,
+		"--destination-kubeconfig", path.Join(jobKubeConfigMountPath, jobDestinationKubeConfigKey),
+	}
+	if flags.SourceInCluster {
+		args = append(args, "--source-in-cluster")
+	} else {
+		args = append(args, "--kubeconfig", path.Join(jobKubeConfigMountPath, jobSourceKubeConfigKey))
+	}
+	if flags.Context != "" {
+		args = append(args, "--context", flags.Context)
+	}
+	if flags.DestinationContext != "" {
+		args = append(args, "--destination-context", flags.DestinationContext)
+	}
+	if flags.Force {
+		args = append(args, "--force")
+	}
+	if flags.ForceRecreate {
+		args = append(args, "--force-recreate")
+	}
+	if flags.Adopt {
+		args = append(args, "--adopt")
+	}
+	if flags.OnConflict != "" {
+		args = append(args, "--on-conflict", string(flags.OnConflict))
+	}
+	if flags.Delete {
+		args = append(args, "--delete")
+	}
+	// Nobody answers a confirmation prompt in a Job, --force and --delete were confirmed by generating it
+	if flags.Force || flags.ForceRecreate || flags.OnConflict.replaces() || flags.Delete {
+		args = append(args, "--yes")
+	}
+	if flags.DeleteGracePeriod > 0 {
+		args = append(args, "--delete-grace-period", flags.DeleteGracePeriod.String())
+	}
+
+	backoffLimit := jobDefaultBackoffLimit
+	return &batchv1.Job{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "batch/v1", Kind: "Job"},
+		ObjectMeta: metav1.ObjectMeta{Name: flags.Name, Namespace: flags.JobNamespace, Labels: labels},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: apiv1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: apiv1.PodSpec{
+					ServiceAccountName: serviceAccount,
+					RestartPolicy:      apiv1.RestartPolicyNever,
+					Containers: []apiv1.Container{
+						{
+							Name:  "kn-migration",
+							Image: flags.Image,
+							Args:  args,
+							VolumeMounts: []apiv1.VolumeMount{
+								{Name: "kubeconfig", MountPath: jobKubeConfigMountPath, ReadOnly: true},
+							},
+						},
+					},
+					Volumes: []apiv1.Volume{
+						{
+							Name: "kubeconfig",
+							VolumeSource: apiv1.VolumeSource{
+								Secret: &apiv1.SecretVolumeSource{SecretName: flags.Name + "-kubeconfig"},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
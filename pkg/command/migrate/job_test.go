@@ -0,0 +1,138 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func TestRenderJobManifestSourceInCluster(t *testing.T) {
+	flags := generateJobCmdFlags{Name: "kn-migration", JobNamespace: "default", Image: "kn-migration", Namespace: "default", DestinationNamespace: "prod"}
+
+	manifest, err := renderJobManifest(flags, []byte("source"), []byte("destination"))
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(string(manifest), jobSourceKubeConfigKey+":"))
+	assert.Assert(t, strings.Contains(string(manifest), "/etc/kn-migration/source-kubeconfig"))
+
+	flags.SourceInCluster = true
+	manifest, err = renderJobManifest(flags, nil, []byte("destination"))
+	assert.NilError(t, err)
+	assert.Assert(t, !strings.Contains(string(manifest), jobSourceKubeConfigKey))
+	assert.Assert(t, strings.Contains(string(manifest), "--source-in-cluster"))
+	assert.Assert(t, strings.Contains(string(manifest), jobDestinationKubeConfigKey+":"))
+}
+
+func TestGetKubeConfigsSourceInCluster(t *testing.T) {
+	source, destination, err := getKubeConfigs(clusterConfig{InCluster: true}, clusterConfig{KubeConfig: "prod.yml"})
+	assert.NilError(t, err)
+	assert.Equal(t, source.String(), "in-cluster")
+	assert.Equal(t, destination.KubeConfig, "prod.yml")
+
+	_, _, err = getKubeConfigs(clusterConfig{InCluster: true, Context: "staging"}, clusterConfig{KubeConfig: "prod.yml"})
+	assert.ErrorContains(t, err, "--source-in-cluster")
+}
+
+func TestBuildJobConfirmsDestructiveActions(t *testing.T) {
+	flags := generateJobCmdFlags{Name: "kn-migration", JobNamespace: "default", Image: "kn-migration", Namespace: "default", DestinationNamespace: "prod"}
+	args := buildJob(flags, "kn-migration", nil).Spec.Template.Spec.Containers[0].Args
+	assert.Assert(t, !strings.Contains(strings.Join(args, " "), "--yes"))
+
+	flags.Delete = true
+	args = buildJob(flags, "kn-migration", nil).Spec.Template.Spec.Containers[0].Args
+	assert.Assert(t, strings.Contains(strings.Join(args, " "), "--delete --yes"))
+}
+
+// jobRoleAllows returns true if a rule of the ClusterRole grants the verb on the resource
+func jobRoleAllows(role rbacv1.ClusterRole, group, resource, verb string) bool {
+	for _, rule := range role.Rules {
+		if contains(rule.APIGroups, group) && contains(rule.Resources, resource) && contains(rule.Verbs, verb) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// renderJobRole returns the ClusterRole of the Job manifest rendered with flags
+func renderJobRole(t *testing.T, flags generateJobCmdFlags) rbacv1.ClusterRole {
+	manifest, err := renderJobManifest(flags, []byte("source"), []byte("destination"))
+	assert.NilError(t, err)
+	var role rbacv1.ClusterRole
+	for _, document := range strings.Split(string(manifest), "---\n") {
+		if strings.Contains(document, "kind: ClusterRole\n") {
+			assert.NilError(t, yaml.Unmarshal([]byte(document), &role))
+		}
+	}
+	assert.Assert(t, len(role.Rules) > 0)
+	return role
+}
+
+func TestRenderJobManifestRules(t *testing.T) {
+	flags := generateJobCmdFlags{Name: "kn-migration", JobNamespace: "default", Image: "kn-migration", Namespace: "default", DestinationNamespace: "prod"}
+	role := renderJobRole(t, flags)
+
+	// The verbs of the calls made by the migration, --force and --on-conflict apply services with a patch
+	for _, used := range []struct {
+		group, resource string
+		verbs           []string
+	}{
+		{"", "namespaces", []string{"get", "create"}},
+		{"", "configmaps", []string{"get", "list", "create", "update"}},
+		{"", "secrets", []string{"get", "list", "create", "update"}},
+		{"", "serviceaccounts", []string{"get", "create"}},
+		{"", "persistentvolumeclaims", []string{"get", "create"}},
+		{"", "events", []string{"create"}},
+		{"serving.knative.dev", "services", []string{"get", "list", "create", "update", "patch"}},
+		{"serving.knative.dev", "revisions", []string{"get", "list", "create", "update"}},
+		{"serving.knative.dev", "configurations", []string{"get", "list"}},
+		{"serving.knative.dev", "domainmappings", []string{"get", "list", "create", "update"}},
+		{"rbac.authorization.k8s.io", "rolebindings", []string{"get", "list", "create"}},
+	} {
+		for _, verb := range used.verbs {
+			assert.Assert(t, jobRoleAllows(role, used.group, used.resource, verb), "%s %s/%s is not granted", verb, used.group, used.resource)
+		}
+	}
+}
+
+func TestRenderJobManifestRulesFollowFlags(t *testing.T) {
+	flags := generateJobCmdFlags{Name: "kn-migration", JobNamespace: "default", Image: "kn-migration", Namespace: "default", DestinationNamespace: "prod"}
+	role := renderJobRole(t, flags)
+	assert.Assert(t, !jobRoleAllows(role, "serving.knative.dev", "services", "delete"))
+	// Nothing copies the data of the claims nor rolls back from the Job
+	assert.Assert(t, !jobRoleAllows(role, "", "pods", "create"))
+	assert.Assert(t, !jobRoleAllows(role, "", "persistentvolumeclaims", "delete"))
+
+	for _, enable := range []func(*generateJobCmdFlags){
+		func(flags *generateJobCmdFlags) { flags.Delete = true },
+		func(flags *generateJobCmdFlags) { flags.ForceRecreate = true },
+	} {
+		flags := flags
+		enable(&flags)
+		assert.Assert(t, jobRoleAllows(renderJobRole(t, flags), "serving.knative.dev", "services", "delete"))
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"knative.dev/kn-plugin-migration/pkg/command"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// ExportFormatBundle, ExportFormatKustomize and ExportFormatHelm are the formats of 'kn migrate export'
+	ExportFormatBundle    = "bundle"
+	ExportFormatKustomize = "kustomize"
+	ExportFormatHelm      = "helm"
+
+	kustomizationFile = "kustomization.yaml"
+)
+
+// kustomization is the subset of a kustomization.yaml written by the kustomize export
+type kustomization struct {
+	APIVersion string           `json:"apiVersion"`
+	Kind       string           `json:"kind"`
+	Namespace  string           `json:"namespace,omitempty"`
+	Resources  []string         `json:"resources"`
+	Images     []kustomizeImage `json:"images,omitempty"`
+}
+
+// kustomizeImage is an entry of the image transformer of a kustomization
+type kustomizeImage struct {
+	Name    string `json:"name"`
+	NewName string `json:"newName,omitempty"`
+	NewTag  string `json:"newTag,omitempty"`
+	Digest  string `json:"digest,omitempty"`
+}
+
+// kustomizeOverlay is an environment the services are applied to, with its namespace
+type kustomizeOverlay struct {
+	Name      string
+	Namespace string
+}
+
+func newKustomization() *kustomization {
+	return &kustomization{APIVersion: "kustomize.config.k8s.io/v1beta1", Kind: "Kustomization", Resources: []string{}}
+}
+
+// validateExportFormat checks the format of an export
+func validateExportFormat(format string) error {
+	switch format {
+	case ExportFormatBundle, ExportFormatKustomize, ExportFormatHelm:
+		return nil
+	}
+	return fmt.Errorf("unsupported export format %q, use one of: %s, %s, %s", format, ExportFormatBundle, ExportFormatKustomize, ExportFormatHelm)
+}
+
+// parseOverlays parses the name=namespace pairs of --overlay, an overlay without namespace applies to the namespace
+// of the same name. A single overlay named destination applying to the source namespace is returned when empty.
+func parseOverlays(values []string, namespace string) ([]kustomizeOverlay, error) {
+	if len(values) == 0 {
+		return []kustomizeOverlay{{Name: "destination", Namespace: namespace}}, nil
+	}
+	overlays := []kustomizeOverlay{}
+	seen := map[string]bool{}
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		overlay := kustomizeOverlay{Name: parts[0], Namespace: parts[0]}
+		if len(parts) == 2 {
+			overlay.Namespace = parts[1]
+		}
+		if overlay.Name == "" || overlay.Namespace == "" || strings.ContainsAny(overlay.Name, `/\`) || overlay.Name == "." || overlay.Name == ".." {
+			return nil, fmt.Errorf("invalid overlay %q, use <name>=<namespace>", value)
+		}
+		if seen[overlay.Name] {
+			return nil, fmt.Errorf("duplicate overlay %s", overlay.Name)
+		}
+		seen[overlay.Name] = true
+		overlays = append(overlays, overlay)
+	}
+	return overlays, nil
+}
+
+// splitImage splits an image reference in its name, tag and digest
+func splitImage(image string) (string, string, string) {
+	name, digest := image, ""
+	if i := strings.Index(image, "@"); i >= 0 {
+		name, digest = image[:i], image[i+1:]
+	}
+	tag := ""
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, tag = name[:i], name[i+1:]
+	}
+	return name, tag, digest
+}
+
+// gitOpsService returns the service written to a kustomize base or a Helm chart, without namespace so that they
+// set it. Its revisions are not written since the destination creates them, so that a traffic split between
+// revisions is replaced by the default route to the latest revision.
+func gitOpsService(serviceS serving_v1_api.Service) (*serving_v1_api.Service, bool) {
+	service := &serving_v1_api.Service{
+		TypeMeta:   metav1.TypeMeta{APIVersion: serving_v1_api.SchemeGroupVersion.String(), Kind: "Service"},
+		ObjectMeta: command.SanitizeObjectMeta(serviceS.ObjectMeta, ""),
+		Spec:       *serviceS.Spec.DeepCopy(),
+	}
+	for _, target := range service.Spec.Traffic {
+		if target.RevisionName != "" && target.RevisionName != service.Spec.Template.Name {
+			service.Spec.Traffic = nil
+			return service, true
+		}
+	}
+	return service, false
+}
+
+// exportKustomize writes the selected services of the namespace and their configmaps to a kustomize base, with an
+// overlay per environment setting its namespace and listing the images of the services for the image transformer
+func exportKustomize(ctx context.Context, out io.Writer, clientSet kubernetes.Interface, migrationClient command.MigrationClient, namespace string, filter *serviceFilter, dir string, overlays []kustomizeOverlay) error {
+	services, err := listSourceServices(ctx, migrationClient, filter)
+	if err != nil {
+		return err
+	}
+
+	base := newKustomization()
+	images := map[string]kustomizeImage{}
+	secrets := map[string]bool{}
+	write := func(file string, object interface{}) error {
+		data, err := yaml.Marshal(object)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Join(dir, "base", filepath.Dir(file)), 0755); err != nil {
+			return err
+		}
+		base.Resources = append(base.Resources, file)
+		return ioutil.WriteFile(filepath.Join(dir, "base", file), data, 0644)
+	}
+	for _, serviceS := range services.Items {
+		configmap, err := getConfigmap(ctx, clientSet, namespace, generateConfigmapName(serviceS.Name))
+		if err != nil && !api_errors.IsNotFound(err) {
+			return err
+		}
+		if configmap != nil {
+			configmap.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"}
+			configmap.ObjectMeta = command.SanitizeObjectMeta(configmap.ObjectMeta, "")
+			if err := write(path.Join("configmaps", configmap.Name+".yaml"), configmap); err != nil {
+				return err
+			}
+		}
+
+		service, split := gitOpsService(serviceS)
+		if split {
+			fmt.Fprintln(out, color.YellowString("Service %s splits its traffic between revisions, the kustomize base routes its traffic to its latest revision", serviceS.Name))
+		}
+		if err := write(path.Join("services", service.Name+".yaml"), service); err != nil {
+			return err
+		}
+		for _, container := range service.Spec.Template.Spec.Containers {
+			name, tag, digest := splitImage(container.Image)
+			images[name] = kustomizeImage{Name: name, NewName: name, NewTag: tag, Digest: digest}
+		}
+		for _, name := range referencedSecrets(serviceS.Spec.Template) {
+			secrets[name] = true
+		}
+		fmt.Fprintln(out, "Exported service", color.CyanString(service.Name))
+	}
+	if err := writeKustomization(filepath.Join(dir, "base"), base); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(images))
+	for name := range images {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, overlay := range overlays {
+		kustomization := newKustomization()
+		kustomization.Namespace = overlay.Namespace
+		kustomization.Resources = []string{"../../base"}
+		for _, name := range names {
+			kustomization.Images = append(kustomization.Images, images[name])
+		}
+		if err := writeKustomization(filepath.Join(dir, "overlays", overlay.Name), kustomization); err != nil {
+			return err
+		}
+	}
+
+	if len(secrets) > 0 {
+		fmt.Fprintln(out, color.YellowString("The %d secret(s) referenced by the services are not written to %s, they must be provided in the destination", len(secrets), dir))
+	}
+	fmt.Fprintln(out, "Exported", color.CyanString("%d", len(services.Items)), "service(s) of namespace", color.BlueString(namespace), "to the kustomize base and", len(overlays), "overlay(s) of", dir)
+	return nil
+}
+
+func writeKustomization(dir string, kustomization *kustomization) error {
+	data, err := yaml.Marshal(kustomization)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, kustomizationFile), data, 0644)
+}
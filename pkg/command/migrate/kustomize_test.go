@@ -0,0 +1,117 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/assert"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8s_fake "k8s.io/client-go/kubernetes/fake"
+	"knative.dev/kn-plugin-migration/pkg/command"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+	serving_fake "knative.dev/serving/pkg/client/clientset/versioned/fake"
+	"sigs.k8s.io/yaml"
+)
+
+func TestParseOverlays(t *testing.T) {
+	overlays, err := parseOverlays(nil, "default")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, overlays, []kustomizeOverlay{{Name: "destination", Namespace: "default"}})
+
+	overlays, err = parseOverlays([]string{"staging", "prod=payments-prod"}, "default")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, overlays, []kustomizeOverlay{{Name: "staging", Namespace: "staging"}, {Name: "prod", Namespace: "payments-prod"}})
+
+	_, err = parseOverlays([]string{"prod=a", "prod=b"}, "default")
+	assert.ErrorContains(t, err, "duplicate overlay prod")
+	for _, value := range []string{"", "=prod", "prod=", "../prod", ".."} {
+		_, err = parseOverlays([]string{value}, "default")
+		assert.ErrorContains(t, err, "invalid overlay")
+	}
+}
+
+func TestSplitImage(t *testing.T) {
+	for image, expected := range map[string][3]string{
+		"gcr.io/app/hello":                   {"gcr.io/app/hello", "", ""},
+		"gcr.io/app/hello:v1":                {"gcr.io/app/hello", "v1", ""},
+		"localhost:5000/hello":               {"localhost:5000/hello", "", ""},
+		"localhost:5000/hello:v1":            {"localhost:5000/hello", "v1", ""},
+		"gcr.io/app/hello@sha256:abc":        {"gcr.io/app/hello", "", "sha256:abc"},
+		"gcr.io/app/hello:v1@sha256:abc":     {"gcr.io/app/hello", "v1", "sha256:abc"},
+		"localhost:5000/hello:v1@sha256:abc": {"localhost:5000/hello", "v1", "sha256:abc"},
+	} {
+		name, tag, digest := splitImage(image)
+		assert.DeepEqual(t, [3]string{name, tag, digest}, expected)
+	}
+}
+
+func TestExportKustomize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kustomize")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	percent := func(p int64) *int64 { return &p }
+	hello := &serving_v1_api.Service{ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "default", ResourceVersion: "42", Labels: map[string]string{"team": "payments"}}}
+	hello.Spec.Template.Spec.Containers = []apiv1.Container{{Image: "gcr.io/app/hello:v1"}}
+	hello.Spec.Traffic = []serving_v1_api.TrafficTarget{{RevisionName: "hello-00001", Percent: percent(50)}, {RevisionName: "hello-00002", Percent: percent(50)}}
+	bye := &serving_v1_api.Service{ObjectMeta: metav1.ObjectMeta{Name: "bye", Namespace: "default"}}
+	bye.Spec.Template.Spec.Containers = []apiv1.Container{{Image: "gcr.io/app/bye@sha256:abc"}}
+	clientSet := k8s_fake.NewSimpleClientset(&apiv1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "hello-config", Namespace: "default", UID: "1234"}, Data: map[string]string{"key": "value"}})
+	migrationClient := command.NewMigrationClient(serving_fake.NewSimpleClientset(hello, bye).ServingV1(), "default")
+	filter, _ := newServiceFilter(nil, "")
+	overlays := []kustomizeOverlay{{Name: "staging", Namespace: "staging"}, {Name: "prod", Namespace: "payments-prod"}}
+
+	assert.NilError(t, exportKustomize(context.Background(), io.Discard, clientSet, migrationClient, "default", filter, dir, overlays))
+
+	base := readKustomization(t, filepath.Join(dir, "base"))
+	assert.DeepEqual(t, base.Resources, []string{"services/bye.yaml", "configmaps/hello-config.yaml", "services/hello.yaml"})
+	assert.Equal(t, base.Namespace, "")
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "base", "services", "hello.yaml"))
+	assert.NilError(t, err)
+	service := serving_v1_api.Service{}
+	assert.NilError(t, yaml.Unmarshal(data, &service))
+	assert.Equal(t, service.Kind, "Service")
+	assert.Equal(t, service.Namespace, "")
+	assert.Equal(t, service.ResourceVersion, "")
+	assert.Equal(t, service.Labels["team"], "payments")
+	// The revisions are not exported, the split between them is dropped
+	assert.Equal(t, len(service.Spec.Traffic), 0)
+
+	prod := readKustomization(t, filepath.Join(dir, "overlays", "prod"))
+	assert.Equal(t, prod.Namespace, "payments-prod")
+	assert.DeepEqual(t, prod.Resources, []string{"../../base"})
+	assert.DeepEqual(t, prod.Images, []kustomizeImage{
+		{Name: "gcr.io/app/bye", NewName: "gcr.io/app/bye", Digest: "sha256:abc"},
+		{Name: "gcr.io/app/hello", NewName: "gcr.io/app/hello", NewTag: "v1"},
+	})
+	assert.Equal(t, readKustomization(t, filepath.Join(dir, "overlays", "staging")).Namespace, "staging")
+}
+
+func readKustomization(t *testing.T, dir string) kustomization {
+	data, err := ioutil.ReadFile(filepath.Join(dir, kustomizationFile))
+	assert.NilError(t, err)
+	kustomization := kustomization{}
+	assert.NilError(t, yaml.Unmarshal(data, &kustomization))
+	assert.Equal(t, kustomization.Kind, "Kustomization")
+	return kustomization
+}
@@ -0,0 +1,173 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/fatih/color"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// secretGenerator is a kind of custom resource whose controller generates a Secret, so that the secret material
+// is kept in the control plane of each cluster
+type secretGenerator struct {
+	Kind     string
+	Resource schema.GroupVersionResource
+}
+
+// secretGenerators are the custom resources migrated instead of the secrets they generate
+var secretGenerators = []secretGenerator{
+	{Kind: "SealedSecret", Resource: schema.GroupVersionResource{Group: "bitnami.com", Version: "v1alpha1", Resource: "sealedsecrets"}},
+	{Kind: "ExternalSecret", Resource: schema.GroupVersionResource{Group: "external-secrets.io", Version: "v1beta1", Resource: "externalsecrets"}},
+}
+
+var (
+	secretStores        = schema.GroupVersionResource{Group: "external-secrets.io", Version: "v1beta1", Resource: "secretstores"}
+	clusterSecretStores = schema.GroupVersionResource{Group: "external-secrets.io", Version: "v1beta1", Resource: "clustersecretstores"}
+)
+
+// secretGeneratorOf returns the custom resource controlling a secret and its name, if the secret is generated
+func secretGeneratorOf(secret *apiv1.Secret) (secretGenerator, string, bool) {
+	owner := metav1.GetControllerOf(secret)
+	if owner == nil {
+		return secretGenerator{}, "", false
+	}
+	group := strings.SplitN(owner.APIVersion, "/", 2)[0]
+	for _, generator := range secretGenerators {
+		if generator.Kind == owner.Kind && generator.Resource.Group == group {
+			return generator, owner.Name, true
+		}
+	}
+	return secretGenerator{}, "", false
+}
+
+// secretGeneratorClients returns the dynamic clients of the secret handler, nil when the generated secrets are
+// copied as any other secret, e.g. when importing a bundle
+func secretGeneratorClients(options *MigrationOptions) (dynamic.Interface, dynamic.Interface) {
+	handler, ok := options.resourceHandlers().handlers[SecretKind].(secretHandler)
+	if !ok {
+		return nil, nil
+	}
+	return handler.clientS, handler.clientD
+}
+
+// getSecretGenerator returns the custom resource generating a secret of the source namespace
+func getSecretGenerator(ctx context.Context, client dynamic.Interface, generator secretGenerator, namespace, name string) (*unstructured.Unstructured, error) {
+	object, err := client.Resource(generator.Resource).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	return object, sourceError(err)
+}
+
+// warnSecretGenerator warns about what the destination needs to generate the secret of a custom resource: the
+// sealing key of the source for a SealedSecret, which is also bound to its namespace unless it is namespace or
+// cluster wide, and the secret store of an ExternalSecret
+func warnSecretGenerator(ctx context.Context, client dynamic.Interface, built *unstructured.Unstructured, namespaceS string, options *MigrationOptions) {
+	switch built.GetKind() {
+	case "SealedSecret":
+		annotations := built.GetAnnotations()
+		scoped := annotations["sealedsecrets.bitnami.com/namespace-wide"] != "true" && annotations["sealedsecrets.bitnami.com/cluster-wide"] != "true"
+		if scoped && built.GetNamespace() != namespaceS {
+			options.warn("SealedSecret %s is sealed for namespace %s, the destination controller cannot unseal it in namespace %s", built.GetName(), namespaceS, built.GetNamespace())
+		} else {
+			options.warn("SealedSecret %s is only unsealed if the destination controller holds the sealing key of the source", built.GetName())
+		}
+	case "ExternalSecret":
+		name, _, _ := unstructured.NestedString(built.Object, "spec", "secretStoreRef", "name")
+		kind, _, _ := unstructured.NestedString(built.Object, "spec", "secretStoreRef", "kind")
+		if name == "" {
+			return
+		}
+		var err error
+		if kind == "ClusterSecretStore" {
+			_, err = client.Resource(clusterSecretStores).Get(ctx, name, metav1.GetOptions{})
+		} else {
+			kind = "SecretStore"
+			_, err = client.Resource(secretStores).Namespace(built.GetNamespace()).Get(ctx, name, metav1.GetOptions{})
+		}
+		if api_errors.IsNotFound(err) {
+			options.warn("ExternalSecret %s reads from %s %s which does not exist in the destination", built.GetName(), kind, name)
+		}
+	}
+}
+
+// applySecretGenerator creates the custom resource generating a secret in the destination and returns false if the
+// existing one was kept. As for secrets, an existing resource with a different spec is only replaced when forced.
+func applySecretGenerator(ctx context.Context, client dynamic.Interface, generator secretGenerator, built *unstructured.Unstructured, options *MigrationOptions) (bool, error) {
+	built.SetAnnotations(options.withOwnership(built.GetAnnotations()))
+	objects := client.Resource(generator.Resource).Namespace(built.GetNamespace())
+	existing, err := objects.Get(ctx, built.GetName(), metav1.GetOptions{})
+	switch {
+	case api_errors.IsNotFound(err):
+		err = options.paced(ctx, "create "+generator.Resource.Resource+" "+built.GetName(), func() error {
+			_, err := objects.Create(ctx, built, metav1.CreateOptions{})
+			return destinationError(err)
+		})
+		if api_errors.IsNotFound(err) {
+			return false, fmt.Errorf("cannot migrate %s %s: the destination cluster does not serve %s, is its controller installed?", generator.Kind, built.GetName(), generator.Resource.GroupResource())
+		}
+		if err != nil {
+			return false, err
+		}
+		options.changes().createdDynamic(generator.Kind, generator.Resource, built.GetNamespace(), built.GetName(), client)
+		fmt.Fprintln(options.out(), "Migrated", strings.ToLower(generator.Kind), color.CyanString(built.GetName()), "Successfully")
+		return true, nil
+	case err != nil:
+		return false, err
+	case equality.Semantic.DeepEqual(existing.Object["spec"], built.Object["spec"]):
+		fmt.Fprintln(options.out(), generator.Kind, color.CyanString(built.GetName()), "already exists in the destination with the same spec, skip migrate", strings.ToLower(generator.Kind))
+		return false, nil
+	case !options.forces(ForceSecrets):
+		fmt.Fprintln(options.out(), generator.Kind, color.CyanString(built.GetName()), "already exists in the destination and secrets are not forced, keep the destination", strings.ToLower(generator.Kind))
+		return false, nil
+	}
+	if err := options.checkOwnership(generator.Kind, built.GetName(), existing.GetAnnotations()); err != nil {
+		return false, err
+	}
+
+	built.SetResourceVersion(existing.GetResourceVersion())
+	err = options.paced(ctx, "update "+generator.Resource.Resource+" "+built.GetName(), func() error {
+		_, err := objects.Update(ctx, built, metav1.UpdateOptions{})
+		return destinationError(err)
+	})
+	if err != nil {
+		return false, err
+	}
+	options.changes().updatedDynamic(generator.Kind, generator.Resource, existing, client)
+	fmt.Fprintln(options.out(), "Replaced", strings.ToLower(generator.Kind), color.CyanString(built.GetName()), "Successfully")
+	return true, nil
+}
+
+// planSecretGenerator returns the plan entry of the custom resource generating a secret
+func planSecretGenerator(ctx context.Context, client dynamic.Interface, generator secretGenerator, name, secretName, namespaceD string, options *MigrationOptions) (planEntry, error) {
+	entry := planEntry{Kind: generator.Kind, Name: name, Namespace: namespaceD, Cluster: "destination", Action: planActionCreate, Reason: "generates secret " + secretName + ", which is not copied"}
+	_, err := client.Resource(generator.Resource).Namespace(namespaceD).Get(ctx, name, metav1.GetOptions{})
+	switch {
+	case err == nil && options.forces(ForceSecrets):
+		entry.Action, entry.Reason = planActionReplace, "already exists and secrets are forced"
+	case err == nil:
+		entry.Action, entry.Reason = planActionSkip, "already exists and secrets are not forced, the destination "+strings.ToLower(generator.Kind)+" is kept"
+	case !api_errors.IsNotFound(err):
+		return entry, err
+	}
+	return entry, nil
+}
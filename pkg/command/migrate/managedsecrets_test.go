@@ -0,0 +1,144 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"testing"
+
+	"gotest.tools/assert"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamic_fake "k8s.io/client-go/dynamic/fake"
+	k8s_fake "k8s.io/client-go/kubernetes/fake"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+// newGeneratedSecret returns a secret of the default namespace controlled by a custom resource
+func newGeneratedSecret(name, apiVersion, kind string) *apiv1.Secret {
+	controller := true
+	return &apiv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", OwnerReferences: []metav1.OwnerReference{
+			{APIVersion: apiVersion, Kind: kind, Name: name, Controller: &controller},
+		}},
+		Data: map[string][]byte{"password": []byte("s3cr3t")},
+	}
+}
+
+func newSecretGenerator(generator secretGenerator, name string, spec map[string]interface{}) *unstructured.Unstructured {
+	object := &unstructured.Unstructured{Object: map[string]interface{}{"kind": generator.Kind, "spec": spec}}
+	object.SetAPIVersion(generator.Resource.GroupVersion().String())
+	object.SetName(name)
+	object.SetNamespace("default")
+	object.SetResourceVersion("42")
+	return object
+}
+
+func TestSecretGeneratorOf(t *testing.T) {
+	generator, name, ok := secretGeneratorOf(newGeneratedSecret("token", "bitnami.com/v1alpha1", "SealedSecret"))
+	assert.Assert(t, ok)
+	assert.Equal(t, generator.Kind, "SealedSecret")
+	assert.Equal(t, name, "token")
+
+	// Any served version of ExternalSecret generates the secret
+	generator, _, ok = secretGeneratorOf(newGeneratedSecret("token", "external-secrets.io/v1", "ExternalSecret"))
+	assert.Assert(t, ok)
+	assert.Equal(t, generator.Kind, "ExternalSecret")
+
+	_, _, ok = secretGeneratorOf(newGeneratedSecret("token", "example.com/v1", "SealedSecret"))
+	assert.Assert(t, !ok)
+	_, _, ok = secretGeneratorOf(&apiv1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "token"}})
+	assert.Assert(t, !ok)
+}
+
+func TestSecretHandlerWithGenerators(t *testing.T) {
+	clientSetS := k8s_fake.NewSimpleClientset(
+		newGeneratedSecret("sealed", "bitnami.com/v1alpha1", "SealedSecret"),
+		newGeneratedSecret("external", "external-secrets.io/v1beta1", "ExternalSecret"),
+		&apiv1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "plain", Namespace: "default"}},
+	)
+	clientSetD := k8s_fake.NewSimpleClientset()
+	clientS := dynamic_fake.NewSimpleDynamicClient(runtime.NewScheme(),
+		newSecretGenerator(secretGenerators[0], "sealed", map[string]interface{}{"encryptedData": map[string]interface{}{"password": "AgBy3i4OJSWK"}}),
+		newSecretGenerator(secretGenerators[1], "external", map[string]interface{}{"secretStoreRef": map[string]interface{}{"name": "vault", "kind": "ClusterSecretStore"}}),
+	)
+	clientD := dynamic_fake.NewSimpleDynamicClient(runtime.NewScheme())
+
+	service := &serving_v1_api.Service{ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "default"}}
+	service.Spec.Template.Spec.ImagePullSecrets = []apiv1.LocalObjectReference{{Name: "sealed"}, {Name: "external"}, {Name: "plain"}}
+	options := NewMigrationOptions()
+	options.ResourceHandlers.Register(SecretKind, NewSecretHandler(clientS, clientD))
+	m := &ServiceMigration{
+		SourceName:           "hello",
+		Service:              service,
+		SourceNamespace:      "default",
+		DestinationNamespace: "prod",
+		ClientSetD:           clientSetD,
+		Options:              options,
+		source:               newLiveSource(clientSetS, nil, "default"),
+	}
+
+	handler := options.ResourceHandlers.handlers[SecretKind]
+	objects, err := handler.Discover(context.Background(), m)
+	assert.NilError(t, err)
+	objects, err = handler.Transform(context.Background(), m, objects)
+	assert.NilError(t, err)
+	assert.NilError(t, handler.Apply(context.Background(), m, objects))
+
+	// Only the plain secret is copied, the generated secrets are left to the controllers of the destination
+	secrets, err := clientSetD.CoreV1().Secrets("prod").List(context.Background(), metav1.ListOptions{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(secrets.Items), 1)
+	assert.Equal(t, secrets.Items[0].Name, "plain")
+
+	sealed, err := clientD.Resource(secretGenerators[0].Resource).Namespace("prod").Get(context.Background(), "sealed", metav1.GetOptions{})
+	assert.NilError(t, err)
+	data, _, _ := unstructured.NestedString(sealed.Object, "spec", "encryptedData", "password")
+	assert.Equal(t, data, "AgBy3i4OJSWK")
+	assert.Equal(t, sealed.GetResourceVersion(), "")
+	_, err = clientD.Resource(secretGenerators[1].Resource).Namespace("prod").Get(context.Background(), "external", metav1.GetOptions{})
+	assert.NilError(t, err)
+
+	assert.DeepEqual(t, m.dependencies, []string{"ExternalSecret external", "Secret plain", "SealedSecret sealed"})
+	assert.DeepEqual(t, options.warnings(), []string{
+		"ExternalSecret external reads from ClusterSecretStore vault which does not exist in the destination",
+		"SealedSecret sealed is sealed for namespace default, the destination controller cannot unseal it in namespace prod",
+	})
+
+	// Existing resources are kept unless secrets are forced
+	applied, err := applySecretGenerator(context.Background(), clientD, secretGenerators[0], buildEventingObject(*newSecretGenerator(secretGenerators[0], "sealed", map[string]interface{}{}), "default", "prod"), options)
+	assert.NilError(t, err)
+	assert.Assert(t, !applied)
+	options.Force = true
+	applied, err = applySecretGenerator(context.Background(), clientD, secretGenerators[0], buildEventingObject(*newSecretGenerator(secretGenerators[0], "sealed", map[string]interface{}{}), "default", "prod"), options)
+	assert.NilError(t, err)
+	assert.Assert(t, applied)
+}
+
+func TestSecretHandlerWithoutClients(t *testing.T) {
+	// Without the dynamic clients, e.g. when importing a bundle, the generated secrets are copied
+	clientSetS := k8s_fake.NewSimpleClientset(newGeneratedSecret("sealed", "bitnami.com/v1alpha1", "SealedSecret"))
+	service := &serving_v1_api.Service{ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "default"}}
+	service.Spec.Template.Spec.ImagePullSecrets = []apiv1.LocalObjectReference{{Name: "sealed"}}
+	m := &ServiceMigration{SourceName: "hello", Service: service, SourceNamespace: "default", source: newLiveSource(clientSetS, nil, "default")}
+
+	objects, err := secretHandler{}.Discover(context.Background(), m)
+	assert.NilError(t, err)
+	assert.Equal(t, len(objects), 1)
+	_, ok := objects[0].(*apiv1.Secret)
+	assert.Assert(t, ok)
+}
@@ -0,0 +1,161 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+// MetadataRules rewrite the labels and annotations of the migrated services, revisions and configmaps,
+// the removed annotations are dropped before the labels and annotations are set
+type MetadataRules struct {
+	SetLabels      map[string]string
+	SetAnnotations map[string]string
+	// RemoveAnnotations are annotation keys or glob patterns, e.g. eks.amazonaws.com/*
+	RemoveAnnotations []string
+}
+
+// parseMetadataRules parses the key=value pairs of --set-label and --set-annotation and the keys of --remove-annotation
+func parseMetadataRules(setLabels, setAnnotations, removeAnnotations []string) (MetadataRules, error) {
+	rules := MetadataRules{SetLabels: map[string]string{}, SetAnnotations: map[string]string{}}
+	for _, pair := range setLabels {
+		key, value, err := parseMetadataPair("--set-label", pair)
+		if err != nil {
+			return rules, err
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return rules, fmt.Errorf("invalid --set-label %q: %s", pair, strings.Join(errs, ", "))
+		}
+		rules.SetLabels[key] = value
+	}
+	for _, pair := range setAnnotations {
+		key, value, err := parseMetadataPair("--set-annotation", pair)
+		if err != nil {
+			return rules, err
+		}
+		rules.SetAnnotations[key] = value
+	}
+	for _, pattern := range removeAnnotations {
+		pattern = strings.TrimSpace(pattern)
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return rules, fmt.Errorf("invalid --remove-annotation %q, expected an annotation key or a glob pattern", pattern)
+		}
+		rules.RemoveAnnotations = append(rules.RemoveAnnotations, pattern)
+	}
+	return rules, nil
+}
+
+// parseMetadataPair splits a key=value pair and validates the key
+func parseMetadataPair(flag, pair string) (string, string, error) {
+	parts := strings.SplitN(pair, "=", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("invalid %s %q, expected key=value", flag, pair)
+	}
+	key := strings.TrimSpace(parts[0])
+	if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+		return "", "", fmt.Errorf("invalid %s %q: %s", flag, pair, strings.Join(errs, ", "))
+	}
+	return key, parts[1], nil
+}
+
+// empty returns true if the rules change nothing
+func (r MetadataRules) empty() bool {
+	return len(r.SetLabels) == 0 && len(r.SetAnnotations) == 0 && len(r.RemoveAnnotations) == 0
+}
+
+// removes returns true if an annotation is removed by the rules
+func (r MetadataRules) removes(key string) bool {
+	for _, pattern := range r.RemoveAnnotations {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// apply rewrites the labels and annotations of an object and returns the applied changes, the maps are replaced
+// by copies since the source objects may be shared
+func (r MetadataRules) apply(meta *metav1.ObjectMeta) []string {
+	changes := []string{}
+	if len(r.SetLabels) > 0 {
+		labels := make(map[string]string, len(meta.Labels)+len(r.SetLabels))
+		for key, value := range meta.Labels {
+			labels[key] = value
+		}
+		for key, value := range r.SetLabels {
+			if current, ok := labels[key]; !ok || current != value {
+				changes = append(changes, fmt.Sprintf("set label %s to %s", key, value))
+			}
+			labels[key] = value
+		}
+		meta.Labels = labels
+	}
+	if len(r.RemoveAnnotations) > 0 || len(r.SetAnnotations) > 0 {
+		annotations := make(map[string]string, len(meta.Annotations)+len(r.SetAnnotations))
+		for key, value := range meta.Annotations {
+			if r.removes(key) {
+				changes = append(changes, fmt.Sprintf("removed annotation %s", key))
+				continue
+			}
+			annotations[key] = value
+		}
+		for key, value := range r.SetAnnotations {
+			if current, ok := annotations[key]; !ok || current != value {
+				changes = append(changes, fmt.Sprintf("set annotation %s to %s", key, value))
+			}
+			annotations[key] = value
+		}
+		if len(annotations) == 0 {
+			annotations = nil
+		}
+		meta.Annotations = annotations
+	}
+	sort.Strings(changes)
+	return changes
+}
+
+// rewriteMetadata applies the metadata rules of the options to a service, its template, its revisions and its configmap
+func rewriteMetadata(serviceS *serving_v1_api.Service, revisionsS *serving_v1_api.RevisionList, configmapS *apiv1.ConfigMap, options *MigrationOptions) {
+	rules := options.MetadataRules
+	if rules.empty() {
+		return
+	}
+	reportMetadataChanges(options.out(), "service", serviceS.Name, rules.apply(&serviceS.ObjectMeta))
+	reportMetadataChanges(options.out(), "the template of service", serviceS.Name, rules.apply(&serviceS.Spec.Template.ObjectMeta))
+	for i := range revisionsS.Items {
+		revision := &revisionsS.Items[i]
+		reportMetadataChanges(options.out(), "revision", revision.Name, rules.apply(&revision.ObjectMeta))
+	}
+	if configmapS != nil {
+		reportMetadataChanges(options.out(), "configmap", configmapS.Name, rules.apply(&configmapS.ObjectMeta))
+	}
+}
+
+// reportMetadataChanges prints the changes of the metadata of an object
+func reportMetadataChanges(out io.Writer, kind, name string, changes []string) {
+	for _, change := range changes {
+		fmt.Fprintln(out, "Rewrote metadata of", kind, color.CyanString(name)+":", change)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"testing"
+
+	"gotest.tools/assert"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+func TestParseMetadataRules(t *testing.T) {
+	rules, err := parseMetadataRules([]string{"cluster=prod-2"}, []string{"example.com/owner=team-a", "example.com/empty="}, []string{"eks.amazonaws.com/*"})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, rules.SetLabels, map[string]string{"cluster": "prod-2"})
+	assert.DeepEqual(t, rules.SetAnnotations, map[string]string{"example.com/owner": "team-a", "example.com/empty": ""})
+	assert.DeepEqual(t, rules.RemoveAnnotations, []string{"eks.amazonaws.com/*"})
+
+	_, err = parseMetadataRules([]string{"cluster"}, nil, nil)
+	assert.ErrorContains(t, err, "invalid --set-label \"cluster\", expected key=value")
+	_, err = parseMetadataRules([]string{"cluster=prod 2"}, nil, nil)
+	assert.ErrorContains(t, err, "invalid --set-label \"cluster=prod 2\"")
+	_, err = parseMetadataRules(nil, []string{"not a key=value"}, nil)
+	assert.ErrorContains(t, err, "invalid --set-annotation")
+	_, err = parseMetadataRules(nil, nil, []string{"[example.com"})
+	assert.ErrorContains(t, err, "invalid --remove-annotation")
+}
+
+func TestRewriteMetadata(t *testing.T) {
+	rules, err := parseMetadataRules([]string{"cluster=prod-2"}, []string{"example.com/domain=new.example.com"}, []string{"eks.amazonaws.com/*"})
+	assert.NilError(t, err)
+	options := &MigrationOptions{MetadataRules: rules}
+	sourceAnnotations := map[string]string{"eks.amazonaws.com/role-arn": "arn", "example.com/domain": "old.example.com", "example.com/keep": "yes"}
+	service := serving_v1_api.Service{ObjectMeta: metav1.ObjectMeta{Name: "hello", Annotations: sourceAnnotations}}
+	revisions := &serving_v1_api.RevisionList{Items: []serving_v1_api.Revision{
+		{ObjectMeta: metav1.ObjectMeta{Name: "hello-00001", Labels: map[string]string{"serving.knative.dev/service": "hello"}}},
+	}}
+	configmap := &apiv1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "hello-config"}}
+
+	rewriteMetadata(&service, revisions, configmap, options)
+
+	assert.DeepEqual(t, service.Labels, map[string]string{"cluster": "prod-2"})
+	assert.DeepEqual(t, service.Annotations, map[string]string{"example.com/domain": "new.example.com", "example.com/keep": "yes"})
+	assert.DeepEqual(t, service.Spec.Template.Labels, map[string]string{"cluster": "prod-2"})
+	assert.DeepEqual(t, revisions.Items[0].Labels, map[string]string{"serving.knative.dev/service": "hello", "cluster": "prod-2"})
+	assert.DeepEqual(t, revisions.Items[0].Annotations, map[string]string{"example.com/domain": "new.example.com"})
+	assert.DeepEqual(t, configmap.Labels, map[string]string{"cluster": "prod-2"})
+	// The annotations of the source are not modified
+	assert.Equal(t, sourceAnnotations["eks.amazonaws.com/role-arn"], "arn")
+}
+
+func TestMetadataRulesApplyRemovesOnly(t *testing.T) {
+	rules := MetadataRules{RemoveAnnotations: []string{"example.com/legacy"}}
+	meta := metav1.ObjectMeta{Annotations: map[string]string{"example.com/legacy": "true"}}
+	changes := rules.apply(&meta)
+	assert.DeepEqual(t, changes, []string{"removed annotation example.com/legacy"})
+	assert.Assert(t, meta.Annotations == nil)
+	assert.Assert(t, meta.Labels == nil)
+}
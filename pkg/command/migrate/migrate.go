@@ -16,8 +16,13 @@ package migrate
 
 import (
 	"context"
+	"crypto/ed25519"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fatih/color"
@@ -26,30 +31,78 @@ import (
 	api_errors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	clientset "k8s.io/client-go/kubernetes"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/oidc" // from https://github.com/kubernetes/client-go/issues/345
-	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/rest"
 	"knative.dev/kn-plugin-migration/pkg/command"
 	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
 	serving_v1_client "knative.dev/serving/pkg/client/clientset/versioned/typed/serving/v1"
+	serving_v1beta1_client "knative.dev/serving/pkg/client/clientset/versioned/typed/serving/v1beta1"
 )
 
 type migrateCmdFlags struct {
-	Namespace             string
-	KubeConfig            string
-	DestinationKubeConfig string
-	DestinationNamespace  string
-	Force                 bool
-	Delete                bool
+	Namespaces                  []string
+	AllNamespaces               bool
+	NamespaceMapping            string
+	IncludeReferencedNamespaces bool
+	KubeConfig                  string
+	Context                     string
+	SourceInCluster             bool
+	DestinationKubeConfigs      []string
+	DestinationContexts         []string
+	DestinationNamespace        string
+	SourceProfile               string
+	DestinationProfiles         []string
+	Delete                      bool
+	Yes                         bool
+	DeleteGracePeriod           time.Duration
+	BackupDir                   string
+	EventSink                   string
+	PreHook                     string
+	PostHook                    string
+	HookTimeout                 time.Duration
+	ReportFile                  string
+	AuditLog                    string
+	OwnerAnnotation             string
+	SnapshotFile                string
+	SnapshotSigningKey          string
+	DryRun                      bool
+	Output                      string
+	Services                    []string
+	Selector                    string
+	Exclude                     []string
+	ExcludeSelector             string
+	GateNamespaces              bool
+	GateTimeout                 time.Duration
+	AnnotationMapping           string
+	Renames                     []string
+	RenameFile                  string
+	SetLabels                   []string
+	SetAnnotations              []string
+	RemoveAnnotations           []string
+	NamespaceLabels             []string
+	ImageRewrites               []string
+	CheckImages                 bool
+	Env                         []string
+	EnvFile                     string
+	EndpointsFile               string
+	IncludeDomainMappings       bool
+	IncludeEventing             bool
+	IncludeNamespaceConfig      bool
+	IncludeStandalone           bool
+	DiscoveryCacheDir           string
+	DiscoveryCacheTTL           time.Duration
+	DashboardAddr               string
+	Options                     *MigrationOptions
 }
 
-var MaxGetRetries = 16
-var MaxUpdateRetries = 16
 var migrateFlags migrateCmdFlags
 
 // migrateCmd represents the migrate command
 func NewMigrateCommand() *cobra.Command {
+	migrateFlags.Options = NewMigrationOptions()
 	var migrateCmd = &cobra.Command{
 		Use:   "migrate",
 		Short: "Migrate Knative services from source cluster to destination cluster",
@@ -61,167 +114,789 @@ func NewMigrateCommand() *cobra.Command {
   # Migrate Knative services from source cluster to destination cluster and force replace the service if exists in destination cluster
   kn migrate --namespace default --destination-namespace default --force
   # Migrate Knative services from source cluster to destination cluster and delete the service in source cluster
-  kn migrate --namespace default --destination-namespace default --force --delete`,
+  kn migrate --namespace default --destination-namespace default --force --delete
+  # Migrate between two contexts of a single kubeconfig file
+  kn migrate --namespace default --destination-namespace default --context staging --destination-context prod
+  # Replicate a namespace to the clusters of two regions, contexts of a single kubeconfig file
+  kn migrate --namespace default --destination-context prod-eu --destination-context prod-us
+  # Migrate from inside the source cluster, e.g. from a Job, to the cluster of a kubeconfig mounted from a Secret
+  kn migrate --namespace default --source-in-cluster --destination-kubeconfig /etc/kn-migration/destination-kubeconfig
+  # Migrate several namespaces, keeping their names in the destination cluster
+  kn migrate --namespace team-a,team-b
+  # Migrate a namespace and the namespaces its services reference, e.g. through a sink URL of another namespace
+  kn migrate --namespace frontend --include-referenced-namespaces
+  # Migrate every namespace containing Knative services, renaming some of them with a file of src-ns=dst-ns lines
+  kn migrate --all-namespaces --namespace-mapping namespaces.txt
+  # Replace the services existing in the destination and delete the source services without asking for confirmation
+  kn migrate --namespace default --destination-namespace default --force --delete --yes
+  # Migrate only the checkout service and the services whose name starts with frontend-
+  kn migrate --namespace default --destination-namespace default --service checkout --service "frontend-*"
+  # Migrate the frontend service as storefront because another frontend service exists in the destination namespace
+  kn migrate --namespace default --destination-namespace shop --rename frontend=storefront
+  # Migrate the services with a label of the new cluster, dropping the annotations of the cloud provider of the old one
+  kn migrate --namespace default --destination-namespace default --set-label cluster=prod-2 --remove-annotation "eks.amazonaws.com/*"
+  # Migrate to a cluster pulling the images from a registry mirror
+  kn migrate --namespace default --destination-namespace default --image-rewrite gcr.io/old=registry.corp/new
+  # Migrate the services pointing them at the database of the destination cluster
+  kn migrate --namespace default --destination-namespace default --env DB_HOST=db.prod-2.internal
+  # Migrate only the services labeled with team=payments
+  kn migrate --namespace default --destination-namespace default -l team=payments
+  # Migrate every service except the legacy ones and the services being decommissioned
+  kn migrate --namespace default --destination-namespace default --exclude "legacy-*" --exclude-selector lifecycle=decommissioned
+  # Print the migration plan as JSON without changing anything in either cluster
+  kn migrate --namespace default --destination-namespace default --force --dry-run -o json
+  # Resume an interrupted migration, skipping the services it already migrated
+  kn migrate --namespace default --destination-namespace default --resume
+  # Migrate up to 8 services in parallel
+  kn migrate --namespace default --destination-namespace default --concurrency 8
+  # Migrate at most 120 objects per minute to keep the destination API server responsive for other tenants
+  kn migrate --namespace default --destination-namespace default --pace 120
+  # Migrate from a Kourier to an Istio cluster, rewriting the ingress class and dropping Kourier specific annotations
+  kn migrate --namespace default --destination-namespace default --source-networking kourier --destination-networking istio
+  # Migrate every service even if some of them fail, then exit with an error if anything failed
+  kn migrate --namespace default --destination-namespace default --best-effort
+  # Migrate the services with the DomainMappings of their custom domains
+  kn migrate --namespace default --destination-namespace default --include-domainmappings
+  # Migrate the services with the eventing objects and event sources of their namespace
+  kn migrate --namespace default --destination-namespace default --include-eventing
+  # Migrate and write the URLs of the services before and after the migration, to notify their consumers
+  kn migrate --namespace default --destination-namespace default --endpoints-file endpoints.yaml
+  # Migrate and warm up the cache of every service with a script receiving the manifest of the service on stdin
+  kn migrate --namespace default --destination-namespace default --post-hook ./scripts/warm-up.sh
+  # Migrate and print a YAML report of every migrated service and revision for a CI pipeline
+  kn migrate --namespace default --destination-namespace default -o yaml`,
 
-		Run: func(cmd *cobra.Command, args []string) {
-			kubeconfigS := migrateFlags.KubeConfig
-			if kubeconfigS == "" {
-				kubeconfigS = os.Getenv("KUBECONFIG")
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := command.NewCommandContext(cmd)
+			defer cancel()
+
+			// With a structured report or plan the progress messages go to stderr so that stdout only holds the output
+			progress := cmd.OutOrStdout()
+			if migrateFlags.Output != "" {
+				progress = cmd.ErrOrStderr()
+				command.ConfigureColors(cmd, os.Stderr)
 			}
-			if kubeconfigS == "" {
-				fmt.Printf("cannot get source cluster kube config, please use --kubeconfig or export environment variable KUBECONFIG to set\n")
-				os.Exit(1)
+			migrateFlags.Options.Out = progress
+
+			destinations, err := migrateDestinations(cmd, &migrateFlags)
+			if err != nil {
+				return err
 			}
 
-			kubeconfigD := migrateFlags.DestinationKubeConfig
-			if kubeconfigD == "" {
-				kubeconfigD = os.Getenv("KUBECONFIG_DESTINATION")
+			if cmd.Flags().Changed("force-scope") || migrateFlags.Options.ForceRecreate {
+				migrateFlags.Options.Force = true
 			}
-			if kubeconfigD == "" {
-				fmt.Printf("cannot get destination cluster kube config, please use --destination-kubeconfig or export environment variable KUBECONFIG_DESTINATION to set\n")
-				os.Exit(1)
+			warnInjectedFailures(migrateFlags.Options)
+
+			var kubeconfigS clusterConfig
+			for i, destination := range destinations {
+				kubeconfigS, destinations[i], err = getKubeConfigs(clusterConfig{KubeConfig: migrateFlags.KubeConfig, Context: migrateFlags.Context, InCluster: migrateFlags.SourceInCluster}, destination)
+				if err != nil {
+					return err
+				}
+			}
+			err = validateFanOut(&migrateFlags, destinations)
+			if err != nil {
+				return err
+			}
+			audit, err := openAuditLog(migrateFlags.AuditLog)
+			if err != nil {
+				return err
+			}
+			kubeconfigS.audit = audit
+			for i := range destinations {
+				destinations[i].audit = audit
 			}
 
-			namespaceS := migrateFlags.Namespace
-			if namespaceS == "" {
-				fmt.Printf("cannot get source cluster namespace, please use --namespace to set\n")
-				os.Exit(1)
+			filter, err := newServiceFilter(migrateFlags.Services, migrateFlags.Selector)
+			if err != nil {
+				return err
+			}
+			err = filter.exclude(migrateFlags.Exclude, migrateFlags.ExcludeSelector)
+			if err != nil {
+				return err
 			}
 
-			namespaceD := migrateFlags.DestinationNamespace
-			if namespaceD == "" {
-				fmt.Printf("cannot get destination cluster namespace, please use --destination-namespace to set\n")
-				os.Exit(1)
+			// Outside of the maintenance windows only the read-only plan is allowed for destructive migrations
+			outsideWindow := false
+			if (migrateFlags.Options.replacing() || migrateFlags.Delete) && !migrateFlags.DryRun {
+				outsideWindow, err = outsideMaintenanceWindows(time.Now())
+				if err != nil {
+					return err
+				}
+				if outsideWindow {
+					fmt.Fprintln(cmd.ErrOrStderr(), color.YellowString("Outside of the maintenance windows declared in the config file, refusing --force and --delete and printing the migration plan only"))
+					migrateFlags.DryRun = true
+				}
 			}
 
-			// For source
-			clientSetS, migrationClientS, err := getClients(kubeconfigS, namespaceS)
+			err = validateOutputFormat(migrateFlags.Output)
 			if err != nil {
-				fmt.Printf(err.Error())
-				os.Exit(1)
+				return err
 			}
-			err = migrationClientS.PrintServiceWithRevisions("source")
+			err = setupNetworkingTranslation(migrateFlags.Options, migrateFlags.AnnotationMapping)
 			if err != nil {
-				fmt.Printf(err.Error())
-				os.Exit(1)
+				return err
 			}
-
-			// For destination
-			clientSetD, migrationClientD, err := getClients(kubeconfigD, namespaceD)
+			migrateFlags.Options.Renames, err = parseRenames(migrateFlags.Renames, migrateFlags.RenameFile)
+			if err != nil {
+				return err
+			}
+			migrateFlags.Options.MetadataRules, err = parseMetadataRules(migrateFlags.SetLabels, migrateFlags.SetAnnotations, migrateFlags.RemoveAnnotations)
+			if err != nil {
+				return err
+			}
+			migrateFlags.Options.NamespaceLabels, err = parseNamespaceLabels(migrateFlags.NamespaceLabels)
 			if err != nil {
-				fmt.Printf(err.Error())
-				os.Exit(1)
+				return err
+			}
+			migrateFlags.Options.ImageRewrites, err = parseImageRewrites(migrateFlags.ImageRewrites)
+			if err != nil {
+				return err
+			}
+			err = validateRevisionRegeneration(migrateFlags.Options)
+			if err != nil {
+				return err
+			}
+			err = validateImageCopy(migrateFlags.Options.CopyImages, migrateFlags.Options.DestRegistry, migrateFlags.ImageRewrites)
+			if err != nil {
+				return err
+			}
+			err = validateClaimCopy(migrateFlags.Options)
+			if err != nil {
+				return err
+			}
+			if migrateFlags.CheckImages && migrateFlags.Options.CopyImages {
+				return fmt.Errorf("--check-images cannot be combined with --copy-images")
+			}
+			migrateFlags.Options.EnvOverrides, err = parseEnvOverrides(migrateFlags.Env, migrateFlags.EnvFile)
+			if err != nil {
+				return err
+			}
+			preHook, err := newMigrationHook(migrateFlags.PreHook, migrateFlags.HookTimeout, progress, cmd.ErrOrStderr())
+			if err != nil {
+				return err
+			}
+			postHook, err := newMigrationHook(migrateFlags.PostHook, migrateFlags.HookTimeout, progress, cmd.ErrOrStderr())
+			if err != nil {
+				return err
+			}
+			setupHooks(migrateFlags.Options, preHook, postHook)
+			if migrateFlags.Options.RollbackOnFailure && migrateFlags.Options.BestEffort {
+				return fmt.Errorf("--rollback-on-failure cannot be combined with --best-effort")
+			}
+			if migrateFlags.OwnerAnnotation != "" && migrateFlags.EventSink == "" {
+				return fmt.Errorf("--owner-annotation requires --event-sink to send the summaries of the owners to")
+			}
+			var sourceSnapshot *snapshot
+			var signingKey ed25519.PrivateKey
+			if migrateFlags.SnapshotFile != "" {
+				if migrateFlags.SnapshotSigningKey == "" {
+					return fmt.Errorf("--snapshot-file requires --snapshot-signing-key to sign the snapshot manifest")
+				}
+				signingKey, err = readSigningKey(migrateFlags.SnapshotSigningKey)
+				if err != nil {
+					return err
+				}
+				sourceSnapshot = newSnapshot()
 			}
 
-			fmt.Println(color.GreenString("[Before migration in destination cluster]"))
-			err = migrationClientD.PrintServiceWithRevisions("destination")
+			// For source
+			clientSetS, servingClientS, err := getClusterClients(kubeconfigS)
 			if err != nil {
-				fmt.Printf(err.Error())
-				os.Exit(1)
+				return err
 			}
+			migrateFlags.Options.SourceCluster = clusterHost(kubeconfigS)
+			discoveryS, err := newDiscoveryCache(kubeconfigS, migrateFlags.DiscoveryCacheDir, migrateFlags.DiscoveryCacheTTL)
+			if err != nil {
+				return err
+			}
+			servingClientS, err = sourceServingClient(progress, kubeconfigS, discoveryS, servingClientS)
+			if err != nil {
+				return err
+			}
+
+			// For destinations, the source namespaces are replicated to each of them in turn
+			targets := []*migrationTarget{}
+			for _, destination := range destinations {
+				target, err := newMigrationTarget(progress, destination, migrateFlags.DiscoveryCacheDir, migrateFlags.DiscoveryCacheTTL, migrateFlags.IncludeDomainMappings, migrateFlags.IncludeEventing)
+				if err != nil {
+					return err
+				}
+				targets = append(targets, target)
+			}
+			fanOut := len(targets) > 1
 
-			fmt.Println("\nNow migrate all Knative service resources")
-			fmt.Println("From the source", color.BlueString(namespaceS), "namespace of cluster", color.CyanString(kubeconfigS))
-			fmt.Println("To the destination", color.BlueString(namespaceD), "namespace of cluster", color.CyanString(kubeconfigD))
+			detectNetworkingLayers(ctx, migrateFlags.Options, clientSetS, nil)
 
-			err = getOrCreateNamespace(clientSetD, namespaceD)
+			namespaces, err := resolveNamespaces(ctx, servingClientS, migrateFlags.Namespaces, migrateFlags.AllNamespaces, migrateFlags.DestinationNamespace, migrateFlags.NamespaceMapping)
 			if err != nil {
-				fmt.Printf(err.Error())
-				os.Exit(1)
+				return err
 			}
 
-			servicesS, err := migrationClientS.ListService()
+			// Services of the selected namespaces may reference objects of other namespaces, which are either
+			// pulled into the migration or reported as dangling
+			sources := []migrationSource{}
+			for _, namespace := range namespaces {
+				sources = append(sources, newLiveSource(clientSetS, command.NewMigrationClient(servingClientS, namespace.Source), namespace.Source))
+			}
+			references, err := findCrossNamespaceReferences(ctx, sources, filter)
 			if err != nil {
-				fmt.Printf(err.Error())
-				os.Exit(1)
+				return err
+			}
+			if migrateFlags.IncludeReferencedNamespaces {
+				namespaces = includeReferencedNamespaces(namespaces, references)
+			}
+			allServices, err := newServiceFilter(nil, "")
+			if err != nil {
+				return err
+			}
+			namespaceFilter := func(namespace namespacePair) *serviceFilter {
+				if namespace.Referenced {
+					return allServices
+				}
+				return filter
 			}
-			for i := 0; i < len(servicesS.Items); i++ {
-				serviceS := servicesS.Items[i]
-				fmt.Println("Start migrate service", color.CyanString(serviceS.Name))
 
-				configmapS, err := getConfigmap(clientSetS, namespaceS, generateConfigmapName(serviceS.Name))
-				if err != nil && !api_errors.IsNotFound(err) {
-					fmt.Printf(err.Error())
-					os.Exit(1)
+			var domainMappingsS serving_v1beta1_client.ServingV1beta1Interface
+			if migrateFlags.IncludeDomainMappings {
+				domainMappingsS, err = getDomainMappingClient(kubeconfigS)
+				if err != nil {
+					return err
 				}
-				if configmapS != nil {
-					err := createConfigmap(clientSetD, namespaceD, configmapS)
-					if err != nil {
-						fmt.Printf(err.Error())
-						os.Exit(1)
+			}
+
+			dynamicS, err := getDynamicClient(kubeconfigS)
+			if err != nil {
+				return err
+			}
+			// useTarget points the options at a destination cluster, whose networking layer is detected unless given
+			destinationNetworking := migrateFlags.Options.DestinationNetworking
+			useTarget := func(target *migrationTarget) {
+				migrateFlags.Options.DestinationNetworking = destinationNetworking
+				detectNetworkingLayers(ctx, migrateFlags.Options, nil, target.clientSet)
+				migrateFlags.Options.ResourceHandlers.Register(SecretKind, NewSecretHandler(dynamicS, target.dynamic))
+			}
+
+			buildPlans := func() ([]*migrationPlan, error) {
+				plans := []*migrationPlan{}
+				for _, target := range targets {
+					useTarget(target)
+					for _, namespace := range namespaces {
+						source := newLiveSource(clientSetS, command.NewMigrationClient(servingClientS, namespace.Source), namespace.Source)
+						migrationClientD := command.NewMigrationClient(target.servingClient, namespace.Destination)
+						plan, err := buildPlan(ctx, source, target.clientSet, migrationClientD, namespace.Destination, namespaceFilter(namespace), migrateFlags.Options, migrateFlags.Delete)
+						if err != nil {
+							return nil, err
+						}
+						if fanOut {
+							plan.DestinationCluster = target.cluster.String()
+						}
+						planReferences(plan, namespaces, references, migrateFlags.IncludeReferencedNamespaces)
+						if migrateFlags.IncludeDomainMappings {
+							err = planDomainMappings(ctx, plan, domainMappingsS, target.domainMappings, migrateFlags.Options)
+							if err != nil {
+								return nil, err
+							}
+						}
+						if migrateFlags.IncludeEventing {
+							err = planEventing(ctx, plan, dynamicS, target.dynamic, migrateFlags.Options)
+							if err != nil {
+								return nil, err
+							}
+						}
+						if migrateFlags.IncludeNamespaceConfig {
+							err = planNamespaceConfig(ctx, plan, clientSetS, target.clientSet)
+							if err != nil {
+								return nil, err
+							}
+						}
+						err = planStandaloneWorkloads(ctx, plan, servingClientS, target.servingClient, migrateFlags.IncludeStandalone)
+						if err != nil {
+							return nil, err
+						}
+						plans = append(plans, plan)
 					}
-					fmt.Println("Migrated configmap", color.CyanString(generateConfigmapName(serviceS.Name)), "Successfully")
-				} else {
-					fmt.Printf("no configmap for service %s, skip migrate configmap\n", serviceS.Name)
 				}
-				err = createService(migrationClientD, serviceS, migrateFlags.Force)
+				return plans, nil
+			}
+
+			if migrateFlags.DryRun {
+				plans, err := buildPlans()
 				if err != nil {
-					fmt.Printf(err.Error())
-					os.Exit(1)
+					return err
 				}
-				fmt.Println("Migrated service", color.CyanString(serviceS.Name), "Successfully")
-
-				serviceD, err := migrationClientD.GetService(serviceS.Name)
+				err = printPlans(cmd.OutOrStdout(), plans, migrateFlags.Output)
 				if err != nil {
-					fmt.Printf(err.Error())
-					os.Exit(1)
+					return err
+				}
+				if outsideWindow {
+					return fmt.Errorf("refused --force and --delete outside of the maintenance windows, only the migration plan was printed")
 				}
+				return nil
+			}
 
-				//fmt.Printf("try get configuration for migrate revisions: s: %s, d:%s\n", serviceS.Name, serviceD.Name)
-				config, err := getConfig(migrationClientD, serviceD.Name)
+			// Replacing destination objects and deleting source services is confirmed before anything is migrated
+			if migrateFlags.Options.replacing() || migrateFlags.Delete {
+				plans, err := buildPlans()
+				if err == nil {
+					err = confirmDestructiveActions(plans, migrateFlags.Yes)
+				}
 				if err != nil {
-					fmt.Printf(err.Error())
-					os.Exit(1)
+					return err
 				}
-				configUUID := config.UID
+			}
 
-				//fmt.Printf("try list source revisions: s: %s\n", serviceS.Name)
-				revisionsS, err := migrationClientS.ListRevisionByService(serviceS.Name)
-				if err != nil {
-					fmt.Printf(err.Error())
-					os.Exit(1)
+			// The images are resolved with the pull secrets of the destination before any service is created
+			if migrateFlags.CheckImages {
+				for _, target := range targets {
+					for _, namespace := range namespaces {
+						source := newLiveSource(clientSetS, command.NewMigrationClient(servingClientS, namespace.Source), namespace.Source)
+						err = checkImagePullability(ctx, source, clientSetS, target.clientSet, namespace.Destination, namespaceFilter(namespace), migrateFlags.Options)
+						if err != nil {
+							return err
+						}
+					}
 				}
-				for i := 0; i < len(revisionsS.Items); i++ {
-					revisionS := revisionsS.Items[i]
-					//fmt.Printf("migrate revision: source: %s/%s\n", revisionS.Namespace, revisionS.Name)
-					err = migrateRevision(migrationClientD, revisionS, serviceS, configUUID, serviceD.Status.LatestCreatedRevisionName)
+				fmt.Fprintln(progress, "The destination can pull the images of the services to migrate")
+			}
+
+			// The owners are looked up before the migration, --delete removes the source services and their URLs
+			owners := map[string]map[string]serviceOwnership{}
+			if migrateFlags.OwnerAnnotation != "" {
+				for _, namespace := range namespaces {
+					source := newLiveSource(clientSetS, command.NewMigrationClient(servingClientS, namespace.Source), namespace.Source)
+					owners[namespace.Source], err = lookupOwners(ctx, source, namespaceFilter(namespace), migrateFlags.OwnerAnnotation)
 					if err != nil {
-						fmt.Printf(err.Error())
-						os.Exit(1)
+						return err
 					}
-					time.Sleep(5 * time.Second)
 				}
-				fmt.Println("")
 			}
 
-			fmt.Println(color.GreenString("[After migration in destination cluster]"))
-			err = migrationClientD.PrintServiceWithRevisions("destination")
-			if err != nil {
-				fmt.Printf(err.Error())
-				os.Exit(1)
+			out := cmd.OutOrStdout()
+			report := newMigrationReport()
+			report.Command, report.Flags = cmd.CommandPath(), usedFlags(cmd)
+			report.RunID = migrateFlags.Options.runID()
+			if migrateFlags.DashboardAddr != "" {
+				migrateFlags.Options.dashboard = newDashboard(namespaces)
+				if err := migrateFlags.Options.dashboard.serve(migrateFlags.DashboardAddr); err != nil {
+					return err
+				}
+				fmt.Fprintln(progress, "Serving the migration progress on", color.CyanString(migrateFlags.DashboardAddr))
+			}
+			// finishWithReport completes and prints the report of the run, and returns the error it failed with
+			finishWithReport := func(err error) error {
+				err = report.conclude(ctx, err, migrateFlags.Options)
+				if hookErr := callPostRunHook(context.Background(), postHook, report); hookErr != nil {
+					migrateFlags.Options.warn(hookErr.Error())
+					report.Warnings = migrateFlags.Options.warnings()
+				}
+				migrateFlags.Options.dashboard.finish(err)
+				if err := printOutcome(out, cmd.ErrOrStderr(), report, migrateFlags.Output); err != nil {
+					fmt.Fprintln(progress, err.Error())
+				}
+				if migrateFlags.ReportFile != "" {
+					if err := writeReportFile(migrateFlags.ReportFile, report); err != nil {
+						fmt.Fprintln(progress, err.Error())
+					} else {
+						fmt.Fprintln(progress, "Wrote the migration report to", color.CyanString(migrateFlags.ReportFile))
+					}
+				}
+				if sourceSnapshot != nil {
+					if err := sourceSnapshot.write(migrateFlags.SnapshotFile, kubeconfigS.String(), report.StartedAt.Time, signingKey); err != nil {
+						fmt.Fprintln(progress, err.Error())
+					} else {
+						fmt.Fprintln(progress, "Wrote the signed snapshot manifest of the source objects to", color.CyanString(migrateFlags.SnapshotFile))
+					}
+				}
+				notifyCompletion(progress, migrateFlags.EventSink, report)
+				if migrateFlags.OwnerAnnotation != "" {
+					summaries := ownerSummaries(context.Background(), report, owners, func(namespace string) command.MigrationClient {
+						return command.NewMigrationClient(targets[0].servingClient, namespace)
+					})
+					notifyOwners(progress, migrateFlags.EventSink, report, summaries)
+				}
+				if auditErr := audit.Close(); auditErr != nil {
+					return auditErr
+				}
+				return err
 			}
 
-			err = deleteAllServices(migrationClientS, migrateFlags.Delete)
+			// A failure while migrating undoes the changes made to the destination with --rollback-on-failure,
+			// once services are deleted from the source the destination copies are the only ones left
+			// An interrupted or timed out migration is not rolled back, it is resumed with --resume instead
+			abort := func(err error) error {
+				if ctx.Err() != nil {
+					printInterrupted(cmd.ErrOrStderr(), report, ctx.Err())
+				}
+				migrateFlags.Options.rollbackFailure(ctx, report, err)
+				return finishWithReport(err)
+			}
+
+			err = callPreRunHook(ctx, preHook, namespaces)
 			if err != nil {
-				fmt.Printf(err.Error())
-				os.Exit(1)
+				return finishWithReport(err)
 			}
+
+			// Each destination has its own checkpoint, all of them are kept until every destination succeeded
+			checkpointFile := migrateFlags.Options.CheckpointFile
+			checkpoints := []*checkpoint{}
+			for _, target := range targets {
+				clientSetD, servingClientD, kubeconfigD := target.clientSet, target.servingClient, target.cluster
+				useTarget(target)
+				if fanOut {
+					migrateFlags.Options.startDestination(destinationCheckpointFile(checkpointFile, kubeconfigD))
+				}
+
+				fmt.Fprintln(progress, "\nNow migrate all Knative service resources")
+				fmt.Fprintln(progress, "From the source cluster", color.CyanString(kubeconfigS.String()))
+				fmt.Fprintln(progress, "To the destination cluster", color.CyanString(kubeconfigD.String()))
+				copiedReferences := []crossNamespaceReference{}
+				if migrateFlags.IncludeReferencedNamespaces {
+					copiedReferences, err = copyReferencedObjects(ctx, clientSetS, clientSetD, namespaces, references, migrateFlags.Options)
+					if err != nil {
+						return abort(err)
+					}
+				}
+				dangling := danglingReferences(namespaces, references)
+				for _, reference := range dangling {
+					migrateFlags.Options.warn("Dangling reference: the %s is not migrated, use --include-referenced-namespaces to migrate its namespace", reference)
+				}
+
+				migratedByNamespace := make([][]string, len(namespaces))
+				namespaceReports := make([]*NamespaceReport, len(namespaces))
+				for i, namespace := range namespaces {
+					migrationClientS := command.NewMigrationClient(servingClientS, namespace.Source)
+					migrationClientD := command.NewMigrationClient(servingClientD, namespace.Destination)
+					namespaceReport := report.namespace(namespace.Source, namespace.Destination)
+					namespaceReports[i] = namespaceReport
+					if fanOut {
+						namespaceReport.DestinationCluster = kubeconfigD.String()
+					}
+					for _, reference := range copiedReferences {
+						if reference.ReferencedNamespace == namespace.Source {
+							namespaceReport.Dependencies = append(namespaceReport.Dependencies, reference.Kind+" "+reference.Name)
+						}
+					}
+					for _, reference := range dangling {
+						if reference.Namespace == namespace.Source {
+							namespaceReport.DanglingReferences = append(namespaceReport.DanglingReferences, reference)
+						}
+					}
+					err = sourceError(migrationClientS.PrintServiceWithRevisions(ctx, progress, "source"))
+					// The services are created under the constraints and connectivity rules of their namespace
+					if err == nil && migrateFlags.IncludeNamespaceConfig {
+						var copied []string
+						copied, err = copyNamespaceConfig(ctx, clientSetS, clientSetD, namespace.Source, namespace.Destination, migrateFlags.Options)
+						namespaceReport.Dependencies = append(namespaceReport.Dependencies, copied...)
+					}
+					if err == nil {
+						source := newLiveSource(clientSetS, migrationClientS, namespace.Source)
+						if sourceSnapshot != nil {
+							source = snapshotSource{migrationSource: source, snapshot: sourceSnapshot}
+						}
+						migratedByNamespace[i], err = migrateNamespace(ctx, source, clientSetD, migrationClientD, namespace.Destination, namespaceFilter(namespace), migrateFlags.Options, namespaceReport)
+					}
+					migrateFlags.Options.dashboard.done(namespace.Source, namespace.Destination, err)
+					if err != nil {
+						if !migrateFlags.Options.BestEffort || ctx.Err() != nil {
+							return abort(err)
+						}
+						fmt.Fprintln(progress, err.Error())
+						namespaceReport.Error = err.Error()
+						continue
+					}
+					if migrateFlags.Options.dashboard != nil {
+						diff, err := diffServices(ctx, migrationClientS, migrationClientD, namespace.Source, namespace.Destination)
+						drift := diff.summary()
+						if err != nil {
+							drift = "unknown: " + err.Error()
+						}
+						migrateFlags.Options.dashboard.drift(namespace.Source, namespace.Destination, drift)
+					}
+
+					// Catch a systemic destination problem before migrating the next namespace
+					if migrateFlags.GateNamespaces && i < len(namespaces)-1 {
+						fmt.Fprintln(progress, "Waiting for the services of namespace", color.BlueString(namespace.Destination), "to be Ready before migrating the next namespace")
+						err = waitForServicesReady(ctx, migrationClientD, migratedByNamespace[i], migrateFlags.GateTimeout)
+						if err != nil {
+							err = fmt.Errorf("namespace gate of %s failed, not migrating the remaining namespaces: %w", namespace.Destination, err)
+							return abort(err)
+						}
+					}
+				}
+
+				// The DomainMappings are recreated once their services exist in the destination
+				if migrateFlags.IncludeDomainMappings {
+					for i, namespace := range namespaces {
+						copied, err := migrateDomainMappings(ctx, clientSetS, clientSetD, domainMappingsS, target.domainMappings, namespace.Source, namespace.Destination, migratedByNamespace[i], migrateFlags.Options)
+						namespaceReports[i].Dependencies = append(namespaceReports[i].Dependencies, copied...)
+						if err != nil {
+							return abort(err)
+						}
+					}
+				}
+
+				// The standalone configurations and routes are recreated once the service revisions routes may name exist
+				for i, namespace := range namespaces {
+					if namespaceReports[i].Error != "" {
+						continue
+					}
+					if !migrateFlags.IncludeStandalone {
+						err = reportStandaloneWorkloads(ctx, servingClientS, namespace.Source, migrateFlags.Options)
+					} else {
+						var copied []string
+						copied, err = migrateStandaloneWorkloads(ctx, servingClientS, servingClientD, namespace.Source, namespace.Destination, migrateFlags.Options)
+						namespaceReports[i].Dependencies = append(namespaceReports[i].Dependencies, copied...)
+					}
+					if err != nil {
+						return abort(err)
+					}
+				}
+
+				// The eventing objects are recreated once the services they deliver to exist in the destination
+				if migrateFlags.IncludeEventing {
+					for i, namespace := range namespaces {
+						if namespaceReports[i].Error != "" {
+							continue
+						}
+						copied, err := migrateEventing(ctx, clientSetS, clientSetD, dynamicS, target.dynamic, namespace.Source, namespace.Destination, migratedByNamespace[i], migrateFlags.Options)
+						namespaceReports[i].Dependencies = append(namespaceReports[i].Dependencies, copied...)
+						if err != nil {
+							return abort(err)
+						}
+					}
+				}
+
+				// The notice is written before --delete, while the source services still tell their URLs
+				if migrateFlags.EndpointsFile != "" {
+					err = writeMigrationEndpoints(ctx, migrateFlags.EndpointsFile, servingClientS, servingClientD, kubeconfigS, kubeconfigD, namespaces, migratedByNamespace, migrateFlags.Options.Renames)
+					if err != nil {
+						return finishWithReport(err)
+					}
+					fmt.Fprintln(progress, "Wrote the endpoint-change notice of the migrated services to", color.CyanString(migrateFlags.EndpointsFile))
+				}
+
+				for i, namespace := range namespaces {
+					migrationClientS := command.NewMigrationClient(servingClientS, namespace.Source)
+					migrationClientD := command.NewMigrationClient(servingClientD, namespace.Destination)
+					err = deleteServices(ctx, clientSetS, migrationClientS, migrationClientD, namespace.Source, migratedByNamespace[i], migrateFlags.Delete, migrateFlags.Options, migrateFlags.DeleteGracePeriod, backupRunDir(migrateFlags.BackupDir, report.StartedAt.Time))
+					if err != nil {
+						return finishWithReport(err)
+					}
+				}
+				checkpoints = append(checkpoints, migrateFlags.Options.checkpoint)
+			}
+
+			// The checkpoints are kept to resume the failed services
+			if report.failures() > 0 {
+				return finishWithReport(nil)
+			}
+			for _, checkpoint := range checkpoints {
+				if err := checkpoint.remove(); err != nil {
+					fmt.Fprintln(progress, err.Error())
+				}
+			}
+			return finishWithReport(nil)
 		},
 	}
 
-	migrateCmd.Flags().StringVarP(&migrateFlags.Namespace, "namespace", "n", "", "The namespace of the source Knative resources")
+	migrateCmd.Flags().StringSliceVarP(&migrateFlags.Namespaces, "namespace", "n", nil, "The namespaces of the source Knative resources, comma separated or repeated")
+	migrateCmd.Flags().BoolVarP(&migrateFlags.AllNamespaces, "all-namespaces", "A", false, "Migrate the Knative resources of every source namespace containing services")
 	migrateCmd.Flags().StringVar(&migrateFlags.KubeConfig, "kubeconfig", "", "The kubeconfig of the Knative resources (default is KUBECONFIG from environment variable)")
+	migrateCmd.Flags().StringVar(&migrateFlags.Context, "context", "", "The context of the kubeconfig of the Knative resources (default is the current context)")
+
+	migrateCmd.Flags().BoolVar(&migrateFlags.SourceInCluster, "source-in-cluster", false, "Use the ServiceAccount of the pod the migration runs in for the source cluster instead of a kubeconfig")
+	migrateCmd.Flags().StringArrayVar(&migrateFlags.DestinationKubeConfigs, "destination-kubeconfig", nil, "The kubeconfig of the destination Knative resources (default is KUBECONFIG_DESTINATION from environment variable, else the source kubeconfig with --destination-context), repeated to replicate to several destination clusters")
+	migrateCmd.Flags().StringArrayVar(&migrateFlags.DestinationContexts, "destination-context", nil, "The context of the kubeconfig of the destination Knative resources (default is the current context), repeated to replicate to several destination clusters")
+	migrateCmd.Flags().StringVar(&migrateFlags.DestinationNamespace, "destination-namespace", "", "The namespace of the destination Knative resources (default is the name of the source namespace)")
+	migrateCmd.Flags().StringVar(&migrateFlags.SourceProfile, "source-profile", "", "The profile of the config file giving the kubeconfig, context and namespace of the source Knative resources")
+	migrateCmd.Flags().StringArrayVar(&migrateFlags.DestinationProfiles, "destination-profile", nil, "The profile of the config file giving the kubeconfig, context and namespace of the destination Knative resources, repeated to replicate to several destination clusters")
+	migrateCmd.Flags().BoolVar(&migrateFlags.IncludeReferencedNamespaces, "include-referenced-namespaces", false, "Also migrate the namespaces referenced by the migrated services, e.g. by a sink URL, and copy the referenced secrets and configmaps")
+	migrateCmd.Flags().StringVar(&migrateFlags.NamespaceMapping, "namespace-mapping", "", "A file of src-ns=dst-ns lines mapping source namespaces to destination namespaces")
 
-	migrateCmd.Flags().StringVar(&migrateFlags.DestinationKubeConfig, "destination-kubeconfig", "", "The kubeconfig of the destination Knative resources (default is KUBECONFIG_DESTINATION from environment variable)")
-	migrateCmd.Flags().StringVar(&migrateFlags.DestinationNamespace, "destination-namespace", "", "The namespace of the destination Knative resources")
+	migrateCmd.Flags().BoolVar(&migrateFlags.Options.Force, "force", false, "Migrate service forcefully, replaces existing service if any.")
+	migrateCmd.Flags().Var(&migrateFlags.Options.OnConflict, "on-conflict", "What to do with the services which already exist in the destination, one of: skip, overwrite, merge, fail (default is overwrite with --force, else fail)")
+	migrateCmd.Flags().BoolVar(&migrateFlags.Options.ForceRecreate, "force-recreate", false, "Delete the existing services and create them again instead of applying the migrated services over them with server-side apply, dropping their destination-only revisions, implies --force")
+	migrateCmd.Flags().BoolVar(&migrateFlags.Options.Adopt, "adopt", false, "Overwrite the destination objects which were not written by a migration, which are refused otherwise to avoid clobbering objects managed by someone else")
+	migrateCmd.Flags().Var(&migrateFlags.Options.ForceScope, "force-scope", "The kinds of objects replaced when they exist in the destination, comma separated, one of: services, configmaps, secrets, domainmappings, eventing, implies --force (default is all kinds with --force)")
+	migrateCmd.Flags().BoolVar(&migrateFlags.Delete, "delete", false, "Delete all Knative resources after kn-migration from source cluster, once their destination copies are Ready (and answer their URL with --verify)")
+	migrateCmd.Flags().BoolVarP(&migrateFlags.Yes, "yes", "y", false, "Replace the existing objects with --force and delete the source services with --delete without asking for confirmation")
+	migrateCmd.Flags().StringVar(&migrateFlags.BackupDir, "backup-dir", DefaultBackupDir, "The directory the services deleted from the source with --delete are backed up to, in a timestamped subdirectory, for 'kn migrate restore' (empty disables the backup)")
+	migrateCmd.Flags().DurationVar(&migrateFlags.DeleteGracePeriod, "delete-grace-period", 0, "The time the destination copies must keep serving before their source services are deleted with --delete, e.g. 10m")
+	migrateCmd.Flags().StringSliceVar(&migrateFlags.Services, "service", nil, "The names or glob patterns of the services to migrate, comma separated or repeated (default is all services of the namespace)")
+	migrateCmd.Flags().StringVarP(&migrateFlags.Selector, "selector", "l", "", "The label selector of the services to migrate, e.g. team=payments")
+	migrateCmd.Flags().StringSliceVar(&migrateFlags.Exclude, "exclude", nil, "The names or glob patterns of the services not to migrate, with their configmap and secrets, comma separated or repeated")
+	migrateCmd.Flags().StringVar(&migrateFlags.ExcludeSelector, "exclude-selector", "", "The label selector of the services not to migrate, e.g. lifecycle=decommissioned")
+	migrateCmd.Flags().IntVar(&migrateFlags.Options.MaxObjectSize, "max-object-size", migrateFlags.Options.MaxObjectSize, "The maximum size in bytes of a serialized object accepted by the destination cluster")
+	migrateCmd.Flags().IntVar(&migrateFlags.Options.MaxRetries, "max-retries", DefaultMaxRetries, "The number of retries of an API call failing because a resource is not created yet or because of a conflict")
+	migrateCmd.Flags().IntVar(&migrateFlags.Options.MaxThrottledRetries, "max-throttled-retries", DefaultMaxThrottledRetries, "The number of retries of a write the API server throttled with 429 Too Many Requests")
+	migrateCmd.Flags().DurationVar(&migrateFlags.Options.RetryBackoff, "retry-backoff", DefaultRetryBackoff, "The delay before the first retry of an API call, doubled with jitter for each next retry up to 1s, or the first delay if longer")
+	migrateCmd.Flags().DurationVar(&migrateFlags.Options.RetryBudget, "retry-budget", 0, "The total time the run may spend waiting for retries before failing, e.g. 5m (default is unlimited)")
+	migrateCmd.Flags().StringVar(&migrateFlags.Options.GroupBy, "group-by", "", "A label grouping the services of an application, e.g. app.kubernetes.io/part-of, whose services are migrated and verified Ready together and rolled back together when one of them fails")
+	migrateCmd.Flags().DurationVar(&migrateFlags.Options.GroupTimeout, "group-timeout", DefaultGroupTimeout, "The maximum time to wait for the services of an application to be Ready with --group-by")
+	migrateCmd.Flags().BoolVar(&migrateFlags.Options.RegenerateRevisions, "regenerate-revisions", false, "Recreate none of the source revisions and let the destination generate the revisions of the migrated services from their template, routing their traffic to the latest revision")
+	migrateCmd.Flags().BoolVar(&migrateFlags.Options.LatestOnly, "latest-only", false, "Move only the current definition of the migrated services: recreate none of the source revisions like --regenerate-revisions and wait for the single revision generated by the destination to be Ready")
+	migrateCmd.Flags().BoolVar(&migrateFlags.Options.PreserveRevisionHistory, "preserve-revision-history", false, "Annotate the migrated revisions with their creation timestamp and configuration generation in the source cluster")
+	migrateCmd.Flags().DurationVar(&migrateFlags.Options.RevisionTimeout, "revision-timeout", DefaultRevisionTimeout, "The maximum time to wait for a migrated revision to be Ready in the destination before migrating the next revision")
+	migrateCmd.Flags().BoolVar(&migrateFlags.Options.Verify, "verify", false, "Wait for every migrated service to be Ready in the destination and request its URL, the service fails unless the URL answers with a success status within --verify-timeout")
+	migrateCmd.Flags().DurationVar(&migrateFlags.Options.VerifyTimeout, "verify-timeout", DefaultVerifyTimeout, "The maximum time for a migrated service to be Ready and answer its URL with --verify")
+	migrateCmd.Flags().BoolVar(&migrateFlags.Options.Wait, "wait", false, "Wait for every migrated service to be Ready in the destination before migrating the next one, like kn service create, the service fails unless it is Ready within --wait-timeout")
+	migrateCmd.Flags().DurationVar(&migrateFlags.Options.WaitTimeout, "wait-timeout", DefaultWaitTimeout, "The maximum time for a migrated service to be Ready with --wait")
+	migrateCmd.Flags().BoolVar(&migrateFlags.Options.CopyClaimData, "copy-pvc-data", false, "Copy the data of the persistentvolumeclaims created in the destination with rsync over SSH, from a daemon exposed by a service of the source to a Job of the destination")
+	migrateCmd.Flags().StringVar(&migrateFlags.Options.ClaimCopyImage, "pvc-copy-image", "", "The image running the SSH daemon and rsync to copy the data of the persistentvolumeclaims with --copy-pvc-data, it must provide sshd and rsync (required with --copy-pvc-data)")
+	migrateCmd.Flags().Var(&migrateFlags.Options.ClaimCopyExpose, "pvc-copy-expose", "How the source daemon serving the data of a persistentvolumeclaim with --copy-pvc-data is exposed to the destination, one of: internal (an internal load balancer), public (a load balancer reachable from the internet), nodeport (a port of its node)")
+	migrateCmd.Flags().DurationVar(&migrateFlags.Options.ClaimCopyTimeout, "pvc-copy-timeout", DefaultClaimCopyTimeout, "The maximum time to expose and copy the data of a persistentvolumeclaim with --copy-pvc-data")
+	migrateCmd.Flags().BoolVar(&migrateFlags.GateNamespaces, "gate-namespaces", false, "Wait for all migrated services of a namespace to be Ready in the destination before migrating the next namespace")
+	migrateCmd.Flags().DurationVar(&migrateFlags.GateTimeout, "gate-timeout", 5*time.Minute, "The maximum time to wait for the services of a namespace to be Ready with --gate-namespaces")
+	migrateCmd.Flags().Var(&migrateFlags.Options.Revisions, "revisions", "The source revisions of every service recreated in the destination, one of: all, traffic (the revisions its traffic routes to), latest, or a number of most recent revisions, the latest revision is always migrated (default is all)")
+	migrateCmd.Flags().BoolVar(&migrateFlags.Options.SkipBrokenRevisions, "skip-broken-revisions", false, "Leave out the source revisions which are not Ready, e.g. because their image no longer exists, unless the service routes to them")
+	migrateCmd.Flags().Var(&migrateFlags.Options.RevisionCollision, "revision-collision", "What to do with a revision whose name is taken in the destination by a different revision, one of: fail, remap")
+	migrateCmd.Flags().IntVar(&migrateFlags.Options.Concurrency, "concurrency", migrateFlags.Options.Concurrency, "The number of services migrated, or deleted from the source with --delete, in parallel, the revisions of a service are always migrated in order")
+	migrateCmd.Flags().IntVar(&migrateFlags.Options.Pace, "pace", 0, "The maximum number of objects written to the destination cluster, and of services deleted from the source cluster with --delete, per minute, slowed down further when the API server throttles writes (default is unlimited)")
+	migrateCmd.Flags().StringVar(&migrateFlags.Options.SourceNetworking, "source-networking", "", "The networking layer of the source cluster, one of: contour, istio, kourier (default is detected from the config-network configmap)")
+	migrateCmd.Flags().StringVar(&migrateFlags.Options.DestinationNetworking, "destination-networking", "", "The networking layer of the destination cluster, one of: contour, istio, kourier (default is detected from the config-network configmap)")
+	migrateCmd.Flags().StringVar(&migrateFlags.AnnotationMapping, "annotation-mapping", "", "A file of src-key=dst-key lines renaming annotations in the destination, an empty dst-key drops the annotation")
+	migrateCmd.Flags().StringArrayVar(&migrateFlags.Renames, "rename", nil, "Migrate a service under a new name in the destination as old-name=new-name, with the revisions, configmap and labels derived from its name (can be repeated)")
+	migrateCmd.Flags().StringVar(&migrateFlags.RenameFile, "rename-file", "", "A file of old-name=new-name lines renaming services in the destination, overridden by --rename")
+	migrateCmd.Flags().StringArrayVar(&migrateFlags.SetLabels, "set-label", nil, "Set a label as key=value on the migrated services, revisions and configmaps (can be repeated)")
+	migrateCmd.Flags().StringArrayVar(&migrateFlags.SetAnnotations, "set-annotation", nil, "Set an annotation as key=value on the migrated services, revisions and configmaps (can be repeated)")
+	migrateCmd.Flags().StringArrayVar(&migrateFlags.RemoveAnnotations, "remove-annotation", nil, "Remove an annotation by key or glob pattern, e.g. eks.amazonaws.com/*, from the migrated services, revisions and configmaps (can be repeated)")
+	migrateCmd.Flags().BoolVar(&migrateFlags.Options.CopyNamespaceMetadata, "copy-namespace-metadata", true, "Create the destination namespaces with the labels and annotations of the source namespaces, e.g. istio-injection or the pod security labels")
+	migrateCmd.Flags().StringArrayVar(&migrateFlags.NamespaceLabels, "namespace-label", nil, "Set a label of the created destination namespaces as key=value, or remove it as key- (can be repeated)")
+	migrateCmd.Flags().StringArrayVar(&migrateFlags.ImageRewrites, "image-rewrite", nil, "Replace the prefix of the container images of the migrated services and revisions as old-prefix=new-prefix, e.g. gcr.io/old=registry.corp/new (can be repeated)")
+	migrateCmd.Flags().BoolVar(&migrateFlags.Options.PinDigests, "pin-digests", false, "Replace the tags of the container images of the migrated services and revisions by the digests running in the source, so that the destination runs the same images")
+	migrateCmd.Flags().BoolVar(&migrateFlags.Options.CopyImages, "copy-images", false, "Copy the container images of the migrated services and revisions by digest to --dest-registry and refer to the copies, for destinations which cannot pull from the source registries")
+	migrateCmd.Flags().StringVar(&migrateFlags.Options.DestRegistry, "dest-registry", "", "The registry and namespace the images are copied to with --copy-images, e.g. registry.corp/ns")
+	migrateCmd.Flags().BoolVar(&migrateFlags.CheckImages, "check-images", false, "Resolve every image of the services to migrate with the image pull secrets of the destination before migrating, and fail with the images which cannot be pulled")
+	migrateCmd.Flags().StringArrayVar(&migrateFlags.Env, "env", nil, "Set an environment variable as KEY=VALUE, or remove it with KEY-, in the serving container of the migrated services and revisions (can be repeated)")
+	migrateCmd.Flags().StringVar(&migrateFlags.EnvFile, "env-from-file", "", "A file of KEY=VALUE lines setting environment variables in the serving container of the migrated services and revisions, overridden by --env")
+	migrateCmd.Flags().BoolVar(&migrateFlags.Options.BestEffort, "best-effort", false, "Continue with the remaining services and namespaces when a service fails to migrate")
+	migrateCmd.Flags().BoolVar(&migrateFlags.Options.RollbackOnFailure, "rollback-on-failure", false, "Delete every object created in the destination and restore the replaced services when the migration fails")
+	migrateCmd.Flags().StringVar(&migrateFlags.Options.CheckpointFile, "checkpoint-file", defaultCheckpointFile(), "The file recording the migrated services to resume an interrupted migration from, removed once the migration succeeded (empty disables the checkpoint)")
+	migrateCmd.Flags().BoolVar(&migrateFlags.Options.Resume, "resume", false, "Skip the services recorded as migrated in the checkpoint file by an interrupted migration")
+	migrateCmd.Flags().BoolVar(&migrateFlags.IncludeDomainMappings, "include-domainmappings", false, "Also migrate the DomainMappings pointing at the migrated services, with the secrets of their TLS certificates")
+	migrateCmd.Flags().BoolVar(&migrateFlags.IncludeNamespaceConfig, "include-namespace-config", false, "Also copy the NetworkPolicies, ResourceQuotas and LimitRanges of the source namespaces before migrating their services, keeping those which already exist in the destination")
+	migrateCmd.Flags().BoolVar(&migrateFlags.IncludeStandalone, "include-standalone", false, "Also migrate the Configurations and Routes created without a Service, which are otherwise only reported")
+	migrateCmd.Flags().BoolVar(&migrateFlags.IncludeEventing, "include-eventing", false, "Also migrate the Brokers, Triggers, Channels, Subscriptions, Sequences, Parallels and event sources of the namespace, rewiring their references to the destination namespace")
+	migrateCmd.Flags().StringVar(&migrateFlags.ReportFile, "report-file", "", "Write the report of the run with the flags used and the warnings to this file, as an HTML page if its extension is .html, as JSON otherwise")
+	migrateCmd.Flags().StringVar(&migrateFlags.AuditLog, "audit-log", "", "Append a JSON record with the timestamp, cluster, verb, resource, namespace, name and result of every create, update, patch and delete request sent to the clusters to this file")
+	migrateCmd.Flags().StringVar(&migrateFlags.EventSink, "event-sink", "", "The URL to send a CloudEvent summarizing the migration to once it completed, e.g. the URL of a Broker")
+	migrateCmd.Flags().StringVar(&migrateFlags.OwnerAnnotation, "owner-annotation", "", "The annotation of the source services naming their owner, e.g. a team or an email, to send each owner a CloudEvent summarizing their services to --event-sink")
+	migrateCmd.Flags().StringVar(&migrateFlags.PreHook, "pre-hook", "", "A command, or a webhook URL, called with the manifest of every source service as JSON on stdin, or as body of a POST, before it is migrated, and with the namespaces before the run, a failure fails the service or the run")
+	migrateCmd.Flags().StringVar(&migrateFlags.PostHook, "post-hook", "", "A command, or a webhook URL, called with the manifest of every source service as JSON on stdin, or as body of a POST, once it is migrated or failed, and with the report after the run, a failure fails the service")
+	migrateCmd.Flags().DurationVar(&migrateFlags.HookTimeout, "hook-timeout", DefaultHookTimeout, "The maximum time a call of --pre-hook or --post-hook may take")
+	migrateCmd.Flags().StringVar(&migrateFlags.SnapshotFile, "snapshot-file", "", "Write the SHA-256 hashes of every object read from the source cluster to this YAML manifest, signed with --snapshot-signing-key to the manifest path with a .sig suffix")
+	migrateCmd.Flags().StringVar(&migrateFlags.SnapshotSigningKey, "snapshot-signing-key", "", "The PEM file of the ed25519 private key signing the --snapshot-file manifest")
+	migrateCmd.Flags().StringVar(&migrateFlags.EndpointsFile, "endpoints-file", "", "Write the default, tag and DomainMapping URLs of every migrated service before and after the migration to this YAML file")
+	migrateCmd.Flags().StringVar(&migrateFlags.DiscoveryCacheDir, "discovery-cache-dir", defaultDiscoveryCacheDir(), "The directory caching the API discovery results and OpenAPI schema of the clusters across runs (empty disables the cache)")
+	migrateCmd.Flags().DurationVar(&migrateFlags.DiscoveryCacheTTL, "discovery-cache-ttl", DefaultDiscoveryCacheTTL, "The time the cached discovery results of the destination cluster are reused before being refreshed (0 disables the cache)")
+	migrateCmd.Flags().Var(&migrateFlags.Options.InjectFailures, "inject-failures", "Randomly fail writes to the destination to rehearse the recovery of a partial failure, e.g. rate=0.05,seed=42")
+	// Failure injection is a rehearsal tool, not an option of a real migration
+	_ = migrateCmd.Flags().MarkHidden("inject-failures")
+	migrateCmd.Flags().StringVar(&migrateFlags.DashboardAddr, "dashboard-addr", "", "Serve a read-only web dashboard of the progress of every namespace on this address while the migration runs, e.g. :8080")
+	migrateCmd.Flags().BoolVar(&migrateFlags.DryRun, "dry-run", false, "Print the migration plan without changing anything in the source or destination cluster")
+	migrateCmd.Flags().StringVarP(&migrateFlags.Output, "output", "o", "", "Output format of the migration report, or of the migration plan with --dry-run, one of: json, yaml (default is human readable)")
 
-	migrateCmd.Flags().BoolVar(&migrateFlags.Force, "force", false, "Migrate service forcefully, replaces existing service if any.")
-	migrateCmd.Flags().BoolVar(&migrateFlags.Delete, "delete", false, "Delete all Knative resources after kn-migration from source cluster")
+	migrateCmd.AddCommand(NewGenerateJobCommand())
+	migrateCmd.AddCommand(NewSyncCommand())
+	migrateCmd.AddCommand(NewCheckCommand())
+	migrateCmd.AddCommand(NewDiffCommand())
+	migrateCmd.AddCommand(NewVerifyCommand())
+	migrateCmd.AddCommand(NewRehearseCommand())
+	migrateCmd.AddCommand(NewRestoreCommand())
+	migrateCmd.AddCommand(NewExportCommand())
+	migrateCmd.AddCommand(NewImportCommand())
+	migrateCmd.AddCommand(NewSimulateCommand())
+	migrateCmd.AddCommand(NewCutoverCommand())
 	return migrateCmd
 }
 
-func getClients(kubeConfig, namespace string) (*kubernetes.Clientset, command.MigrationClient, error) {
-	cfg, err := clientcmd.BuildConfigFromFlags("", kubeConfig)
+// clusterConfig locates a cluster in a kubeconfig file, or is the cluster the command runs in
+type clusterConfig struct {
+	KubeConfig string
+	// Context is the context of the kubeconfig file, its current context if empty
+	Context string
+	// InCluster uses the ServiceAccount of the pod the command runs in instead of a kubeconfig file
+	InCluster bool
+	// audit records the write requests sent to the cluster, nil without --audit-log
+	audit *auditLog
+}
+
+func (c clusterConfig) String() string {
+	if c.InCluster {
+		return "in-cluster"
+	}
+	if c.Context == "" {
+		return c.KubeConfig
+	}
+	return fmt.Sprintf("%s (context %s)", c.KubeConfig, c.Context)
+}
+
+// restConfig returns the client configuration of the cluster
+func (c clusterConfig) restConfig() (*rest.Config, error) {
+	var cfg *rest.Config
+	var err error
+	if c.InCluster {
+		cfg, err = rest.InClusterConfig()
+	} else {
+		cfg, err = command.BuildConfig(c.KubeConfig, c.Context)
+	}
+	if err != nil || c.audit == nil {
+		return cfg, err
+	}
+	cfg.Wrap(c.audit.wrap(c.String()))
+	return cfg, nil
+}
+
+// clusterHost returns the URL of the API server of a cluster, else the kubeconfig locating it
+func clusterHost(cluster clusterConfig) string {
+	cfg, err := cluster.restConfig()
+	if err != nil {
+		return cluster.String()
+	}
+	return cfg.Host
+}
+
+// getKubeConfigs returns the source and destination clusters, falling back to the KUBECONFIG and
+// KUBECONFIG_DESTINATION environment variables. A destination given by its context only is looked up
+// in the source kubeconfig, so that both clusters can be contexts of a single kubeconfig file. An in-cluster
+// source needs no kubeconfig, its destination is usually a kubeconfig mounted from a Secret.
+func getKubeConfigs(source, destination clusterConfig) (clusterConfig, clusterConfig, error) {
+	if source.InCluster && (source.KubeConfig != "" || source.Context != "") {
+		return clusterConfig{}, clusterConfig{}, fmt.Errorf("--source-in-cluster cannot be combined with --kubeconfig or --context")
+	}
+	if source.KubeConfig == "" && !source.InCluster {
+		source.KubeConfig = os.Getenv("KUBECONFIG")
+	}
+	if source.KubeConfig == "" && !source.InCluster {
+		return clusterConfig{}, clusterConfig{}, fmt.Errorf("cannot get source cluster kube config, please use --kubeconfig or export environment variable KUBECONFIG to set")
+	}
+
+	if destination.KubeConfig == "" {
+		destination.KubeConfig = os.Getenv("KUBECONFIG_DESTINATION")
+	}
+	if destination.KubeConfig == "" && destination.Context != "" && !source.InCluster {
+		destination.KubeConfig = source.KubeConfig
+	}
+	if destination.KubeConfig == "" {
+		return clusterConfig{}, clusterConfig{}, fmt.Errorf("cannot get destination cluster kube config, please use --destination-kubeconfig, --destination-context or export environment variable KUBECONFIG_DESTINATION to set")
+	}
+	return source, destination, nil
+}
+
+func getClients(cluster clusterConfig, namespace string) (kubernetes.Interface, command.MigrationClient, error) {
+	clientSet, servingClient, err := getClusterClients(cluster)
+	if err != nil {
+		return nil, nil, err
+	}
+	migrationClient := command.NewMigrationClient(servingClient, namespace)
+	return clientSet, migrationClient, nil
+}
+
+// getClusterClients returns the namespace independent clients of a cluster
+func getClusterClients(cluster clusterConfig) (kubernetes.Interface, serving_v1_client.ServingV1Interface, error) {
+	cfg, err := cluster.restConfig()
 	if err != nil {
 		return nil, nil, err
 	}
@@ -233,35 +908,291 @@ func getClients(kubeConfig, namespace string) (*kubernetes.Clientset, command.Mi
 	if err != nil {
 		return nil, nil, err
 	}
-	migrationClient := command.NewMigrationClient(servingClient, namespace)
-	return clientSet, migrationClient, nil
+	return clientSet, servingClient, nil
+}
+
+// getDomainMappingClient returns the DomainMapping client of a cluster
+func getDomainMappingClient(cluster clusterConfig) (serving_v1beta1_client.ServingV1beta1Interface, error) {
+	cfg, err := cluster.restConfig()
+	if err != nil {
+		return nil, err
+	}
+	return serving_v1beta1_client.NewForConfig(cfg)
+}
+
+// getDynamicClient returns the dynamic client of a cluster, for the kinds without a typed client in the plugin
+func getDynamicClient(cluster clusterConfig) (dynamic.Interface, error) {
+	cfg, err := cluster.restConfig()
+	if err != nil {
+		return nil, err
+	}
+	return dynamic.NewForConfig(cfg)
+}
+
+// migrateNamespace migrates the selected services of one source namespace to its destination namespace
+// and returns the names of the migrated services, the result of every service is recorded in the report.
+// With best effort a failed service does not stop the migration of the remaining services.
+func migrateNamespace(ctx context.Context, source migrationSource, clientSetD kubernetes.Interface, migrationClientD command.MigrationClient, namespaceD string, filter *serviceFilter, options *MigrationOptions, report *NamespaceReport) ([]string, error) {
+	namespaceS := source.Namespace()
+	defer report.done()
+	defer report.rename(options.Renames)
+	if options.Concurrency < 1 {
+		return nil, fmt.Errorf("the concurrency must be at least 1, got %d", options.Concurrency)
+	}
+
+	fmt.Fprintln(options.out(), color.GreenString("[Before migration in destination cluster]"))
+	err := migrationClientD.PrintServiceWithRevisions(ctx, options.out(), "destination")
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintln(options.out(), "\nNow migrate all Knative service resources")
+	fmt.Fprintln(options.out(), "From the source", color.BlueString(namespaceS), "namespace")
+	fmt.Fprintln(options.out(), "To the destination", color.BlueString(namespaceD), "namespace")
+
+	created, err := getOrCreateNamespace(ctx, sourceClientSet(source), clientSetD, namespaceS, namespaceD, options)
+	if err != nil {
+		return nil, err
+	}
+	if created {
+		options.changes().created("Namespace", namespaceD, namespaceD, clientSetD, nil)
+	}
+
+	// Services migrated by a previous run are not migrated again with --resume
+	progress, err := options.progress()
+	if err != nil {
+		return nil, err
+	}
+	// The source services are listed page by page, only the services left to migrate are kept
+	resumed := []string{}
+	servicesS := &serving_v1_api.ServiceList{}
+	err = source.EachService(ctx, filter, func(serviceS *serving_v1_api.Service) error {
+		if progress.done(namespaceS, namespaceD, serviceS.Name) {
+			resumed = append(resumed, serviceS.Name)
+			return nil
+		}
+		servicesS.Items = append(servicesS.Items, *serviceS)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	options.dashboard.start(namespaceS, namespaceD, len(resumed)+len(servicesS.Items))
+	migrated := []string{}
+	for _, name := range resumed {
+		fmt.Fprintln(options.out(), "Service", color.CyanString(name), "was migrated by the previous run, skip migrate service")
+		report.skip(name)
+		options.dashboard.skip(namespaceS, namespaceD)
+		migrated = append(migrated, name)
+	}
+
+	violations, err := checkObjectSizes(ctx, source, migrationClientD, namespaceD, servicesS, options.MaxObjectSize)
+	if err != nil {
+		return nil, err
+	}
+	if len(violations) > 0 {
+		fmt.Fprintln(options.out(), color.RedString("Cannot migrate because the following objects exceed the maximum object size of %d bytes:", options.MaxObjectSize))
+		for _, violation := range violations {
+			fmt.Fprintln(options.out(), "  |-", violation)
+		}
+		return nil, fmt.Errorf("%d object(s) of namespace %s exceed the maximum object size", len(violations), namespaceS)
+	}
+	capabilities := detectCapabilities(ctx, clientSetD, options)
+	incompatibilities, err := checkCompatibility(ctx, source, servicesS, capabilities, options.Revisions)
+	if err != nil {
+		return nil, err
+	}
+	if len(incompatibilities) > 0 {
+		fmt.Fprintln(options.out(), color.RedString("Cannot migrate because Knative Serving %s of the destination does not accept the following fields:", capabilities.Version))
+		for _, incompatibility := range incompatibilities {
+			fmt.Fprintln(options.out(), "  |-", incompatibility)
+		}
+		return nil, fmt.Errorf("%d service(s) of namespace %s use fields the destination does not accept", len(incompatibilities), namespaceS)
+	}
+	results := migrateGroups(ctx, source, clientSetD, migrationClientD, namespaceD, servicesS, options, progress)
+	var firstErr error
+	for i, result := range results {
+		if !result.started {
+			continue
+		}
+		name := servicesS.Items[i].Name
+		if result.skipped && result.err == nil {
+			// Skipped services are kept in the source by --delete
+			report.skip(name)
+			continue
+		}
+		report.add(name, result.revisions, result.dependencies, result.duration, result.err)
+		if result.err == nil {
+			migrated = append(migrated, name)
+		} else if firstErr == nil && !options.BestEffort {
+			firstErr = result.err
+		}
+		if result.checkpointErr != nil && firstErr == nil {
+			firstErr = result.checkpointErr
+		}
+	}
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	fmt.Fprintln(options.out(), color.GreenString("[After migration in destination cluster]"))
+	return migrated, migrationClientD.PrintServiceWithRevisions(ctx, options.out(), "destination")
+}
+
+// serviceResult is the outcome of the migration of one service by a worker
+type serviceResult struct {
+	started bool
+	// skipped is true when the service exists in the destination and conflicting services are skipped
+	skipped       bool
+	revisions     []string
+	dependencies  []string
+	duration      time.Duration
+	err           error
+	checkpointErr error
 }
 
-func getOrCreateNamespace(clientSet *kubernetes.Clientset, namespace string) error {
+// migrateServices migrates the services with options.Concurrency workers and returns their results in the order
+// of the services. The revisions of a service are always migrated in order by a single worker. Without best effort
+// no service is started once a service failed, the services already being migrated are still completed.
+// No service is started either once the context is done.
+func migrateServices(ctx context.Context, source migrationSource, clientSetD kubernetes.Interface, migrationClientD command.MigrationClient, namespaceD string, servicesS *serving_v1_api.ServiceList, options *MigrationOptions, progress *checkpoint) []serviceResult {
+	results := make([]serviceResult, len(servicesS.Items))
+	var aborted int32
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < options.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if atomic.LoadInt32(&aborted) != 0 || ctx.Err() != nil {
+					continue
+				}
+				serviceS := servicesS.Items[i]
+				started := time.Now()
+				var revisions, dependencies []string
+				err := options.Hooks.before(ctx, &serviceS)
+				if err == nil {
+					revisions, dependencies, err = migrateService(ctx, source, clientSetD, migrationClientD, namespaceD, serviceS, options)
+				}
+				skipped := errors.Is(err, errServiceSkipped)
+				if skipped {
+					err = nil
+				}
+				err = options.Hooks.after(ctx, &serviceS, err)
+				results[i] = serviceResult{started: true, skipped: skipped, revisions: revisions, dependencies: dependencies, duration: time.Since(started), err: err}
+				if skipped && err == nil {
+					// The service is neither migrated nor completed, a later run with another strategy migrates it
+					options.dashboard.skip(source.Namespace(), namespaceD)
+					continue
+				}
+				options.dashboard.service(source.Namespace(), namespaceD, serviceS.Name, err)
+				if err != nil {
+					if options.BestEffort {
+						fmt.Fprintln(options.out(), color.RedString("Failed to migrate service %s, continue with the remaining services: %s", serviceS.Name, err.Error()))
+					} else {
+						atomic.StoreInt32(&aborted, 1)
+					}
+					continue
+				}
+				results[i].checkpointErr = progress.complete(source.Namespace(), namespaceD, serviceS.Name)
+				if results[i].checkpointErr != nil {
+					atomic.StoreInt32(&aborted, 1)
+				}
+			}
+		}()
+	}
+	for i := range servicesS.Items {
+		if atomic.LoadInt32(&aborted) != 0 || ctx.Err() != nil {
+			break
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// migrateService migrates the objects of a single source service with the resource handlers of the options, by default
+// its configmap, its secrets, the service and its revisions, and returns the names of the revisions migrated so far
+// and the objects copied for it
+func migrateService(ctx context.Context, source migrationSource, clientSetD kubernetes.Interface, migrationClientD command.MigrationClient, namespaceD string, serviceS serving_v1_api.Service, options *MigrationOptions) ([]string, []string, error) {
+	fmt.Fprintln(options.out(), "Start migrate service", color.CyanString(serviceS.Name))
+	migration := &ServiceMigration{
+		SourceName:           serviceS.Name,
+		Service:              &serviceS,
+		Revisions:            &serving_v1_api.RevisionList{},
+		SourceNamespace:      source.Namespace(),
+		DestinationNamespace: namespaceD,
+		ClientSetD:           clientSetD,
+		MigrationClientD:     migrationClientD,
+		Options:              options,
+		source:               source,
+		revisions:            []string{},
+		dependencies:         []string{},
+	}
+	err := options.resourceHandlers().migrate(ctx, migration)
+	if errors.Is(err, errServiceSkipped) {
+		fmt.Fprintln(options.out(), "")
+		return nil, nil, err
+	}
+	if err != nil {
+		return migration.revisions, migration.dependencies, err
+	}
+	fmt.Fprintln(options.out(), "")
+	return migration.revisions, migration.dependencies, nil
+}
+
+// unresolvedTrafficRevisions returns the revisions named by the traffic block of a service which are neither
+// migrated with it nor created by it from its template, so that the route of the destination service cannot resolve them
+func unresolvedTrafficRevisions(serviceS serving_v1_api.Service, revisionsS *serving_v1_api.RevisionList) []string {
+	known := map[string]bool{command.TemplateRevisionName(serviceS): true}
+	for _, revision := range revisionsS.Items {
+		known[revision.Name] = true
+	}
+	missing := []string{}
+	for _, target := range serviceS.Spec.Traffic {
+		if target.RevisionName != "" && !known[target.RevisionName] {
+			missing = append(missing, target.RevisionName)
+			known[target.RevisionName] = true
+		}
+	}
+	return missing
+}
+
+// getOrCreateNamespace creates the namespace if it does not exist and returns true if it was created. The namespace
+// is created with the labels and annotations of the source namespace, read with clientSetS unless it is nil.
+func getOrCreateNamespace(ctx context.Context, clientSetS, clientSet kubernetes.Interface, namespaceS, namespace string, options *MigrationOptions) (bool, error) {
 	namespaceExists := true
-	_, err := clientSet.CoreV1().Namespaces().Get(context.TODO(), namespace, metav1.GetOptions{})
+	_, err := clientSet.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
 	if api_errors.IsNotFound(err) {
 		namespaceExists = false
-	}
-	if err != nil {
-		return err
+	} else if err != nil {
+		return false, err
 	}
 
 	if !namespaceExists {
-		fmt.Println("Create namespace", color.BlueString(migrateFlags.Namespace), "in destination cluster")
-		nsSpec := &apiv1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}}
-		_, err := clientSet.CoreV1().Namespaces().Create(context.TODO(), nsSpec, metav1.CreateOptions{})
+		fmt.Fprintln(options.out(), "Create namespace", color.BlueString(namespace), "in destination cluster")
+		sourceNamespace, err := readSourceNamespace(ctx, clientSetS, namespaceS, options)
 		if err != nil {
-			return err
+			return false, err
 		}
-	} else {
-		fmt.Println("Namespace", migrateFlags.Namespace, "already exists in destination cluster")
+		nsSpec := buildNamespace(namespace, sourceNamespace, options)
+		_, err = clientSet.CoreV1().Namespaces().Create(ctx, nsSpec, metav1.CreateOptions{})
+		if err != nil {
+			return false, destinationError(err)
+		}
+		return true, nil
 	}
-	return nil
+	fmt.Fprintln(options.out(), "Namespace", namespace, "already exists in destination cluster")
+	return false, nil
 }
 
-func getConfigmap(clientSet *kubernetes.Clientset, namespace, configmapName string) (*apiv1.ConfigMap, error) {
-	cm, err := clientSet.CoreV1().ConfigMaps(namespace).Get(context.TODO(), configmapName, metav1.GetOptions{})
+func getConfigmap(ctx context.Context, clientSet kubernetes.Interface, namespace, configmapName string) (*apiv1.ConfigMap, error) {
+	cm, err := clientSet.CoreV1().ConfigMaps(namespace).Get(ctx, configmapName, metav1.GetOptions{})
 	if err != nil {
 		return nil, err
 	}
@@ -269,88 +1200,149 @@ func getConfigmap(clientSet *kubernetes.Clientset, namespace, configmapName stri
 	return cm, nil
 }
 
-func createConfigmap(clientSet *kubernetes.Clientset, namespace string, configmap *apiv1.ConfigMap) error {
-	cm := apiv1.ConfigMap{
+// applyConfigmap creates the configmap in the destination namespace, an existing configmap is replaced when forced
+// and managed by the migration, and fails the migration otherwise. It returns the configmap it replaced, if any.
+func applyConfigmap(ctx context.Context, clientSet kubernetes.Interface, namespace string, configmap *apiv1.ConfigMap, force bool, options *MigrationOptions) (*apiv1.ConfigMap, error) {
+	built := buildConfigmap(namespace, configmap)
+	options.stampOwnership(&built.ObjectMeta)
+	_, err := clientSet.CoreV1().ConfigMaps(namespace).Create(ctx, built, metav1.CreateOptions{})
+	if !api_errors.IsAlreadyExists(err) {
+		return nil, destinationError(err)
+	}
+	if !force {
+		return nil, fmt.Errorf("cannot migrate configmap %s: it already exists in the destination and configmaps are not forced, use --force or --force-scope configmaps to replace it", configmap.Name)
+	}
+	existing, err := getConfigmap(ctx, clientSet, namespace, configmap.Name)
+	if err != nil {
+		return nil, err
+	}
+	if err := options.checkOwnership("ConfigMap", configmap.Name, existing.Annotations); err != nil {
+		return nil, err
+	}
+	built.ResourceVersion = existing.ResourceVersion
+	_, err = clientSet.CoreV1().ConfigMaps(namespace).Update(ctx, built, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, destinationError(err)
+	}
+	return existing, nil
+}
+
+// buildConfigmap returns the copy of the configmap to create in the given namespace
+func buildConfigmap(namespace string, configmap *apiv1.ConfigMap) *apiv1.ConfigMap {
+	return &apiv1.ConfigMap{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       "ConfigMap",
 			APIVersion: "v1",
 		},
-		ObjectMeta: metav1.ObjectMeta{
-			Name:        configmap.Name,
-			Namespace:   namespace,
-			Labels:      configmap.Labels,
-			Annotations: configmap.Annotations,
-		},
-		Data: configmap.Data,
+		ObjectMeta: command.SanitizeObjectMeta(configmap.ObjectMeta, namespace),
+		Data:       configmap.Data,
 	}
-
-	_, err := clientSet.CoreV1().ConfigMaps(namespace).Create(context.TODO(), &cm, metav1.CreateOptions{})
-	return err
 }
 
-func createService(migrationClient command.MigrationClient, service serving_v1_api.Service, force bool) error {
-	serviceExists, err := migrationClient.ServiceExists(service.Name)
-	if err != nil {
+// createService creates the service in the destination, an existing service is handled with the conflict strategy:
+// the service is applied over it with server-side apply, or merged into it, keeping its revisions in both cases,
+// or it is deleted and created again with recreate
+func createService(ctx context.Context, out io.Writer, migrationClient command.MigrationClient, service serving_v1_api.Service, strategy ConflictStrategy, recreate bool) error {
+	existing, err := migrationClient.GetService(ctx, service.Name)
+	serviceExists := err == nil
+	if err != nil && !api_errors.IsNotFound(err) {
 		return err
 	}
 
 	if serviceExists {
-		if !force {
-			return fmt.Errorf("cannot migrate service %s in namespace because the service already exists and no --force option was given", service.Name)
+		if !strategy.replaces() {
+			return fmt.Errorf("cannot migrate service %s: %w and no --force or --on-conflict option was given", service.Name, ErrServiceExists)
 		}
-		fmt.Println("Deleting service", color.CyanString(service.Name), "from the destination cluster and recreate as replacement")
-		migrationClient.DeleteService(service.Name)
+		if strategy == ConflictMerge {
+			fmt.Fprintln(out, "Merging service", color.CyanString(service.Name), "into the existing service of the destination cluster")
+			merged := mergeService(*existing, service)
+			_, err = migrationClient.ApplyService(ctx, &merged, command.FieldManager)
+			return destinationError(err)
+		}
+		if !recreate {
+			fmt.Fprintln(out, "Applying service", color.CyanString(service.Name), "over the existing service of the destination cluster")
+			_, err = migrationClient.ApplyService(ctx, &service, command.FieldManager)
+			return destinationError(err)
+		}
+		fmt.Fprintln(out, "Deleting service", color.CyanString(service.Name), "from the destination cluster and recreate as replacement")
+		err = migrationClient.DeleteService(ctx, service.Name)
 		if err != nil {
 			return err
 		}
 	}
-	_, err = migrationClient.CreateService(&service)
+	_, err = migrationClient.CreateService(ctx, &service)
 	if err != nil {
-		return err
+		return destinationError(err)
 	}
 	return nil
 }
 
-func deleteAllServices(migrationClient command.MigrationClient, delete bool) error {
+// deleteServices deletes the migrated services from the source cluster with --delete,
+// services which failed to migrate are never deleted
+func deleteServices(ctx context.Context, clientSet kubernetes.Interface, migrationClient, migrationClientD command.MigrationClient, namespace string, names []string, delete bool, options *MigrationOptions, gracePeriod time.Duration, backupDir string) error {
 	if !delete {
-		fmt.Println("Migrate without --delete option, skip deleting Knative resource in source cluster")
-	} else {
-		fmt.Println("Migrate with --delete option, deleting all Knative resource in source cluster")
-		services, err := migrationClient.ListService()
-		if err != nil {
-			return err
-		}
-		for i := 0; i < len(services.Items); i++ {
-			service := services.Items[i]
-			err = migrationClient.DeleteService(service.Name)
-			if err != nil {
-				return err
-			}
-			fmt.Println("Deleted service", service.Name, "in source cluster")
+		fmt.Fprintln(options.out(), "Migrate without --delete option, skip deleting Knative resource in source cluster")
+		return nil
+	}
+	fmt.Fprintln(options.out(), "Migrate with --delete option, deleting the migrated Knative resource verified in the destination from source cluster")
+
+	// Services deleted by a previous run are not verified nor deleted again with --resume
+	progress, err := options.progress()
+	if err != nil {
+		return err
+	}
+	pending := []string{}
+	for _, name := range names {
+		if progress.deleted(namespace, name) {
+			fmt.Fprintln(options.out(), "Service", color.CyanString(name), "was deleted from the source by the previous run, skip delete service")
+			continue
 		}
+		pending = append(pending, name)
+	}
+
+	verified, failures, err := verifyBeforeDeletion(ctx, migrationClientD, pending, options, gracePeriod)
+	if err != nil {
+		return err
+	}
+	if err := backupServices(ctx, options.out(), clientSet, migrationClient, namespace, verified, backupDir); err != nil {
+		return err
+	}
+	if err := deleteVerifiedServices(ctx, migrationClient, namespace, verified, options, progress); err != nil {
+		return err
+	}
+	if len(failures) > 0 {
+		return keptServicesError(options.out(), failures)
 	}
 	return nil
 }
 
-func migrateRevision(migrationClient command.MigrationClient, revisionS serving_v1_api.Revision, serviceS serving_v1_api.Service, configUuid types.UID, latestCreatedRevisionName string) error {
+func migrateRevision(ctx context.Context, migrationClient command.MigrationClient, revisionS serving_v1_api.Revision, serviceS serving_v1_api.Service, configUuid types.UID, latestCreatedRevisionName string, options *MigrationOptions) error {
 	// change configuration
 
 	if revisionS.Name != latestCreatedRevisionName {
-		_, err := migrationClient.CreateRevision(&revisionS, configUuid)
+		err := options.paced(ctx, "create revision "+revisionS.Name, func() error {
+			_, err := migrationClient.CreateRevision(ctx, &revisionS, configUuid)
+			return destinationError(err)
+		})
 		if err != nil {
 			return err
 		}
-		fmt.Println("Migrated revision", color.CyanString(revisionS.Name), "successfully")
+		fmt.Fprintln(options.out(), "Migrated revision", color.CyanString(revisionS.Name), "successfully")
 	} else {
 		getRetries := 0
 		updateRetries := 0
+		getBackoff := newRetryBackoff(options.RetryBackoff)
+		updateBackoff := newRetryBackoff(options.RetryBackoff)
 		for {
-			revision, err := migrationClient.GetRevision(revisionS.Name)
+			revision, err := migrationClient.GetRevision(ctx, revisionS.Name)
 			if err != nil {
-				if api_errors.IsNotFound(err) && getRetries < MaxGetRetries {
-					fmt.Printf("retry to get revision(%s) after 1sec(try#: %d)\n", revisionS.Name, getRetries)
+				if api_errors.IsNotFound(err) && getRetries < options.MaxRetries {
+					delay := getBackoff.Step()
 					getRetries++
-					time.Sleep(time.Second)
+					fmt.Fprintf(options.out(), "retry to get revision(%s) after %s(try#: %d)\n", revisionS.Name, delay.Round(time.Millisecond), getRetries)
+					if err := options.wait(ctx, delay, "get revision "+revisionS.Name); err != nil {
+						return err
+					}
 					continue
 				}
 				return err
@@ -358,33 +1350,52 @@ func migrateRevision(migrationClient command.MigrationClient, revisionS serving_
 
 			sourceRevisionGeneration := revisionS.ObjectMeta.Labels["serving.knative.dev/configurationGeneration"]
 			revision.ObjectMeta.Labels["serving.knative.dev/configurationGeneration"] = sourceRevisionGeneration
+			for _, key := range revisionHistoryAnnotations {
+				if value, ok := revisionS.Annotations[key]; ok {
+					if revision.Annotations == nil {
+						revision.Annotations = map[string]string{}
+					}
+					revision.Annotations[key] = value
+				}
+			}
 
-			err = migrationClient.UpdateRevision(revision)
+			err = options.paced(ctx, "update revision "+revisionS.Name, func() error {
+				return migrationClient.UpdateRevision(ctx, revision)
+			})
 			if err != nil {
 				// Retry to update when a resource version conflict exists
-				if api_errors.IsConflict(err) && updateRetries < MaxUpdateRetries {
-					fmt.Printf("retry to update revision(%s) after 1sec(try#: %d)\n", revisionS.Name, updateRetries)
+				if api_errors.IsConflict(err) && updateRetries < options.MaxRetries {
+					delay := updateBackoff.Step()
 					updateRetries++
+					fmt.Fprintf(options.out(), "retry to update revision(%s) after %s(try#: %d)\n", revisionS.Name, delay.Round(time.Millisecond), updateRetries)
+					if err := options.wait(ctx, delay, "update revision "+revisionS.Name); err != nil {
+						return err
+					}
 					continue
 				}
 				return err
 			}
-			fmt.Println("Replace revision", color.CyanString(revisionS.Name), "to generation", sourceRevisionGeneration, "successfully")
+			fmt.Fprintln(options.out(), "Replace revision", color.CyanString(revisionS.Name), "to generation", sourceRevisionGeneration, "successfully")
 			break
 		}
 	}
 	return nil
 }
 
-func getConfig(migrationClient command.MigrationClient, serviceName string) (*serving_v1_api.Configuration, error) {
+func getConfig(ctx context.Context, migrationClient command.MigrationClient, serviceName string, options *MigrationOptions) (*serving_v1_api.Configuration, error) {
 	retries := 0
+	backoff := newRetryBackoff(options.RetryBackoff)
 	for {
-		config, err := migrationClient.GetConfig(serviceName)
+		config, err := migrationClient.GetConfig(ctx, serviceName)
 		if err != nil {
-			if api_errors.IsNotFound(err) && retries < MaxGetRetries {
-				fmt.Printf(err.Error())
-				fmt.Printf(" retry after 1sec(try#: %d)\n", retries+1)
-				time.Sleep(time.Second)
+			if api_errors.IsNotFound(err) && retries < options.MaxRetries {
+				delay := backoff.Step()
+				fmt.Fprint(options.out(), err.Error())
+				fmt.Fprintf(options.out(), " retry after %s(try#: %d)\n", delay.Round(time.Millisecond), retries+1)
+				retries++
+				if err := options.wait(ctx, delay, "get configuration "+serviceName); err != nil {
+					return nil, err
+				}
 				continue
 			}
 			return nil, err
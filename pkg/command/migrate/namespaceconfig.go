@@ -0,0 +1,216 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+
+	apiv1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"knative.dev/kn-plugin-migration/pkg/command"
+)
+
+// namespaceNameLabel is the label Kubernetes sets on every namespace with its name, used by network policies
+// to select namespaces by name
+const namespaceNameLabel = "kubernetes.io/metadata.name"
+
+// namespaceConfig are the NetworkPolicies, ResourceQuotas and LimitRanges of a namespace, as created in the destination
+type namespaceConfig struct {
+	networkPolicies []*networkingv1.NetworkPolicy
+	resourceQuotas  []*apiv1.ResourceQuota
+	limitRanges     []*apiv1.LimitRange
+}
+
+// readNamespaceConfig reads the namespace config of the source namespace and builds its copies for the destination
+// namespace. The network policies selecting the source namespace by name select the destination namespace instead.
+func readNamespaceConfig(ctx context.Context, clientSetS kubernetes.Interface, namespaceS, namespaceD string) (*namespaceConfig, error) {
+	config := &namespaceConfig{}
+	policies, err := clientSetS.NetworkingV1().NetworkPolicies(namespaceS).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, sourceError(err)
+	}
+	for _, policyS := range policies.Items {
+		policy := &networkingv1.NetworkPolicy{
+			ObjectMeta: command.SanitizeObjectMeta(policyS.ObjectMeta, namespaceD),
+			Spec:       *policyS.Spec.DeepCopy(),
+		}
+		renamePolicyNamespace(policy, namespaceS, namespaceD)
+		config.networkPolicies = append(config.networkPolicies, policy)
+	}
+	quotas, err := clientSetS.CoreV1().ResourceQuotas(namespaceS).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, sourceError(err)
+	}
+	for _, quotaS := range quotas.Items {
+		config.resourceQuotas = append(config.resourceQuotas, &apiv1.ResourceQuota{
+			ObjectMeta: command.SanitizeObjectMeta(quotaS.ObjectMeta, namespaceD),
+			Spec:       *quotaS.Spec.DeepCopy(),
+		})
+	}
+	limitRanges, err := clientSetS.CoreV1().LimitRanges(namespaceS).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, sourceError(err)
+	}
+	for _, limitRangeS := range limitRanges.Items {
+		config.limitRanges = append(config.limitRanges, &apiv1.LimitRange{
+			ObjectMeta: command.SanitizeObjectMeta(limitRangeS.ObjectMeta, namespaceD),
+			Spec:       *limitRangeS.Spec.DeepCopy(),
+		})
+	}
+	return config, nil
+}
+
+// renamePolicyNamespace replaces the source namespace by the destination namespace in the namespace selectors
+// of the rules of a network policy which select it by name
+func renamePolicyNamespace(policy *networkingv1.NetworkPolicy, namespaceS, namespaceD string) {
+	rename := func(peers []networkingv1.NetworkPolicyPeer) {
+		for _, peer := range peers {
+			if peer.NamespaceSelector == nil {
+				continue
+			}
+			if peer.NamespaceSelector.MatchLabels[namespaceNameLabel] == namespaceS {
+				peer.NamespaceSelector.MatchLabels[namespaceNameLabel] = namespaceD
+			}
+			for i, expression := range peer.NamespaceSelector.MatchExpressions {
+				if expression.Key != namespaceNameLabel {
+					continue
+				}
+				for j, value := range expression.Values {
+					if value == namespaceS {
+						peer.NamespaceSelector.MatchExpressions[i].Values[j] = namespaceD
+					}
+				}
+			}
+		}
+	}
+	for _, rule := range policy.Spec.Ingress {
+		rename(rule.From)
+	}
+	for _, rule := range policy.Spec.Egress {
+		rename(rule.To)
+	}
+}
+
+// copyNamespaceConfig copies the NetworkPolicies, ResourceQuotas and LimitRanges of the source namespace to the
+// destination namespace before its services are migrated, so that they run under the same constraints and
+// connectivity rules, and returns the objects it created. Objects already existing in the destination are kept.
+func copyNamespaceConfig(ctx context.Context, clientSetS, clientSetD kubernetes.Interface, namespaceS, namespaceD string, options *MigrationOptions) ([]string, error) {
+	copied := []string{}
+	config, err := readNamespaceConfig(ctx, clientSetS, namespaceS, namespaceD)
+	if err != nil {
+		return copied, err
+	}
+	created, err := getOrCreateNamespace(ctx, clientSetS, clientSetD, namespaceS, namespaceD, options)
+	if err != nil {
+		return copied, err
+	}
+	if created {
+		options.changes().created("Namespace", namespaceD, namespaceD, clientSetD, nil)
+	}
+
+	copyObject := func(kind, what, name string, create func() error) error {
+		created, err := createIfAbsent(ctx, what+" "+name, options, create)
+		if err != nil {
+			return err
+		}
+		if created {
+			options.changes().created(kind, namespaceD, name, clientSetD, nil)
+			copied = append(copied, kind+" "+name)
+		}
+		return nil
+	}
+	for _, policy := range config.networkPolicies {
+		options.stampOwnership(&policy.ObjectMeta)
+		err := copyObject("NetworkPolicy", "networkpolicy", policy.Name, func() error {
+			_, err := clientSetD.NetworkingV1().NetworkPolicies(namespaceD).Create(ctx, policy, metav1.CreateOptions{})
+			return err
+		})
+		if err != nil {
+			return copied, err
+		}
+	}
+	for _, quota := range config.resourceQuotas {
+		options.stampOwnership(&quota.ObjectMeta)
+		err := copyObject("ResourceQuota", "resourcequota", quota.Name, func() error {
+			_, err := clientSetD.CoreV1().ResourceQuotas(namespaceD).Create(ctx, quota, metav1.CreateOptions{})
+			return err
+		})
+		if err != nil {
+			return copied, err
+		}
+	}
+	for _, limitRange := range config.limitRanges {
+		options.stampOwnership(&limitRange.ObjectMeta)
+		err := copyObject("LimitRange", "limitrange", limitRange.Name, func() error {
+			_, err := clientSetD.CoreV1().LimitRanges(namespaceD).Create(ctx, limitRange, metav1.CreateOptions{})
+			return err
+		})
+		if err != nil {
+			return copied, err
+		}
+	}
+	return copied, nil
+}
+
+// planNamespaceConfig adds the NetworkPolicies, ResourceQuotas and LimitRanges of the namespace of a plan
+func planNamespaceConfig(ctx context.Context, plan *migrationPlan, clientSetS, clientSetD kubernetes.Interface) error {
+	config, err := readNamespaceConfig(ctx, clientSetS, plan.SourceNamespace, plan.DestinationNamespace)
+	if err != nil {
+		return err
+	}
+	add := func(kind, name string, get func() error) error {
+		err := get()
+		switch {
+		case api_errors.IsNotFound(err):
+			plan.add(planEntry{Kind: kind, Name: name, Namespace: plan.DestinationNamespace, Cluster: "destination", Action: planActionCreate})
+		case err != nil:
+			return err
+		default:
+			plan.add(planEntry{Kind: kind, Name: name, Namespace: plan.DestinationNamespace, Cluster: "destination", Action: planActionSkip, Reason: "already exists, the destination object is kept"})
+		}
+		return nil
+	}
+	for _, policy := range config.networkPolicies {
+		err := add("NetworkPolicy", policy.Name, func() error {
+			_, err := clientSetD.NetworkingV1().NetworkPolicies(plan.DestinationNamespace).Get(ctx, policy.Name, metav1.GetOptions{})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, quota := range config.resourceQuotas {
+		err := add("ResourceQuota", quota.Name, func() error {
+			_, err := clientSetD.CoreV1().ResourceQuotas(plan.DestinationNamespace).Get(ctx, quota.Name, metav1.GetOptions{})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, limitRange := range config.limitRanges {
+		err := add("LimitRange", limitRange.Name, func() error {
+			_, err := clientSetD.CoreV1().LimitRanges(plan.DestinationNamespace).Get(ctx, limitRange.Name, metav1.GetOptions{})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"testing"
+
+	"gotest.tools/assert"
+	apiv1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8s_fake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCopyNamespaceConfig(t *testing.T) {
+	policy := &networkingv1.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{Name: "allow-gateway", Namespace: "default", ResourceVersion: "12"}}
+	policy.Spec.Ingress = []networkingv1.NetworkPolicyIngressRule{{From: []networkingv1.NetworkPolicyPeer{
+		{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{namespaceNameLabel: "default"}}},
+		{NamespaceSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: namespaceNameLabel, Operator: metav1.LabelSelectorOpIn, Values: []string{"default", "kourier-system"}}}}},
+	}}}
+	quota := &apiv1.ResourceQuota{ObjectMeta: metav1.ObjectMeta{Name: "compute", Namespace: "default"}, Spec: apiv1.ResourceQuotaSpec{Hard: apiv1.ResourceList{apiv1.ResourceLimitsCPU: resource.MustParse("8")}}}
+	quota.Status.Used = apiv1.ResourceList{apiv1.ResourceLimitsCPU: resource.MustParse("2")}
+	limitRange := &apiv1.LimitRange{ObjectMeta: metav1.ObjectMeta{Name: "defaults", Namespace: "default"}, Spec: apiv1.LimitRangeSpec{Limits: []apiv1.LimitRangeItem{{Type: apiv1.LimitTypeContainer, Default: apiv1.ResourceList{apiv1.ResourceMemory: resource.MustParse("256Mi")}}}}}
+	clientSetS := k8s_fake.NewSimpleClientset(policy, quota, limitRange)
+	// The destination already limits its containers differently
+	clientSetD := k8s_fake.NewSimpleClientset(&apiv1.LimitRange{ObjectMeta: metav1.ObjectMeta{Name: "defaults", Namespace: "prod"}})
+	options := NewMigrationOptions()
+
+	copied, err := copyNamespaceConfig(context.Background(), clientSetS, clientSetD, "default", "prod", options)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, copied, []string{"NetworkPolicy allow-gateway", "ResourceQuota compute"})
+
+	policyD, err := clientSetD.NetworkingV1().NetworkPolicies("prod").Get(context.Background(), "allow-gateway", metav1.GetOptions{})
+	assert.NilError(t, err)
+	assert.Equal(t, policyD.ResourceVersion, "")
+	assert.Equal(t, policyD.Spec.Ingress[0].From[0].NamespaceSelector.MatchLabels[namespaceNameLabel], "prod")
+	assert.DeepEqual(t, policyD.Spec.Ingress[0].From[1].NamespaceSelector.MatchExpressions[0].Values, []string{"prod", "kourier-system"})
+	// The source policy is not modified
+	assert.Equal(t, policy.Spec.Ingress[0].From[0].NamespaceSelector.MatchLabels[namespaceNameLabel], "default")
+
+	quotaD, err := clientSetD.CoreV1().ResourceQuotas("prod").Get(context.Background(), "compute", metav1.GetOptions{})
+	assert.NilError(t, err)
+	limits := quotaD.Spec.Hard[apiv1.ResourceLimitsCPU]
+	assert.Equal(t, limits.String(), "8")
+	assert.Equal(t, len(quotaD.Status.Used), 0)
+
+	limitRangeD, err := clientSetD.CoreV1().LimitRanges("prod").Get(context.Background(), "defaults", metav1.GetOptions{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(limitRangeD.Spec.Limits), 0)
+
+	plan := &migrationPlan{SourceNamespace: "default", DestinationNamespace: "prod"}
+	assert.NilError(t, planNamespaceConfig(context.Background(), plan, clientSetS, clientSetD))
+	assert.Equal(t, len(plan.Entries), 3)
+	for _, entry := range plan.Entries {
+		assert.Equal(t, entry.Action, planActionSkip, entry.Kind)
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	apiv1 "k8s.io/api/core/v1"
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+	"knative.dev/kn-plugin-migration/pkg/command"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+	serving_v1_client "knative.dev/serving/pkg/client/clientset/versioned/typed/serving/v1"
+)
+
+// namespacePair is a source namespace and the destination namespace it is migrated to
+type namespacePair struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	// Referenced is true for a namespace pulled in by a cross-namespace reference, all its services are migrated
+	Referenced bool `json:"referenced,omitempty"`
+}
+
+// resolveNamespaces returns the namespaces to migrate in a stable order. The destination of a
+// namespace is taken from the mapping file, else from destinationNamespace for a single source
+// namespace, else it keeps the name of the source namespace.
+func resolveNamespaces(ctx context.Context, servingClient serving_v1_client.ServingV1Interface, namespaces []string, allNamespaces bool, destinationNamespace, mappingFile string) ([]namespacePair, error) {
+	if allNamespaces && len(namespaces) > 0 {
+		return nil, fmt.Errorf("--namespace and --all-namespaces cannot be used together")
+	}
+
+	sources := []string{}
+	if allNamespaces {
+		seen := map[string]bool{}
+		list := func(opts metav1.ListOptions) (runtime.Object, error) {
+			return servingClient.Services("").List(ctx, opts)
+		}
+		err := command.EachListItem(ctx, command.DefaultListPageSize, "", list, func(object runtime.Object) error {
+			namespace := object.(*serving_v1_api.Service).Namespace
+			if !seen[namespace] {
+				seen[namespace] = true
+				sources = append(sources, namespace)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(sources)
+	} else {
+		for _, namespace := range namespaces {
+			namespace = strings.TrimSpace(namespace)
+			if namespace != "" {
+				sources = append(sources, namespace)
+			}
+		}
+		if len(sources) == 0 {
+			return nil, fmt.Errorf("cannot get source cluster namespace, please use --namespace or --all-namespaces to set")
+		}
+	}
+
+	if destinationNamespace != "" && (allNamespaces || len(sources) > 1) {
+		return nil, fmt.Errorf("--destination-namespace can only be used with a single source namespace, please use --namespace-mapping to map several namespaces")
+	}
+
+	mapping := map[string]string{}
+	if mappingFile != "" {
+		var err error
+		mapping, err = parseNamespaceMapping(mappingFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	pairs := []namespacePair{}
+	for _, source := range sources {
+		destination := mapping[source]
+		if destination == "" {
+			destination = destinationNamespace
+		}
+		if destination == "" {
+			destination = source
+		}
+		pairs = append(pairs, namespacePair{Source: source, Destination: destination})
+	}
+	return pairs, nil
+}
+
+// parseNamespaceMapping reads a file of 'src-ns=dst-ns' lines, empty lines and lines
+// starting with '#' are ignored
+func parseNamespaceMapping(file string) (map[string]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	mapping := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		parts := strings.SplitN(text, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("invalid namespace mapping %q at %s:%d, expected src-ns=dst-ns", text, file, line)
+		}
+		mapping[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return mapping, nil
+}
+
+// namespaceAnnotationPrefixes are the prefixes of the namespace annotations set by the source cluster for itself,
+// e.g. the user and group ranges OpenShift allocates to every namespace, which are not copied
+var namespaceAnnotationPrefixes = []string{
+	"openshift.io/sa.scc.",
+	"scheduler.alpha.kubernetes.io/",
+}
+
+// parseNamespaceLabels parses the key=value pairs of --namespace-label, key- removes the label
+func parseNamespaceLabels(pairs []string) (map[string]*string, error) {
+	labels := map[string]*string{}
+	for _, pair := range pairs {
+		pair = strings.TrimSpace(pair)
+		if strings.HasSuffix(pair, "-") && !strings.Contains(pair, "=") {
+			key := strings.TrimSuffix(pair, "-")
+			if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+				return nil, fmt.Errorf("invalid --namespace-label %q: %s", pair, strings.Join(errs, ", "))
+			}
+			labels[key] = nil
+			continue
+		}
+		key, value, err := parseMetadataPair("--namespace-label", pair)
+		if err != nil {
+			return nil, fmt.Errorf("%w, or key- to remove the label", err)
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid --namespace-label %q: %s", pair, strings.Join(errs, ", "))
+		}
+		labels[key] = &value
+	}
+	return labels, nil
+}
+
+// readSourceNamespace returns the source namespace whose metadata the destination namespace is created with, nil
+// without the source cluster, with CopyNamespaceMetadata unset or when the source namespace does not exist
+func readSourceNamespace(ctx context.Context, clientSetS kubernetes.Interface, namespace string, options *MigrationOptions) (*apiv1.Namespace, error) {
+	if clientSetS == nil || !options.CopyNamespaceMetadata {
+		return nil, nil
+	}
+	namespaceS, err := clientSetS.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if api_errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, sourceError(err)
+	}
+	return namespaceS, nil
+}
+
+// buildNamespace returns the destination namespace to create with the labels and annotations of the source namespace,
+// if any, and the labels of the options
+func buildNamespace(name string, namespaceS *apiv1.Namespace, options *MigrationOptions) *apiv1.Namespace {
+	namespace := &apiv1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	if namespaceS != nil {
+		namespace.ObjectMeta = command.SanitizeObjectMeta(namespaceS.ObjectMeta, "")
+		namespace.Name = name
+		// The name label is set by the destination cluster
+		delete(namespace.Labels, namespaceNameLabel)
+		for key := range namespace.Annotations {
+			for _, prefix := range namespaceAnnotationPrefixes {
+				if strings.HasPrefix(key, prefix) {
+					delete(namespace.Annotations, key)
+				}
+			}
+		}
+	}
+	for key, value := range options.NamespaceLabels {
+		if value == nil {
+			delete(namespace.Labels, key)
+			continue
+		}
+		if namespace.Labels == nil {
+			namespace.Labels = map[string]string{}
+		}
+		namespace.Labels[key] = *value
+	}
+	if len(namespace.Labels) == 0 {
+		namespace.Labels = nil
+	}
+	if len(namespace.Annotations) == 0 {
+		namespace.Annotations = nil
+	}
+	return namespace
+}
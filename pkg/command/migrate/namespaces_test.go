@@ -0,0 +1,84 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"testing"
+
+	"gotest.tools/assert"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8s_fake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseNamespaceLabels(t *testing.T) {
+	labels, err := parseNamespaceLabels([]string{"istio-injection=disabled", "pod-security.kubernetes.io/enforce-", "team="})
+	assert.NilError(t, err)
+	assert.Equal(t, len(labels), 3)
+	assert.Equal(t, *labels["istio-injection"], "disabled")
+	assert.Assert(t, labels["pod-security.kubernetes.io/enforce"] == nil)
+	assert.Equal(t, *labels["team"], "")
+
+	_, err = parseNamespaceLabels([]string{"istio-injection"})
+	assert.ErrorContains(t, err, "invalid --namespace-label \"istio-injection\", expected key=value, or key- to remove the label")
+	_, err = parseNamespaceLabels([]string{"team=a b"})
+	assert.ErrorContains(t, err, "invalid --namespace-label \"team=a b\"")
+}
+
+func TestGetOrCreateNamespace(t *testing.T) {
+	clientSetS := k8s_fake.NewSimpleClientset(&apiv1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:            "default",
+		ResourceVersion: "7",
+		Labels: map[string]string{
+			namespaceNameLabel:                   "default",
+			"istio-injection":                    "enabled",
+			"pod-security.kubernetes.io/enforce": "restricted",
+		},
+		Annotations: map[string]string{
+			"openshift.io/sa.scc.uid-range":                    "1000650000/10000",
+			"kubectl.kubernetes.io/last-applied-configuration": "{}",
+			"owner": "payments",
+		},
+	}})
+	clientSetD := k8s_fake.NewSimpleClientset()
+	labels, err := parseNamespaceLabels([]string{"pod-security.kubernetes.io/enforce-", "env=prod"})
+	assert.NilError(t, err)
+	options := NewMigrationOptions()
+	options.NamespaceLabels = labels
+
+	created, err := getOrCreateNamespace(context.Background(), clientSetS, clientSetD, "default", "prod", options)
+	assert.NilError(t, err)
+	assert.Assert(t, created)
+	namespace, err := clientSetD.CoreV1().Namespaces().Get(context.Background(), "prod", metav1.GetOptions{})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, namespace.Labels, map[string]string{"istio-injection": "enabled", "env": "prod"})
+	assert.DeepEqual(t, namespace.Annotations, map[string]string{"owner": "payments"})
+	assert.Equal(t, namespace.ResourceVersion, "")
+
+	// An existing namespace is kept as it is
+	created, err = getOrCreateNamespace(context.Background(), clientSetS, clientSetD, "default", "prod", options)
+	assert.NilError(t, err)
+	assert.Assert(t, !created)
+
+	// Without the source metadata only the labels of the options are set
+	options.CopyNamespaceMetadata = false
+	_, err = getOrCreateNamespace(context.Background(), clientSetS, clientSetD, "default", "bare", options)
+	assert.NilError(t, err)
+	namespace, err = clientSetD.CoreV1().Namespaces().Get(context.Background(), "bare", metav1.GetOptions{})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, namespace.Labels, map[string]string{"env": "prod"})
+	assert.Assert(t, namespace.Annotations == nil)
+}
@@ -0,0 +1,232 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+const (
+	// ingressClassAnnotation selects the networking layer of a service
+	ingressClassAnnotation = "networking.knative.dev/ingress.class"
+
+	// networkConfigNamespace and networkConfigName locate the networking configuration of Knative Serving
+	networkConfigNamespace = "knative-serving"
+	networkConfigName      = "config-network"
+)
+
+// networkingLayer is a Knative networking layer, its ingress class and the prefixes
+// of the annotations only it understands
+type networkingLayer struct {
+	class    string
+	prefixes []string
+}
+
+// networkingLayers is the built-in translation table of the supported networking layers
+var networkingLayers = map[string]networkingLayer{
+	"kourier": {
+		class:    "kourier.ingress.networking.knative.dev",
+		prefixes: []string{"kourier.knative.dev/"},
+	},
+	"istio": {
+		class:    "istio.ingress.networking.knative.dev",
+		prefixes: []string{"sidecar.istio.io/", "traffic.sidecar.istio.io/", "proxy.istio.io/", "networking.istio.io/"},
+	},
+	"contour": {
+		class:    "contour.ingress.networking.knative.dev",
+		prefixes: []string{"projectcontour.io/"},
+	},
+}
+
+// validateNetworkingLayer checks that a networking layer is supported, empty means unknown
+func validateNetworkingLayer(layer string) error {
+	if _, ok := networkingLayers[layer]; layer == "" || ok {
+		return nil
+	}
+	return fmt.Errorf("unsupported networking layer %q, supported layers are: contour, istio, kourier", layer)
+}
+
+// detectNetworkingLayer returns the networking layer configured in Knative Serving of a cluster,
+// or an empty string if it cannot be read or is not one of the supported layers
+func detectNetworkingLayer(ctx context.Context, clientSet kubernetes.Interface) string {
+	config, err := clientSet.CoreV1().ConfigMaps(networkConfigNamespace).Get(ctx, networkConfigName, metav1.GetOptions{})
+	if err != nil {
+		return ""
+	}
+	class := config.Data["ingress-class"]
+	if class == "" {
+		class = config.Data["ingress.class"]
+	}
+	for name, layer := range networkingLayers {
+		if class == layer.class {
+			return name
+		}
+	}
+	return ""
+}
+
+// setupNetworkingTranslation validates the networking layers of the options and loads the annotation mapping of the
+// transformation rules of the config file and of the mapping file
+func setupNetworkingTranslation(options *MigrationOptions, mappingFile string) error {
+	err := validateNetworkingLayer(options.SourceNetworking)
+	if err != nil {
+		return err
+	}
+	err = validateNetworkingLayer(options.DestinationNetworking)
+	if err != nil {
+		return err
+	}
+	transformations, err := loadTransformations()
+	if err != nil {
+		return err
+	}
+	mapping := map[string]string{}
+	for _, rule := range transformations.Annotations {
+		mapping[rule.From] = rule.To
+	}
+	// The mapping file of the command line overrides the rules of the config file
+	if mappingFile != "" {
+		fileMapping, err := parseAnnotationMapping(mappingFile)
+		if err != nil {
+			return err
+		}
+		for src, dst := range fileMapping {
+			mapping[src] = dst
+		}
+	}
+	if len(mapping) > 0 {
+		options.AnnotationMapping = mapping
+	}
+	return nil
+}
+
+// detectNetworkingLayers detects the networking layers of the options which were not given,
+// a nil client set leaves the layer of its cluster unknown
+func detectNetworkingLayers(ctx context.Context, options *MigrationOptions, clientSetS, clientSetD kubernetes.Interface) {
+	if options.SourceNetworking == "" && clientSetS != nil {
+		options.SourceNetworking = detectNetworkingLayer(ctx, clientSetS)
+	}
+	if options.DestinationNetworking == "" && clientSetD != nil {
+		options.DestinationNetworking = detectNetworkingLayer(ctx, clientSetD)
+	}
+}
+
+// parseAnnotationMapping reads a file of src-key=dst-key lines, an empty dst-key drops the annotation
+func parseAnnotationMapping(file string) (map[string]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	mapping := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		parts := strings.SplitN(text, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid annotation mapping %q at %s:%d, expected src-key=dst-key or src-key= to drop it", text, file, line)
+		}
+		mapping[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return mapping, nil
+}
+
+// translateAnnotations returns a copy of the annotations for the destination networking layer and the applied changes.
+// User mappings win over the built-in table, which rewrites the ingress class of the source layer and drops
+// the annotations only the source layer understands.
+func translateAnnotations(annotations map[string]string, source, destination string, mapping map[string]string) (map[string]string, []string) {
+	if annotations == nil {
+		return nil, nil
+	}
+	translate := source != "" && destination != "" && source != destination
+	translated := make(map[string]string, len(annotations))
+	changes := []string{}
+	for key, value := range annotations {
+		if target, ok := mapping[key]; ok {
+			if target == "" {
+				changes = append(changes, fmt.Sprintf("dropped %s", key))
+				continue
+			}
+			translated[target] = value
+			if target != key {
+				changes = append(changes, fmt.Sprintf("renamed %s to %s", key, target))
+			}
+			continue
+		}
+		if translate && key == ingressClassAnnotation && value == networkingLayers[source].class {
+			translated[key] = networkingLayers[destination].class
+			changes = append(changes, fmt.Sprintf("set %s to %s", key, translated[key]))
+			continue
+		}
+		if translate && hasAnyPrefix(key, networkingLayers[source].prefixes) {
+			changes = append(changes, fmt.Sprintf("dropped %s, not supported by %s", key, destination))
+			continue
+		}
+		translated[key] = value
+	}
+	sort.Strings(changes)
+	return translated, changes
+}
+
+func hasAnyPrefix(key string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// translateNetworkingAnnotations translates the annotations of a service, its template and its revisions
+// from the source to the destination networking layer of the options
+func translateNetworkingAnnotations(serviceS *serving_v1_api.Service, revisionsS *serving_v1_api.RevisionList, options *MigrationOptions) {
+	source, destination, mapping := options.SourceNetworking, options.DestinationNetworking, options.AnnotationMapping
+	if (source == "" || destination == "" || source == destination) && len(mapping) == 0 {
+		return
+	}
+	report := func(kind, name string, changes []string) {
+		for _, change := range changes {
+			fmt.Fprintln(options.out(), "Translated annotations of", kind, color.CyanString(name)+":", change)
+		}
+	}
+
+	var changes []string
+	serviceS.Annotations, changes = translateAnnotations(serviceS.Annotations, source, destination, mapping)
+	report("service", serviceS.Name, changes)
+	serviceS.Spec.Template.Annotations, changes = translateAnnotations(serviceS.Spec.Template.Annotations, source, destination, mapping)
+	report("the template of service", serviceS.Name, changes)
+	for i := range revisionsS.Items {
+		revision := &revisionsS.Items[i]
+		revision.Annotations, changes = translateAnnotations(revision.Annotations, source, destination, mapping)
+		report("revision", revision.Name, changes)
+	}
+}
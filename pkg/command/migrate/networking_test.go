@@ -0,0 +1,63 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"testing"
+
+	"gotest.tools/assert"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8s_fake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestTranslateAnnotations(t *testing.T) {
+	annotations := map[string]string{
+		ingressClassAnnotation:                          "kourier.ingress.networking.knative.dev",
+		"kourier.knative.dev/timeout":                   "30s",
+		"autoscaling.knative.dev/min-scale":             "1",
+		"example.com/team":                              "payments",
+		"sidecar.istio.io/inject":                       "false",
+		"traffic.sidecar.istio.io/excludeOutboundPorts": "443",
+	}
+	mapping := map[string]string{"example.com/team": "example.com/owner", "sidecar.istio.io/inject": ""}
+
+	translated, changes := translateAnnotations(annotations, "kourier", "istio", mapping)
+	assert.DeepEqual(t, translated, map[string]string{
+		ingressClassAnnotation:                          "istio.ingress.networking.knative.dev",
+		"autoscaling.knative.dev/min-scale":             "1",
+		"example.com/owner":                             "payments",
+		"traffic.sidecar.istio.io/excludeOutboundPorts": "443",
+	})
+	assert.Equal(t, len(changes), 4)
+	assert.Equal(t, annotations["kourier.knative.dev/timeout"], "30s")
+
+	translated, changes = translateAnnotations(annotations, "istio", "istio", nil)
+	assert.DeepEqual(t, translated, annotations)
+	assert.Equal(t, len(changes), 0)
+}
+
+func TestDetectNetworkingLayer(t *testing.T) {
+	clientSet := k8s_fake.NewSimpleClientset()
+	assert.Equal(t, detectNetworkingLayer(context.Background(), clientSet), "")
+
+	clientSet = k8s_fake.NewSimpleClientset(&apiv1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: networkConfigName, Namespace: networkConfigNamespace},
+		Data:       map[string]string{"ingress-class": "contour.ingress.networking.knative.dev"},
+	})
+	assert.Equal(t, detectNetworkingLayer(context.Background(), clientSet), "contour")
+	assert.ErrorContains(t, validateNetworkingLayer("nginx"), "unsupported networking layer")
+}
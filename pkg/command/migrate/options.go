@@ -0,0 +1,434 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"k8s.io/client-go/kubernetes"
+	"knative.dev/kn-plugin-migration/pkg/command"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+const (
+	// DefaultMaxRetries is the default number of retries of a failed API call
+	DefaultMaxRetries = 16
+	// DefaultMaxThrottledRetries is the default number of retries of a write throttled by the API server
+	DefaultMaxThrottledRetries = 16
+	// DefaultRetryBackoff is the default delay before the first retry, doubled with jitter for each next retry up
+	// to 1s, the default retries of a loop wait 13.5s plus jitter at most
+	DefaultRetryBackoff = 100 * time.Millisecond
+	// DefaultRevisionTimeout is the default maximum time to wait for a migrated revision to be Ready
+	DefaultRevisionTimeout = 2 * time.Minute
+	// DefaultWaitTimeout is the default maximum time to wait for a migrated service to be Ready with Wait,
+	// the 600 seconds of kn service create
+	DefaultWaitTimeout = 600 * time.Second
+)
+
+var (
+	// MaxGetRetries is the number of retries to get a resource created by the destination controllers.
+	//
+	// Deprecated: use MigrationOptions.MaxRetries, which NewMigrationOptions sets from this variable when it was changed.
+	MaxGetRetries = DefaultMaxRetries
+	// MaxUpdateRetries is the number of retries to update a resource after a conflict.
+	//
+	// Deprecated: use MigrationOptions.MaxRetries, which NewMigrationOptions sets from this variable when it was changed.
+	MaxUpdateRetries = DefaultMaxRetries
+)
+
+// MigrationOptions configures a migration, it is bound to the command line flags and used by every
+// migration function instead of package level state, so that concurrent migrations don't share it
+type MigrationOptions struct {
+	// Force replaces the services, configmaps and secrets already existing in the destination
+	Force bool
+	// ForceScope limits Force to some kinds of objects, every kind when empty
+	ForceScope ForceScope
+	// OnConflict tells what to do with the services already existing in the destination, overwrite with Force
+	// and fail otherwise when empty
+	OnConflict ConflictStrategy
+	// ForceRecreate deletes the services replaced by Force and creates them again instead of applying the migrated
+	// services over them, which drops the revisions existing only in the destination
+	ForceRecreate bool
+	// Concurrency is the number of services migrated in parallel
+	Concurrency int
+	// Pace is the maximum number of objects written to the destination per minute, zero means unlimited
+	Pace int
+	// BestEffort continues with the remaining services when a service fails to migrate
+	BestEffort bool
+	// MaxObjectSize is the maximum size in bytes of a serialized object accepted by the destination
+	MaxObjectSize int
+	// Revisions selects the source revisions of a service which are migrated, every revision when empty
+	Revisions RevisionSelection
+	// SkipBrokenRevisions leaves out the source revisions which are not Ready, unless the service needs them
+	SkipBrokenRevisions bool
+	// RevisionCollision tells what to do with revisions whose name is taken in the destination
+	RevisionCollision RevisionCollisionPolicy
+	// MaxRetries is the number of retries of an API call which failed because a resource is not created yet
+	// by the destination controllers or because of an update conflict
+	MaxRetries int
+	// MaxThrottledRetries is the number of retries of a write the API server throttled with 429 Too Many Requests,
+	// counted apart from MaxRetries since the pace slows down to let the server catch up
+	MaxThrottledRetries int
+	// RetryBackoff is the delay before the first retry, doubled with jitter for each next retry
+	RetryBackoff time.Duration
+	// RetryBudget is the total time the migration may spend waiting for retries, zero means unlimited
+	RetryBudget time.Duration
+	// RollbackOnFailure records every change made to the destination so that Rollback can undo them
+	RollbackOnFailure bool
+	// CheckpointFile records the migrated services, no checkpoint is written if empty
+	CheckpointFile string
+	// Resume skips the services recorded as migrated in the CheckpointFile by a previous run
+	Resume bool
+	// SourceNetworking and DestinationNetworking are the networking layers of the clusters, one of contour, istio, kourier,
+	// the networking annotations are translated between them when they differ
+	SourceNetworking      string
+	DestinationNetworking string
+	// AnnotationMapping renames annotations by key before the built-in networking translation, an empty value drops them
+	AnnotationMapping map[string]string
+	// MetadataRules rewrite the labels and annotations of the migrated services, revisions and configmaps
+	MetadataRules MetadataRules
+	// CopyNamespaceMetadata creates the destination namespaces with the labels and annotations of the source
+	// namespaces, e.g. istio-injection or the pod security labels, NamespaceLabels then sets the labels of its keys
+	// to their values and removes the labels of its keys with a nil value
+	CopyNamespaceMetadata bool
+	NamespaceLabels       map[string]*string
+	// ImageRewrites replace the registry prefixes of the container images of the migrated services and revisions
+	ImageRewrites []ImageRewrite
+	// PinDigests replaces the tags of the container images of the migrated services and revisions by the digests
+	// running in the source
+	PinDigests bool
+	// CopyImages copies the container images of the migrated services and revisions by digest to DestRegistry,
+	// e.g. registry.corp/ns, and refers to the copies in the destination
+	CopyImages   bool
+	DestRegistry string
+	// EnvOverrides set or remove environment variables of the migrated services and revisions
+	EnvOverrides EnvOverrides
+	// SourceCluster is the source cluster recorded on the migrated services, e.g. the URL of its API server
+	SourceCluster string
+	// RunID identifies the run on the objects it writes to the destination, generated when empty
+	RunID string
+	// Adopt overwrites the destination objects which were not written by the migration, which are refused otherwise
+	Adopt bool
+	// Renames migrates the services named by its keys under the names of its values in the destination
+	Renames map[string]string
+	// RevisionTimeout is the maximum time to wait for a migrated revision to be Ready before migrating the next one
+	RevisionTimeout time.Duration
+	// GroupBy is the label grouping the services of an application, which are migrated and verified together
+	// and rolled back together when one of them fails
+	GroupBy string
+	// GroupTimeout is the maximum time to wait for the services of an application to be Ready with GroupBy
+	GroupTimeout time.Duration
+	// RegenerateRevisions recreates none of the source revisions, the destination generates the revisions of the
+	// migrated services from their template and their traffic routes to the latest revision
+	RegenerateRevisions bool
+	// LatestOnly regenerates the revisions like RegenerateRevisions and waits for the single revision the
+	// destination generates for each migrated service to be Ready
+	LatestOnly bool
+	// PreserveRevisionHistory annotates the migrated revisions with their creation timestamp and generation in the source
+	PreserveRevisionHistory bool
+	// Verify waits for every migrated service to be Ready and requests its URL, a service which does not answer
+	// with a success status within VerifyTimeout fails
+	Verify        bool
+	VerifyTimeout time.Duration
+	// Wait waits for every migrated service to be Ready before the worker migrates its next service, like
+	// kn service create, a service which is not Ready within WaitTimeout fails
+	Wait        bool
+	WaitTimeout time.Duration
+	// InjectFailures randomly fails writes to the destination to rehearse the recovery of a partial failure
+	InjectFailures FailureInjection
+	// CopyClaimData copies the data of the persistent volume claims created in the destination with rsync over SSH,
+	// run with ClaimCopyImage, exposed as told by ClaimCopyExpose and failing after ClaimCopyTimeout
+	CopyClaimData    bool
+	ClaimCopyImage   string
+	ClaimCopyExpose  ClaimCopyExposure
+	ClaimCopyTimeout time.Duration
+	// Hooks are called around the migration of every service
+	Hooks ServiceHooks
+	// ResourceHandlers migrate the objects of every service by kind, the built-in handlers when nil
+	ResourceHandlers *ResourceHandlers
+	// Out receives the progress messages and warnings of the migration, os.Stdout when nil and nothing with
+	// io.Discard
+	Out io.Writer
+
+	// mu guards the lazily created state below, shared by the workers migrating services in parallel
+	mu          sync.Mutex
+	budget      *retryBudget
+	pacer       *pacer
+	sourcePacer *pacer
+	journal     *rollbackJournal
+	checkpoint  *checkpoint
+	warned      []string
+	// copiedImages are the references in DestRegistry of the images already copied, by source image
+	copiedImages map[string]string
+	// resolvedDigests are the digests the tags resolved to in the source registries with PinDigests, by image
+	resolvedDigests map[string]string
+	// dashboard is the live progress of the run served over HTTP, nil unless the command serves it
+	dashboard *dashboard
+}
+
+// ServiceHooks are called by the workers around the migration of every service, possibly concurrently with
+// Concurrency above 1. The hooks must not modify the service they are given.
+type ServiceHooks struct {
+	// Before is called before a service is migrated, an error fails the service without migrating it
+	Before func(ctx context.Context, service *serving_v1_api.Service) error
+	// After is called once a service is migrated, or failed with err, and an error it returns fails the service
+	After func(ctx context.Context, service *serving_v1_api.Service, err error) error
+}
+
+// before calls the Before hook, if any
+func (h ServiceHooks) before(ctx context.Context, service *serving_v1_api.Service) error {
+	if h.Before == nil {
+		return nil
+	}
+	if err := h.Before(ctx, service); err != nil {
+		return fmt.Errorf("the hook before service %s failed: %w", service.Name, err)
+	}
+	return nil
+}
+
+// after calls the After hook, if any, and returns the error of the migration of the service or of the hook
+func (h ServiceHooks) after(ctx context.Context, service *serving_v1_api.Service, err error) error {
+	if h.After == nil {
+		return err
+	}
+	if hookErr := h.After(ctx, service, err); hookErr != nil && err == nil {
+		return fmt.Errorf("the hook after service %s failed: %w", service.Name, hookErr)
+	}
+	return err
+}
+
+// deprecatedMaxRetries returns the retries set by the deprecated MaxGetRetries and MaxUpdateRetries, the larger
+// of them when both were changed, and DefaultMaxRetries when none was
+func deprecatedMaxRetries() int {
+	retries, changed := DefaultMaxRetries, false
+	for _, value := range []int{MaxGetRetries, MaxUpdateRetries} {
+		if value != DefaultMaxRetries && (!changed || value > retries) {
+			retries, changed = value, true
+		}
+	}
+	return retries
+}
+
+// NewMigrationOptions returns the options with their default values
+func NewMigrationOptions() *MigrationOptions {
+	return &MigrationOptions{
+		Concurrency:           1,
+		CopyNamespaceMetadata: true,
+		MaxObjectSize:         defaultMaxObjectSize,
+		RevisionCollision:     RevisionCollisionFail,
+		MaxRetries:            deprecatedMaxRetries(),
+		MaxThrottledRetries:   DefaultMaxThrottledRetries,
+		RetryBackoff:          DefaultRetryBackoff,
+		RevisionTimeout:       DefaultRevisionTimeout,
+		GroupTimeout:          DefaultGroupTimeout,
+		VerifyTimeout:         DefaultVerifyTimeout,
+		WaitTimeout:           DefaultWaitTimeout,
+		ClaimCopyExpose:       ClaimCopyExposeInternal,
+		ClaimCopyTimeout:      DefaultClaimCopyTimeout,
+		ResourceHandlers:      NewResourceHandlers(),
+	}
+}
+
+// resourceHandlers returns the handlers migrating the objects of every service
+func (o *MigrationOptions) resourceHandlers() *ResourceHandlers {
+	if o.ResourceHandlers == nil {
+		return NewResourceHandlers()
+	}
+	return o.ResourceHandlers
+}
+
+// forces returns true if the objects of a kind already existing in the destination are replaced
+func (o *MigrationOptions) forces(kind string) bool {
+	return o.Force && o.ForceScope.includes(kind)
+}
+
+// onConflict returns what to do with the services already existing in the destination
+func (o *MigrationOptions) onConflict() ConflictStrategy {
+	switch {
+	case o.OnConflict != "":
+		return o.OnConflict
+	case o.forces(ForceServices):
+		return ConflictOverwrite
+	default:
+		return ConflictFail
+	}
+}
+
+// recreates returns true if the services already existing in the destination are deleted and created again
+// instead of having the migrated services applied over them
+func (o *MigrationOptions) recreates() bool {
+	return o.onConflict() == ConflictOverwrite && o.ForceRecreate
+}
+
+// replacing returns true if the migration may replace objects already existing in the destination
+func (o *MigrationOptions) replacing() bool {
+	return o.Force || o.onConflict().replaces()
+}
+
+// destinationName returns the name of a source service in the destination
+func (o *MigrationOptions) destinationName(name string) string {
+	if renamed, ok := o.Renames[name]; ok {
+		return renamed
+	}
+	return name
+}
+
+// wait sleeps for d before a retry of what, charged to the retry budget of the options
+func (o *MigrationOptions) wait(ctx context.Context, d time.Duration, what string) error {
+	o.mu.Lock()
+	if o.budget == nil {
+		o.budget = newRetryBudget(o.RetryBudget)
+	}
+	budget := o.budget
+	o.mu.Unlock()
+	return budget.wait(ctx, d, what)
+}
+
+// changes returns the journal of the changes made to the destination, nil without RollbackOnFailure or GroupBy
+func (o *MigrationOptions) changes() *rollbackJournal {
+	if !o.RollbackOnFailure && o.GroupBy == "" {
+		return nil
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.journal == nil {
+		o.journal = &rollbackJournal{}
+	}
+	return o.journal
+}
+
+// warn prints a warning and records it for the report of the run
+func (o *MigrationOptions) warn(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	fmt.Fprintln(o.out(), color.YellowString(message))
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.warned = append(o.warned, message)
+}
+
+// out returns the writer of the progress messages
+func (o *MigrationOptions) out() io.Writer {
+	if o.Out == nil {
+		return os.Stdout
+	}
+	return o.Out
+}
+
+// warnings returns the warnings printed during the run
+func (o *MigrationOptions) warnings() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]string{}, o.warned...)
+}
+
+// progress returns the checkpoint of the migration, nil without CheckpointFile
+func (o *MigrationOptions) progress() (*checkpoint, error) {
+	if o.CheckpointFile == "" {
+		return nil, nil
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.checkpoint == nil {
+		checkpoint, err := loadCheckpoint(o.CheckpointFile, o.Resume)
+		if err != nil {
+			return nil, err
+		}
+		o.checkpoint = checkpoint
+	}
+	return o.checkpoint, nil
+}
+
+// startDestination prepares the options for the next destination cluster of a fan-out: its writes are paced
+// on their own and its progress is recorded to its own checkpoint file. The rollback journal and the warnings
+// are kept for the whole run.
+func (o *MigrationOptions) startDestination(checkpointFile string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.CheckpointFile = checkpointFile
+	o.checkpoint = nil
+	o.pacer = nil
+}
+
+// Rollback undoes the changes made to the destination by the migrations run with these options,
+// it does nothing without RollbackOnFailure
+func (o *MigrationOptions) Rollback(ctx context.Context) error {
+	o.mu.Lock()
+	journal := o.journal
+	o.mu.Unlock()
+	if !o.RollbackOnFailure || journal == nil {
+		return nil
+	}
+	return journal.rollback(ctx, o.out())
+}
+
+// rollbackFailure undoes the changes of a run which failed with err with RollbackOnFailure and records it in the
+// report. An interrupted run is not rolled back, it is resumed with Resume instead.
+func (o *MigrationOptions) rollbackFailure(ctx context.Context, report *MigrationReport, err error) {
+	if err == nil || ctx.Err() != nil || !o.RollbackOnFailure {
+		return
+	}
+	if rollbackErr := o.Rollback(context.Background()); rollbackErr != nil {
+		o.warn("Failed to roll back the migration: %v", rollbackErr)
+		return
+	}
+	report.RolledBack = true
+}
+
+// MigrateNamespace migrates the services of the source namespace of migrationClientS selected by the names or glob patterns
+// and the label selector to namespaceD, and returns the names of the migrated services.
+// No API call is issued anymore once the context is done.
+// Errors can be matched with errors.Is against ErrServiceExists, ErrSourceUnreachable and ErrQuotaExceeded.
+func MigrateNamespace(ctx context.Context, clientSetS kubernetes.Interface, migrationClientS command.MigrationClient, namespaceS string, clientSetD kubernetes.Interface, migrationClientD command.MigrationClient, namespaceD string, services []string, selector string, options *MigrationOptions) ([]string, error) {
+	filter, err := newServiceFilter(services, selector)
+	if err != nil {
+		return nil, err
+	}
+	source := newLiveSource(clientSetS, migrationClientS, namespaceS)
+	return migrateNamespace(ctx, source, clientSetD, migrationClientD, namespaceD, filter, options, newMigrationReport().namespace(namespaceS, namespaceD))
+}
+
+// ServiceSelection selects the services of a namespace by names or glob patterns and label selector,
+// without the services matching the exclusions
+type ServiceSelection struct {
+	Services        []string
+	Selector        string
+	Exclude         []string
+	ExcludeSelector string
+}
+
+// MigrateNamespaceWithReport migrates the selected services of namespaceS to namespaceD like MigrateNamespace
+// and returns the report of the run, which holds the services which failed with BestEffort.
+// A failed migration is rolled back with RollbackOnFailure, unless it failed because the context is done.
+// The progress messages are written to options.Out, an embedder silences them with io.Discard.
+func MigrateNamespaceWithReport(ctx context.Context, clientSetS kubernetes.Interface, migrationClientS command.MigrationClient, namespaceS string, clientSetD kubernetes.Interface, migrationClientD command.MigrationClient, namespaceD string, selection ServiceSelection, options *MigrationOptions) (*MigrationReport, error) {
+	report := newMigrationReport()
+	filter, err := newServiceFilter(selection.Services, selection.Selector)
+	if err == nil {
+		err = filter.exclude(selection.Exclude, selection.ExcludeSelector)
+	}
+	if err != nil {
+		return report, report.conclude(ctx, err, options)
+	}
+	source := newLiveSource(clientSetS, migrationClientS, namespaceS)
+	_, err = migrateNamespace(ctx, source, clientSetD, migrationClientD, namespaceD, filter, options, report.namespace(namespaceS, namespaceD))
+	options.rollbackFailure(ctx, report, err)
+	return report, report.conclude(ctx, err, options)
+}
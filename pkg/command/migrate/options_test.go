@@ -0,0 +1,86 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"gotest.tools/assert"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8s_fake "k8s.io/client-go/kubernetes/fake"
+	"knative.dev/kn-plugin-migration/pkg/command"
+	serving_fake "knative.dev/serving/pkg/client/clientset/versioned/fake"
+)
+
+// newBundleClients returns the clients of a source cluster holding the services and revisions of a bundle
+func newBundleClients(bundle *bundleSource) (*k8s_fake.Clientset, command.MigrationClient) {
+	objects := []runtime.Object{}
+	for i := range bundle.services {
+		service := &bundle.services[i]
+		objects = append(objects, service)
+		for j := range bundle.revisions[service.Name] {
+			objects = append(objects, &bundle.revisions[service.Name][j])
+		}
+	}
+	return k8s_fake.NewSimpleClientset(), command.NewMigrationClient(serving_fake.NewSimpleClientset(objects...).ServingV1(), bundle.namespace)
+}
+
+func TestMigrateNamespaceWithReport(t *testing.T) {
+	clientSetS, migrationClientS := newBundleClients(simulatedBundle("default", "bye", "hello"))
+	clientSetD, migrationClientD := newSimulatedDestination("prod", &bundleSource{})
+	options := NewMigrationOptions()
+	var progress bytes.Buffer
+	options.Out = &progress
+
+	report, err := MigrateNamespaceWithReport(context.Background(), clientSetS, migrationClientS, "default", clientSetD, migrationClientD, "prod", ServiceSelection{Exclude: []string{"bye"}}, options)
+	assert.NilError(t, err)
+	assert.Assert(t, report.Succeeded)
+	assert.Equal(t, report.Namespaces[0].totals().Migrated, 1)
+	assert.Assert(t, progress.Len() > 0)
+}
+
+func TestMigrateNamespaceWithReportRollback(t *testing.T) {
+	clientSetS, migrationClientS := newBundleClients(simulatedBundle("default", "bye", "hello"))
+	// The existing destination service fails the migration of bye
+	clientSetD, migrationClientD := newSimulatedDestination("prod", simulatedBundle("prod", "hello"))
+	options := NewMigrationOptions()
+	options.RollbackOnFailure = true
+	options.Out = &bytes.Buffer{}
+
+	report, err := MigrateNamespaceWithReport(context.Background(), clientSetS, migrationClientS, "default", clientSetD, migrationClientD, "prod", ServiceSelection{}, options)
+	assert.ErrorContains(t, err, "")
+	assert.Assert(t, !report.Succeeded)
+	assert.Assert(t, report.RolledBack)
+	assert.Equal(t, report.Error, err.Error())
+	// The service migrated before the failure is deleted again
+	_, err = migrationClientD.GetService(context.Background(), "bye")
+	assert.ErrorContains(t, err, "not found")
+}
+
+func TestNewMigrationOptionsDeprecatedRetries(t *testing.T) {
+	defer func(get, update int) {
+		MaxGetRetries, MaxUpdateRetries = get, update
+	}(MaxGetRetries, MaxUpdateRetries)
+
+	assert.Equal(t, NewMigrationOptions().MaxRetries, DefaultMaxRetries)
+	MaxGetRetries = 3
+	assert.Equal(t, NewMigrationOptions().MaxRetries, 3)
+	MaxUpdateRetries = 5
+	assert.Equal(t, NewMigrationOptions().MaxRetries, 5)
+	MaxGetRetries, MaxUpdateRetries = DefaultMaxRetries, 30
+	assert.Equal(t, NewMigrationOptions().MaxRetries, 30)
+}
@@ -0,0 +1,133 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/fatih/color"
+	"knative.dev/kn-plugin-migration/pkg/command"
+)
+
+// ownerSummaryEvent is the type of the CloudEvent sent to the owner of migrated services, with an owner extension
+const ownerSummaryEvent = "dev.knative.migration.owner.summary"
+
+// serviceOwnership is the owner and the URL of a source service, read before the migration deletes it
+type serviceOwnership struct {
+	Owner string
+	URL   string
+}
+
+// ownedService is a service of an owner summary
+type ownedService struct {
+	SourceNamespace      string        `json:"sourceNamespace"`
+	DestinationNamespace string        `json:"destinationNamespace"`
+	Name                 string        `json:"name"`
+	Status               ServiceStatus `json:"status"`
+	URL                  string        `json:"url,omitempty"`
+	PreviousURL          string        `json:"previousUrl,omitempty"`
+	// ActionRequired tells the owner what to do after the migration, empty if nothing
+	ActionRequired string `json:"actionRequired,omitempty"`
+}
+
+// ownerSummary lists the services of an owner with their outcome
+type ownerSummary struct {
+	Owner    string         `json:"owner"`
+	Services []ownedService `json:"services"`
+}
+
+// lookupOwners returns the ownership of the services of a source with the owner annotation, by service name
+func lookupOwners(ctx context.Context, source migrationSource, filter *serviceFilter, annotation string) (map[string]serviceOwnership, error) {
+	owners := map[string]serviceOwnership{}
+	services, err := source.ListServices(ctx, filter)
+	if err != nil {
+		return nil, sourceError(err)
+	}
+	for _, service := range services.Items {
+		owner := service.Annotations[annotation]
+		if owner == "" {
+			continue
+		}
+		owners[service.Name] = serviceOwnership{Owner: owner, URL: service.Status.URL.String()}
+	}
+	return owners, nil
+}
+
+// ownerSummaries groups the services of the report by owner, with their URL in the destination and the action
+// required from their owner. owners holds the ownership of the services by source namespace, services without an
+// owner are left out.
+func ownerSummaries(ctx context.Context, report *MigrationReport, owners map[string]map[string]serviceOwnership, destination func(namespace string) command.MigrationClient) []ownerSummary {
+	byOwner := map[string]*ownerSummary{}
+	for _, namespace := range report.Namespaces {
+		for _, serviceReport := range namespace.Services {
+			ownership, ok := owners[namespace.SourceNamespace][serviceReport.Name]
+			if !ok {
+				continue
+			}
+			service := ownedService{
+				SourceNamespace:      namespace.SourceNamespace,
+				DestinationNamespace: namespace.DestinationNamespace,
+				Name:                 serviceReport.Name,
+				Status:               serviceReport.Status,
+				PreviousURL:          ownership.URL,
+			}
+			if serviceReport.Status != ServiceStatusFailed {
+				if serviceD, err := destination(namespace.DestinationNamespace).GetService(ctx, serviceReport.destination()); err == nil {
+					service.URL = serviceD.Status.URL.String()
+				}
+			}
+			switch {
+			case serviceReport.Status == ServiceStatusFailed:
+				service.ActionRequired = "Fix the failure and migrate the service again: " + serviceReport.Error
+			case serviceReport.Status == ServiceStatusMigrated && service.URL == "":
+				service.ActionRequired = "Check the service in the destination cluster, it has no URL yet"
+			case serviceReport.Status == ServiceStatusMigrated && service.URL != service.PreviousURL && service.PreviousURL != "":
+				service.ActionRequired = fmt.Sprintf("Update the clients of %s to %s", service.PreviousURL, service.URL)
+			}
+
+			summary, ok := byOwner[ownership.Owner]
+			if !ok {
+				summary = &ownerSummary{Owner: ownership.Owner, Services: []ownedService{}}
+				byOwner[ownership.Owner] = summary
+			}
+			summary.Services = append(summary.Services, service)
+		}
+	}
+
+	summaries := []ownerSummary{}
+	for _, summary := range byOwner {
+		summaries = append(summaries, *summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].Owner < summaries[j].Owner
+	})
+	return summaries
+}
+
+// notifyOwners sends the summary of every owner to the sink as a CloudEvent, with the owner in its owner extension
+// so that a Trigger can route it to the owner, e.g. by email or chat. A failure to send a summary only prints a warning.
+func notifyOwners(out io.Writer, sink string, report *MigrationReport, summaries []ownerSummary) {
+	for _, summary := range summaries {
+		err := sendEvent(context.Background(), sink, ownerSummaryEvent, report.FinishedAt.Time, map[string]string{"owner": summary.Owner}, summary)
+		if err != nil {
+			fmt.Fprintln(out, color.YellowString(err.Error()))
+			continue
+		}
+		fmt.Fprintln(out, "Sent the summary of the", len(summary.Services), "service(s) of", color.CyanString(summary.Owner), "to", color.CyanString(sink))
+	}
+}
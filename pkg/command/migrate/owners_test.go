@@ -0,0 +1,94 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gotest.tools/assert"
+	k8s_fake "k8s.io/client-go/kubernetes/fake"
+	"knative.dev/kn-plugin-migration/pkg/command"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+	serving_fake "knative.dev/serving/pkg/client/clientset/versioned/fake"
+)
+
+const ownerAnnotation = "example.com/owner"
+
+func newOwnedService(name, url, owner string) *serving_v1_api.Service {
+	service := newReadyService(name, url, nil)
+	if owner != "" {
+		service.Annotations = map[string]string{ownerAnnotation: owner}
+	}
+	return service
+}
+
+func TestOwnerSummaries(t *testing.T) {
+	servingClientS := serving_fake.NewSimpleClientset(
+		newOwnedService("checkout", "http://checkout.default.old.example.com", "payments@example.com"),
+		newOwnedService("invoice", "http://invoice.default.old.example.com", "payments@example.com"),
+		newOwnedService("frontend", "http://frontend.default.old.example.com", "web@example.com"),
+		newOwnedService("orphan", "http://orphan.default.old.example.com", ""),
+	)
+	source := newLiveSource(k8s_fake.NewSimpleClientset(), command.NewMigrationClient(servingClientS.ServingV1(), "default"), "default")
+	allServices, _ := newServiceFilter(nil, "")
+	owners, err := lookupOwners(context.Background(), source, allServices, ownerAnnotation)
+	assert.NilError(t, err)
+	assert.Equal(t, len(owners), 3)
+
+	servingClientD := serving_fake.NewSimpleClientset(
+		newOwnedService("checkout", "http://checkout.default.new.example.com", "payments@example.com"),
+		newOwnedService("frontend", "http://frontend.default.new.example.com", "web@example.com"),
+		newOwnedService("orphan", "http://orphan.default.new.example.com", ""),
+	)
+	report := newMigrationReport()
+	namespace := report.namespace("default", "default")
+	namespace.add("checkout", nil, nil, 0, nil)
+	namespace.add("invoice", nil, nil, 0, errors.New("quota exceeded"))
+	namespace.add("frontend", nil, nil, 0, nil)
+	namespace.add("orphan", nil, nil, 0, nil)
+	report.finish(nil)
+
+	summaries := ownerSummaries(context.Background(), report, map[string]map[string]serviceOwnership{"default": owners}, func(namespace string) command.MigrationClient {
+		return command.NewMigrationClient(servingClientD.ServingV1(), namespace)
+	})
+	assert.Equal(t, len(summaries), 2)
+	assert.Equal(t, summaries[0].Owner, "payments@example.com")
+	assert.Equal(t, len(summaries[0].Services), 2)
+	assert.Equal(t, summaries[0].Services[0].URL, "http://checkout.default.new.example.com")
+	assert.Equal(t, summaries[0].Services[0].ActionRequired, "Update the clients of http://checkout.default.old.example.com to http://checkout.default.new.example.com")
+	assert.Equal(t, summaries[0].Services[1].Status, ServiceStatusFailed)
+	assert.Equal(t, summaries[0].Services[1].ActionRequired, "Fix the failure and migrate the service again: quota exceeded")
+	assert.Equal(t, summaries[1].Owner, "web@example.com")
+
+	notified := []string{}
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.Header.Get("Ce-Type"), ownerSummaryEvent)
+		data, _ := ioutil.ReadAll(r.Body)
+		summary := ownerSummary{}
+		assert.NilError(t, json.Unmarshal(data, &summary))
+		assert.Equal(t, summary.Owner, r.Header.Get("Ce-Owner"))
+		notified = append(notified, summary.Owner)
+	}))
+	defer sink.Close()
+	notifyOwners(io.Discard, sink.URL, report, summaries)
+	assert.DeepEqual(t, notified, []string{"payments@example.com", "web@example.com"})
+}
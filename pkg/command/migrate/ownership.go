@@ -0,0 +1,86 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// managedByAnnotation marks the destination objects written by the migration, the objects without it are
+	// managed by someone else and are not overwritten without --adopt
+	managedByAnnotation = "migration.knative.dev/managed-by"
+	// sourceClusterAnnotation records the source cluster an object was migrated from
+	sourceClusterAnnotation = "migration.knative.dev/source-cluster"
+	// runIDAnnotation records the run which last wrote an object
+	runIDAnnotation = "migration.knative.dev/run-id"
+)
+
+// runID returns the ID of the run recorded on the objects it writes, generated on first use unless it is set
+func (o *MigrationOptions) runID() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.RunID == "" {
+		suffix := make([]byte, 4)
+		_, _ = rand.Read(suffix)
+		o.RunID = time.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix)
+	}
+	return o.RunID
+}
+
+// withOwnership returns a copy of the annotations of an object written to the destination with the annotations
+// identifying the migration, the source cluster and the run, nil options leave the annotations as they are
+func (o *MigrationOptions) withOwnership(annotations map[string]string) map[string]string {
+	if o == nil {
+		return annotations
+	}
+	stamped := make(map[string]string, len(annotations)+3)
+	for key, value := range annotations {
+		stamped[key] = value
+	}
+	stamped[managedByAnnotation] = migrationComponent
+	stamped[runIDAnnotation] = o.runID()
+	delete(stamped, sourceClusterAnnotation)
+	if o.SourceCluster != "" {
+		stamped[sourceClusterAnnotation] = o.SourceCluster
+	}
+	return stamped
+}
+
+// stampOwnership records the ownership annotations on the metadata of an object written to the destination
+func (o *MigrationOptions) stampOwnership(meta *metav1.ObjectMeta) {
+	meta.Annotations = o.withOwnership(meta.Annotations)
+}
+
+// managed returns true if an existing destination object was written by the migration, services migrated before
+// the ownership annotations existed are recognized by their provenance
+func managed(annotations map[string]string) bool {
+	return annotations[managedByAnnotation] == migrationComponent || annotations[migratedFromAnnotation] != ""
+}
+
+// checkOwnership fails when an existing destination object about to be overwritten was not written by the
+// migration, unless it is adopted. Nil options check nothing.
+func (o *MigrationOptions) checkOwnership(kind, name string, annotations map[string]string) error {
+	if o == nil || o.Adopt || managed(annotations) {
+		return nil
+	}
+	return fmt.Errorf("cannot migrate %s %s: %w, use --adopt to overwrite it", strings.ToLower(kind), name, ErrNotManaged)
+}
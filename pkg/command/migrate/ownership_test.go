@@ -0,0 +1,82 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestOwnershipAnnotations(t *testing.T) {
+	options := NewMigrationOptions()
+	options.SourceCluster = "https://source:6443"
+	runID := options.runID()
+	assert.Assert(t, runID != "")
+	assert.Equal(t, options.runID(), runID)
+
+	source := simulatedBundle("default", "hello")
+	clientSetD, migrationClientD := newSimulatedDestination("prod", &bundleSource{})
+	_, _, err := migrateService(context.Background(), source, clientSetD, migrationClientD, "prod", source.services[0], options)
+	assert.NilError(t, err)
+	serviceD, err := migrationClientD.GetService(context.Background(), "hello")
+	assert.NilError(t, err)
+	assert.Equal(t, serviceD.Annotations[managedByAnnotation], migrationComponent)
+	assert.Equal(t, serviceD.Annotations[sourceClusterAnnotation], "https://source:6443")
+	assert.Equal(t, serviceD.Annotations[runIDAnnotation], runID)
+
+	// A service written by a previous migration is overwritten by the next run
+	options = NewMigrationOptions()
+	options.Force = true
+	_, _, err = migrateService(context.Background(), source, clientSetD, migrationClientD, "prod", source.services[0], options)
+	assert.NilError(t, err)
+	serviceD, err = migrationClientD.GetService(context.Background(), "hello")
+	assert.NilError(t, err)
+	assert.Equal(t, serviceD.Annotations[runIDAnnotation], options.runID())
+}
+
+func TestOwnershipRefusesUnmanagedObjects(t *testing.T) {
+	filter, err := newServiceFilter(nil, "")
+	assert.NilError(t, err)
+	source := simulatedBundle("default", "hello")
+	clientSetD, migrationClientD := newSimulatedDestination("prod", destinationWithExtraRevision())
+	options := NewMigrationOptions()
+	options.Force = true
+
+	plan, err := buildPlan(context.Background(), source, clientSetD, migrationClientD, "prod", filter, options, false)
+	assert.NilError(t, err)
+	for _, entry := range plan.Entries {
+		if entry.Kind == "Service" && entry.Cluster == "destination" {
+			assert.Equal(t, entry.Action, planActionConflict)
+			assert.Equal(t, entry.Reason, "already exists and is not managed by kn-migration, use --adopt to overwrite it")
+		}
+	}
+
+	// The service created by someone else is kept as it is
+	_, _, err = migrateService(context.Background(), source, clientSetD, migrationClientD, "prod", source.services[0], options)
+	assert.Assert(t, errors.Is(err, ErrNotManaged))
+	serviceD, err := migrationClientD.GetService(context.Background(), "hello")
+	assert.NilError(t, err)
+	assert.Equal(t, serviceD.Annotations[managedByAnnotation], "")
+
+	options.Adopt = true
+	_, _, err = migrateService(context.Background(), source, clientSetD, migrationClientD, "prod", source.services[0], options)
+	assert.NilError(t, err)
+	serviceD, err = migrationClientD.GetService(context.Background(), "hello")
+	assert.NilError(t, err)
+	assert.Equal(t, serviceD.Annotations[managedByAnnotation], migrationComponent)
+}
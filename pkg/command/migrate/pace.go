@@ -0,0 +1,168 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	// throttledInterval is the interval between two writes once the destination rejected a write
+	// without a configured pace, i.e. 60 objects per minute
+	throttledInterval = time.Second
+	// maxPaceInterval is the slowest pace the automatic pacing slows down to
+	maxPaceInterval = time.Minute
+	// paceRecoveryWrites is the number of successful writes after which the automatic pacing speeds up again
+	paceRecoveryWrites = 20
+)
+
+// pacer spaces the writes to a cluster so that a mass migration stays below the configured number of objects
+// per minute, and slows down when the API server priority and fairness rejects writes
+type pacer struct {
+	mu sync.Mutex
+	// cluster names the cluster written to in the messages, source or destination
+	cluster string
+	// base is the interval of the configured pace, zero means unlimited
+	base time.Duration
+	// interval is the current interval, slowed down from base after rejections
+	interval  time.Duration
+	next      time.Time
+	successes int
+}
+
+// newPacer creates a pacer of the writes to a cluster of the given objects per minute, zero means unlimited
+func newPacer(cluster string, perMinute int) *pacer {
+	p := &pacer{cluster: cluster}
+	if perMinute > 0 {
+		p.base = time.Minute / time.Duration(perMinute)
+	}
+	p.interval = p.base
+	return p
+}
+
+// take waits for the next write slot, or returns the error of the context once it is done
+func (p *pacer) take(ctx context.Context) error {
+	p.mu.Lock()
+	now := time.Now()
+	slot := p.next
+	if slot.Before(now) {
+		slot = now
+	}
+	p.next = slot.Add(p.interval)
+	p.mu.Unlock()
+
+	return sleep(ctx, time.Until(slot))
+}
+
+// throttled halves the pace after a rejected write
+func (p *pacer) throttled(out io.Writer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.successes = 0
+	if p.interval == 0 {
+		p.interval = throttledInterval
+	} else if p.interval < maxPaceInterval {
+		p.interval *= 2
+		if p.interval > maxPaceInterval {
+			p.interval = maxPaceInterval
+		}
+	}
+	fmt.Fprintln(out, color.YellowString("The %s API server is throttling writes, slowing down to %.1f object(s) per minute", p.cluster, float64(time.Minute)/float64(p.interval)))
+}
+
+// succeeded speeds the pace up again toward the configured pace after enough successful writes
+func (p *pacer) succeeded() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.interval == p.base {
+		return
+	}
+	p.successes++
+	if p.successes < paceRecoveryWrites {
+		return
+	}
+	p.successes = 0
+	p.interval /= 2
+	if p.interval < p.base || p.interval < throttledInterval {
+		p.interval = p.base
+	}
+}
+
+// paced runs a write to the destination in its pace slot, writes rejected with 429 Too Many Requests
+// slow the pace down and are retried after the delay suggested by the API server. With InjectFailures
+// the write may fail on purpose before reaching the destination.
+func (o *MigrationOptions) paced(ctx context.Context, what string, write func() error) error {
+	o.mu.Lock()
+	if o.pacer == nil {
+		o.pacer = newPacer("destination", o.Pace)
+	}
+	pacer := o.pacer
+	o.mu.Unlock()
+
+	return o.pacedBy(ctx, pacer, what, func() error {
+		if err := o.InjectFailures.fail(what); err != nil {
+			return err
+		}
+		return write()
+	})
+}
+
+// sourcePaced runs a write to the source, i.e. the deletion of a migrated service, in its pace slot. The source
+// is paced separately from the destination, its API server throttles its own clients.
+func (o *MigrationOptions) sourcePaced(ctx context.Context, what string, write func() error) error {
+	o.mu.Lock()
+	if o.sourcePacer == nil {
+		o.sourcePacer = newPacer("source", o.Pace)
+	}
+	pacer := o.sourcePacer
+	o.mu.Unlock()
+
+	return o.pacedBy(ctx, pacer, what, write)
+}
+
+func (o *MigrationOptions) pacedBy(ctx context.Context, pacer *pacer, what string, write func() error) error {
+	retries := 0
+	backoff := newRetryBackoff(o.RetryBackoff)
+	for {
+		err := pacer.take(ctx)
+		if err != nil {
+			return err
+		}
+		err = write()
+		if !api_errors.IsTooManyRequests(err) || retries >= o.MaxThrottledRetries {
+			if err == nil {
+				pacer.succeeded()
+			}
+			return err
+		}
+		pacer.throttled(o.out())
+		retries++
+		delay := backoff.Step()
+		if seconds, ok := api_errors.SuggestsClientDelay(err); ok && seconds > 0 {
+			delay = time.Duration(seconds) * time.Second
+		}
+		fmt.Fprintf(o.out(), "retry to %s after %s(try#: %d)\n", what, delay.Round(time.Millisecond), retries)
+		if err := o.wait(ctx, delay, what); err != nil {
+			return err
+		}
+	}
+}
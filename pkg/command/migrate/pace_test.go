@@ -0,0 +1,87 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+func TestPacer(t *testing.T) {
+	p := newPacer("destination", 120)
+	assert.Equal(t, p.interval, 500*time.Millisecond)
+	p.throttled(io.Discard)
+	p.throttled(io.Discard)
+	assert.Equal(t, p.interval, 2*time.Second)
+	for i := 0; i < paceRecoveryWrites; i++ {
+		p.succeeded()
+	}
+	assert.Equal(t, p.interval, time.Second)
+	for i := 0; i < paceRecoveryWrites; i++ {
+		p.succeeded()
+	}
+	assert.Equal(t, p.interval, 500*time.Millisecond)
+
+	p = newPacer("destination", 0)
+	p.throttled(io.Discard)
+	assert.Equal(t, p.interval, throttledInterval)
+	for i := 0; i < paceRecoveryWrites; i++ {
+		p.succeeded()
+	}
+	assert.Equal(t, p.interval, time.Duration(0))
+}
+
+func TestPacedThrottled(t *testing.T) {
+	options := NewMigrationOptions()
+	options.RetryBudget = time.Nanosecond
+	writes := 0
+	err := options.paced(context.Background(), "create service hello", func() error {
+		writes++
+		return api_errors.NewTooManyRequests("too many requests", 0)
+	})
+	assert.Assert(t, errors.Is(err, errRetryBudgetExhausted))
+	assert.Equal(t, writes, 1)
+	assert.Equal(t, options.pacer.interval, throttledInterval)
+
+	writes = 0
+	err = options.paced(context.Background(), "create service hello", func() error {
+		writes++
+		return nil
+	})
+	assert.NilError(t, err)
+	assert.Equal(t, writes, 1)
+}
+
+func TestPacedMaxThrottledRetries(t *testing.T) {
+	options := NewMigrationOptions()
+	options.Out = io.Discard
+	options.RetryBackoff = time.Millisecond
+	// The throttled writes are retried apart from the retries of conflicts and missing resources
+	options.MaxRetries = 0
+	options.MaxThrottledRetries = 2
+	writes := 0
+	err := options.paced(context.Background(), "create service hello", func() error {
+		writes++
+		return api_errors.NewTooManyRequests("too many requests", 0)
+	})
+	assert.Assert(t, api_errors.IsTooManyRequests(err))
+	assert.Equal(t, writes, 3)
+}
@@ -0,0 +1,155 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	apiv1 "k8s.io/api/core/v1"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+// pinnedImage returns the image referred to by a digest, keeping its repository as written, e.g. gcr.io/proj/app:v1
+// and sha256:0123 give gcr.io/proj/app@sha256:0123
+func pinnedImage(image, digest string) string {
+	repository := image
+	if at := strings.LastIndex(repository, "@"); at >= 0 {
+		repository = repository[:at]
+	}
+	if colon := strings.LastIndex(repository, ":"); colon > strings.LastIndex(repository, "/") {
+		repository = repository[:colon]
+	}
+	return repository + "@" + digest
+}
+
+// resolveDigest returns the digest a tag currently refers to in the source registry, as the source cluster pulls it
+func resolveDigest(ctx context.Context, image string, options *MigrationOptions) (string, error) {
+	options.mu.Lock()
+	digest, ok := options.resolvedDigests[image]
+	options.mu.Unlock()
+	if ok {
+		return digest, nil
+	}
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return "", fmt.Errorf("cannot pin image %s to its digest: %w", image, err)
+	}
+	desc, err := remote.Head(ref, remote.WithContext(ctx), remote.WithAuthFromKeychain(imageKeychain))
+	if err != nil {
+		return "", fmt.Errorf("cannot pin image %s to its digest: %w", image, err)
+	}
+	options.mu.Lock()
+	if options.resolvedDigests == nil {
+		options.resolvedDigests = map[string]string{}
+	}
+	options.resolvedDigests[image] = desc.Digest.String()
+	options.mu.Unlock()
+	return desc.Digest.String(), nil
+}
+
+// statusDigests returns the digests a revision resolved its images to when it was created, by container name
+func statusDigests(revision serving_v1_api.Revision) map[string]string {
+	digests := map[string]string{}
+	for _, status := range append(append([]serving_v1_api.ContainerStatus{}, revision.Status.ContainerStatuses...), revision.Status.InitContainerStatuses...) {
+		if at := strings.LastIndex(status.ImageDigest, "@"); at >= 0 {
+			digests[status.Name] = status.ImageDigest[at+1:]
+		}
+	}
+	return digests
+}
+
+// pinContainerImages returns a copy of the containers with their tags replaced by digests and the applied changes,
+// the digests resolved by the revision running the containers are used first, the source registry is asked otherwise
+func pinContainerImages(ctx context.Context, containers []apiv1.Container, digests map[string]string, options *MigrationOptions) ([]apiv1.Container, []string, error) {
+	if containers == nil {
+		return nil, nil, nil
+	}
+	pinned := make([]apiv1.Container, len(containers))
+	changes := []string{}
+	for i, container := range containers {
+		if !strings.Contains(container.Image, "@") {
+			digest, ok := digests[container.Name]
+			if !ok {
+				var err error
+				digest, err = resolveDigest(ctx, container.Image, options)
+				if err != nil {
+					return nil, nil, err
+				}
+			}
+			image := pinnedImage(container.Image, digest)
+			changes = append(changes, fmt.Sprintf("%s to %s", container.Image, image))
+			container.Image = image
+		}
+		pinned[i] = container
+	}
+	return pinned, changes, nil
+}
+
+// pinDigests replaces the tags of the images of a service and its revisions by the digests running in the source,
+// so that the destination runs the same images even if the tags move. The template of the service uses the digests
+// of its latest ready revision when it runs the same images.
+func pinDigests(ctx context.Context, serviceS *serving_v1_api.Service, revisionsS *serving_v1_api.RevisionList, options *MigrationOptions) error {
+	if !options.PinDigests {
+		return nil
+	}
+	pin := func(kind, name string, spec *apiv1.PodSpec, digests map[string]string) error {
+		var changes, initChanges []string
+		var err error
+		spec.Containers, changes, err = pinContainerImages(ctx, spec.Containers, digests, options)
+		if err != nil {
+			return err
+		}
+		spec.InitContainers, initChanges, err = pinContainerImages(ctx, spec.InitContainers, digests, options)
+		if err != nil {
+			return err
+		}
+		for _, change := range append(changes, initChanges...) {
+			fmt.Fprintln(options.out(), "Pinned image of", kind, color.CyanString(name)+":", change)
+		}
+		return nil
+	}
+
+	templateDigests := map[string]string{}
+	for _, revision := range revisionsS.Items {
+		if revision.Name != serviceS.Status.LatestReadyRevisionName {
+			continue
+		}
+		digests := statusDigests(revision)
+		images := map[string]string{}
+		for _, container := range append(append([]apiv1.Container{}, revision.Spec.Containers...), revision.Spec.InitContainers...) {
+			images[container.Name] = container.Image
+		}
+		for _, container := range append(append([]apiv1.Container{}, serviceS.Spec.Template.Spec.Containers...), serviceS.Spec.Template.Spec.InitContainers...) {
+			if digest, ok := digests[container.Name]; ok && images[container.Name] == container.Image {
+				templateDigests[container.Name] = digest
+			}
+		}
+	}
+	if err := pin("the template of service", serviceS.Name, &serviceS.Spec.Template.Spec.PodSpec, templateDigests); err != nil {
+		return err
+	}
+	for i := range revisionsS.Items {
+		revision := &revisionsS.Items[i]
+		if err := pin("revision", revision.Name, &revision.Spec.PodSpec, statusDigests(*revision)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"gotest.tools/assert"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+func TestPinnedImage(t *testing.T) {
+	for image, expected := range map[string]string{
+		"gcr.io/proj/app:v1":        "gcr.io/proj/app@sha256:0123",
+		"gcr.io/proj/app":           "gcr.io/proj/app@sha256:0123",
+		"localhost:5000/app":        "localhost:5000/app@sha256:0123",
+		"localhost:5000/app:latest": "localhost:5000/app@sha256:0123",
+		"nginx:1.21":                "nginx@sha256:0123",
+	} {
+		assert.Equal(t, pinnedImage(image, "sha256:0123"), expected, image)
+	}
+}
+
+func TestPinDigests(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	img, err := random.Image(64, 1)
+	assert.NilError(t, err)
+	ref, err := name.ParseReference(host + "/proj/sidecar:latest")
+	assert.NilError(t, err)
+	assert.NilError(t, remote.Write(ref, img))
+	sidecarDigest, err := img.Digest()
+	assert.NilError(t, err)
+
+	service := serving_v1_api.Service{ObjectMeta: metav1.ObjectMeta{Name: "hello"}}
+	service.Spec.Template.Spec.Containers = []apiv1.Container{
+		{Name: "app", Image: host + "/proj/app:latest"},
+		{Name: "sidecar", Image: host + "/proj/sidecar:latest"},
+		{Name: "pinned", Image: host + "/proj/pinned@sha256:4567"},
+	}
+	service.Status.LatestReadyRevisionName = "hello-00002"
+	newRevision := func(name, image, digest string) serving_v1_api.Revision {
+		revision := serving_v1_api.Revision{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		revision.Spec.Containers = []apiv1.Container{{Name: "app", Image: image}}
+		revision.Status.ContainerStatuses = []serving_v1_api.ContainerStatus{{Name: "app", ImageDigest: host + "/proj/app@" + digest}}
+		return revision
+	}
+	revisions := &serving_v1_api.RevisionList{Items: []serving_v1_api.Revision{
+		newRevision("hello-00001", host+"/proj/app:latest", "sha256:0001"),
+		newRevision("hello-00002", host+"/proj/app:latest", "sha256:0002"),
+	}}
+	options := NewMigrationOptions()
+	options.PinDigests = true
+
+	assert.NilError(t, pinDigests(context.Background(), &service, revisions, options))
+
+	// The template runs the digest of its latest ready revision, and the registry resolves the images it has no digest of
+	assert.Equal(t, service.Spec.Template.Spec.Containers[0].Image, host+"/proj/app@sha256:0002")
+	assert.Equal(t, service.Spec.Template.Spec.Containers[1].Image, host+"/proj/sidecar@"+sidecarDigest.String())
+	assert.Equal(t, service.Spec.Template.Spec.Containers[2].Image, host+"/proj/pinned@sha256:4567")
+	// Every revision keeps running the digest it resolved when it was created, even if the tag moved since
+	assert.Equal(t, revisions.Items[0].Spec.Containers[0].Image, host+"/proj/app@sha256:0001")
+	assert.Equal(t, revisions.Items[1].Spec.Containers[0].Image, host+"/proj/app@sha256:0002")
+
+	// A tag which cannot be resolved fails the service
+	service.Spec.Template.Spec.Containers = []apiv1.Container{{Name: "missing", Image: host + "/proj/missing:v1"}}
+	err = pinDigests(context.Background(), &service, &serving_v1_api.RevisionList{}, options)
+	assert.ErrorContains(t, err, "cannot pin image "+host+"/proj/missing:v1 to its digest")
+}
@@ -0,0 +1,379 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/fatih/color"
+	apiv1 "k8s.io/api/core/v1"
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"knative.dev/kn-plugin-migration/pkg/command"
+)
+
+// planAction is what a migration would do with a single resource
+type planAction string
+
+const (
+	planActionCreate   planAction = "create"
+	planActionReplace  planAction = "replace"
+	planActionUpdate   planAction = "update"
+	planActionRemap    planAction = "remap"
+	planActionDelete   planAction = "delete"
+	planActionSkip     planAction = "skip"
+	planActionConflict planAction = "conflict"
+	planActionDangling planAction = "dangling"
+)
+
+// planEntry describes the action planned for one resource
+type planEntry struct {
+	Kind      string     `json:"kind"`
+	Name      string     `json:"name"`
+	Namespace string     `json:"namespace"`
+	Cluster   string     `json:"cluster"`
+	Action    planAction `json:"action"`
+	Reason    string     `json:"reason,omitempty"`
+}
+
+// migrationPlan is the result of the read-only discovery of a migration
+type migrationPlan struct {
+	SourceNamespace      string      `json:"sourceNamespace"`
+	DestinationNamespace string      `json:"destinationNamespace"`
+	Entries              []planEntry `json:"entries"`
+	Footprint            *footprint  `json:"footprint"`
+	// DestinationCluster is the destination cluster of the plan when the run fans out to several clusters
+	DestinationCluster string `json:"destinationCluster,omitempty"`
+}
+
+// planDocument is the structured output of the plans, an object listing the plan of every namespace as the
+// migration report does, so that the plan of a single namespace and of several namespaces have the same shape
+type planDocument struct {
+	Namespaces []*migrationPlan `json:"namespaces"`
+}
+
+func (p *migrationPlan) add(entry planEntry) {
+	p.Entries = append(p.Entries, entry)
+}
+
+// hasConflicts returns true if the plan contains actions that would make the migration fail
+func (p *migrationPlan) hasConflicts() bool {
+	for _, entry := range p.Entries {
+		if entry.Action == planActionConflict {
+			return true
+		}
+	}
+	return false
+}
+
+// buildPlan runs the discovery of the migration against the source and the destination without any write call
+func buildPlan(ctx context.Context, source migrationSource, clientSetD kubernetes.Interface, migrationClientD command.MigrationClient, namespaceD string, filter *serviceFilter, options *MigrationOptions, delete bool) (*migrationPlan, error) {
+	namespaceS := source.Namespace()
+	plan := &migrationPlan{
+		SourceNamespace:      namespaceS,
+		DestinationNamespace: namespaceD,
+	}
+
+	_, err := clientSetD.CoreV1().Namespaces().Get(ctx, namespaceD, metav1.GetOptions{})
+	if err != nil && !api_errors.IsNotFound(err) {
+		return nil, err
+	}
+	if api_errors.IsNotFound(err) {
+		plan.add(planEntry{Kind: "Namespace", Name: namespaceD, Cluster: "destination", Action: planActionCreate})
+	} else {
+		plan.add(planEntry{Kind: "Namespace", Name: namespaceD, Cluster: "destination", Action: planActionSkip, Reason: "already exists"})
+	}
+
+	servicesS, err := source.ListServices(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < len(servicesS.Items); i++ {
+		serviceS := servicesS.Items[i]
+		revisionsS, err := source.ListRevisionByService(ctx, serviceS.Name)
+		if err != nil {
+			return nil, err
+		}
+		revisionsS, left := selectRevisions(serviceS, revisionsS, options.Revisions)
+		for _, name := range left {
+			plan.add(planEntry{Kind: "Revision", Name: name, Namespace: plan.SourceNamespace, Cluster: "source", Action: planActionSkip, Reason: "not selected by --revisions " + string(options.Revisions)})
+		}
+		if options.RegenerateRevisions {
+			for _, revision := range revisionsS.Items {
+				plan.add(planEntry{Kind: "Revision", Name: revision.Name, Namespace: plan.SourceNamespace, Cluster: "source", Action: planActionSkip, Reason: "regenerated by the destination from the service"})
+			}
+			revisionsS = revisionsS.DeepCopy()
+			revisionsS.Items = nil
+			serviceS.Spec.Traffic, _ = regenerateTraffic(serviceS.Spec.Traffic)
+		}
+		if options.SkipBrokenRevisions {
+			broken := map[string]bool{}
+			for _, revision := range findBrokenRevisions(serviceS, revisionsS) {
+				if !revision.Needed {
+					broken[revision.Name] = true
+					plan.add(planEntry{Kind: "Revision", Name: revision.Name, Namespace: plan.SourceNamespace, Cluster: "source", Action: planActionSkip, Reason: "not Ready in the source: " + revision.Reason})
+				}
+			}
+			revisionsS = withoutRevisions(revisionsS, broken)
+		}
+		configmapS, err := source.GetConfigmap(ctx, generateConfigmapName(serviceS.Name))
+		if err != nil && !api_errors.IsNotFound(err) {
+			return nil, err
+		}
+		hash, err := serviceSourceHash(serviceS)
+		if err != nil {
+			return nil, err
+		}
+		created := planEntry{Kind: "Service", Name: options.destinationName(serviceS.Name), Namespace: namespaceD, Cluster: "destination", Action: planActionCreate}
+		if created.Name != serviceS.Name {
+			created.Reason = "renamed from " + serviceS.Name
+			renameService(&serviceS, revisionsS, created.Name)
+		}
+		serviceExists, err := migrationClientD.ServiceExists(ctx, serviceS.Name)
+		if err != nil {
+			return nil, err
+		}
+		if serviceExists && options.onConflict() == ConflictSkip {
+			plan.add(planEntry{Kind: "Service", Name: serviceS.Name, Namespace: namespaceD, Cluster: "destination", Action: planActionSkip, Reason: "already exists and conflicting services are skipped, the destination service is kept"})
+			continue
+		}
+		changed := false
+		annotationsD := map[string]string{}
+		if serviceExists {
+			serviceD, err := migrationClientD.GetService(ctx, serviceS.Name)
+			if err != nil {
+				return nil, err
+			}
+			annotationsD = serviceD.Annotations
+		}
+		if recorded := annotationsD[sourceHashAnnotation]; serviceExists && options.comparesSourceHash(ForceServices) {
+			if recorded == hash {
+				plan.add(planEntry{Kind: "Service", Name: serviceS.Name, Namespace: namespaceD, Cluster: "destination", Action: planActionSkip, Reason: "unchanged in the source since the last migration"})
+				continue
+			}
+			changed = recorded != ""
+		}
+		configmapName := generateConfigmapName(serviceS.Name)
+		if configmapS != nil {
+			configmapD, err := getConfigmap(ctx, clientSetD, namespaceD, configmapName)
+			if err != nil && !api_errors.IsNotFound(err) {
+				return nil, err
+			}
+			configmapHash, hashErr := configmapSourceHash(configmapS)
+			if hashErr != nil {
+				return nil, hashErr
+			}
+			recorded := recordedAnnotation(configmapD, sourceHashAnnotation)
+			compared := recorded != "" && options.comparesSourceHash(ForceConfigMaps)
+			switch {
+			case compared && recorded == configmapHash:
+				plan.add(planEntry{Kind: "ConfigMap", Name: configmapName, Namespace: namespaceD, Cluster: "destination", Action: planActionSkip, Reason: "unchanged in the source since the last migration"})
+			case compared:
+				plan.add(planEntry{Kind: "ConfigMap", Name: configmapName, Namespace: namespaceD, Cluster: "destination", Action: planActionReplace, Reason: "changed in the source since the last migration"})
+			case err == nil && options.forces(ForceConfigMaps) && !options.Adopt && !managed(configmapD.Annotations):
+				plan.add(planEntry{Kind: "ConfigMap", Name: configmapName, Namespace: namespaceD, Cluster: "destination", Action: planActionConflict, Reason: "already exists and is not managed by kn-migration, use --adopt to overwrite it"})
+			case err == nil && options.forces(ForceConfigMaps):
+				plan.add(planEntry{Kind: "ConfigMap", Name: configmapName, Namespace: namespaceD, Cluster: "destination", Action: planActionReplace, Reason: "already exists and configmaps are forced"})
+			case err == nil:
+				plan.add(planEntry{Kind: "ConfigMap", Name: configmapName, Namespace: namespaceD, Cluster: "destination", Action: planActionConflict, Reason: "already exists and configmaps are not forced"})
+			default:
+				plan.add(planEntry{Kind: "ConfigMap", Name: configmapName, Namespace: namespaceD, Cluster: "destination", Action: planActionCreate})
+			}
+		}
+
+		for _, secretName := range referencedSecrets(serviceS.Spec.Template) {
+			secretS, err := source.GetSecret(ctx, secretName)
+			if api_errors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if secretS.Type == apiv1.SecretTypeServiceAccountToken {
+				continue
+			}
+			if generator, generatorName, ok := secretGeneratorOf(secretS); ok {
+				if clientS, clientD := secretGeneratorClients(options); clientS != nil {
+					entry, err := planSecretGenerator(ctx, clientD, generator, generatorName, secretName, namespaceD, options)
+					if err != nil {
+						return nil, err
+					}
+					plan.add(entry)
+					continue
+				}
+			}
+			_, err = getSecret(ctx, clientSetD, namespaceD, secretName)
+			switch {
+			case err == nil && options.forces(ForceSecrets):
+				plan.add(planEntry{Kind: "Secret", Name: secretName, Namespace: namespaceD, Cluster: "destination", Action: planActionReplace, Reason: "already exists and secrets are forced"})
+			case err == nil:
+				plan.add(planEntry{Kind: "Secret", Name: secretName, Namespace: namespaceD, Cluster: "destination", Action: planActionSkip, Reason: "already exists and secrets are not forced, the destination secret is kept"})
+			case api_errors.IsNotFound(err):
+				plan.add(planEntry{Kind: "Secret", Name: secretName, Namespace: namespaceD, Cluster: "destination", Action: planActionCreate})
+			default:
+				return nil, err
+			}
+		}
+
+		for _, claimName := range referencedClaims(serviceS.Spec.Template) {
+			_, err := source.GetPersistentVolumeClaim(ctx, claimName)
+			if api_errors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			_, err = getPersistentVolumeClaim(ctx, clientSetD, namespaceD, claimName)
+			switch {
+			case err == nil:
+				plan.add(planEntry{Kind: "PersistentVolumeClaim", Name: claimName, Namespace: namespaceD, Cluster: "destination", Action: planActionSkip, Reason: "already exists, the destination claim and its data are kept"})
+			case api_errors.IsNotFound(err) && options.CopyClaimData:
+				plan.add(planEntry{Kind: "PersistentVolumeClaim", Name: claimName, Namespace: namespaceD, Cluster: "destination", Action: planActionCreate, Reason: "its data is copied from the source"})
+			case api_errors.IsNotFound(err):
+				plan.add(planEntry{Kind: "PersistentVolumeClaim", Name: claimName, Namespace: namespaceD, Cluster: "destination", Action: planActionCreate, Reason: "empty, its data is not copied without --copy-pvc-data"})
+			default:
+				return nil, err
+			}
+		}
+
+		if clientSetS := sourceClientSet(source); clientSetS != nil {
+			for _, accountName := range referencedServiceAccounts(serviceS, revisionsS) {
+				_, err := clientSetS.CoreV1().ServiceAccounts(namespaceS).Get(ctx, accountName, metav1.GetOptions{})
+				if api_errors.IsNotFound(err) {
+					continue
+				}
+				if err != nil {
+					return nil, err
+				}
+				_, err = clientSetD.CoreV1().ServiceAccounts(namespaceD).Get(ctx, accountName, metav1.GetOptions{})
+				switch {
+				case err == nil:
+					plan.add(planEntry{Kind: "ServiceAccount", Name: accountName, Namespace: namespaceD, Cluster: "destination", Action: planActionSkip, Reason: "already exists, the destination service account is kept"})
+				case api_errors.IsNotFound(err):
+					plan.add(planEntry{Kind: "ServiceAccount", Name: accountName, Namespace: namespaceD, Cluster: "destination", Action: planActionCreate, Reason: "with its image pull secrets, Roles and RoleBindings"})
+				default:
+					return nil, err
+				}
+			}
+		}
+
+		switch {
+		case !serviceExists:
+			plan.add(created)
+		case changed:
+			plan.add(planEntry{Kind: "Service", Name: serviceS.Name, Namespace: namespaceD, Cluster: "destination", Action: planActionReplace, Reason: "changed in the source since the last migration, applied over it keeping its revisions"})
+		case !options.Adopt && !managed(annotationsD) && options.onConflict().replaces():
+			plan.add(planEntry{Kind: "Service", Name: serviceS.Name, Namespace: namespaceD, Cluster: "destination", Action: planActionConflict, Reason: "already exists and is not managed by kn-migration, use --adopt to overwrite it"})
+		case options.recreates():
+			plan.add(planEntry{Kind: "Service", Name: serviceS.Name, Namespace: namespaceD, Cluster: "destination", Action: planActionReplace, Reason: "already exists and services are recreated, deleting its revisions"})
+		case options.onConflict() == ConflictOverwrite:
+			plan.add(planEntry{Kind: "Service", Name: serviceS.Name, Namespace: namespaceD, Cluster: "destination", Action: planActionReplace, Reason: "already exists and services are forced, applied over it keeping its revisions"})
+		case options.onConflict() == ConflictMerge:
+			plan.add(planEntry{Kind: "Service", Name: serviceS.Name, Namespace: namespaceD, Cluster: "destination", Action: planActionReplace, Reason: "already exists and services are merged, keeping its revisions and the labels, annotations and traffic the source does not set"})
+		default:
+			plan.add(planEntry{Kind: "Service", Name: serviceS.Name, Namespace: namespaceD, Cluster: "destination", Action: planActionConflict, Reason: "already exists and no --force or --on-conflict option was given"})
+		}
+
+		collisions, err := detectRevisionCollisions(ctx, migrationClientD, serviceS, revisionsS, serviceExists && options.recreates(), serviceExists && (changed || options.onConflict().replaces() && !options.recreates()), options.RevisionCollision)
+		if err != nil {
+			return nil, err
+		}
+		colliding := map[string]revisionCollision{}
+		for _, collision := range collisions.Collisions {
+			colliding[collision.Name] = collision
+		}
+		for _, name := range unresolvedTrafficRevisions(serviceS, revisionsS) {
+			plan.add(planEntry{Kind: "Revision", Name: name, Namespace: namespaceD, Cluster: "destination", Action: planActionConflict, Reason: "is a traffic target of the service but is not migrated with it"})
+		}
+		for j := 0; j < len(revisionsS.Items); j++ {
+			revisionS := revisionsS.Items[j]
+			collision, collides := colliding[revisionS.Name]
+			switch {
+			case collides && collision.Remapped == "":
+				plan.add(planEntry{Kind: "Revision", Name: revisionS.Name, Namespace: namespaceD, Cluster: "destination", Action: planActionConflict, Reason: collision.Reason})
+			case collides:
+				plan.add(planEntry{Kind: "Revision", Name: collision.Remapped, Namespace: namespaceD, Cluster: "destination", Action: planActionRemap, Reason: fmt.Sprintf("remapped from %s which %s", revisionS.Name, collision.Reason)})
+			case collisions.Existing[revisionS.Name]:
+				plan.add(planEntry{Kind: "Revision", Name: revisionS.Name, Namespace: namespaceD, Cluster: "destination", Action: planActionSkip, Reason: "already exists with the same spec"})
+			case revisionS.Name == command.TemplateRevisionName(serviceS):
+				plan.add(planEntry{Kind: "Revision", Name: revisionS.Name, Namespace: namespaceD, Cluster: "destination", Action: planActionUpdate, Reason: "created by the service, generation is rewritten"})
+			default:
+				plan.add(planEntry{Kind: "Revision", Name: revisionS.Name, Namespace: namespaceD, Cluster: "destination", Action: planActionCreate})
+			}
+		}
+		if len(serviceS.Spec.Traffic) > 0 {
+			plan.add(planEntry{Kind: "Service", Name: serviceS.Name, Namespace: namespaceD, Cluster: "destination", Action: planActionUpdate, Reason: "traffic " + describeTraffic(serviceS.Spec.Traffic) + " is restored once the revisions are migrated"})
+		}
+	}
+
+	violations, err := checkObjectSizes(ctx, source, migrationClientD, namespaceD, servicesS, options.MaxObjectSize)
+	if err != nil {
+		return nil, err
+	}
+	for _, violation := range violations {
+		plan.add(planEntry{Kind: violation.Kind, Name: violation.Name, Namespace: namespaceD, Cluster: "destination", Action: planActionConflict, Reason: fmt.Sprintf("%d bytes exceed the maximum object size of %d bytes", violation.Size, options.MaxObjectSize)})
+	}
+
+	incompatibilities, err := checkCompatibility(ctx, source, servicesS, detectCapabilities(ctx, clientSetD, options), options.Revisions)
+	if err != nil {
+		return nil, err
+	}
+	for _, incompatibility := range incompatibilities {
+		plan.add(planEntry{Kind: "Service", Name: incompatibility.Service, Namespace: namespaceD, Cluster: "destination", Action: planActionConflict, Reason: "uses fields the destination does not accept: " + strings.Join(incompatibility.Fields, ", ")})
+	}
+
+	plan.Footprint, err = estimateFootprint(servicesS.Items)
+	if err != nil {
+		return nil, err
+	}
+
+	if delete {
+		for i := 0; i < len(servicesS.Items); i++ {
+			plan.add(planEntry{Kind: "Service", Name: servicesS.Items[i].Name, Namespace: namespaceS, Cluster: "source", Action: planActionDelete})
+		}
+	}
+	return plan, nil
+}
+
+// printPlans writes the plans of every namespace in the given output format, human readable if the format is empty
+func printPlans(out io.Writer, plans []*migrationPlan, format string) error {
+	switch format {
+	case "":
+		for _, plan := range plans {
+			fmt.Fprintln(out, color.GreenString("[Migration plan]"))
+			if plan.DestinationCluster != "" {
+				fmt.Fprintln(out, "To the destination cluster", color.CyanString(plan.DestinationCluster))
+			}
+			fmt.Fprintln(out, "From the source", color.BlueString(plan.SourceNamespace), "namespace to the destination", color.BlueString(plan.DestinationNamespace), "namespace")
+			color.New(color.FgCyan).Fprintf(out, "%-10s%-13s%-12s%-40s%s\n", "Action", "Cluster", "Kind", "Name", "Reason")
+			for _, entry := range plan.Entries {
+				fmt.Fprintf(out, "%-10s%-13s%-12s%-40s%s\n", entry.Action, entry.Cluster, entry.Kind, entry.Name, entry.Reason)
+			}
+			fmt.Fprintln(out, "")
+			printFootprint(out, plan.Footprint)
+			if plan.hasConflicts() {
+				fmt.Fprintln(out, color.RedString("The migration would fail because of the conflicts above"))
+			}
+			fmt.Fprintln(out, "")
+		}
+		return nil
+	default:
+		return printStructured(out, planDocument{Namespaces: plans}, format)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func planEntries(plan *migrationPlan) []string {
+	entries := []string{}
+	for _, entry := range plan.Entries {
+		entries = append(entries, fmt.Sprintf("%s %s %s/%s", entry.Action, entry.Cluster, entry.Kind, entry.Name))
+	}
+	return entries
+}
+
+func TestBuildPlan(t *testing.T) {
+	source := simulatedBundle("default", "bye", "hello")
+	filter, err := newServiceFilter(nil, "")
+	assert.NilError(t, err)
+
+	clientSetD, migrationClientD := newSimulatedDestination("prod", simulatedBundle("prod", "bye"))
+	plan, err := buildPlan(context.Background(), source, clientSetD, migrationClientD, "prod", filter, NewMigrationOptions(), true)
+	assert.NilError(t, err)
+	assert.Equal(t, plan.SourceNamespace, "default")
+	assert.Equal(t, plan.DestinationNamespace, "prod")
+	assert.DeepEqual(t, planEntries(plan), []string{
+		"skip destination Namespace/prod",
+		"conflict destination Service/bye",
+		"skip destination Revision/bye-00001",
+		"conflict destination Revision/bye-00002",
+		"create destination Service/hello",
+		"create destination Revision/hello-00001",
+		"update destination Revision/hello-00002",
+		"delete source Service/bye",
+		"delete source Service/hello",
+	})
+	assert.Assert(t, plan.hasConflicts())
+	assert.Assert(t, plan.Footprint != nil)
+
+	// An existing service which was not migrated is only replaced when adopted
+	options := NewMigrationOptions()
+	options.Force = true
+	options.Adopt = true
+	clientSetD, migrationClientD = newSimulatedDestination("prod", &bundleSource{})
+	plan, err = buildPlan(context.Background(), simulatedBundle("default", "hello"), clientSetD, migrationClientD, "prod", filter, options, false)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, planEntries(plan), []string{
+		"create destination Namespace/prod",
+		"create destination Service/hello",
+		"create destination Revision/hello-00001",
+		"update destination Revision/hello-00002",
+	})
+	assert.Assert(t, !plan.hasConflicts())
+}
+
+func TestPrintPlans(t *testing.T) {
+	plans := []*migrationPlan{{SourceNamespace: "default", DestinationNamespace: "prod", Footprint: &footprint{}}}
+	plans[0].add(planEntry{Kind: "Service", Name: "hello", Namespace: "prod", Cluster: "destination", Action: planActionConflict, Reason: "already exists"})
+
+	var out bytes.Buffer
+	assert.NilError(t, printPlans(&out, plans, ""))
+	assert.Assert(t, strings.Contains(out.String(), "From the source default namespace to the destination prod namespace"), out.String())
+	assert.Assert(t, strings.Contains(out.String(), "The migration would fail because of the conflicts above"), out.String())
+
+	// The structured plan is an object listing the plan of every namespace, even of a single one
+	out.Reset()
+	assert.NilError(t, printPlans(&out, plans, "json"))
+	document := map[string][]map[string]interface{}{}
+	assert.NilError(t, json.Unmarshal(out.Bytes(), &document))
+	assert.Equal(t, len(document["namespaces"]), 1)
+	assert.Equal(t, document["namespaces"][0]["sourceNamespace"], "default")
+	assert.Equal(t, len(document["namespaces"][0]["entries"].([]interface{})), 1)
+
+	out.Reset()
+	assert.NilError(t, printPlans(&out, plans, "yaml"))
+	assert.Assert(t, strings.HasPrefix(out.String(), "namespaces:\n"), out.String())
+}
@@ -0,0 +1,128 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// profilesConfigKey is the config file key declaring the clusters migrations run between by name, e.g.
+//
+//	profiles:
+//	  staging:
+//	    kubeconfig: ~/.kube/staging
+//	    namespace: default
+//	  prod:
+//	    kubeconfig: ~/.kube/config
+//	    context: prod
+//	    namespace: default
+const profilesConfigKey = "profiles"
+
+// transformationsConfigKey is the config file key declaring how objects are rewritten in the destination, e.g.
+//
+//	transformations:
+//	  annotations:
+//	  - from: example.com/team
+//	    to: acme.io/team
+//	  - from: example.com/legacy
+const transformationsConfigKey = "transformations"
+
+// migrationProfile is a named cluster and namespace of the config file
+type migrationProfile struct {
+	KubeConfig string `mapstructure:"kubeconfig"`
+	Context    string `mapstructure:"context"`
+	Namespace  string `mapstructure:"namespace"`
+}
+
+// annotationRule renames an annotation by key, an empty To drops the annotation
+type annotationRule struct {
+	From string `mapstructure:"from"`
+	To   string `mapstructure:"to"`
+}
+
+// migrationTransformations are the rules of the config file rewriting the migrated objects
+type migrationTransformations struct {
+	Annotations []annotationRule `mapstructure:"annotations"`
+}
+
+// loadProfile reads a named profile from the config file
+func loadProfile(name string) (migrationProfile, error) {
+	profile := migrationProfile{}
+	key := profilesConfigKey + "." + name
+	if !viper.IsSet(key) {
+		return profile, fmt.Errorf("no profile %s in config file", name)
+	}
+	err := viper.UnmarshalKey(key, &profile)
+	if err != nil {
+		return profile, fmt.Errorf("cannot read profile %s from config file: %w", name, err)
+	}
+	return profile, nil
+}
+
+// applyProfiles sets the cluster flags of a command which are not given on the command line to the source and
+// destination profiles of the config file, an empty profile name leaves the flags of its cluster unchanged
+func applyProfiles(cmd *cobra.Command, source, destination string) error {
+	if source != "" {
+		profile, err := loadProfile(source)
+		if err != nil {
+			return err
+		}
+		err = setUnchangedFlags(cmd, map[string]string{"kubeconfig": profile.KubeConfig, "context": profile.Context, "namespace": profile.Namespace})
+		if err != nil {
+			return err
+		}
+	}
+	if destination != "" {
+		profile, err := loadProfile(destination)
+		if err != nil {
+			return err
+		}
+		err = setUnchangedFlags(cmd, map[string]string{"destination-kubeconfig": profile.KubeConfig, "destination-context": profile.Context, "destination-namespace": profile.Namespace})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setUnchangedFlags sets the flags which are not given on the command line, an empty value is ignored
+func setUnchangedFlags(cmd *cobra.Command, values map[string]string) error {
+	for name, value := range values {
+		flag := cmd.Flags().Lookup(name)
+		if value == "" || flag == nil || flag.Changed {
+			continue
+		}
+		if err := flag.Value.Set(value); err != nil {
+			return fmt.Errorf("invalid %s %s: %w", name, value, err)
+		}
+	}
+	return nil
+}
+
+// loadTransformations reads the transformation rules from the config file, if any
+func loadTransformations() (migrationTransformations, error) {
+	transformations := migrationTransformations{}
+	if !viper.IsSet(transformationsConfigKey) {
+		return transformations, nil
+	}
+	err := viper.UnmarshalKey(transformationsConfigKey, &transformations)
+	if err != nil {
+		return transformations, fmt.Errorf("cannot read %s from config file: %w", transformationsConfigKey, err)
+	}
+	return transformations, nil
+}
@@ -0,0 +1,86 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gotest.tools/assert"
+)
+
+const testProfilesConfig = `
+profiles:
+  staging:
+    kubeconfig: /kube/staging
+    namespace: team-a
+  prod:
+    kubeconfig: /kube/config
+    context: prod
+transformations:
+  annotations:
+  - from: example.com/team
+    to: acme.io/team
+  - from: example.com/legacy
+`
+
+func readProfilesConfig(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.SetConfigType("yaml")
+	assert.NilError(t, viper.ReadConfig(bytes.NewBufferString(testProfilesConfig)))
+}
+
+func TestApplyProfiles(t *testing.T) {
+	readProfilesConfig(t)
+	cmd := &cobra.Command{}
+	kubeconfig := cmd.Flags().String("kubeconfig", "", "")
+	kubeContext := cmd.Flags().String("context", "", "")
+	namespace := cmd.Flags().String("namespace", "", "")
+	destinationKubeConfig := cmd.Flags().String("destination-kubeconfig", "", "")
+	destinationContext := cmd.Flags().String("destination-context", "", "")
+	destinationNamespace := cmd.Flags().String("destination-namespace", "", "")
+	assert.NilError(t, cmd.Flags().Parse([]string{"--destination-namespace", "team-b"}))
+
+	assert.NilError(t, applyProfiles(cmd, "staging", "prod"))
+	assert.Equal(t, *kubeconfig, "/kube/staging")
+	assert.Equal(t, *kubeContext, "")
+	assert.Equal(t, *namespace, "team-a")
+	assert.Equal(t, *destinationKubeConfig, "/kube/config")
+	assert.Equal(t, *destinationContext, "prod")
+	// The command line wins over the profile
+	assert.Equal(t, *destinationNamespace, "team-b")
+
+	err := applyProfiles(cmd, "dev", "")
+	assert.ErrorContains(t, err, "no profile dev in config file")
+}
+
+func TestTransformationsAnnotationMapping(t *testing.T) {
+	readProfilesConfig(t)
+	options := &MigrationOptions{}
+	assert.NilError(t, setupNetworkingTranslation(options, ""))
+	assert.DeepEqual(t, options.AnnotationMapping, map[string]string{"example.com/team": "acme.io/team", "example.com/legacy": ""})
+
+	// The mapping file overrides the rules of the config file
+	mappingFile := filepath.Join(t.TempDir(), "mapping.txt")
+	assert.NilError(t, ioutil.WriteFile(mappingFile, []byte("example.com/team=corp.io/team\n"), 0600))
+	options = &MigrationOptions{}
+	assert.NilError(t, setupNetworkingTranslation(options, mappingFile))
+	assert.DeepEqual(t, options.AnnotationMapping, map[string]string{"example.com/team": "corp.io/team", "example.com/legacy": ""})
+}
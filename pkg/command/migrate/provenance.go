@@ -0,0 +1,112 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"knative.dev/kn-plugin-migration/pkg/command"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+const (
+	// migratedFromAnnotation records on a migrated service the JSON of the provenance of its migration
+	migratedFromAnnotation = "migration.knative.dev/migrated-from"
+	// migratedFromReason is the reason of the Event recorded on a migrated service
+	migratedFromReason = "MigratedFrom"
+	// migrationComponent is the component reporting the Events of the migration
+	migrationComponent = "kn-migration"
+)
+
+// provenance tells where a migrated service comes from
+type provenance struct {
+	// Cluster is the source cluster, the URL of its API server, or the bundle a service was imported from
+	Cluster   string `json:"cluster,omitempty"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Timestamp string `json:"timestamp"`
+	Version   string `json:"version"`
+}
+
+// newProvenance returns the provenance of a source service migrated now
+func newProvenance(options *MigrationOptions, namespace, name string, now time.Time) provenance {
+	version := command.Version
+	if version == "" {
+		version = "dev"
+	}
+	return provenance{
+		Cluster:   options.SourceCluster,
+		Namespace: namespace,
+		Name:      name,
+		Timestamp: now.UTC().Format(time.RFC3339),
+		Version:   version,
+	}
+}
+
+// annotate records the provenance on the metadata of the service, not on its template, so that no revision is created for it
+func (p provenance) annotate(service *serving_v1_api.Service) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return
+	}
+	annotations := make(map[string]string, len(service.Annotations)+1)
+	for key, value := range service.Annotations {
+		annotations[key] = value
+	}
+	annotations[migratedFromAnnotation] = string(data)
+	service.Annotations = annotations
+}
+
+func (p provenance) message() string {
+	source := fmt.Sprintf("service %s of namespace %s", p.Name, p.Namespace)
+	if p.Cluster != "" {
+		source += " of " + p.Cluster
+	}
+	return fmt.Sprintf("Migrated from %s at %s by kn-migration %s", source, p.Timestamp, p.Version)
+}
+
+// recordMigratedFromEvent records a MigratedFrom Event on the destination service, shown by kubectl describe
+func recordMigratedFromEvent(ctx context.Context, clientSetD kubernetes.Interface, serviceD *serving_v1_api.Service, p provenance, now time.Time) error {
+	timestamp := metav1.NewTime(now)
+	event := &apiv1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: serviceD.Name + ".",
+			Namespace:    serviceD.Namespace,
+		},
+		InvolvedObject: apiv1.ObjectReference{
+			APIVersion:      serving_v1_api.SchemeGroupVersion.String(),
+			Kind:            "Service",
+			Namespace:       serviceD.Namespace,
+			Name:            serviceD.Name,
+			UID:             serviceD.UID,
+			ResourceVersion: serviceD.ResourceVersion,
+		},
+		Reason:         migratedFromReason,
+		Message:        p.message(),
+		Type:           apiv1.EventTypeNormal,
+		Source:         apiv1.EventSource{Component: migrationComponent},
+		FirstTimestamp: timestamp,
+		LastTimestamp:  timestamp,
+		Count:          1,
+	}
+	_, err := clientSetD.CoreV1().Events(serviceD.Namespace).Create(ctx, event, metav1.CreateOptions{})
+	return destinationError(err)
+}
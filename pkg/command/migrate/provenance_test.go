@@ -0,0 +1,83 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+func TestProvenanceAnnotation(t *testing.T) {
+	options := NewMigrationOptions()
+	options.SourceCluster = "https://source:6443"
+	p := newProvenance(options, "default", "hello", time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC))
+	assert.Equal(t, p.Timestamp, "2020-06-01T12:00:00Z")
+	assert.Equal(t, p.message(), "Migrated from service hello of namespace default of https://source:6443 at 2020-06-01T12:00:00Z by kn-migration "+p.Version)
+
+	service := &serving_v1_api.Service{}
+	service.Annotations = map[string]string{"team": "a"}
+	service.Spec.Template.Annotations = map[string]string{"autoscaling.knative.dev/minScale": "1"}
+	p.annotate(service)
+	decoded := provenance{}
+	assert.NilError(t, json.Unmarshal([]byte(service.Annotations[migratedFromAnnotation]), &decoded))
+	assert.DeepEqual(t, decoded, p)
+	assert.Equal(t, service.Annotations["team"], "a")
+	// The template is left untouched so that no revision is created
+	assert.DeepEqual(t, service.Spec.Template.Annotations, map[string]string{"autoscaling.knative.dev/minScale": "1"})
+}
+
+func TestRecordMigratedFromEvent(t *testing.T) {
+	clientSet := fake.NewSimpleClientset()
+	service := &serving_v1_api.Service{ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "prod", UID: "1234"}}
+	p := provenance{Namespace: "default", Name: "hello", Timestamp: "2020-06-01T12:00:00Z", Version: "v0.1"}
+	assert.NilError(t, recordMigratedFromEvent(context.Background(), clientSet, service, p, time.Now()))
+
+	events, err := clientSet.CoreV1().Events("prod").List(context.Background(), metav1.ListOptions{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(events.Items), 1)
+	event := events.Items[0]
+	assert.Equal(t, event.Reason, migratedFromReason)
+	assert.Equal(t, event.InvolvedObject.Kind, "Service")
+	assert.Equal(t, event.InvolvedObject.Name, "hello")
+	assert.Equal(t, string(event.InvolvedObject.UID), "1234")
+	assert.Equal(t, event.Message, "Migrated from service hello of namespace default at 2020-06-01T12:00:00Z by kn-migration v0.1")
+}
+
+func TestMigrateServiceRecordsProvenance(t *testing.T) {
+	options := NewMigrationOptions()
+	source := simulatedBundle("default", "hello")
+	clientSetD, migrationClientD := newSimulatedDestination("prod", &bundleSource{})
+	_, _, err := migrateService(context.Background(), source, clientSetD, migrationClientD, "prod", source.services[0], options)
+	assert.NilError(t, err)
+
+	serviceD, err := migrationClientD.GetService(context.Background(), "hello")
+	assert.NilError(t, err)
+	decoded := provenance{}
+	assert.NilError(t, json.Unmarshal([]byte(serviceD.Annotations[migratedFromAnnotation]), &decoded))
+	assert.Equal(t, decoded.Namespace, "default")
+	assert.Equal(t, decoded.Name, "hello")
+
+	events, err := clientSetD.CoreV1().Events("prod").List(context.Background(), metav1.ListOptions{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(events.Items), 1)
+	assert.Equal(t, events.Items[0].Reason, migratedFromReason)
+}
@@ -0,0 +1,581 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	batchv1 "k8s.io/api/batch/v1"
+	apiv1 "k8s.io/api/core/v1"
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"knative.dev/kn-plugin-migration/pkg/command"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+const (
+	// DefaultClaimCopyTimeout is the default maximum time to copy the data of a persistent volume claim
+	DefaultClaimCopyTimeout = 30 * time.Minute
+
+	// claimCopyManager is the manager of the objects of the copy
+	claimCopyManager = "kn-migration"
+	// claimCopyPort is the port of the SSH daemon serving the source data
+	claimCopyPort = 2222
+	// claimCopyKeyType is the type of the SSH keys generated for a copy
+	claimCopyKeyType = "ecdsa-sha2-nistp256"
+	// claimCopyLabel selects the rsync daemon of a persistent volume claim
+	claimCopyLabel = "migration.knative.dev/pvc-copy"
+	// claimCopyPollInterval is the interval between two checks of the progress of a data copy
+	claimCopyPollInterval = 2 * time.Second
+	// claimCopyServerCommand is the only command the client key of a copy runs on the daemon: the rsync server
+	// sending /data/ for the rsync -a of the copy job, whatever command the client asks for
+	claimCopyServerCommand = "rsync --server --sender -logDtpr . /data/"
+)
+
+// ClaimCopyExposure tells how the SSH daemon serving the data of a source claim is reached by the destination
+type ClaimCopyExposure string
+
+const (
+	// ClaimCopyExposeInternal exposes the daemon with an internal load balancer of the cloud of the source cluster
+	ClaimCopyExposeInternal ClaimCopyExposure = "internal"
+	// ClaimCopyExposePublic exposes the daemon with a load balancer reachable from the internet
+	ClaimCopyExposePublic ClaimCopyExposure = "public"
+	// ClaimCopyExposeNodePort exposes the daemon on a port of the node it runs on
+	ClaimCopyExposeNodePort ClaimCopyExposure = "nodeport"
+)
+
+// String implements pflag.Value
+func (e *ClaimCopyExposure) String() string {
+	return string(*e)
+}
+
+// Set implements pflag.Value
+func (e *ClaimCopyExposure) Set(value string) error {
+	switch ClaimCopyExposure(value) {
+	case ClaimCopyExposeInternal, ClaimCopyExposePublic, ClaimCopyExposeNodePort:
+		*e = ClaimCopyExposure(value)
+		return nil
+	default:
+		return fmt.Errorf("unsupported pvc copy exposure %q, supported exposures are: internal, public, nodeport", value)
+	}
+}
+
+// Type implements pflag.Value
+func (e *ClaimCopyExposure) Type() string {
+	return "string"
+}
+
+// internalLoadBalancerAnnotations request an internal load balancer from the cloud providers supporting one
+var internalLoadBalancerAnnotations = map[string]string{
+	"networking.gke.io/load-balancer-type":                               "Internal",
+	"service.beta.kubernetes.io/aws-load-balancer-internal":              "true",
+	"service.beta.kubernetes.io/azure-load-balancer-internal":            "true",
+	"service.beta.kubernetes.io/openstack-internal-load-balancer":        "true",
+	"service.beta.kubernetes.io/oci-load-balancer-internal":              "true",
+	"service.beta.kubernetes.io/alibaba-cloud-loadbalancer-address-type": "intranet",
+}
+
+// claimBindingAnnotations bind a persistent volume claim to a volume and a node of the source cluster
+var claimBindingAnnotations = []string{
+	"pv.kubernetes.io/bind-completed",
+	"pv.kubernetes.io/bound-by-controller",
+	"volume.beta.kubernetes.io/storage-provisioner",
+	"volume.kubernetes.io/storage-provisioner",
+	"volume.kubernetes.io/selected-node",
+}
+
+// validateClaimCopy checks that --copy-pvc-data has an image to run, which must already provide sshd and rsync since
+// nothing is installed in the pods holding the claims
+func validateClaimCopy(options *MigrationOptions) error {
+	if options.CopyClaimData && options.ClaimCopyImage == "" {
+		return fmt.Errorf("--copy-pvc-data requires --pvc-copy-image, an image providing sshd and rsync")
+	}
+	return nil
+}
+
+// referencedClaims returns the names of the persistent volume claims mounted by the template of a service
+func referencedClaims(template serving_v1_api.RevisionTemplateSpec) []string {
+	names := []string{}
+	seen := map[string]bool{}
+	for _, volume := range template.Spec.Volumes {
+		if volume.PersistentVolumeClaim != nil && !seen[volume.PersistentVolumeClaim.ClaimName] {
+			seen[volume.PersistentVolumeClaim.ClaimName] = true
+			names = append(names, volume.PersistentVolumeClaim.ClaimName)
+		}
+	}
+	return names
+}
+
+func getPersistentVolumeClaim(ctx context.Context, clientSet kubernetes.Interface, namespace, name string) (*apiv1.PersistentVolumeClaim, error) {
+	return clientSet.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+}
+
+// buildPersistentVolumeClaim returns the claim to create in the destination namespace, without the volume, the
+// selector and the data source binding it to the source cluster, so that the destination provisions a new volume
+func buildPersistentVolumeClaim(namespace string, claim *apiv1.PersistentVolumeClaim) *apiv1.PersistentVolumeClaim {
+	meta := command.SanitizeObjectMeta(claim.ObjectMeta, namespace)
+	for _, key := range claimBindingAnnotations {
+		delete(meta.Annotations, key)
+	}
+	return &apiv1.PersistentVolumeClaim{
+		ObjectMeta: meta,
+		Spec: apiv1.PersistentVolumeClaimSpec{
+			AccessModes:      claim.Spec.AccessModes,
+			Resources:        claim.Spec.Resources,
+			StorageClassName: claim.Spec.StorageClassName,
+			VolumeMode:       claim.Spec.VolumeMode,
+		},
+	}
+}
+
+// sourceClientSet returns the client set of the cluster a source reads from, nil for bundles
+func sourceClientSet(source migrationSource) kubernetes.Interface {
+	switch s := source.(type) {
+	case *liveSource:
+		return s.clientSet
+	case snapshotSource:
+		return sourceClientSet(s.migrationSource)
+	}
+	return nil
+}
+
+// persistentVolumeClaimHandler creates the persistent volume claims mounted by the template of the service,
+// and copies their data before the service starts with CopyClaimData
+type persistentVolumeClaimHandler struct{}
+
+func (persistentVolumeClaimHandler) Discover(ctx context.Context, m *ServiceMigration) ([]runtime.Object, error) {
+	objects := []runtime.Object{}
+	for _, name := range referencedClaims(m.Service.Spec.Template) {
+		claimS, err := m.source.GetPersistentVolumeClaim(ctx, name)
+		if api_errors.IsNotFound(err) {
+			fmt.Fprintf(m.Options.out(), "no persistentvolumeclaim %s in the source for service %s, skip migrate persistentvolumeclaim\n", name, m.SourceName)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, claimS)
+	}
+	return objects, nil
+}
+
+func (persistentVolumeClaimHandler) Transform(ctx context.Context, m *ServiceMigration, objects []runtime.Object) ([]runtime.Object, error) {
+	transformed := []runtime.Object{}
+	for _, object := range objects {
+		claim := buildPersistentVolumeClaim(m.DestinationNamespace, object.(*apiv1.PersistentVolumeClaim))
+		m.Options.stampOwnership(&claim.ObjectMeta)
+		if class := claim.Spec.StorageClassName; class != nil && *class != "" {
+			_, err := m.ClientSetD.StorageV1().StorageClasses().Get(ctx, *class, metav1.GetOptions{})
+			if api_errors.IsNotFound(err) {
+				m.Options.warn("No storage class %s in the destination, persistentvolumeclaim %s uses the default storage class", *class, claim.Name)
+				claim.Spec.StorageClassName = nil
+			} else if err != nil {
+				return nil, destinationError(err)
+			}
+		}
+		transformed = append(transformed, claim)
+	}
+	return transformed, nil
+}
+
+// Apply creates the claims which do not exist in the destination, existing claims are kept with their data
+func (persistentVolumeClaimHandler) Apply(ctx context.Context, m *ServiceMigration, objects []runtime.Object) error {
+	for _, object := range objects {
+		claim := object.(*apiv1.PersistentVolumeClaim)
+		created, err := createIfAbsent(ctx, "persistentvolumeclaim "+claim.Name, m.Options, func() error {
+			_, err := m.ClientSetD.CoreV1().PersistentVolumeClaims(m.DestinationNamespace).Create(ctx, claim, metav1.CreateOptions{})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if !created {
+			continue
+		}
+		m.Options.changes().created("PersistentVolumeClaim", m.DestinationNamespace, claim.Name, m.ClientSetD, nil)
+		m.AddDependency("PersistentVolumeClaim", claim.Name)
+
+		clientSetS := sourceClientSet(m.source)
+		switch {
+		case !m.Options.CopyClaimData:
+			m.Options.warn("The data of persistentvolumeclaim %s is not copied to the destination, use --copy-pvc-data to copy it", claim.Name)
+		case clientSetS == nil:
+			m.Options.warn("The data of persistentvolumeclaim %s cannot be copied without the source cluster", claim.Name)
+		default:
+			err = copyClaimData(ctx, clientSetS, m.ClientSetD, m.SourceNamespace, m.DestinationNamespace, claim.Name, m.Options)
+			if err != nil {
+				return fmt.Errorf("cannot copy the data of persistentvolumeclaim %s: %w", claim.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (persistentVolumeClaimHandler) Verify(ctx context.Context, m *ServiceMigration, objects []runtime.Object) error {
+	return nil
+}
+
+// claimCopy names the objects copying the data of a persistent volume claim, and holds the SSH keys generated
+// for the copy: the source daemon only accepts the client key, and the destination only trusts the host key
+type claimCopy struct {
+	name      string
+	labels    map[string]string
+	hostKey   *ecdsa.PrivateKey
+	clientKey *ecdsa.PrivateKey
+}
+
+func newClaimCopy(claim string) (*claimCopy, error) {
+	name := claim + "-pvc-copy"
+	// The name of the daemon service must be a DNS label
+	if len(name) > 63 {
+		name = claim[:63-len("-pvc-copy")] + "-pvc-copy"
+	}
+	hostKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &claimCopy{
+		name:      name,
+		labels:    map[string]string{"app.kubernetes.io/managed-by": claimCopyManager, claimCopyLabel: name},
+		hostKey:   hostKey,
+		clientKey: clientKey,
+	}, nil
+}
+
+// sshPublicKey returns a public key in the format of the authorized_keys and known_hosts files of OpenSSH
+func sshPublicKey(key *ecdsa.PublicKey) string {
+	var blob []byte
+	for _, field := range [][]byte{[]byte(claimCopyKeyType), []byte("nistp256"), elliptic.Marshal(key.Curve, key.X, key.Y)} {
+		blob = binary.BigEndian.AppendUint32(blob, uint32(len(field)))
+		blob = append(blob, field...)
+	}
+	return claimCopyKeyType + " " + base64.StdEncoding.EncodeToString(blob)
+}
+
+// sshPrivateKey returns a private key in the PEM format read by OpenSSH
+func sshPrivateKey(key *ecdsa.PrivateKey) (string, error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})), nil
+}
+
+// sourceSecret holds the host key of the SSH daemon and the client key it accepts, without any private key of the
+// client, so that the source cluster cannot impersonate the destination
+func (c *claimCopy) sourceSecret(namespace string) (*apiv1.Secret, error) {
+	hostKey, err := sshPrivateKey(c.hostKey)
+	if err != nil {
+		return nil, err
+	}
+	return &apiv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: c.name, Namespace: namespace, Labels: c.labels},
+		StringData: map[string]string{
+			"host_key": hostKey,
+			// The key is forced to run the rsync server sending the data, without a terminal nor port forwarding
+			"authorized_keys": `command="` + claimCopyServerCommand + `",restrict ` + sshPublicKey(&c.clientKey.PublicKey) + "\n",
+		},
+	}, nil
+}
+
+// destinationSecret holds the client key of the copy job and the host key of the source daemon it trusts
+func (c *claimCopy) destinationSecret(namespace string) (*apiv1.Secret, error) {
+	clientKey, err := sshPrivateKey(c.clientKey)
+	if err != nil {
+		return nil, err
+	}
+	return &apiv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: c.name, Namespace: namespace, Labels: c.labels},
+		StringData: map[string]string{
+			"id":          clientKey,
+			"known_hosts": "* " + sshPublicKey(&c.hostKey.PublicKey) + "\n",
+		},
+	}, nil
+}
+
+// claimCopyDaemonScript starts the SSH daemon serving the data of the source claim, accepting only the client key
+// of the copy. Nothing is installed: an image without sshd or rsync fails at once.
+const claimCopyDaemonScript = `set -e
+command -v sshd >/dev/null || { echo "the image has no sshd to serve the data" >&2; exit 1; }
+command -v rsync >/dev/null || { echo "the image has no rsync to send the data" >&2; exit 1; }
+mkdir -p /root/.ssh /run/sshd
+cp /etc/pvc-copy/authorized_keys /root/.ssh/authorized_keys
+chmod 700 /root/.ssh
+chmod 600 /root/.ssh/authorized_keys
+exec "$(command -v sshd)" -D -e -p %d -h /etc/pvc-copy/host_key -o PasswordAuthentication=no -o PermitRootLogin=prohibit-password -o AllowTcpForwarding=no -o AuthorizedKeysFile=/root/.ssh/authorized_keys
+`
+
+// daemon is the pod serving the data of the source claim, mounted read-only, over SSH. A pod pinned to a node
+// runs next to the pods already mounting a ReadWriteOnce claim.
+func (c *claimCopy) daemon(namespace, claim, image, node string) *apiv1.Pod {
+	mode := int32(0400)
+	return &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: c.name, Namespace: namespace, Labels: c.labels},
+		Spec: apiv1.PodSpec{
+			NodeName:      node,
+			RestartPolicy: apiv1.RestartPolicyNever,
+			Containers: []apiv1.Container{{
+				Name:    "sshd",
+				Image:   image,
+				Command: []string{"sh", "-c", fmt.Sprintf(claimCopyDaemonScript, claimCopyPort)},
+				Ports:   []apiv1.ContainerPort{{Name: "ssh", ContainerPort: claimCopyPort}},
+				VolumeMounts: []apiv1.VolumeMount{
+					{Name: "data", MountPath: "/data", ReadOnly: true},
+					{Name: "keys", MountPath: "/etc/pvc-copy", ReadOnly: true},
+				},
+			}},
+			Volumes: []apiv1.Volume{
+				{Name: "data", VolumeSource: apiv1.VolumeSource{PersistentVolumeClaim: &apiv1.PersistentVolumeClaimVolumeSource{ClaimName: claim, ReadOnly: true}}},
+				{Name: "keys", VolumeSource: apiv1.VolumeSource{Secret: &apiv1.SecretVolumeSource{SecretName: c.name, DefaultMode: &mode}}},
+			},
+		},
+	}
+}
+
+// service exposes the SSH daemon to the destination cluster, with an internal load balancer unless told otherwise
+func (c *claimCopy) service(namespace string, exposure ClaimCopyExposure) *apiv1.Service {
+	service := &apiv1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: c.name, Namespace: namespace, Labels: c.labels},
+		Spec: apiv1.ServiceSpec{
+			Type:     apiv1.ServiceTypeLoadBalancer,
+			Selector: c.labels,
+			Ports:    []apiv1.ServicePort{{Name: "ssh", Port: claimCopyPort, TargetPort: intstr.FromString("ssh")}},
+		},
+	}
+	switch exposure {
+	case ClaimCopyExposeNodePort:
+		service.Spec.Type = apiv1.ServiceTypeNodePort
+	case ClaimCopyExposePublic:
+	default:
+		// A copy, so that the annotations of the service never change the shared ones
+		service.Annotations = make(map[string]string, len(internalLoadBalancerAnnotations))
+		for key, value := range internalLoadBalancerAnnotations {
+			service.Annotations[key] = value
+		}
+	}
+	return service
+}
+
+// job pulls the data of the source claim from the SSH daemon into the destination claim, checking the host key
+// of the daemon, with the rsync -a options the daemon forces in claimCopyServerCommand
+func (c *claimCopy) job(namespace, claim, image, address string, port int32) *batchv1.Job {
+	backoffLimit := int32(2)
+	mode := int32(0400)
+	host, hostPort := address, fmt.Sprint(port)
+	ssh := strings.Join([]string{"ssh", "-p", hostPort, "-i", "/etc/pvc-copy/id", "-o", "UserKnownHostsFile=/etc/pvc-copy/known_hosts", "-o", "StrictHostKeyChecking=yes", "-o", "BatchMode=yes"}, " ")
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: c.name, Namespace: namespace, Labels: c.labels},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: apiv1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: c.labels},
+				Spec: apiv1.PodSpec{
+					RestartPolicy: apiv1.RestartPolicyNever,
+					Containers: []apiv1.Container{{
+						Name:    "rsync",
+						Image:   image,
+						Command: []string{"rsync", "-a", "-e", ssh, "root@" + host + ":/data/", "/data/"},
+						VolumeMounts: []apiv1.VolumeMount{
+							{Name: "data", MountPath: "/data"},
+							{Name: "keys", MountPath: "/etc/pvc-copy", ReadOnly: true},
+						},
+					}},
+					Volumes: []apiv1.Volume{
+						{Name: "data", VolumeSource: apiv1.VolumeSource{PersistentVolumeClaim: &apiv1.PersistentVolumeClaimVolumeSource{ClaimName: claim}}},
+						{Name: "keys", VolumeSource: apiv1.VolumeSource{Secret: &apiv1.SecretVolumeSource{SecretName: c.name, DefaultMode: &mode}}},
+					},
+				},
+			},
+		},
+	}
+}
+
+// claimNode returns the node the daemon of a claim must run on: a claim only attached to one node at a time which
+// is mounted by a running pod is served from the node of the pod, and a ReadWriteOncePod claim mounted by a pod
+// cannot be served at all
+func claimNode(ctx context.Context, clientSet kubernetes.Interface, namespace string, claim *apiv1.PersistentVolumeClaim) (string, error) {
+	modes := map[apiv1.PersistentVolumeAccessMode]bool{}
+	for _, mode := range claim.Spec.AccessModes {
+		modes[mode] = true
+	}
+	if modes[apiv1.ReadOnlyMany] || modes[apiv1.ReadWriteMany] {
+		return "", nil
+	}
+	pods, err := clientSet.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return "", sourceError(err)
+	}
+	for _, pod := range pods.Items {
+		if pod.Status.Phase == apiv1.PodSucceeded || pod.Status.Phase == apiv1.PodFailed || pod.Spec.NodeName == "" {
+			continue
+		}
+		for _, volume := range pod.Spec.Volumes {
+			if volume.PersistentVolumeClaim == nil || volume.PersistentVolumeClaim.ClaimName != claim.Name {
+				continue
+			}
+			if modes[apiv1.ReadWriteOncePod] {
+				return "", fmt.Errorf("the ReadWriteOncePod claim is mounted by pod %s, scale its service to zero in the source to copy its data", pod.Name)
+			}
+			return pod.Spec.NodeName, nil
+		}
+	}
+	return "", nil
+}
+
+// copyClaimData copies the data of a persistent volume claim with rsync over SSH: a daemon mounting the source
+// claim read-only is exposed by a service of the source cluster, by default an internal load balancer, and a Job of
+// the destination cluster pulls the data into the destination claim. Both ends authenticate with SSH keys
+// generated for the copy. The objects of the copy are deleted from both clusters once it is done or failed.
+func copyClaimData(ctx context.Context, clientSetS, clientSetD kubernetes.Interface, namespaceS, namespaceD, claim string, options *MigrationOptions) error {
+	c, err := newClaimCopy(claim)
+	if err != nil {
+		return err
+	}
+	claimS, err := getPersistentVolumeClaim(ctx, clientSetS, namespaceS, claim)
+	if err != nil {
+		return sourceError(err)
+	}
+	node, err := claimNode(ctx, clientSetS, namespaceS, claimS)
+	if err != nil {
+		return err
+	}
+	sourceSecret, err := c.sourceSecret(namespaceS)
+	if err != nil {
+		return err
+	}
+	destinationSecret, err := c.destinationSecret(namespaceD)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(options.out(), "Copy the data of persistentvolumeclaim", color.CyanString(claim))
+	defer func() {
+		// The objects of the copy are deleted even when the migration is interrupted
+		ctx := context.Background()
+		propagation := metav1.DeletePropagationBackground
+		_ = clientSetD.BatchV1().Jobs(namespaceD).Delete(ctx, c.name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+		_ = clientSetD.CoreV1().Secrets(namespaceD).Delete(ctx, c.name, metav1.DeleteOptions{})
+		_ = clientSetS.CoreV1().Services(namespaceS).Delete(ctx, c.name, metav1.DeleteOptions{})
+		_ = clientSetS.CoreV1().Pods(namespaceS).Delete(ctx, c.name, metav1.DeleteOptions{})
+		_ = clientSetS.CoreV1().Secrets(namespaceS).Delete(ctx, c.name, metav1.DeleteOptions{})
+	}()
+
+	_, err = clientSetS.CoreV1().Secrets(namespaceS).Create(ctx, sourceSecret, metav1.CreateOptions{})
+	if err != nil {
+		return sourceError(err)
+	}
+	_, err = clientSetS.CoreV1().Pods(namespaceS).Create(ctx, c.daemon(namespaceS, claim, options.ClaimCopyImage, node), metav1.CreateOptions{})
+	if err != nil {
+		return sourceError(err)
+	}
+	_, err = clientSetS.CoreV1().Services(namespaceS).Create(ctx, c.service(namespaceS, options.ClaimCopyExpose), metav1.CreateOptions{})
+	if err != nil {
+		return sourceError(err)
+	}
+	address, port, err := waitForClaimDaemon(ctx, clientSetS, namespaceS, c.name, options.ClaimCopyTimeout)
+	if err != nil {
+		return err
+	}
+
+	_, err = clientSetD.CoreV1().Secrets(namespaceD).Create(ctx, destinationSecret, metav1.CreateOptions{})
+	if err != nil {
+		return destinationError(err)
+	}
+	_, err = clientSetD.BatchV1().Jobs(namespaceD).Create(ctx, c.job(namespaceD, claim, options.ClaimCopyImage, address, port), metav1.CreateOptions{})
+	if err != nil {
+		return destinationError(err)
+	}
+	err = waitForClaimJob(ctx, clientSetD, namespaceD, c.name, options.ClaimCopyTimeout)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(options.out(), "Copied the data of persistentvolumeclaim", color.CyanString(claim), "Successfully")
+	return nil
+}
+
+// waitForClaimDaemon waits until the SSH daemon runs and its service is reachable, and returns the address and the
+// port to reach it at: the address of its load balancer, or the address of its node with a NodePort service
+func waitForClaimDaemon(ctx context.Context, clientSet kubernetes.Interface, namespace, name string, timeout time.Duration) (string, int32, error) {
+	address := ""
+	port := int32(claimCopyPort)
+	err := wait.PollImmediateWithContext(ctx, claimCopyPollInterval, timeout, func(ctx context.Context) (bool, error) {
+		pod, err := clientSet.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, sourceError(err)
+		}
+		if pod.Status.Phase == apiv1.PodFailed || pod.Status.Phase == apiv1.PodSucceeded {
+			return false, fmt.Errorf("the SSH daemon of the source stopped: %s", pod.Status.Message)
+		}
+		service, err := clientSet.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, sourceError(err)
+		}
+		if service.Spec.Type == apiv1.ServiceTypeNodePort {
+			address = pod.Status.HostIP
+			if len(service.Spec.Ports) > 0 {
+				port = service.Spec.Ports[0].NodePort
+			}
+		}
+		for _, ingress := range service.Status.LoadBalancer.Ingress {
+			address = ingress.IP
+			if address == "" {
+				address = ingress.Hostname
+			}
+		}
+		return pod.Status.Phase == apiv1.PodRunning && address != "" && port != 0, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return "", 0, fmt.Errorf("the SSH daemon of the source is not exposed after %s, does the source cluster provision %s services?", timeout, "LoadBalancer or NodePort")
+	}
+	return address, port, err
+}
+
+// waitForClaimJob waits until the Job copying the data succeeded, failing when it exhausted its retries
+func waitForClaimJob(ctx context.Context, clientSet kubernetes.Interface, namespace, name string, timeout time.Duration) error {
+	err := wait.PollImmediateWithContext(ctx, claimCopyPollInterval, timeout, func(ctx context.Context) (bool, error) {
+		job, err := clientSet.BatchV1().Jobs(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, destinationError(err)
+		}
+		for _, condition := range job.Status.Conditions {
+			if condition.Type == batchv1.JobFailed && condition.Status == apiv1.ConditionTrue {
+				return false, fmt.Errorf("the copy job failed: %s", condition.Message)
+			}
+		}
+		return job.Status.Succeeded > 0, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("the copy job did not complete after %s", timeout)
+	}
+	return err
+}
@@ -0,0 +1,233 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+	batchv1 "k8s.io/api/batch/v1"
+	apiv1 "k8s.io/api/core/v1"
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8s_fake "k8s.io/client-go/kubernetes/fake"
+	k8s_testing "k8s.io/client-go/testing"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+func newBoundClaim(name, class string) *apiv1.PersistentVolumeClaim {
+	return &apiv1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Annotations: map[string]string{"pv.kubernetes.io/bind-completed": "yes", "team": "a"}},
+		Spec: apiv1.PersistentVolumeClaimSpec{
+			AccessModes:      []apiv1.PersistentVolumeAccessMode{apiv1.ReadWriteOnce},
+			StorageClassName: &class,
+			VolumeName:       "pvc-1234",
+		},
+	}
+}
+
+func newClaimMigration(clientSetS, clientSetD *k8s_fake.Clientset, options *MigrationOptions) *ServiceMigration {
+	service := &serving_v1_api.Service{ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "default"}}
+	service.Spec.Template.Spec.Volumes = []apiv1.Volume{{Name: "data", VolumeSource: apiv1.VolumeSource{PersistentVolumeClaim: &apiv1.PersistentVolumeClaimVolumeSource{ClaimName: "hello-data"}}}}
+	return &ServiceMigration{
+		SourceName:           "hello",
+		Service:              service,
+		SourceNamespace:      "default",
+		DestinationNamespace: "prod",
+		ClientSetD:           clientSetD,
+		Options:              options,
+		source:               newLiveSource(clientSetS, nil, "default"),
+	}
+}
+
+func migrateClaims(t *testing.T, m *ServiceMigration) {
+	handler := persistentVolumeClaimHandler{}
+	objects, err := handler.Discover(context.Background(), m)
+	assert.NilError(t, err)
+	objects, err = handler.Transform(context.Background(), m, objects)
+	assert.NilError(t, err)
+	assert.NilError(t, handler.Apply(context.Background(), m, objects))
+}
+
+func TestPersistentVolumeClaimHandler(t *testing.T) {
+	clientSetS := k8s_fake.NewSimpleClientset(newBoundClaim("hello-data", "fast"))
+	clientSetD := k8s_fake.NewSimpleClientset()
+	options := NewMigrationOptions()
+	options.RunID = "run"
+	m := newClaimMigration(clientSetS, clientSetD, options)
+	migrateClaims(t, m)
+
+	// The claim is provisioned again by the destination with its default storage class
+	claim, err := clientSetD.CoreV1().PersistentVolumeClaims("prod").Get(context.Background(), "hello-data", metav1.GetOptions{})
+	assert.NilError(t, err)
+	assert.Equal(t, claim.Spec.VolumeName, "")
+	assert.Assert(t, claim.Spec.StorageClassName == nil)
+	assert.DeepEqual(t, claim.Annotations, map[string]string{"team": "a", managedByAnnotation: migrationComponent, runIDAnnotation: "run"})
+	assert.DeepEqual(t, m.dependencies, []string{"PersistentVolumeClaim hello-data"})
+	assert.DeepEqual(t, options.warnings(), []string{
+		"No storage class fast in the destination, persistentvolumeclaim hello-data uses the default storage class",
+		"The data of persistentvolumeclaim hello-data is not copied to the destination, use --copy-pvc-data to copy it",
+	})
+
+	// An existing claim is kept with its data
+	m = newClaimMigration(clientSetS, clientSetD, NewMigrationOptions())
+	m.Options.CopyClaimData = true
+	migrateClaims(t, m)
+	assert.Equal(t, len(m.dependencies), 0)
+	assert.DeepEqual(t, m.Options.warnings(), []string{"No storage class fast in the destination, persistentvolumeclaim hello-data uses the default storage class"})
+}
+
+// copyClaimReactors make the fake clusters run the SSH daemon, expose it and complete the copy job as soon as they
+// are created, and record the daemon, its service and the job
+func copyClaimReactors(clientSetS, clientSetD *k8s_fake.Clientset) (*apiv1.Pod, *apiv1.Service, *batchv1.Job) {
+	daemon, service, job := &apiv1.Pod{}, &apiv1.Service{}, &batchv1.Job{}
+	clientSetS.PrependReactor("create", "pods", func(action k8s_testing.Action) (bool, runtime.Object, error) {
+		pod := action.(k8s_testing.CreateAction).GetObject().(*apiv1.Pod)
+		pod.Status.Phase = apiv1.PodRunning
+		pod.Status.HostIP = "10.0.0.4"
+		*daemon = *pod
+		return false, nil, nil
+	})
+	clientSetS.PrependReactor("create", "services", func(action k8s_testing.Action) (bool, runtime.Object, error) {
+		created := action.(k8s_testing.CreateAction).GetObject().(*apiv1.Service)
+		if created.Spec.Type == apiv1.ServiceTypeNodePort {
+			created.Spec.Ports[0].NodePort = 30222
+		} else {
+			created.Status.LoadBalancer.Ingress = []apiv1.LoadBalancerIngress{{IP: "10.1.0.7"}}
+		}
+		*service = *created
+		return false, nil, nil
+	})
+	clientSetD.PrependReactor("create", "jobs", func(action k8s_testing.Action) (bool, runtime.Object, error) {
+		created := action.(k8s_testing.CreateAction).GetObject().(*batchv1.Job)
+		created.Status.Succeeded = 1
+		*job = *created
+		return false, nil, nil
+	})
+	return daemon, service, job
+}
+
+func claimMountingPod(name, node string) *apiv1.Pod {
+	return &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: apiv1.PodSpec{
+			NodeName: node,
+			Volumes:  []apiv1.Volume{{Name: "data", VolumeSource: apiv1.VolumeSource{PersistentVolumeClaim: &apiv1.PersistentVolumeClaimVolumeSource{ClaimName: "hello-data"}}}},
+		},
+		Status: apiv1.PodStatus{Phase: apiv1.PodRunning},
+	}
+}
+
+func TestCopyClaimData(t *testing.T) {
+	clientSetS := k8s_fake.NewSimpleClientset(newBoundClaim("hello-data", "fast"), claimMountingPod("hello-00001-deployment-abc", "node-2"))
+	clientSetD := k8s_fake.NewSimpleClientset()
+	daemon, service, job := copyClaimReactors(clientSetS, clientSetD)
+
+	options := NewMigrationOptions()
+	options.CopyClaimData = true
+	migrateClaims(t, newClaimMigration(clientSetS, clientSetD, options))
+	// The data is pulled over SSH from an internal load balancer, trusting the host key of the daemon only
+	assert.DeepEqual(t, job.Spec.Template.Spec.Containers[0].Command, []string{"rsync", "-a", "-e",
+		"ssh -p 2222 -i /etc/pvc-copy/id -o UserKnownHostsFile=/etc/pvc-copy/known_hosts -o StrictHostKeyChecking=yes -o BatchMode=yes",
+		"root@10.1.0.7:/data/", "/data/"})
+	assert.Equal(t, job.Spec.Template.Spec.Volumes[0].PersistentVolumeClaim.ClaimName, "hello-data")
+	assert.Equal(t, service.Spec.Type, apiv1.ServiceTypeLoadBalancer)
+	assert.Equal(t, service.Annotations["service.beta.kubernetes.io/aws-load-balancer-internal"], "true")
+	// The service gets its own copy of the annotations
+	service.Annotations["team"] = "a"
+	assert.Equal(t, internalLoadBalancerAnnotations["team"], "")
+	// The ReadWriteOnce claim is served from the node of the pod mounting it
+	assert.Equal(t, daemon.Spec.NodeName, "node-2")
+	assert.Assert(t, daemon.Spec.Volumes[0].PersistentVolumeClaim.ReadOnly)
+	assert.Equal(t, len(options.warnings()), 1)
+
+	// The objects of the copy are deleted from both clusters
+	_, err := clientSetS.CoreV1().Pods("default").Get(context.Background(), "hello-data-pvc-copy", metav1.GetOptions{})
+	assert.Assert(t, api_errors.IsNotFound(err))
+	_, err = clientSetS.CoreV1().Secrets("default").Get(context.Background(), "hello-data-pvc-copy", metav1.GetOptions{})
+	assert.Assert(t, api_errors.IsNotFound(err))
+	_, err = clientSetD.BatchV1().Jobs("prod").Get(context.Background(), "hello-data-pvc-copy", metav1.GetOptions{})
+	assert.Assert(t, api_errors.IsNotFound(err))
+	_, err = clientSetD.CoreV1().Secrets("prod").Get(context.Background(), "hello-data-pvc-copy", metav1.GetOptions{})
+	assert.Assert(t, api_errors.IsNotFound(err))
+}
+
+func TestCopyClaimDataNodePort(t *testing.T) {
+	clientSetS := k8s_fake.NewSimpleClientset(newBoundClaim("hello-data", "fast"))
+	clientSetD := k8s_fake.NewSimpleClientset()
+	daemon, service, job := copyClaimReactors(clientSetS, clientSetD)
+
+	options := NewMigrationOptions()
+	options.CopyClaimData = true
+	assert.NilError(t, options.ClaimCopyExpose.Set("nodeport"))
+	migrateClaims(t, newClaimMigration(clientSetS, clientSetD, options))
+	assert.Equal(t, service.Spec.Type, apiv1.ServiceTypeNodePort)
+	assert.Equal(t, len(service.Annotations), 0)
+	assert.Equal(t, daemon.Spec.NodeName, "")
+	command := job.Spec.Template.Spec.Containers[0].Command
+	assert.Equal(t, command[3], "ssh -p 30222 -i /etc/pvc-copy/id -o UserKnownHostsFile=/etc/pvc-copy/known_hosts -o StrictHostKeyChecking=yes -o BatchMode=yes")
+	assert.Equal(t, command[4], "root@10.0.0.4:/data/")
+
+	assert.ErrorContains(t, options.ClaimCopyExpose.Set("ingress"), "unsupported pvc copy exposure")
+}
+
+func TestCopyClaimDataReadWriteOncePod(t *testing.T) {
+	claim := newBoundClaim("hello-data", "fast")
+	claim.Spec.AccessModes = []apiv1.PersistentVolumeAccessMode{apiv1.ReadWriteOncePod}
+	clientSetS := k8s_fake.NewSimpleClientset(claim, claimMountingPod("hello-00001-deployment-abc", "node-2"))
+	clientSetD := k8s_fake.NewSimpleClientset()
+
+	options := NewMigrationOptions()
+	options.CopyClaimData = true
+	m := newClaimMigration(clientSetS, clientSetD, options)
+	handler := persistentVolumeClaimHandler{}
+	objects, err := handler.Discover(context.Background(), m)
+	assert.NilError(t, err)
+	objects, err = handler.Transform(context.Background(), m, objects)
+	assert.NilError(t, err)
+	// The copy fails before creating anything in the source
+	assert.ErrorContains(t, handler.Apply(context.Background(), m, objects), "mounted by pod hello-00001-deployment-abc")
+	pods, err := clientSetS.CoreV1().Pods("default").List(context.Background(), metav1.ListOptions{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(pods.Items), 1)
+}
+
+func TestValidateClaimCopy(t *testing.T) {
+	options := NewMigrationOptions()
+	assert.NilError(t, validateClaimCopy(options))
+	options.CopyClaimData = true
+	assert.ErrorContains(t, validateClaimCopy(options), "--copy-pvc-data requires --pvc-copy-image")
+	options.ClaimCopyImage = "registry.corp/tools/rsync-sshd:3.2"
+	assert.NilError(t, validateClaimCopy(options))
+}
+
+func TestClaimCopySecrets(t *testing.T) {
+	c, err := newClaimCopy("hello-data")
+	assert.NilError(t, err)
+	source, err := c.sourceSecret("default")
+	assert.NilError(t, err)
+	destination, err := c.destinationSecret("prod")
+	assert.NilError(t, err)
+	// Every cluster only holds its own private key and the public key of the other end
+	assert.Assert(t, strings.HasPrefix(source.StringData["authorized_keys"], `command="rsync --server --sender -logDtpr . /data/",restrict ecdsa-sha2-nistp256 `))
+	assert.Assert(t, strings.HasPrefix(destination.StringData["known_hosts"], "* ecdsa-sha2-nistp256 "))
+	assert.Equal(t, strings.TrimPrefix(destination.StringData["known_hosts"], "* "), sshPublicKey(&c.hostKey.PublicKey)+"\n")
+	assert.Assert(t, strings.Contains(source.StringData["host_key"], "EC PRIVATE KEY"))
+	assert.Assert(t, strings.Contains(destination.StringData["id"], "EC PRIVATE KEY"))
+	assert.Assert(t, source.StringData["host_key"] != destination.StringData["id"])
+}
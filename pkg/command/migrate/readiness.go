@@ -0,0 +1,181 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"knative.dev/kn-plugin-migration/pkg/command"
+)
+
+const (
+	// readinessPollInterval is the interval between two readiness checks of destination services
+	readinessPollInterval = 2 * time.Second
+	// revisionPollInterval is the interval between two readiness checks of a migrated revision
+	revisionPollInterval = 500 * time.Millisecond
+)
+
+// waitForServicesReady waits until every named service reports Ready in the destination,
+// failing early with ErrVerificationFailed if a service reports a failed Ready condition
+func waitForServicesReady(ctx context.Context, migrationClient command.MigrationClient, names []string, timeout time.Duration) error {
+	pending := map[string]bool{}
+	for _, name := range names {
+		pending[name] = true
+	}
+
+	var failed []string
+	err := wait.PollImmediateWithContext(ctx, readinessPollInterval, timeout, func(ctx context.Context) (bool, error) {
+		for name := range pending {
+			service, err := migrationClient.GetService(ctx, name)
+			if err != nil {
+				return false, err
+			}
+			if service.IsReady() {
+				delete(pending, name)
+				continue
+			}
+			if service.IsFailed() {
+				failed = append(failed, name)
+			}
+		}
+		return len(pending) == 0 || len(failed) > 0, nil
+	})
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return newMigrationError(ErrVerificationFailed, fmt.Errorf("service(s) %s failed to become Ready", strings.Join(failed, ", ")))
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err == wait.ErrWaitTimeout {
+		return newMigrationError(ErrVerificationFailed, fmt.Errorf("service(s) %s are not Ready after %s", strings.Join(sortedKeys(pending), ", "), timeout))
+	}
+	return err
+}
+
+// waitForServiceReady waits until a migrated service is Ready in the destination, and fails with
+// ErrVerificationFailed if it reports a failed Ready condition or is not Ready within the timeout
+func waitForServiceReady(ctx context.Context, out io.Writer, migrationClient command.MigrationClient, name string, timeout time.Duration) error {
+	started := time.Now()
+	fmt.Fprintln(out, "Waiting for service", color.CyanString(name), "to be Ready in the destination")
+	if err := waitForServicesReady(ctx, migrationClient, []string{name}, timeout); err != nil {
+		return err
+	}
+	fmt.Fprintln(out, "Service", color.CyanString(name), "is Ready in the destination after", time.Since(started).Round(time.Millisecond))
+	return nil
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// waitForRevisionReady waits until a migrated revision reports Ready in the destination, so that the controllers
+// caught up before the next revision is migrated. A revision which failed or is not Ready within the timeout
+// is reported but does not fail the migration, since old revisions may legitimately not be able to start.
+// A revision which cannot be read, because it is gone or the destination forbids it, fails the migration,
+// while other errors are retried until the timeout.
+func waitForRevisionReady(ctx context.Context, migrationClient command.MigrationClient, name string, options *MigrationOptions) error {
+	timeout := options.RevisionTimeout
+	failed := false
+	var lastErr error
+	err := wait.PollImmediateWithContext(ctx, revisionPollInterval, timeout, func(ctx context.Context) (bool, error) {
+		revision, err := migrationClient.GetRevision(ctx, name)
+		if err != nil {
+			if api_errors.IsNotFound(err) || api_errors.IsForbidden(err) || api_errors.IsUnauthorized(err) {
+				return false, err
+			}
+			lastErr = err
+			return false, nil
+		}
+		failed = revision.IsFailed()
+		return revision.IsReady() || failed, nil
+	})
+	switch {
+	case ctx.Err() != nil:
+		// Interrupted, the next call fails with the error of the context
+	case failed:
+		options.warn("Revision %s failed to become Ready in the destination, continue with the next revision", name)
+	case err == wait.ErrWaitTimeout && lastErr != nil:
+		options.warn("Cannot get the readiness of revision %s in the destination after %s, continue with the next revision: %s", name, timeout, lastErr.Error())
+	case err == wait.ErrWaitTimeout:
+		options.warn("Revision %s is not Ready after %s in the destination, continue with the next revision", name, timeout)
+	case err != nil:
+		return destinationError(fmt.Errorf("cannot get the readiness of revision %s: %w", name, err))
+	}
+	return nil
+}
+
+// waitForLatestRevisionReady waits until the destination reconciled the current generation of a migrated service,
+// and the latest revision it created for this generation reports Ready. The revision is the only one of the service,
+// so that a revision which failed or is not Ready within the timeout fails the service with ErrVerificationFailed.
+func waitForLatestRevisionReady(ctx context.Context, migrationClient command.MigrationClient, serviceName string, options *MigrationOptions) error {
+	name := ""
+	err := wait.PollImmediateWithContext(ctx, revisionPollInterval, options.RevisionTimeout, func(ctx context.Context) (bool, error) {
+		service, err := migrationClient.GetService(ctx, serviceName)
+		if err != nil {
+			return false, err
+		}
+		// The latest created revision of an earlier generation is not the revision of the migrated definition
+		if service.Status.ObservedGeneration != service.Generation {
+			return false, nil
+		}
+		name = service.Status.LatestCreatedRevisionName
+		return name != "", nil
+	})
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err == wait.ErrWaitTimeout {
+		return newMigrationError(ErrVerificationFailed, fmt.Errorf("service %s created no revision after %s in the destination", serviceName, options.RevisionTimeout))
+	}
+	if err != nil {
+		return destinationError(err)
+	}
+
+	fmt.Fprintln(options.out(), "Waiting for revision", color.CyanString(name), "of service", color.CyanString(serviceName), "to be Ready in the destination")
+	failed := false
+	err = wait.PollImmediateWithContext(ctx, revisionPollInterval, options.RevisionTimeout, func(ctx context.Context) (bool, error) {
+		revision, err := migrationClient.GetRevision(ctx, name)
+		if err != nil {
+			return false, err
+		}
+		failed = revision.IsFailed()
+		return revision.IsReady() || failed, nil
+	})
+	switch {
+	case ctx.Err() != nil:
+		return ctx.Err()
+	case failed:
+		return newMigrationError(ErrVerificationFailed, fmt.Errorf("revision %s of service %s failed to become Ready in the destination", name, serviceName))
+	case err == wait.ErrWaitTimeout:
+		return newMigrationError(ErrVerificationFailed, fmt.Errorf("revision %s of service %s is not Ready after %s in the destination", name, serviceName, options.RevisionTimeout))
+	case err != nil:
+		return destinationError(err)
+	}
+	return nil
+}
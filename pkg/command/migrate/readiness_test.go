@@ -0,0 +1,152 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+	apiv1 "k8s.io/api/core/v1"
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8s_testing "k8s.io/client-go/testing"
+	"knative.dev/kn-plugin-migration/pkg/command"
+	"knative.dev/pkg/apis"
+	duckv1 "knative.dev/pkg/apis/duck/v1"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+	serving_fake "knative.dev/serving/pkg/client/clientset/versioned/fake"
+)
+
+func newReadinessService(generation, observed int64, latest string) *serving_v1_api.Service {
+	service := &serving_v1_api.Service{ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "default", Generation: generation}}
+	service.Status.ObservedGeneration = observed
+	service.Status.LatestCreatedRevisionName = latest
+	return service
+}
+
+func newReadinessRevision(name string, status apiv1.ConditionStatus) *serving_v1_api.Revision {
+	revision := &serving_v1_api.Revision{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}}
+	revision.Status.Conditions = duckv1.Conditions{{Type: apis.ConditionReady, Status: status}}
+	return revision
+}
+
+func TestWaitForLatestRevisionReady(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		service  *serving_v1_api.Service
+		revision *serving_v1_api.Revision
+		err      string
+	}{
+		{"ready", newReadinessService(2, 2, "hello-00002"), newReadinessRevision("hello-00002", apiv1.ConditionTrue), ""},
+		{"failed", newReadinessService(2, 2, "hello-00002"), newReadinessRevision("hello-00002", apiv1.ConditionFalse), "revision hello-00002 of service hello failed to become Ready"},
+		{"not ready", newReadinessService(2, 2, "hello-00002"), newReadinessRevision("hello-00002", apiv1.ConditionUnknown), "revision hello-00002 of service hello is not Ready after"},
+		// The latest revision of the previous generation is not waited for
+		{"not reconciled", newReadinessService(2, 1, "hello-00001"), newReadinessRevision("hello-00001", apiv1.ConditionTrue), "service hello created no revision after"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			migrationClient := command.NewMigrationClient(serving_fake.NewSimpleClientset(tc.service, tc.revision).ServingV1(), "default")
+			options := NewMigrationOptions()
+			options.RevisionTimeout = time.Second
+			err := waitForLatestRevisionReady(context.Background(), migrationClient, "hello", options)
+			if tc.err == "" {
+				assert.NilError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tc.err)
+			assert.Assert(t, errors.Is(err, ErrVerificationFailed))
+		})
+	}
+}
+
+func TestWaitForRevisionReady(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		revision *serving_v1_api.Revision
+		reactor  k8s_testing.ReactionFunc
+		err      string
+		warning  string
+	}{
+		{"ready", newReadinessRevision("hello-00001", apiv1.ConditionTrue), nil, "", ""},
+		{"failed", newReadinessRevision("hello-00001", apiv1.ConditionFalse), nil, "", "Revision hello-00001 failed to become Ready"},
+		{"not ready", newReadinessRevision("hello-00001", apiv1.ConditionUnknown), nil, "", "Revision hello-00001 is not Ready after"},
+		{"not found", newReadinessRevision("hello-00002", apiv1.ConditionTrue), nil, "cannot get the readiness of revision hello-00001", ""},
+		{"forbidden", newReadinessRevision("hello-00001", apiv1.ConditionTrue), func(action k8s_testing.Action) (bool, runtime.Object, error) {
+			return true, nil, api_errors.NewForbidden(serving_v1_api.Resource("revisions"), "hello-00001", errors.New("denied"))
+		}, "cannot get the readiness of revision hello-00001", ""},
+		// Transient errors are retried until the timeout
+		{"unavailable", newReadinessRevision("hello-00001", apiv1.ConditionTrue), func(action k8s_testing.Action) (bool, runtime.Object, error) {
+			return true, nil, api_errors.NewServiceUnavailable("overloaded")
+		}, "", "Cannot get the readiness of revision hello-00001 in the destination after"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			servingFake := serving_fake.NewSimpleClientset(tc.revision)
+			if tc.reactor != nil {
+				servingFake.PrependReactor("get", "revisions", tc.reactor)
+			}
+			migrationClient := command.NewMigrationClient(servingFake.ServingV1(), "default")
+			options := NewMigrationOptions()
+			options.Out = io.Discard
+			options.RevisionTimeout = time.Second
+			err := waitForRevisionReady(context.Background(), migrationClient, "hello-00001", options)
+			if tc.err != "" {
+				assert.ErrorContains(t, err, tc.err)
+				return
+			}
+			assert.NilError(t, err)
+			if tc.warning == "" {
+				assert.Equal(t, len(options.warnings()), 0)
+				return
+			}
+			assert.Equal(t, len(options.warnings()), 1)
+			assert.Assert(t, strings.Contains(options.warnings()[0], tc.warning), options.warnings()[0])
+		})
+	}
+}
+
+func newServiceWithReadiness(name string, status apiv1.ConditionStatus) *serving_v1_api.Service {
+	service := &serving_v1_api.Service{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}}
+	service.Status.Conditions = duckv1.Conditions{{Type: apis.ConditionReady, Status: status}}
+	return service
+}
+
+func TestWaitForServicesReady(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		services []runtime.Object
+		err      string
+	}{
+		{"ready", []runtime.Object{newServiceWithReadiness("bye", apiv1.ConditionTrue), newServiceWithReadiness("hello", apiv1.ConditionTrue)}, ""},
+		{"failed", []runtime.Object{newServiceWithReadiness("bye", apiv1.ConditionFalse), newServiceWithReadiness("hello", apiv1.ConditionTrue)}, "service(s) bye failed to become Ready"},
+		{"not ready", []runtime.Object{newServiceWithReadiness("bye", apiv1.ConditionUnknown), newServiceWithReadiness("hello", apiv1.ConditionUnknown)}, "service(s) bye, hello are not Ready after 1s"},
+		{"not found", []runtime.Object{newServiceWithReadiness("hello", apiv1.ConditionTrue)}, `"bye" not found`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			migrationClient := command.NewMigrationClient(serving_fake.NewSimpleClientset(tc.services...).ServingV1(), "default")
+			err := waitForServicesReady(context.Background(), migrationClient, []string{"bye", "hello"}, time.Second)
+			if tc.err == "" {
+				assert.NilError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tc.err)
+			assert.Equal(t, errors.Is(err, ErrVerificationFailed), tc.name != "not found")
+		})
+	}
+}
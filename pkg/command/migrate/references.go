@@ -0,0 +1,254 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+	apiv1 "k8s.io/api/core/v1"
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+var (
+	// clusterLocalHostRegexp matches the cluster-local hostname of a Kubernetes service, e.g. a sink URL
+	clusterLocalHostRegexp = regexp.MustCompile(`\b([a-z0-9]([-a-z0-9]*[a-z0-9])?)\.([a-z0-9]([-a-z0-9]*[a-z0-9])?)\.svc(\.cluster\.local)?\b`)
+	// namespacedNameRegexp matches a namespace/name reference, as used by custom controllers in annotations
+	namespacedNameRegexp = regexp.MustCompile(`^([a-z0-9]([-a-z0-9]*[a-z0-9])?)/([a-z0-9]([-.a-z0-9]*[a-z0-9])?)$`)
+)
+
+// crossNamespaceReference is an object of another namespace referenced by a service
+type crossNamespaceReference struct {
+	Namespace string `json:"namespace"`
+	Service   string `json:"service"`
+	// Via is where the reference was found, e.g. env SINK or annotation example.com/secret
+	Via                 string `json:"via"`
+	Kind                string `json:"kind"`
+	Name                string `json:"name"`
+	ReferencedNamespace string `json:"referencedNamespace"`
+}
+
+func (r crossNamespaceReference) String() string {
+	return fmt.Sprintf("%s %s/%s referenced by service %s/%s through %s", r.Kind, r.ReferencedNamespace, r.Name, r.Namespace, r.Service, r.Via)
+}
+
+// crossNamespaceReferences returns the objects of other namespaces a service references: services through
+// cluster-local hostnames in the environment, arguments and annotations, and secrets or configmaps through
+// namespace/name annotations of custom controllers. Kubernetes itself cannot resolve such references,
+// so they are only found by convention.
+func crossNamespaceReferences(service serving_v1_api.Service) []crossNamespaceReference {
+	references := []crossNamespaceReference{}
+	seen := map[crossNamespaceReference]bool{}
+	add := func(reference crossNamespaceReference) {
+		if reference.ReferencedNamespace == service.Namespace || seen[reference] {
+			return
+		}
+		seen[reference] = true
+		references = append(references, reference)
+	}
+	addHosts := func(via, value string) {
+		for _, match := range clusterLocalHostRegexp.FindAllStringSubmatch(value, -1) {
+			add(crossNamespaceReference{Namespace: service.Namespace, Service: service.Name, Via: via, Kind: "Service", Name: match[1], ReferencedNamespace: match[3]})
+		}
+	}
+
+	annotations := map[string]string{}
+	for key, value := range service.Annotations {
+		annotations[key] = value
+	}
+	for key, value := range service.Spec.Template.Annotations {
+		annotations[key] = value
+	}
+	keys := make([]string, 0, len(annotations))
+	for key := range annotations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := annotations[key]
+		addHosts("annotation "+key, value)
+		match := namespacedNameRegexp.FindStringSubmatch(strings.TrimSpace(value))
+		if match == nil {
+			continue
+		}
+		lowerKey := strings.ToLower(key)
+		switch {
+		case strings.Contains(lowerKey, "secret"):
+			add(crossNamespaceReference{Namespace: service.Namespace, Service: service.Name, Via: "annotation " + key, Kind: "Secret", Name: match[3], ReferencedNamespace: match[1]})
+		case strings.Contains(lowerKey, "configmap"):
+			add(crossNamespaceReference{Namespace: service.Namespace, Service: service.Name, Via: "annotation " + key, Kind: "ConfigMap", Name: match[3], ReferencedNamespace: match[1]})
+		}
+	}
+
+	for _, container := range service.Spec.Template.Spec.Containers {
+		for _, env := range container.Env {
+			addHosts("env "+env.Name, env.Value)
+		}
+		for _, arg := range append(append([]string{}, container.Command...), container.Args...) {
+			addHosts("args of container "+container.Name, arg)
+		}
+	}
+	return references
+}
+
+// findCrossNamespaceReferences returns the cross-namespace references of the selected services of every source
+func findCrossNamespaceReferences(ctx context.Context, sources []migrationSource, filter *serviceFilter) ([]crossNamespaceReference, error) {
+	references := []crossNamespaceReference{}
+	for _, source := range sources {
+		services, err := source.ListServices(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+		for _, service := range services.Items {
+			references = append(references, crossNamespaceReferences(service)...)
+		}
+	}
+	return references, nil
+}
+
+// includeReferencedNamespaces appends the referenced namespaces which are not migrated yet, keeping their names
+// in the destination cluster. Every service of a pulled in namespace is migrated.
+func includeReferencedNamespaces(namespaces []namespacePair, references []crossNamespaceReference) []namespacePair {
+	migrated := map[string]bool{}
+	for _, namespace := range namespaces {
+		migrated[namespace.Source] = true
+	}
+	included := []string{}
+	for _, reference := range references {
+		if !migrated[reference.ReferencedNamespace] {
+			migrated[reference.ReferencedNamespace] = true
+			included = append(included, reference.ReferencedNamespace)
+		}
+	}
+	sort.Strings(included)
+	for _, namespace := range included {
+		namespaces = append(namespaces, namespacePair{Source: namespace, Destination: namespace, Referenced: true})
+	}
+	return namespaces
+}
+
+// danglingReferences returns the references to namespaces which are not migrated
+func danglingReferences(namespaces []namespacePair, references []crossNamespaceReference) []crossNamespaceReference {
+	migrated := map[string]bool{}
+	for _, namespace := range namespaces {
+		migrated[namespace.Source] = true
+	}
+	dangling := []crossNamespaceReference{}
+	for _, reference := range references {
+		if !migrated[reference.ReferencedNamespace] {
+			dangling = append(dangling, reference)
+		}
+	}
+	return dangling
+}
+
+// destinationNamespaceOf returns the destination of a migrated source namespace
+func destinationNamespaceOf(namespaces []namespacePair, namespace string) (string, bool) {
+	for _, pair := range namespaces {
+		if pair.Source == namespace {
+			return pair.Destination, true
+		}
+	}
+	return "", false
+}
+
+// planReferences adds the cross-namespace references of the services of a plan: the dangling references and,
+// when referenced namespaces are included, the referenced secrets and configmaps copied to migrated namespaces
+func planReferences(plan *migrationPlan, namespaces []namespacePair, references []crossNamespaceReference, include bool) {
+	for _, reference := range references {
+		if reference.Namespace != plan.SourceNamespace {
+			continue
+		}
+		namespaceD, migrated := destinationNamespaceOf(namespaces, reference.ReferencedNamespace)
+		switch {
+		case !migrated:
+			plan.add(planEntry{Kind: reference.Kind, Name: reference.Name, Namespace: reference.ReferencedNamespace, Cluster: "source", Action: planActionDangling, Reason: fmt.Sprintf("referenced by service %s through %s, its namespace is not migrated", reference.Service, reference.Via)})
+		case include && (reference.Kind == "Secret" || reference.Kind == "ConfigMap"):
+			plan.add(planEntry{Kind: reference.Kind, Name: reference.Name, Namespace: namespaceD, Cluster: "destination", Action: planActionCreate, Reason: fmt.Sprintf("referenced by service %s through %s", reference.Service, reference.Via)})
+		}
+	}
+}
+
+// copyReferencedObjects copies the secrets and configmaps referenced across namespaces to the destination of their
+// namespace and returns the references it copied, the referenced services are migrated with their namespace
+func copyReferencedObjects(ctx context.Context, clientSetS, clientSetD kubernetes.Interface, namespaces []namespacePair, references []crossNamespaceReference, options *MigrationOptions) ([]crossNamespaceReference, error) {
+	copied := []crossNamespaceReference{}
+	for _, reference := range references {
+		namespaceD, migrated := destinationNamespaceOf(namespaces, reference.ReferencedNamespace)
+		if !migrated || (reference.Kind != "Secret" && reference.Kind != "ConfigMap") {
+			continue
+		}
+		if _, err := getOrCreateNamespace(ctx, clientSetS, clientSetD, reference.ReferencedNamespace, namespaceD, options); err != nil {
+			return copied, err
+		}
+
+		var err error
+		applied := true
+		if reference.Kind == "Secret" {
+			applied, err = copyReferencedSecret(ctx, clientSetS, clientSetD, reference, namespaceD, options)
+		} else {
+			err = copyReferencedConfigmap(ctx, clientSetS, clientSetD, reference, namespaceD, options)
+		}
+		if api_errors.IsNotFound(err) {
+			fmt.Fprintln(options.out(), color.YellowString("Cannot find the %s", reference))
+			continue
+		}
+		if err != nil {
+			return copied, err
+		}
+		if applied {
+			copied = append(copied, reference)
+		}
+	}
+	return copied, nil
+}
+
+func copyReferencedSecret(ctx context.Context, clientSetS, clientSetD kubernetes.Interface, reference crossNamespaceReference, namespaceD string, options *MigrationOptions) (bool, error) {
+	secretS, err := getSecret(ctx, clientSetS, reference.ReferencedNamespace, reference.Name)
+	if err != nil {
+		return false, sourceError(err)
+	}
+	return copySecret(ctx, clientSetD, namespaceD, secretS, options)
+}
+
+func copyReferencedConfigmap(ctx context.Context, clientSetS, clientSetD kubernetes.Interface, reference crossNamespaceReference, namespaceD string, options *MigrationOptions) error {
+	configmapS, err := getConfigmap(ctx, clientSetS, reference.ReferencedNamespace, reference.Name)
+	if err != nil {
+		return sourceError(err)
+	}
+	var replaced *apiv1.ConfigMap
+	err = options.paced(ctx, "create configmap "+reference.Name, func() error {
+		var err error
+		replaced, err = applyConfigmap(ctx, clientSetD, namespaceD, configmapS, options.forces(ForceConfigMaps), options)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if replaced != nil {
+		options.changes().updated("ConfigMap", namespaceD, reference.Name, replaced, clientSetD)
+		fmt.Fprintln(options.out(), "Replaced referenced configmap", color.CyanString(reference.Name), "in namespace", color.BlueString(namespaceD))
+	} else {
+		options.changes().created("ConfigMap", namespaceD, reference.Name, clientSetD, nil)
+		fmt.Fprintln(options.out(), "Copied referenced configmap", color.CyanString(reference.Name), "to namespace", color.BlueString(namespaceD))
+	}
+	return nil
+}
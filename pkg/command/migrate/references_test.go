@@ -0,0 +1,75 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"testing"
+
+	"gotest.tools/assert"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+func TestCrossNamespaceReferences(t *testing.T) {
+	service := serving_v1_api.Service{ObjectMeta: metav1.ObjectMeta{
+		Name:      "hello",
+		Namespace: "frontend",
+		Annotations: map[string]string{
+			"example.com/tls-secret":  "certs/wildcard",
+			"example.com/configmap":   "frontend/local",
+			"example.com/description": "shared/readme",
+		},
+	}}
+	service.Spec.Template.Spec.Containers = []apiv1.Container{{
+		Name: "user-container",
+		Env: []apiv1.EnvVar{
+			{Name: "K_SINK", Value: "http://broker-ingress.eventing.svc.cluster.local/default/default"},
+			{Name: "LOCAL", Value: "http://cache.frontend.svc"},
+		},
+		Args: []string{"--backend=http://orders.backend.svc:8080"},
+	}}
+
+	references := crossNamespaceReferences(service)
+	assert.DeepEqual(t, references, []crossNamespaceReference{
+		{Namespace: "frontend", Service: "hello", Via: "annotation example.com/tls-secret", Kind: "Secret", Name: "wildcard", ReferencedNamespace: "certs"},
+		{Namespace: "frontend", Service: "hello", Via: "env K_SINK", Kind: "Service", Name: "broker-ingress", ReferencedNamespace: "eventing"},
+		{Namespace: "frontend", Service: "hello", Via: "args of container user-container", Kind: "Service", Name: "orders", ReferencedNamespace: "backend"},
+	})
+}
+
+func TestReferencedNamespaces(t *testing.T) {
+	source := simulatedBundle("frontend", "hello")
+	source.services[0].Spec.Template.Spec.Containers = []apiv1.Container{{
+		Env: []apiv1.EnvVar{{Name: "BACKEND", Value: "http://orders.backend.svc.cluster.local"}},
+	}}
+	filter, err := newServiceFilter(nil, "")
+	assert.NilError(t, err)
+	references, err := findCrossNamespaceReferences(context.Background(), []migrationSource{source}, filter)
+	assert.NilError(t, err)
+	assert.Equal(t, len(references), 1)
+
+	namespaces := []namespacePair{{Source: "frontend", Destination: "frontend"}}
+	assert.Equal(t, len(danglingReferences(namespaces, references)), 1)
+	plan := &migrationPlan{SourceNamespace: "frontend"}
+	planReferences(plan, namespaces, references, false)
+	assert.Equal(t, plan.Entries[0].Action, planActionDangling)
+	assert.Assert(t, !plan.hasConflicts())
+
+	namespaces = includeReferencedNamespaces(namespaces, references)
+	assert.DeepEqual(t, namespaces, []namespacePair{{Source: "frontend", Destination: "frontend"}, {Source: "backend", Destination: "backend", Referenced: true}})
+	assert.Equal(t, len(danglingReferences(namespaces, references)), 0)
+}
@@ -0,0 +1,92 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"fmt"
+
+	"github.com/fatih/color"
+	"knative.dev/pkg/ptr"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+// validateRevisionRegeneration checks that the options recreating the source revisions are not combined with
+// RegenerateRevisions. LatestOnly implies RegenerateRevisions.
+func validateRevisionRegeneration(options *MigrationOptions) error {
+	flag := "--regenerate-revisions"
+	if options.LatestOnly {
+		flag = "--latest-only"
+		options.RegenerateRevisions = true
+	}
+	if !options.RegenerateRevisions {
+		return nil
+	}
+	if options.Revisions.selects() {
+		return fmt.Errorf("%s cannot be combined with --revisions, no source revision is recreated", flag)
+	}
+	if options.PreserveRevisionHistory {
+		return fmt.Errorf("%s cannot be combined with --preserve-revision-history, no source revision is recreated", flag)
+	}
+	return nil
+}
+
+// regenerateTraffic returns the traffic block of a service with its targets naming revisions routed to its latest
+// revision instead, since the revisions are generated again by the destination. The untagged targets are merged
+// into one, the tagged targets keep their tag and percent on the latest revision.
+func regenerateTraffic(traffic []serving_v1_api.TrafficTarget) ([]serving_v1_api.TrafficTarget, bool) {
+	changed := false
+	untagged := -1
+	regenerated := []serving_v1_api.TrafficTarget{}
+	for _, target := range traffic {
+		if target.RevisionName != "" {
+			target.RevisionName = ""
+			target.LatestRevision = ptr.Bool(true)
+			changed = true
+		}
+		if target.Tag != "" {
+			regenerated = append(regenerated, target)
+			continue
+		}
+		if untagged < 0 {
+			regenerated = append(regenerated, serving_v1_api.TrafficTarget{LatestRevision: ptr.Bool(true)})
+			untagged = len(regenerated) - 1
+		}
+		if target.Percent != nil {
+			percent := *target.Percent
+			if regenerated[untagged].Percent != nil {
+				percent += *regenerated[untagged].Percent
+			}
+			regenerated[untagged].Percent = ptr.Int64(percent)
+		}
+	}
+	if !changed {
+		return traffic, false
+	}
+	return regenerated, true
+}
+
+// regenerateRevisions routes the traffic of a service to its latest revision with RegenerateRevisions, so that the
+// destination serves the service from the revision it generates from the template
+func regenerateRevisions(service *serving_v1_api.Service, options *MigrationOptions) {
+	if !options.RegenerateRevisions {
+		return
+	}
+	traffic, changed := regenerateTraffic(service.Spec.Traffic)
+	if changed {
+		options.warn("The traffic of service %s routes to revisions of the source, it routes to the regenerated latest revision instead: %s", service.Name, describeTraffic(traffic))
+		service.Spec.Traffic = traffic
+	}
+	fmt.Fprintln(options.out(), "The revisions of service", color.CyanString(service.Name), "are generated again by the destination from the service")
+}
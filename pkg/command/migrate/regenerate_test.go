@@ -0,0 +1,102 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"testing"
+
+	"gotest.tools/assert"
+	"knative.dev/pkg/ptr"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+func TestRegenerateTraffic(t *testing.T) {
+	traffic, changed := regenerateTraffic([]serving_v1_api.TrafficTarget{
+		{RevisionName: "hello-00001", Percent: ptr.Int64(20)},
+		{RevisionName: "hello-00002", Percent: ptr.Int64(30), Tag: "canary"},
+		{LatestRevision: ptr.Bool(true), Percent: ptr.Int64(50)},
+		{RevisionName: "hello-00001", Percent: ptr.Int64(0), Tag: "old"},
+	})
+	assert.Assert(t, changed)
+	assert.DeepEqual(t, traffic, []serving_v1_api.TrafficTarget{
+		{LatestRevision: ptr.Bool(true), Percent: ptr.Int64(70)},
+		{LatestRevision: ptr.Bool(true), Percent: ptr.Int64(30), Tag: "canary"},
+		{LatestRevision: ptr.Bool(true), Percent: ptr.Int64(0), Tag: "old"},
+	})
+
+	latest := []serving_v1_api.TrafficTarget{{LatestRevision: ptr.Bool(true), Percent: ptr.Int64(100)}}
+	traffic, changed = regenerateTraffic(latest)
+	assert.Assert(t, !changed)
+	assert.DeepEqual(t, traffic, latest)
+}
+
+func TestValidateRevisionRegeneration(t *testing.T) {
+	options := NewMigrationOptions()
+	options.Revisions = RevisionsLatest
+	assert.NilError(t, validateRevisionRegeneration(options))
+	options.RegenerateRevisions = true
+	assert.ErrorContains(t, validateRevisionRegeneration(options), "--regenerate-revisions cannot be combined with --revisions")
+	options.Revisions = RevisionsAll
+	assert.NilError(t, validateRevisionRegeneration(options))
+	options.PreserveRevisionHistory = true
+	assert.ErrorContains(t, validateRevisionRegeneration(options), "--regenerate-revisions cannot be combined with --preserve-revision-history")
+}
+
+func TestMigrateRegeneratedRevisions(t *testing.T) {
+	source := simulatedBundle("default", "hello")
+	source.services[0].Spec.Traffic = []serving_v1_api.TrafficTarget{
+		{RevisionName: "hello-00001", Percent: ptr.Int64(10)},
+		{LatestRevision: ptr.Bool(true), Percent: ptr.Int64(90)},
+	}
+	clientSetD, migrationClientD := newSimulatedDestination("prod", &bundleSource{})
+	options := NewMigrationOptions()
+	options.RegenerateRevisions = true
+	revisions, _, err := migrateService(context.Background(), source, clientSetD, migrationClientD, "prod", source.services[0], options)
+	assert.NilError(t, err)
+	assert.Equal(t, len(revisions), 0)
+
+	serviceD, err := migrationClientD.GetService(context.Background(), "hello")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, serviceD.Spec.Traffic, []serving_v1_api.TrafficTarget{{LatestRevision: ptr.Bool(true), Percent: ptr.Int64(100)}})
+	assert.Equal(t, len(options.warnings()), 1)
+}
+
+func TestValidateLatestOnly(t *testing.T) {
+	options := NewMigrationOptions()
+	options.LatestOnly = true
+	assert.NilError(t, validateRevisionRegeneration(options))
+	assert.Assert(t, options.RegenerateRevisions)
+	options.Revisions = RevisionsLatest
+	assert.ErrorContains(t, validateRevisionRegeneration(options), "--latest-only cannot be combined with --revisions")
+}
+
+func TestMigrateLatestOnly(t *testing.T) {
+	source := simulatedBundle("default", "hello")
+	clientSetD, migrationClientD := newSimulatedDestination("prod", &bundleSource{})
+	options := NewMigrationOptions()
+	options.LatestOnly = true
+	assert.NilError(t, validateRevisionRegeneration(options))
+	revisions, _, err := migrateService(context.Background(), source, clientSetD, migrationClientD, "prod", source.services[0], options)
+	assert.NilError(t, err)
+	assert.Equal(t, len(revisions), 0)
+
+	serviceD, err := migrationClientD.GetService(context.Background(), "hello")
+	assert.NilError(t, err)
+	revisionD, err := migrationClientD.GetRevision(context.Background(), serviceD.Status.LatestCreatedRevisionName)
+	assert.NilError(t, err)
+	assert.Assert(t, revisionD.IsReady())
+	assert.Equal(t, len(options.warnings()), 0)
+}
@@ -0,0 +1,269 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"knative.dev/kn-plugin-migration/pkg/command"
+)
+
+const (
+	// DefaultRehearsalCluster is the default name of the ephemeral rehearsal cluster
+	DefaultRehearsalCluster = "kn-migration-rehearsal"
+	// DefaultRehearsalKnativeVersion is the default version of Knative Serving installed in the rehearsal cluster
+	DefaultRehearsalKnativeVersion = "1.4.0"
+
+	rehearsalProviderKind     = "kind"
+	rehearsalProviderMinikube = "minikube"
+)
+
+// commandRunner runs an external command with extra environment variables
+type commandRunner func(ctx context.Context, env []string, name string, args ...string) error
+
+// newCommandRunner returns the runner of the external commands of a rehearsal, writing their output to out and
+// their errors to errOut
+func newCommandRunner(out, errOut io.Writer) commandRunner {
+	return func(ctx context.Context, env []string, name string, args ...string) error {
+		fmt.Fprintln(out, color.BlueString("$ %s %s", name, strings.Join(args, " ")))
+		cmd := exec.CommandContext(ctx, name, args...)
+		cmd.Env = append(os.Environ(), env...)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = out
+		cmd.Stderr = errOut
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s %s failed: %w", name, args[0], err)
+		}
+		return nil
+	}
+}
+
+type rehearseCmdFlags struct {
+	Namespaces       []string
+	KubeConfig       string
+	Context          string
+	SourceInCluster  bool
+	Provider         string
+	ClusterName      string
+	KnativeVersion   string
+	TargetKubeConfig string
+	TargetContext    string
+	Keep             bool
+	VerifyTimeout    time.Duration
+}
+
+var rehearseFlags rehearseCmdFlags
+
+// NewRehearseCommand represents the 'migrate rehearse' command
+func NewRehearseCommand() *cobra.Command {
+	rehearseCmd := &cobra.Command{
+		Use:   "rehearse [-- migrate flags]",
+		Short: "Rehearse a migration end to end against an ephemeral local cluster",
+		Long: `Rehearse a migration end to end against an ephemeral local cluster.
+
+A kind or minikube cluster is created and Knative Serving is installed in it, the namespaces
+of the real source cluster are migrated into it, the migrated services are verified Ready
+and the cluster is deleted. The source cluster is only read. With --target-kubeconfig or
+--target-context an existing local cluster with Knative Serving installed is used instead,
+and is kept. The flags after -- are passed to the migration, --delete is refused.`,
+		Example: `
+  # Rehearse the migration of the default namespace in a kind cluster
+  kn migrate rehearse --namespace default
+  # Rehearse with the options of the real migration, keeping the cluster to inspect it
+  kn migrate rehearse --namespace default --keep -- --include-domainmappings --concurrency 4`,
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := command.NewCommandContext(cmd)
+			defer cancel()
+
+			out := cmd.OutOrStdout()
+			err := rehearse(ctx, out, rehearseFlags, args, newCommandRunner(out, cmd.ErrOrStderr()), verifyRehearsal)
+			if err != nil {
+				return fmt.Errorf("the rehearsal failed: %w", err)
+			}
+			fmt.Fprintln(out, color.GreenString("The rehearsal succeeded"))
+			return nil
+		},
+	}
+
+	rehearseCmd.Flags().StringSliceVarP(&rehearseFlags.Namespaces, "namespace", "n", nil, "The namespaces of the source Knative resources to rehearse the migration of, comma separated or repeated")
+	rehearseCmd.Flags().StringVar(&rehearseFlags.KubeConfig, "kubeconfig", "", "The kubeconfig of the Knative resources (default is KUBECONFIG from environment variable)")
+	rehearseCmd.Flags().StringVar(&rehearseFlags.Context, "context", "", "The context of the kubeconfig of the Knative resources (default is the current context)")
+	rehearseCmd.Flags().BoolVar(&rehearseFlags.SourceInCluster, "source-in-cluster", false, "Use the ServiceAccount of the pod the rehearsal runs in for the source cluster instead of a kubeconfig")
+	rehearseCmd.Flags().StringVar(&rehearseFlags.Provider, "provider", rehearsalProviderKind, "The tool creating the rehearsal cluster, one of: kind, minikube")
+	rehearseCmd.Flags().StringVar(&rehearseFlags.ClusterName, "cluster-name", DefaultRehearsalCluster, "The name of the rehearsal cluster")
+	rehearseCmd.Flags().StringVar(&rehearseFlags.KnativeVersion, "knative-version", DefaultRehearsalKnativeVersion, "The version of Knative Serving installed in the rehearsal cluster")
+	rehearseCmd.Flags().StringVar(&rehearseFlags.TargetKubeConfig, "target-kubeconfig", "", "The kubeconfig of an existing local cluster with Knative Serving to rehearse against instead of creating one")
+	rehearseCmd.Flags().StringVar(&rehearseFlags.TargetContext, "target-context", "", "The context of an existing local cluster with Knative Serving to rehearse against instead of creating one")
+	rehearseCmd.Flags().BoolVar(&rehearseFlags.Keep, "keep", false, "Keep the rehearsal cluster instead of deleting it, e.g. to inspect a failed rehearsal")
+	rehearseCmd.Flags().DurationVar(&rehearseFlags.VerifyTimeout, "verify-timeout", DefaultVerifyTimeout, "The maximum time for the migrated services to be Ready in the rehearsal cluster")
+	return rehearseCmd
+}
+
+// rehearse creates the rehearsal cluster unless one is targeted, migrates the namespaces into it by running
+// the migrate command of this binary with the extra migrate arguments, verifies the migrated services and
+// deletes the cluster it created, telling its progress to out
+func rehearse(ctx context.Context, out io.Writer, flags rehearseCmdFlags, migrateArgs []string, run commandRunner, verify func(ctx context.Context, out io.Writer, cluster clusterConfig, namespaces []string, timeout time.Duration) error) error {
+	if len(flags.Namespaces) == 0 {
+		return fmt.Errorf("cannot get source cluster namespace, please use --namespace to set")
+	}
+	for _, arg := range migrateArgs {
+		if arg == "--delete" || strings.HasPrefix(arg, "--delete=") {
+			return fmt.Errorf("a rehearsal never deletes the source services, remove --delete from the migrate flags")
+		}
+	}
+	if flags.Provider != rehearsalProviderKind && flags.Provider != rehearsalProviderMinikube {
+		return fmt.Errorf("unsupported rehearsal provider %q, supported providers are: kind, minikube", flags.Provider)
+	}
+
+	target := clusterConfig{KubeConfig: flags.TargetKubeConfig, Context: flags.TargetContext}
+	if target.KubeConfig == "" && target.Context == "" {
+		dir, err := ioutil.TempDir("", "kn-migration-rehearsal")
+		if err != nil {
+			return err
+		}
+		target.KubeConfig = filepath.Join(dir, "kubeconfig")
+		// The kubeconfig of a kept cluster is kept with it
+		if !flags.Keep {
+			defer os.RemoveAll(dir)
+		}
+
+		env := []string{"KUBECONFIG=" + target.KubeConfig}
+		fmt.Fprintln(out, "Creating the rehearsal cluster", color.CyanString(flags.ClusterName), "with", flags.Provider)
+		err = run(ctx, env, flags.Provider, createClusterArgs(flags)...)
+		if flags.Keep {
+			defer fmt.Fprintln(out, "Kept the rehearsal cluster", color.CyanString(flags.ClusterName), "with the kubeconfig", color.CyanString(target.KubeConfig))
+		} else {
+			// The cluster is deleted even when its creation failed halfway, or the rehearsal was interrupted
+			defer func() {
+				fmt.Fprintln(out, "Deleting the rehearsal cluster", color.CyanString(flags.ClusterName))
+				if err := run(context.Background(), env, flags.Provider, deleteClusterArgs(flags)...); err != nil {
+					fmt.Fprintln(out, color.YellowString("Cannot delete the rehearsal cluster %s: %s", flags.ClusterName, err.Error()))
+				}
+			}()
+		}
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(out, "Installing Knative Serving", flags.KnativeVersion, "in the rehearsal cluster")
+		for _, args := range installKnativeArgs(flags.KnativeVersion) {
+			if err := run(ctx, env, "kubectl", args...); err != nil {
+				return err
+			}
+		}
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, "Migrating", strings.Join(flags.Namespaces, ", "), "into the rehearsal cluster")
+	if err := run(ctx, nil, executable, rehearsalMigrateArgs(flags, target, migrateArgs)...); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(out, "Verifying the migrated services")
+	return verify(ctx, out, target, flags.Namespaces, flags.VerifyTimeout)
+}
+
+// createClusterArgs returns the arguments creating the rehearsal cluster, the provider writes its
+// credentials to the kubeconfig of the KUBECONFIG environment variable
+func createClusterArgs(flags rehearseCmdFlags) []string {
+	if flags.Provider == rehearsalProviderMinikube {
+		return []string{"start", "--profile", flags.ClusterName}
+	}
+	return []string{"create", "cluster", "--name", flags.ClusterName, "--wait", "5m"}
+}
+
+// deleteClusterArgs returns the arguments deleting the rehearsal cluster
+func deleteClusterArgs(flags rehearseCmdFlags) []string {
+	if flags.Provider == rehearsalProviderMinikube {
+		return []string{"delete", "--profile", flags.ClusterName}
+	}
+	return []string{"delete", "cluster", "--name", flags.ClusterName}
+}
+
+// installKnativeArgs returns the kubectl arguments installing Knative Serving with Kourier and waiting for it
+func installKnativeArgs(version string) [][]string {
+	release := "https://github.com/knative/%s/releases/download/knative-v" + strings.TrimPrefix(version, "v") + "/%s"
+	return [][]string{
+		{"apply", "-f", fmt.Sprintf(release, "serving", "serving-crds.yaml")},
+		{"wait", "--for", "condition=Established", "--all", "crd", "--timeout", "5m"},
+		{"apply", "-f", fmt.Sprintf(release, "serving", "serving-core.yaml")},
+		{"apply", "-f", fmt.Sprintf(release, "net-kourier", "kourier.yaml")},
+		{"patch", "configmap/config-network", "--namespace", "knative-serving", "--type", "merge", "--patch", `{"data":{"ingress.class":"kourier.ingress.networking.knative.dev"}}`},
+		{"wait", "--for", "condition=Available", "--all", "deployment", "--namespace", "knative-serving", "--timeout", "5m"},
+		{"wait", "--for", "condition=Available", "--all", "deployment", "--namespace", "kourier-system", "--timeout", "5m"},
+	}
+}
+
+// rehearsalMigrateArgs returns the arguments of the migrate command migrating the namespaces into the rehearsal
+// cluster, the checkpoint of the real migration is left alone and the objects replaced in the throwaway cluster
+// are not confirmed
+func rehearsalMigrateArgs(flags rehearseCmdFlags, target clusterConfig, migrateArgs []string) []string {
+	args := []string{"migrate", "--namespace", strings.Join(flags.Namespaces, ","), "--checkpoint-file=", "--yes"}
+	if flags.KubeConfig != "" {
+		args = append(args, "--kubeconfig", flags.KubeConfig)
+	}
+	if flags.Context != "" {
+		args = append(args, "--context", flags.Context)
+	}
+	if flags.SourceInCluster {
+		args = append(args, "--source-in-cluster")
+	}
+	if target.KubeConfig != "" {
+		args = append(args, "--destination-kubeconfig", target.KubeConfig)
+	}
+	if target.Context != "" {
+		args = append(args, "--destination-context", target.Context)
+	}
+	return append(args, migrateArgs...)
+}
+
+// verifyRehearsal waits for every service of the rehearsed namespaces to be Ready in the rehearsal cluster.
+// Their URLs are not probed, the domains of a local cluster do not resolve.
+func verifyRehearsal(ctx context.Context, out io.Writer, cluster clusterConfig, namespaces []string, timeout time.Duration) error {
+	_, servingClient, err := getClusterClients(cluster)
+	if err != nil {
+		return err
+	}
+	for _, namespace := range namespaces {
+		migrationClient := command.NewMigrationClient(servingClient, namespace)
+		services, err := migrationClient.ListService(ctx)
+		if err != nil {
+			return err
+		}
+		names := []string{}
+		for _, service := range services.Items {
+			names = append(names, service.Name)
+		}
+		if err := waitForServicesReady(ctx, migrationClient, names, timeout); err != nil {
+			return err
+		}
+		fmt.Fprintln(out, "The", len(names), "service(s) of namespace", color.BlueString(namespace), "are Ready in the rehearsal cluster")
+	}
+	return nil
+}
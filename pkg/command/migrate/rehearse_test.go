@@ -0,0 +1,81 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+// recordedRunner records the commands of a rehearsal and fails the command starting with failing, if any
+func recordedRunner(commands *[]string, failing string) commandRunner {
+	return func(ctx context.Context, env []string, name string, args ...string) error {
+		line := strings.Join(append([]string{name}, args...), " ")
+		if strings.HasSuffix(name, ".test") {
+			line = strings.Join(append([]string{"<self>"}, args...), " ")
+		}
+		*commands = append(*commands, line)
+		if failing != "" && strings.HasPrefix(line, failing) {
+			return errors.New("exit status 1")
+		}
+		return nil
+	}
+}
+
+func noVerification(ctx context.Context, out io.Writer, cluster clusterConfig, namespaces []string, timeout time.Duration) error {
+	return nil
+}
+
+func TestRehearse(t *testing.T) {
+	flags := rehearseCmdFlags{Namespaces: []string{"default", "payments"}, Context: "prod", Provider: rehearsalProviderKind, ClusterName: "rehearsal", KnativeVersion: "1.4.0"}
+	commands := []string{}
+	var verified []string
+	err := rehearse(context.Background(), io.Discard, flags, []string{"--concurrency", "4"}, recordedRunner(&commands, ""), func(ctx context.Context, out io.Writer, cluster clusterConfig, namespaces []string, timeout time.Duration) error {
+		verified = namespaces
+		// kind writes the credentials of the rehearsal cluster to a temporary kubeconfig
+		assert.Assert(t, strings.HasSuffix(cluster.KubeConfig, "kubeconfig"))
+		return nil
+	})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, verified, []string{"default", "payments"})
+	assert.Equal(t, commands[0], "kind create cluster --name rehearsal --wait 5m")
+	assert.Equal(t, commands[1], "kubectl apply -f https://github.com/knative/serving/releases/download/knative-v1.4.0/serving-crds.yaml")
+	migrate := commands[len(commands)-2]
+	assert.Assert(t, strings.HasPrefix(migrate, "<self> migrate --namespace default,payments --checkpoint-file= --yes --context prod --destination-kubeconfig "), migrate)
+	assert.Assert(t, strings.HasSuffix(migrate, " --concurrency 4"), migrate)
+	assert.Equal(t, commands[len(commands)-1], "kind delete cluster --name rehearsal")
+
+	// The cluster is deleted even when the migration fails
+	commands = []string{}
+	err = rehearse(context.Background(), io.Discard, flags, nil, recordedRunner(&commands, "<self> migrate"), noVerification)
+	assert.ErrorContains(t, err, "exit status 1")
+	assert.Equal(t, commands[len(commands)-1], "kind delete cluster --name rehearsal")
+
+	// A targeted cluster is neither created nor deleted
+	commands = []string{}
+	flags.TargetContext = "kind-local"
+	assert.NilError(t, rehearse(context.Background(), io.Discard, flags, nil, recordedRunner(&commands, ""), noVerification))
+	assert.Equal(t, len(commands), 1)
+	assert.Assert(t, strings.HasSuffix(commands[0], "--destination-context kind-local"))
+
+	err = rehearse(context.Background(), io.Discard, flags, []string{"--delete"}, recordedRunner(&commands, ""), noVerification)
+	assert.ErrorContains(t, err, "never deletes the source services")
+}
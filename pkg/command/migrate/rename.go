@@ -0,0 +1,154 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+// renamedLabels are the labels of a revision naming its service, its configuration and its route
+var renamedLabels = []string{"serving.knative.dev/service", "serving.knative.dev/configuration", "serving.knative.dev/route"}
+
+// parseRenames reads the old=new pairs of --rename and the old=new lines of a rename file, the pairs given
+// on the command line override the lines of the file
+func parseRenames(pairs []string, file string) (map[string]string, error) {
+	renames := map[string]string{}
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for line := 1; scanner.Scan(); line++ {
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" || strings.HasPrefix(text, "#") {
+				continue
+			}
+			if err := addRename(renames, text); err != nil {
+				return nil, fmt.Errorf("%w at %s:%d", err, file, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	for _, pair := range pairs {
+		if err := addRename(renames, pair); err != nil {
+			return nil, err
+		}
+	}
+
+	renamed := map[string]string{}
+	for old, name := range renames {
+		if other, ok := renamed[name]; ok {
+			return nil, fmt.Errorf("cannot rename both services %s and %s to %s", other, old, name)
+		}
+		renamed[name] = old
+	}
+	return renames, nil
+}
+
+// addRename validates an old=new pair and adds it to the renames
+func addRename(renames map[string]string, pair string) error {
+	parts := strings.SplitN(pair, "=", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+		return fmt.Errorf("invalid rename %q, expected old-name=new-name", pair)
+	}
+	old, name := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+	if errs := validation.IsDNS1035Label(name); len(errs) > 0 {
+		return fmt.Errorf("invalid rename %q, %s is not a valid service name: %s", pair, name, strings.Join(errs, ", "))
+	}
+	renames[old] = name
+	return nil
+}
+
+// renameService renames a service before it is created in the destination with the revisions, the labels and the
+// configmap derived from its name. The revisions named after the service, e.g. frontend-00001, are renamed after
+// the new name and the references of its template and revisions to its configmap follow the new configmap name.
+func renameService(serviceS *serving_v1_api.Service, revisionsS *serving_v1_api.RevisionList, name string) {
+	// The objects of the source may be shared, e.g. by a bundle, and are not modified
+	*serviceS = *serviceS.DeepCopy()
+	*revisionsS = *revisionsS.DeepCopy()
+	old := serviceS.Name
+	serviceS.Name = name
+
+	remapping := map[string]string{}
+	renamedRevision := func(revision string) {
+		if strings.HasPrefix(revision, old+"-") {
+			remapping[revision] = name + strings.TrimPrefix(revision, old)
+		}
+	}
+	renamedRevision(serviceS.Spec.Template.Name)
+	renamedRevision(serviceS.Status.LatestCreatedRevisionName)
+	for _, revision := range revisionsS.Items {
+		renamedRevision(revision.Name)
+	}
+	remapRevisions(serviceS, revisionsS, remapping)
+
+	configmap, renamedConfigmap := generateConfigmapName(old), generateConfigmapName(name)
+	renameConfigmapReferences(&serviceS.Spec.Template.Spec.PodSpec, configmap, renamedConfigmap)
+	for i := range revisionsS.Items {
+		revision := &revisionsS.Items[i]
+		for _, key := range renamedLabels {
+			if revision.Labels[key] == old {
+				revision.Labels[key] = name
+			}
+		}
+		// The revisions are owned by the configuration of the service, which has its name
+		for j := range revision.OwnerReferences {
+			if revision.OwnerReferences[j].Name == old {
+				revision.OwnerReferences[j].Name = name
+			}
+		}
+		renameConfigmapReferences(&revision.Spec.PodSpec, configmap, renamedConfigmap)
+	}
+}
+
+// renameConfigmapReferences replaces the references of a pod spec to a configmap
+func renameConfigmapReferences(spec *apiv1.PodSpec, old, name string) {
+	for i := range spec.Containers {
+		container := &spec.Containers[i]
+		for _, env := range container.Env {
+			if env.ValueFrom != nil && env.ValueFrom.ConfigMapKeyRef != nil && env.ValueFrom.ConfigMapKeyRef.Name == old {
+				env.ValueFrom.ConfigMapKeyRef.Name = name
+			}
+		}
+		for _, envFrom := range container.EnvFrom {
+			if envFrom.ConfigMapRef != nil && envFrom.ConfigMapRef.Name == old {
+				envFrom.ConfigMapRef.Name = name
+			}
+		}
+	}
+	for _, volume := range spec.Volumes {
+		if volume.ConfigMap != nil && volume.ConfigMap.Name == old {
+			volume.ConfigMap.Name = name
+		}
+		if volume.Projected != nil {
+			for _, projection := range volume.Projected.Sources {
+				if projection.ConfigMap != nil && projection.ConfigMap.Name == old {
+					projection.ConfigMap.Name = name
+				}
+			}
+		}
+	}
+}
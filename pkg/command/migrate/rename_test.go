@@ -0,0 +1,96 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/assert"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+func TestParseRenames(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "renames.txt")
+	assert.NilError(t, ioutil.WriteFile(file, []byte("# services clashing in the destination\nfrontend=storefront\nbackend = api\n"), 0600))
+
+	renames, err := parseRenames([]string{"backend=orders"}, file)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, renames, map[string]string{"frontend": "storefront", "backend": "orders"})
+
+	_, err = parseRenames([]string{"frontend"}, "")
+	assert.ErrorContains(t, err, "invalid rename \"frontend\", expected old-name=new-name")
+	_, err = parseRenames([]string{"frontend=Store_Front"}, "")
+	assert.ErrorContains(t, err, "Store_Front is not a valid service name")
+	_, err = parseRenames([]string{"frontend=web", "backend=web"}, "")
+	assert.ErrorContains(t, err, "to web")
+
+	assert.NilError(t, ioutil.WriteFile(file, []byte("frontend=\n"), 0600))
+	_, err = parseRenames(nil, file)
+	assert.ErrorContains(t, err, "renames.txt:1")
+}
+
+func TestRenameService(t *testing.T) {
+	percent := func(p int64) *int64 { return &p }
+	configmapVolume := apiv1.Volume{Name: "config", VolumeSource: apiv1.VolumeSource{ConfigMap: &apiv1.ConfigMapVolumeSource{LocalObjectReference: apiv1.LocalObjectReference{Name: "frontend-config"}}}}
+	service := serving_v1_api.Service{ObjectMeta: metav1.ObjectMeta{Name: "frontend", Labels: map[string]string{"team": "web"}}}
+	service.Spec.Template.Name = "frontend-v2"
+	service.Spec.Template.Spec.Volumes = []apiv1.Volume{configmapVolume}
+	service.Spec.Traffic = []serving_v1_api.TrafficTarget{{RevisionName: "frontend-v1", Percent: percent(10)}, {RevisionName: "frontend-v2", Percent: percent(90)}}
+	revision := serving_v1_api.Revision{ObjectMeta: metav1.ObjectMeta{
+		Name:            "frontend-v1",
+		Labels:          map[string]string{"serving.knative.dev/service": "frontend", "serving.knative.dev/configuration": "frontend", "serving.knative.dev/configurationGeneration": "1"},
+		OwnerReferences: []metav1.OwnerReference{{Kind: "Configuration", Name: "frontend"}},
+	}}
+	revision.Spec.Volumes = []apiv1.Volume{configmapVolume}
+	revisions := &serving_v1_api.RevisionList{Items: []serving_v1_api.Revision{revision, {ObjectMeta: metav1.ObjectMeta{Name: "frontend-v2"}}}}
+	source := service.DeepCopy()
+
+	renameService(&service, revisions, "storefront")
+
+	assert.Equal(t, service.Name, "storefront")
+	assert.Equal(t, service.Spec.Template.Name, "storefront-v2")
+	assert.Equal(t, service.Spec.Template.Spec.Volumes[0].ConfigMap.Name, "storefront-config")
+	assert.Equal(t, service.Spec.Traffic[0].RevisionName, "storefront-v1")
+	assert.Equal(t, service.Spec.Traffic[1].RevisionName, "storefront-v2")
+	renamed := revisions.Items[0]
+	assert.Equal(t, renamed.Name, "storefront-v1")
+	assert.Equal(t, renamed.Labels["serving.knative.dev/service"], "storefront")
+	assert.Equal(t, renamed.Labels["serving.knative.dev/configuration"], "storefront")
+	assert.Equal(t, renamed.Labels["serving.knative.dev/configurationGeneration"], "1")
+	assert.Equal(t, renamed.OwnerReferences[0].Name, "storefront")
+	assert.Equal(t, renamed.Spec.Volumes[0].ConfigMap.Name, "storefront-config")
+	assert.Equal(t, revisions.Items[1].Name, "storefront-v2")
+
+	// The source objects are left untouched
+	assert.Equal(t, source.Spec.Template.Spec.Volumes[0].ConfigMap.Name, "frontend-config")
+	assert.Equal(t, revision.Labels["serving.knative.dev/service"], "frontend")
+	assert.Equal(t, revision.OwnerReferences[0].Name, "frontend")
+}
+
+func TestDestinationName(t *testing.T) {
+	options := &MigrationOptions{Renames: map[string]string{"frontend": "storefront"}}
+	assert.Equal(t, options.destinationName("frontend"), "storefront")
+	assert.Equal(t, options.destinationName("backend"), "backend")
+
+	report := &NamespaceReport{Services: []ServiceReport{{Name: "frontend"}, {Name: "backend"}}}
+	report.rename(options.Renames)
+	assert.Equal(t, report.Services[0].destination(), "storefront")
+	assert.Equal(t, report.Services[1].destination(), "backend")
+	assert.Equal(t, report.Services[1].DestinationName, "")
+}
@@ -0,0 +1,370 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/fatih/color"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// ServiceStatus is the outcome of the migration of a single service
+type ServiceStatus string
+
+const (
+	ServiceStatusMigrated ServiceStatus = "migrated"
+	ServiceStatusFailed   ServiceStatus = "failed"
+	ServiceStatusSkipped  ServiceStatus = "skipped"
+)
+
+// MigrationReport is the structured result of a migration run printed with --output and returned to library callers
+type MigrationReport struct {
+	StartedAt  metav1.Time `json:"startedAt"`
+	FinishedAt metav1.Time `json:"finishedAt"`
+	Duration   string      `json:"duration"`
+	Succeeded  bool        `json:"succeeded"`
+	Error      string      `json:"error,omitempty"`
+	RolledBack bool        `json:"rolledBack,omitempty"`
+	// Interrupted tells that the run was stopped by a signal or by --timeout before it completed
+	Interrupted bool `json:"interrupted,omitempty"`
+	// Command is the command of the run and Flags the flags set on its command line
+	Command string            `json:"command,omitempty"`
+	Flags   map[string]string `json:"flags,omitempty"`
+	// RunID is the ID of the run recorded on the objects it wrote to the destination
+	RunID string `json:"runId,omitempty"`
+	// Warnings are the warnings printed during the run
+	Warnings   []string           `json:"warnings,omitempty"`
+	Namespaces []*NamespaceReport `json:"namespaces"`
+	// Destinations sums up the namespaces of every destination cluster when the run fanned out to several clusters
+	Destinations []DestinationReport `json:"destinations,omitempty"`
+}
+
+// DestinationReport is the result of the migration to one destination cluster of a fan-out
+type DestinationReport struct {
+	Cluster   string `json:"cluster"`
+	Succeeded bool   `json:"succeeded"`
+	Migrated  int    `json:"migrated"`
+	Skipped   int    `json:"skipped"`
+	Failed    int    `json:"failed"`
+}
+
+// NamespaceReport is the result of the migration of one source namespace
+type NamespaceReport struct {
+	SourceNamespace      string `json:"sourceNamespace"`
+	DestinationNamespace string `json:"destinationNamespace"`
+	// DestinationCluster is the destination cluster of the namespace when the run fanned out to several clusters
+	DestinationCluster string          `json:"destinationCluster,omitempty"`
+	Services           []ServiceReport `json:"services"`
+	// Dependencies are the objects copied for the namespace besides the dependencies of its services,
+	// e.g. DomainMappings or objects referenced from other namespaces
+	Dependencies []string `json:"dependencies,omitempty"`
+	// DanglingReferences are the objects of namespaces which are not migrated referenced by the services
+	DanglingReferences []crossNamespaceReference `json:"danglingReferences,omitempty"`
+	Duration           string                    `json:"duration,omitempty"`
+	// Error is the failure which stopped the migration of the whole namespace, if any
+	Error string `json:"error,omitempty"`
+
+	startedAt time.Time
+}
+
+// ServiceReport is the result of the migration of one service
+type ServiceReport struct {
+	Name      string        `json:"name"`
+	Status    ServiceStatus `json:"status"`
+	Revisions []string      `json:"revisions"`
+	// Dependencies are the configmap and secrets copied for the service
+	Dependencies []string `json:"dependencies,omitempty"`
+	Duration     string   `json:"duration"`
+	Error        string   `json:"error,omitempty"`
+	// DestinationName is the name of the service in the destination when it was renamed
+	DestinationName string `json:"destinationName,omitempty"`
+}
+
+func newMigrationReport() *MigrationReport {
+	return &MigrationReport{
+		StartedAt:  metav1.NewTime(time.Now()),
+		Namespaces: []*NamespaceReport{},
+	}
+}
+
+// namespace adds the report of a namespace pair and returns it
+func (r *MigrationReport) namespace(namespaceS, namespaceD string) *NamespaceReport {
+	report := &NamespaceReport{SourceNamespace: namespaceS, DestinationNamespace: namespaceD, Services: []ServiceReport{}, startedAt: time.Now()}
+	r.Namespaces = append(r.Namespaces, report)
+	return report
+}
+
+// conclude finishes the report of a run which stopped with err, or failed services, and returns the error of the run
+func (r *MigrationReport) conclude(ctx context.Context, err error, options *MigrationOptions) error {
+	if err == nil {
+		if failures := r.failures(); failures > 0 {
+			err = fmt.Errorf("%d failure(s) during the migration", failures)
+		}
+	}
+	if ctx.Err() != nil {
+		r.Interrupted = true
+	}
+	r.finish(err)
+	r.Warnings = options.warnings()
+	return err
+}
+
+// finish records the end of the run and the error that stopped it, if any
+func (r *MigrationReport) finish(err error) {
+	r.FinishedAt = metav1.NewTime(time.Now())
+	r.Duration = r.FinishedAt.Sub(r.StartedAt.Time).Round(time.Millisecond).String()
+	r.Succeeded = err == nil && r.failures() == 0
+	if err != nil {
+		r.Error = err.Error()
+	}
+	r.Destinations = nil
+	for _, namespace := range r.Namespaces {
+		if namespace.DestinationCluster == "" {
+			continue
+		}
+		i := 0
+		for i < len(r.Destinations) && r.Destinations[i].Cluster != namespace.DestinationCluster {
+			i++
+		}
+		if i == len(r.Destinations) {
+			r.Destinations = append(r.Destinations, DestinationReport{Cluster: namespace.DestinationCluster, Succeeded: true})
+		}
+		totals := namespace.totals()
+		if namespace.Error != "" {
+			totals.Failed++
+		}
+		r.Destinations[i].Migrated += totals.Migrated
+		r.Destinations[i].Skipped += totals.Skipped
+		r.Destinations[i].Failed += totals.Failed
+		r.Destinations[i].Succeeded = r.Destinations[i].Succeeded && totals.Failed == 0
+	}
+}
+
+// done records the duration of the migration of the namespace
+func (r *NamespaceReport) done() {
+	r.Duration = time.Since(r.startedAt).Round(time.Millisecond).String()
+}
+
+// skip records a service migrated by a previous run, or kept as it is in the destination with --on-conflict skip
+func (r *NamespaceReport) skip(name string) {
+	r.Services = append(r.Services, ServiceReport{Name: name, Status: ServiceStatusSkipped, Revisions: []string{}, Duration: "0s"})
+}
+
+// rename records the destination names of the renamed services
+func (r *NamespaceReport) rename(renames map[string]string) {
+	for i := range r.Services {
+		if name, ok := renames[r.Services[i].Name]; ok {
+			r.Services[i].DestinationName = name
+		}
+	}
+}
+
+// destination returns the name of the service in the destination
+func (s ServiceReport) destination() string {
+	if s.DestinationName != "" {
+		return s.DestinationName
+	}
+	return s.Name
+}
+
+// failures returns the number of failed services and namespaces
+func (r *MigrationReport) failures() int {
+	failures := 0
+	for _, namespace := range r.Namespaces {
+		if namespace.Error != "" {
+			failures++
+		}
+		for _, service := range namespace.Services {
+			if service.Status == ServiceStatusFailed {
+				failures++
+			}
+		}
+	}
+	return failures
+}
+
+// add records the result of a service migrated in the given duration
+func (r *NamespaceReport) add(name string, revisions, dependencies []string, duration time.Duration, err error) {
+	report := ServiceReport{
+		Name:         name,
+		Status:       ServiceStatusMigrated,
+		Revisions:    revisions,
+		Dependencies: dependencies,
+		Duration:     duration.Round(time.Millisecond).String(),
+	}
+	if report.Revisions == nil {
+		report.Revisions = []string{}
+	}
+	if err != nil {
+		report.Status = ServiceStatusFailed
+		report.Error = err.Error()
+	}
+	r.Services = append(r.Services, report)
+}
+
+// namespaceTotals is a row of the summary table
+type namespaceTotals struct {
+	Migrated     int
+	Skipped      int
+	Failed       int
+	Revisions    int
+	Dependencies int
+}
+
+func (t *namespaceTotals) add(other namespaceTotals) {
+	t.Migrated += other.Migrated
+	t.Skipped += other.Skipped
+	t.Failed += other.Failed
+	t.Revisions += other.Revisions
+	t.Dependencies += other.Dependencies
+}
+
+// totals counts the services of the namespace by status, the revisions they replayed and the objects copied
+func (r *NamespaceReport) totals() namespaceTotals {
+	totals := namespaceTotals{Dependencies: len(r.Dependencies)}
+	for _, service := range r.Services {
+		switch service.Status {
+		case ServiceStatusMigrated:
+			totals.Migrated++
+		case ServiceStatusSkipped:
+			totals.Skipped++
+		case ServiceStatusFailed:
+			totals.Failed++
+		}
+		totals.Revisions += len(service.Revisions)
+		totals.Dependencies += len(service.Dependencies)
+	}
+	return totals
+}
+
+// printSummary writes the summary table of a run, one row per namespace, followed by the failures if any.
+// It is the authoritative outcome of the run and is printed at the end of every run.
+func printSummary(out io.Writer, report *MigrationReport) {
+	const row = "%-25s%-25s%-10s%-9s%-8s%-11s%-14s%s\n"
+	fmt.Fprintln(out, color.GreenString("[Migration summary]"))
+	color.New(color.FgCyan).Fprintf(out, row, "Namespace", "Destination", "Migrated", "Skipped", "Failed", "Revisions", "Dependencies", "Duration")
+	total := namespaceTotals{}
+	for _, namespace := range report.Namespaces {
+		totals := namespace.totals()
+		if namespace.Error != "" {
+			totals.Failed++
+		}
+		total.add(totals)
+		duration := namespace.Duration
+		if duration == "" {
+			duration = "-"
+		}
+		fmt.Fprintf(out, row, namespace.SourceNamespace, namespace.DestinationNamespace, strconv.Itoa(totals.Migrated), strconv.Itoa(totals.Skipped), strconv.Itoa(totals.Failed), strconv.Itoa(totals.Revisions), strconv.Itoa(totals.Dependencies), duration)
+	}
+	duration := report.Duration
+	if duration == "" {
+		duration = time.Since(report.StartedAt.Time).Round(time.Millisecond).String()
+	}
+	color.New(color.Bold).Fprintf(out, row, "Total", "", strconv.Itoa(total.Migrated), strconv.Itoa(total.Skipped), strconv.Itoa(total.Failed), strconv.Itoa(total.Revisions), strconv.Itoa(total.Dependencies), duration)
+	if len(report.Destinations) > 0 {
+		const destinationRow = "%-50s%-10s%-9s%s\n"
+		fmt.Fprintln(out, "")
+		color.New(color.FgCyan).Fprintf(out, destinationRow, "Destination cluster", "Migrated", "Skipped", "Failed")
+		for _, destination := range report.Destinations {
+			fmt.Fprintf(out, destinationRow, destination.Cluster, strconv.Itoa(destination.Migrated), strconv.Itoa(destination.Skipped), strconv.Itoa(destination.Failed))
+		}
+	}
+
+	for _, namespace := range report.Namespaces {
+		if namespace.Error != "" {
+			fmt.Fprintln(out, color.RedString("  |- namespace %s: %s", namespace.SourceNamespace, namespace.Error))
+		}
+		for _, service := range namespace.Services {
+			if service.Status == ServiceStatusFailed {
+				fmt.Fprintln(out, color.RedString("  |- service %s/%s: %s", namespace.SourceNamespace, service.Name, service.Error))
+			}
+		}
+	}
+	switch {
+	case report.Error != "" && report.failures() == 0:
+		fmt.Fprintln(out, color.RedString("The run failed: %s", report.Error))
+	case report.failures() > 0:
+		fmt.Fprintln(out, color.RedString("%d failure(s), failed services are kept in the source cluster", report.failures()))
+	default:
+		fmt.Fprintln(out, color.GreenString("All services migrated successfully"))
+	}
+}
+
+// printOutcome ends a run with the summary table, or with the structured report in the given format while the
+// summary table then goes to errOut
+func printOutcome(out, errOut io.Writer, report *MigrationReport, format string) error {
+	if format == "" {
+		printSummary(out, report)
+		return nil
+	}
+	printSummary(errOut, report)
+	return printStructured(out, report, format)
+}
+
+// printInterrupted tells out which services were migrated before the run was interrupted by a signal or by --timeout
+func printInterrupted(out io.Writer, report *MigrationReport, err error) {
+	report.Interrupted = true
+	reason := "interrupted"
+	if errors.Is(err, context.DeadlineExceeded) {
+		reason = "stopped by --timeout"
+	}
+	migrated := 0
+	for _, namespace := range report.Namespaces {
+		for _, service := range namespace.Services {
+			if service.Status == ServiceStatusMigrated {
+				migrated++
+			}
+		}
+	}
+	fmt.Fprintln(out, color.YellowString("The migration was %s after migrating %d service(s), run the same command with --resume to migrate the remaining services", reason, migrated))
+}
+
+// validateOutputFormat checks the value of --output, empty is the human readable output
+func validateOutputFormat(format string) error {
+	switch format {
+	case "", "json", "yaml":
+		return nil
+	default:
+		return fmt.Errorf("unsupported output format %q, supported formats are: json, yaml", format)
+	}
+}
+
+// printStructured writes the object as indented JSON or as YAML
+func printStructured(out io.Writer, object interface{}, format string) error {
+	var data []byte
+	var err error
+	switch format {
+	case "json":
+		data, err = json.MarshalIndent(object, "", "  ")
+		data = append(data, '\n')
+	case "yaml":
+		data, err = yaml.Marshal(object)
+	default:
+		return validateOutputFormat(format)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(data)
+	return err
+}
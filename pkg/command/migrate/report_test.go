@@ -0,0 +1,51 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+func TestMigrationReport(t *testing.T) {
+	report := newMigrationReport()
+	namespace := report.namespace("default", "prod")
+	namespace.add("hello", []string{"hello-00001"}, []string{"ConfigMap hello-config"}, time.Second, nil)
+	namespace.add("bye", nil, nil, time.Second, errors.New("quota exceeded"))
+	namespace.Dependencies = []string{"DomainMapping api.example.com"}
+	namespace.done()
+	report.namespace("team-a", "team-a").Error = "cannot create namespace"
+	report.finish(nil)
+
+	assert.Equal(t, report.failures(), 2)
+	assert.Assert(t, !report.Succeeded)
+	assert.Equal(t, namespace.Services[1].Status, ServiceStatusFailed)
+	assert.DeepEqual(t, namespace.Services[1].Revisions, []string{})
+
+	out := &bytes.Buffer{}
+	assert.NilError(t, printStructured(out, report, "yaml"))
+	assert.Assert(t, strings.Contains(out.String(), "error: quota exceeded"))
+
+	out.Reset()
+	printSummary(out, report)
+	assert.Assert(t, strings.Contains(out.String(), "2 failure(s)"))
+	assert.Assert(t, strings.Contains(out.String(), "service default/bye: quota exceeded"))
+	assert.DeepEqual(t, namespace.totals(), namespaceTotals{Migrated: 1, Failed: 1, Revisions: 1, Dependencies: 2})
+}
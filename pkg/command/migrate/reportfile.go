@@ -0,0 +1,92 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// usedFlags returns the flags set on the command line of a run by name
+func usedFlags(cmd *cobra.Command) map[string]string {
+	flags := map[string]string{}
+	cmd.Flags().Visit(func(flag *pflag.Flag) {
+		flags[flag.Name] = flag.Value.String()
+	})
+	return flags
+}
+
+// writeReportFile writes the report of a run to a file to attach to a change ticket, as an HTML page if the
+// extension of the file is .html or .htm, as indented JSON otherwise
+func writeReportFile(path string, report *MigrationReport) error {
+	var data []byte
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".html", ".htm":
+		var page bytes.Buffer
+		err = reportPage.Execute(&page, report)
+		data = page.Bytes()
+	default:
+		data, err = json.MarshalIndent(report, "", "  ")
+		data = append(data, '\n')
+	}
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+var reportPage = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>kn migration report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.8em; text-align: left; vertical-align: top; }
+.failed { color: #b00; }
+.migrated { color: #070; }
+.warning { color: #a60; }
+</style>
+</head>
+<body>
+<h1>Knative migration report</h1>
+<p>{{if .Succeeded}}<span class="migrated">Succeeded</span>{{else}}<span class="failed">Failed{{if .Error}}: {{.Error}}{{end}}</span>{{end}}{{if .RolledBack}}, rolled back{{end}}{{if .Interrupted}}, interrupted{{end}}</p>
+<p>Started at {{.StartedAt.Format "2006-01-02 15:04:05 MST"}}, finished at {{.FinishedAt.Format "2006-01-02 15:04:05 MST"}}, took {{.Duration}}</p>
+{{if .Command}}<h2>Command</h2>
+<table>
+<tr><th>Command</th><td><code>{{.Command}}</code></td></tr>
+{{range $name, $value := .Flags}}<tr><th>--{{$name}}</th><td><code>{{$value}}</code></td></tr>
+{{end}}</table>
+{{end}}{{if .Warnings}}<h2>Warnings</h2>
+<ul>{{range .Warnings}}<li class="warning">{{.}}</li>{{end}}</ul>
+{{end}}{{range .Namespaces}}<h2>Namespace {{.SourceNamespace}} to {{.DestinationNamespace}}</h2>
+{{if .Error}}<p class="failed">{{.Error}}</p>
+{{end}}<table>
+<tr><th>Service</th><th>Status</th><th>Revisions</th><th>Duration</th><th>Dependencies</th><th>Error</th></tr>
+{{range .Services}}<tr><td>{{.Name}}</td><td class="{{.Status}}">{{.Status}}</td><td>{{len .Revisions}}</td><td>{{.Duration}}</td><td>{{range .Dependencies}}{{.}}<br>{{end}}</td><td class="failed">{{.Error}}</td></tr>
+{{end}}</table>
+{{if .Dependencies}}<p>Dependencies: {{range $i, $d := .Dependencies}}{{if $i}}, {{end}}{{$d}}{{end}}</p>
+{{end}}{{end}}</body>
+</html>
+`))
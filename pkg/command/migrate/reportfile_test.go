@@ -0,0 +1,70 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"gotest.tools/assert"
+)
+
+func TestWriteReportFile(t *testing.T) {
+	cmd := &cobra.Command{Use: "migrate"}
+	cmd.Flags().String("namespace", "", "")
+	cmd.Flags().Bool("delete", false, "")
+	cmd.Flags().Int("concurrency", 1, "")
+	assert.NilError(t, cmd.Flags().Parse([]string{"--namespace", "default", "--delete"}))
+
+	options := NewMigrationOptions()
+	options.warn("Revision %s failed to become Ready in the destination, continue with the next revision", "hello-00001")
+	report := newMigrationReport()
+	report.Command, report.Flags = cmd.CommandPath(), usedFlags(cmd)
+	namespace := report.namespace("default", "prod")
+	namespace.add("hello", []string{"hello-00001", "hello-00002"}, []string{"ConfigMap hello-config"}, 0, nil)
+	namespace.add("<script>", nil, nil, 0, errors.New("quota exceeded"))
+	report.finish(nil)
+	report.Warnings = options.warnings()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "report.json")
+	assert.NilError(t, writeReportFile(path, report))
+	data, err := ioutil.ReadFile(path)
+	assert.NilError(t, err)
+	written := MigrationReport{}
+	assert.NilError(t, json.Unmarshal(data, &written))
+	// Only the flags set on the command line are recorded
+	assert.DeepEqual(t, written.Flags, map[string]string{"namespace": "default", "delete": "true"})
+	assert.Equal(t, written.Command, "migrate")
+	assert.DeepEqual(t, written.Warnings, []string{"Revision hello-00001 failed to become Ready in the destination, continue with the next revision"})
+	assert.Equal(t, len(written.Namespaces[0].Services[0].Revisions), 2)
+
+	path = filepath.Join(dir, "report.HTML")
+	assert.NilError(t, writeReportFile(path, report))
+	data, err = ioutil.ReadFile(path)
+	assert.NilError(t, err)
+	page := string(data)
+	assert.Assert(t, strings.HasPrefix(page, "<!DOCTYPE html>"))
+	assert.Assert(t, strings.Contains(page, "<tr><th>--namespace</th><td><code>default</code></td></tr>"), page)
+	assert.Assert(t, strings.Contains(page, "<td>hello</td><td class=\"migrated\">migrated</td><td>2</td>"), page)
+	assert.Assert(t, strings.Contains(page, "failed to become Ready"), page)
+	// Names are escaped
+	assert.Assert(t, !strings.Contains(page, "<script>"), page)
+}
@@ -0,0 +1,94 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	// retryBackoffFactor multiplies the delay between two retries
+	retryBackoffFactor = 2.0
+	// retryBackoffJitter adds up to 20% of random delay, so that parallel workers don't retry in lockstep
+	retryBackoffJitter = 0.2
+	// maxRetryBackoff caps the delay between two retries at the fixed delay of the former retry loops, so that
+	// the default retries of a loop still wait about 16s at most
+	maxRetryBackoff = time.Second
+)
+
+// errRetryBudgetExhausted is returned once a run spent its whole retry budget
+var errRetryBudgetExhausted = errors.New("retry budget exhausted")
+
+// retryBudget limits the total time a run spends waiting between retries,
+// so that API flakiness fails the run early instead of multiplying retries across objects
+type retryBudget struct {
+	mu    sync.Mutex
+	limit time.Duration
+	spent time.Duration
+}
+
+// newRetryBudget creates a budget of the given total duration, zero means unlimited
+func newRetryBudget(limit time.Duration) *retryBudget {
+	return &retryBudget{limit: limit}
+}
+
+// wait sleeps for d before a retry of what, or fails if the budget does not allow it or the context is done
+func (b *retryBudget) wait(ctx context.Context, d time.Duration, what string) error {
+	b.mu.Lock()
+	if b.limit > 0 && b.spent+d > b.limit {
+		spent := b.spent
+		b.mu.Unlock()
+		return fmt.Errorf("%w: spent %s of %s in retries, giving up to %s", errRetryBudgetExhausted, spent, b.limit, what)
+	}
+	b.spent += d
+	b.mu.Unlock()
+
+	return sleep(ctx, d)
+}
+
+// sleep waits for d, or returns the error of the context once it is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// newRetryBackoff returns an exponential backoff with jitter starting at the given delay, capped at
+// maxRetryBackoff or at the initial delay if it is longer. Every retry loop has its own backoff.
+func newRetryBackoff(initial time.Duration) *wait.Backoff {
+	limit := maxRetryBackoff
+	if initial > limit {
+		limit = initial
+	}
+	return &wait.Backoff{
+		Duration: initial,
+		Factor:   retryBackoffFactor,
+		Jitter:   retryBackoffJitter,
+		Steps:    math.MaxInt32,
+		Cap:      limit,
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		initial time.Duration
+		delays  []time.Duration
+	}{
+		{"default", DefaultRetryBackoff, []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second}},
+		{"capped", 300 * time.Millisecond, []time.Duration{300 * time.Millisecond, 600 * time.Millisecond, time.Second, time.Second}},
+		{"longer than the cap", 5 * time.Second, []time.Duration{5 * time.Second, 5 * time.Second, 5 * time.Second}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			backoff := newRetryBackoff(tc.initial)
+			for i, expected := range tc.delays {
+				// The jitter adds up to 20% to every delay
+				delay := backoff.Step()
+				assert.Assert(t, delay >= expected && delay <= expected+expected/5, "delay %d is %s, expected %s", i, delay, expected)
+			}
+		})
+	}
+
+	// The default retries of a loop wait about as long as the former 16 retries after 1s
+	var total time.Duration
+	backoff := newRetryBackoff(DefaultRetryBackoff)
+	for i := 0; i < DefaultMaxRetries; i++ {
+		total += backoff.Step()
+	}
+	assert.Assert(t, total >= 13500*time.Millisecond && total <= 16200*time.Millisecond, "total %s", total)
+}
+
+func TestRetryBudget(t *testing.T) {
+	budget := newRetryBudget(30 * time.Millisecond)
+	assert.NilError(t, budget.wait(context.Background(), 10*time.Millisecond, "get service hello"))
+	assert.NilError(t, budget.wait(context.Background(), 10*time.Millisecond, "get service hello"))
+	// A wait exceeding what is left fails, a shorter one still fits
+	err := budget.wait(context.Background(), 20*time.Millisecond, "update revision hello-00001")
+	assert.Assert(t, errors.Is(err, errRetryBudgetExhausted))
+	assert.ErrorContains(t, err, "spent 20ms of 30ms in retries, giving up to update revision hello-00001")
+	assert.NilError(t, budget.wait(context.Background(), 10*time.Millisecond, "get service hello"))
+	assert.Assert(t, errors.Is(budget.wait(context.Background(), time.Millisecond, "get service hello"), errRetryBudgetExhausted))
+
+	// Without a limit the waits are only bounded by the context
+	unlimited := newRetryBudget(0)
+	for i := 0; i < 10; i++ {
+		assert.NilError(t, unlimited.wait(context.Background(), time.Millisecond, "get service hello"))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, unlimited.wait(ctx, time.Hour, "get service hello"), context.Canceled)
+
+	// The services migrated in parallel share the budget of the run
+	options := NewMigrationOptions()
+	options.RetryBudget = 50 * time.Millisecond
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	exhausted := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := options.wait(context.Background(), 10*time.Millisecond, "get service hello"); errors.Is(err, errRetryBudgetExhausted) {
+				mu.Lock()
+				exhausted++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, exhausted, 5)
+}
@@ -0,0 +1,178 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"fmt"
+	"strconv"
+
+	"knative.dev/kn-plugin-migration/pkg/command"
+	"knative.dev/pkg/apis"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+// RevisionSelection tells which source revisions of a service are recreated in the destination: every revision,
+// the revisions its traffic routes to, its latest revision, or a number of its most recent revisions.
+// The latest revision is always migrated since the destination service creates it from its template.
+type RevisionSelection string
+
+const (
+	// RevisionsAll migrates every revision of a service
+	RevisionsAll RevisionSelection = "all"
+	// RevisionsTraffic migrates the revisions named by the traffic block of a service and its latest revision
+	RevisionsTraffic RevisionSelection = "traffic"
+	// RevisionsLatest migrates the latest revision of a service only
+	RevisionsLatest RevisionSelection = "latest"
+)
+
+// String implements pflag.Value
+func (s *RevisionSelection) String() string {
+	return string(*s)
+}
+
+// Set implements pflag.Value
+func (s *RevisionSelection) Set(value string) error {
+	selection, err := parseRevisionSelection(value)
+	if err != nil {
+		return err
+	}
+	*s = selection
+	return nil
+}
+
+// Type implements pflag.Value
+func (s *RevisionSelection) Type() string {
+	return "string"
+}
+
+func parseRevisionSelection(selection string) (RevisionSelection, error) {
+	switch RevisionSelection(selection) {
+	case RevisionsAll, RevisionsTraffic, RevisionsLatest:
+		return RevisionSelection(selection), nil
+	}
+	if count, err := strconv.Atoi(selection); err == nil && count > 0 {
+		return RevisionSelection(selection), nil
+	}
+	return "", fmt.Errorf("unsupported revision selection %q, supported selections are: all, traffic, latest, or a number of most recent revisions", selection)
+}
+
+// selects returns true if the selection may leave out some revisions of a service
+func (s RevisionSelection) selects() bool {
+	return s != "" && s != RevisionsAll
+}
+
+// selectRevisions returns the revisions of a service kept by the selection in their order, and the names of the
+// revisions left out. The revisions are listed from the oldest to the most recent one.
+func selectRevisions(service serving_v1_api.Service, revisions *serving_v1_api.RevisionList, selection RevisionSelection) (*serving_v1_api.RevisionList, []string) {
+	left := []string{}
+	if !selection.selects() {
+		return revisions, left
+	}
+	kept := map[string]bool{command.TemplateRevisionName(service): true}
+	switch selection {
+	case RevisionsTraffic:
+		for _, target := range service.Spec.Traffic {
+			if target.RevisionName != "" {
+				kept[target.RevisionName] = true
+			}
+		}
+	case RevisionsLatest:
+	default:
+		count, _ := strconv.Atoi(string(selection))
+		for i := len(revisions.Items) - count; i < len(revisions.Items); i++ {
+			if i >= 0 {
+				kept[revisions.Items[i].Name] = true
+			}
+		}
+	}
+	selected := revisions.DeepCopy()
+	selected.Items = nil
+	for _, revision := range revisions.Items {
+		if kept[revision.Name] {
+			selected.Items = append(selected.Items, revision)
+		} else {
+			left = append(left, revision.Name)
+		}
+	}
+	return selected, left
+}
+
+// brokenRevision is a source revision whose Ready condition is False, e.g. because its image no longer exists,
+// which would never become Ready in the destination either
+type brokenRevision struct {
+	Name   string
+	Reason string
+	// Needed is true for the latest revision, created by the service, and the revisions its traffic routes to,
+	// which cannot be left out
+	Needed bool
+}
+
+// findBrokenRevisions returns the revisions of a service which are not Ready in the source
+func findBrokenRevisions(service serving_v1_api.Service, revisions *serving_v1_api.RevisionList) []brokenRevision {
+	needed := map[string]bool{command.TemplateRevisionName(service): true}
+	for _, target := range service.Spec.Traffic {
+		if target.RevisionName != "" {
+			needed[target.RevisionName] = true
+		}
+	}
+	broken := []brokenRevision{}
+	for _, revision := range revisions.Items {
+		ready := revision.Status.GetCondition(apis.ConditionReady)
+		if ready == nil || !ready.IsFalse() {
+			continue
+		}
+		reason := ready.Reason
+		if ready.Message != "" {
+			reason += ": " + ready.Message
+		}
+		broken = append(broken, brokenRevision{Name: revision.Name, Reason: reason, Needed: needed[revision.Name]})
+	}
+	return broken
+}
+
+// skipBrokenRevisions warns about the broken revisions of a service and leaves out those the service does not need
+// with SkipBrokenRevisions, it returns the remaining revisions and the names of the revisions left out
+func skipBrokenRevisions(service serving_v1_api.Service, revisions *serving_v1_api.RevisionList, options *MigrationOptions) (*serving_v1_api.RevisionList, []string) {
+	skipped := map[string]bool{}
+	left := []string{}
+	for _, broken := range findBrokenRevisions(service, revisions) {
+		switch {
+		case broken.Needed:
+			options.warn("Revision %s of service %s is not Ready in the source (%s), it is migrated since the service needs it", broken.Name, service.Name, broken.Reason)
+		case options.SkipBrokenRevisions:
+			options.warn("Skip migrate revision %s of service %s which is not Ready in the source (%s)", broken.Name, service.Name, broken.Reason)
+			skipped[broken.Name] = true
+			left = append(left, broken.Name)
+		default:
+			options.warn("Revision %s of service %s is not Ready in the source (%s) and will not become Ready in the destination, use --skip-broken-revisions to leave it out", broken.Name, service.Name, broken.Reason)
+		}
+	}
+	if len(left) == 0 {
+		return revisions, left
+	}
+	return withoutRevisions(revisions, skipped), left
+}
+
+// withoutRevisions returns a copy of a list of revisions without the revisions of the given names
+func withoutRevisions(revisions *serving_v1_api.RevisionList, names map[string]bool) *serving_v1_api.RevisionList {
+	kept := revisions.DeepCopy()
+	kept.Items = nil
+	for _, revision := range revisions.Items {
+		if !names[revision.Name] {
+			kept.Items = append(kept.Items, revision)
+		}
+	}
+	return kept
+}
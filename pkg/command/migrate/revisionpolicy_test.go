@@ -0,0 +1,115 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"testing"
+
+	"gotest.tools/assert"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/apis"
+	duckv1 "knative.dev/pkg/apis/duck/v1"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+func TestParseRevisionSelection(t *testing.T) {
+	for _, value := range []string{"all", "traffic", "latest", "3"} {
+		selection, err := parseRevisionSelection(value)
+		assert.NilError(t, err)
+		assert.Equal(t, string(selection), value)
+	}
+	for _, value := range []string{"0", "-1", "newest"} {
+		_, err := parseRevisionSelection(value)
+		assert.ErrorContains(t, err, "unsupported revision selection")
+	}
+}
+
+func TestSelectRevisions(t *testing.T) {
+	percent := func(p int64) *int64 { return &p }
+	service := serving_v1_api.Service{}
+	service.Status.LatestCreatedRevisionName = "hello-00005"
+	service.Spec.Traffic = []serving_v1_api.TrafficTarget{{RevisionName: "hello-00002", Percent: percent(10)}, {LatestRevision: &[]bool{true}[0], Percent: percent(90)}}
+	revisions := &serving_v1_api.RevisionList{}
+	for _, name := range []string{"hello-00001", "hello-00002", "hello-00003", "hello-00004", "hello-00005"} {
+		revisions.Items = append(revisions.Items, serving_v1_api.Revision{ObjectMeta: metav1.ObjectMeta{Name: name}})
+	}
+
+	for _, test := range []struct {
+		selection RevisionSelection
+		selected  []string
+		left      []string
+	}{
+		{"", []string{"hello-00001", "hello-00002", "hello-00003", "hello-00004", "hello-00005"}, []string{}},
+		{RevisionsAll, []string{"hello-00001", "hello-00002", "hello-00003", "hello-00004", "hello-00005"}, []string{}},
+		{RevisionsTraffic, []string{"hello-00002", "hello-00005"}, []string{"hello-00001", "hello-00003", "hello-00004"}},
+		{RevisionsLatest, []string{"hello-00005"}, []string{"hello-00001", "hello-00002", "hello-00003", "hello-00004"}},
+		{"2", []string{"hello-00004", "hello-00005"}, []string{"hello-00001", "hello-00002", "hello-00003"}},
+		{"10", []string{"hello-00001", "hello-00002", "hello-00003", "hello-00004", "hello-00005"}, []string{}},
+	} {
+		selected, left := selectRevisions(service, revisions, test.selection)
+		assert.DeepEqual(t, revisionNames(selected), test.selected)
+		assert.DeepEqual(t, left, test.left)
+	}
+	assert.Equal(t, len(revisions.Items), 5)
+}
+
+func TestMigrateLatestRevisionOnly(t *testing.T) {
+	source := simulatedBundle("default", "hello")
+	clientSetD, migrationClientD := newSimulatedDestination("prod", &bundleSource{})
+	options := NewMigrationOptions()
+	options.Revisions = RevisionsLatest
+	revisions, _, err := migrateService(context.Background(), source, clientSetD, migrationClientD, "prod", source.services[0], options)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, revisions, []string{"hello-00002"})
+
+	revisionsD, err := migrationClientD.ListRevisionByService(context.Background(), "hello")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, revisionNames(revisionsD), []string{"hello-00002"})
+}
+
+func TestSkipBrokenRevisions(t *testing.T) {
+	source := simulatedBundle("default", "hello")
+	broken := duckv1.Conditions{{Type: apis.ConditionReady, Status: apiv1.ConditionFalse, Reason: "ContainerMissing", Message: "image not found"}}
+	source.revisions["hello"][0].Status.Conditions = broken
+	// The latest revision is needed by the service even when broken
+	source.revisions["hello"][1].Status.Conditions = broken
+
+	found := findBrokenRevisions(source.services[0], &serving_v1_api.RevisionList{Items: source.revisions["hello"]})
+	assert.DeepEqual(t, found, []brokenRevision{
+		{Name: "hello-00001", Reason: "ContainerMissing: image not found"},
+		{Name: "hello-00002", Reason: "ContainerMissing: image not found", Needed: true},
+	})
+
+	// Broken revisions are only reported without the option
+	clientSetD, migrationClientD := newSimulatedDestination("prod", &bundleSource{})
+	options := NewMigrationOptions()
+	revisions, _, err := migrateService(context.Background(), source, clientSetD, migrationClientD, "prod", source.services[0], options)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, revisions, []string{"hello-00001", "hello-00002"})
+	assert.Equal(t, len(options.warnings()), 2)
+
+	clientSetD, migrationClientD = newSimulatedDestination("prod", &bundleSource{})
+	options = NewMigrationOptions()
+	options.SkipBrokenRevisions = true
+	revisions, _, err = migrateService(context.Background(), source, clientSetD, migrationClientD, "prod", source.services[0], options)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, revisions, []string{"hello-00002"})
+	assert.DeepEqual(t, options.warnings(), []string{
+		"Skip migrate revision hello-00001 of service hello which is not Ready in the source (ContainerMissing: image not found)",
+		"Revision hello-00002 of service hello is not Ready in the source (ContainerMissing: image not found), it is migrated since the service needs it",
+	})
+}
@@ -0,0 +1,301 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	apiv1 "k8s.io/api/core/v1"
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"knative.dev/kn-plugin-migration/pkg/command"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+	serving_v1beta1_api "knative.dev/serving/pkg/apis/serving/v1beta1"
+	serving_v1_client "knative.dev/serving/pkg/client/clientset/versioned/typed/serving/v1"
+	serving_v1beta1_client "knative.dev/serving/pkg/client/clientset/versioned/typed/serving/v1beta1"
+)
+
+// replaceRestoreTimeout is the maximum time to wait for a migrated service to be deleted
+// before the service it replaced is restored
+const replaceRestoreTimeout = time.Minute
+
+// journalEntry is a change made to the destination cluster which can be undone
+type journalEntry struct {
+	Kind      string
+	Name      string
+	Namespace string
+	// Replaced is the destination service deleted to be replaced with --force-recreate, recreated on rollback from
+	// its spec only: its revisions were garbage collected with it, the recreated service gets a single new revision
+	// from its template and the traffic to the other revisions is lost
+	Replaced *serving_v1_api.Service
+	// Previous is the destination service, configmap, secret, DomainMapping or eventing object updated with --force,
+	// its content is restored on rollback
+	Previous runtime.Object
+
+	clientSet       kubernetes.Interface
+	migrationClient command.MigrationClient
+	domainMappings  serving_v1beta1_client.DomainMappingsGetter
+	// servingClient is set for the standalone Configurations and Routes
+	servingClient serving_v1_client.ServingV1Interface
+	// dynamicClient and resource are set for the objects migrated without a typed client, e.g. eventing objects
+	dynamicClient dynamic.Interface
+	resource      schema.GroupVersionResource
+}
+
+// rollbackJournal records the changes made to the destination cluster in order, so that a failed run
+// can restore the destination to its state before the run
+type rollbackJournal struct {
+	mu      sync.Mutex
+	entries []journalEntry
+}
+
+// created records an object created in the destination, nothing is recorded by a nil journal
+func (j *rollbackJournal) created(kind, namespace, name string, clientSet kubernetes.Interface, migrationClient command.MigrationClient) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries = append(j.entries, journalEntry{Kind: kind, Name: name, Namespace: namespace, clientSet: clientSet, migrationClient: migrationClient})
+}
+
+// replaced records a destination service deleted to be replaced
+func (j *rollbackJournal) replaced(service *serving_v1_api.Service, migrationClient command.MigrationClient) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries = append(j.entries, journalEntry{Kind: "Service", Name: service.Name, Namespace: service.Namespace, Replaced: service, migrationClient: migrationClient})
+}
+
+// updatedService records a destination service the migrated service was applied over
+func (j *rollbackJournal) updatedService(previous *serving_v1_api.Service, migrationClient command.MigrationClient) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries = append(j.entries, journalEntry{Kind: "Service", Name: previous.Name, Namespace: previous.Namespace, Previous: previous, migrationClient: migrationClient})
+}
+
+// updated records a destination configmap or secret replaced in place, previous is its content before the update
+func (j *rollbackJournal) updated(kind, namespace, name string, previous runtime.Object, clientSet kubernetes.Interface) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries = append(j.entries, journalEntry{Kind: kind, Name: name, Namespace: namespace, Previous: previous, clientSet: clientSet})
+}
+
+// createdDomainMapping records a DomainMapping created in the destination
+func (j *rollbackJournal) createdDomainMapping(namespace, name string, domainMappings serving_v1beta1_client.DomainMappingsGetter) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries = append(j.entries, journalEntry{Kind: "DomainMapping", Name: name, Namespace: namespace, domainMappings: domainMappings})
+}
+
+// updatedDomainMapping records a destination DomainMapping replaced in place, previous is its content before the update
+func (j *rollbackJournal) updatedDomainMapping(previous *serving_v1beta1_api.DomainMapping, domainMappings serving_v1beta1_client.DomainMappingsGetter) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries = append(j.entries, journalEntry{Kind: "DomainMapping", Name: previous.Name, Namespace: previous.Namespace, Previous: previous, domainMappings: domainMappings})
+}
+
+// createdServing records a standalone Configuration or Route created in the destination
+func (j *rollbackJournal) createdServing(kind, namespace, name string, servingClient serving_v1_client.ServingV1Interface) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries = append(j.entries, journalEntry{Kind: kind, Name: name, Namespace: namespace, servingClient: servingClient})
+}
+
+// createdDynamic records an object of the given resource created in the destination
+func (j *rollbackJournal) createdDynamic(kind string, resource schema.GroupVersionResource, namespace, name string, client dynamic.Interface) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries = append(j.entries, journalEntry{Kind: kind, Name: name, Namespace: namespace, dynamicClient: client, resource: resource})
+}
+
+// updatedDynamic records a destination object of the given resource replaced in place, previous is its content before the update
+func (j *rollbackJournal) updatedDynamic(kind string, resource schema.GroupVersionResource, previous *unstructured.Unstructured, client dynamic.Interface) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries = append(j.entries, journalEntry{Kind: kind, Name: previous.GetName(), Namespace: previous.GetNamespace(), Previous: previous, dynamicClient: client, resource: resource})
+}
+
+// mark returns the position of the next change, to undo the changes recorded after it with rollbackSince
+func (j *rollbackJournal) mark() int {
+	if j == nil {
+		return 0
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return len(j.entries)
+}
+
+// rollback undoes the recorded changes in reverse order, objects already gone are ignored
+// and the remaining changes are still undone when one of them fails
+func (j *rollbackJournal) rollback(ctx context.Context, out io.Writer) error {
+	return j.rollbackSince(ctx, out, 0)
+}
+
+// rollbackSince undoes the changes recorded after a mark in reverse order and forgets them
+func (j *rollbackJournal) rollbackSince(ctx context.Context, out io.Writer, mark int) error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	fmt.Fprintln(out, color.YellowString("Rolling back %d change(s) in the destination cluster", len(j.entries)-mark))
+	failed := 0
+	for i := len(j.entries) - 1; i >= mark; i-- {
+		entry := j.entries[i]
+		err := entry.undo(ctx)
+		if err != nil && !api_errors.IsNotFound(err) {
+			fmt.Fprintln(out, color.RedString("Failed to roll back %s %s in namespace %s: %s", entry.Kind, entry.Name, entry.Namespace, err.Error()))
+			failed++
+			continue
+		}
+		if entry.Replaced != nil {
+			fmt.Fprintln(out, "Recreated", entry.Kind, color.CyanString(entry.Name), "in namespace", color.BlueString(entry.Namespace), color.YellowString("from its spec, its previous revisions are not restored"))
+		} else if entry.Previous != nil {
+			fmt.Fprintln(out, "Restored", entry.Kind, color.CyanString(entry.Name), "in namespace", color.BlueString(entry.Namespace))
+		} else {
+			fmt.Fprintln(out, "Deleted", entry.Kind, color.CyanString(entry.Name), "in namespace", color.BlueString(entry.Namespace))
+		}
+	}
+	j.entries = j.entries[:mark]
+	if failed > 0 {
+		return fmt.Errorf("%d change(s) could not be rolled back in the destination cluster", failed)
+	}
+	return nil
+}
+
+func (e journalEntry) undo(ctx context.Context) error {
+	if e.Replaced != nil {
+		// The migrated service deleted just before may still be terminating
+		return wait.PollImmediateWithContext(ctx, time.Second, replaceRestoreTimeout, func(ctx context.Context) (bool, error) {
+			_, err := e.migrationClient.CreateService(ctx, e.Replaced.DeepCopy())
+			if api_errors.IsAlreadyExists(err) {
+				return false, nil
+			}
+			return err == nil, err
+		})
+	}
+	switch previous := e.Previous.(type) {
+	case *serving_v1_api.Service:
+		restored, err := e.migrationClient.GetService(ctx, e.Name)
+		if err != nil {
+			return err
+		}
+		restored.Labels = previous.Labels
+		restored.Annotations = previous.Annotations
+		restored.Spec = previous.Spec
+		_, err = e.migrationClient.UpdateService(ctx, restored)
+		return err
+	case *apiv1.ConfigMap:
+		restored := previous.DeepCopy()
+		restored.ResourceVersion = ""
+		_, err := e.clientSet.CoreV1().ConfigMaps(e.Namespace).Update(ctx, restored, metav1.UpdateOptions{})
+		return err
+	case *apiv1.Secret:
+		restored := previous.DeepCopy()
+		restored.ResourceVersion = ""
+		_, err := e.clientSet.CoreV1().Secrets(e.Namespace).Update(ctx, restored, metav1.UpdateOptions{})
+		return err
+	case *serving_v1beta1_api.DomainMapping:
+		restored, err := e.domainMappings.DomainMappings(e.Namespace).Get(ctx, e.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		restored.Spec = previous.Spec
+		_, err = e.domainMappings.DomainMappings(e.Namespace).Update(ctx, restored, metav1.UpdateOptions{})
+		return err
+	case *unstructured.Unstructured:
+		objects := e.dynamicClient.Resource(e.resource).Namespace(e.Namespace)
+		restored, err := objects.Get(ctx, e.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		restored.Object["spec"] = previous.Object["spec"]
+		_, err = objects.Update(ctx, restored, metav1.UpdateOptions{})
+		return err
+	}
+	if e.dynamicClient != nil {
+		return e.dynamicClient.Resource(e.resource).Namespace(e.Namespace).Delete(ctx, e.Name, metav1.DeleteOptions{})
+	}
+	switch e.Kind {
+	case "Namespace":
+		return e.clientSet.CoreV1().Namespaces().Delete(ctx, e.Name, metav1.DeleteOptions{})
+	case "ConfigMap":
+		return e.clientSet.CoreV1().ConfigMaps(e.Namespace).Delete(ctx, e.Name, metav1.DeleteOptions{})
+	case "Secret":
+		return e.clientSet.CoreV1().Secrets(e.Namespace).Delete(ctx, e.Name, metav1.DeleteOptions{})
+	case "ServiceAccount":
+		return e.clientSet.CoreV1().ServiceAccounts(e.Namespace).Delete(ctx, e.Name, metav1.DeleteOptions{})
+	case "PersistentVolumeClaim":
+		return e.clientSet.CoreV1().PersistentVolumeClaims(e.Namespace).Delete(ctx, e.Name, metav1.DeleteOptions{})
+	case "Role":
+		return e.clientSet.RbacV1().Roles(e.Namespace).Delete(ctx, e.Name, metav1.DeleteOptions{})
+	case "RoleBinding":
+		return e.clientSet.RbacV1().RoleBindings(e.Namespace).Delete(ctx, e.Name, metav1.DeleteOptions{})
+	case "NetworkPolicy":
+		return e.clientSet.NetworkingV1().NetworkPolicies(e.Namespace).Delete(ctx, e.Name, metav1.DeleteOptions{})
+	case "ResourceQuota":
+		return e.clientSet.CoreV1().ResourceQuotas(e.Namespace).Delete(ctx, e.Name, metav1.DeleteOptions{})
+	case "LimitRange":
+		return e.clientSet.CoreV1().LimitRanges(e.Namespace).Delete(ctx, e.Name, metav1.DeleteOptions{})
+	case "Service":
+		return e.migrationClient.DeleteService(ctx, e.Name)
+	case "Revision":
+		return e.migrationClient.DeleteRevision(ctx, e.Name)
+	case "DomainMapping":
+		return e.domainMappings.DomainMappings(e.Namespace).Delete(ctx, e.Name, metav1.DeleteOptions{})
+	case "Configuration":
+		return e.servingClient.Configurations(e.Namespace).Delete(ctx, e.Name, metav1.DeleteOptions{})
+	case "Route":
+		return e.servingClient.Routes(e.Namespace).Delete(ctx, e.Name, metav1.DeleteOptions{})
+	default:
+		return fmt.Errorf("cannot roll back unknown kind %s", e.Kind)
+	}
+}
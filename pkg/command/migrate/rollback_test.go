@@ -0,0 +1,74 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"knative.dev/kn-plugin-migration/pkg/command"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+// recordingClient records the delete and create calls made by a rollback
+type recordingClient struct {
+	command.MigrationClient
+	calls []string
+}
+
+func (c *recordingClient) DeleteService(ctx context.Context, name string) error {
+	c.calls = append(c.calls, "delete service "+name)
+	return nil
+}
+
+func (c *recordingClient) DeleteRevision(ctx context.Context, name string) error {
+	c.calls = append(c.calls, "delete revision "+name)
+	return api_errors.NewNotFound(schema.GroupResource{Resource: "revisions"}, name)
+}
+
+func (c *recordingClient) CreateService(ctx context.Context, service *serving_v1_api.Service) (*serving_v1_api.Service, error) {
+	c.calls = append(c.calls, "create service "+service.Name)
+	return service, nil
+}
+
+func TestRollbackJournal(t *testing.T) {
+	client := &recordingClient{}
+	journal := &rollbackJournal{}
+	journal.created("Service", "default", "hello", nil, client)
+	journal.created("Revision", "default", "hello-00001", nil, client)
+	journal.replaced(&serving_v1_api.Service{ObjectMeta: metav1.ObjectMeta{Name: "bye", Namespace: "default"}}, client)
+	journal.created("Service", "default", "bye", nil, client)
+
+	var out bytes.Buffer
+	assert.NilError(t, journal.rollback(context.Background(), &out))
+	assert.DeepEqual(t, client.calls, []string{
+		"delete service bye",
+		"create service bye",
+		"delete revision hello-00001",
+		"delete service hello",
+	})
+	assert.Equal(t, len(journal.entries), 0)
+	// A recreated service is restored from its spec, without its revisions
+	assert.Assert(t, strings.Contains(out.String(), "Recreated Service bye in namespace default from its spec, its previous revisions are not restored"), out.String())
+
+	var disabled *rollbackJournal
+	disabled.created("Service", "default", "hello", nil, client)
+}
@@ -0,0 +1,142 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/fatih/color"
+	apiv1 "k8s.io/api/core/v1"
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"knative.dev/kn-plugin-migration/pkg/command"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+// referencedSecrets returns the sorted names of the secrets a revision template reads, through the environment
+// of its containers, its volumes or its image pull secrets
+func referencedSecrets(template serving_v1_api.RevisionTemplateSpec) []string {
+	names := map[string]bool{}
+	for _, container := range template.Spec.Containers {
+		for _, env := range container.Env {
+			if env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil {
+				names[env.ValueFrom.SecretKeyRef.Name] = true
+			}
+		}
+		for _, envFrom := range container.EnvFrom {
+			if envFrom.SecretRef != nil {
+				names[envFrom.SecretRef.Name] = true
+			}
+		}
+	}
+	for _, volume := range template.Spec.Volumes {
+		if volume.Secret != nil {
+			names[volume.Secret.SecretName] = true
+		}
+		if volume.Projected != nil {
+			for _, projection := range volume.Projected.Sources {
+				if projection.Secret != nil {
+					names[projection.Secret.Name] = true
+				}
+			}
+		}
+	}
+	for _, pullSecret := range template.Spec.ImagePullSecrets {
+		names[pullSecret.Name] = true
+	}
+
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		if name != "" {
+			sorted = append(sorted, name)
+		}
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+func getSecret(ctx context.Context, clientSet kubernetes.Interface, namespace, secretName string) (*apiv1.Secret, error) {
+	return clientSet.CoreV1().Secrets(namespace).Get(ctx, secretName, metav1.GetOptions{})
+}
+
+// applySecret creates the secret in the destination namespace. An existing secret is replaced when forced and kept
+// otherwise, since secrets often hold cluster specific credentials, and forcing fails for a secret not managed by
+// the migration. It returns the secret it replaced, if any, and false if the existing secret was kept.
+func applySecret(ctx context.Context, clientSet kubernetes.Interface, namespace string, secret *apiv1.Secret, force bool, options *MigrationOptions) (*apiv1.Secret, bool, error) {
+	built := buildSecret(namespace, secret)
+	options.stampOwnership(&built.ObjectMeta)
+	_, err := clientSet.CoreV1().Secrets(namespace).Create(ctx, built, metav1.CreateOptions{})
+	if !api_errors.IsAlreadyExists(err) {
+		return nil, true, destinationError(err)
+	}
+	if !force {
+		return nil, false, nil
+	}
+	existing, err := getSecret(ctx, clientSet, namespace, secret.Name)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := options.checkOwnership("Secret", secret.Name, existing.Annotations); err != nil {
+		return nil, false, err
+	}
+	built.ResourceVersion = existing.ResourceVersion
+	_, err = clientSet.CoreV1().Secrets(namespace).Update(ctx, built, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, false, destinationError(err)
+	}
+	return existing, true, nil
+}
+
+// copySecret applies a source secret to the destination namespace and records the change in the rollback journal,
+// it returns false if the destination secret was kept
+func copySecret(ctx context.Context, clientSetD kubernetes.Interface, namespaceD string, secretS *apiv1.Secret, options *MigrationOptions) (bool, error) {
+	var replaced *apiv1.Secret
+	applied := false
+	err := options.paced(ctx, "create secret "+secretS.Name, func() error {
+		var err error
+		replaced, applied, err = applySecret(ctx, clientSetD, namespaceD, secretS, options.forces(ForceSecrets), options)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case !applied:
+		fmt.Fprintln(options.out(), "Secret", color.CyanString(secretS.Name), "already exists in the destination and secrets are not forced, keep the destination secret")
+	case replaced != nil:
+		options.changes().updated("Secret", namespaceD, secretS.Name, replaced, clientSetD)
+		fmt.Fprintln(options.out(), "Replaced secret", color.CyanString(secretS.Name), "Successfully")
+	default:
+		options.changes().created("Secret", namespaceD, secretS.Name, clientSetD, nil)
+		fmt.Fprintln(options.out(), "Migrated secret", color.CyanString(secretS.Name), "Successfully")
+	}
+	return applied, nil
+}
+
+// buildSecret returns the copy of the secret to create in the given namespace
+func buildSecret(namespace string, secret *apiv1.Secret) *apiv1.Secret {
+	return &apiv1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Secret",
+			APIVersion: "v1",
+		},
+		ObjectMeta: command.SanitizeObjectMeta(secret.ObjectMeta, namespace),
+		Type:       secret.Type,
+		Data:       secret.Data,
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"gotest.tools/assert"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8s_fake "k8s.io/client-go/kubernetes/fake"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+func TestReferencedSecrets(t *testing.T) {
+	template := serving_v1_api.RevisionTemplateSpec{}
+	template.Spec.Containers = []apiv1.Container{{
+		Env: []apiv1.EnvVar{
+			{Name: "PLAIN", Value: "value"},
+			{Name: "TOKEN", ValueFrom: &apiv1.EnvVarSource{SecretKeyRef: &apiv1.SecretKeySelector{LocalObjectReference: apiv1.LocalObjectReference{Name: "token"}, Key: "token"}}},
+		},
+		EnvFrom: []apiv1.EnvFromSource{{SecretRef: &apiv1.SecretEnvSource{LocalObjectReference: apiv1.LocalObjectReference{Name: "env"}}}},
+	}}
+	template.Spec.Volumes = []apiv1.Volume{
+		{Name: "certs", VolumeSource: apiv1.VolumeSource{Secret: &apiv1.SecretVolumeSource{SecretName: "certs"}}},
+		{Name: "token", VolumeSource: apiv1.VolumeSource{Secret: &apiv1.SecretVolumeSource{SecretName: "token"}}},
+	}
+	template.Spec.ImagePullSecrets = []apiv1.LocalObjectReference{{Name: "registry"}}
+
+	assert.DeepEqual(t, referencedSecrets(template), []string{"certs", "env", "registry", "token"})
+}
+
+func TestApplySecretForce(t *testing.T) {
+	existing := &apiv1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "token", Namespace: "prod"}, Data: map[string][]byte{"token": []byte("old")}}
+	clientSet := k8s_fake.NewSimpleClientset(existing)
+	secret := &apiv1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "token", Namespace: "default"}, Data: map[string][]byte{"token": []byte("new")}}
+
+	replaced, applied, err := applySecret(context.Background(), clientSet, "prod", secret, false, nil)
+	assert.NilError(t, err)
+	assert.Assert(t, !applied)
+	assert.Assert(t, replaced == nil)
+
+	replaced, applied, err = applySecret(context.Background(), clientSet, "prod", secret, true, nil)
+	assert.NilError(t, err)
+	assert.Assert(t, applied)
+	assert.Equal(t, string(replaced.Data["token"]), "old")
+	current, err := getSecret(context.Background(), clientSet, "prod", "token")
+	assert.NilError(t, err)
+	assert.Equal(t, string(current.Data["token"]), "new")
+
+	journal := &rollbackJournal{}
+	journal.updated("Secret", "prod", "token", replaced, clientSet)
+	assert.NilError(t, journal.rollback(context.Background(), io.Discard))
+	current, err = getSecret(context.Background(), clientSet, "prod", "token")
+	assert.NilError(t, err)
+	assert.Equal(t, string(current.Data["token"]), "old")
+}
+
+func TestApplyConfigmapForce(t *testing.T) {
+	clientSet := k8s_fake.NewSimpleClientset(&apiv1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "hello-config", Namespace: "prod"}})
+	configmap := &apiv1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "hello-config"}, Data: map[string]string{"key": "value"}}
+
+	_, err := applyConfigmap(context.Background(), clientSet, "prod", configmap, false, nil)
+	assert.ErrorContains(t, err, "--force-scope configmaps")
+
+	replaced, err := applyConfigmap(context.Background(), clientSet, "prod", configmap, true, nil)
+	assert.NilError(t, err)
+	assert.Assert(t, replaced != nil)
+	current, err := getConfigmap(context.Background(), clientSet, "prod", "hello-config")
+	assert.NilError(t, err)
+	assert.Equal(t, current.Data["key"], "value")
+}
+
+func TestForceScope(t *testing.T) {
+	options := NewMigrationOptions()
+	assert.Assert(t, !options.forces(ForceServices))
+	options.Force = true
+	assert.Assert(t, options.forces(ForceSecrets))
+
+	assert.NilError(t, options.ForceScope.Set("configmaps, secrets"))
+	assert.Assert(t, !options.forces(ForceServices))
+	assert.Assert(t, options.forces(ForceConfigMaps))
+	assert.Equal(t, options.ForceScope.String(), "configmaps,secrets")
+
+	assert.ErrorContains(t, options.ForceScope.Set("routes"), "unsupported force scope")
+}
@@ -0,0 +1,254 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	apiv1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"knative.dev/kn-plugin-migration/pkg/command"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+// defaultServiceAccount is the service account of the pods which do not name one, created by every cluster
+// in every namespace
+const defaultServiceAccount = "default"
+
+// referencedServiceAccounts returns the service accounts the service and its revisions run as, besides the
+// default service account
+func referencedServiceAccounts(service serving_v1_api.Service, revisions *serving_v1_api.RevisionList) []string {
+	names := map[string]bool{service.Spec.Template.Spec.ServiceAccountName: true}
+	if revisions != nil {
+		for _, revision := range revisions.Items {
+			names[revision.Spec.ServiceAccountName] = true
+		}
+	}
+	sorted := []string{}
+	for name := range names {
+		if name != "" && name != defaultServiceAccount {
+			sorted = append(sorted, name)
+		}
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// serviceAccountHandler copies the service accounts the service and its revisions run as before the service is
+// created, with their image pull secrets and the Roles and RoleBindings granting them permissions in the namespace
+type serviceAccountHandler struct{}
+
+func (serviceAccountHandler) Discover(ctx context.Context, m *ServiceMigration) ([]runtime.Object, error) {
+	revisionsS, err := m.source.ListRevisionByService(ctx, m.SourceName)
+	if err != nil {
+		return nil, err
+	}
+	if m.Options.RegenerateRevisions {
+		revisionsS = nil
+	}
+	names := referencedServiceAccounts(*m.Service, revisionsS)
+	clientSetS := sourceClientSet(m.source)
+	if clientSetS == nil {
+		for _, name := range names {
+			m.Options.warn("The service account %s of service %s cannot be copied without the source cluster, it must exist in the destination", name, m.SourceName)
+		}
+		return nil, nil
+	}
+	objects := []runtime.Object{}
+	for _, name := range names {
+		accountS, err := clientSetS.CoreV1().ServiceAccounts(m.SourceNamespace).Get(ctx, name, metav1.GetOptions{})
+		if api_errors.IsNotFound(err) {
+			m.Options.warn("No service account %s in the source namespace %s for service %s, skip migrate service account", name, m.SourceNamespace, m.SourceName)
+			continue
+		}
+		if err != nil {
+			return nil, sourceError(err)
+		}
+		objects = append(objects, accountS)
+	}
+	return objects, nil
+}
+
+func (serviceAccountHandler) Transform(ctx context.Context, m *ServiceMigration, objects []runtime.Object) ([]runtime.Object, error) {
+	return objects, nil
+}
+
+// Apply copies the image pull secrets of the service accounts, then the service accounts with their permissions
+func (serviceAccountHandler) Apply(ctx context.Context, m *ServiceMigration, objects []runtime.Object) error {
+	clientSetS := sourceClientSet(m.source)
+	for _, object := range objects {
+		account := object.(*apiv1.ServiceAccount)
+		for _, pullSecret := range account.ImagePullSecrets {
+			secretS, err := m.source.GetSecret(ctx, pullSecret.Name)
+			if api_errors.IsNotFound(err) {
+				m.Options.warn("No image pull secret %s of service account %s in the source namespace %s, skip migrate secret", pullSecret.Name, account.Name, m.SourceNamespace)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			applied, err := copySecret(ctx, m.ClientSetD, m.DestinationNamespace, secretS, m.Options)
+			if err != nil {
+				return err
+			}
+			if applied {
+				m.AddDependency("Secret", secretS.Name)
+			}
+		}
+		copied, err := copyServiceAccount(ctx, clientSetS, m.ClientSetD, m.SourceNamespace, m.DestinationNamespace, account.Name, m.Options)
+		m.dependencies = append(m.dependencies, copied...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (serviceAccountHandler) Verify(ctx context.Context, m *ServiceMigration, objects []runtime.Object) error {
+	return nil
+}
+
+// copyServiceAccount copies a service account to the destination namespace with the RoleBindings granting it
+// permissions in its namespace and the Roles they refer to, and returns the objects it created. Objects already
+// existing in the destination are kept, since permissions are often tailored to each cluster, and the ClusterRoles
+// referred to by the RoleBindings are expected to exist in the destination.
+func copyServiceAccount(ctx context.Context, clientSetS, clientSetD kubernetes.Interface, namespaceS, namespaceD, name string, options *MigrationOptions) ([]string, error) {
+	copied := []string{}
+	accountS, err := clientSetS.CoreV1().ServiceAccounts(namespaceS).Get(ctx, name, metav1.GetOptions{})
+	if api_errors.IsNotFound(err) {
+		options.warn("No service account %s in the source namespace %s, skip migrate service account", name, namespaceS)
+		return copied, nil
+	}
+	if err != nil {
+		return copied, sourceError(err)
+	}
+	account := &apiv1.ServiceAccount{
+		ObjectMeta: command.SanitizeObjectMeta(accountS.ObjectMeta, namespaceD),
+		// The token secrets are generated again by the destination cluster
+		ImagePullSecrets:             accountS.ImagePullSecrets,
+		AutomountServiceAccountToken: accountS.AutomountServiceAccountToken,
+	}
+	options.stampOwnership(&account.ObjectMeta)
+	created, err := createIfAbsent(ctx, "serviceaccount "+name, options, func() error {
+		_, err := clientSetD.CoreV1().ServiceAccounts(namespaceD).Create(ctx, account, metav1.CreateOptions{})
+		return err
+	})
+	if err != nil {
+		return copied, err
+	}
+	if created {
+		options.changes().created("ServiceAccount", namespaceD, name, clientSetD, nil)
+		copied = append(copied, "ServiceAccount "+name)
+	}
+
+	bindings, err := clientSetS.RbacV1().RoleBindings(namespaceS).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return copied, sourceError(err)
+	}
+	for _, bindingS := range bindings.Items {
+		if !bindsServiceAccount(bindingS, namespaceS, name) {
+			continue
+		}
+		if bindingS.RoleRef.Kind == "Role" {
+			roleCopied, err := copyRole(ctx, clientSetS, clientSetD, namespaceS, namespaceD, bindingS.RoleRef.Name, options)
+			if err != nil {
+				return copied, err
+			}
+			if roleCopied {
+				copied = append(copied, "Role "+bindingS.RoleRef.Name)
+			}
+		}
+		binding := &rbacv1.RoleBinding{
+			ObjectMeta: command.SanitizeObjectMeta(bindingS.ObjectMeta, namespaceD),
+			RoleRef:    bindingS.RoleRef,
+		}
+		options.stampOwnership(&binding.ObjectMeta)
+		for _, subject := range bindingS.Subjects {
+			if subject.Kind == rbacv1.ServiceAccountKind && (subject.Namespace == namespaceS || subject.Namespace == "") {
+				subject.Namespace = namespaceD
+			}
+			binding.Subjects = append(binding.Subjects, subject)
+		}
+		created, err := createIfAbsent(ctx, "rolebinding "+binding.Name, options, func() error {
+			_, err := clientSetD.RbacV1().RoleBindings(namespaceD).Create(ctx, binding, metav1.CreateOptions{})
+			return err
+		})
+		if err != nil {
+			return copied, err
+		}
+		if created {
+			options.changes().created("RoleBinding", namespaceD, binding.Name, clientSetD, nil)
+			copied = append(copied, "RoleBinding "+binding.Name)
+		}
+	}
+	return copied, nil
+}
+
+// bindsServiceAccount returns true if a RoleBinding grants its role to the service account
+func bindsServiceAccount(binding rbacv1.RoleBinding, namespace, name string) bool {
+	for _, subject := range binding.Subjects {
+		if subject.Kind == rbacv1.ServiceAccountKind && subject.Name == name && (subject.Namespace == namespace || subject.Namespace == "") {
+			return true
+		}
+	}
+	return false
+}
+
+// copyRole copies a Role to the destination namespace unless it exists there
+func copyRole(ctx context.Context, clientSetS, clientSetD kubernetes.Interface, namespaceS, namespaceD, name string, options *MigrationOptions) (bool, error) {
+	roleS, err := clientSetS.RbacV1().Roles(namespaceS).Get(ctx, name, metav1.GetOptions{})
+	if api_errors.IsNotFound(err) {
+		options.warn("No role %s in the source namespace %s, skip migrate role", name, namespaceS)
+		return false, nil
+	}
+	if err != nil {
+		return false, sourceError(err)
+	}
+	role := &rbacv1.Role{
+		ObjectMeta: command.SanitizeObjectMeta(roleS.ObjectMeta, namespaceD),
+		Rules:      roleS.Rules,
+	}
+	options.stampOwnership(&role.ObjectMeta)
+	created, err := createIfAbsent(ctx, "role "+name, options, func() error {
+		_, err := clientSetD.RbacV1().Roles(namespaceD).Create(ctx, role, metav1.CreateOptions{})
+		return err
+	})
+	if created {
+		options.changes().created("Role", namespaceD, name, clientSetD, nil)
+	}
+	return created, err
+}
+
+// createIfAbsent creates an object in the destination and returns false if it already exists there
+func createIfAbsent(ctx context.Context, what string, options *MigrationOptions, create func() error) (bool, error) {
+	err := options.paced(ctx, "create "+what, func() error {
+		return destinationError(create())
+	})
+	if api_errors.IsAlreadyExists(err) {
+		fmt.Fprintln(options.out(), what, "already exists in the destination, skip migrate", what)
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	fmt.Fprintln(options.out(), "Migrated", what, "Successfully")
+	return true, nil
+}
@@ -0,0 +1,84 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"testing"
+
+	"gotest.tools/assert"
+	apiv1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8s_fake "k8s.io/client-go/kubernetes/fake"
+	"knative.dev/kn-plugin-migration/pkg/command"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+	serving_fake "knative.dev/serving/pkg/client/clientset/versioned/fake"
+)
+
+func TestServiceAccountHandler(t *testing.T) {
+	service := &serving_v1_api.Service{ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "default"}}
+	service.Spec.Template.Spec.ServiceAccountName = "hello-sa"
+	revision := &serving_v1_api.Revision{ObjectMeta: metav1.ObjectMeta{Name: "hello-00001", Namespace: "default", Labels: map[string]string{"serving.knative.dev/service": "hello"}}}
+	revision.Spec.ServiceAccountName = "old-sa"
+	clientSetS := k8s_fake.NewSimpleClientset(
+		&apiv1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "hello-sa", Namespace: "default"}, ImagePullSecrets: []apiv1.LocalObjectReference{{Name: "registry"}, {Name: "missing"}}},
+		&apiv1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "registry", Namespace: "default"}, Type: apiv1.SecretTypeDockerConfigJson, Data: map[string][]byte{apiv1.DockerConfigJsonKey: []byte(`{"auths":{}}`)}},
+		&rbacv1.Role{ObjectMeta: metav1.ObjectMeta{Name: "reader", Namespace: "default"}, Rules: []rbacv1.PolicyRule{{Verbs: []string{"get"}, Resources: []string{"configmaps"}, APIGroups: []string{""}}}},
+		&rbacv1.RoleBinding{ObjectMeta: metav1.ObjectMeta{Name: "hello-reader", Namespace: "default"}, RoleRef: rbacv1.RoleRef{Kind: "Role", Name: "reader"}, Subjects: []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: "hello-sa"}}},
+	)
+	servingClientS := serving_fake.NewSimpleClientset(service, revision)
+	clientSetD := k8s_fake.NewSimpleClientset()
+	m := &ServiceMigration{
+		SourceName:           "hello",
+		Service:              service,
+		SourceNamespace:      "default",
+		DestinationNamespace: "prod",
+		ClientSetD:           clientSetD,
+		Options:              NewMigrationOptions(),
+		source:               newLiveSource(clientSetS, command.NewMigrationClient(servingClientS.ServingV1(), "default"), "default"),
+	}
+
+	objects, err := serviceAccountHandler{}.Discover(context.Background(), m)
+	assert.NilError(t, err)
+	// The service account of the old revision does not exist in the source anymore
+	assert.Equal(t, len(objects), 1)
+	assert.DeepEqual(t, m.Options.warnings(), []string{"No service account old-sa in the source namespace default for service hello, skip migrate service account"})
+
+	assert.NilError(t, serviceAccountHandler{}.Apply(context.Background(), m, objects))
+	assert.DeepEqual(t, m.dependencies, []string{"Secret registry", "ServiceAccount hello-sa", "Role reader", "RoleBinding hello-reader"})
+	account, err := clientSetD.CoreV1().ServiceAccounts("prod").Get(context.Background(), "hello-sa", metav1.GetOptions{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(account.ImagePullSecrets), 2)
+	binding, err := clientSetD.RbacV1().RoleBindings("prod").Get(context.Background(), "hello-reader", metav1.GetOptions{})
+	assert.NilError(t, err)
+	assert.Equal(t, binding.Subjects[0].Namespace, "prod")
+
+	// The objects already existing in the destination are kept
+	m.dependencies = nil
+	assert.NilError(t, serviceAccountHandler{}.Apply(context.Background(), m, objects))
+	assert.Equal(t, len(m.dependencies), 0)
+}
+
+func TestReferencedServiceAccounts(t *testing.T) {
+	service := serving_v1_api.Service{}
+	assert.Equal(t, len(referencedServiceAccounts(service, nil)), 0)
+	service.Spec.Template.Spec.ServiceAccountName = "b"
+	revisions := &serving_v1_api.RevisionList{Items: []serving_v1_api.Revision{{}, {}, {}}}
+	revisions.Items[0].Spec.ServiceAccountName = "a"
+	revisions.Items[1].Spec.ServiceAccountName = defaultServiceAccount
+	revisions.Items[2].Spec.ServiceAccountName = "b"
+	assert.DeepEqual(t, referencedServiceAccounts(service, revisions), []string{"a", "b"})
+}
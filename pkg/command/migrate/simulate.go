@@ -0,0 +1,166 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+	"knative.dev/kn-plugin-migration/pkg/command"
+	"knative.dev/kn-plugin-migration/pkg/command/migrate/internal/simulated"
+)
+
+type simulateCmdFlags struct {
+	From                 string
+	DestinationFrom      string
+	DecryptWith          string
+	DestinationNamespace string
+	Output               string
+	Services             []string
+	Selector             string
+	Exclude              []string
+	ExcludeSelector      string
+	Options              *MigrationOptions
+}
+
+var simulateFlags simulateCmdFlags
+
+// NewSimulateCommand represents the 'migrate simulate' command
+func NewSimulateCommand() *cobra.Command {
+	simulateFlags.Options = NewMigrationOptions()
+	simulateCmd := &cobra.Command{
+		Use:   "simulate",
+		Short: "Simulate the migration of an exported bundle without any cluster access",
+		Long: `Simulate the migration of an exported bundle without any cluster access.
+
+The services of a bundle written by 'kn migrate export' are migrated with the same
+logic as a live migration into an in-memory destination cluster, optionally seeded
+with the resources of another bundle, and the result of every service is reported.`,
+		Example: `
+  # Simulate the migration of the bundle into an empty prod namespace
+  kn migrate simulate --from ./bundle/ --destination-namespace prod
+  # Simulate the migration into a destination already containing the services of another bundle
+  kn migrate simulate --from ./bundle/ --destination-from ./prod-bundle/ --destination-namespace prod --force -o yaml`,
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := command.NewCommandContext(cmd)
+			defer cancel()
+
+			if cmd.Flags().Changed("force-scope") || simulateFlags.Options.ForceRecreate {
+				simulateFlags.Options.Force = true
+			}
+
+			if simulateFlags.From == "" {
+				return fmt.Errorf("cannot get the bundle directory, please use --from to set")
+			}
+			err := validateOutputFormat(simulateFlags.Output)
+			if err != nil {
+				return err
+			}
+			filter, err := newServiceFilter(simulateFlags.Services, simulateFlags.Selector)
+			if err != nil {
+				return err
+			}
+			err = filter.exclude(simulateFlags.Exclude, simulateFlags.ExcludeSelector)
+			if err != nil {
+				return err
+			}
+
+			key, err := parseBundleKey(simulateFlags.DecryptWith)
+			if err != nil {
+				return err
+			}
+			source, err := readBundle(simulateFlags.From, key)
+			if err != nil {
+				return err
+			}
+			namespaceD := simulateFlags.DestinationNamespace
+			if namespaceD == "" {
+				namespaceD = source.Namespace()
+			}
+			seed := &bundleSource{}
+			if simulateFlags.DestinationFrom != "" {
+				seed, err = readBundle(simulateFlags.DestinationFrom, key)
+				if err != nil {
+					return err
+				}
+			}
+
+			// With a structured report the progress messages go to stderr so that stdout only holds the report
+			out := cmd.OutOrStdout()
+			simulateFlags.Options.Out = out
+			if simulateFlags.Output != "" {
+				simulateFlags.Options.Out = cmd.ErrOrStderr()
+				command.ConfigureColors(cmd, os.Stderr)
+			}
+			clientSetD, migrationClientD := newSimulatedDestination(namespaceD, seed)
+
+			report := newMigrationReport()
+			fmt.Fprintln(simulateFlags.Options.Out, color.GreenString("[Simulation of the migration of the bundle %s]", simulateFlags.From))
+			_, migrateErr := migrateNamespace(ctx, source, clientSetD, migrationClientD, namespaceD, filter, simulateFlags.Options, report.namespace(source.Namespace(), namespaceD))
+			report.finish(migrateErr)
+
+			err = printOutcome(out, cmd.ErrOrStderr(), report, simulateFlags.Output)
+			if err != nil {
+				return err
+			}
+			if migrateErr != nil {
+				return fmt.Errorf("the simulated migration failed: %w", migrateErr)
+			}
+			if !report.Succeeded {
+				return fmt.Errorf("%d service(s) failed the simulated migration", report.failures())
+			}
+			return nil
+		},
+	}
+
+	simulateCmd.Flags().StringVar(&simulateFlags.From, "from", "", "The bundle directory written by 'kn migrate export' to simulate the migration of")
+	simulateCmd.Flags().StringVar(&simulateFlags.DestinationFrom, "destination-from", "", "A bundle directory whose resources are loaded in the simulated destination namespace before the migration")
+	simulateCmd.Flags().StringVar(&simulateFlags.DecryptWith, "decrypt-with", "", "The key of the bundles exported with --encrypt-with, either age:<identity file> or passphrase to use the passphrase of the "+BundlePassphraseEnv+" environment variable")
+	simulateCmd.Flags().StringVar(&simulateFlags.DestinationNamespace, "destination-namespace", "", "The simulated destination namespace (default is the namespace the bundle was exported from)")
+	simulateCmd.Flags().BoolVar(&simulateFlags.Options.Force, "force", false, "Simulate a forceful migration, replacing existing services if any.")
+	simulateCmd.Flags().Var(&simulateFlags.Options.OnConflict, "on-conflict", "What to do with the services which already exist in the destination, one of: skip, overwrite, merge, fail (default is overwrite with --force, else fail)")
+	simulateCmd.Flags().BoolVar(&simulateFlags.Options.ForceRecreate, "force-recreate", false, "Simulate deleting the existing services and creating them again instead of applying the migrated services over them, implies --force")
+	simulateCmd.Flags().BoolVar(&simulateFlags.Options.Adopt, "adopt", false, "Simulate overwriting the destination objects which were not written by a migration, which are refused otherwise")
+	simulateCmd.Flags().Var(&simulateFlags.Options.ForceScope, "force-scope", "The kinds of objects replaced when they exist in the destination, comma separated, one of: services, configmaps, secrets, domainmappings, eventing, implies --force (default is all kinds with --force)")
+	simulateCmd.Flags().StringSliceVar(&simulateFlags.Services, "service", nil, "The names or glob patterns of the services to migrate, comma separated or repeated (default is all services of the bundle)")
+	simulateCmd.Flags().StringVarP(&simulateFlags.Selector, "selector", "l", "", "The label selector of the services to migrate, e.g. team=payments")
+	simulateCmd.Flags().StringSliceVar(&simulateFlags.Exclude, "exclude", nil, "The names or glob patterns of the services not to migrate, with their configmap and secrets, comma separated or repeated")
+	simulateCmd.Flags().StringVar(&simulateFlags.ExcludeSelector, "exclude-selector", "", "The label selector of the services not to migrate, e.g. lifecycle=decommissioned")
+	simulateCmd.Flags().IntVar(&simulateFlags.Options.MaxObjectSize, "max-object-size", simulateFlags.Options.MaxObjectSize, "The maximum size in bytes of a serialized object accepted by the destination cluster")
+	simulateCmd.Flags().Var(&simulateFlags.Options.Revisions, "revisions", "The source revisions of every service recreated in the destination, one of: all, traffic (the revisions its traffic routes to), latest, or a number of most recent revisions, the latest revision is always migrated (default is all)")
+	simulateCmd.Flags().BoolVar(&simulateFlags.Options.SkipBrokenRevisions, "skip-broken-revisions", false, "Leave out the source revisions which are not Ready, e.g. because their image no longer exists, unless the service routes to them")
+	simulateCmd.Flags().Var(&simulateFlags.Options.RevisionCollision, "revision-collision", "What to do with a revision whose name is taken in the destination by a different revision, one of: fail, remap")
+	simulateCmd.Flags().IntVar(&simulateFlags.Options.Concurrency, "concurrency", simulateFlags.Options.Concurrency, "The number of services migrated in parallel, the revisions of a service are always migrated in order")
+	simulateCmd.Flags().BoolVar(&simulateFlags.Options.BestEffort, "best-effort", false, "Continue with the remaining services when a service fails to migrate")
+	simulateCmd.Flags().StringVarP(&simulateFlags.Output, "output", "o", "", "Output format of the simulation report, one of: json, yaml (default is human readable)")
+	return simulateCmd
+}
+
+// newSimulatedDestination returns in-memory clients of a destination cluster containing the resources of the seed bundle
+// in the namespace
+func newSimulatedDestination(namespace string, seed *bundleSource) (kubernetes.Interface, command.MigrationClient) {
+	content := simulated.Seed{Namespace: seed.namespace != "", Services: seed.services}
+	for _, configmap := range seed.configmaps {
+		content.ConfigMaps = append(content.ConfigMaps, configmap)
+	}
+	for _, revisions := range seed.revisions {
+		content.Revisions = append(content.Revisions, revisions...)
+	}
+	return simulated.NewDestination(namespace, content)
+}
@@ -0,0 +1,208 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"gotest.tools/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	api_serving "knative.dev/serving/pkg/apis/serving"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+func simulatedBundle(namespace string, services ...string) *bundleSource {
+	bundle := &bundleSource{namespace: namespace, revisions: map[string][]serving_v1_api.Revision{}}
+	for _, name := range services {
+		service := serving_v1_api.Service{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+		service.Status.LatestCreatedRevisionName = name + "-00002"
+		bundle.services = append(bundle.services, service)
+		for _, revision := range []string{name + "-00001", name + "-00002"} {
+			bundle.revisions[name] = append(bundle.revisions[name], serving_v1_api.Revision{ObjectMeta: metav1.ObjectMeta{
+				Name:      revision,
+				Namespace: namespace,
+				Labels:    map[string]string{api_serving.ServiceLabelKey: name, "serving.knative.dev/configurationGeneration": "1"},
+				OwnerReferences: []metav1.OwnerReference{{
+					APIVersion: serving_v1_api.SchemeGroupVersion.String(),
+					Kind:       "Configuration",
+					Name:       name,
+				}},
+			}})
+		}
+	}
+	return bundle
+}
+
+func TestSimulateMigration(t *testing.T) {
+	source := simulatedBundle("default", "hello", "bye")
+	clientSetD, migrationClientD := newSimulatedDestination("prod", simulatedBundle("prod", "bye"))
+	filter, err := newServiceFilter(nil, "")
+	assert.NilError(t, err)
+	options := NewMigrationOptions()
+	options.BestEffort = true
+	var progress bytes.Buffer
+	options.Out = &progress
+
+	report := newMigrationReport()
+	migrated, err := migrateNamespace(context.Background(), source, clientSetD, migrationClientD, "prod", filter, options, report.namespace("default", "prod"))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, migrated, []string{"hello"})
+	assert.Equal(t, report.failures(), 1)
+	// The progress messages and warnings go to the writer of the options
+	assert.Assert(t, strings.Contains(progress.String(), "[After migration in destination cluster]"))
+	assert.Assert(t, strings.Contains(progress.String(), "Services in destination prod namespace:"))
+
+	revisions, err := migrationClientD.ListRevisionByService(context.Background(), "hello")
+	assert.NilError(t, err)
+	assert.Equal(t, len(revisions.Items), 2)
+}
+
+func TestSimulateConcurrentMigration(t *testing.T) {
+	names := []string{}
+	for i := 0; i < 10; i++ {
+		names = append(names, fmt.Sprintf("service-%d", i))
+	}
+	source := simulatedBundle("default", names...)
+	clientSetD, migrationClientD := newSimulatedDestination("default", &bundleSource{})
+	filter, err := newServiceFilter(nil, "")
+	assert.NilError(t, err)
+	options := NewMigrationOptions()
+	options.Concurrency = 4
+
+	report := newMigrationReport()
+	migrated, err := migrateNamespace(context.Background(), source, clientSetD, migrationClientD, "default", filter, options, report.namespace("default", "default"))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, migrated, names)
+	for i, service := range report.Namespaces[0].Services {
+		assert.Equal(t, service.Name, names[i])
+		assert.DeepEqual(t, service.Revisions, []string{names[i] + "-00001", names[i] + "-00002"})
+	}
+
+	options.Concurrency = 0
+	_, err = migrateNamespace(context.Background(), source, clientSetD, migrationClientD, "default", filter, options, report.namespace("default", "default"))
+	assert.ErrorContains(t, err, "concurrency must be at least 1")
+}
+
+func TestSimulateTemplateRevisionNames(t *testing.T) {
+	source := simulatedBundle("default", "hello")
+	service := &source.services[0]
+	service.Spec.Template.Name = "hello-green"
+	service.Spec.Traffic = []serving_v1_api.TrafficTarget{{RevisionName: "hello-00002"}, {RevisionName: "hello-green"}}
+	green := *source.revisions["hello"][1].DeepCopy()
+	green.Name = "hello-green"
+	source.revisions["hello"] = append(source.revisions["hello"], green)
+
+	clientSetD, migrationClientD := newSimulatedDestination("default", &bundleSource{})
+	filter, err := newServiceFilter(nil, "")
+	assert.NilError(t, err)
+	report := newMigrationReport()
+	_, err = migrateNamespace(context.Background(), source, clientSetD, migrationClientD, "default", filter, NewMigrationOptions(), report.namespace("default", "default"))
+	assert.NilError(t, err)
+
+	serviceD, err := migrationClientD.GetService(context.Background(), "hello")
+	assert.NilError(t, err)
+	assert.Equal(t, serviceD.Spec.Template.Name, "hello-green")
+	revisions, err := migrationClientD.ListRevisionByService(context.Background(), "hello")
+	assert.NilError(t, err)
+	assert.Equal(t, len(revisions.Items), 3)
+
+	service.Spec.Traffic = append(service.Spec.Traffic, serving_v1_api.TrafficTarget{RevisionName: "hello-gone"})
+	clientSetD, migrationClientD = newSimulatedDestination("default", &bundleSource{})
+	_, err = migrateNamespace(context.Background(), source, clientSetD, migrationClientD, "default", filter, NewMigrationOptions(), report.namespace("default", "default"))
+	assert.ErrorContains(t, err, "hello-gone")
+}
+
+func TestSimulateTrafficSplit(t *testing.T) {
+	source := simulatedBundle("default", "hello")
+	latest := true
+	percent := func(p int64) *int64 { return &p }
+	traffic := []serving_v1_api.TrafficTarget{
+		{RevisionName: "hello-00001", Percent: percent(90), Tag: "stable"},
+		{LatestRevision: &latest, Percent: percent(10), Tag: "canary"},
+	}
+	source.services[0].Spec.Traffic = traffic
+
+	clientSetD, migrationClientD := newSimulatedDestination("default", &bundleSource{})
+	filter, err := newServiceFilter(nil, "")
+	assert.NilError(t, err)
+	report := newMigrationReport()
+	_, err = migrateNamespace(context.Background(), source, clientSetD, migrationClientD, "default", filter, NewMigrationOptions(), report.namespace("default", "default"))
+	assert.NilError(t, err)
+
+	serviceD, err := migrationClientD.GetService(context.Background(), "hello")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, serviceD.Spec.Traffic, traffic)
+	assert.Equal(t, describeTraffic(traffic), "hello-00001=90%(stable),latest=10%(canary)")
+}
+
+func TestSimulateRevisionHistory(t *testing.T) {
+	source := simulatedBundle("default", "hello")
+	created := time.Date(2021, time.March, 4, 10, 30, 0, 0, time.UTC)
+	for i := range source.revisions["hello"] {
+		source.revisions["hello"][i].CreationTimestamp = metav1.NewTime(created.Add(time.Duration(i) * time.Hour))
+		source.revisions["hello"][i].Labels["serving.knative.dev/configurationGeneration"] = fmt.Sprint(i + 1)
+	}
+
+	clientSetD, migrationClientD := newSimulatedDestination("default", &bundleSource{})
+	filter, err := newServiceFilter(nil, "")
+	assert.NilError(t, err)
+	options := NewMigrationOptions()
+	options.PreserveRevisionHistory = true
+	report := newMigrationReport()
+	_, err = migrateNamespace(context.Background(), source, clientSetD, migrationClientD, "default", filter, options, report.namespace("default", "default"))
+	assert.NilError(t, err)
+
+	for i, name := range []string{"hello-00001", "hello-00002"} {
+		revision, err := migrationClientD.GetRevision(context.Background(), name)
+		assert.NilError(t, err)
+		assert.Equal(t, revision.Annotations[sourceCreationTimestampAnnotation], created.Add(time.Duration(i)*time.Hour).Format(time.RFC3339))
+		assert.Equal(t, revision.Annotations[sourceGenerationAnnotation], fmt.Sprint(i+1))
+	}
+}
+
+func TestSimulateGroupBy(t *testing.T) {
+	source := simulatedBundle("default", "hello", "bye", "blog")
+	for i, app := range []string{"shop", "shop", "blog"} {
+		source.services[i].Labels = map[string]string{"app.kubernetes.io/part-of": app}
+	}
+	// bye already exists in the destination, so the whole shop application is rolled back
+	clientSetD, migrationClientD := newSimulatedDestination("prod", simulatedBundle("prod", "bye"))
+	filter, err := newServiceFilter(nil, "")
+	assert.NilError(t, err)
+	options := NewMigrationOptions()
+	options.BestEffort = true
+	options.GroupBy = "app.kubernetes.io/part-of"
+
+	report := newMigrationReport()
+	migrated, err := migrateNamespace(context.Background(), source, clientSetD, migrationClientD, "prod", filter, options, report.namespace("default", "prod"))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, migrated, []string{"blog"})
+	assert.Equal(t, report.failures(), 2)
+	exists, err := migrationClientD.ServiceExists(context.Background(), "hello")
+	assert.NilError(t, err)
+	assert.Assert(t, !exists)
+	exists, err = migrationClientD.ServiceExists(context.Background(), "bye")
+	assert.NilError(t, err)
+	assert.Assert(t, exists)
+
+	groups := groupServices(&serving_v1_api.ServiceList{Items: append(source.services, serving_v1_api.Service{})}, options.GroupBy)
+	assert.DeepEqual(t, groups, []serviceGroup{{Name: "blog", services: []int{2}}, {Name: "shop", services: []int{0, 1}}, {Name: "", services: []int{3}}}, cmp.AllowUnexported(serviceGroup{}))
+}
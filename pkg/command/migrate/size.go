@@ -0,0 +1,105 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"knative.dev/kn-plugin-migration/pkg/command"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+// sizeCheckConfigurationUID stands for the uid of the destination configuration owning the revisions, which only
+// exists once the service is created, with the length of a generated uid
+const sizeCheckConfigurationUID = types.UID("00000000-0000-0000-0000-000000000000")
+
+// defaultMaxObjectSize is the size limit of a single object, etcd rejects larger requests
+// and ConfigMap and Secret data is limited to the same size by the API server
+const defaultMaxObjectSize = 1024 * 1024
+
+// sizeViolation is an object which would be rejected by the destination because of its size
+type sizeViolation struct {
+	Kind string
+	Name string
+	Size int
+}
+
+// checkObjectSizes serializes every object as it would be created in the destination and returns
+// the ones larger than maxSize, before compression or any encoding done by the API server
+func checkObjectSizes(ctx context.Context, source migrationSource, migrationClientD command.MigrationClient, namespaceD string, services *serving_v1_api.ServiceList, maxSize int) ([]sizeViolation, error) {
+	violations := []sizeViolation{}
+	check := func(kind, name string, object interface{}) error {
+		data, err := json.Marshal(object)
+		if err != nil {
+			return err
+		}
+		if len(data) > maxSize {
+			violations = append(violations, sizeViolation{Kind: kind, Name: name, Size: len(data)})
+		}
+		return nil
+	}
+
+	for i := 0; i < len(services.Items); i++ {
+		serviceS := services.Items[i]
+
+		configmapS, err := source.GetConfigmap(ctx, generateConfigmapName(serviceS.Name))
+		if err != nil && !api_errors.IsNotFound(err) {
+			return nil, err
+		}
+		if configmapS != nil {
+			if err := check("ConfigMap", configmapS.Name, buildConfigmap(namespaceD, configmapS)); err != nil {
+				return nil, err
+			}
+		}
+
+		for _, name := range referencedSecrets(serviceS.Spec.Template) {
+			secretS, err := source.GetSecret(ctx, name)
+			if api_errors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if err := check("Secret", name, buildSecret(namespaceD, secretS)); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := check("Service", serviceS.Name, migrationClientD.ConstructService(*serviceS.DeepCopy())); err != nil {
+			return nil, err
+		}
+
+		revisionsS, err := source.ListRevisionByService(ctx, serviceS.Name)
+		if err != nil {
+			return nil, err
+		}
+		for j := 0; j < len(revisionsS.Items); j++ {
+			revisionS := revisionsS.Items[j]
+			revision := migrationClientD.BuildRevision(revisionS, sizeCheckConfigurationUID)
+			if err := check("Revision", revisionS.Name, revision); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return violations, nil
+}
+
+func (v sizeViolation) String() string {
+	return fmt.Sprintf("%s %s is %d bytes", v.Kind, v.Name, v.Size)
+}
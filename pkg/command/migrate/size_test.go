@@ -0,0 +1,59 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/kn-plugin-migration/pkg/command"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+	serving_fake "knative.dev/serving/pkg/client/clientset/versioned/fake"
+)
+
+func TestCheckObjectSizes(t *testing.T) {
+	source := simulatedBundle("default", "bye", "hello")
+	migrationClientD := command.NewMigrationClient(serving_fake.NewSimpleClientset().ServingV1(), "prod")
+	services := &serving_v1_api.ServiceList{Items: source.services}
+
+	violations, err := checkObjectSizes(context.Background(), source, migrationClientD, "prod", services, defaultMaxObjectSize)
+	assert.NilError(t, err)
+	assert.Equal(t, len(violations), 0)
+
+	large := strings.Repeat("x", 8192)
+	// The annotations stripped from the migrated revisions do not count
+	source.revisions["hello"][0].Annotations = map[string]string{"kubectl.kubernetes.io/last-applied-configuration": large}
+	source.revisions["hello"][1].ManagedFields = []metav1.ManagedFieldsEntry{{Manager: large}}
+	violations, err = checkObjectSizes(context.Background(), source, migrationClientD, "prod", services, 4096)
+	assert.NilError(t, err)
+	assert.Equal(t, len(violations), 0)
+
+	source.revisions["bye"][1].Annotations = map[string]string{"example.com/notes": large}
+	source.configmaps = map[string]*apiv1.ConfigMap{
+		"hello-config": {ObjectMeta: metav1.ObjectMeta{Name: "hello-config", Namespace: "default"}, Data: map[string]string{"data": large}},
+	}
+	violations, err = checkObjectSizes(context.Background(), source, migrationClientD, "prod", services, 4096)
+	assert.NilError(t, err)
+	assert.Equal(t, len(violations), 2)
+	assert.Equal(t, violations[0].Kind+"/"+violations[0].Name, "Revision/bye-00002")
+	assert.Equal(t, violations[1].Kind+"/"+violations[1].Name, "ConfigMap/hello-config")
+	assert.Assert(t, violations[0].Size > 8192)
+	assert.Equal(t, violations[0].String(), "Revision bye-00002 is "+strconv.Itoa(violations[0].Size)+" bytes")
+}
@@ -0,0 +1,201 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// snapshotSignatureSuffix is appended to the path of a snapshot manifest to name its signature
+const snapshotSignatureSuffix = ".sig"
+
+// snapshotObject is the hash of the configuration of an object read from the source
+type snapshotObject struct {
+	Kind            string `json:"kind"`
+	Namespace       string `json:"namespace"`
+	Name            string `json:"name"`
+	UID             string `json:"uid,omitempty"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+	// SHA256 is the hash of the JSON of the labels, the annotations and the spec, or data, of the object
+	SHA256 string `json:"sha256"`
+}
+
+// snapshotManifest lists every object read from the source cluster during a migration, so that auditors can prove
+// which configuration was transferred after the source cluster is decommissioned
+type snapshotManifest struct {
+	Cluster string           `json:"cluster"`
+	TakenAt metav1.Time      `json:"takenAt"`
+	Objects []snapshotObject `json:"objects"`
+}
+
+// snapshot records the objects read from the source, services may be migrated in parallel
+type snapshot struct {
+	mu      sync.Mutex
+	objects map[string]snapshotObject
+}
+
+func newSnapshot() *snapshot {
+	return &snapshot{objects: map[string]snapshotObject{}}
+}
+
+// record hashes the configuration of an object read from the source, an object read twice is recorded once
+func (s *snapshot) record(kind string, object metav1.Object, configuration interface{}) {
+	data, err := json.Marshal(configuration)
+	if err != nil {
+		return
+	}
+	sum := sha256.Sum256(data)
+	entry := snapshotObject{
+		Kind:            kind,
+		Namespace:       object.GetNamespace(),
+		Name:            object.GetName(),
+		UID:             string(object.GetUID()),
+		ResourceVersion: object.GetResourceVersion(),
+		SHA256:          hex.EncodeToString(sum[:]),
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[kind+"/"+entry.Namespace+"/"+entry.Name] = entry
+}
+
+// manifest returns the recorded objects sorted by kind, namespace and name
+func (s *snapshot) manifest(cluster string, takenAt time.Time) snapshotManifest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.objects))
+	for key := range s.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	manifest := snapshotManifest{Cluster: cluster, TakenAt: metav1.NewTime(takenAt), Objects: []snapshotObject{}}
+	for _, key := range keys {
+		manifest.Objects = append(manifest.Objects, s.objects[key])
+	}
+	return manifest
+}
+
+// write writes the manifest of the snapshot as YAML to path, and its ed25519 signature to path.sig
+func (s *snapshot) write(path, cluster string, takenAt time.Time, key ed25519.PrivateKey) error {
+	data, err := yaml.Marshal(s.manifest(cluster, takenAt))
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path+snapshotSignatureSuffix, ed25519.Sign(key, data), 0644)
+}
+
+// readSigningKey reads an ed25519 private key in a PKCS #8 PEM file, e.g. generated by 'openssl genpkey -algorithm ed25519'
+func readSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("cannot read the signing key %s: no PEM block", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the signing key %s: %w", path, err)
+	}
+	signingKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("cannot read the signing key %s: not an ed25519 key", path)
+	}
+	return signingKey, nil
+}
+
+// snapshotSource records the objects read from a source in a snapshot
+type snapshotSource struct {
+	migrationSource
+	snapshot *snapshot
+}
+
+func (s snapshotSource) ListServices(ctx context.Context, filter *serviceFilter) (*serving_v1_api.ServiceList, error) {
+	services, err := s.migrationSource.ListServices(ctx, filter)
+	if err == nil {
+		for i := range services.Items {
+			service := &services.Items[i]
+			s.snapshot.record("Service", service, objectConfiguration(service.ObjectMeta, service.Spec))
+		}
+	}
+	return services, err
+}
+
+func (s snapshotSource) EachService(ctx context.Context, filter *serviceFilter, fn func(*serving_v1_api.Service) error) error {
+	return s.migrationSource.EachService(ctx, filter, func(service *serving_v1_api.Service) error {
+		s.snapshot.record("Service", service, objectConfiguration(service.ObjectMeta, service.Spec))
+		return fn(service)
+	})
+}
+
+func (s snapshotSource) GetConfigmap(ctx context.Context, name string) (*apiv1.ConfigMap, error) {
+	configmap, err := s.migrationSource.GetConfigmap(ctx, name)
+	if err == nil {
+		s.snapshot.record("ConfigMap", configmap, objectConfiguration(configmap.ObjectMeta, map[string]interface{}{"data": configmap.Data, "binaryData": configmap.BinaryData}))
+	}
+	return configmap, err
+}
+
+func (s snapshotSource) GetSecret(ctx context.Context, name string) (*apiv1.Secret, error) {
+	secret, err := s.migrationSource.GetSecret(ctx, name)
+	if err == nil {
+		s.snapshot.record("Secret", secret, objectConfiguration(secret.ObjectMeta, map[string]interface{}{"type": secret.Type, "data": secret.Data}))
+	}
+	return secret, err
+}
+
+func (s snapshotSource) GetPersistentVolumeClaim(ctx context.Context, name string) (*apiv1.PersistentVolumeClaim, error) {
+	claim, err := s.migrationSource.GetPersistentVolumeClaim(ctx, name)
+	if err == nil {
+		s.snapshot.record("PersistentVolumeClaim", claim, objectConfiguration(claim.ObjectMeta, claim.Spec))
+	}
+	return claim, err
+}
+
+func (s snapshotSource) ListRevisionByService(ctx context.Context, name string) (*serving_v1_api.RevisionList, error) {
+	revisions, err := s.migrationSource.ListRevisionByService(ctx, name)
+	if err == nil {
+		for i := range revisions.Items {
+			revision := &revisions.Items[i]
+			s.snapshot.record("Revision", revision, objectConfiguration(revision.ObjectMeta, revision.Spec))
+		}
+	}
+	return revisions, err
+}
+
+// objectConfiguration is the part of an object hashed in a snapshot, the status and the metadata set by the
+// cluster are left out
+func objectConfiguration(meta metav1.ObjectMeta, spec interface{}) interface{} {
+	return map[string]interface{}{"labels": meta.Labels, "annotations": meta.Annotations, "spec": spec}
+}
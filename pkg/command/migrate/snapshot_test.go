@@ -0,0 +1,95 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func TestSnapshotSource(t *testing.T) {
+	dir := t.TempDir()
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NilError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	assert.NilError(t, err)
+	keyPath := filepath.Join(dir, "signing.pem")
+	assert.NilError(t, ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600))
+	key, err := readSigningKey(keyPath)
+	assert.NilError(t, err)
+
+	hello := serving_v1_api.Service{ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "default", UID: "1234"}}
+	world := serving_v1_api.Service{ObjectMeta: metav1.ObjectMeta{Name: "world", Namespace: "default"}}
+	world.Spec.Template.Spec.Containers = []apiv1.Container{{Image: "gcr.io/knative-samples/helloworld-go"}}
+	bundle := &bundleSource{
+		namespace:  "default",
+		services:   []serving_v1_api.Service{world, hello},
+		configmaps: map[string]*apiv1.ConfigMap{"hello-config": {ObjectMeta: metav1.ObjectMeta{Name: "hello-config", Namespace: "default"}, Data: map[string]string{"key": "value"}}},
+		revisions:  map[string][]serving_v1_api.Revision{"hello": {{ObjectMeta: metav1.ObjectMeta{Name: "hello-00001", Namespace: "default"}}}},
+	}
+	sourceSnapshot := newSnapshot()
+	source := snapshotSource{migrationSource: bundle, snapshot: sourceSnapshot}
+	allServices, _ := newServiceFilter(nil, "")
+	_, err = source.ListServices(context.Background(), allServices)
+	assert.NilError(t, err)
+	_, err = source.GetConfigmap(context.Background(), "hello-config")
+	assert.NilError(t, err)
+	_, err = source.ListRevisionByService(context.Background(), "hello")
+	assert.NilError(t, err)
+	// Objects not found are not recorded
+	_, err = source.GetSecret(context.Background(), "missing")
+	assert.Assert(t, err != nil)
+
+	path := filepath.Join(dir, "snapshot.yaml")
+	assert.NilError(t, sourceSnapshot.write(path, "source", time.Now(), key))
+	data, err := ioutil.ReadFile(path)
+	assert.NilError(t, err)
+	signature, err := ioutil.ReadFile(path + snapshotSignatureSuffix)
+	assert.NilError(t, err)
+	assert.Assert(t, ed25519.Verify(publicKey, data, signature))
+
+	manifest := snapshotManifest{}
+	assert.NilError(t, yaml.Unmarshal(data, &manifest))
+	assert.Equal(t, manifest.Cluster, "source")
+	assert.Equal(t, len(manifest.Objects), 4)
+	assert.Equal(t, manifest.Objects[0].Kind+" "+manifest.Objects[0].Name, "ConfigMap hello-config")
+	assert.Equal(t, manifest.Objects[1].Kind+" "+manifest.Objects[1].Name, "Revision hello-00001")
+	assert.Equal(t, manifest.Objects[2].Name, "hello")
+	assert.Equal(t, manifest.Objects[2].UID, "1234")
+	assert.Equal(t, manifest.Objects[3].Name, "world")
+	// Different specs have different hashes
+	assert.Assert(t, manifest.Objects[2].SHA256 != manifest.Objects[3].SHA256)
+	assert.Equal(t, len(manifest.Objects[2].SHA256), 64)
+}
+
+func TestReadSigningKeyNotPEM(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key.pem")
+	assert.NilError(t, ioutil.WriteFile(path, []byte("not a key"), 0600))
+	_, err := readSigningKey(path)
+	assert.ErrorContains(t, err, "no PEM block")
+}
@@ -0,0 +1,171 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+
+	apiv1 "k8s.io/api/core/v1"
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"knative.dev/kn-plugin-migration/pkg/command"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+// migrationSource provides the resources of a source namespace, either from a live cluster or from
+// an exported bundle, so that both are replayed into the destination by the same logic
+type migrationSource interface {
+	// Namespace returns the source namespace
+	Namespace() string
+
+	// ListServices returns the services selected by the filter
+	ListServices(ctx context.Context, filter *serviceFilter) (*serving_v1_api.ServiceList, error)
+
+	// EachService calls fn with every service selected by the filter, page by page for a cluster
+	EachService(ctx context.Context, filter *serviceFilter, fn func(*serving_v1_api.Service) error) error
+
+	// GetConfigmap returns a configmap by name, or a NotFound error
+	GetConfigmap(ctx context.Context, name string) (*apiv1.ConfigMap, error)
+
+	// GetSecret returns a secret by name, or a NotFound error
+	GetSecret(ctx context.Context, name string) (*apiv1.Secret, error)
+
+	// GetPersistentVolumeClaim returns a persistent volume claim by name, or a NotFound error
+	GetPersistentVolumeClaim(ctx context.Context, name string) (*apiv1.PersistentVolumeClaim, error)
+
+	// ListRevisionByService returns the revisions of a service
+	ListRevisionByService(ctx context.Context, name string) (*serving_v1_api.RevisionList, error)
+}
+
+// liveSource reads the source resources from a cluster, failing with ErrSourceUnreachable
+// when the cluster does not answer
+type liveSource struct {
+	clientSet       kubernetes.Interface
+	migrationClient command.MigrationClient
+	namespace       string
+}
+
+func newLiveSource(clientSet kubernetes.Interface, migrationClient command.MigrationClient, namespace string) migrationSource {
+	return &liveSource{
+		clientSet:       clientSet,
+		migrationClient: migrationClient,
+		namespace:       namespace,
+	}
+}
+
+func (s *liveSource) Namespace() string {
+	return s.namespace
+}
+
+func (s *liveSource) ListServices(ctx context.Context, filter *serviceFilter) (*serving_v1_api.ServiceList, error) {
+	services := &serving_v1_api.ServiceList{}
+	err := s.EachService(ctx, filter, func(service *serving_v1_api.Service) error {
+		services.Items = append(services.Items, *service)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return services, nil
+}
+
+func (s *liveSource) EachService(ctx context.Context, filter *serviceFilter, fn func(*serving_v1_api.Service) error) error {
+	return filter.each(func(visit func(*serving_v1_api.Service) error) error {
+		// Only the errors of the source cluster are marked, not the errors of fn
+		var visitErr error
+		err := s.migrationClient.EachService(ctx, filter.selector, func(service *serving_v1_api.Service) error {
+			visitErr = visit(service)
+			return visitErr
+		})
+		if visitErr != nil {
+			return visitErr
+		}
+		return sourceError(err)
+	}, func(name string) (*serving_v1_api.Service, error) {
+		service, err := s.migrationClient.GetService(ctx, name)
+		return service, sourceError(err)
+	}, fn)
+}
+
+func (s *liveSource) GetConfigmap(ctx context.Context, name string) (*apiv1.ConfigMap, error) {
+	configmap, err := getConfigmap(ctx, s.clientSet, s.namespace, name)
+	return configmap, sourceError(err)
+}
+
+func (s *liveSource) GetSecret(ctx context.Context, name string) (*apiv1.Secret, error) {
+	secret, err := getSecret(ctx, s.clientSet, s.namespace, name)
+	return secret, sourceError(err)
+}
+
+func (s *liveSource) GetPersistentVolumeClaim(ctx context.Context, name string) (*apiv1.PersistentVolumeClaim, error) {
+	claim, err := getPersistentVolumeClaim(ctx, s.clientSet, s.namespace, name)
+	return claim, sourceError(err)
+}
+
+func (s *liveSource) ListRevisionByService(ctx context.Context, name string) (*serving_v1_api.RevisionList, error) {
+	revisions, err := s.migrationClient.ListRevisionByService(ctx, name)
+	return revisions, sourceError(err)
+}
+
+// bundleSource reads the source resources from an exported bundle loaded in memory
+type bundleSource struct {
+	namespace  string
+	services   []serving_v1_api.Service
+	configmaps map[string]*apiv1.ConfigMap
+	secrets    map[string]*apiv1.Secret
+	revisions  map[string][]serving_v1_api.Revision
+}
+
+func (s *bundleSource) Namespace() string {
+	return s.namespace
+}
+
+func (s *bundleSource) ListServices(ctx context.Context, filter *serviceFilter) (*serving_v1_api.ServiceList, error) {
+	return filter.filter(&serving_v1_api.ServiceList{Items: s.services})
+}
+
+func (s *bundleSource) EachService(ctx context.Context, filter *serviceFilter, fn func(*serving_v1_api.Service) error) error {
+	return filter.each(eachListedService(s.services), nil, fn)
+}
+
+func (s *bundleSource) GetConfigmap(ctx context.Context, name string) (*apiv1.ConfigMap, error) {
+	configmap, ok := s.configmaps[name]
+	if !ok {
+		return nil, api_errors.NewNotFound(schema.GroupResource{Resource: "configmaps"}, name)
+	}
+	return configmap.DeepCopy(), nil
+}
+
+func (s *bundleSource) GetSecret(ctx context.Context, name string) (*apiv1.Secret, error) {
+	secret, ok := s.secrets[name]
+	if !ok {
+		return nil, api_errors.NewNotFound(schema.GroupResource{Resource: "secrets"}, name)
+	}
+	return secret.DeepCopy(), nil
+}
+
+// GetPersistentVolumeClaim returns a NotFound error, bundles do not carry persistent volume claims
+func (s *bundleSource) GetPersistentVolumeClaim(ctx context.Context, name string) (*apiv1.PersistentVolumeClaim, error) {
+	return nil, api_errors.NewNotFound(schema.GroupResource{Resource: "persistentvolumeclaims"}, name)
+}
+
+func (s *bundleSource) ListRevisionByService(ctx context.Context, name string) (*serving_v1_api.RevisionList, error) {
+	revisions := &serving_v1_api.RevisionList{}
+	for _, revision := range s.revisions[name] {
+		revisions.Items = append(revisions.Items, *revision.DeepCopy())
+	}
+	return revisions, nil
+}
@@ -0,0 +1,252 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/fatih/color"
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/kn-plugin-migration/pkg/command"
+	api_serving "knative.dev/serving/pkg/apis/serving"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+	serving_v1_client "knative.dev/serving/pkg/client/clientset/versioned/typed/serving/v1"
+)
+
+// standaloneWorkloads are the Configurations and Routes of a namespace created without a Service, which the
+// migration of the services leaves behind
+type standaloneWorkloads struct {
+	configurations []serving_v1_api.Configuration
+	routes         []serving_v1_api.Route
+}
+
+// empty returns true if the namespace has no standalone Configuration nor Route
+func (w standaloneWorkloads) empty() bool {
+	return len(w.configurations) == 0 && len(w.routes) == 0
+}
+
+// ownedByService returns true if an object was created by a Knative Service
+func ownedByService(meta metav1.ObjectMeta) bool {
+	if meta.Labels[api_serving.ServiceLabelKey] != "" {
+		return true
+	}
+	for _, owner := range meta.OwnerReferences {
+		if owner.Kind == "Service" {
+			return true
+		}
+	}
+	return false
+}
+
+// listStandaloneWorkloads returns the Configurations and Routes of a namespace which are not owned by a Service
+func listStandaloneWorkloads(ctx context.Context, servingClient serving_v1_client.ServingV1Interface, namespace string) (standaloneWorkloads, error) {
+	workloads := standaloneWorkloads{}
+	configurations, err := servingClient.Configurations(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return workloads, err
+	}
+	for _, configuration := range configurations.Items {
+		if !ownedByService(configuration.ObjectMeta) {
+			workloads.configurations = append(workloads.configurations, configuration)
+		}
+	}
+	routes, err := servingClient.Routes(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return workloads, err
+	}
+	for _, route := range routes.Items {
+		if !ownedByService(route.ObjectMeta) {
+			workloads.routes = append(workloads.routes, route)
+		}
+	}
+	return workloads, nil
+}
+
+// reportStandaloneWorkloads warns about the standalone Configurations and Routes of a namespace, which are not
+// migrated without IncludeStandalone
+func reportStandaloneWorkloads(ctx context.Context, servingClient serving_v1_client.ServingV1Interface, namespace string, options *MigrationOptions) error {
+	workloads, err := listStandaloneWorkloads(ctx, servingClient, namespace)
+	if err != nil {
+		return sourceError(err)
+	}
+	for _, configuration := range workloads.configurations {
+		options.warn("Configuration %s of namespace %s is not owned by a service and is not migrated, use --include-standalone to migrate it", configuration.Name, namespace)
+	}
+	for _, route := range workloads.routes {
+		options.warn("Route %s of namespace %s is not owned by a service and is not migrated, use --include-standalone to migrate it", route.Name, namespace)
+	}
+	return nil
+}
+
+// buildStandaloneConfiguration returns the copy of a standalone Configuration to create in the given namespace,
+// its revisions are generated again by the destination from its template
+func buildStandaloneConfiguration(namespace string, configuration serving_v1_api.Configuration) *serving_v1_api.Configuration {
+	return &serving_v1_api.Configuration{
+		ObjectMeta: command.SanitizeObjectMeta(configuration.ObjectMeta, namespace),
+		Spec:       *configuration.Spec.DeepCopy(),
+	}
+}
+
+// buildStandaloneRoute returns the copy of a standalone Route to create in the given namespace. The traffic
+// targets naming a revision of a standalone Configuration which is not generated again by the destination, i.e.
+// not named by the template of the Configuration, route to the latest revision of the Configuration instead.
+func buildStandaloneRoute(namespace string, route serving_v1_api.Route, revisionConfigurations map[string]string, workloads standaloneWorkloads) (*serving_v1_api.Route, []string) {
+	templates := map[string]string{}
+	for _, configuration := range workloads.configurations {
+		templates[configuration.Name] = configuration.Spec.Template.Name
+	}
+	built := &serving_v1_api.Route{
+		ObjectMeta: command.SanitizeObjectMeta(route.ObjectMeta, namespace),
+		Spec:       *route.Spec.DeepCopy(),
+	}
+	rerouted := []string{}
+	for i, target := range built.Spec.Traffic {
+		configuration, ok := revisionConfigurations[target.RevisionName]
+		if !ok {
+			continue
+		}
+		if template, standalone := templates[configuration]; !standalone || template == target.RevisionName {
+			continue
+		}
+		rerouted = append(rerouted, target.RevisionName)
+		built.Spec.Traffic[i].RevisionName = ""
+		built.Spec.Traffic[i].ConfigurationName = configuration
+		built.Spec.Traffic[i].LatestRevision = nil
+	}
+	return built, rerouted
+}
+
+// revisionConfigurations returns the Configuration of every revision named by the traffic of the Routes
+func revisionConfigurations(ctx context.Context, servingClient serving_v1_client.ServingV1Interface, namespace string, routes []serving_v1_api.Route) (map[string]string, error) {
+	configurations := map[string]string{}
+	for _, route := range routes {
+		for _, target := range route.Spec.Traffic {
+			if target.RevisionName == "" {
+				continue
+			}
+			if _, ok := configurations[target.RevisionName]; ok {
+				continue
+			}
+			revision, err := servingClient.Revisions(namespace).Get(ctx, target.RevisionName, metav1.GetOptions{})
+			if api_errors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			configurations[target.RevisionName] = revision.Labels[api_serving.ConfigurationLabelKey]
+		}
+	}
+	return configurations, nil
+}
+
+// migrateStandaloneWorkloads recreates in the destination namespace the Configurations and Routes of the source
+// namespace which are not owned by a Service, and returns the objects it created. The Routes are created after
+// the Configurations and services they route to, the objects already existing in the destination are kept.
+func migrateStandaloneWorkloads(ctx context.Context, servingClientS, servingClientD serving_v1_client.ServingV1Interface, namespaceS, namespaceD string, options *MigrationOptions) ([]string, error) {
+	workloads, err := listStandaloneWorkloads(ctx, servingClientS, namespaceS)
+	if err != nil {
+		return nil, sourceError(err)
+	}
+	copied := []string{}
+	if workloads.empty() {
+		return copied, nil
+	}
+	fmt.Fprintln(options.out(), "Migrate the standalone configurations and routes of namespace", color.BlueString(namespaceS))
+	for _, configurationS := range workloads.configurations {
+		configuration := buildStandaloneConfiguration(namespaceD, configurationS)
+		options.stampOwnership(&configuration.ObjectMeta)
+		created, err := createIfAbsent(ctx, "configuration "+configuration.Name, options, func() error {
+			_, err := servingClientD.Configurations(namespaceD).Create(ctx, configuration, metav1.CreateOptions{})
+			return err
+		})
+		if err != nil {
+			return copied, err
+		}
+		if created {
+			options.changes().createdServing("Configuration", namespaceD, configuration.Name, servingClientD)
+			copied = append(copied, "Configuration "+configuration.Name)
+		}
+	}
+	configurations, err := revisionConfigurations(ctx, servingClientS, namespaceS, workloads.routes)
+	if err != nil {
+		return copied, sourceError(err)
+	}
+	for _, routeS := range workloads.routes {
+		route, rerouted := buildStandaloneRoute(namespaceD, routeS, configurations, workloads)
+		for _, revision := range rerouted {
+			options.warn("Route %s routes to revision %s which is generated again by the destination, it routes to the latest revision of its configuration instead", route.Name, revision)
+		}
+		options.stampOwnership(&route.ObjectMeta)
+		created, err := createIfAbsent(ctx, "route "+route.Name, options, func() error {
+			_, err := servingClientD.Routes(namespaceD).Create(ctx, route, metav1.CreateOptions{})
+			return err
+		})
+		if err != nil {
+			return copied, err
+		}
+		if created {
+			options.changes().createdServing("Route", namespaceD, route.Name, servingClientD)
+			copied = append(copied, "Route "+route.Name)
+		}
+	}
+	return copied, nil
+}
+
+// planStandaloneWorkloads adds the standalone Configurations and Routes of the source namespace of a plan, which
+// are only migrated with include
+func planStandaloneWorkloads(ctx context.Context, plan *migrationPlan, servingClientS, servingClientD serving_v1_client.ServingV1Interface, include bool) error {
+	workloads, err := listStandaloneWorkloads(ctx, servingClientS, plan.SourceNamespace)
+	if err != nil {
+		return err
+	}
+	add := func(kind, name string, get func() error) error {
+		if !include {
+			plan.add(planEntry{Kind: kind, Name: name, Namespace: plan.SourceNamespace, Cluster: "source", Action: planActionSkip, Reason: "not owned by a service, use --include-standalone"})
+			return nil
+		}
+		err := get()
+		switch {
+		case api_errors.IsNotFound(err):
+			plan.add(planEntry{Kind: kind, Name: name, Namespace: plan.DestinationNamespace, Cluster: "destination", Action: planActionCreate})
+		case err != nil:
+			return err
+		default:
+			plan.add(planEntry{Kind: kind, Name: name, Namespace: plan.DestinationNamespace, Cluster: "destination", Action: planActionSkip, Reason: "already exists, the destination object is kept"})
+		}
+		return nil
+	}
+	for _, configuration := range workloads.configurations {
+		err := add("Configuration", configuration.Name, func() error {
+			_, err := servingClientD.Configurations(plan.DestinationNamespace).Get(ctx, configuration.Name, metav1.GetOptions{})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, route := range workloads.routes {
+		err := add("Route", route.Name, func() error {
+			_, err := servingClientD.Routes(plan.DestinationNamespace).Get(ctx, route.Name, metav1.GetOptions{})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"testing"
+
+	"gotest.tools/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	api_serving "knative.dev/serving/pkg/apis/serving"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+	serving_fake "knative.dev/serving/pkg/client/clientset/versioned/fake"
+)
+
+func newStandaloneSource() *serving_fake.Clientset {
+	return serving_fake.NewSimpleClientset(
+		&serving_v1_api.Configuration{ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "default", Labels: map[string]string{api_serving.ServiceLabelKey: "hello"}}},
+		&serving_v1_api.Configuration{ObjectMeta: metav1.ObjectMeta{Name: "batch", Namespace: "default"}},
+		&serving_v1_api.Revision{ObjectMeta: metav1.ObjectMeta{Name: "batch-00001", Namespace: "default", Labels: map[string]string{api_serving.ConfigurationLabelKey: "batch"}}},
+		&serving_v1_api.Revision{ObjectMeta: metav1.ObjectMeta{Name: "hello-00001", Namespace: "default", Labels: map[string]string{api_serving.ServiceLabelKey: "hello", api_serving.ConfigurationLabelKey: "hello"}}},
+		&serving_v1_api.Route{
+			ObjectMeta: metav1.ObjectMeta{Name: "batch", Namespace: "default"},
+			Spec: serving_v1_api.RouteSpec{Traffic: []serving_v1_api.TrafficTarget{
+				{RevisionName: "batch-00001", Tag: "pinned"},
+				{RevisionName: "hello-00001", Tag: "hello"},
+			}},
+		},
+	)
+}
+
+func TestMigrateStandaloneWorkloads(t *testing.T) {
+	servingClientS := newStandaloneSource()
+	servingClientD := serving_fake.NewSimpleClientset()
+	options := NewMigrationOptions()
+	options.RollbackOnFailure = true
+
+	copied, err := migrateStandaloneWorkloads(context.Background(), servingClientS.ServingV1(), servingClientD.ServingV1(), "default", "prod", options)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, copied, []string{"Configuration batch", "Route batch"})
+	_, err = servingClientD.ServingV1().Configurations("prod").Get(context.Background(), "hello", metav1.GetOptions{})
+	assert.ErrorContains(t, err, "not found")
+	route, err := servingClientD.ServingV1().Routes("prod").Get(context.Background(), "batch", metav1.GetOptions{})
+	assert.NilError(t, err)
+	// The revision of the standalone configuration is generated again, the revision of the service is migrated
+	assert.DeepEqual(t, route.Spec.Traffic, []serving_v1_api.TrafficTarget{
+		{ConfigurationName: "batch", Tag: "pinned"},
+		{RevisionName: "hello-00001", Tag: "hello"},
+	})
+	assert.Equal(t, len(options.warnings()), 1)
+
+	// Objects already existing in the destination are kept
+	copied, err = migrateStandaloneWorkloads(context.Background(), servingClientS.ServingV1(), servingClientD.ServingV1(), "default", "prod", options)
+	assert.NilError(t, err)
+	assert.Equal(t, len(copied), 0)
+
+	assert.NilError(t, options.Rollback(context.Background()))
+	_, err = servingClientD.ServingV1().Configurations("prod").Get(context.Background(), "batch", metav1.GetOptions{})
+	assert.ErrorContains(t, err, "not found")
+	_, err = servingClientD.ServingV1().Routes("prod").Get(context.Background(), "batch", metav1.GetOptions{})
+	assert.ErrorContains(t, err, "not found")
+}
+
+func TestReportStandaloneWorkloads(t *testing.T) {
+	options := NewMigrationOptions()
+	assert.NilError(t, reportStandaloneWorkloads(context.Background(), newStandaloneSource().ServingV1(), "default", options))
+	assert.DeepEqual(t, options.warnings(), []string{
+		"Configuration batch of namespace default is not owned by a service and is not migrated, use --include-standalone to migrate it",
+		"Route batch of namespace default is not owned by a service and is not migrated, use --include-standalone to migrate it",
+	})
+}
+
+func TestPlanStandaloneWorkloads(t *testing.T) {
+	servingClientS := newStandaloneSource()
+	servingClientD := serving_fake.NewSimpleClientset(&serving_v1_api.Route{ObjectMeta: metav1.ObjectMeta{Name: "batch", Namespace: "prod"}})
+
+	plan := &migrationPlan{SourceNamespace: "default", DestinationNamespace: "prod"}
+	assert.NilError(t, planStandaloneWorkloads(context.Background(), plan, servingClientS.ServingV1(), servingClientD.ServingV1(), false))
+	assert.Equal(t, len(plan.Entries), 2)
+	assert.Equal(t, plan.Entries[0].Action, planActionSkip)
+	assert.Equal(t, plan.Entries[0].Cluster, "source")
+
+	plan = &migrationPlan{SourceNamespace: "default", DestinationNamespace: "prod"}
+	assert.NilError(t, planStandaloneWorkloads(context.Background(), plan, servingClientS.ServingV1(), servingClientD.ServingV1(), true))
+	assert.DeepEqual(t, plan.Entries, []planEntry{
+		{Kind: "Configuration", Name: "batch", Namespace: "prod", Cluster: "destination", Action: planActionCreate},
+		{Kind: "Route", Name: "batch", Namespace: "prod", Cluster: "destination", Action: planActionSkip, Reason: "already exists, the destination object is kept"},
+	})
+}
@@ -0,0 +1,464 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"knative.dev/kn-plugin-migration/pkg/command"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+// syncHashAnnotation records the spec hash of a service at the time of the last sync
+const syncHashAnnotation = "migration.knative.dev/last-sync-hash"
+
+// syncAction is what a sync does with a service present in either cluster
+type syncAction string
+
+const (
+	syncActionInSync            syncAction = "in-sync"
+	syncActionCopyToDestination syncAction = "copy-to-destination"
+	syncActionCopyToSource      syncAction = "copy-to-source"
+	syncActionConflict          syncAction = "conflict"
+)
+
+// syncState is the state of one service on both sides of a sync
+type syncState struct {
+	ExistsS bool
+	ExistsD bool
+	// HashS and HashD are the hashes of the current specs
+	HashS string
+	HashD string
+	// LastS and LastD are the hashes recorded by the last sync
+	LastS string
+	LastD string
+}
+
+type syncCmdFlags struct {
+	Namespace             string
+	KubeConfig            string
+	Context               string
+	SourceInCluster       bool
+	DestinationKubeConfig string
+	DestinationContext    string
+	DestinationNamespace  string
+	SourceProfile         string
+	DestinationProfile    string
+	DryRun                bool
+	DashboardAddr         string
+	AuditLog              string
+	Schedule              string
+	ReportDir             string
+	ReportWebhook         string
+}
+
+var syncFlags syncCmdFlags
+
+// NewSyncCommand represents the 'migrate sync' command
+func NewSyncCommand() *cobra.Command {
+	syncCmd := &cobra.Command{
+		Use:   "sync",
+		Short: "Synchronize Knative services in both directions between two clusters",
+		Long: `Synchronize Knative services in both directions between two clusters.
+
+A service changed on one side only since the last sync is copied to the other side.
+A service changed on both sides, or deleted on one side, is reported as a conflict
+and left untouched for manual resolution.
+
+With --schedule, the sync runs as a daemon whenever the cron expression fires,
+e.g. to replicate the services to a standby cluster every night, until it is
+interrupted. The report of every run is written to --report-dir and posted to
+--report-webhook.`,
+		Example: `
+  # Synchronize the services of the default namespace of both clusters
+  kn migrate sync --namespace default --destination-namespace default
+  # Show what a synchronization would do without changing anything
+  kn migrate sync --namespace default --destination-namespace default --dry-run
+  # Synchronize the services every night at 2am and keep the report of every run
+  kn migrate sync --namespace default --destination-namespace default --schedule "0 2 * * *" --report-dir reports`,
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := command.NewCommandContext(cmd)
+			defer cancel()
+
+			if err := applyProfiles(cmd, syncFlags.SourceProfile, syncFlags.DestinationProfile); err != nil {
+				return err
+			}
+
+			kubeconfigS, kubeconfigD, err := getKubeConfigs(clusterConfig{KubeConfig: syncFlags.KubeConfig, Context: syncFlags.Context, InCluster: syncFlags.SourceInCluster}, clusterConfig{KubeConfig: syncFlags.DestinationKubeConfig, Context: syncFlags.DestinationContext})
+			if err != nil {
+				return err
+			}
+			if syncFlags.Namespace == "" {
+				return fmt.Errorf("cannot get source cluster namespace, please use --namespace to set")
+			}
+			if syncFlags.DestinationNamespace == "" {
+				return fmt.Errorf("cannot get destination cluster namespace, please use --destination-namespace to set")
+			}
+			var schedule *cronSchedule
+			if syncFlags.Schedule != "" {
+				schedule, err = parseCron(syncFlags.Schedule)
+				if err != nil {
+					return err
+				}
+			}
+			webhook, err := newReportWebhook(syncFlags.ReportWebhook)
+			if err != nil {
+				return err
+			}
+			audit, err := openAuditLog(syncFlags.AuditLog)
+			if err != nil {
+				return err
+			}
+			kubeconfigS.audit, kubeconfigD.audit = audit, audit
+
+			_, migrationClientS, err := getClients(kubeconfigS, syncFlags.Namespace)
+			if err != nil {
+				return err
+			}
+			_, migrationClientD, err := getClients(kubeconfigD, syncFlags.DestinationNamespace)
+			if err != nil {
+				return err
+			}
+
+			var board *dashboard
+			if syncFlags.DashboardAddr != "" {
+				board = newDashboard([]namespacePair{{Source: syncFlags.Namespace, Destination: syncFlags.DestinationNamespace}})
+				if err := board.serve(syncFlags.DashboardAddr); err != nil {
+					return err
+				}
+				fmt.Fprintln(cmd.OutOrStdout(), "Serving the sync progress on", color.CyanString(syncFlags.DashboardAddr))
+			}
+
+			run := func() (int, error) {
+				// Outside of the maintenance windows a run only prints what it would synchronize
+				dryRun := syncFlags.DryRun
+				if !dryRun {
+					outside, err := outsideMaintenanceWindows(time.Now())
+					if err != nil {
+						return 0, err
+					}
+					if outside {
+						fmt.Fprintln(cmd.ErrOrStderr(), color.YellowString("Outside of the maintenance windows declared in the config file, refusing to change the services and printing what would be synchronized only"))
+						dryRun = true
+					}
+				}
+				report := newMigrationReport()
+				report.Command, report.Flags = cmd.CommandPath(), usedFlags(cmd)
+				conflicts, err := syncServices(ctx, cmd.OutOrStdout(), migrationClientS, migrationClientD, syncFlags.Namespace, syncFlags.DestinationNamespace, dryRun, board, report.namespace(syncFlags.Namespace, syncFlags.DestinationNamespace))
+				if err == nil && dryRun != syncFlags.DryRun {
+					err = fmt.Errorf("refused the sync outside of the maintenance windows, only what would be synchronized was printed")
+				}
+				report.finish(err)
+				publishSyncReport(ctx, cmd.OutOrStdout(), report, syncFlags.ReportDir, webhook)
+				return conflicts, err
+			}
+			var conflicts int
+			if schedule != nil {
+				err = syncOnSchedule(ctx, cmd.OutOrStdout(), schedule, run)
+			} else {
+				conflicts, err = run()
+			}
+			board.finish(err)
+			if err := audit.Close(); err != nil {
+				return err
+			}
+			if err != nil {
+				return err
+			}
+			if conflicts > 0 {
+				return fmt.Errorf("%d service(s) have conflicting changes and need manual resolution", conflicts)
+			}
+			return nil
+		},
+	}
+
+	syncCmd.Flags().StringVarP(&syncFlags.Namespace, "namespace", "n", "", "The namespace of the source Knative resources")
+	syncCmd.Flags().StringVar(&syncFlags.KubeConfig, "kubeconfig", "", "The kubeconfig of the Knative resources (default is KUBECONFIG from environment variable)")
+	syncCmd.Flags().StringVar(&syncFlags.Context, "context", "", "The context of the kubeconfig of the Knative resources (default is the current context)")
+	syncCmd.Flags().BoolVar(&syncFlags.SourceInCluster, "source-in-cluster", false, "Use the ServiceAccount of the pod the sync runs in for the source cluster instead of a kubeconfig")
+	syncCmd.Flags().StringVar(&syncFlags.DestinationKubeConfig, "destination-kubeconfig", "", "The kubeconfig of the destination Knative resources (default is KUBECONFIG_DESTINATION from environment variable, else the source kubeconfig with --destination-context)")
+	syncCmd.Flags().StringVar(&syncFlags.DestinationContext, "destination-context", "", "The context of the kubeconfig of the destination Knative resources (default is the current context)")
+	syncCmd.Flags().StringVar(&syncFlags.DestinationNamespace, "destination-namespace", "", "The namespace of the destination Knative resources")
+	syncCmd.Flags().StringVar(&syncFlags.SourceProfile, "source-profile", "", "The profile of the config file giving the kubeconfig, context and namespace of the source Knative resources")
+	syncCmd.Flags().StringVar(&syncFlags.DestinationProfile, "destination-profile", "", "The profile of the config file giving the kubeconfig, context and namespace of the destination Knative resources")
+	syncCmd.Flags().StringVar(&syncFlags.DashboardAddr, "dashboard-addr", "", "Serve a read-only web dashboard of the progress of the sync on this address while it runs, e.g. :8080")
+	syncCmd.Flags().StringVar(&syncFlags.AuditLog, "audit-log", "", "Append a JSON record with the timestamp, cluster, verb, resource, namespace, name and result of every create, update, patch and delete request sent to the clusters to this file")
+	syncCmd.Flags().StringVar(&syncFlags.Schedule, "schedule", "", "Run the sync as a daemon whenever this cron expression fires in the local time zone, e.g. \"0 2 * * *\" every night at 2am, until it is interrupted")
+	syncCmd.Flags().StringVar(&syncFlags.ReportDir, "report-dir", "", "Write the report of every run to a sync-<time>.json file of this directory")
+	syncCmd.Flags().StringVar(&syncFlags.ReportWebhook, "report-webhook", "", "Post the report of every run as JSON to this URL")
+	syncCmd.Flags().BoolVar(&syncFlags.DryRun, "dry-run", false, "Print what would be synchronized without changing anything in either cluster")
+	return syncCmd
+}
+
+// syncServices synchronizes the services of both clusters and returns the number of conflicts found,
+// the progress is recorded on the dashboard if any and every service in the report: services copied to either
+// side are migrated, services in sync or not copied by a dry run are skipped and conflicts are failed
+func syncServices(ctx context.Context, out io.Writer, migrationClientS, migrationClientD command.MigrationClient, namespaceS, namespaceD string, dryRun bool, board *dashboard, report *NamespaceReport) (int, error) {
+	defer report.done()
+	servicesS, err := migrationClientS.ListService(ctx)
+	if err != nil {
+		return 0, err
+	}
+	servicesD, err := migrationClientD.ListService(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	byNameS := map[string]serving_v1_api.Service{}
+	byNameD := map[string]serving_v1_api.Service{}
+	names := []string{}
+	for _, service := range servicesS.Items {
+		byNameS[service.Name] = service
+		names = append(names, service.Name)
+	}
+	for _, service := range servicesD.Items {
+		if _, ok := byNameS[service.Name]; !ok {
+			names = append(names, service.Name)
+		}
+		byNameD[service.Name] = service
+	}
+	sort.Strings(names)
+	board.start(namespaceS, namespaceD, len(names))
+
+	conflicts := 0
+	color.New(color.FgCyan).Fprintf(out, "%-30s%-22s%s\n", "Name", "Action", "Reason")
+	for _, name := range names {
+		serviceS, existsS := byNameS[name]
+		serviceD, existsD := byNameD[name]
+		state := syncState{ExistsS: existsS, ExistsD: existsD}
+		if existsS {
+			state.HashS, err = serviceSpecHash(serviceS)
+			if err != nil {
+				return conflicts, err
+			}
+			state.LastS = serviceS.Annotations[syncHashAnnotation]
+		}
+		if existsD {
+			state.HashD, err = serviceSpecHash(serviceD)
+			if err != nil {
+				return conflicts, err
+			}
+			state.LastD = serviceD.Annotations[syncHashAnnotation]
+		}
+
+		action, reason := decideSync(state)
+		switch action {
+		case syncActionConflict:
+			conflicts++
+			fmt.Fprintf(out, "%-30s%s%s\n", name, color.RedString("%-22s", action), reason)
+			board.service(namespaceS, namespaceD, name, fmt.Errorf("conflict: %s", reason))
+			report.add(name, nil, nil, 0, fmt.Errorf("conflict: %s", reason))
+			continue
+		default:
+			fmt.Fprintf(out, "%-30s%-22s%s\n", name, action, reason)
+		}
+		if dryRun {
+			board.service(namespaceS, namespaceD, name, nil)
+			report.skip(name)
+			continue
+		}
+		started := time.Now()
+
+		switch action {
+		case syncActionCopyToDestination:
+			err = syncService(ctx, migrationClientS, migrationClientD, serviceS, serviceD, existsD, state.HashS)
+		case syncActionCopyToSource:
+			err = syncService(ctx, migrationClientD, migrationClientS, serviceD, serviceS, existsS, state.HashD)
+		case syncActionInSync:
+			// The source is not written only to record the last sync
+			err = recordSyncHash(ctx, migrationClientD, serviceD, state.HashD)
+		}
+		board.service(namespaceS, namespaceD, name, err)
+		if action == syncActionInSync && err == nil {
+			report.skip(name)
+		} else {
+			report.add(name, nil, nil, time.Since(started), err)
+		}
+		if err != nil {
+			board.done(namespaceS, namespaceD, err)
+			return conflicts, err
+		}
+	}
+	board.done(namespaceS, namespaceD, nil)
+	if conflicts > 0 {
+		board.drift(namespaceS, namespaceD, fmt.Sprintf("%d conflict(s)", conflicts))
+	} else {
+		board.drift(namespaceS, namespaceD, "in sync")
+	}
+	return conflicts, nil
+}
+
+// decideSync decides how to synchronize a service given its state on both sides
+func decideSync(state syncState) (syncAction, string) {
+	switch {
+	case state.ExistsS && !state.ExistsD:
+		if state.LastS != "" {
+			return syncActionConflict, "deleted in destination since last sync"
+		}
+		return syncActionCopyToDestination, "only exists in source"
+	case !state.ExistsS && state.ExistsD:
+		if state.LastD != "" {
+			return syncActionConflict, "deleted in source since last sync"
+		}
+		return syncActionCopyToSource, "only exists in destination"
+	}
+
+	if state.HashS == state.HashD {
+		return syncActionInSync, ""
+	}
+	// A service found in sync only records the last sync in the destination
+	last := state.LastS
+	if last == "" {
+		last = state.LastD
+	}
+	if last == "" || (state.LastD != "" && state.LastD != last) {
+		return syncActionConflict, "specs differ and there is no common last sync"
+	}
+	changedS := state.HashS != last
+	changedD := state.HashD != last
+	switch {
+	case changedS && changedD:
+		return syncActionConflict, "changed in both clusters since last sync"
+	case changedS:
+		return syncActionCopyToDestination, "changed in source since last sync"
+	default:
+		return syncActionCopyToSource, "changed in destination since last sync"
+	}
+}
+
+// syncService copies the spec of from to the target cluster, creating the service if needed,
+// and records the synchronized hash on both sides
+func syncService(ctx context.Context, fromClient, toClient command.MigrationClient, from, to serving_v1_api.Service, toExists bool, hash string) error {
+	if !toExists {
+		service := from.DeepCopy()
+		if service.Annotations == nil {
+			service.Annotations = map[string]string{}
+		}
+		service.Annotations[syncHashAnnotation] = hash
+		_, err := toClient.CreateService(ctx, service)
+		if err != nil {
+			return err
+		}
+	} else {
+		service := to.DeepCopy()
+		service.Spec = *from.Spec.DeepCopy()
+		// Let the target cluster generate the name of the new revision
+		service.Spec.Template.ObjectMeta.Name = ""
+		if service.Annotations == nil {
+			service.Annotations = map[string]string{}
+		}
+		service.Annotations[syncHashAnnotation] = hash
+		_, err := toClient.UpdateService(ctx, service)
+		if err != nil {
+			return err
+		}
+	}
+	return recordSyncHash(ctx, fromClient, from, hash)
+}
+
+// recordSyncHash stores the hash of the last sync on the service if it changed
+func recordSyncHash(ctx context.Context, migrationClient command.MigrationClient, service serving_v1_api.Service, hash string) error {
+	if service.Annotations[syncHashAnnotation] == hash {
+		return nil
+	}
+	updated := service.DeepCopy()
+	if updated.Annotations == nil {
+		updated.Annotations = map[string]string{}
+	}
+	updated.Annotations[syncHashAnnotation] = hash
+	_, err := migrationClient.UpdateService(ctx, updated)
+	return err
+}
+
+// serviceSpecHash returns a hash of the service spec which ignores the revision name
+// since it is generated independently by each cluster
+func serviceSpecHash(service serving_v1_api.Service) (string, error) {
+	spec := service.Spec.DeepCopy()
+	spec.Template.ObjectMeta.Name = ""
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(data)), nil
+}
+
+// syncOnSchedule runs the sync whenever the schedule fires until the context is done. The conflicts and errors of
+// a run are reported to out and the next run still happens, so that a failing run does not stop the replication.
+func syncOnSchedule(ctx context.Context, out io.Writer, schedule *cronSchedule, run func() (int, error)) error {
+	for {
+		next := schedule.next(time.Now())
+		if next.IsZero() {
+			return fmt.Errorf("the schedule never fires")
+		}
+		fmt.Fprintln(out, "Next sync at", color.CyanString(next.Format(time.RFC1123)))
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Until(next)):
+		}
+		conflicts, err := run()
+		switch {
+		case err != nil:
+			fmt.Fprintln(out, color.RedString("The sync failed: %v", err))
+		case conflicts > 0:
+			fmt.Fprintln(out, color.RedString("%d service(s) have conflicting changes and need manual resolution", conflicts))
+		}
+	}
+}
+
+// newReportWebhook returns the hook posting the report of every run to a --report-webhook URL, nil if it is empty
+func newReportWebhook(target string) (*migrationHook, error) {
+	// A webhook has no output of its own
+	hook, err := newMigrationHook(target, DefaultHookTimeout, io.Discard, io.Discard)
+	if err != nil || hook == nil {
+		return nil, err
+	}
+	if !hook.webhook() {
+		return nil, fmt.Errorf("the report webhook must be an http or https URL, got %s", target)
+	}
+	return hook, nil
+}
+
+// publishSyncReport writes the report of a sync run to a file of the report directory and posts it to the
+// webhook, if any. A report which cannot be published is reported to out without failing the run.
+func publishSyncReport(ctx context.Context, out io.Writer, report *MigrationReport, dir string, webhook *migrationHook) {
+	if dir != "" {
+		path := filepath.Join(dir, "sync-"+report.StartedAt.UTC().Format("20060102T150405Z")+".json")
+		err := os.MkdirAll(dir, 0750)
+		if err == nil {
+			err = writeReportFile(path, report)
+		}
+		if err != nil {
+			fmt.Fprintln(out, color.RedString("Cannot write the sync report: %v", err))
+		} else {
+			fmt.Fprintln(out, "Wrote the sync report to", color.CyanString(path))
+		}
+	}
+	attributes := map[string]string{"succeeded": fmt.Sprint(report.Succeeded)}
+	if err := webhook.call(ctx, hookPostRun, attributes, report); err != nil {
+		fmt.Fprintln(out, color.RedString(err.Error()))
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestDecideSync(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		state  syncState
+		action syncAction
+	}{
+		{"new in source", syncState{ExistsS: true, HashS: "a"}, syncActionCopyToDestination},
+		{"new in destination", syncState{ExistsD: true, HashD: "a"}, syncActionCopyToSource},
+		{"deleted in destination", syncState{ExistsS: true, HashS: "a", LastS: "a"}, syncActionConflict},
+		{"deleted in source", syncState{ExistsD: true, HashD: "a", LastD: "a"}, syncActionConflict},
+		{"unchanged", syncState{ExistsS: true, ExistsD: true, HashS: "a", HashD: "a", LastS: "a", LastD: "a"}, syncActionInSync},
+		{"equal without last sync", syncState{ExistsS: true, ExistsD: true, HashS: "a", HashD: "a"}, syncActionInSync},
+		{"different without last sync", syncState{ExistsS: true, ExistsD: true, HashS: "a", HashD: "b"}, syncActionConflict},
+		{"changed in source", syncState{ExistsS: true, ExistsD: true, HashS: "b", HashD: "a", LastS: "a", LastD: "a"}, syncActionCopyToDestination},
+		{"changed in destination", syncState{ExistsS: true, ExistsD: true, HashS: "a", HashD: "b", LastS: "a", LastD: "a"}, syncActionCopyToSource},
+		{"changed in both", syncState{ExistsS: true, ExistsD: true, HashS: "b", HashD: "c", LastS: "a", LastD: "a"}, syncActionConflict},
+		{"changed in source since in sync", syncState{ExistsS: true, ExistsD: true, HashS: "b", HashD: "a", LastD: "a"}, syncActionCopyToDestination},
+		{"changed in destination since in sync", syncState{ExistsS: true, ExistsD: true, HashS: "a", HashD: "b", LastD: "a"}, syncActionCopyToSource},
+		{"different last syncs", syncState{ExistsS: true, ExistsD: true, HashS: "b", HashD: "a", LastS: "c", LastD: "a"}, syncActionConflict},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			action, _ := decideSync(tc.state)
+			assert.Equal(t, action, tc.action)
+		})
+	}
+}
+
+func TestSyncReport(t *testing.T) {
+	_, migrationClientS := newSimulatedDestination("default", simulatedBundle("default", "hello", "bye"))
+	_, migrationClientD := newSimulatedDestination("standby", simulatedBundle("standby", "bye"))
+	report := newMigrationReport()
+	conflicts, err := syncServices(context.Background(), io.Discard, migrationClientS, migrationClientD, "default", "standby", false, nil, report.namespace("default", "standby"))
+	assert.NilError(t, err)
+	assert.Equal(t, conflicts, 0)
+	report.finish(err)
+	assert.Assert(t, report.Succeeded)
+	statuses := map[string]ServiceStatus{}
+	for _, service := range report.Namespaces[0].Services {
+		statuses[service.Name] = service.Status
+	}
+	assert.DeepEqual(t, statuses, map[string]ServiceStatus{"bye": ServiceStatusSkipped, "hello": ServiceStatusMigrated})
+	// The service in sync records the last sync in the destination only
+	byeS, err := migrationClientS.GetService(context.Background(), "bye")
+	assert.NilError(t, err)
+	assert.Equal(t, byeS.Annotations[syncHashAnnotation], "")
+	byeD, err := migrationClientD.GetService(context.Background(), "bye")
+	assert.NilError(t, err)
+	assert.Assert(t, byeD.Annotations[syncHashAnnotation] != "")
+
+	posted := &MigrationReport{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.Header.Get("Kn-Migration-Event"), hookPostRun)
+		assert.NilError(t, json.NewDecoder(r.Body).Decode(posted))
+	}))
+	defer server.Close()
+	webhook, err := newReportWebhook(server.URL)
+	assert.NilError(t, err)
+	dir := filepath.Join(t.TempDir(), "reports")
+	out := &bytes.Buffer{}
+	publishSyncReport(context.Background(), out, report, dir, webhook)
+	assert.Equal(t, len(posted.Namespaces[0].Services), 2)
+	files, err := ioutil.ReadDir(dir)
+	assert.NilError(t, err)
+	assert.Equal(t, len(files), 1)
+	assert.Equal(t, files[0].Name(), "sync-"+report.StartedAt.UTC().Format("20060102T150405Z")+".json")
+	assert.Assert(t, strings.Contains(out.String(), "Wrote the sync report to"), out.String())
+
+	_, err = newReportWebhook("./notify.sh")
+	assert.ErrorContains(t, err, "must be an http or https URL")
+}
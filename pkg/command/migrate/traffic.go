@@ -0,0 +1,109 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+	"knative.dev/kn-plugin-migration/pkg/command"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+// describeTraffic returns a short description of a traffic block, e.g. hello-00001=90%,latest=10%(canary)
+func describeTraffic(traffic []serving_v1_api.TrafficTarget) string {
+	targets := []string{}
+	for _, target := range traffic {
+		name := target.RevisionName
+		if target.LatestRevision != nil && *target.LatestRevision {
+			name = "latest"
+		}
+		percent := int64(0)
+		if target.Percent != nil {
+			percent = *target.Percent
+		}
+		description := fmt.Sprintf("%s=%d%%", name, percent)
+		if target.Tag != "" {
+			description += fmt.Sprintf("(%s)", target.Tag)
+		}
+		targets = append(targets, description)
+	}
+	return strings.Join(targets, ",")
+}
+
+// missingTrafficRevisions returns the revisions named by a traffic block which do not exist in the destination
+func missingTrafficRevisions(ctx context.Context, migrationClient command.MigrationClient, traffic []serving_v1_api.TrafficTarget) ([]string, error) {
+	missing := []string{}
+	checked := map[string]bool{}
+	for _, target := range traffic {
+		if target.RevisionName == "" || checked[target.RevisionName] {
+			continue
+		}
+		checked[target.RevisionName] = true
+		_, err := migrationClient.GetRevision(ctx, target.RevisionName)
+		if api_errors.IsNotFound(err) {
+			missing = append(missing, target.RevisionName)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return missing, nil
+}
+
+// applyTraffic sets the traffic block of the source service on the migrated service once all its revisions exist,
+// so that percentages, tags and latestRevision targets are preserved and never point at a revision not migrated yet
+func applyTraffic(ctx context.Context, migrationClient command.MigrationClient, serviceName string, traffic []serving_v1_api.TrafficTarget, options *MigrationOptions) error {
+	missing, err := missingTrafficRevisions(ctx, migrationClient, traffic)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("cannot restore the traffic of service %s: revisions %s were not migrated", serviceName, strings.Join(missing, ", "))
+	}
+
+	retries := 0
+	backoff := newRetryBackoff(options.RetryBackoff)
+	for {
+		service, err := migrationClient.GetService(ctx, serviceName)
+		if err != nil {
+			return err
+		}
+		service.Spec.Traffic = traffic
+		err = options.paced(ctx, "update traffic of service "+serviceName, func() error {
+			_, err := migrationClient.UpdateService(ctx, service)
+			return destinationError(err)
+		})
+		if api_errors.IsConflict(err) && retries < options.MaxRetries {
+			delay := backoff.Step()
+			retries++
+			fmt.Fprintf(options.out(), "retry to update the traffic of service(%s) after %s(try#: %d)\n", serviceName, delay.Round(time.Millisecond), retries)
+			if err := options.wait(ctx, delay, "update traffic of service "+serviceName); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(options.out(), "Restored the traffic of service", color.CyanString(serviceName), "to", describeTraffic(traffic))
+		return nil
+	}
+}
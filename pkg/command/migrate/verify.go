@@ -0,0 +1,260 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"knative.dev/kn-plugin-migration/pkg/command"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// DefaultVerifyTimeout is the default maximum time for a migrated service to be Ready and answer its URL with --verify
+	DefaultVerifyTimeout = 2 * time.Minute
+	// probePollInterval is the interval between two requests to the URL of a migrated service
+	probePollInterval = time.Second
+	// visibilityLabel makes a service private to its cluster when set to clusterLocalVisibility
+	visibilityLabel        = "networking.knative.dev/visibility"
+	clusterLocalVisibility = "cluster-local"
+
+	// verifyOnlyFailed and verifyOnlyAll select the services of a report verified again by 'migrate verify'
+	verifyOnlyFailed = "failed"
+	verifyOnlyAll    = "all"
+)
+
+// probeClient sends the requests to the URLs of the migrated services
+var probeClient = &http.Client{Timeout: 10 * time.Second}
+
+// verifyService waits until a migrated service is Ready in the destination and its URL answers with a success
+// status, and fails with ErrVerificationFailed if it does not within the timeout. The URL of a cluster-local
+// service cannot be reached from outside the destination cluster and is not probed.
+func verifyService(ctx context.Context, out io.Writer, migrationClient command.MigrationClient, name string, timeout time.Duration) error {
+	started := time.Now()
+	err := waitForServicesReady(ctx, migrationClient, []string{name}, timeout)
+	if err != nil {
+		return err
+	}
+	service, err := migrationClient.GetService(ctx, name)
+	if err != nil {
+		return err
+	}
+	if service.Labels[visibilityLabel] == clusterLocalVisibility {
+		fmt.Fprintln(out, "Service", color.CyanString(name), "is Ready, its cluster-local URL is not probed")
+		return nil
+	}
+	if service.Status.URL == nil {
+		return newMigrationError(ErrVerificationFailed, fmt.Errorf("service %s is Ready but has no URL", name))
+	}
+
+	url := service.Status.URL.String()
+	// The URL is requested at least once even when becoming Ready took the whole timeout
+	remaining := timeout - time.Since(started)
+	if remaining < probePollInterval {
+		remaining = probePollInterval
+	}
+	var last string
+	err = wait.PollImmediateWithContext(ctx, probePollInterval, remaining, func(ctx context.Context) (bool, error) {
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return false, err
+		}
+		response, err := probeClient.Do(request)
+		if err != nil {
+			last = err.Error()
+			return false, nil
+		}
+		response.Body.Close()
+		last = response.Status
+		return response.StatusCode >= 200 && response.StatusCode < 300, nil
+	})
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err == wait.ErrWaitTimeout {
+		return newMigrationError(ErrVerificationFailed, fmt.Errorf("service %s did not answer %s with a success status after %s, last answer: %s", name, url, timeout, last))
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, "Verified service", color.CyanString(name), "answers", url, "with", last)
+	return nil
+}
+
+type verifyCmdFlags struct {
+	Report                string
+	Only                  string
+	DestinationKubeConfig string
+	DestinationContext    string
+	VerifyTimeout         time.Duration
+	Output                string
+}
+
+var verifyFlags verifyCmdFlags
+
+// NewVerifyCommand represents the 'migrate verify' command
+func NewVerifyCommand() *cobra.Command {
+	verifyCmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Verify again the migrated services of a migration report",
+		Long: `Verify again the migrated services of a migration report.
+
+The report is the JSON or YAML report of a migration written with --output. Its services
+are verified like with --verify: they must be Ready in the destination and answer their URL
+with a success status. With --only failed only the services which failed verification are
+verified again, to iterate on their fixes without verifying every healthy service.
+The updated report is printed with --output, to be verified again after the next fix.
+The command exits with an error while services of the report fail.`,
+		Example: `
+  # Verify again the services which failed verification during the migration
+  kn migrate --namespace default --destination-namespace default --verify --best-effort -o json > report.json
+  kn migrate verify --only failed --report report.json -o json > report-2.json`,
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := command.NewCommandContext(cmd)
+			defer cancel()
+
+			if verifyFlags.Report == "" {
+				return fmt.Errorf("cannot get the migration report, please use --report to set")
+			}
+			if verifyFlags.Only != verifyOnlyFailed && verifyFlags.Only != verifyOnlyAll {
+				return fmt.Errorf("unsupported --only %q, supported values are: failed, all", verifyFlags.Only)
+			}
+			err := validateOutputFormat(verifyFlags.Output)
+			if err != nil {
+				return err
+			}
+			kubeConfig := verifyFlags.DestinationKubeConfig
+			if kubeConfig == "" {
+				kubeConfig = os.Getenv("KUBECONFIG_DESTINATION")
+			}
+			if kubeConfig == "" {
+				kubeConfig = os.Getenv("KUBECONFIG")
+			}
+			if kubeConfig == "" {
+				return fmt.Errorf("cannot get destination cluster kube config, please use --destination-kubeconfig or export environment variable KUBECONFIG_DESTINATION to set")
+			}
+
+			report, err := readReport(verifyFlags.Report)
+			if err != nil {
+				return err
+			}
+			_, servingClientD, err := getClusterClients(clusterConfig{KubeConfig: kubeConfig, Context: verifyFlags.DestinationContext})
+			if err != nil {
+				return err
+			}
+
+			// With a structured report the progress messages go to stderr so that stdout only holds the report
+			out, progress := cmd.OutOrStdout(), cmd.OutOrStdout()
+			if verifyFlags.Output != "" {
+				progress = cmd.ErrOrStderr()
+				command.ConfigureColors(cmd, os.Stderr)
+			}
+			verified := reverifyReport(ctx, progress, report, verifyFlags.Only, func(namespace string) command.MigrationClient {
+				return command.NewMigrationClient(servingClientD, namespace)
+			}, verifyFlags.VerifyTimeout)
+			fmt.Fprintln(progress, "Verified", verified, "service(s) of the report", color.CyanString(verifyFlags.Report))
+			if err := printOutcome(out, cmd.ErrOrStderr(), report, verifyFlags.Output); err != nil {
+				fmt.Fprintln(progress, err.Error())
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if !report.Succeeded {
+				return fmt.Errorf("%d service(s) of the report %s fail verification in the destination", report.failures(), verifyFlags.Report)
+			}
+			return nil
+		},
+	}
+
+	verifyCmd.Flags().StringVar(&verifyFlags.Report, "report", "", "The JSON or YAML report of the migration written with --output")
+	verifyCmd.Flags().StringVar(&verifyFlags.Only, "only", verifyOnlyAll, "The services of the report to verify again, one of: failed (the services which failed verification), all (every service of the report in the destination)")
+	verifyCmd.Flags().StringVar(&verifyFlags.DestinationKubeConfig, "destination-kubeconfig", "", "The kubeconfig of the destination Knative resources (default is KUBECONFIG_DESTINATION from environment variable, else KUBECONFIG)")
+	verifyCmd.Flags().StringVar(&verifyFlags.DestinationContext, "destination-context", "", "The context of the kubeconfig of the destination Knative resources (default is the current context)")
+	verifyCmd.Flags().DurationVar(&verifyFlags.VerifyTimeout, "verify-timeout", DefaultVerifyTimeout, "The maximum time for a service to be Ready and answer its URL")
+	verifyCmd.Flags().StringVarP(&verifyFlags.Output, "output", "o", "", "Output format of the updated report, one of: json, yaml (default is human readable)")
+	return verifyCmd
+}
+
+// readReport reads a migration report written as JSON or YAML with --output
+func readReport(path string) (*MigrationReport, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	report := &MigrationReport{}
+	if err := yaml.Unmarshal(data, report); err != nil {
+		return nil, fmt.Errorf("cannot read the migration report %s: %w", path, err)
+	}
+	return report, nil
+}
+
+// failedVerification returns true if a service of a report was migrated but failed its verification
+func failedVerification(service ServiceReport) bool {
+	return service.Status == ServiceStatusFailed && strings.HasPrefix(service.Error, ErrVerificationFailed.Error())
+}
+
+// reverifyReport verifies again the services of the report selected by only, updates their status in the report
+// and returns the number of services verified. The services which failed before reaching the destination are not
+// verified, they have to be migrated again.
+func reverifyReport(ctx context.Context, out io.Writer, report *MigrationReport, only string, destination func(namespace string) command.MigrationClient, timeout time.Duration) int {
+	verified := 0
+	for _, namespace := range report.Namespaces {
+		migrationClient := destination(namespace.DestinationNamespace)
+		for i := range namespace.Services {
+			service := &namespace.Services[i]
+			selected := failedVerification(*service)
+			if only == verifyOnlyAll {
+				selected = selected || service.Status == ServiceStatusMigrated || service.Status == ServiceStatusSkipped
+			}
+			if !selected {
+				continue
+			}
+
+			err := verifyService(ctx, out, migrationClient, service.destination(), timeout)
+			// An interrupted verification leaves the service as reported
+			if ctx.Err() != nil {
+				return verified
+			}
+			verified++
+			if err != nil {
+				fmt.Fprintln(out, err.Error())
+				service.Status = ServiceStatusFailed
+				service.Error = err.Error()
+				continue
+			}
+			if service.Status == ServiceStatusFailed {
+				service.Status = ServiceStatusMigrated
+				service.Error = ""
+			}
+		}
+	}
+
+	report.Succeeded = report.failures() == 0
+	if report.Succeeded {
+		report.Error = ""
+	}
+	return verified
+}
@@ -0,0 +1,127 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/kn-plugin-migration/pkg/command"
+	"knative.dev/pkg/apis"
+	duckv1 "knative.dev/pkg/apis/duck/v1"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+	serving_fake "knative.dev/serving/pkg/client/clientset/versioned/fake"
+)
+
+func newReadyService(name, url string, labels map[string]string) *serving_v1_api.Service {
+	service := &serving_v1_api.Service{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: labels}}
+	service.Status.Conditions = duckv1.Conditions{{Type: apis.ConditionReady, Status: corev1.ConditionTrue}}
+	service.Status.URL, _ = apis.ParseURL(url)
+	return service
+}
+
+func TestVerifyService(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer healthy.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer broken.Close()
+
+	servingClient := serving_fake.NewSimpleClientset(
+		newReadyService("healthy", healthy.URL, nil),
+		newReadyService("broken", broken.URL, nil),
+		// Not reachable from outside the cluster, so not probed
+		newReadyService("private", "http://private.default.svc.cluster.local", map[string]string{visibilityLabel: clusterLocalVisibility}),
+	)
+	migrationClient := command.NewMigrationClient(servingClient.ServingV1(), "default")
+
+	assert.NilError(t, verifyService(context.Background(), io.Discard, migrationClient, "healthy", time.Second))
+	assert.NilError(t, verifyService(context.Background(), io.Discard, migrationClient, "private", time.Second))
+
+	err := verifyService(context.Background(), io.Discard, migrationClient, "broken", time.Millisecond)
+	assert.Assert(t, errors.Is(err, ErrVerificationFailed))
+	assert.ErrorContains(t, err, "502 Bad Gateway")
+}
+
+func TestReverifyReport(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer healthy.Close()
+	servingClient := serving_fake.NewSimpleClientset(
+		newReadyService("fixed", healthy.URL, nil),
+		newReadyService("healthy", healthy.URL, nil),
+	)
+
+	report := newMigrationReport()
+	namespace := report.namespace("default", "default")
+	namespace.add("fixed", nil, nil, 0, newMigrationError(ErrVerificationFailed, errors.New("bad gateway")))
+	namespace.add("healthy", nil, nil, 0, nil)
+	namespace.add("unmigrated", nil, nil, 0, newMigrationError(ErrQuotaExceeded, errors.New("exceeded quota")))
+	report.finish(errors.New("2 failure(s) during the migration"))
+
+	// The report is read back from the output of the migration
+	path := filepath.Join(t.TempDir(), "report.json")
+	out := &bytes.Buffer{}
+	assert.NilError(t, printStructured(out, report, "json"))
+	assert.NilError(t, ioutil.WriteFile(path, out.Bytes(), 0600))
+	report, err := readReport(path)
+	assert.NilError(t, err)
+
+	destination := func(namespace string) command.MigrationClient {
+		return command.NewMigrationClient(servingClient.ServingV1(), namespace)
+	}
+	verified := reverifyReport(context.Background(), io.Discard, report, verifyOnlyFailed, destination, time.Second)
+	assert.Equal(t, verified, 1)
+	assert.Equal(t, report.Namespaces[0].Services[0].Status, ServiceStatusMigrated)
+	assert.Equal(t, report.Namespaces[0].Services[0].Error, "")
+	// Only a new migration fixes a service which never reached the destination
+	assert.Equal(t, report.Namespaces[0].Services[2].Status, ServiceStatusFailed)
+	assert.Assert(t, !report.Succeeded)
+
+	verified = reverifyReport(context.Background(), io.Discard, report, verifyOnlyAll, destination, time.Second)
+	assert.Equal(t, verified, 2)
+	assert.Equal(t, report.failures(), 1)
+}
+
+func TestWaitForService(t *testing.T) {
+	pending := &serving_v1_api.Service{ObjectMeta: metav1.ObjectMeta{Name: "pending", Namespace: "default"}}
+	servingClient := serving_fake.NewSimpleClientset(newReadyService("ready", "http://ready.example.com", nil), pending)
+	migrationClient := command.NewMigrationClient(servingClient.ServingV1(), "default")
+
+	options := NewMigrationOptions()
+	options.Wait, options.WaitTimeout = true, time.Millisecond
+	// The URL of a Ready service is not requested without --verify
+	m := &ServiceMigration{Options: options, MigrationClientD: migrationClient, Service: newReadyService("ready", "http://ready.example.com", nil)}
+	assert.NilError(t, serviceHandler{}.Verify(context.Background(), m, nil))
+
+	m.Service = pending
+	err := serviceHandler{}.Verify(context.Background(), m, nil)
+	assert.Assert(t, errors.Is(err, ErrVerificationFailed))
+	assert.ErrorContains(t, err, "service(s) pending are not Ready after 1ms")
+
+	options.Wait = false
+	assert.NilError(t, serviceHandler{}.Verify(context.Background(), m, nil))
+}
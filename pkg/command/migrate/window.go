@@ -0,0 +1,94 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// maintenanceWindowsConfigKey is the config file key declaring when destructive phases may run, e.g.
+//
+//	maintenance-windows:
+//	- schedule: "0 22 * * 5"
+//	  duration: 4h
+//	  timezone: Europe/Berlin
+const maintenanceWindowsConfigKey = "maintenance-windows"
+
+// maintenanceWindow is a time window starting whenever the cron schedule fires
+type maintenanceWindow struct {
+	Schedule string `mapstructure:"schedule"`
+	Duration string `mapstructure:"duration"`
+	Timezone string `mapstructure:"timezone"`
+}
+
+// loadMaintenanceWindows reads the maintenance windows from the config file, if any
+func loadMaintenanceWindows() ([]maintenanceWindow, error) {
+	windows := []maintenanceWindow{}
+	if !viper.IsSet(maintenanceWindowsConfigKey) {
+		return windows, nil
+	}
+	err := viper.UnmarshalKey(maintenanceWindowsConfigKey, &windows)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %s from config file: %w", maintenanceWindowsConfigKey, err)
+	}
+	return windows, nil
+}
+
+// outsideMaintenanceWindows returns true if the config file declares maintenance windows and none of them is open
+// at now, every command writing to a cluster then refuses its destructive phases and only plans them
+func outsideMaintenanceWindows(now time.Time) (bool, error) {
+	windows, err := loadMaintenanceWindows()
+	if err != nil || len(windows) == 0 {
+		return false, err
+	}
+	open, err := inMaintenanceWindow(windows, now)
+	if err != nil {
+		return false, err
+	}
+	return !open, nil
+}
+
+// inMaintenanceWindow returns true if now is inside any of the given windows
+func inMaintenanceWindow(windows []maintenanceWindow, now time.Time) (bool, error) {
+	for _, window := range windows {
+		schedule, err := parseCron(window.Schedule)
+		if err != nil {
+			return false, err
+		}
+		duration, err := time.ParseDuration(window.Duration)
+		if err != nil {
+			return false, fmt.Errorf("invalid duration of maintenance window %q: %w", window.Schedule, err)
+		}
+		location := time.Local
+		if window.Timezone != "" {
+			location, err = time.LoadLocation(window.Timezone)
+			if err != nil {
+				return false, fmt.Errorf("invalid timezone of maintenance window %q: %w", window.Schedule, err)
+			}
+		}
+
+		// The window is open if the schedule fired within the last duration
+		t := now.In(location).Truncate(time.Minute)
+		for elapsed := time.Duration(0); elapsed < duration; elapsed += time.Minute {
+			if schedule.matches(t.Add(-elapsed)) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,62 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SanitizedAnnotations are the annotations written by the tools and controllers of the source cluster, they are
+// stripped from every migrated object
+var SanitizedAnnotations = []string{
+	"kubectl.kubernetes.io/last-applied-configuration",
+	"serving.knative.dev/creator",
+	"serving.knative.dev/lastModifier",
+}
+
+// SanitizeObjectMeta returns the metadata of a source object to create in the given namespace of the destination.
+// Only the name, the labels and the annotations are kept, the fields generated by the source cluster, e.g. its
+// uid, resource version, creation timestamp and owner references, are stripped with the SanitizedAnnotations.
+// Owner references pointing at objects of the destination must be set again by the caller.
+func SanitizeObjectMeta(meta metav1.ObjectMeta, namespace string) metav1.ObjectMeta {
+	var labels map[string]string
+	if meta.Labels != nil {
+		labels = make(map[string]string, len(meta.Labels))
+		for key, value := range meta.Labels {
+			labels[key] = value
+		}
+	}
+	return metav1.ObjectMeta{
+		Name:        meta.Name,
+		Namespace:   namespace,
+		Labels:      labels,
+		Annotations: SanitizeAnnotations(meta.Annotations),
+	}
+}
+
+// SanitizeAnnotations returns a copy of the annotations without the SanitizedAnnotations, nil if none is left
+func SanitizeAnnotations(annotations map[string]string) map[string]string {
+	sanitized := make(map[string]string, len(annotations))
+	for key, value := range annotations {
+		sanitized[key] = value
+	}
+	for _, key := range SanitizedAnnotations {
+		delete(sanitized, key)
+	}
+	if len(sanitized) == 0 {
+		return nil
+	}
+	return sanitized
+}
@@ -0,0 +1,87 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"testing"
+
+	"gotest.tools/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+func sourceObjectMeta(name string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:              name,
+		Namespace:         "source",
+		UID:               types.UID("source-uid"),
+		ResourceVersion:   "42",
+		Generation:        3,
+		CreationTimestamp: metav1.Now(),
+		Finalizers:        []string{"example.com/cleanup"},
+		ManagedFields:     []metav1.ManagedFieldsEntry{{Manager: "kubectl"}},
+		OwnerReferences:   []metav1.OwnerReference{{Kind: "Configuration", Name: "hello", UID: types.UID("source-configuration-uid")}},
+		Labels:            map[string]string{"serving.knative.dev/configurationGeneration": "3", "team": "web"},
+		Annotations: map[string]string{
+			"kubectl.kubernetes.io/last-applied-configuration": "{}",
+			"serving.knative.dev/creator":                      "alice",
+			"serving.knative.dev/lastModifier":                 "bob",
+			"example.com/keep":                                 "yes",
+		},
+	}
+}
+
+func TestSanitizeObjectMeta(t *testing.T) {
+	source := sourceObjectMeta("hello")
+	sanitized := SanitizeObjectMeta(source, "destination")
+	assert.DeepEqual(t, sanitized, metav1.ObjectMeta{
+		Name:        "hello",
+		Namespace:   "destination",
+		Labels:      map[string]string{"serving.knative.dev/configurationGeneration": "3", "team": "web"},
+		Annotations: map[string]string{"example.com/keep": "yes"},
+	})
+
+	// The maps of the source are not shared
+	sanitized.Labels["team"] = "api"
+	assert.Equal(t, source.Labels["team"], "web")
+
+	assert.Assert(t, SanitizeAnnotations(map[string]string{"serving.knative.dev/creator": "alice"}) == nil)
+	assert.Assert(t, SanitizeObjectMeta(metav1.ObjectMeta{Name: "bare"}, "destination").Labels == nil)
+}
+
+func TestBuildSanitizedServiceAndRevision(t *testing.T) {
+	client := NewMigrationClient(nil, "destination").(*migrationClient)
+
+	service := serving_v1_api.Service{ObjectMeta: sourceObjectMeta("hello")}
+	service.Status.LatestCreatedRevisionName = "hello-00003"
+	built := client.ConstructService(service)
+	assert.Equal(t, built.Namespace, "destination")
+	assert.Equal(t, built.UID, types.UID(""))
+	assert.Equal(t, built.ResourceVersion, "")
+	assert.Assert(t, built.CreationTimestamp.IsZero())
+	assert.Assert(t, built.OwnerReferences == nil)
+	assert.Assert(t, built.Finalizers == nil)
+	assert.Equal(t, built.Annotations["serving.knative.dev/creator"], "")
+	assert.Equal(t, built.Spec.Template.Name, "hello-00003")
+
+	revision := serving_v1_api.Revision{ObjectMeta: sourceObjectMeta("hello-00001")}
+	builtRevision := client.BuildRevision(revision, types.UID("destination-configuration-uid"))
+	assert.DeepEqual(t, builtRevision.OwnerReferences, []metav1.OwnerReference{{Kind: "Configuration", Name: "hello", UID: types.UID("destination-configuration-uid")}})
+	assert.Equal(t, builtRevision.Labels["serving.knative.dev/configurationGeneration"], "3")
+	assert.Equal(t, builtRevision.Annotations["kubectl.kubernetes.io/last-applied-configuration"], "")
+	// The owner reference of the source is not modified
+	assert.Equal(t, revision.OwnerReferences[0].UID, types.UID("source-configuration-uid"))
+}
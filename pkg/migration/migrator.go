@@ -0,0 +1,137 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package migration embeds the migration of Knative services between clusters in other Go programs, e.g. operators,
+// without running the kn migrate command.
+package migration
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"knative.dev/kn-plugin-migration/pkg/command"
+	"knative.dev/kn-plugin-migration/pkg/command/migrate"
+	serving_v1_client "knative.dev/serving/pkg/client/clientset/versioned/typed/serving/v1"
+)
+
+// Report is the result of a migration, the same report kn migrate prints with --output
+type Report = migrate.MigrationReport
+
+// Cluster is a namespace of a cluster services are migrated from or to
+type Cluster struct {
+	// Config is the REST config of the cluster, used to create the clients which are not set
+	Config *rest.Config
+	// ClientSet and Serving are the clients of the cluster
+	ClientSet kubernetes.Interface
+	Serving   serving_v1_client.ServingV1Interface
+	// Namespace is the namespace of the services, the destination namespace defaults to the source namespace
+	Namespace string
+}
+
+// clients returns the clients of the cluster, created from its config when they are not set
+func (c Cluster) clients() (kubernetes.Interface, serving_v1_client.ServingV1Interface, error) {
+	clientSet, servingClient := c.ClientSet, c.Serving
+	if (clientSet == nil || servingClient == nil) && c.Config == nil {
+		return nil, nil, fmt.Errorf("either the config or the clients of the cluster of namespace %s must be set", c.Namespace)
+	}
+	var err error
+	if clientSet == nil {
+		clientSet, err = kubernetes.NewForConfig(c.Config)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if servingClient == nil {
+		servingClient, err = serving_v1_client.NewForConfig(c.Config)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return clientSet, servingClient, nil
+}
+
+// Options configures a Migrator
+type Options struct {
+	Source      Cluster
+	Destination Cluster
+	// Services, Selector, Exclude and ExcludeSelector select the services to migrate like the --service,
+	// --selector, --exclude and --exclude-selector flags, every service of the source namespace by default
+	Services        []string
+	Selector        string
+	Exclude         []string
+	ExcludeSelector string
+	// Hooks are called around the migration of every service, they replace the hooks of Migration when set
+	Hooks migrate.ServiceHooks
+	// Migration holds what the other flags of kn migrate set, the defaults of migrate.NewMigrationOptions when nil
+	Migration *migrate.MigrationOptions
+}
+
+// Migrator migrates the services of a namespace of the source cluster to a namespace of the destination cluster
+type Migrator struct {
+	options          Options
+	clientSetS       kubernetes.Interface
+	migrationClientS command.MigrationClient
+	clientSetD       kubernetes.Interface
+	migrationClientD command.MigrationClient
+}
+
+// NewMigrator returns a Migrator, creating the clients of the clusters from their config when they are not set
+func NewMigrator(options Options) (*Migrator, error) {
+	if options.Source.Namespace == "" {
+		return nil, fmt.Errorf("the source namespace must be set")
+	}
+	if options.Destination.Namespace == "" {
+		options.Destination.Namespace = options.Source.Namespace
+	}
+	if options.Migration == nil {
+		options.Migration = migrate.NewMigrationOptions()
+	}
+	if options.Migration.SourceCluster == "" && options.Source.Config != nil {
+		options.Migration.SourceCluster = options.Source.Config.Host
+	}
+	if options.Hooks.Before != nil || options.Hooks.After != nil {
+		options.Migration.Hooks = options.Hooks
+	}
+
+	clientSetS, servingClientS, err := options.Source.clients()
+	if err != nil {
+		return nil, err
+	}
+	clientSetD, servingClientD, err := options.Destination.clients()
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{
+		options:          options,
+		clientSetS:       clientSetS,
+		migrationClientS: command.NewMigrationClient(servingClientS, options.Source.Namespace),
+		clientSetD:       clientSetD,
+		migrationClientD: command.NewMigrationClient(servingClientD, options.Destination.Namespace),
+	}, nil
+}
+
+// Migrate runs the migration and returns its report, along with an error if the migration or any of its services
+// failed. The changes of a failed migration are rolled back with the RollbackOnFailure option.
+// A Migrator runs one migration at a time, since the state of a run is kept in its migrate.MigrationOptions.
+func (m *Migrator) Migrate(ctx context.Context) (*Report, error) {
+	selection := migrate.ServiceSelection{
+		Services:        m.options.Services,
+		Selector:        m.options.Selector,
+		Exclude:         m.options.Exclude,
+		ExcludeSelector: m.options.ExcludeSelector,
+	}
+	return migrate.MigrateNamespaceWithReport(ctx, m.clientSetS, m.migrationClientS, m.options.Source.Namespace, m.clientSetD, m.migrationClientD, m.options.Destination.Namespace, selection, m.options.Migration)
+}
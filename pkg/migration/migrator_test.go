@@ -0,0 +1,102 @@
+// Copyright © 2020 The Knative Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migration
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gotest.tools/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8s_fake "k8s.io/client-go/kubernetes/fake"
+	"knative.dev/kn-plugin-migration/pkg/command/migrate"
+	serving_v1_api "knative.dev/serving/pkg/apis/serving/v1"
+	serving_fake "knative.dev/serving/pkg/client/clientset/versioned/fake"
+)
+
+func fakeCluster(namespace string, services ...string) Cluster {
+	servingClient := serving_fake.NewSimpleClientset()
+	for _, name := range services {
+		_ = servingClient.Tracker().Add(&serving_v1_api.Service{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: map[string]string{"team": name}}})
+	}
+	return Cluster{ClientSet: k8s_fake.NewSimpleClientset(), Serving: servingClient.ServingV1(), Namespace: namespace}
+}
+
+func TestNewMigrator(t *testing.T) {
+	_, err := NewMigrator(Options{Source: fakeCluster(""), Destination: fakeCluster("prod")})
+	assert.ErrorContains(t, err, "source namespace must be set")
+
+	_, err = NewMigrator(Options{Source: fakeCluster("default"), Destination: Cluster{Namespace: "prod"}})
+	assert.ErrorContains(t, err, "either the config or the clients")
+
+	migrator, err := NewMigrator(Options{Source: fakeCluster("default"), Destination: Cluster{ClientSet: k8s_fake.NewSimpleClientset(), Serving: serving_fake.NewSimpleClientset().ServingV1()}})
+	assert.NilError(t, err)
+	assert.Equal(t, migrator.options.Destination.Namespace, "default")
+	assert.Equal(t, migrator.options.Migration.Concurrency, 1)
+}
+
+func TestMigrateEmptyNamespace(t *testing.T) {
+	migrator, err := NewMigrator(Options{Source: fakeCluster("default"), Destination: fakeCluster("prod")})
+	assert.NilError(t, err)
+	report, err := migrator.Migrate(context.Background())
+	assert.NilError(t, err)
+	assert.Assert(t, report.Succeeded)
+	assert.Equal(t, len(report.Namespaces), 1)
+	assert.Equal(t, report.Namespaces[0].DestinationNamespace, "prod")
+	assert.Equal(t, len(report.Namespaces[0].Services), 0)
+}
+
+func TestMigrateHooks(t *testing.T) {
+	before, after := []string{}, []string{}
+	options := migrate.NewMigrationOptions()
+	options.BestEffort = true
+	migrator, err := NewMigrator(Options{
+		Source:      fakeCluster("default", "hello", "bye", "legacy"),
+		Destination: fakeCluster("prod"),
+		Exclude:     []string{"legacy"},
+		Hooks: migrate.ServiceHooks{
+			Before: func(ctx context.Context, service *serving_v1_api.Service) error {
+				before = append(before, service.Name)
+				return errors.New("maintenance in progress")
+			},
+			After: func(ctx context.Context, service *serving_v1_api.Service, err error) error {
+				after = append(after, service.Name+": "+err.Error())
+				return nil
+			},
+		},
+		Migration: options,
+	})
+	assert.NilError(t, err)
+	report, err := migrator.Migrate(context.Background())
+	assert.ErrorContains(t, err, "2 failure(s)")
+	assert.Assert(t, !report.Succeeded)
+	assert.DeepEqual(t, before, []string{"bye", "hello"})
+	assert.DeepEqual(t, after, []string{
+		"bye: the hook before service bye failed: maintenance in progress",
+		"hello: the hook before service hello failed: maintenance in progress",
+	})
+	for _, service := range report.Namespaces[0].Services {
+		assert.Equal(t, service.Status, migrate.ServiceStatusFailed)
+	}
+}
+
+func TestMigrateInvalidSelection(t *testing.T) {
+	migrator, err := NewMigrator(Options{Source: fakeCluster("default"), Destination: fakeCluster("prod"), Selector: "team in ("})
+	assert.NilError(t, err)
+	report, err := migrator.Migrate(context.Background())
+	assert.Assert(t, err != nil)
+	assert.Assert(t, !report.Succeeded)
+}